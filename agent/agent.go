@@ -0,0 +1,466 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress of a synchronization
+type Progress struct {
+	Created   int // upstream resources without local one
+	Updated   int // upstream resources whose local one was out of date
+	Unchanged int // upstream resources whose local one was up to date
+	Deleted   int // local resources whose upstream one is gone, found by full synchronizations only
+	Failed    int // upstream resources that could not be written, see the log
+}
+
+// Synchronizes the users and groups of an upstream server into a local one. A local resource maps to the
+// upstream one whose id is its externalId: upstream resources are copied but for their id, meta and the groups
+// of users, and get an id and meta of their own when created. The members of groups are mapped to the local
+// resources alike, members without one are left out. Local resources are written through the handlers of the
+// local server, validated, hooked and published like the writes of its clients. Users are synchronized before
+// groups, and the groups of users are rebuilt from the members of the groups when any changed.
+//
+// A synchronization reads the upstream resources changed since the latest change it read before, by
+// meta.lastModified, which upstream servers are to support in filters. Upstream deletions are not among
+// them: a full synchronization reads all upstream resources and deletes the local resources with an
+// externalId none of them has, so the local server is not to hold resources with an externalId of its own.
+// A full synchronization that reads fewer resources than the upstream or local server reports, i.e. because
+// a search timed out, fails rather than deletes resources it did not read. The first synchronization is a
+// full one.
+type Agent struct {
+	Source    Source
+	Server    handlers.ScimServer // the local server
+	Logger    shared.Logger       // no logging if nil
+	PageSize  int                 // resources read per request, 100 if 0
+	Interval  time.Duration       // between synchronizations of Run, only triggered ones if 0
+	FullEvery int                 // every how many synchronizations of Run is a full one, only the first if 0
+	// Called with the outcome of every synchronization of Run, i.e. to record metrics; failed synchronizations
+	// are logged if nil
+	Report func(progress Progress, err error)
+
+	mu         sync.Mutex
+	watermarks map[string]string // by resource type, the latest meta.lastModified read
+	once       sync.Once
+	trigger    chan struct{}
+}
+
+// Resource types are synchronized in this order, so that the members of groups are found
+var resourceTypes = []string{shared.UserResourceType, shared.GroupResourceType}
+
+// the schema and handlers of the resources of a type on the local server
+type localType struct {
+	urn         string
+	uriProperty string
+	create      handlers.EndpointHandler
+	replace     handlers.EndpointHandler
+	delete      handlers.EndpointHandler
+	// the request types of create, replace and delete
+	requestTypes [3]int
+}
+
+var localTypes = map[string]localType{
+	shared.UserResourceType: {
+		shared.UserUrn, "scim.protocol.uri.user",
+		handlers.CreateUserHandler, handlers.ReplaceUserHandler, handlers.DeleteUserByIdHandler,
+		[3]int{shared.CreateUser, shared.ReplaceUser, shared.DeleteUser},
+	},
+	shared.GroupResourceType: {
+		shared.GroupUrn, "scim.protocol.uri.group",
+		handlers.CreateGroupHandler, handlers.ReplaceGroupHandler, handlers.DeleteGroupByIdHandler,
+		[3]int{shared.CreateGroup, shared.ReplaceGroup, shared.DeleteGroup},
+	},
+}
+
+func (a *Agent) init() {
+	a.once.Do(func() {
+		a.trigger = make(chan struct{}, 1)
+	})
+}
+
+func (a *Agent) pageSize() int {
+	if a.PageSize < 1 {
+		return 100
+	}
+	return a.PageSize
+}
+
+func (a *Agent) logger() shared.Logger {
+	if a.Logger == nil {
+		return shared.NewNoOpLogger()
+	}
+	return a.Logger
+}
+
+// Synchronize right away, then every Interval and whenever triggered, until the context is done. The first
+// synchronization and every FullEvery one after are full ones. Returns the error of the context.
+func (a *Agent) Run(ctx context.Context) error {
+	a.init()
+	var tick <-chan time.Time
+	if a.Interval > 0 {
+		ticker := time.NewTicker(a.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for run := 0; ; run++ {
+		full := run == 0 || (a.FullEvery > 0 && run%a.FullEvery == 0)
+		progress, err := a.Sync(full, ctx)
+		if a.Report != nil {
+			a.Report(progress, err)
+		} else if err != nil && ctx.Err() == nil {
+			a.logger().Error("synchronization failed", "full", full, "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+		case <-a.trigger:
+		}
+	}
+}
+
+// Have Run synchronize as soon as it is done with the synchronization it is running, if any. Triggers
+// arriving meanwhile are coalesced into one.
+func (a *Agent) Trigger() {
+	a.init()
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// A webhook for the upstream server to call on changes: POST requests carrying the secret in the
+// X-Webhook-Secret header trigger a synchronization and are answered with 202 Accepted. Any request
+// triggers one if the secret is empty.
+func (a *Agent) Webhook(secret string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if len(secret) > 0 && subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Webhook-Secret")), []byte(secret)) != 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		a.Trigger()
+		rw.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// Synchronize once, fully or the changes since the last synchronization. Synchronizations run one at a time.
+func (a *Agent) Sync(full bool, ctx context.Context) (Progress, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.watermarks == nil {
+		a.watermarks = make(map[string]string)
+	}
+
+	progress := Progress{}
+	groupsChanged := false
+	for _, resourceType := range resourceTypes {
+		before := progress
+		if err := a.sync(resourceType, full, &progress, ctx); err != nil {
+			return progress, err
+		}
+		if resourceType == shared.GroupResourceType {
+			groupsChanged = progress.Created+progress.Updated+progress.Deleted > before.Created+before.Updated+before.Deleted
+		}
+	}
+
+	if groupsChanged {
+		users, groups := a.Server.Repository(shared.UserResourceType), a.Server.Repository(shared.GroupResourceType)
+		if _, err := shared.RebuildGroupReferences(users, groups, a.pageSize(), ctx); err != nil {
+			return progress, err
+		}
+	}
+	return progress, nil
+}
+
+func (a *Agent) sync(resourceType string, full bool, progress *Progress, ctx context.Context) error {
+	watermark := a.watermarks[resourceType]
+	full = full || len(watermark) == 0
+
+	// some servers, this one among them, require a filter
+	sr := shared.SearchRequest{Filter: "id pr", SortBy: "meta.lastModified", SortOrder: "ascending", Count: a.pageSize()}
+	if !full {
+		// changes made in the second of the watermark after it was read are read again rather than missed
+		value, err := shared.QuoteFilterString(watermark)
+		if err != nil {
+			return err
+		}
+		sr.Filter = "meta.lastModified ge " + value
+	}
+
+	seen := make(map[string]bool)
+	latest := watermark
+	err := readAll(resourceType+" upstream", sr, func(sr shared.SearchRequest) (*shared.ListResponse, error) {
+		return a.Source.Search(resourceType, sr, ctx)
+	}, func(dp shared.DataProvider) {
+		upstream := dp.GetData()
+		seen[dp.GetId()] = true
+		if meta, ok := upstream["meta"].(map[string]interface{}); ok {
+			if lastModified, _ := meta["lastModified"].(string); shared.CompareDateTime(lastModified, latest) > 0 {
+				latest = lastModified
+			}
+		}
+		a.apply(resourceType, upstream, progress, ctx)
+	}, ctx)
+	if err != nil {
+		return err
+	}
+
+	if full {
+		if err := a.deleteGone(resourceType, seen, progress, ctx); err != nil {
+			return err
+		}
+	}
+	a.watermarks[resourceType] = latest
+	return nil
+}
+
+// page through all resources the search matches, however many a page holds; a partial page, or fewer
+// resources than the total reported, fails the read
+func readAll(what string, sr shared.SearchRequest, search func(sr shared.SearchRequest) (*shared.ListResponse, error), each func(dp shared.DataProvider), ctx context.Context) error {
+	for sr.StartIndex = 1; ; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lr, err := search(sr)
+		if err != nil {
+			return err
+		}
+		if lr.Partial {
+			return shared.Error.Text("partial read of the %s resources", what)
+		}
+		for _, dp := range lr.Resources {
+			each(dp)
+		}
+		sr.StartIndex += len(lr.Resources)
+		if sr.StartIndex > lr.TotalResults {
+			return nil
+		}
+		if len(lr.Resources) == 0 {
+			return shared.Error.Text("short read of the %s resources, %d of %d", what, sr.StartIndex-1, lr.TotalResults)
+		}
+	}
+}
+
+// create or update the local resource of the upstream one
+func (a *Agent) apply(resourceType string, upstream shared.Complex, progress *Progress, ctx context.Context) {
+	upstreamId, _ := upstream["id"].(string)
+	err := func() error {
+		if len(upstreamId) == 0 {
+			return shared.Error.Text("upstream %s without id", resourceType)
+		}
+		local, err := a.lookup(resourceType, upstreamId, ctx)
+		if err != nil {
+			return err
+		}
+		r := &shared.Resource{Complex: a.translate(resourceType, upstream, ctx)}
+		r.Complex["externalId"] = upstreamId
+
+		if local == nil {
+			if err := a.write(resourceType, http.MethodPost, "", "", r.Complex, ctx); err != nil {
+				return err
+			}
+			progress.Created++
+			return nil
+		}
+
+		data := local.GetData()
+		r.Complex["id"] = local.GetId()
+		if groups, ok := data["groups"]; ok && resourceType == shared.UserResourceType {
+			r.Complex["groups"] = groups
+		}
+		if len(shared.Diff(&shared.Resource{Complex: data}, r, a.Server.InternalSchema(localTypes[resourceType].urn))) == 0 {
+			progress.Unchanged++
+			return nil
+		}
+
+		version := ""
+		if meta, ok := data["meta"].(map[string]interface{}); ok {
+			version, _ = meta["version"].(string)
+		}
+		if err := a.write(resourceType, http.MethodPut, local.GetId(), version, r.Complex, ctx); err != nil {
+			return err
+		}
+		progress.Updated++
+		return nil
+	}()
+	if err != nil {
+		progress.Failed++
+		a.logger().Warn("upstream resource not synchronized", "resourceType", resourceType, "upstreamId", upstreamId, "error", err.Error())
+	}
+}
+
+// create, replace or delete a local resource through the handler of the local server, conditional on the
+// version unless it is empty
+func (a *Agent) write(resourceType, method, id, version string, data shared.Complex, ctx context.Context) error {
+	lt := localTypes[resourceType]
+	target := a.Server.Property().GetString(lt.uriProperty)
+	var handler handlers.EndpointHandler
+	var requestType int
+	switch method {
+	case http.MethodPost:
+		handler, requestType = lt.create, lt.requestTypes[0]
+	case http.MethodPut:
+		handler, requestType = lt.replace, lt.requestTypes[1]
+	default:
+		handler, requestType = lt.delete, lt.requestTypes[2]
+	}
+	if len(id) > 0 {
+		target += "/" + id
+	}
+	headers := make(map[string]string)
+	if len(version) > 0 {
+		headers["If-Match"] = version
+	}
+	var body []byte
+	if data != nil {
+		schemas := []interface{}{lt.urn}
+		if given, ok := data["schemas"].([]interface{}); ok && len(given) > 0 {
+			schemas = given
+		}
+		data["schemas"] = schemas
+		var err error
+		if body, err = json.Marshal(map[string]interface{}(data)); err != nil {
+			return err
+		}
+	}
+	_, err := handlers.RunHandler(handler, requestType, handlers.NewWebRequest(method, target, id, headers, body), a.Server, ctx)
+	return err
+}
+
+// the local resource of the upstream id, nil if there is none
+func (a *Agent) lookup(resourceType, upstreamId string, ctx context.Context) (shared.DataProvider, error) {
+	value, err := shared.QuoteFilterString(upstreamId)
+	if err != nil {
+		return nil, err
+	}
+	lr, err := a.Server.Repository(resourceType).Search(shared.SearchRequest{
+		Filter:     "externalId eq " + value,
+		StartIndex: 1,
+		Count:      2,
+	}, ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch len(lr.Resources) {
+	case 0:
+		return nil, nil
+	case 1:
+		return lr.Resources[0], nil
+	default:
+		return nil, shared.Error.Text("more than one local resource of upstream id %s", upstreamId)
+	}
+}
+
+// a copy of the upstream data without the attributes of its own, and with members mapped to local resources
+func (a *Agent) translate(resourceType string, upstream shared.Complex, ctx context.Context) shared.Complex {
+	data := make(shared.Complex, len(upstream))
+	for k, v := range upstream {
+		switch k {
+		case "id", "meta":
+			continue
+		case "groups":
+			if resourceType == shared.UserResourceType {
+				continue
+			}
+		case "members":
+			if resourceType == shared.GroupResourceType {
+				v = a.translateMembers(v, ctx)
+			}
+		}
+		data[k] = v
+	}
+	return data
+}
+
+func (a *Agent) translateMembers(v interface{}, ctx context.Context) interface{} {
+	members, ok := v.([]interface{})
+	if !ok {
+		return v
+	}
+	translated := make([]interface{}, 0, len(members))
+	for _, each := range members {
+		member, ok := each.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		upstreamId, _ := member["value"].(string)
+		localId, found := a.resolveMember(upstreamId, member["type"], ctx)
+		if !found {
+			a.logger().Debug("member without local resource left out", "upstreamId", upstreamId)
+			continue
+		}
+		copied := make(map[string]interface{}, len(member))
+		for k, v := range member {
+			// the reference of the member points upstream, it is assigned anew when read
+			if k != "$ref" {
+				copied[k] = v
+			}
+		}
+		copied["value"] = localId
+		translated = append(translated, copied)
+	}
+	return translated
+}
+
+// the local id of the member, a user or group as its type says, either if it says neither
+func (a *Agent) resolveMember(upstreamId string, typ interface{}, ctx context.Context) (string, bool) {
+	candidates := resourceTypes
+	switch typ {
+	case shared.UserResourceType:
+		candidates = []string{shared.UserResourceType}
+	case shared.GroupResourceType:
+		candidates = []string{shared.GroupResourceType}
+	}
+	for _, resourceType := range candidates {
+		if local, err := a.lookup(resourceType, upstreamId, ctx); err == nil && local != nil {
+			return local.GetId(), true
+		}
+	}
+	return "", false
+}
+
+// delete the local resources with an externalId the upstream resources read no longer have, unless the local
+// ones cannot all be read
+func (a *Agent) deleteGone(resourceType string, seen map[string]bool, progress *Progress, ctx context.Context) error {
+	type local struct{ id, version string }
+	gone := make([]local, 0)
+	repo := a.Server.Repository(resourceType)
+	sr := shared.SearchRequest{Filter: "externalId pr", SortBy: "id", SortOrder: "ascending", Count: a.pageSize()}
+	err := readAll(resourceType+" local", sr, func(sr shared.SearchRequest) (*shared.ListResponse, error) {
+		return repo.Search(sr, ctx)
+	}, func(dp shared.DataProvider) {
+		if externalId, _ := dp.GetData()["externalId"].(string); !seen[externalId] {
+			meta, _ := dp.GetData()["meta"].(map[string]interface{})
+			version, _ := meta["version"].(string)
+			gone = append(gone, local{dp.GetId(), version})
+		}
+	}, ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, each := range gone {
+		if err := a.write(resourceType, http.MethodDelete, each.id, each.version, nil, ctx); err != nil {
+			if _, ok := err.(*shared.ResourceNotFoundError); !ok {
+				progress.Failed++
+				a.logger().Warn("local resource not deleted", "resourceType", resourceType, "id", each.id, "error", err.Error())
+			}
+			continue
+		}
+		progress.Deleted++
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgent(t *testing.T) {
+	// the upstream server is a go-scim server itself
+	cfg := testConfig()
+	upstream, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer upstream.Close()
+	ts := httptest.NewServer(upstream.Handler())
+	defer ts.Close()
+
+	do := func(method, path, body string) string {
+		req, err := http.NewRequest(method, ts.URL+"/v2"+path, bytes.NewReader([]byte(body)))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "application/scim+json")
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.True(t, resp.StatusCode < 300, "%s %s answered %d", method, path, resp.StatusCode)
+		data := make(map[string]interface{})
+		json.NewDecoder(resp.Body).Decode(&data)
+		id, _ := data["id"].(string)
+		return id
+	}
+	alice := do(http.MethodPost, "/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "alice"}`)
+	bob := do(http.MethodPost, "/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "bob"}`)
+	do(http.MethodPost, "/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "admins",
+		"members": [{"value": "`+alice+`", "type": "User"}]
+	}`)
+
+	// the local server is another one
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	groups := server.Repository(shared.GroupResourceType)
+	agent := &Agent{
+		Source:   &Client{BaseURL: ts.URL + "/v2"},
+		Server:   server,
+		PageSize: 2,
+	}
+	ctx := context.Background()
+	local := func(resourceType, upstreamId string) shared.Complex {
+		dp, err := agent.lookup(resourceType, upstreamId, ctx)
+		require.Nil(t, err)
+		if dp == nil {
+			return nil
+		}
+		return dp.GetData()
+	}
+
+	progress, err := agent.Sync(false, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, Progress{Created: 3}, progress)
+	localAlice := local(shared.UserResourceType, alice)
+	require.NotNil(t, localAlice)
+	assert.Equal(t, "alice", localAlice["userName"])
+	assert.NotEqual(t, alice, localAlice["id"])
+	assert.True(t, strings.HasSuffix(localAlice["meta"].(map[string]interface{})["location"].(string), "/Users/"+localAlice["id"].(string)))
+	lr, err := groups.Search(shared.SearchRequest{Filter: `displayName eq "admins"`, StartIndex: 1, Count: 1}, ctx)
+	require.Nil(t, err)
+	require.Len(t, lr.Resources, 1)
+	members := lr.Resources[0].GetData()["members"].([]interface{})
+	assert.Equal(t, localAlice["id"], members[0].(map[string]interface{})["value"])
+	assert.Len(t, local(shared.UserResourceType, alice)["groups"], 1)
+
+	// the changes since
+	do(http.MethodPatch, "/Users/"+bob, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "nickName", "value": "bobby"}]
+	}`)
+	progress, err = agent.Sync(false, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, progress.Updated)
+	assert.Equal(t, 0, progress.Created)
+	assert.Equal(t, "bobby", local(shared.UserResourceType, bob)["nickName"])
+
+	// deletions are found by full synchronizations only
+	do(http.MethodDelete, "/Users/"+alice, "")
+	progress, err = agent.Sync(false, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 0, progress.Deleted)
+	progress, err = agent.Sync(true, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, progress.Deleted)
+	assert.Nil(t, local(shared.UserResourceType, alice))
+
+	// a webhook call has Run synchronize again
+	synced := make(chan Progress, 10)
+	agent.Report = func(progress Progress, err error) {
+		if err == nil {
+			synced <- progress
+		}
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go agent.Run(runCtx)
+	<-synced
+
+	carol := do(http.MethodPost, "/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "carol"}`)
+	rw := httptest.NewRecorder()
+	agent.Webhook("secret").ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	req.Header.Set("X-Webhook-Secret", "secret")
+	rw = httptest.NewRecorder()
+	agent.Webhook("secret").ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusAccepted, rw.Code)
+	select {
+	case progress = <-synced:
+		assert.Equal(t, 1, progress.Created)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook did not trigger a synchronization")
+	}
+	cancel()
+	assert.Equal(t, "carol", local(shared.UserResourceType, carol)["userName"])
+}
+
+func TestAgent_Reads(t *testing.T) {
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	ctx := context.Background()
+	users := server.Repository(shared.UserResourceType)
+	require.Nil(t, users.Create(&shared.Resource{Complex: shared.Complex{"id": "local", "userName": "gone", "externalId": "gone"}}, ctx))
+
+	source := &pagedSource{pageSize: 1}
+	for _, userName := range []string{"alice", "bob", "carol"} {
+		source.users = append(source.users, &shared.Resource{Complex: shared.Complex{"id": "upstream-" + userName, "userName": userName}})
+	}
+	agent := &Agent{Source: source, Server: server, PageSize: 2}
+
+	// a partial read deletes nothing
+	source.partial = true
+	_, err = agent.Sync(true, ctx)
+	assert.NotNil(t, err)
+	_, err = users.Get("local", "", ctx)
+	assert.Nil(t, err)
+
+	// every page is read, however few resources the upstream server puts in one
+	source.partial = false
+	progress, err := agent.Sync(true, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 3, progress.Created)
+	assert.Equal(t, 1, progress.Deleted)
+	n, err := users.Count("externalId pr", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	// the writes went through the handlers, which assigned ids and meta
+	dp, err := agent.lookup(shared.UserResourceType, "upstream-alice", ctx)
+	require.Nil(t, err)
+	assert.NotEqual(t, "upstream-alice", dp.GetId())
+	assert.NotEmpty(t, dp.GetData()["meta"].(map[string]interface{})["version"])
+}
+
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Schemas = config.SchemaConfig{
+		Root:          "../resources/schemas/root_internal.json",
+		User:          "../resources/schemas/user_internal.json",
+		Group:         "../resources/schemas/group_internal.json",
+		Served:        []string{"../resources/schemas/user.json", "../resources/schemas/group.json"},
+		ResourceTypes: []string{"../resources/resource_types/user.json", "../resources/resource_types/group.json"},
+		SPConfig:      "../resources/sp_config/sp_config.json",
+	}
+	cfg.Repository.Kind = config.MemoryRepository
+	cfg.LogLevel = "error"
+	return cfg
+}
+
+// a source of users serving pages of at most pageSize resources, partial ones if partial is set
+type pagedSource struct {
+	users    []shared.DataProvider
+	pageSize int
+	partial  bool
+}
+
+func (s *pagedSource) Search(resourceType string, sr shared.SearchRequest, ctx context.Context) (*shared.ListResponse, error) {
+	lr := &shared.ListResponse{Resources: []shared.DataProvider{}, StartIndex: sr.StartIndex, Partial: s.partial}
+	if resourceType != shared.UserResourceType {
+		return lr, nil
+	}
+	lr.TotalResults = len(s.users)
+	for i := sr.StartIndex - 1; i < len(s.users) && len(lr.Resources) < s.pageSize && len(lr.Resources) < sr.Count; i++ {
+		lr.Resources = append(lr.Resources, s.users[i])
+	}
+	return lr, nil
+}
@@ -0,0 +1,111 @@
+// Package agent synchronizes users and groups from an upstream SCIM server into a local one, so that
+// the server also serves as a replica of another, i.e. of an identity provider applications cannot reach.
+// An Agent reads the upstream resources changed since its last run through a Source, i.e. a Client, and
+// creates, updates and deletes the local ones they map to by externalId.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The resources an agent synchronizes from
+type Source interface {
+	// One page of the resources of the resource type, User or Group, matching the search request
+	Search(resourceType string, sr shared.SearchRequest, ctx context.Context) (*shared.ListResponse, error)
+}
+
+// A Source reading from a SCIM 2.0 server by HTTP GET on its /Users and /Groups endpoints
+type Client struct {
+	BaseURL    string       // i.e. https://idp.example.com/scim/v2
+	Token      string       // sent as bearer token, none if empty
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+var endpoints = map[string]string{
+	shared.UserResourceType:  "/Users",
+	shared.GroupResourceType: "/Groups",
+}
+
+func (c *Client) Search(resourceType string, sr shared.SearchRequest, ctx context.Context) (*shared.ListResponse, error) {
+	endpoint, ok := endpoints[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q, expect %s or %s", resourceType, shared.UserResourceType, shared.GroupResourceType)
+	}
+
+	query := url.Values{}
+	if len(sr.Filter) > 0 {
+		query.Set("filter", sr.Filter)
+	}
+	if len(sr.SortBy) > 0 {
+		query.Set("sortBy", sr.SortBy)
+		if len(sr.SortOrder) > 0 {
+			query.Set("sortOrder", sr.SortOrder)
+		}
+	}
+	if len(sr.Attributes) > 0 {
+		query.Set("attributes", strings.Join(sr.Attributes, ","))
+	}
+	query.Set("startIndex", strconv.Itoa(sr.StartIndex))
+	query.Set("count", strconv.Itoa(sr.Count))
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", shared.ScimMediaType)
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		scimErr := struct {
+			Detail string `json:"detail"`
+		}{}
+		json.Unmarshal(body, &scimErr)
+		return nil, fmt.Errorf("upstream answered %d to GET %s: %s", resp.StatusCode, endpoint, scimErr.Detail)
+	}
+
+	page := struct {
+		TotalResults int                      `json:"totalResults"`
+		ItemsPerPage int                      `json:"itemsPerPage"`
+		StartIndex   int                      `json:"startIndex"`
+		Resources    []map[string]interface{} `json:"Resources"`
+	}{}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("upstream answered GET %s with an invalid list response: %s", endpoint, err)
+	}
+	resources := make([]shared.DataProvider, 0, len(page.Resources))
+	for _, data := range page.Resources {
+		resources = append(resources, &shared.Resource{Complex: shared.Complex(data)})
+	}
+	return &shared.ListResponse{
+		Schemas:      []string{shared.ListResponseUrn},
+		TotalResults: page.TotalResults,
+		ItemsPerPage: page.ItemsPerPage,
+		StartIndex:   page.StartIndex,
+		Resources:    resources,
+	}, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"io"
+	"os"
+	"sort"
+)
+
+// resources are read and written in pages of this size
+const pageSize = 100
+
+// write every resource of the repository as a line of JSON, ordered by id so that exports can be diffed
+func exportResources(repo shared.Repository, w io.Writer, ctx context.Context) (int, error) {
+	bw := bufio.NewWriter(w)
+	n := 0
+	for startIndex := 1; ; startIndex += pageSize {
+		lr, err := repo.Search(shared.SearchRequest{
+			Filter:     "id pr",
+			SortBy:     "id",
+			SortOrder:  "ascending",
+			StartIndex: startIndex,
+			Count:      pageSize,
+		}, ctx)
+		if err != nil {
+			return n, err
+		}
+		for _, dp := range lr.Resources {
+			line, err := json.Marshal(map[string]interface{}(dp.GetData()))
+			if err != nil {
+				return n, err
+			}
+			bw.Write(line)
+			bw.WriteByte('\n')
+			n++
+		}
+		if len(lr.Resources) < pageSize {
+			break
+		}
+	}
+	return n, bw.Flush()
+}
+
+// creates the resources of an export through the validation of a create request
+type importer struct {
+	repo         shared.Repository
+	sch          *shared.Schema
+	resourceType string
+	unknown      string            // policy for unknown attributes
+	properties   mapPropertySource // the location bases of resources without meta
+	dryRun       bool
+	report       io.Writer
+	reportErr    error // the first error writing the report
+}
+
+func (imp *importer) run(r io.Reader, ctx context.Context) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	created, failed := 0, 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := imp.one(scanner.Bytes(), ctx); err != nil {
+			imp.reportf("line %d: %s\n", line, err)
+			failed++
+			continue
+		}
+		created++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	verb := "imported"
+	if imp.dryRun {
+		verb = "validated"
+	}
+	imp.reportf("%s %d resources, %d failed\n", verb, created, failed)
+	if imp.reportErr != nil {
+		return fmt.Errorf("%d resources failed, the report is incomplete: %s", failed, imp.reportErr)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d resources failed", failed)
+	}
+	return nil
+}
+
+// print to the report, keeping the first error so that a lost report fails the import
+func (imp *importer) reportf(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(imp.report, format, args...); err != nil && imp.reportErr == nil {
+		imp.reportErr = err
+	}
+}
+
+func (imp *importer) one(line []byte, ctx context.Context) error {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(line, &data); err != nil {
+		return shared.Error.InvalidParam("line", "json conforming to resource syntax", err.Error())
+	}
+	resource := &shared.Resource{Complex: shared.Complex(data)}
+
+	for _, validate := range []func() error{
+		func() error { return shared.CheckUnknownAttributes(resource, imp.sch, imp.unknown, ctx) },
+		func() error { return shared.ValidateType(resource, imp.sch, ctx) },
+		func() error { return shared.CorrectCase(resource, imp.sch, ctx) },
+		func() error { return shared.ValidateRequired(resource, imp.sch, ctx) },
+		func() error { return shared.ValidateUniqueness(resource, imp.sch, imp.repo, nil, ctx) },
+		func() error { return imp.assignReadOnlyValues(resource, ctx) },
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	if imp.dryRun {
+		return nil
+	}
+	return imp.repo.Create(resource, ctx)
+}
+
+// keep the id and meta of exported resources, assign them to the others
+func (imp *importer) assignReadOnlyValues(resource *shared.Resource, ctx context.Context) error {
+	if id, _ := resource.Complex["id"].(string); len(id) == 0 {
+		if err := shared.NewIdAssignment().AssignValue(resource, ctx); err != nil {
+			return err
+		}
+	}
+	if _, ok := resource.Complex["meta"].(map[string]interface{}); !ok {
+		return shared.NewMetaAssignment(imp.properties, imp.resourceType).AssignValue(resource, ctx)
+	}
+	return nil
+}
+
+type mapPropertySource map[string]interface{}
+
+func (ps mapPropertySource) Get(key string) interface{} { return ps[key] }
+func (ps mapPropertySource) GetString(key string) string {
+	s, _ := ps[key].(string)
+	return s
+}
+func (ps mapPropertySource) GetInt(key string) int {
+	i, _ := ps[key].(int)
+	return i
+}
+func (ps mapPropertySource) GetBool(key string) bool {
+	b, _ := ps[key].(bool)
+	return b
+}
+
+// print the ids of the resources only in the old export prefixed by -, those only in the new export by +,
+// and the attribute changes of the others, see shared.Diff
+func diffFiles(oldPath, newPath string, sch *shared.Schema, w io.Writer) error {
+	old, err := readExport(oldPath)
+	if err != nil {
+		return err
+	}
+	new, err := readExport(newPath)
+	if err != nil {
+		return err
+	}
+	return diffExports(old, new, sch, w)
+}
+
+func diffExports(old, new map[string]*shared.Resource, sch *shared.Schema, w io.Writer) error {
+	ids := make([]string, 0, len(old)+len(new))
+	for id := range old {
+		ids = append(ids, id)
+	}
+	for id := range new {
+		if _, ok := old[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		o, inOld := old[id]
+		n, inNew := new[id]
+		switch {
+		case !inNew:
+			fmt.Fprintf(bw, "- %s\n", id)
+		case !inOld:
+			fmt.Fprintf(bw, "+ %s\n", id)
+		default:
+			for _, change := range shared.Diff(o, n, sch) {
+				value, err := json.Marshal(change.Value)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(bw, "~ %s %s %s %s\n", id, change.Op, change.Path, value)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// read an export into resources by id
+func readExport(path string) (map[string]*shared.Resource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	resources := make(map[string]*shared.Resource)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		data := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+		}
+		resource := &shared.Resource{Complex: shared.Complex(data)}
+		resources[resource.GetId()] = resource
+	}
+	return resources, scanner.Err()
+}
+
+// write the resources matching the search request as lines of JSON, returning how many match in total
+func query(repo shared.Repository, sch *shared.Schema, sr shared.SearchRequest, w io.Writer, ctx context.Context) (int, error) {
+	if err := sr.Validate(sch); err != nil {
+		return 0, err
+	}
+	lr, err := repo.Search(sr, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, dp := range lr.Resources {
+		line, err := shared.MarshalJSON(dp, sch, sr.Attributes, sr.ExcludedAttributes)
+		if err != nil {
+			return 0, err
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	return lr.TotalResults, bw.Flush()
+}
@@ -0,0 +1,220 @@
+// Command scimctl exports, imports and queries the resources a MongoDB repository of this module stores, for
+// backups, migrations between deployments and troubleshooting:
+//
+//	scimctl export -mongo mongodb://localhost/scim -collection users -schema user_internal.json > users.ndjson
+//	scimctl import -mongo mongodb://localhost/scim -collection users -schema user_internal.json < users.ndjson
+//	scimctl diff -schema user_internal.json old.ndjson new.ndjson
+//	scimctl query -mongo mongodb://localhost/scim -collection users -schema user_internal.json -filter 'userName sw "a"'
+//
+// Resources are exchanged as NDJSON, one resource per line in its stored form. Imports go through the same
+// validation a create request does, against the internal schema given, and keep the id and meta of exported
+// resources; resources without them are assigned new ones. Lines that fail are reported with their line
+// number and skipped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/davidiamyou/go-scim/mongo"
+	"github.com/davidiamyou/go-scim/shared"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `usage: scimctl <command> [flags]
+
+commands:
+  export  write all resources of a collection as NDJSON
+  import  validate and create the resources of an NDJSON export
+  diff    compare two NDJSON exports
+  query   write the resources matching a filter as NDJSON
+
+run scimctl <command> -h for the flags of a command`
+
+// the flags of the commands reaching a repository
+type repositoryFlags struct {
+	url          string
+	db           string
+	collection   string
+	schemaPath   string
+	resourceType string
+}
+
+func (f *repositoryFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.url, "mongo", "mongodb://localhost:27017/scim", "url of the MongoDB server")
+	fs.StringVar(&f.db, "db", "scim", "database holding the collection")
+	fs.StringVar(&f.collection, "collection", "users", "collection of the resources")
+	fs.StringVar(&f.schemaPath, "schema", "", "path of the internal schema of the resources")
+	fs.StringVar(&f.resourceType, "type", shared.UserResourceType, "resource type of the resources")
+}
+
+func (f *repositoryFlags) open() (shared.Repository, *shared.Schema, error) {
+	sch, err := loadSchema(f.schemaPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	repo, err := mongo.NewMongoRepositoryWithUrl(f.url, f.db, f.collection, sch, func(c shared.Complex) shared.DataProvider {
+		return &shared.Resource{Complex: c}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, sch, nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Args[2:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "scimctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("scimctl "+command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	rf := &repositoryFlags{}
+	ctx := context.Background()
+
+	switch command {
+	case "export":
+		rf.register(fs)
+		out := fs.String("out", "", "path of the export, standard output if empty")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		repo, _, err := rf.open()
+		if err != nil {
+			return err
+		}
+		w, closeFile, err := output(*out, stdout)
+		if err != nil {
+			return err
+		}
+		n, err := exportResources(repo, w, ctx)
+		if cerr := closeFile(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stderr, "exported %d resources\n", n)
+		return nil
+
+	case "import":
+		rf.register(fs)
+		in := fs.String("in", "", "path of the export, standard input if empty")
+		baseURL := fs.String("base-url", "", "base URL of the server, i.e. https://example.com/v2, required for resources without meta")
+		unknown := fs.String("unknown", shared.RejectUnknownAttributes, "policy for attributes the schema does not define: reject, strip or preserve")
+		dryRun := fs.Bool("dry-run", false, "validate without creating")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		repo, sch, err := rf.open()
+		if err != nil {
+			return err
+		}
+		r, closeFile, err := input(*in, stdin)
+		if err != nil {
+			return err
+		}
+		defer closeFile()
+		imp := &importer{
+			repo:         repo,
+			sch:          sch,
+			resourceType: rf.resourceType,
+			unknown:      *unknown,
+			properties:   mapPropertySource{},
+			dryRun:       *dryRun,
+			report:       stderr,
+		}
+		if base := strings.TrimSuffix(*baseURL, "/"); len(base) > 0 {
+			imp.properties["scim.resources.user.locationBase"] = base + "/Users"
+			imp.properties["scim.resources.group.locationBase"] = base + "/Groups"
+		}
+		return imp.run(r, ctx)
+
+	case "diff":
+		schemaPath := fs.String("schema", "", "path of the internal schema of the resources")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("diff compares two exports, got %d", fs.NArg())
+		}
+		sch, err := loadSchema(*schemaPath)
+		if err != nil {
+			return err
+		}
+		return diffFiles(fs.Arg(0), fs.Arg(1), sch, stdout)
+
+	case "query":
+		rf.register(fs)
+		sr := shared.SearchRequest{Schemas: []string{shared.SearchUrn}}
+		fs.StringVar(&sr.Filter, "filter", "", "SCIM filter the resources must match")
+		fs.StringVar(&sr.SortBy, "sort-by", "", "attribute to sort by")
+		fs.StringVar(&sr.SortOrder, "sort-order", "", "ascending or descending")
+		fs.IntVar(&sr.StartIndex, "start-index", 1, "1-based index of the first result")
+		fs.IntVar(&sr.Count, "count", 100, "maximum number of results")
+		attributes := fs.String("attributes", "", "comma separated attributes to return")
+		excludedAttributes := fs.String("excluded-attributes", "", "comma separated attributes not to return")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		sr.Attributes = strings.Split(*attributes, ",")
+		sr.ExcludedAttributes = strings.Split(*excludedAttributes, ",")
+		repo, sch, err := rf.open()
+		if err != nil {
+			return err
+		}
+		total, err := query(repo, sch, sr, stdout, ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stderr, "%d resources match\n", total)
+		return nil
+
+	case "-h", "-help", "--help", "help":
+		fmt.Fprintln(stdout, usage)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, usage)
+	}
+}
+
+func loadSchema(path string) (*shared.Schema, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("-schema is required")
+	}
+	sch, _, err := shared.ParseSchema(path)
+	return sch, err
+}
+
+// closing the file reports the write errors the file system deferred
+func output(path string, stdout io.Writer) (io.Writer, func() error, error) {
+	if len(path) == 0 {
+		return stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+func input(path string, stdin io.Reader) (io.Reader, func(), error) {
+	if len(path) == 0 {
+		return stdin, func() {}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportDiff(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	source := shared.NewSearchableMapRepository(sch, nil)
+	for _, id := range []string{"b", "a", "c"} {
+		require.Nil(t, source.Create(&shared.Resource{Complex: shared.Complex{
+			"schemas":  []interface{}{shared.UserUrn},
+			"id":       id,
+			"userName": "user-" + id,
+			"meta":     map[string]interface{}{"resourceType": shared.UserResourceType, "version": "W/\"1\""},
+		}}, ctx))
+	}
+
+	var export bytes.Buffer
+	n, err := exportResources(source, &export, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 3, n)
+	lines := strings.Split(strings.TrimSpace(export.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"id":"a"`, "ordered by id")
+
+	target := shared.NewSearchableMapRepository(sch, nil)
+	var report bytes.Buffer
+	imp := &importer{
+		repo:         target,
+		sch:          sch,
+		resourceType: shared.UserResourceType,
+		unknown:      shared.RejectUnknownAttributes,
+		properties:   mapPropertySource{"scim.resources.user.locationBase": "https://example.com/v2/Users"},
+		report:       &report,
+	}
+	input := export.String() +
+		`{"schemas":["` + shared.UserUrn + `"],"userName":"USER-A"}` + "\n" +
+		`{"schemas":["` + shared.UserUrn + `"],"userName":"new","unknown":1}` + "\n" +
+		`{"schemas":["` + shared.UserUrn + `"],"userName":"new"}` + "\n"
+	err = imp.run(strings.NewReader(input), ctx)
+	assert.NotNil(t, err)
+	assert.Contains(t, report.String(), "line 4:", "duplicate userName")
+	assert.Contains(t, report.String(), "line 5:", "unknown attribute")
+	assert.Contains(t, report.String(), "imported 4 resources, 2 failed")
+
+	dp, err := target.Get("a", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "W/\"1\"", dp.GetData()["meta"].(map[string]interface{})["version"], "meta is kept")
+	lr, err := target.Search(shared.SearchRequest{Filter: `userName eq "new"`, StartIndex: 1, Count: 10}, ctx)
+	require.Nil(t, err)
+	require.Len(t, lr.Resources, 1)
+	assert.NotEmpty(t, lr.Resources[0].GetId(), "id is assigned")
+	meta := lr.Resources[0].GetData()["meta"].(map[string]interface{})
+	assert.Equal(t, "https://example.com/v2/Users/"+lr.Resources[0].GetId(), meta["location"])
+
+	var out bytes.Buffer
+	total, err := query(target, sch, shared.SearchRequest{
+		Schemas:    []string{shared.SearchUrn},
+		Filter:     `userName sw "user"`,
+		SortBy:     "userName",
+		Attributes: []string{"userName"},
+		StartIndex: 1,
+		Count:      2,
+	}, &out, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, `{"schemas":["`+shared.UserUrn+`"],"id":"a","userName":"user-a"}`, strings.Split(out.String(), "\n")[0])
+
+	dir, err := ioutil.TempDir("", "scimctl")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, target.Delete("c", "", ctx))
+	require.Nil(t, target.Update("b", "", &shared.Resource{Complex: shared.Complex{
+		"schemas":  []interface{}{shared.UserUrn},
+		"id":       "b",
+		"userName": "renamed",
+	}}, ctx))
+	var reexport bytes.Buffer
+	_, err = exportResources(target, &reexport, ctx)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "old.ndjson"), export.Bytes(), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "new.ndjson"), reexport.Bytes(), 0644))
+
+	var diff bytes.Buffer
+	require.Nil(t, run("diff", []string{"-schema", "../../resources/schemas/user_internal.json",
+		filepath.Join(dir, "old.ndjson"), filepath.Join(dir, "new.ndjson")}, nil, &diff, &report))
+	diffLines := strings.Split(strings.TrimSpace(diff.String()), "\n")
+	require.Len(t, diffLines, 3)
+	assert.Contains(t, diffLines, `~ b replace userName "renamed"`)
+	assert.Contains(t, diffLines, "- c")
+	assert.Contains(t, diffLines, "+ "+lr.Resources[0].GetId())
+}
+
+func TestImport_ReportFailure(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	imp := &importer{
+		repo:         shared.NewSearchableMapRepository(sch, nil),
+		sch:          sch,
+		resourceType: shared.UserResourceType,
+		unknown:      shared.RejectUnknownAttributes,
+		properties:   mapPropertySource{},
+		report:       failingWriter{},
+	}
+	err = imp.run(strings.NewReader(`{"schemas":["`+shared.UserUrn+`"],"id":"a","userName":"a"}`+"\n"), context.Background())
+	require.NotNil(t, err, "a lost report fails the import")
+	assert.Contains(t, err.Error(), "disk full")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
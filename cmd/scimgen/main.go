@@ -0,0 +1,217 @@
+// Command scimgen generates typed Go structs from a SCIM schema, along with conversions from and to
+// shared.Resource, so that embedders need not work with maps. It reads schemas in the standard representation
+// of RFC 7643 section 7 as well as the internal schemas of this repository, and is meant to be run through
+// go:generate:
+//
+//	//go:generate go run github.com/davidiamyou/go-scim/cmd/scimgen -schema device.json -type Device -out device.go
+//
+// Complex attributes become structs named after the type and the attribute, i.e. UserName for name and
+// UserEmail for the elements of emails. Singular attributes other than strings are pointers, so that absent
+// values are told apart from zero values. Extension schemas are attached as pointer fields named after their
+// type with -extension Type=path, the type itself being generated from the extension schema separately.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// an extension schema attached to the generated type
+type extension struct {
+	typeName string
+	urn      string
+}
+
+type extensionFlags []string
+
+func (f *extensionFlags) String() string     { return strings.Join(*f, ",") }
+func (f *extensionFlags) Set(v string) error { *f = append(*f, v); return nil }
+
+func main() {
+	var (
+		schemaPath = flag.String("schema", "", "path of the schema to generate from")
+		typeName   = flag.String("type", "", "name of the generated type, the schema name if empty")
+		pkg        = flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file")
+		out        = flag.String("out", "", "path of the generated file, standard output if empty")
+		extensions extensionFlags
+	)
+	flag.Var(&extensions, "extension", "extension schema to attach as Type=path, may be repeated")
+	flag.Parse()
+
+	if err := run(*schemaPath, *typeName, *pkg, *out, extensions); err != nil {
+		fmt.Fprintln(os.Stderr, "scimgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaPath, typeName, pkg, out string, extensionArgs []string) error {
+	if len(schemaPath) == 0 || len(pkg) == 0 {
+		return fmt.Errorf("-schema and -package are required")
+	}
+	registry := shared.NewSchemaRegistry()
+	sch, err := registry.LoadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+	if len(typeName) == 0 {
+		typeName = exportedName(sch.Name)
+	}
+
+	extensions := make([]extension, 0, len(extensionArgs))
+	for _, arg := range extensionArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid extension %q, expect Type=path", arg)
+		}
+		ext, err := registry.LoadFile(parts[1])
+		if err != nil {
+			return err
+		}
+		extensions = append(extensions, extension{typeName: parts[0], urn: ext.Id})
+	}
+
+	src, err := generate(pkg, typeName, filepath.ToSlash(schemaPath), sch, extensions)
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(out, src, 0644)
+}
+
+// generate the formatted source of the types for the schema
+func generate(pkg, typeName, source string, sch *shared.Schema, extensions []extension) ([]byte, error) {
+	g := &generator{}
+	g.printf("// Code generated by scimgen from %s. DO NOT EDIT.\n\n", source)
+	g.printf("package %s\n\n", pkg)
+	g.printf("import \"github.com/davidiamyou/go-scim/shared\"\n\n")
+	g.printf("// the id of the schema of %s\n", typeName)
+	g.printf("const %sUrn = %q\n\n", typeName, sch.Id)
+
+	g.structType(typeName, sch.Description, sch.Attributes, extensions)
+
+	g.printf("// Convert the %s to a resource\n", typeName)
+	g.printf("func (v *%s) ToResource() (*shared.Resource, error) {\n", typeName)
+	g.printf("return shared.MarshalResource(v)\n}\n\n")
+	g.printf("// Convert the resource to a %s\n", typeName)
+	g.printf("func %sFromResource(r *shared.Resource) (*%s, error) {\n", typeName, typeName)
+	g.printf("v := &%s{}\n", typeName)
+	g.printf("if err := shared.UnmarshalResource(r, v); err != nil {\nreturn nil, err\n}\n")
+	g.printf("return v, nil\n}\n")
+
+	return format.Source(g.buf.Bytes())
+}
+
+type generator struct {
+	buf bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// print the struct for the attributes, followed by the structs of its complex attributes
+func (g *generator) structType(name, description string, attributes []*shared.Attribute, extensions []extension) {
+	if len(description) > 0 {
+		g.printf("// %s\n", firstSentence(description))
+	}
+	g.printf("type %s struct {\n", name)
+	nested := make([]*shared.Attribute, 0)
+	for _, attr := range attributes {
+		g.printf("%s %s `json:\"%s,omitempty\"`\n", exportedName(attr.Name), goType(name, attr), attr.Name)
+		if attr.Type == shared.TypeComplex {
+			nested = append(nested, attr)
+		}
+	}
+	for _, ext := range extensions {
+		g.printf("%s *%s `json:\"%s,omitempty\"`\n", ext.typeName, ext.typeName, ext.urn)
+	}
+	g.printf("}\n\n")
+
+	for _, attr := range nested {
+		g.structType(nestedName(name, attr), attr.Description, attr.SubAttributes, nil)
+	}
+}
+
+func goType(parent string, attr *shared.Attribute) string {
+	var t string
+	switch attr.Type {
+	case shared.TypeComplex:
+		t = nestedName(parent, attr)
+	case shared.TypeBoolean:
+		t = "bool"
+	case shared.TypeInteger:
+		t = "int64"
+	case shared.TypeDecimal:
+		t = "float64"
+	default:
+		t = "string"
+	}
+	switch {
+	case attr.MultiValued:
+		return "[]" + t
+	case t == "string":
+		return t
+	default:
+		return "*" + t
+	}
+}
+
+// the name of the struct of a complex attribute, singular for multi valued attributes
+func nestedName(parent string, attr *shared.Attribute) string {
+	name := exportedName(attr.Name)
+	if attr.MultiValued {
+		name = singular(name)
+	}
+	return parent + name
+}
+
+func singular(name string) string {
+	for _, suffix := range []string{"sses", "xes", "ches", "shes"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, "es")
+		}
+	}
+	if len(name) > 1 && strings.HasSuffix(name, "s") {
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+// the first sentence of a description, which tend to span paragraphs
+func firstSentence(description string) string {
+	description = strings.TrimSpace(strings.SplitN(description, "\n", 2)[0])
+	if i := strings.Index(description, ". "); i >= 0 {
+		return description[:i+1]
+	}
+	return description
+}
+
+// turn an attribute name into an exported identifier, i.e. $ref into Ref and x509Certificates into
+// X509Certificates
+func exportedName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+// the types of package typed must be regenerated whenever the generator or the schemas change
+func TestGenerate(t *testing.T) {
+	enterprise := []extension{{typeName: "EnterpriseUser", urn: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"}}
+	for _, test := range []struct {
+		schema     string
+		typeName   string
+		extensions []extension
+		generated  string
+	}{
+		{"../resources/schemas/user_internal.json", "User", enterprise, "../../typed/user.go"},
+		{"../resources/schemas/group_internal.json", "Group", nil, "../../typed/group.go"},
+		{"../resources/schemas/enterprise_user.json", "EnterpriseUser", nil, "../../typed/enterprise_user.go"},
+	} {
+		sch, err := shared.NewSchemaRegistry().LoadFile("../" + test.schema)
+		require.Nil(t, err)
+		src, err := generate("typed", test.typeName, test.schema, sch, test.extensions)
+		require.Nil(t, err)
+		expected, err := ioutil.ReadFile(test.generated)
+		require.Nil(t, err)
+		assert.Equal(t, string(expected), string(src), test.generated)
+	}
+}
+
+func TestNames(t *testing.T) {
+	for name, expected := range map[string]string{
+		"userName":         "UserName",
+		"$ref":             "Ref",
+		"x509Certificates": "X509Certificates",
+	} {
+		assert.Equal(t, expected, exportedName(name))
+	}
+	for name, expected := range map[string]string{
+		"Emails":    "Email",
+		"Addresses": "Address",
+		"Ims":       "Im",
+		"Manager":   "Manager",
+	} {
+		assert.Equal(t, expected, singular(name))
+	}
+}
@@ -0,0 +1,165 @@
+package compat
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The quirks of an identity provider the server works around for the clients provisioning through it, so that
+// their requests succeed as they are sent rather than being rejected, or worse, applied as RFC 7644 reads them
+type Profile struct {
+	Name string
+	// add the schemas resources and patch requests lack, which OneLogin leaves out
+	FillSchemas bool
+	// apply the merge replace policy, see shared.MergeReplace, to the PUT requests Okta sends with the attributes
+	// it manages only, i.e. when deactivating a user
+	MergeReplace bool
+	// accept patch operation names in any casing, i.e. Replace, which Azure AD sends
+	FoldOpCase bool
+	// repair values of the wrong type, i.e. active as "False", see shared.WithLenientValidation
+	Lenient bool
+}
+
+// The profiles of the identity providers whose provisioning traffic is recorded under resources/tests/compat
+var (
+	AzureAD  = Profile{Name: "azure", FoldOpCase: true, Lenient: true}
+	Okta     = Profile{Name: "okta", MergeReplace: true}
+	OneLogin = Profile{Name: "onelogin", FillSchemas: true}
+	// WorkOS relays the requests of the directories connected to it, so it takes on their quirks
+	WorkOS = Profile{Name: "workos", FillSchemas: true, FoldOpCase: true, Lenient: true}
+)
+
+var profiles = struct {
+	sync.RWMutex
+	byName map[string]Profile
+}{byName: map[string]Profile{
+	AzureAD.Name:  AzureAD,
+	Okta.Name:     Okta,
+	OneLogin.Name: OneLogin,
+	WorkOS.Name:   WorkOS,
+}}
+
+// Make the profile available to Lookup under its name, replacing a profile of the same name
+func Register(profile Profile) {
+	profiles.Lock()
+	defer profiles.Unlock()
+	profiles.byName[strings.ToLower(profile.Name)] = profile
+}
+
+// The profile registered under the name, in any casing
+func Lookup(name string) (Profile, bool) {
+	profiles.RLock()
+	defer profiles.RUnlock()
+	profile, ok := profiles.byName[strings.ToLower(name)]
+	return profile, ok
+}
+
+// Wrap a handler so that requests whose principal has a profile are adapted to its quirks before they reach
+// it; pass it to handlers.ChainWith as an adapter, so that it reads bodies the chain limited. Bodies that are not
+// JSON objects are passed on unchanged, for the handler to reject; the operations of bulk requests are not
+// adapted.
+func Wrap(next handlers.EndpointHandler, requestType int, byPrincipal map[string]Profile) handlers.EndpointHandler {
+	if len(byPrincipal) == 0 {
+		return next
+	}
+	resourceType, operation := shared.DescribeRequestType(requestType)
+	return func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+		principal, _ := ctx.Value(shared.Principal{}).(string)
+		profile, ok := byPrincipal[principal]
+		if !ok {
+			return next(r, server, ctx)
+		}
+
+		if profile.Lenient && shared.LenientWarnings(ctx) == nil {
+			ctx = shared.WithLenientValidation(ctx)
+		}
+		if profile.MergeReplace && operation == "replace" {
+			ctx = context.WithValue(ctx, shared.ReplacePolicy{}, shared.MergeReplace)
+		}
+		if (profile.FillSchemas || profile.FoldOpCase) && (operation == "create" || operation == "replace" || operation == "patch") {
+			if body, err := r.Body(); err == nil {
+				r = &request{WebRequest: r, body: profile.adapt(body, resourceType, operation)}
+			}
+		}
+		return next(r, server, ctx)
+	}
+}
+
+// the body of the request adapted to the quirks of the profile
+func (p Profile) adapt(body []byte, resourceType, operation string) []byte {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+
+	if p.FillSchemas && !hasKey(data, "schemas") {
+		switch {
+		case operation == "patch":
+			data["schemas"] = []interface{}{shared.PatchOpUrn}
+		case resourceType == shared.UserResourceType:
+			data["schemas"] = withExtensions([]interface{}{shared.UserUrn}, data)
+		case resourceType == shared.GroupResourceType:
+			data["schemas"] = withExtensions([]interface{}{shared.GroupUrn}, data)
+		}
+	}
+	if p.FoldOpCase && operation == "patch" {
+		for k, v := range data {
+			if !strings.EqualFold(k, "Operations") {
+				continue
+			}
+			ops, _ := v.([]interface{})
+			for _, op := range ops {
+				if m, ok := op.(map[string]interface{}); ok {
+					if name, ok := m["op"].(string); ok {
+						m["op"] = strings.ToLower(name)
+					}
+				}
+			}
+		}
+	}
+
+	adapted, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	return adapted
+}
+
+func hasKey(data map[string]interface{}, name string) bool {
+	for k := range data {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// the schemas with the URNs of the extension attributes present in the data
+func withExtensions(schemas []interface{}, data map[string]interface{}) []interface{} {
+	urns := make([]string, 0)
+	for k := range data {
+		if strings.HasPrefix(strings.ToLower(k), "urn:") {
+			urns = append(urns, k)
+		}
+	}
+	sort.Strings(urns)
+	for _, urn := range urns {
+		schemas = append(schemas, urn)
+	}
+	return schemas
+}
+
+// a request whose body was adapted
+type request struct {
+	shared.WebRequest
+	body []byte
+}
+
+func (r *request) Body() ([]byte, error) {
+	return r.body, nil
+}
@@ -0,0 +1,70 @@
+package compat
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testRequest struct {
+	shared.WebRequest
+	body string
+}
+
+func (r *testRequest) Body() ([]byte, error) { return []byte(r.body), nil }
+
+func TestWrap(t *testing.T) {
+	var body map[string]interface{}
+	var ctx context.Context
+	next := func(r shared.WebRequest, server handlers.ScimServer, c context.Context) *handlers.ResponseInfo {
+		raw, err := r.Body()
+		require.Nil(t, err)
+		body = make(map[string]interface{})
+		require.Nil(t, json.Unmarshal(raw, &body))
+		ctx = c
+		return nil
+	}
+	byPrincipal := map[string]Profile{"onelogin": OneLogin, "okta": Okta, "azure": AzureAD}
+	as := func(principal string) context.Context {
+		return context.WithValue(context.Background(), shared.Principal{}, principal)
+	}
+
+	Wrap(next, shared.CreateUser, byPrincipal)(&testRequest{body: `{"userName":"mary","` + shared.EnterpriseUrn + `":{}}`}, nil, as("onelogin"))
+	assert.Equal(t, []interface{}{shared.UserUrn, shared.EnterpriseUrn}, body["schemas"])
+
+	Wrap(next, shared.PatchGroup, byPrincipal)(&testRequest{body: `{"Operations":[{"op":"Replace"}]}`}, nil, as("onelogin"))
+	assert.Equal(t, []interface{}{shared.PatchOpUrn}, body["schemas"])
+	assert.Equal(t, "Replace", body["Operations"].([]interface{})[0].(map[string]interface{})["op"])
+
+	Wrap(next, shared.PatchGroup, byPrincipal)(&testRequest{body: `{"Operations":[{"op":"Replace"}]}`}, nil, as("azure"))
+	assert.Nil(t, body["schemas"])
+	assert.Equal(t, "replace", body["Operations"].([]interface{})[0].(map[string]interface{})["op"])
+	assert.NotNil(t, shared.LenientWarnings(ctx))
+
+	Wrap(next, shared.ReplaceUser, byPrincipal)(&testRequest{body: `{}`}, nil, as("okta"))
+	assert.Equal(t, shared.MergeReplace, ctx.Value(shared.ReplacePolicy{}))
+	assert.Nil(t, shared.LenientWarnings(ctx))
+	Wrap(next, shared.CreateUser, byPrincipal)(&testRequest{body: `{}`}, nil, as("okta"))
+	assert.Nil(t, ctx.Value(shared.ReplacePolicy{}))
+
+	// other clients are left alone
+	Wrap(next, shared.CreateUser, byPrincipal)(&testRequest{body: `{"userName":"mary"}`}, nil, as("other"))
+	assert.Nil(t, body["schemas"])
+}
+
+func TestLookup(t *testing.T) {
+	profile, ok := Lookup("Okta")
+	assert.True(t, ok)
+	assert.Equal(t, Okta, profile)
+
+	_, ok = Lookup("acme")
+	assert.False(t, ok)
+	Register(Profile{Name: "acme", FillSchemas: true})
+	profile, ok = Lookup("ACME")
+	assert.True(t, ok)
+	assert.True(t, profile.FillSchemas)
+}
@@ -0,0 +1,82 @@
+package compat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+)
+
+// Provisioning traffic of an identity provider recorded as it was sent, to replay against a server so that
+// changes do not break what real clients send
+type Fixture struct {
+	Profile   string     `json:"profile"` // the profile the requests need, see Lookup
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// A recorded request and what its response must hold
+type Exchange struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"` // below the prefix, {id} standing for the id of the last created resource
+	Body   json.RawMessage `json:"body,omitempty"`
+	Status int             `json:"status"`
+	Expect json.RawMessage `json:"expect,omitempty"` // attributes the response must carry with these values
+}
+
+func LoadFixture(path string) (*Fixture, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixture := &Fixture{}
+	if err := json.Unmarshal(raw, fixture); err != nil {
+		return nil, fmt.Errorf("fixture %s: %s", path, err)
+	}
+	return fixture, nil
+}
+
+// Send the recorded requests to the handler in order, with the bearer token, and fail on the first response
+// that does not match the recording
+func (f *Fixture) Replay(handler http.Handler, prefix, token string) error {
+	id := ""
+	for i, exchange := range f.Exchanges {
+		req := httptest.NewRequest(exchange.Method, prefix+strings.Replace(exchange.Path, "{id}", id, -1), bytes.NewReader(exchange.Body))
+		req.Header.Set("Content-Type", "application/scim+json")
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		if rw.Code != exchange.Status {
+			return fmt.Errorf("exchange %d, %s %s: expect status %d, got %d: %s", i+1, exchange.Method, exchange.Path, exchange.Status, rw.Code, rw.Body.String())
+		}
+		response := make(map[string]interface{})
+		if rw.Body.Len() > 0 {
+			if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+				return fmt.Errorf("exchange %d, %s %s: %s", i+1, exchange.Method, exchange.Path, err)
+			}
+		}
+		if created, ok := response["id"].(string); ok && exchange.Status == http.StatusCreated {
+			id = created
+		}
+
+		if len(exchange.Expect) == 0 {
+			continue
+		}
+		expect := make(map[string]interface{})
+		if err := json.Unmarshal(exchange.Expect, &expect); err != nil {
+			return fmt.Errorf("exchange %d, %s %s: %s", i+1, exchange.Method, exchange.Path, err)
+		}
+		for k, v := range expect {
+			if !reflect.DeepEqual(v, response[k]) {
+				return fmt.Errorf("exchange %d, %s %s: expect %s to be %v, got %v", i+1, exchange.Method, exchange.Path, k, v, response[k])
+			}
+		}
+	}
+	return nil
+}
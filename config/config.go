@@ -0,0 +1,398 @@
+// Package config builds a fully wired server from a configuration file and the environment, so that a server
+// backed by MongoDB, or by memory for tests and demos, takes a file instead of setup code:
+//
+//	cfg, err := config.Load("scim.yaml")
+//	...
+//	server, err := config.Build(cfg)
+//	...
+//	http.ListenAndServe(":8080", server.Handler())
+//
+// Files are YAML, which JSON files also are. Every setting of Default is overridden by the file, and settings
+// with an environment variable, listed with the env tag of their field, by the environment, i.e. SCIM_MONGO_URL
+// for the connection string in containers. Paths are relative to the working directory.
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repository kinds
+const (
+	MongoRepository  = "mongo"  // MongoDB collections, one per resource type
+	MemoryRepository = "memory" // in memory maps, lost on restart
+)
+
+// The settings of a server, see Build
+type Config struct {
+	// The address clients reach the server at, i.e. https://example.com/v2. Its path prefixes all endpoints.
+	BaseURL string `yaml:"baseUrl" env:"SCIM_BASE_URL"`
+	// Build locations from the X-Forwarded-* headers of a trusted proxy instead, see shared.NewForwardedBaseURL
+	TrustForwarded bool   `yaml:"trustForwarded" env:"SCIM_TRUST_FORWARDED"`
+	LogLevel       string `yaml:"logLevel" env:"SCIM_LOG_LEVEL"` // debug, info, warn or error
+
+	Schemas    SchemaConfig     `yaml:"schemas"`
+	Repository RepositoryConfig `yaml:"repository"`
+	Features   FeatureConfig    `yaml:"features"`
+	Protocol   ProtocolConfig   `yaml:"protocol"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Mirror     MirrorConfig     `yaml:"mirror"`
+
+	// Values of attributes absent from created resources, by resource type and attribute path, i.e. active: true
+	// under User. Defaults per tenant take code, see shared.TenantDefault.
+	Defaults map[string]map[string]interface{} `yaml:"defaults"`
+	// Checks of attribute values, by resource type and attribute path, see shared.Validators
+	Validators map[string]map[string]ValidatorConfig `yaml:"validators"`
+	// Receivers of the change events of users and groups, see publish.NewWebhookPublisher
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// The schema and resource files to load
+type SchemaConfig struct {
+	Root          string   `yaml:"root" env:"SCIM_SCHEMA_ROOT"`   // internal schema of root queries
+	User          string   `yaml:"user" env:"SCIM_SCHEMA_USER"`   // internal schema of users
+	Group         string   `yaml:"group" env:"SCIM_SCHEMA_GROUP"` // internal schema of groups
+	Role          string   `yaml:"role" env:"SCIM_SCHEMA_ROLE"`   // internal schema of roles, see Features.Roles
+	Entitlement   string   `yaml:"entitlement" env:"SCIM_SCHEMA_ENTITLEMENT"`
+	Served        []string `yaml:"served" env:"SCIM_SCHEMAS_SERVED"`
+	ResourceTypes []string `yaml:"resourceTypes" env:"SCIM_RESOURCE_TYPES"`
+	SPConfig      string   `yaml:"spConfig" env:"SCIM_SP_CONFIG"`
+	// attribute paths of users no two users may share a value of, see shared.Schema.DeclareUnique
+	UniqueUserAttributes []string `yaml:"uniqueUserAttributes" env:"SCIM_UNIQUE_USER_ATTRIBUTES"`
+	// custom extensions of users and groups, see shared.Schema.AddExtension
+	Extensions []Extension `yaml:"extensions"`
+}
+
+// A schema extension of a resource type. The schema is served, embedded into the internal schema of the
+// resource type and listed among the schemaExtensions of its resource type.
+type Extension struct {
+	ResourceType string `yaml:"resourceType"` // User or Group
+	Schema       string `yaml:"schema"`       // file of the extension schema
+	Required     bool   `yaml:"required"`
+}
+
+// Where resources are stored and how the repositories are decorated
+type RepositoryConfig struct {
+	Kind            string `yaml:"kind" env:"SCIM_REPOSITORY"` // mongo or memory
+	URL             string `yaml:"url" env:"SCIM_MONGO_URL"`
+	Database        string `yaml:"database" env:"SCIM_MONGO_DB"`
+	UserCollection  string `yaml:"userCollection" env:"SCIM_MONGO_USER_COLLECTION"`
+	GroupCollection string `yaml:"groupCollection" env:"SCIM_MONGO_GROUP_COLLECTION"`
+	// collections of roles and entitlements, opened when the roles feature is on
+	RoleCollection        string `yaml:"roleCollection" env:"SCIM_MONGO_ROLE_COLLECTION"`
+	EntitlementCollection string `yaml:"entitlementCollection" env:"SCIM_MONGO_ENTITLEMENT_COLLECTION"`
+
+	FilterCacheSize  int           `yaml:"filterCacheSize" env:"SCIM_FILTER_CACHE_SIZE"`    // compiled filters cached, none if 0
+	CacheSize        int           `yaml:"cacheSize" env:"SCIM_CACHE_SIZE"`                 // resources cached per type, none if 0
+	CacheTTL         time.Duration `yaml:"cacheTtl" env:"SCIM_CACHE_TTL"`                   // how long resources stay cached
+	RetryAttempts    int           `yaml:"retryAttempts" env:"SCIM_RETRY_ATTEMPTS"`         // no retries if less than 2
+	RetryBaseDelay   time.Duration `yaml:"retryBaseDelay" env:"SCIM_RETRY_BASE_DELAY"`      // see shared.RetryPolicy
+	RetryMaxDelay    time.Duration `yaml:"retryMaxDelay" env:"SCIM_RETRY_MAX_DELAY"`        // see shared.RetryPolicy
+	BreakerThreshold int           `yaml:"breakerThreshold" env:"SCIM_BREAKER_THRESHOLD"`   // no circuit breaker if 0
+	BreakerCooldown  time.Duration `yaml:"breakerCooldown" env:"SCIM_BREAKER_COOLDOWN"`     // see shared.NewCircuitBreaker
+	UniquenessBatch  int           `yaml:"uniquenessBatchSize" env:"SCIM_UNIQUENESS_BATCH"` // see shared.ValidateUniquenessBatch
+	UniquenessWorker int           `yaml:"uniquenessWorkers" env:"SCIM_UNIQUENESS_WORKERS"` // see shared.ValidateUniquenessBatch
+	SearchTimeout    time.Duration `yaml:"searchTimeout" env:"SCIM_SEARCH_TIMEOUT"`         // see shared.NewSearchTimeoutRepository, none if 0
+	Tombstones       time.Duration `yaml:"tombstones" env:"SCIM_TOMBSTONES"`                // see shared.NewTombstoneRepository, in MongoDB with it, none if 0
+	ChangeLog        time.Duration `yaml:"changeLog" env:"SCIM_CHANGE_LOG"`                 // changes kept for delta queries, none if 0
+	ChangeLogSize    int           `yaml:"changeLogSize" env:"SCIM_CHANGE_LOG_SIZE"`        // at most this many of them, see shared.NewMemoryChangeLog
+	Compact          bool          `yaml:"compact" env:"SCIM_REPOSITORY_COMPACT"`           // memory only, see shared.NewCompactRepository
+	// users and groups are each spread over this many collections, see shared.NewShardedRepository, one if 0
+	Shards int `yaml:"shards" env:"SCIM_REPOSITORY_SHARDS"`
+	// mongo only, create the indexes shared.AdviseIndexes recommends from the schemas and the filter log on startup
+	EnsureIndexes   bool   `yaml:"ensureIndexes" env:"SCIM_ENSURE_INDEXES"`
+	FilterLog       string `yaml:"filterLog" env:"SCIM_FILTER_LOG"`              // executed filters, see shared.ReadFilterLog
+	IndexMinFilters int    `yaml:"indexMinFilters" env:"SCIM_INDEX_MIN_FILTERS"` // filters on an attribute that advise an index
+}
+
+// Optional features. Those of the service provider configuration are advertised accordingly, and requests to
+// turned off ones are answered with 501 Not Implemented.
+type FeatureConfig struct {
+	Bulk           bool   `yaml:"bulk" env:"SCIM_FEATURE_BULK"`
+	Patch          bool   `yaml:"patch" env:"SCIM_FEATURE_PATCH"`
+	ETag           string `yaml:"etag" env:"SCIM_FEATURE_ETAG"` // off, optional or required, see shared.ETagOptional
+	ChangePassword bool   `yaml:"changePassword" env:"SCIM_FEATURE_CHANGE_PASSWORD"`
+	Async          bool   `yaml:"async" env:"SCIM_FEATURE_ASYNC"`                    // queue mutations, see shared.OperationWorkers
+	AttributeUsage bool   `yaml:"attributeUsage" env:"SCIM_FEATURE_ATTRIBUTE_USAGE"` // see shared.AttributeUsage
+	Journal        bool   `yaml:"journal" env:"SCIM_FEATURE_JOURNAL"`                // see shared.NewMemoryJournal
+	ProfileLabels  bool   `yaml:"profileLabels" env:"SCIM_FEATURE_PROFILE_LABELS"`   // see shared.WithProfileLabels
+	// POST /Users/.export and /Groups/.export, dumps kept in memory unless exportDir names a directory
+	Export    bool   `yaml:"export" env:"SCIM_FEATURE_EXPORT"`
+	ExportDir string `yaml:"exportDir" env:"SCIM_EXPORT_DIR"`
+	// finished exports and their dumps are deleted this long after they finished, a day if 0
+	ExportRetention time.Duration `yaml:"exportRetention" env:"SCIM_EXPORT_RETENTION"`
+	// reject group members closing a membership cycle, see shared.DetectMembershipCycle
+	MembershipCycles bool `yaml:"membershipCycles" env:"SCIM_FEATURE_MEMBERSHIP_CYCLES"`
+	// serve /Roles and /Entitlements, which the roles and entitlements of users must then refer to, see
+	// shared.ValidateAssignments
+	Roles bool `yaml:"roles" env:"SCIM_FEATURE_ROLES"`
+	// serve POST /Groups/{id}/members for sync engines, see handlers.ApplyMembershipDelta
+	MembershipDelta bool `yaml:"membershipDelta" env:"SCIM_FEATURE_MEMBERSHIP_DELTA"`
+	// operations answered 501 by resource type, i.e. delete and patch under User, see shared.OperationToggles
+	Disabled map[string][]string `yaml:"disabled"`
+}
+
+// The scim.protocol properties the handlers read
+type ProtocolConfig struct {
+	ItemsPerPage      int           `yaml:"itemsPerPage" env:"SCIM_ITEMS_PER_PAGE"`
+	MaxResults        int           `yaml:"maxResults" env:"SCIM_MAX_RESULTS"` // filter.maxResults advertised, unchanged if 0
+	UnknownAttributes string        `yaml:"unknownAttributes" env:"SCIM_UNKNOWN_ATTRIBUTES"`
+	Replace           string        `yaml:"replace" env:"SCIM_REPLACE"`
+	DuplicateCreate   string        `yaml:"duplicateCreate" env:"SCIM_DUPLICATE_CREATE"`
+	RequestTimeout    time.Duration `yaml:"requestTimeout" env:"SCIM_REQUEST_TIMEOUT"`
+	MaxRequestBytes   int           `yaml:"maxRequestBytes" env:"SCIM_MAX_REQUEST_BYTES"`
+	LenientClients    []string      `yaml:"lenientClients" env:"SCIM_LENIENT_CLIENTS"`
+	ManagerChainDepth int           `yaml:"managerChainDepth" env:"SCIM_MANAGER_CHAIN_DEPTH"`
+	PatchRetries      int           `yaml:"patchRetries" env:"SCIM_PATCH_RETRIES"` // PATCH without If-Match, off if 0
+	// users updated within the request renaming a group they refer to, more are updated in the background
+	GroupDisplaySyncLimit int `yaml:"groupDisplaySyncLimit" env:"SCIM_GROUP_DISPLAY_SYNC_LIMIT"`
+	// renamed groups updated in the background at a time, a rename finding all busy updates within the request
+	GroupDisplayWorkers int    `yaml:"groupDisplayWorkers" env:"SCIM_GROUP_DISPLAY_WORKERS"`
+	CanonicalJSON       bool   `yaml:"canonicalJson" env:"SCIM_CANONICAL_JSON"`
+	ListItemSchemas     bool   `yaml:"listItemSchemas" env:"SCIM_LIST_ITEM_SCHEMAS"`
+	Locale              string `yaml:"locale" env:"SCIM_LOCALE"` // see shared.SetCollationLocale, unchanged if empty
+	// translations of error details picked by Accept-Language, see shared.MessageCatalog, English only if empty
+	Messages string `yaml:"messages" env:"SCIM_MESSAGES"`
+
+	// bounds of the filters of clients, see shared.FilterLimits, unbounded if 0
+	FilterMaxDepth   int `yaml:"filterMaxDepth" env:"SCIM_FILTER_MAX_DEPTH"`
+	FilterMaxClauses int `yaml:"filterMaxClauses" env:"SCIM_FILTER_MAX_CLAUSES"`
+}
+
+// Who may call the server. Without tokens, requests are not authenticated and the embedder is expected to set
+// the shared.Principal and shared.Scopes of requests itself.
+type AuthConfig struct {
+	Tokens     []Token  `yaml:"tokens" env:"SCIM_AUTH_TOKENS"`     // bearer tokens, principal=token in the environment
+	Policies   []Policy `yaml:"policies"`                          // all access is granted if none
+	AdminToken string   `yaml:"adminToken" env:"SCIM_ADMIN_TOKEN"` // X-Admin-Token of the /Admin endpoints, off if empty
+	RateLimit  float64  `yaml:"rateLimit" env:"SCIM_RATE_LIMIT"`   // requests per second per client, unlimited if 0
+	RateBurst  int      `yaml:"rateBurst" env:"SCIM_RATE_BURST"`
+	// IPs or CIDR ranges of the proxies whose X-Forwarded-For and X-Real-IP tell the client rate limited
+	TrustedProxies []string `yaml:"trustedProxies" env:"SCIM_TRUSTED_PROXIES"`
+}
+
+// The checks of the values of an attribute, all of which must pass
+type ValidatorConfig struct {
+	Pattern      string   `yaml:"pattern"`     // a regular expression the values match in full
+	Description  string   `yaml:"description"` // of what the pattern matches, reported to clients
+	E164         bool     `yaml:"e164"`        // phone numbers in E.164 format
+	EmailDomains []string `yaml:"emailDomains"`
+}
+
+// A receiver of change events and the attributes of the resources it is sent, see shared.EventMask. Events
+// are posted as part of the write; those the receiver fails to take are posted again in the background.
+type WebhookConfig struct {
+	URL                string   `yaml:"url"`
+	Secret             string   `yaml:"secret"` // sent in the X-Webhook-Secret header, none if empty
+	Attributes         []string `yaml:"attributes"`
+	ExcludedAttributes []string `yaml:"excludedAttributes"`
+	QueueSize          int      `yaml:"queueSize"` // events waiting to be posted, 1000 if not positive; more are dropped
+}
+
+// Serve the repositories as a read only projection of an external system of record, i.e. an HR system, populated
+// by a feed of its own. Users and groups can be read and searched; writes are answered with 501, or forwarded to
+// the upstream if one is given, see handlers.Forward.
+type MirrorConfig struct {
+	Enabled  bool   `yaml:"enabled" env:"SCIM_MIRROR"`
+	Upstream string `yaml:"upstream" env:"SCIM_MIRROR_UPSTREAM"` // i.e. https://hr.example.com/scim/v2
+	Token    string `yaml:"token" env:"SCIM_MIRROR_TOKEN"`       // bearer token of the upstream, none if empty
+	// of the requests to the upstream, handlers.DefaultForwardTimeout if 0
+	Timeout time.Duration `yaml:"timeout" env:"SCIM_MIRROR_TIMEOUT"`
+	// upstream responses larger are answered with 503, handlers.DefaultForwardResponseBytes if 0
+	MaxResponseBytes int `yaml:"maxResponseBytes" env:"SCIM_MIRROR_MAX_RESPONSE_BYTES"`
+}
+
+// A bearer token and the principal and scopes of the requests carrying it
+type Token struct {
+	Token     string   `yaml:"token"`
+	Principal string   `yaml:"principal"`
+	Scopes    []string `yaml:"scopes"`
+	Profile   string   `yaml:"profile"` // quirks of the identity provider to work around, see compat.Lookup
+}
+
+// Read principal=token, the form tokens take in the environment
+func (t *Token) UnmarshalText(text []byte) error {
+	parts := strings.SplitN(string(text), "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("invalid token, expect principal=token")
+	}
+	t.Principal, t.Token = parts[0], parts[1]
+	return nil
+}
+
+// An access policy, see shared.AccessPolicy
+type Policy struct {
+	Principals   []string `yaml:"principals"`
+	Scopes       []string `yaml:"scopes"`
+	ResourceType string   `yaml:"resourceType"`
+	Readable     []string `yaml:"readable"`
+	Writable     []string `yaml:"writable"`
+}
+
+// The settings of the example server, against the schemas of this repository and a local MongoDB
+func Default() *Config {
+	return &Config{
+		BaseURL:  "http://localhost:8080/v2",
+		LogLevel: "info",
+		Schemas: SchemaConfig{
+			Root:          "resources/schemas/root_internal.json",
+			User:          "resources/schemas/user_internal.json",
+			Group:         "resources/schemas/group_internal.json",
+			Role:          "resources/schemas/role_internal.json",
+			Entitlement:   "resources/schemas/entitlement_internal.json",
+			Served:        []string{"resources/schemas/user.json", "resources/schemas/group.json"},
+			ResourceTypes: []string{"resources/resource_types/user.json", "resources/resource_types/group.json"},
+			SPConfig:      "resources/sp_config/sp_config.json",
+		},
+		Repository: RepositoryConfig{
+			Kind:             MongoRepository,
+			URL:              "mongodb://localhost:27017/scim?maxPoolSize=100",
+			Database:         "scim",
+			UserCollection:   "users",
+			GroupCollection:  "groups",
+			FilterCacheSize:  1000,
+			RetryAttempts:    3,
+			RetryBaseDelay:   50 * time.Millisecond,
+			RetryMaxDelay:    time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+			UniquenessBatch:  50,
+			UniquenessWorker: 4,
+			ChangeLogSize:    1000000,
+
+			RoleCollection:        "roles",
+			EntitlementCollection: "entitlements",
+		},
+		Features: FeatureConfig{
+			Bulk:  true,
+			Patch: true,
+			ETag:  "optional",
+		},
+		Protocol: ProtocolConfig{
+			ItemsPerPage:          10,
+			UnknownAttributes:     "reject",
+			Replace:               "strict",
+			DuplicateCreate:       "conflict",
+			RequestTimeout:        30 * time.Second,
+			MaxRequestBytes:       1 << 20,
+			ManagerChainDepth:     20,
+			GroupDisplaySyncLimit: 100,
+			GroupDisplayWorkers:   4,
+			FilterMaxDepth:        10,
+			FilterMaxClauses:      100,
+		},
+	}
+}
+
+// Load the configuration: the defaults, overridden by the file unless path is empty, overridden by the
+// environment
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if len(path) > 0 {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := cfg.ApplyEnvironment(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Override the settings that have an environment variable set. Lists are comma separated.
+func (cfg *Config) ApplyEnvironment(lookup func(key string) (string, bool)) error {
+	return applyEnvironment(reflect.ValueOf(cfg).Elem(), lookup)
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func applyEnvironment(v reflect.Value, lookup func(key string) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		field, structField := v.Field(i), v.Type().Field(i)
+		key := structField.Tag.Get("env")
+		if len(key) == 0 {
+			if field.Kind() == reflect.Struct {
+				if err := applyEnvironment(field, lookup); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		text, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setField(field, strings.TrimSpace(text)); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, text string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		elems := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, each := range strings.Split(text, ",") {
+			if each = strings.TrimSpace(each); len(each) == 0 {
+				continue
+			}
+			elem := reflect.New(field.Type().Elem())
+			if u, ok := elem.Interface().(encoding.TextUnmarshaler); ok && elem.Type().Implements(textUnmarshalerType) {
+				if err := u.UnmarshalText([]byte(each)); err != nil {
+					return err
+				}
+			} else if err := setField(elem.Elem(), each); err != nil {
+				return err
+			}
+			elems = reflect.Append(elems, elem.Elem())
+		}
+		field.Set(elems)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
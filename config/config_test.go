@@ -0,0 +1,1068 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/compat"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	env := map[string]string{
+		"SCIM_REPOSITORY":          "memory",
+		"SCIM_CACHE_TTL":           "5m",
+		"SCIM_FEATURE_BULK":        "false",
+		"SCIM_LENIENT_CLIENTS":     "okta, azure",
+		"SCIM_AUTH_TOKENS":         "okta=secret,azure=other",
+		"SCIM_ITEMS_PER_PAGE":      "25",
+		"SCIM_UNKNOWN_OPTION":      "ignored",
+		"SCIM_MANAGER_CHAIN_DEPTH": "5",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	cfg, err := Load("../resources/config/server.yaml")
+	require.Nil(t, err)
+	assert.Equal(t, MongoRepository, cfg.Repository.Kind)
+	assert.Equal(t, time.Minute, cfg.Repository.CacheTTL)
+	assert.Equal(t, 30*time.Second, cfg.Protocol.RequestTimeout)
+	assert.Equal(t, []string{"emails.value"}, cfg.Schemas.UniqueUserAttributes)
+	require.Len(t, cfg.Auth.Tokens, 1)
+	assert.Equal(t, Token{Token: "change-me", Principal: "okta", Scopes: []string{"scim.read", "scim.write"}}, cfg.Auth.Tokens[0])
+	assert.Equal(t, map[string]map[string]interface{}{"User": {"active": true}}, cfg.Defaults)
+
+	require.Nil(t, cfg.ApplyEnvironment(lookup))
+	assert.Equal(t, MemoryRepository, cfg.Repository.Kind)
+	assert.Equal(t, 5*time.Minute, cfg.Repository.CacheTTL)
+	assert.False(t, cfg.Features.Bulk)
+	assert.True(t, cfg.Features.Patch)
+	assert.Equal(t, []string{"okta", "azure"}, cfg.Protocol.LenientClients)
+	assert.Equal(t, []Token{{Token: "secret", Principal: "okta"}, {Token: "other", Principal: "azure"}}, cfg.Auth.Tokens)
+	assert.Equal(t, 25, cfg.Protocol.ItemsPerPage)
+	assert.Equal(t, 5, cfg.Protocol.ManagerChainDepth)
+
+	env["SCIM_ITEMS_PER_PAGE"] = "many"
+	assert.NotNil(t, cfg.ApplyEnvironment(lookup))
+	env["SCIM_ITEMS_PER_PAGE"] = "25"
+	env["SCIM_AUTH_TOKENS"] = "secret"
+	assert.NotNil(t, cfg.ApplyEnvironment(lookup))
+}
+
+func testConfig() *Config {
+	cfg := Default()
+	cfg.Schemas = SchemaConfig{
+		Root:          "../resources/schemas/root_internal.json",
+		User:          "../resources/schemas/user_internal.json",
+		Group:         "../resources/schemas/group_internal.json",
+		Role:          "../resources/schemas/role_internal.json",
+		Entitlement:   "../resources/schemas/entitlement_internal.json",
+		Served:        []string{"../resources/schemas/user.json", "../resources/schemas/group.json"},
+		ResourceTypes: []string{"../resources/resource_types/user.json", "../resources/resource_types/group.json"},
+		SPConfig:      "../resources/sp_config/sp_config.json",
+	}
+	cfg.Repository.Kind = MemoryRepository
+	cfg.LogLevel = "error"
+	return cfg
+}
+
+func TestBuild(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.Bulk = false
+	cfg.Auth.Tokens = []Token{{Token: "secret", Principal: "okta"}}
+	cfg.Defaults = map[string]map[string]interface{}{"User": {"active": true, "preferredLanguage": "en-US"}}
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	user := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"bjensen"}`
+	assert.Equal(t, http.StatusUnauthorized, scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, bearer("wrong")).Code)
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/healthz", nil, nil).Code)
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, bearer("secret"))
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	created := scimtest.Decode(t, rw)
+	assert.Equal(t, "http://localhost:8080/v2/Users/"+created["id"].(string), rw.Header().Get("Location"))
+	assert.Equal(t, true, created["active"])
+	assert.Equal(t, "en-US", created["preferredLanguage"])
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+created["id"].(string), nil, bearer("secret"))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), "bjensen")
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Bulk", `{"schemas":["`+shared.BulkRequestUrn+`"],"Operations":[]}`, bearer("secret"))
+	assert.Equal(t, http.StatusNotImplemented, rw.Code)
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/ServiceProviderConfig", nil, bearer("secret"))
+	require.Equal(t, http.StatusOK, rw.Code)
+	spConfig := scimtest.Decode(t, rw)
+	assert.Equal(t, false, spConfig["bulk"].(map[string]interface{})["supported"])
+	assert.Equal(t, true, spConfig["patch"].(map[string]interface{})["supported"])
+}
+
+func TestBuildCompat(t *testing.T) {
+	paths, err := filepath.Glob("../resources/tests/compat/*.json")
+	require.Nil(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		fixture, err := compat.LoadFixture(path)
+		require.Nil(t, err)
+
+		replay := func(token string) error {
+			cfg := testConfig()
+			cfg.Auth.Tokens = []Token{
+				{Token: "quirky", Principal: "idp", Profile: fixture.Profile},
+				{Token: "strict", Principal: "other"},
+			}
+			server, err := Build(cfg)
+			require.Nil(t, err)
+			defer server.Close()
+			return fixture.Replay(server.Handler(), "/v2", token)
+		}
+		assert.Nil(t, replay("quirky"), path)
+		// the quirks are worked around for the clients of the profile only
+		assert.NotNil(t, replay("strict"), path)
+	}
+
+	// the quirks are worked around inside the chain, on bodies within the limit
+	cfg := testConfig()
+	cfg.Protocol.MaxRequestBytes = 64
+	cfg.Auth.Tokens = []Token{{Token: "quirky", Principal: "idp", Profile: compat.OneLogin.Name}}
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	rw := scimtest.Serve(t, server.Handler(), http.MethodPost, "/v2/Users", `{"userName": "`+strings.Repeat("x", 64)+`"}`,
+		map[string]string{"Authorization": "Bearer quirky"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code, rw.Body.String())
+	server.Close()
+
+	cfg = testConfig()
+	cfg.Auth.Tokens = []Token{{Token: "secret", Principal: "idp", Profile: "unknown"}}
+	_, err = Build(cfg)
+	assert.EqualError(t, err, `unknown profile "unknown" of the auth token of principal "idp"`)
+}
+
+func TestBuildExport(t *testing.T) {
+	server, err := Build(testConfig())
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotImplemented, scimtest.Serve(t, server.Handler(), http.MethodPost, "/v2/Users/.export", nil, nil).Code)
+	server.Close()
+
+	cfg := testConfig()
+	cfg.Features.Export = true
+	cfg.Auth.Tokens = []Token{{Token: "secret", Principal: "okta"}, {Token: "other", Principal: "azure"}}
+	server, err = Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	bearer := func(token string, headers map[string]string) map[string]string {
+		all := map[string]string{"Authorization": "Bearer " + token}
+		for k, v := range headers {
+			all[k] = v
+		}
+		return all
+	}
+	for i := 0; i < 5; i++ {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", fmt.Sprintf(`{"schemas":["%s"],"userName":"user%d"}`, shared.UserUrn, i), bearer("secret", nil))
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	}
+
+	assert.Equal(t, http.StatusBadRequest, scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/.export", `{"format":"csv"}`, bearer("secret", nil)).Code)
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/.export", `{"filter":"userName sw \"user\""}`, bearer("secret", nil))
+	require.Equal(t, http.StatusAccepted, rw.Code, rw.Body.String())
+	location := rw.Header().Get("Location")
+	require.Contains(t, location, "/v2/Exports/")
+	location = location[strings.Index(location, "/v2/"):]
+
+	var job map[string]interface{}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		rw = scimtest.Serve(t, handler, http.MethodGet, location, nil, bearer("secret", nil))
+		require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+		if job = scimtest.Decode(t, rw); job["status"] == shared.OperationSucceeded {
+			break
+		}
+	}
+	require.Equal(t, shared.OperationSucceeded, job["status"])
+	assert.Equal(t, float64(5), job["resources"])
+	size := int64(job["size"].(float64))
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, location+"/download", nil, bearer("secret", map[string]string{"Accept": "*/*"}))
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, "application/x-ndjson", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "bytes", rw.Header().Get("Accept-Ranges"))
+	full := rw.Body.Bytes()
+	assert.Equal(t, size, int64(len(full)))
+	assert.Equal(t, 5, bytes.Count(full, []byte("\n")))
+
+	// an interrupted download resumes where it stopped
+	rw = scimtest.Serve(t, handler, http.MethodGet, location+"/download", nil, bearer("secret", map[string]string{"Accept": "*/*", "Range": "bytes=10-", "If-Range": rw.Header().Get("ETag")}))
+	require.Equal(t, http.StatusPartialContent, rw.Code, rw.Body.String())
+	assert.Equal(t, fmt.Sprintf("bytes 10-%d/%d", size-1, size), rw.Header().Get("Content-Range"))
+	assert.Equal(t, full[10:], rw.Body.Bytes())
+	rw = scimtest.Serve(t, handler, http.MethodGet, location+"/download", nil, bearer("secret", map[string]string{"Accept": "*/*", "Range": fmt.Sprintf("bytes=%d-", size)}))
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rw.Code)
+	assert.Equal(t, fmt.Sprintf("bytes */%d", size), rw.Header().Get("Content-Range"))
+
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, "/v2/Exports/missing", nil, bearer("secret", nil)).Code)
+
+	// exports are the business of the principal that started them alone
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, location, nil, bearer("other", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, location+"/download", nil, bearer("other", map[string]string{"Accept": "*/*"})).Code)
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodDelete, location, nil, bearer("other", nil)).Code)
+
+	rw = scimtest.Serve(t, handler, http.MethodDelete, location, nil, bearer("secret", nil))
+	assert.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, location, nil, bearer("secret", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, location+"/download", nil, bearer("secret", map[string]string{"Accept": "*/*"})).Code)
+}
+
+func TestBuildValidators(t *testing.T) {
+	cfg := testConfig()
+	cfg.Validators = map[string]map[string]ValidatorConfig{
+		shared.UserResourceType: {
+			"phoneNumbers.value": {E164: true},
+			"emails.value":       {EmailDomains: []string{"example.com"}},
+		},
+	}
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"bjensen","emails":[{"value":"bjensen@example.com"}]}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	created := scimtest.Decode(t, rw)
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"mary","phoneNumbers":[{"value":"555-1234"}]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), `"scimType":"invalidValue"`)
+	assert.Contains(t, rw.Body.String(), "phoneNumbers.value")
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+created["id"].(string), `{"schemas":["`+shared.PatchOpUrn+`"],"Operations":[{"op":"add","path":"emails","value":[{"value":"bjensen@other.com"}]}]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "emails.value")
+
+	cfg = testConfig()
+	cfg.Validators = map[string]map[string]ValidatorConfig{shared.UserResourceType: {"nickName": {}}}
+	_, err = Build(cfg)
+	assert.EqualError(t, err, "validator of User nickName: expect a pattern, e164 or emailDomains")
+	cfg.Validators = map[string]map[string]ValidatorConfig{shared.UserResourceType: {"userName": {Pattern: "[a-z"}}}
+	_, err = Build(cfg)
+	assert.NotNil(t, err)
+}
+
+func TestBuildDisabledOperations(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.ChangePassword = true
+	cfg.Features.Disabled = map[string][]string{
+		shared.UserResourceType:  {"delete", "patch"},
+		shared.GroupResourceType: {"patch"},
+	}
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"bjensen"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	rw = scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, nil)
+	assert.Equal(t, http.StatusNotImplemented, rw.Code)
+	assert.Contains(t, rw.Body.String(), "delete of User is not supported")
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "nickName", "value": "babs"}]
+	}`, nil)
+	assert.Equal(t, http.StatusNotImplemented, rw.Code)
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+id, nil, nil).Code)
+	// a password change patches the user
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/"+id+"/.password", `{"oldPassword": "old secret", "newPassword": "new secret"}`, nil)
+	assert.Equal(t, http.StatusNotImplemented, rw.Code, rw.Body.String())
+	assert.Contains(t, rw.Body.String(), "patch of User is not supported")
+
+	// also within bulk requests
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Bulk", `{
+		"schemas": ["`+shared.BulkRequestUrn+`"],
+		"Operations": [{"method": "DELETE", "path": "/Users/`+id+`"}]
+	}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Contains(t, rw.Body.String(), `"status":501`)
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+id, nil, nil).Code)
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/ServiceProviderConfig", nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code)
+	spConfig := scimtest.Decode(t, rw)
+	assert.Equal(t, false, spConfig["patch"].(map[string]interface{})["supported"])
+	assert.Equal(t, true, spConfig["filter"].(map[string]interface{})["supported"])
+	assert.Equal(t, map[string]interface{}{
+		shared.UserResourceType:  []interface{}{"delete", "patch"},
+		shared.GroupResourceType: []interface{}{"patch"},
+	}, spConfig["disabledOperations"])
+
+	// the members of a group are read like the group
+	cfg.Features.Disabled = map[string][]string{shared.GroupResourceType: {"get"}}
+	server, err = Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	rw = scimtest.Serve(t, server.Handler(), http.MethodGet, "/v2/Groups/foo/members", nil, nil)
+	assert.Equal(t, http.StatusNotImplemented, rw.Code, rw.Body.String())
+	assert.Contains(t, rw.Body.String(), "get of Group is not supported")
+	rw = scimtest.Serve(t, server.Handler(), http.MethodGet, "/v2/Users/foo/managers", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rw.Code, rw.Body.String())
+
+	cfg.Features.Disabled = map[string][]string{shared.UserResourceType: {"purge"}}
+	_, err = Build(cfg)
+	assert.NotNil(t, err)
+}
+
+func TestBuildMirror(t *testing.T) {
+	const user = `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"bjensen"}`
+
+	cfg := testConfig()
+	cfg.Mirror.Enabled = true
+	mirror, err := Build(cfg)
+	require.Nil(t, err)
+	defer mirror.Close()
+	handler := mirror.Handler()
+
+	// populated by a feed of its own
+	require.Nil(t, mirror.Repository(shared.UserResourceType).Create(&shared.Resource{Complex: shared.Complex{
+		"schemas":  []interface{}{shared.UserUrn},
+		"id":       "fed",
+		"userName": "fed",
+		"meta":     map[string]interface{}{"version": "W/\"1\""},
+	}}, context.Background()))
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/fed", nil, nil).Code)
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, `/v2/Users?filter=userName+eq+"fed"`, nil, nil).Code)
+	assert.Equal(t, http.StatusNotImplemented, scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil).Code)
+	assert.Equal(t, http.StatusNotImplemented, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/fed", nil, nil).Code)
+	assert.Equal(t, http.StatusNotImplemented, scimtest.Serve(t, handler, http.MethodPost, "/v2/Bulk", `{"schemas":["`+shared.BulkRequestUrn+`"],"Operations":[]}`, nil).Code)
+
+	spConfig := scimtest.Decode(t, scimtest.Serve(t, handler, http.MethodGet, "/v2/ServiceProviderConfig", nil, nil))
+	assert.Equal(t, false, spConfig["bulk"].(map[string]interface{})["supported"])
+	assert.Equal(t, false, spConfig["patch"].(map[string]interface{})["supported"])
+	assert.Equal(t, true, spConfig["filter"].(map[string]interface{})["supported"])
+	rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Schemas/"+shared.UserUrn, nil, nil)
+	assert.NotContains(t, rw.Body.String(), `"readWrite"`)
+	assert.Contains(t, rw.Body.String(), `"readOnly"`)
+
+	// writes forwarded to the system of record
+	upstream, err := Build(testConfig())
+	require.Nil(t, err)
+	defer upstream.Close()
+	ts := httptest.NewServer(upstream.Handler())
+	defer ts.Close()
+
+	cfg = testConfig()
+	cfg.Mirror = MirrorConfig{Enabled: true, Upstream: ts.URL + "/v2"}
+	forwarding, err := Build(cfg)
+	require.Nil(t, err)
+	defer forwarding.Close()
+	handler = forwarding.Handler()
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	created := scimtest.Decode(t, rw)
+	assert.True(t, strings.HasSuffix(rw.Header().Get("Location"), "/Users/"+created["id"].(string)))
+	_, err = upstream.Repository(shared.UserResourceType).Get(created["id"].(string), "", context.Background())
+	assert.Nil(t, err)
+	_, err = forwarding.Repository(shared.UserResourceType).Get(created["id"].(string), "", context.Background())
+	assert.NotNil(t, err)
+
+	// authorized before they leave the mirror
+	cfg.Auth.Policies = []Policy{{Principals: []string{"*"}, ResourceType: "*", Readable: []string{"*"}, Writable: []string{"userName"}}}
+	guarded, err := Build(cfg)
+	require.Nil(t, err)
+	defer guarded.Close()
+	count := func() int {
+		n, err := upstream.Repository(shared.UserResourceType).Count("id pr", context.Background())
+		require.Nil(t, err)
+		return n
+	}
+	before := count()
+	rw = scimtest.Serve(t, guarded.Handler(), http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"alice","nickName":"al"}`, nil)
+	assert.Equal(t, http.StatusForbidden, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, guarded.Handler(), http.MethodPatch, "/v2/Users/"+created["id"].(string), `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [{"op": "replace", "path": "nickName", "value": "al"}]
+	}`, nil)
+	assert.Equal(t, http.StatusForbidden, rw.Code, rw.Body.String())
+	assert.Equal(t, before, count())
+
+	// upstream responses are read up to a limit
+	cfg.Auth.Policies = nil
+	cfg.Mirror.MaxResponseBytes = 16
+	limited, err := Build(cfg)
+	require.Nil(t, err)
+	defer limited.Close()
+	rw = scimtest.Serve(t, limited.Handler(), http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"bob"}`, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code, rw.Body.String())
+
+	ts.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil).Code)
+}
+
+func TestBuildExtension(t *testing.T) {
+	const acmeUrn = "urn:example:params:scim:schemas:extension:acme:2.0:Group"
+	cfg := testConfig()
+	cfg.Schemas.Extensions = []Extension{{ResourceType: shared.GroupResourceType, Schema: "../resources/tests/group_extension.json"}}
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group", "`+acmeUrn+`"],
+		"displayName": "admins",
+		"`+acmeUrn+`": {"costCenter": "4130", "provisioningSource": "okta"}
+	}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	group := scimtest.Decode(t, rw)
+	assert.Equal(t, map[string]interface{}{"costCenter": "4130", "provisioningSource": "okta"}, group[acmeUrn])
+	id := group["id"].(string)
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group", "`+acmeUrn+`"],
+		"displayName": "others",
+		"`+acmeUrn+`": {"costCenter": 4130}
+	}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Groups/"+id, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "replace", "path": "`+acmeUrn+`:description", "value": "Administrators"},
+			{"op": "add", "path": "`+acmeUrn+`:owners", "value": [{"value": "2819c223"}]}
+		]
+	}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	group = scimtest.Decode(t, rw)
+	assert.Equal(t, "Administrators", group[acmeUrn].(map[string]interface{})["description"])
+	assert.Len(t, group[acmeUrn].(map[string]interface{})["owners"], 1)
+
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Schemas/"+acmeUrn, nil, nil).Code)
+	rt, err := server.Repository(shared.ResourceTypeResourceType).Get(shared.GroupResourceType, "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"schema": acmeUrn, "required": false}}, rt.GetData()["schemaExtensions"])
+}
+
+func TestBuildInvalid(t *testing.T) {
+	for _, mutate := range []func(cfg *Config){
+		func(cfg *Config) { cfg.BaseURL = "/v2" },
+		func(cfg *Config) { cfg.LogLevel = "verbose" },
+		func(cfg *Config) { cfg.Features.ETag = "strong" },
+		func(cfg *Config) { cfg.Repository.Kind = "postgres" },
+		func(cfg *Config) { cfg.Schemas.User = "missing.json" },
+		func(cfg *Config) { cfg.Auth.Tokens = []Token{{Token: "secret"}} },
+		func(cfg *Config) { cfg.Defaults = map[string]map[string]interface{}{"Device": {"active": true}} },
+		func(cfg *Config) {
+			cfg.Schemas.Extensions = []Extension{{ResourceType: "Device", Schema: "../resources/tests/group_extension.json"}}
+		},
+		func(cfg *Config) { cfg.Defaults = map[string]map[string]interface{}{"User": {"unknown": true}} },
+	} {
+		cfg := testConfig()
+		mutate(cfg)
+		_, err := Build(cfg)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})
+	created := make([]string, 0)
+	hooks := shared.NewHooks().AfterCreate(shared.UserResourceType, func(resource *shared.Resource, ctx context.Context) error {
+		created = append(created, resource.GetId())
+		return nil
+	})
+	n := 0
+	cfg := testConfig()
+	server, err := NewServer(
+		WithConfig(cfg),
+		WithSchema(shared.UserResourceType, sch),
+		WithRepository(shared.UserResourceType, users),
+		WithIdGenerator(func() string {
+			n++
+			return fmt.Sprintf("user-%d", n)
+		}),
+		WithHooks(hooks),
+		WithCompatibilityMode("*"),
+	)
+	require.Nil(t, err)
+	defer server.Close()
+	assert.Empty(t, cfg.Protocol.LenientClients)
+
+	// active sent as a string is accepted in compatibility mode
+	rw := scimtest.Serve(t, server.Handler(), http.MethodPost, "/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "david",
+		"active": "true"
+	}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+
+	assert.Equal(t, []string{"user-1"}, created)
+	stored, err := users.Get("user-1", "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, true, stored.GetData()["active"])
+
+	_, err = NewServer(WithConfig(testConfig()), WithRepository("Device", users))
+	assert.NotNil(t, err)
+}
+
+func TestBuildMembershipCycles(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.MembershipCycles = true
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	create := func(displayName, members string) string {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"], "displayName": "`+displayName+`", "members": [`+members+`]}`, nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)["id"].(string)
+	}
+
+	a := create("a", "")
+	b := create("b", `{"value": "`+a+`", "type": "Group"}`)
+
+	rw := scimtest.Serve(t, handler, http.MethodPut, "/v2/Groups/"+a, `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "a",
+		"members": [{"value": "`+a+`", "type": "Group"}]
+	}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Groups/"+b, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "displayName", "value": "bee"}]
+	}`, nil)
+	assert.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Groups/"+a, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "add", "path": "members", "value": [{"value": "`+b+`", "type": "Group"}]}]
+	}`, nil)
+	require.Equal(t, http.StatusBadRequest, rw.Code)
+	body := scimtest.Decode(t, rw)
+	assert.Equal(t, "invalidValue", body["scimType"])
+	assert.Equal(t, "Group membership cycle "+a+" -> "+b+" -> "+a, body["detail"])
+}
+
+func TestBuildMessages(t *testing.T) {
+	cfg := testConfig()
+	cfg.Protocol.Messages = "../resources/messages/messages.json"
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	user := `{"schemas": ["` + shared.UserUrn + `"], "userName": "bjensen", "shoeSize": 42}`
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, map[string]string{"Accept-Language": "de-AT, en;q=0.5"})
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "de", rw.Header().Get("Content-Language"))
+	body := scimtest.Decode(t, rw)
+	assert.Equal(t, "Das Attribut 'shoeSize' ist im Schema nicht definiert", body["detail"])
+	assert.Equal(t, "invalidValue", body["scimType"])
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, map[string]string{"Accept-Language": "it, en"})
+	assert.Empty(t, rw.Header().Get("Content-Language"))
+	assert.Equal(t, "Attribute 'shoeSize' is not defined by the schema", scimtest.Decode(t, rw)["detail"])
+
+	cfg.Protocol.Messages = "../resources/messages/missing.json"
+	_, err = Build(cfg)
+	assert.NotNil(t, err)
+}
+
+func TestBuildRequestId(t *testing.T) {
+	server, err := Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "bjensen", "shoeSize": 42}`, map[string]string{"X-Request-Id": "client-42"})
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "client-42", rw.Header().Get("X-Request-Id"))
+	body := scimtest.Decode(t, rw)
+	assert.Equal(t, "client-42", body["requestId"])
+	assert.Equal(t, "invalidValue", body["scimType"])
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "bjensen"}`, nil)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Len(t, rw.Header().Get("X-Request-Id"), 36)
+	assert.Nil(t, scimtest.Decode(t, rw)["requestId"])
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/missing", nil, map[string]string{"X-Request-Id": `bad "id"`})
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	assert.NotEqual(t, `bad "id"`, rw.Header().Get("X-Request-Id"))
+	assert.Equal(t, rw.Header().Get("X-Request-Id"), scimtest.Decode(t, rw)["requestId"])
+}
+
+func TestBuildETag(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.ETag = shared.ETagOff
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "bjensen"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.Empty(t, rw.Header().Get("ETag"))
+	created := scimtest.Decode(t, rw)
+	assert.NotEmpty(t, created["meta"].(map[string]interface{})["version"], "versions are kept")
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+created["id"].(string), nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Empty(t, rw.Header().Get("ETag"))
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Bulk", `{"schemas": ["`+shared.BulkRequestUrn+`"], "Operations": [
+		{"method": "POST", "path": "/Users", "bulkId": "a", "data": {"schemas": ["`+shared.UserUrn+`"], "userName": "alice"}}]}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Empty(t, scimtest.Decode(t, rw)["Operations"].([]interface{})[0].(map[string]interface{})["version"])
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/ServiceProviderConfig", nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, false, scimtest.Decode(t, rw)["etag"].(map[string]interface{})["supported"])
+
+	// the flag etag was before it took a mode
+	cfg = Default()
+	require.Nil(t, yaml.Unmarshal([]byte("features:\n  etag: true\n"), cfg))
+	etag, err := parseETag(cfg.Features.ETag)
+	require.Nil(t, err)
+	assert.Equal(t, shared.ETagOptional, etag)
+	etag, err = parseETag("false")
+	require.Nil(t, err)
+	assert.Equal(t, shared.ETagOff, etag)
+}
+
+func TestBuildProfileLabels(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	step := func(profileLabels bool) string {
+		users := &labelRecordingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})}
+		cfg := testConfig()
+		cfg.Features.ProfileLabels = profileLabels
+		server, err := NewServer(WithConfig(cfg), WithSchema(shared.UserResourceType, sch), WithRepository(shared.UserResourceType, users))
+		require.Nil(t, err)
+		defer server.Close()
+		rw := scimtest.Serve(t, server.Handler(), http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "bjensen"}`, nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		return users.step
+	}
+
+	// the labels of one server leave those of the others alone
+	assert.Equal(t, "repository.create", step(true))
+	assert.Empty(t, step(false))
+}
+
+// records the pprof step label creates run with
+type labelRecordingRepository struct {
+	shared.Repository
+	step string
+}
+
+func (r *labelRecordingRepository) Create(provider shared.DataProvider, ctx context.Context) error {
+	r.step, _ = pprof.Label(ctx, shared.ProfileLabelStep)
+	return r.Repository.Create(provider, ctx)
+}
+
+func TestBuildFilterLimits(t *testing.T) {
+	cfg := testConfig()
+	cfg.Protocol.FilterMaxDepth = 2
+	cfg.Protocol.FilterMaxClauses = 3
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`userName eq "a" or (active eq true and title pr)`), nil, nil)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`userName eq "a" or (active eq true and not (title pr))`), nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "invalidFilter", scimtest.Decode(t, rw)["scimType"])
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/.search", `{"schemas": ["`+shared.SearchUrn+`"], "filter": "id eq \"1\" or id eq \"2\" or id eq \"3\" or id eq \"4\""}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "invalidFilter", scimtest.Decode(t, rw)["scimType"])
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`password sw "a"`), nil, nil)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Equal(t, "Not allowed to filter on attribute at 'password'", scimtest.Decode(t, rw)["detail"])
+}
+
+func TestBuildShards(t *testing.T) {
+	cfg := testConfig()
+	cfg.Repository.Shards = 3
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	ids := make([]string, 0)
+	for i := 0; i < 12; i++ {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", fmt.Sprintf(`{"schemas": ["%s"], "userName": "user%02d"}`, shared.UserUrn, i), nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		ids = append(ids, scimtest.Decode(t, rw)["id"].(string))
+	}
+	for _, id := range ids {
+		assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+id, nil, nil).Code)
+	}
+
+	rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`userName pr`)+"&sortBy=userName&startIndex=4&count=5", nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	body := scimtest.Decode(t, rw)
+	assert.Equal(t, float64(12), body["totalResults"])
+	userNames := make([]string, 0)
+	for _, resource := range body["Resources"].([]interface{}) {
+		userNames = append(userNames, resource.(map[string]interface{})["userName"].(string))
+	}
+	assert.Equal(t, []string{"user03", "user04", "user05", "user06", "user07"}, userNames)
+}
+
+func TestServerShutdown(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	hooks := shared.NewHooks().BeforeCreate(shared.UserResourceType, func(resource *shared.Resource, ctx context.Context) error {
+		close(entered)
+		<-release
+		return nil
+	})
+	server, err := NewServer(WithConfig(testConfig()), WithHooks(hooks))
+	require.Nil(t, err)
+	handler := server.Handler()
+	user := `{"schemas": ["` + shared.UserUrn + `"], "userName": "david"}`
+
+	created := make(chan int)
+	go func() {
+		created <- scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil).Code
+	}()
+	<-entered
+
+	// a request in flight holds the shutdown up, new ones are turned away
+	shutdown := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	var rw *httptest.ResponseRecorder
+	for i := 0; i < 100; i++ {
+		if rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/readyz", nil, nil); rw.Code == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	assert.Equal(t, "close", rw.Header().Get("Connection"))
+	assert.Contains(t, rw.Body.String(), "shutting down")
+	select {
+	case <-shutdown:
+		t.Fatal("shut down with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, <-created)
+	assert.Nil(t, <-shutdown)
+
+	// gives up when the context is done first
+	entered, release = make(chan struct{}), make(chan struct{})
+	server, err = NewServer(WithConfig(testConfig()), WithHooks(hooks))
+	require.Nil(t, err)
+	handler = server.Handler()
+	go scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil)
+	<-entered
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, server.Shutdown(ctx))
+	close(release)
+}
+
+func TestBuildWebhooks(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		events   = make(map[string]shared.ChangeEvent)
+		failures = 1
+		block    = make(chan struct{})
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-block
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Header.Get("X-Webhook-Secret") != "s3cret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failures > 0 {
+			failures--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event shared.ChangeEvent
+		require.Nil(t, json.NewDecoder(req.Body).Decode(&event))
+		assert.Equal(t, event.EventId, req.Header.Get("X-Event-Id"))
+		// the relay may post an event once more on shutdown, receivers drop it by its id
+		events[event.EventId] = event
+	}))
+	defer receiver.Close()
+
+	cfg := testConfig()
+	cfg.Webhooks = []WebhookConfig{{URL: receiver.URL, Secret: "s3cret", Attributes: []string{"userName", "password", "displayName"}}}
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	handler := server.Handler()
+	for _, userName := range []string{"lost", "david"} {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "`+userName+`", "password": "t0ps3cret", "nickName": "d"}`, nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	}
+	// the writes did not wait for the receiver, which the relay posts to in the background
+	close(block)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failures == 0
+	}, 5*time.Second, time.Millisecond)
+
+	// the event the receiver failed to take is posted again on shutdown
+	require.Nil(t, server.Shutdown(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 2)
+	userNames := make([]string, 0)
+	for _, event := range events {
+		assert.Equal(t, shared.ChangeCreate, event.Op)
+		userNames = append(userNames, event.Document["userName"].(string))
+		assert.NotContains(t, event.Document, "password")
+		assert.NotContains(t, event.Document, "nickName")
+	}
+	assert.ElementsMatch(t, []string{"lost", "david"}, userNames)
+
+	cfg.Webhooks = []WebhookConfig{{URL: "/relative"}}
+	_, err = Build(cfg)
+	assert.NotNil(t, err)
+}
+
+func TestBuildDeltaQuery(t *testing.T) {
+	cfg := testConfig()
+	cfg.Repository.ChangeLog = time.Hour
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	create := func(userName string) string {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "`+userName+`"}`, nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)["id"].(string)
+	}
+	list := func(query string) map[string]interface{} {
+		rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?"+query, nil, nil)
+		require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)
+	}
+	delta := func(body map[string]interface{}) map[string]interface{} {
+		return body[shared.DeltaListResponseUrn].(map[string]interface{})
+	}
+
+	kept, deleted := create("kept"), create("deleted")
+	body := list("filter=" + url.QueryEscape(`userName pr`))
+	assert.Contains(t, body["schemas"], shared.DeltaListResponseUrn)
+	start := delta(body)["watermark"].(string)
+
+	added := create("added")
+	rw := scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+kept, `{"schemas": ["`+shared.PatchOpUrn+`"], "Operations": [{"op": "replace", "path": "nickName", "value": "k"}]}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	require.Equal(t, http.StatusNoContent, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+deleted, nil, nil).Code)
+
+	body = list("since=" + start + "&attributes=nickName")
+	assert.Equal(t, float64(2), body["totalResults"])
+	resources := body["Resources"].([]interface{})
+	require.Len(t, resources, 2)
+	assert.Equal(t, added, resources[0].(map[string]interface{})["id"])
+	assert.Equal(t, "k", resources[1].(map[string]interface{})["nickName"])
+	tombstones := delta(body)["deleted"].([]interface{})
+	require.Len(t, tombstones, 1)
+	assert.Equal(t, deleted, tombstones[0].(map[string]interface{})["id"])
+	latest := delta(body)["watermark"].(string)
+
+	// pages of a single change each
+	body = list("since=" + start + "&count=1")
+	assert.Len(t, body["Resources"].([]interface{}), 1)
+	assert.NotEqual(t, latest, delta(body)["watermark"])
+
+	body = list("since=" + latest)
+	assert.Empty(t, body["Resources"])
+	assert.Empty(t, delta(body)["deleted"])
+	assert.Equal(t, latest, delta(body)["watermark"])
+
+	assert.Equal(t, http.StatusBadRequest, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?since=bogus", nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?since="+latest+"&filter="+url.QueryEscape(`userName pr`), nil, nil).Code)
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Groups?since="+latest, nil, nil).Code)
+
+	server, err = Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	assert.Equal(t, http.StatusNotImplemented, scimtest.Serve(t, server.Handler(), http.MethodGet, "/v2/Users?since="+latest, nil, nil).Code)
+}
+
+func TestBuildRoles(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.Roles = true
+	server, err := Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Roles", `{"schemas": ["`+shared.RoleUrn+`"], "value": "admin", "displayName": "Administrator"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	role := scimtest.Decode(t, rw)
+	assert.Equal(t, "http://localhost:8080/v2/Roles/"+role["id"].(string), rw.Header().Get("Location"))
+	assert.Equal(t, http.StatusConflict, scimtest.Serve(t, handler, http.MethodPost, "/v2/Roles", `{"schemas": ["`+shared.RoleUrn+`"], "value": "admin", "displayName": "Admin"}`, nil).Code)
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Entitlements", `{"schemas": ["`+shared.EntitlementUrn+`"], "value": "vpn", "displayName": "VPN access"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Roles?filter="+url.QueryEscape(`value eq "admin"`), nil, nil)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), `"totalResults":1`)
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Roles/"+role["id"].(string), `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "description", "value": "Full access"}]
+	}`, nil)
+	assert.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+
+	// both are discoverable, their schemas without the common attributes
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/ResourceTypes", nil, nil)
+	assert.Contains(t, rw.Body.String(), `"endpoint":"/Roles"`)
+	assert.Contains(t, rw.Body.String(), `"endpoint":"/Entitlements"`)
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Schemas/"+shared.EntitlementUrn, nil, nil)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), `"name":"value"`)
+	assert.NotContains(t, rw.Body.String(), `"name":"meta"`)
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "bjensen",
+		"roles": [{"value": "admin"}],
+		"entitlements": [{"value": "vpn"}]
+	}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	user := scimtest.Decode(t, rw)
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "mary",
+		"roles": [{"value": "auditor"}]
+	}`, nil)
+	require.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "invalidValue", scimtest.Decode(t, rw)["scimType"])
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+user["id"].(string), `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "add", "path": "entitlements", "value": [{"value": "wifi"}]}]
+	}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+
+	// an assigned role keeps its value and is not deleted, its holders would fail validation from then on
+	rw = scimtest.Serve(t, handler, http.MethodDelete, "/v2/Roles/"+role["id"].(string), nil, nil)
+	assert.Equal(t, http.StatusConflict, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Roles/"+role["id"].(string), `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "value", "value": "administrator"}]
+	}`, nil)
+	assert.Equal(t, http.StatusConflict, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+user["id"].(string), `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "remove", "path": "roles"}]
+	}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, http.StatusNoContent, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Roles/"+role["id"].(string), nil, nil).Code)
+	assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+user["id"].(string), nil, nil).Code)
+
+	server, err = Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler = server.Handler()
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, "/v2/Roles", nil, nil).Code)
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "mary",
+		"roles": [{"value": "auditor"}]
+	}`, nil)
+	assert.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+}
+
+// run with -race: the handlers share the server without synchronization once it is frozen
+func TestServerConcurrency(t *testing.T) {
+	server, err := Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	server.SetLogger(shared.NewTextLogger(ioutil.Discard, shared.LogError))
+	handler := server.Handler()
+
+	ids := make([]string, 0)
+	for i := 0; i < 8; i++ {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", fmt.Sprintf(`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "user%d"}`, i), nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		ids = append(ids, scimtest.Decode(t, rw)["id"].(string))
+	}
+
+	// the memory repository synchronizes itself, the requests write as well as read
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", fmt.Sprintf(`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "new%d"}`, i), nil)
+			assert.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+			rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+				"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+				"Operations": [{"op": "replace", "path": "nickName", "value": "nick"}]
+			}`, nil)
+			assert.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+			assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+id, nil, nil).Code)
+			assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`userName sw "user"`), nil, nil).Code)
+			assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/Schemas", nil, nil).Code)
+			assert.Equal(t, http.StatusOK, scimtest.Serve(t, handler, http.MethodGet, "/v2/ResourceTypes", nil, nil).Code)
+			if i%2 == 0 {
+				assert.Equal(t, http.StatusNoContent, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, nil).Code)
+			}
+			// registries synchronize themselves and may be added to while serving
+			server.Hooks().AfterCreate(shared.GroupResourceType, func(r *shared.Resource, ctx context.Context) error { return nil })
+			assert.NotNil(t, server.Schemas().Get(shared.UserUrn))
+		}(i, id)
+	}
+	wg.Wait()
+	rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`nickName eq "nick"`), nil, nil)
+	assert.Equal(t, float64(4), scimtest.Decode(t, rw)["totalResults"])
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`userName sw "new"`), nil, nil)
+	assert.Equal(t, float64(8), scimtest.Decode(t, rw)["totalResults"])
+
+	_, err = server.Schemas().RegisterJSON([]byte(`{"id": "urn:example:late", "attributes": [{"name": "a", "type": "string"}]}`))
+	assert.NotNil(t, err)
+	assert.Panics(t, func() { server.SetTracer(shared.NewNoOpTracer()) })
+}
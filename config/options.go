@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+)
+
+// Customizes a server built by NewServer. Options are applied in order, a later option of the same kind
+// replacing an earlier one, so that new options can be added without breaking embedders.
+type Option func(o *options)
+
+type options struct {
+	cfg            *Config
+	repositories   map[string]shared.Repository
+	schemas        map[string]*shared.Schema
+	idGenerator    func() string
+	logger         shared.Logger
+	hooks          *shared.Hooks
+	lenientClients []string
+}
+
+// Build the server from the configuration instead of Default()
+func WithConfig(cfg *Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// Store the resources of the resource type, User or Group, in the repository instead of opening the configured
+// one. The repository is decorated like the configured one, except for the filter cache and the retries,
+// which are MongoDB specific.
+func WithRepository(resourceType string, repo shared.Repository) Option {
+	return func(o *options) {
+		o.repositories[resourceType] = repo
+	}
+}
+
+// Validate resources of the resource type, User or Group, against the internal schema instead of the one of
+// the configured file, i.e. one returned by shared.ParseSchema. The configured extensions and unique
+// attributes are added to it.
+func WithSchema(resourceType string, sch *shared.Schema) Option {
+	return func(o *options) {
+		o.schemas[resourceType] = sch
+	}
+}
+
+// Assign ids to created resources from the generator instead of random UUIDs, see
+// shared.NewGeneratedIdAssignment
+func WithIdGenerator(generate func() string) Option {
+	return func(o *options) {
+		o.idGenerator = generate
+	}
+}
+
+// Log with the logger instead of writing text to standard output
+func WithLogger(logger shared.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Run the hooks, which may still be registered to afterwards, instead of none
+func WithHooks(hooks *shared.Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
+
+// Validate the requests of the clients leniently, '*' naming all of them, in addition to those of
+// protocol.lenientClients, see shared.WithLenientValidation
+func WithCompatibilityMode(clients ...string) Option {
+	return func(o *options) {
+		o.lenientClients = append(o.lenientClients, clients...)
+	}
+}
+
+// Load the schemas and resources, connect the repositories and wire everything else the configuration and
+// the options ask for. Operation workers are started when async is on; Close stops them.
+func NewServer(opts ...Option) (*Server, error) {
+	o := &options{
+		cfg:          Default(),
+		repositories: make(map[string]shared.Repository),
+		schemas:      make(map[string]*shared.Schema),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	for resourceType := range o.repositories {
+		if err := checkResourceType(resourceType); err != nil {
+			return nil, err
+		}
+	}
+	for resourceType := range o.schemas {
+		if err := checkResourceType(resourceType); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.lenientClients) > 0 {
+		// the configuration of the caller is left as it is
+		cfg := *o.cfg
+		cfg.Protocol.LenientClients = append(append([]string{}, cfg.Protocol.LenientClients...), o.lenientClients...)
+		o.cfg = &cfg
+	}
+	return build(o)
+}
+
+func checkResourceType(resourceType string) error {
+	if resourceType != shared.UserResourceType && resourceType != shared.GroupResourceType {
+		return fmt.Errorf("unknown resource type %q, expect %s or %s", resourceType, shared.UserResourceType, shared.GroupResourceType)
+	}
+	return nil
+}
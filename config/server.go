@@ -0,0 +1,1271 @@
+package config
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/compat"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/httpadapter"
+	"github.com/davidiamyou/go-scim/mongo"
+	"github.com/davidiamyou/go-scim/publish"
+	"github.com/davidiamyou/go-scim/shared"
+	"gopkg.in/mgo.v2"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A server wired from a Config, see NewServer. It implements handlers.ScimServer, so that embedders who need more
+// than the configuration offers can still wrap it or replace single parts of it.
+type Server struct {
+	cfg                 *Config
+	properties          mapPropertySource
+	logger              shared.Logger
+	metrics             *shared.Metrics
+	tracer              shared.Tracer
+	rateLimiter         shared.RateLimiter
+	accessController    shared.AccessController
+	operationQueue      shared.OperationQueue
+	operationStore      shared.OperationStore
+	exportStore         shared.ExportStore
+	groupDisplayWorkers *shared.WorkerPool
+	hooks               *shared.Hooks
+	transformers        *shared.Transformers
+	validators          *shared.Validators
+	messages            *shared.MessageCatalog
+	defaults            *shared.Defaults
+	pipelines           *handlers.Pipelines
+	idempotencyCache    shared.IdempotencyCache
+	journal             shared.Journal
+	changeLog           shared.ChangeLog
+	itemSchemas         map[string]string // the schema by resource type, see shared.ItemSchemas
+	attributeUsage      *shared.AttributeUsage
+	operationToggles    *shared.OperationToggles
+	baseURL             shared.BaseURLProvider
+	idAssignment        shared.ReadOnlyAssignment
+	userMetaAssignment  shared.ReadOnlyAssignment
+	groupMetaAssignment shared.ReadOnlyAssignment
+	groupAssignment     shared.ReadOnlyAssignment
+
+	schemas                 *shared.SchemaRegistry
+	rootSchema              *shared.Schema
+	userSchema, groupSchema *shared.Schema
+	extensions              []*shared.Schema // in the order of cfg.Schemas.Extensions
+	userRepo, groupRepo     shared.Repository
+	rootQueryRepo           shared.Repository
+	resourceTypeRepo        shared.Repository
+	spConfigRepo            shared.Repository
+	tokens                  map[string]Token
+	profiles                map[string]compat.Profile // by principal
+	stopWorkers             context.CancelFunc
+	workersDone             chan struct{}         // closed once the operation workers returned
+	relays                  []*shared.OutboxRelay // of the webhooks
+	stopRelays              context.CancelFunc
+	relaysDone              sync.WaitGroup // of the relays started
+	sweeps                  []sweep        // started once the server is built
+	stopSweeps              context.CancelFunc
+	drain                   *shared.Drain
+	forward                 handlers.EndpointHandler // the writes of a mirror with an upstream, see MirrorConfig
+	closers                 []io.Closer              // the repositories opened, see Shutdown
+	frozen                  int32                    // set by Freeze, atomically
+
+	// of the roles feature, nil unless it is on
+	roleSchema, entitlementSchema                 *shared.Schema
+	roleRepo, entitlementRepo                     shared.Repository
+	roleMetaAssignment, entitlementMetaAssignment shared.ReadOnlyAssignment
+}
+
+// Same as NewServer(WithConfig(cfg))
+func Build(cfg *Config) (*Server, error) {
+	return NewServer(WithConfig(cfg))
+}
+
+func build(o *options) (*Server, error) {
+	cfg := o.cfg
+	base, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil || len(base.Scheme) == 0 || len(base.Host) == 0 {
+		return nil, fmt.Errorf("invalid base URL %q, expect an absolute URL", cfg.BaseURL)
+	}
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := parseETag(cfg.Features.ETag)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Protocol.Locale) > 0 {
+		if err := shared.SetCollationLocale(cfg.Protocol.Locale); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Server{
+		cfg:          cfg,
+		properties:   properties(cfg, base.String(), etag),
+		logger:       shared.NewTextLogger(os.Stdout, level),
+		metrics:      shared.NewMetrics(nil),
+		tracer:       shared.NewNoOpTracer(),
+		rateLimiter:  shared.NewUnlimitedRateLimiter(),
+		hooks:        shared.NewHooks(),
+		transformers: shared.NewTransformers(),
+		validators:   shared.NewValidators(),
+		messages:     shared.NewMessageCatalog(),
+		defaults:     shared.NewDefaults(),
+		pipelines:    handlers.NewPipelines(),
+		// a replayed create within ten minutes returns the resource created first
+		idempotencyCache: shared.NewIdempotencyCache(10 * time.Minute),
+		idAssignment:     shared.NewIdAssignment(),
+		drain:            shared.NewDrain(),
+		tokens:           make(map[string]Token),
+		profiles:         make(map[string]compat.Profile),
+	}
+
+	if o.logger != nil {
+		s.logger = o.logger
+	}
+	if o.hooks != nil {
+		s.hooks = o.hooks
+	}
+	if o.idGenerator != nil {
+		s.idAssignment = shared.NewGeneratedIdAssignment(o.idGenerator)
+	}
+
+	if err := s.loadSchemas(o.schemas); err != nil {
+		return nil, err
+	}
+	if err := s.openRepositories(o.repositories); err != nil {
+		return nil, err
+	}
+	if err := s.loadResources(); err != nil {
+		return nil, err
+	}
+
+	if err := s.registerDefaults(); err != nil {
+		return nil, err
+	}
+	if err := s.registerValidators(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Protocol.Messages) > 0 {
+		if err := s.messages.LoadFile(cfg.Protocol.Messages); err != nil {
+			return nil, err
+		}
+	}
+
+	s.userMetaAssignment = shared.NewMetaAssignment(s.properties, shared.UserResourceType)
+	s.groupMetaAssignment = shared.NewMetaAssignment(s.properties, shared.GroupResourceType)
+	s.groupAssignment = shared.NewGroupAssignment(s.groupRepo)
+	s.roleMetaAssignment = shared.NewMetaAssignment(s.properties, shared.RoleResourceType)
+	s.entitlementMetaAssignment = shared.NewMetaAssignment(s.properties, shared.EntitlementResourceType)
+
+	if cfg.TrustForwarded {
+		if s.baseURL, err = shared.NewForwardedBaseURL(base.String()); err != nil {
+			return nil, err
+		}
+	} else {
+		s.baseURL = shared.NewStaticBaseURL(base.String())
+	}
+
+	if cfg.Mirror.Enabled && len(cfg.Mirror.Upstream) > 0 {
+		if upstream, err := url.Parse(cfg.Mirror.Upstream); err != nil || len(upstream.Scheme) == 0 || len(upstream.Host) == 0 {
+			return nil, fmt.Errorf("invalid mirror upstream %q, expect an absolute URL", cfg.Mirror.Upstream)
+		}
+		timeout := cfg.Mirror.Timeout
+		if timeout <= 0 {
+			timeout = handlers.DefaultForwardTimeout
+		}
+		s.forward = handlers.Forward(cfg.Mirror.Upstream, base.Path, cfg.Mirror.Token, &http.Client{Timeout: timeout}, int64(cfg.Mirror.MaxResponseBytes))
+	}
+
+	if cfg.Auth.RateLimit > 0 {
+		s.rateLimiter = shared.NewTokenBucketRateLimiter(cfg.Auth.RateLimit, cfg.Auth.RateBurst)
+	}
+	if len(cfg.Auth.Policies) > 0 {
+		policies := make([]shared.AccessPolicy, 0, len(cfg.Auth.Policies))
+		for _, p := range cfg.Auth.Policies {
+			policies = append(policies, shared.AccessPolicy(p))
+		}
+		s.accessController = shared.NewPolicyAccessController(policies...)
+	} else {
+		s.accessController = shared.NewUnrestrictedAccessController()
+	}
+	for _, t := range cfg.Auth.Tokens {
+		if len(t.Token) == 0 || len(t.Principal) == 0 {
+			return nil, fmt.Errorf("auth token of principal %q is incomplete", t.Principal)
+		}
+		s.tokens[t.Token] = t
+		if len(t.Profile) > 0 {
+			profile, ok := compat.Lookup(t.Profile)
+			if !ok {
+				return nil, fmt.Errorf("unknown profile %q of the auth token of principal %q", t.Profile, t.Principal)
+			}
+			s.profiles[t.Principal] = profile
+		}
+	}
+
+	if cfg.Features.Journal {
+		s.journal = shared.NewMemoryJournal(24 * time.Hour)
+		s.sweeps = append(s.sweeps, sweep{"journal", 24 * time.Hour, func(now time.Time) error {
+			return s.journal.Expire(now.Add(-24 * time.Hour))
+		}})
+	}
+	if cfg.Features.AttributeUsage {
+		s.attributeUsage = shared.NewAttributeUsage()
+	}
+	s.operationToggles = shared.NewOperationToggles()
+	for resourceType, operations := range cfg.Features.Disabled {
+		if !s.servesResourceType(resourceType) {
+			return nil, fmt.Errorf("operations disabled of unknown resource type %q", resourceType)
+		}
+		for _, op := range operations {
+			switch op {
+			case "get", "create", "replace", "patch", "delete", "query":
+			default:
+				return nil, fmt.Errorf("unknown operation %q disabled of %s", op, resourceType)
+			}
+		}
+		s.operationToggles.Disable(resourceType, operations...)
+	}
+	if s.readOnly() {
+		for _, resourceType := range []string{shared.UserResourceType, shared.GroupResourceType, shared.RoleResourceType, shared.EntitlementResourceType} {
+			s.operationToggles.Disable(resourceType, "create", "replace", "patch", "delete")
+		}
+	}
+	if cfg.Features.MembershipCycles {
+		// groups are then patched through the pipeline, not in place, see shared.MemberPatcher
+		for _, op := range []string{handlers.ReplaceOperation, handlers.PatchOperation} {
+			s.pipelines.Set(shared.GroupResourceType, op, handlers.DefaultPipeline(op).
+				InsertBefore(handlers.StageValidateUniqueness, handlers.DetectMembershipCycleStage))
+		}
+	}
+	if cfg.Features.Roles {
+		for _, op := range []string{handlers.CreateOperation, handlers.ReplaceOperation, handlers.PatchOperation} {
+			s.pipelines.Set(shared.UserResourceType, op, handlers.DefaultPipeline(op).
+				InsertBefore(handlers.StageValidateUniqueness, handlers.ValidateAssignmentsStage))
+		}
+		for _, resourceType := range []string{shared.RoleResourceType, shared.EntitlementResourceType} {
+			for _, op := range []string{handlers.ReplaceOperation, handlers.PatchOperation} {
+				s.pipelines.Set(resourceType, op, handlers.DefaultPipeline(op).
+					InsertBefore(handlers.StageValidateUniqueness, handlers.ValidateUnassignedStage))
+			}
+		}
+	}
+	s.groupDisplayWorkers = shared.NewWorkerPool(cfg.Protocol.GroupDisplayWorkers)
+	if cfg.Features.Export {
+		s.exportStore = shared.NewMemoryExportStore()
+		if len(cfg.Features.ExportDir) > 0 {
+			s.exportStore = shared.NewFileExportStore(cfg.Features.ExportDir)
+		}
+		retention := cfg.Features.ExportRetention
+		if retention <= 0 {
+			retention = 24 * time.Hour
+		}
+		s.sweeps = append(s.sweeps, sweep{"exports", retention, func(now time.Time) error {
+			return s.exportStore.Expire(now.Add(-retention))
+		}})
+	}
+	if cfg.Features.Async {
+		s.operationQueue = shared.NewChannelOperationQueue(100)
+		s.operationStore = shared.NewMapOperationStore()
+		workers := &shared.OperationWorkers{
+			Size:       4,
+			Queue:      s.operationQueue,
+			Store:      s.operationStore,
+			Repository: s.Repository,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopWorkers, s.workersDone = cancel, make(chan struct{})
+		go func() {
+			defer close(s.workersDone)
+			workers.Run(ctx)
+		}()
+	}
+	if len(s.relays) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopRelays = cancel
+		for _, relay := range s.relays {
+			s.relaysDone.Add(1)
+			go func(relay *shared.OutboxRelay) {
+				defer s.relaysDone.Done()
+				relay.Run(ctx)
+			}(relay)
+		}
+	}
+	if len(s.sweeps) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopSweeps = cancel
+		for _, each := range s.sweeps {
+			go each.run(ctx, serverLogger{s})
+		}
+	}
+	return s, nil
+}
+
+// Work done on a ticker while the server runs, i.e. deleting what expired after a retention, a tenth of the
+// retention apart
+type sweep struct {
+	name      string
+	retention time.Duration
+	do        func(now time.Time) error
+}
+
+func (w sweep) run(ctx context.Context, logger shared.Logger) {
+	interval := w.retention / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.do(now); err != nil {
+				logger.Warn("sweep failed", "sweep", w.name, "error", err.Error())
+			}
+		}
+	}
+}
+
+// Shut the server down without downtime for clients, i.e. on SIGTERM during a deploy, after the http.Server
+// stopped listening: requests that still arrive are answered with 503 and Connection: close, the requests in
+// flight and the work they started in the background, like exports and the propagation of group displays,
+// are waited for, then the operation workers apply what is queued, the events webhooks failed to take are
+// posted once more, and the MongoDB sessions are closed. Returns the error of the context when it is done
+// before, leaving the repositories open.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.drain.Wait(ctx); err != nil {
+		return err
+	}
+	if s.stopWorkers != nil {
+		s.stopWorkers()
+		select {
+		case <-s.workersDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.stopSweeps != nil {
+		s.stopSweeps()
+	}
+	if s.stopRelays != nil {
+		// the pass of a relay cancelled in flight is done once more
+		s.stopRelays()
+		s.relaysDone.Wait()
+		for _, relay := range s.relays {
+			for {
+				if n, err := relay.RelayOnce(ctx); err != nil || n == 0 {
+					break
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return s.closeRepositories()
+}
+
+// Stop the operation workers, the relays of webhooks and the sweeps, if any, and close the repositories without waiting
+// for the requests in flight
+func (s *Server) Close() {
+	if s.stopWorkers != nil {
+		s.stopWorkers()
+	}
+	if s.stopRelays != nil {
+		s.stopRelays()
+	}
+	if s.stopSweeps != nil {
+		s.stopSweeps()
+	}
+	s.closeRepositories()
+}
+
+func (s *Server) closeRepositories() (err error) {
+	for _, closer := range s.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	s.closers = nil
+	return
+}
+
+// Replace the logger, which writes text to standard output by default. Panics once the server is frozen.
+func (s *Server) SetLogger(logger shared.Logger) {
+	s.checkNotFrozen("SetLogger")
+	s.logger = logger
+}
+
+// Replace the metrics, which are discarded by default. Panics once the server is frozen.
+func (s *Server) SetMetrics(metrics *shared.Metrics) {
+	s.checkNotFrozen("SetMetrics")
+	s.metrics = metrics
+}
+
+// Replace the tracer, which is a no-op by default. Panics once the server is frozen.
+func (s *Server) SetTracer(tracer shared.Tracer) {
+	s.checkNotFrozen("SetTracer")
+	s.tracer = tracer
+}
+
+// Make the parts the handlers read without synchronization immutable, as the server is about to serve: the
+// schema registry stops accepting schemas and the setters panic. Repositories and schemas are only given
+// to NewServer, so they are fixed anyway. Handler freezes the server; the registries of hooks,
+// transformers, validators and pipelines may still be added to. Freezing twice is harmless.
+func (s *Server) Freeze() {
+	s.schemas.Freeze()
+	atomic.StoreInt32(&s.frozen, 1)
+}
+
+func (s *Server) checkNotFrozen(method string) {
+	if atomic.LoadInt32(&s.frozen) == 1 {
+		panic(method + " called after the server was frozen, call it before Handler")
+	}
+}
+
+// Returns the endpoints of the server below the path of the base URL. Requests to features turned off are
+// answered with 501. When tokens are configured, requests must carry one of them as bearer token, except for
+// the probes and the /Admin endpoints, which the admin token guards. Freezes the server.
+func (s *Server) Handler() http.Handler {
+	s.Freeze()
+	base, _ := url.Parse(strings.TrimSuffix(s.cfg.BaseURL, "/"))
+	opts := []httpadapter.Option{
+		httpadapter.WithPrefix(base.Path),
+		httpadapter.WithWrapper(s.wrap),
+	}
+	if s.cfg.Features.Roles {
+		opts = append(opts, httpadapter.WithRoles())
+	}
+	if s.cfg.Features.MembershipDelta {
+		opts = append(opts, httpadapter.WithMembershipDelta())
+	}
+	if len(s.cfg.Auth.AdminToken) > 0 {
+		opts = append(opts, httpadapter.WithAdmin(func(req *http.Request) bool {
+			return subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Admin-Token")), []byte(s.cfg.Auth.AdminToken)) == 1
+		}))
+	}
+	router := httpadapter.NewRouter(s, opts...)
+	if len(s.tokens) == 0 {
+		return s.countInFlight(router)
+	}
+	return s.countInFlight(s.authenticate(router, "/"+strings.Trim(base.Path, "/")))
+}
+
+// count the requests in flight for Shutdown, rejecting those arriving once it began, the probes included, so
+// that load balancers take the server out of rotation
+func (s *Server) countInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !s.drain.Enter() {
+			rw.Header().Set("Connection", "close")
+			rw.Header().Set("Retry-After", "1")
+			rw.Header().Set("Content-Type", "application/scim+json")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(rw, `{"schemas":["%s"],"status":"503","detail":"The server is shutting down, retry later"}`, shared.ErrorUrn)
+			return
+		}
+		defer s.drain.Leave()
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), shared.InFlight{}, s.drain)))
+	})
+}
+
+// whether the server serves resources of the type, users and groups always, roles and entitlements when the
+// roles feature is on
+func (s *Server) servesResourceType(resourceType string) bool {
+	switch resourceType {
+	case shared.UserResourceType, shared.GroupResourceType:
+		return true
+	case shared.RoleResourceType, shared.EntitlementResourceType:
+		return s.cfg.Features.Roles
+	}
+	return false
+}
+
+// whether the server is a mirror not accepting writes, see MirrorConfig
+func (s *Server) readOnly() bool {
+	return s.cfg.Mirror.Enabled && len(s.cfg.Mirror.Upstream) == 0
+}
+
+// reject requests to features turned off before anything else runs, and send writes to the upstream of a mirror,
+// authorized like local ones, see handlers.Forward. The endpoints of the members of a group, the managers of a
+// user and password changes are subject to the operation toggles of the resource they read or write.
+func (s *Server) wrap(handler handlers.EndpointHandler, requestType int) handlers.EndpointHandler {
+	var feature string
+	switch {
+	case requestType == shared.BulkOp && (!s.cfg.Features.Bulk || s.readOnly()):
+		feature = "bulk"
+	case (requestType == shared.PatchUser || requestType == shared.PatchGroup || requestType == shared.PatchRole ||
+		requestType == shared.PatchEntitlement) && !s.cfg.Features.Patch:
+		feature = "patch"
+	case requestType == shared.ChangeUserPassword && (!s.cfg.Features.ChangePassword || s.readOnly()):
+		feature = "changePassword"
+	default:
+		if _, op := shared.DescribeRequestType(requestType); s.forward != nil && writeOperations[op] {
+			handler = s.forward
+		}
+		if resourceType, op, ok := toggledOperation(requestType); ok {
+			next := handler
+			handler = func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+				handlers.ErrorCheck(server.OperationToggles().Check(resourceType, op))
+				return next(r, server, ctx)
+			}
+		}
+		adapters := []func(next handlers.EndpointHandler) handlers.EndpointHandler{
+			func(next handlers.EndpointHandler) handlers.EndpointHandler {
+				return compat.Wrap(next, requestType, s.profiles)
+			},
+		}
+		if s.cfg.Features.ProfileLabels {
+			adapters = append(adapters, labelProfiles)
+		}
+		return handlers.ChainWith(handler, requestType, adapters...)
+	}
+	return handlers.Chain(func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+		panic(shared.Error.NotImplemented(feature))
+	}, requestType)
+}
+
+// run the steps of the request with pprof labels, see shared.ProfileStep
+func labelProfiles(next handlers.EndpointHandler) handlers.EndpointHandler {
+	return func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+		return next(r, server, shared.WithProfileLabels(ctx))
+	}
+}
+
+// the toggled operation of the endpoints the handlers do not check the toggles of themselves
+func toggledOperation(requestType int) (resourceType, operation string, ok bool) {
+	switch requestType {
+	case shared.GetUserManagers:
+		return shared.UserResourceType, "get", true
+	case shared.GetGroupMembers:
+		return shared.GroupResourceType, "get", true
+	case shared.ChangeUserPassword:
+		return shared.UserResourceType, "patch", true
+	}
+	return "", "", false
+}
+
+// the operations a mirror forwards to its upstream
+var writeOperations = map[string]bool{
+	"create":         true,
+	"replace":        true,
+	"patch":          true,
+	"delete":         true,
+	"bulk":           true,
+	"changePassword": true,
+}
+
+// identify requests by their bearer token, setting the principal and scopes of the token
+func (s *Server) authenticate(next http.Handler, prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, prefix)
+		if path == "/healthz" || path == "/readyz" || strings.HasPrefix(path, "/Admin/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		auth := req.Header.Get("Authorization")
+		token, ok := s.tokens[strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))]
+		if !ok || !strings.HasPrefix(auth, "Bearer ") {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			rw.Header().Set("Content-Type", "application/scim+json")
+			rw.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(rw, `{"schemas":["%s"],"status":"401","detail":"Missing or invalid bearer token"}`, shared.ErrorUrn)
+			return
+		}
+		ctx := context.WithValue(req.Context(), shared.Principal{}, token.Principal)
+		ctx = context.WithValue(ctx, shared.Scopes{}, token.Scopes)
+		next.ServeHTTP(rw, req.WithContext(ctx))
+	})
+}
+
+// the internal schemas given take the place of those of the configured files
+func (s *Server) loadSchemas(given map[string]*shared.Schema) (err error) {
+	paths := s.cfg.Schemas
+	if s.rootSchema, _, err = shared.ParseSchema(paths.Root); err != nil {
+		return
+	}
+	if s.userSchema = given[shared.UserResourceType]; s.userSchema == nil {
+		if s.userSchema, _, err = shared.ParseSchema(paths.User); err != nil {
+			return
+		}
+	}
+	if s.groupSchema = given[shared.GroupResourceType]; s.groupSchema == nil {
+		if s.groupSchema, _, err = shared.ParseSchema(paths.Group); err != nil {
+			return
+		}
+	}
+	if s.cfg.Features.Roles {
+		if s.roleSchema, _, err = shared.ParseSchema(paths.Role); err != nil {
+			return
+		}
+		if s.entitlementSchema, _, err = shared.ParseSchema(paths.Entitlement); err != nil {
+			return
+		}
+	}
+	if len(paths.UniqueUserAttributes) > 0 {
+		if err = s.userSchema.DeclareUnique(paths.UniqueUserAttributes, false); err != nil {
+			return
+		}
+	}
+	s.schemas = shared.NewSchemaRegistry()
+	for _, path := range paths.Served {
+		if _, err = s.schemas.LoadFile(path); err != nil {
+			return
+		}
+	}
+	for _, internal := range []*shared.Schema{s.roleSchema, s.entitlementSchema} {
+		if internal == nil {
+			continue
+		}
+		if err = s.schemas.Register(publicSchema(internal)); err != nil {
+			return
+		}
+	}
+	for _, ext := range paths.Extensions {
+		var sch *shared.Schema
+		switch ext.ResourceType {
+		case shared.UserResourceType:
+			sch = s.userSchema
+		case shared.GroupResourceType:
+			sch = s.groupSchema
+		default:
+			return fmt.Errorf("extension %s of unknown resource type %q", ext.Schema, ext.ResourceType)
+		}
+		extension, err := s.schemas.LoadFile(ext.Schema)
+		if err != nil {
+			return err
+		}
+		if err := sch.AddExtension(extension, ext.Required); err != nil {
+			return err
+		}
+		s.extensions = append(s.extensions, extension)
+	}
+	if s.readOnly() {
+		// clients learn from the served schemas that nothing can be written
+		for _, sch := range s.schemas.All() {
+			markReadOnly(sch.Attributes)
+		}
+	}
+	return
+}
+
+// the schema clients are served of an internal one, without the attributes common to all resources
+func publicSchema(internal *shared.Schema) *shared.Schema {
+	public := &shared.Schema{Id: internal.Id, Name: internal.Name, Description: internal.Description}
+	for _, attr := range internal.Attributes {
+		switch attr.Name {
+		case "schemas", "id", "externalId", "meta":
+		default:
+			public.Attributes = append(public.Attributes, attr.Clone())
+		}
+	}
+	return public
+}
+
+func markReadOnly(attrs []*shared.Attribute) {
+	for _, attr := range attrs {
+		attr.Mutability = shared.ReadOnly
+		markReadOnly(attr.SubAttributes)
+	}
+}
+
+// the repositories given take the place of the configured ones, which are only opened when missing
+func (s *Server) openRepositories(given map[string]shared.Repository) (err error) {
+	rc := s.cfg.Repository
+	filterLog := make(map[string][]string)
+	if len(rc.FilterLog) > 0 {
+		var f *os.File
+		if f, err = os.Open(rc.FilterLog); err != nil {
+			return
+		}
+		defer f.Close()
+		if filterLog, err = shared.ReadFilterLog(f); err != nil {
+			return
+		}
+	}
+
+	var open func(resourceType, collection string, sch *shared.Schema) (shared.Repository, error)
+	// where the deletions of the resources of a collection are remembered, see repository.tombstones
+	openTombstones := func(collection string) (shared.TombstoneStore, error) {
+		return shared.NewMemoryTombstoneStore(), nil
+	}
+	switch rc.Kind {
+	case MongoRepository:
+		var filterCache *shared.FilterCache
+		if rc.FilterCacheSize > 0 {
+			filterCache = shared.NewFilterCache(rc.FilterCacheSize, s.metrics)
+		}
+		// both collections live in the same database, so they share the circuit breaker
+		var breaker *shared.CircuitBreaker
+		if rc.BreakerThreshold > 0 {
+			breaker = shared.NewCircuitBreaker(rc.BreakerThreshold, rc.BreakerCooldown)
+		}
+		policy := shared.RetryPolicy{MaxAttempts: rc.RetryAttempts, BaseDelay: rc.RetryBaseDelay, MaxDelay: rc.RetryMaxDelay, Classifier: mongo.IsTransient}
+		resourceConstructor := func(c shared.Complex) shared.DataProvider { return &shared.Resource{Complex: c} }
+		open = func(resourceType, collection string, sch *shared.Schema) (shared.Repository, error) {
+			repo, err := mongo.NewMongoRepositoryWithUrl(rc.URL, rc.Database, collection, sch, resourceConstructor)
+			if err != nil {
+				return nil, err
+			}
+			if closer, ok := repo.(io.Closer); ok {
+				s.closers = append(s.closers, closer)
+			}
+			if rc.EnsureIndexes {
+				ensurer, ok := repo.(shared.IndexEnsurer)
+				if !ok {
+					return nil, fmt.Errorf("repository of %s resources cannot ensure indexes, turn off ensureIndexes", resourceType)
+				}
+				filtered := shared.CountFilteredPaths(filterLog[resourceType], sch)
+				advice := shared.AdviseIndexes(sch, filtered, int64(rc.IndexMinFilters))
+				if err := ensurer.EnsureIndexes(advice, context.Background()); err != nil {
+					return nil, err
+				}
+			}
+			if user, ok := repo.(shared.FilterCacheUser); ok && filterCache != nil {
+				user.UseFilterCache(filterCache)
+			}
+			if rc.RetryAttempts > 1 || rc.BreakerThreshold > 0 {
+				return shared.NewRetryingRepository(repo, policy, breaker), nil
+			}
+			return repo, nil
+		}
+		openTombstones = func(collection string) (shared.TombstoneStore, error) {
+			session, err := mgo.Dial(rc.URL)
+			if err != nil {
+				return nil, err
+			}
+			store, err := mongo.NewTombstoneStore(session, rc.Database, collection+"_tombstones", rc.Tombstones)
+			if err != nil {
+				session.Close()
+				return nil, err
+			}
+			s.closers = append(s.closers, store.(io.Closer))
+			return store, nil
+		}
+	case MemoryRepository:
+		open = func(resourceType, collection string, sch *shared.Schema) (shared.Repository, error) {
+			repo := shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})
+			if rc.Compact {
+				return shared.NewCompactRepository(repo, sch), nil
+			}
+			return repo, nil
+		}
+	default:
+		return fmt.Errorf("unknown repository kind %q, expect %s or %s", rc.Kind, MongoRepository, MemoryRepository)
+	}
+	// users and groups spread over collections named after theirs with the number of the shard appended
+	openSharded := func(resourceType, collection string, sch *shared.Schema) (shared.Repository, error) {
+		if rc.Shards < 2 {
+			return open(resourceType, collection, sch)
+		}
+		shards := make(map[string]shared.Repository, rc.Shards)
+		for i := 0; i < rc.Shards; i++ {
+			name := collection + "_" + strconv.Itoa(i)
+			shard, err := open(resourceType, name, sch)
+			if err != nil {
+				return nil, err
+			}
+			shards[name] = shard
+		}
+		return shared.NewShardedRepository(sch, shards), nil
+	}
+	if s.userRepo = given[shared.UserResourceType]; s.userRepo == nil {
+		if s.userRepo, err = openSharded(shared.UserResourceType, rc.UserCollection, s.userSchema); err != nil {
+			return
+		}
+	}
+	// innermost, so that no decorator holds a password, see shared.NewPasswordHashingRepository
+	s.userRepo = shared.NewPasswordHashingRepository(s.userRepo)
+	if s.groupRepo = given[shared.GroupResourceType]; s.groupRepo == nil {
+		if s.groupRepo, err = openSharded(shared.GroupResourceType, rc.GroupCollection, s.groupSchema); err != nil {
+			return
+		}
+	}
+
+	if s.cfg.Features.Roles {
+		if s.roleRepo = given[shared.RoleResourceType]; s.roleRepo == nil {
+			if s.roleRepo, err = open(shared.RoleResourceType, rc.RoleCollection, s.roleSchema); err != nil {
+				return
+			}
+		}
+		if s.entitlementRepo = given[shared.EntitlementResourceType]; s.entitlementRepo == nil {
+			if s.entitlementRepo, err = open(shared.EntitlementResourceType, rc.EntitlementCollection, s.entitlementSchema); err != nil {
+				return
+			}
+		}
+		// seldom written, searched by every write of the roles or entitlements of a user
+		s.roleRepo = shared.NewInstrumentedRepository(s.roleRepo, shared.RoleResourceType, s.metrics)
+		s.entitlementRepo = shared.NewInstrumentedRepository(s.entitlementRepo, shared.EntitlementResourceType, s.metrics)
+	}
+
+	if rc.SearchTimeout > 0 {
+		s.userRepo = shared.NewSearchTimeoutRepository(s.userRepo, rc.SearchTimeout)
+		s.groupRepo = shared.NewSearchTimeoutRepository(s.groupRepo, rc.SearchTimeout)
+	}
+	s.userRepo = shared.NewInstrumentedRepository(s.userRepo, shared.UserResourceType, s.metrics)
+	s.groupRepo = shared.NewInstrumentedRepository(s.groupRepo, shared.GroupResourceType, s.metrics)
+	if rc.CacheSize > 0 {
+		s.userRepo = shared.NewCachingRepository(s.userRepo, shared.NewLRUResourceCache(rc.CacheSize, rc.CacheTTL), shared.UserResourceType, s.metrics)
+		s.groupRepo = shared.NewCachingRepository(s.groupRepo, shared.NewLRUResourceCache(rc.CacheSize, rc.CacheTTL), shared.GroupResourceType, s.metrics)
+	}
+	if rc.ChangeLog > 0 {
+		s.changeLog = shared.NewMemoryChangeLog(rc.ChangeLog, rc.ChangeLogSize)
+		s.sweeps = append(s.sweeps, sweep{"changeLog", rc.ChangeLog, func(now time.Time) error {
+			return s.changeLog.Expire(now.Add(-rc.ChangeLog))
+		}})
+		s.userRepo = shared.NewPublishingRepository(s.userRepo, shared.UserResourceType, s.userSchema, s.changeLog, nil, serverLogger{s})
+		s.groupRepo = shared.NewPublishingRepository(s.groupRepo, shared.GroupResourceType, s.groupSchema, s.changeLog, nil, serverLogger{s})
+	}
+	// every webhook is sent the events through its mask by its relay, in the background, so that a slow
+	// receiver does not hold up writes
+	schemas := map[string]*shared.Schema{shared.UserResourceType: s.userSchema, shared.GroupResourceType: s.groupSchema}
+	for _, webhook := range s.cfg.Webhooks {
+		if u, err := url.Parse(webhook.URL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("invalid webhook URL %q, expect an absolute URL", webhook.URL)
+		}
+		publisher := shared.NewMaskingPublisher(publish.NewWebhookPublisher(webhook.URL, webhook.Secret, nil), schemas,
+			shared.EventMask{Attributes: webhook.Attributes, ExcludedAttributes: webhook.ExcludedAttributes})
+		outbox := shared.NewMemoryOutbox(webhook.QueueSize)
+		s.relays = append(s.relays, &shared.OutboxRelay{Outbox: outbox, Publisher: publisher})
+		s.userRepo = shared.NewPublishingRepository(s.userRepo, shared.UserResourceType, s.userSchema, nil, outbox, serverLogger{s})
+		s.groupRepo = shared.NewPublishingRepository(s.groupRepo, shared.GroupResourceType, s.groupSchema, nil, outbox, serverLogger{s})
+	}
+	// outermost, so that the handlers find the DeletionReader
+	if rc.Tombstones > 0 {
+		var userTombstones, groupTombstones shared.TombstoneStore
+		if userTombstones, err = openTombstones(rc.UserCollection); err != nil {
+			return
+		}
+		if groupTombstones, err = openTombstones(rc.GroupCollection); err != nil {
+			return
+		}
+		s.userRepo = shared.NewTombstoneRepository(s.userRepo, userTombstones, rc.Tombstones)
+		s.groupRepo = shared.NewTombstoneRepository(s.groupRepo, groupTombstones, rc.Tombstones)
+		s.sweeps = append(s.sweeps, sweep{"tombstones", rc.Tombstones, func(now time.Time) error {
+			if err := userTombstones.Expire(now.Add(-rc.Tombstones)); err != nil {
+				return err
+			}
+			return groupTombstones.Expire(now.Add(-rc.Tombstones))
+		}})
+	}
+	s.rootQueryRepo = &rootQueryRepository{repos: []shared.Repository{s.userRepo, s.groupRepo}}
+	return
+}
+
+func (s *Server) loadResources() error {
+	resourceTypes := make(map[string]shared.DataProvider)
+	for _, path := range s.cfg.Schemas.ResourceTypes {
+		rt, _, err := shared.ParseResource(path)
+		if err != nil {
+			return err
+		}
+		resourceTypes[rt.GetId()] = rt
+	}
+	if s.cfg.Features.Roles {
+		base := strings.TrimSuffix(s.cfg.BaseURL, "/")
+		for resourceType, each := range map[string]struct{ endpoint, urn string }{
+			shared.RoleResourceType:        {"/Roles", shared.RoleUrn},
+			shared.EntitlementResourceType: {"/Entitlements", shared.EntitlementUrn},
+		} {
+			resourceTypes[resourceType] = &shared.Resource{Complex: shared.Complex{
+				"schemas":     []interface{}{shared.ResourceTypeUrn},
+				"id":          resourceType,
+				"name":        resourceType,
+				"endpoint":    each.endpoint,
+				"description": resourceType,
+				"schema":      each.urn,
+				"meta": map[string]interface{}{
+					"location":     base + "/ResourceTypes/" + resourceType,
+					"resourceType": shared.ResourceTypeResourceType,
+				},
+			}}
+		}
+	}
+	// list the configured extensions, unless the resource type file does already
+	for i, ext := range s.cfg.Schemas.Extensions {
+		rt, ok := resourceTypes[ext.ResourceType]
+		if !ok {
+			return fmt.Errorf("no resource type %s for extension %s", ext.ResourceType, ext.Schema)
+		}
+		extensions, _ := rt.GetData()["schemaExtensions"].([]interface{})
+		listed := false
+		for _, each := range extensions {
+			if m, ok := each.(map[string]interface{}); ok && m["schema"] == s.extensions[i].Id {
+				m["required"], listed = ext.Required, true
+			}
+		}
+		if !listed {
+			rt.GetData()["schemaExtensions"] = append(extensions, map[string]interface{}{
+				"schema":   s.extensions[i].Id,
+				"required": ext.Required,
+			})
+		}
+	}
+	s.resourceTypeRepo = shared.NewMapRepository(resourceTypes)
+	registered := make([]shared.DataProvider, 0, len(resourceTypes))
+	for _, rt := range resourceTypes {
+		registered = append(registered, rt)
+	}
+	s.itemSchemas = shared.ResourceTypeSchemas(registered...)
+
+	spConfig, _, err := shared.ParseResource(s.cfg.Schemas.SPConfig)
+	if err != nil {
+		return err
+	}
+	// advertise the features as configured, whatever the file says
+	features := s.cfg.Features
+	for name, supported := range map[string]bool{
+		"bulk":           features.Bulk && !s.readOnly(),
+		"patch":          features.Patch && !s.readOnly(),
+		"etag":           s.properties.GetString("scim.protocol.etag") != shared.ETagOff,
+		"changePassword": features.ChangePassword && !s.readOnly(),
+	} {
+		if feature, ok := spConfig.Complex[name].(map[string]interface{}); ok {
+			feature["supported"] = supported
+		} else {
+			spConfig.Complex[name] = map[string]interface{}{"supported": supported}
+		}
+	}
+	if max := s.cfg.Protocol.MaxResults; max > 0 {
+		if filter, ok := spConfig.Complex["filter"].(map[string]interface{}); ok {
+			filter["maxResults"] = max
+		}
+	}
+	s.spConfigRepo = shared.NewMapRepository(map[string]shared.DataProvider{"": spConfig})
+	return nil
+}
+
+// the configured defaults, whose values are brought to the types JSON decodes to, as yaml decodes integers to int
+func (s *Server) registerDefaults() error {
+	schemas := map[string]*shared.Schema{
+		shared.UserResourceType:  s.userSchema,
+		shared.GroupResourceType: s.groupSchema,
+	}
+	for resourceType, values := range s.cfg.Defaults {
+		sch, ok := schemas[resourceType]
+		if !ok {
+			return fmt.Errorf("defaults of unknown resource type %q", resourceType)
+		}
+		for path, value := range values {
+			if _, _, err := shared.CompilePath(path, sch); err != nil {
+				return fmt.Errorf("default of %s %s: %s", resourceType, path, err)
+			}
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("default of %s %s: %s", resourceType, path, err)
+			}
+			var normalized interface{}
+			if err := json.Unmarshal(raw, &normalized); err != nil {
+				return fmt.Errorf("default of %s %s: %s", resourceType, path, err)
+			}
+			s.defaults.Register(resourceType, path, shared.StaticDefault(normalized))
+		}
+	}
+	return nil
+}
+
+// the configured validators, checked against the schemas
+func (s *Server) registerValidators() error {
+	schemas := map[string]*shared.Schema{
+		shared.UserResourceType:  s.userSchema,
+		shared.GroupResourceType: s.groupSchema,
+	}
+	for resourceType, byPath := range s.cfg.Validators {
+		sch, ok := schemas[resourceType]
+		if !ok {
+			return fmt.Errorf("validators of unknown resource type %q", resourceType)
+		}
+		for path, vc := range byPath {
+			if _, _, err := shared.CompilePath(path, sch); err != nil {
+				return fmt.Errorf("validator of %s %s: %s", resourceType, path, err)
+			}
+			validators := make([]shared.ValueValidator, 0, 3)
+			if len(vc.Pattern) > 0 {
+				validator, err := shared.MatchPattern(vc.Pattern, vc.Description)
+				if err != nil {
+					return fmt.Errorf("validator of %s %s: %s", resourceType, path, err)
+				}
+				validators = append(validators, validator)
+			}
+			if vc.E164 {
+				validators = append(validators, shared.E164())
+			}
+			if len(vc.EmailDomains) > 0 {
+				validators = append(validators, shared.EmailDomains(vc.EmailDomains...))
+			}
+			if len(validators) == 0 {
+				return fmt.Errorf("validator of %s %s: expect a pattern, e164 or emailDomains", resourceType, path)
+			}
+			s.validators.Register(resourceType, path, validators...)
+		}
+	}
+	return nil
+}
+
+// Logs to the logger of the server at the time of the call, so that decorators built before SetLogger use
+// the one set
+type serverLogger struct{ s *Server }
+
+func (l serverLogger) Debug(msg string, keyvals ...interface{}) { l.s.logger.Debug(msg, keyvals...) }
+func (l serverLogger) Info(msg string, keyvals ...interface{})  { l.s.logger.Info(msg, keyvals...) }
+func (l serverLogger) Warn(msg string, keyvals ...interface{})  { l.s.logger.Warn(msg, keyvals...) }
+func (l serverLogger) Error(msg string, keyvals ...interface{}) { l.s.logger.Error(msg, keyvals...) }
+
+func (s *Server) Property() shared.PropertySource            { return s.properties }
+func (s *Server) Logger() shared.Logger                      { return s.logger }
+func (s *Server) Metrics() *shared.Metrics                   { return s.metrics }
+func (s *Server) Tracer() shared.Tracer                      { return s.tracer }
+func (s *Server) RateLimiter() shared.RateLimiter            { return s.rateLimiter }
+func (s *Server) AccessController() shared.AccessController  { return s.accessController }
+func (s *Server) OperationQueue() shared.OperationQueue      { return s.operationQueue }
+func (s *Server) OperationStore() shared.OperationStore      { return s.operationStore }
+func (s *Server) ExportStore() shared.ExportStore            { return s.exportStore }
+func (s *Server) GroupDisplayWorkers() *shared.WorkerPool    { return s.groupDisplayWorkers }
+func (s *Server) Hooks() *shared.Hooks                       { return s.hooks }
+func (s *Server) Transformers() *shared.Transformers         { return s.transformers }
+func (s *Server) Validators() *shared.Validators             { return s.validators }
+func (s *Server) Messages() *shared.MessageCatalog           { return s.messages }
+func (s *Server) Defaults() *shared.Defaults                 { return s.defaults }
+func (s *Server) Pipelines() *handlers.Pipelines             { return s.pipelines }
+func (s *Server) IdempotencyCache() shared.IdempotencyCache  { return s.idempotencyCache }
+func (s *Server) Journal() shared.Journal                    { return s.journal }
+func (s *Server) ChangeLog() shared.ChangeLog                { return s.changeLog }
+func (s *Server) AttributeUsage() *shared.AttributeUsage     { return s.attributeUsage }
+func (s *Server) OperationToggles() *shared.OperationToggles { return s.operationToggles }
+func (s *Server) BaseURL() shared.BaseURLProvider            { return s.baseURL }
+func (s *Server) WebRequest(r *http.Request) shared.WebRequest {
+	return httpadapter.NewWebRequest(r)
+}
+func (s *Server) Schemas() *shared.SchemaRegistry { return s.schemas }
+func (s *Server) InternalSchema(id string) *shared.Schema {
+	switch id {
+	case "":
+		return s.rootSchema
+	case shared.UserUrn:
+		return s.userSchema
+	case shared.GroupUrn:
+		return s.groupSchema
+	case shared.RoleUrn:
+		if s.roleSchema != nil {
+			return s.roleSchema
+		}
+	case shared.EntitlementUrn:
+		if s.entitlementSchema != nil {
+			return s.entitlementSchema
+		}
+	}
+	panic(shared.Error.Text("unknown schema id %s", id))
+}
+func (s *Server) CorrectCase(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.CorrectCase(subj, sch, ctx)
+}
+func (s *Server) CheckUnknownAttributes(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.CheckUnknownAttributes(subj, sch, s.cfg.Protocol.UnknownAttributes, ctx)
+}
+func (s *Server) ApplyReplacePolicy(subj *shared.Resource, ref *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ApplyReplacePolicy(subj, ref, sch, s.cfg.Protocol.Replace, ctx)
+}
+func (s *Server) ApplyPatch(patch shared.Patch, subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ApplyPatch(patch, subj, sch, ctx)
+}
+func (s *Server) ValidateType(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ValidateType(subj, sch, ctx)
+}
+func (s *Server) ValidateRequired(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ValidateRequired(subj, sch, ctx)
+}
+func (s *Server) ValidateMutability(subj *shared.Resource, ref *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ValidateMutability(subj, ref, sch, ctx)
+}
+func (s *Server) ValidateUniqueness(subj *shared.Resource, sch *shared.Schema, repo shared.Repository, ctx context.Context) error {
+	return shared.ValidateUniqueness(subj, sch, repo, []shared.Repository{s.userRepo, s.groupRepo}, ctx)
+}
+func (s *Server) ValidateUniquenessBatch(subjs []*shared.Resource, sch *shared.Schema, repo shared.Repository, ctx context.Context) []error {
+	return shared.ValidateUniquenessBatch(subjs, sch, repo, []shared.Repository{s.userRepo, s.groupRepo},
+		s.cfg.Repository.UniquenessBatch, s.cfg.Repository.UniquenessWorker, ctx)
+}
+func (s *Server) AssignReadOnlyValue(r *shared.Resource, ctx context.Context) (err error) {
+	requestType := ctx.Value(shared.RequestType{}).(int)
+	switch requestType {
+	case shared.CreateUser:
+		handlers.ErrorCheck(s.idAssignment.AssignValue(r, ctx))
+		handlers.ErrorCheck(s.userMetaAssignment.AssignValue(r, ctx))
+		handlers.ErrorCheck(s.groupAssignment.AssignValue(r, ctx))
+	case shared.ReplaceUser, shared.PatchUser, shared.ChangeUserPassword:
+		handlers.ErrorCheck(s.userMetaAssignment.AssignValue(r, ctx))
+		handlers.ErrorCheck(s.groupAssignment.AssignValue(r, ctx))
+	case shared.CreateGroup:
+		handlers.ErrorCheck(s.idAssignment.AssignValue(r, ctx))
+		handlers.ErrorCheck(s.groupMetaAssignment.AssignValue(r, ctx))
+	case shared.ReplaceGroup, shared.PatchGroup:
+		handlers.ErrorCheck(s.groupMetaAssignment.AssignValue(r, ctx))
+	case shared.CreateRole:
+		handlers.ErrorCheck(s.idAssignment.AssignValue(r, ctx))
+		handlers.ErrorCheck(s.roleMetaAssignment.AssignValue(r, ctx))
+	case shared.ReplaceRole, shared.PatchRole:
+		handlers.ErrorCheck(s.roleMetaAssignment.AssignValue(r, ctx))
+	case shared.CreateEntitlement:
+		handlers.ErrorCheck(s.idAssignment.AssignValue(r, ctx))
+		handlers.ErrorCheck(s.entitlementMetaAssignment.AssignValue(r, ctx))
+	case shared.ReplaceEntitlement, shared.PatchEntitlement:
+		handlers.ErrorCheck(s.entitlementMetaAssignment.AssignValue(r, ctx))
+	}
+	return
+}
+func (s *Server) MarshalJSON(v interface{}, sch *shared.Schema, attributes []string, excludedAttributes []string) ([]byte, error) {
+	options := make([]shared.MarshalOption, 0)
+	if s.cfg.Protocol.CanonicalJSON {
+		options = append(options, shared.CanonicalOrder())
+	}
+	if s.cfg.Protocol.ListItemSchemas {
+		options = append(options, shared.ItemSchemas(s.itemSchemas))
+	}
+	return shared.MarshalJSON(v, sch, attributes, excludedAttributes, options...)
+}
+func (s *Server) Repository(identifier string) shared.Repository {
+	switch identifier {
+	case "":
+		return s.rootQueryRepo
+	case shared.UserResourceType:
+		return s.userRepo
+	case shared.GroupResourceType:
+		return s.groupRepo
+	case shared.RoleResourceType:
+		if s.roleRepo != nil {
+			return s.roleRepo
+		}
+	case shared.EntitlementResourceType:
+		if s.entitlementRepo != nil {
+			return s.entitlementRepo
+		}
+	case shared.ResourceTypeResourceType:
+		return s.resourceTypeRepo
+	case shared.ServiceProviderConfigResourceType:
+		return s.spConfigRepo
+	}
+	panic(shared.Error.Text("no repo matches identifier %s", identifier))
+}
+
+// the properties the handlers and read only assignments read
+func properties(cfg *Config, base, etag string) mapPropertySource {
+	p := cfg.Protocol
+	return mapPropertySource{
+		"scim.resources.user.locationBase":      base + "/Users",
+		"scim.resources.group.locationBase":     base + "/Groups",
+		"scim.resources.operation.locationBase": base + "/Operations",
+		"scim.protocol.itemsPerPage":            p.ItemsPerPage,
+		"scim.protocol.uri.user":                "/Users",
+		"scim.protocol.uri.group":               "/Groups",
+		"scim.protocol.unknownAttributes":       p.UnknownAttributes,
+		"scim.protocol.replace":                 p.Replace,
+		"scim.protocol.duplicateCreate":         p.DuplicateCreate,
+		"scim.protocol.requestTimeout":          int(p.RequestTimeout / time.Second),
+		"scim.protocol.maxRequestBytes":         p.MaxRequestBytes,
+		"scim.protocol.lenientClients":          strings.Join(p.LenientClients, ","),
+		"scim.protocol.managerChainDepth":       p.ManagerChainDepth,
+		"scim.protocol.patchRetries":            p.PatchRetries,
+		"scim.protocol.etag":                    etag,
+		"scim.protocol.groupDisplaySyncLimit":   p.GroupDisplaySyncLimit,
+		"scim.protocol.filterMaxDepth":          p.FilterMaxDepth,
+		"scim.protocol.filterMaxClauses":        p.FilterMaxClauses,
+		"scim.auth.trustedProxies":              strings.Join(cfg.Auth.TrustedProxies, ","),
+
+		"scim.resources.role.locationBase":        base + "/Roles",
+		"scim.resources.entitlement.locationBase": base + "/Entitlements",
+		"scim.protocol.uri.role":                  "/Roles",
+		"scim.protocol.uri.entitlement":           "/Entitlements",
+	}
+}
+
+// the mode of the etag feature, which was a flag before required was added: true is read as optional, false as off
+func parseETag(etag string) (string, error) {
+	switch strings.ToLower(etag) {
+	case "", "true", shared.ETagOptional:
+		return shared.ETagOptional, nil
+	case "false", shared.ETagOff:
+		return shared.ETagOff, nil
+	case shared.ETagRequired:
+		return shared.ETagRequired, nil
+	default:
+		return "", fmt.Errorf("unknown etag mode %q, expect off, optional or required", etag)
+	}
+}
+
+func parseLogLevel(level string) (int, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return shared.LogDebug, nil
+	case "", "info":
+		return shared.LogInfo, nil
+	case "warn":
+		return shared.LogWarn, nil
+	case "error":
+		return shared.LogError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, expect debug, info, warn or error", level)
+	}
+}
+
+// map based property source, missing keys read as zero values
+type mapPropertySource map[string]interface{}
+
+func (ps mapPropertySource) Get(key string) interface{} { return ps[key] }
+func (ps mapPropertySource) GetString(key string) string {
+	s, _ := ps[key].(string)
+	return s
+}
+func (ps mapPropertySource) GetInt(key string) int {
+	i, _ := ps[key].(int)
+	return i
+}
+func (ps mapPropertySource) GetBool(key string) bool {
+	b, _ := ps[key].(bool)
+	return b
+}
+
+// searches users and groups together, for queries at the root endpoint
+type rootQueryRepository struct {
+	repos []shared.Repository
+}
+
+func (r *rootQueryRepository) Create(provider shared.DataProvider, ctx context.Context) error {
+	panic("not implemented")
+}
+func (r *rootQueryRepository) Get(id, version string, ctx context.Context) (shared.DataProvider, error) {
+	panic("not implemented")
+}
+func (r *rootQueryRepository) GetAll(ctx context.Context) ([]shared.Complex, error) {
+	panic("not implemented")
+}
+func (r *rootQueryRepository) Count(query string, ctx context.Context) (int, error) {
+	panic("not implemented")
+}
+func (r *rootQueryRepository) Update(id, version string, provider shared.DataProvider, ctx context.Context) error {
+	panic("not implemented")
+}
+func (r *rootQueryRepository) Delete(id, version string, ctx context.Context) error {
+	panic("not implemented")
+}
+func (r *rootQueryRepository) Ping(ctx context.Context) error {
+	for _, repo := range r.repos {
+		if err := repo.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (r *rootQueryRepository) Search(payload shared.SearchRequest, ctx context.Context) (*shared.ListResponse, error) {
+	return shared.CompositeSearchFunc(r.repos...)(payload, ctx)
+}
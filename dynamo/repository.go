@@ -0,0 +1,322 @@
+// Package dynamo stores users and groups in a single DynamoDB table, for serverless deployments that cannot
+// run MongoDB. Every resource is an item keyed by its resource type and id, holding the resource as JSON
+// along with the attributes the table is queried by:
+//
+//	pk             string, partition key of the table: resource type and id, i.e. 'User#2819c223'
+//	type           string, the resource type
+//	version        string, meta.version, compared by conditional writes
+//	data           string, the resource as JSON
+//	userNameKey    string, partition key of the userName-index global secondary index
+//	externalIdKey  string, partition key of the externalId-index global secondary index
+//
+// Filters comparing id, userName or externalId for equality, alone or as a term of an and, are answered
+// from the key or the indexes; all other filters scan the items of the resource type. Either way the whole
+// filter is evaluated on the candidates, which are then sorted and paged in memory.
+//
+// The repository talks to DynamoDB through the Table interface. An implementation on top of
+// github.com/aws/aws-sdk-go is only built with the dynamodb build tag:
+//
+//	go build -tags dynamodb ./...
+package dynamo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	. "github.com/davidiamyou/go-scim/shared"
+	"strings"
+)
+
+// Attributes of the items
+const (
+	KeyAttribute        = "pk"
+	TypeAttribute       = "type"
+	VersionAttribute    = "version"
+	DataAttribute       = "data"
+	UserNameAttribute   = "userNameKey"
+	ExternalIdAttribute = "externalIdKey"
+)
+
+// Global secondary indexes of the table, projecting all attributes
+const (
+	UserNameIndex   = "userName-index"
+	ExternalIdIndex = "externalId-index"
+)
+
+// An item of the table; all attributes the repository writes are strings
+type Item map[string]string
+
+// Conditions of a write, checked atomically by the table
+type Condition struct {
+	Exists    bool   // the item must exist
+	NotExists bool   // the item must not exist
+	Version   string // the version attribute of the item must equal it, unless empty
+}
+
+// Returned by Table writes whose condition does not hold
+var ErrConditionFailed = errors.New("dynamo: condition failed")
+
+// The operations the repository needs from a table
+type Table interface {
+	// the item with the key, nil when there is none
+	Get(key string, ctx context.Context) (Item, error)
+	// create or replace the item, returning ErrConditionFailed when the condition does not hold
+	Put(item Item, condition Condition, ctx context.Context) error
+	// delete the item with the key, returning ErrConditionFailed when the condition does not hold
+	Delete(key string, condition Condition, ctx context.Context) error
+	// all items whose attribute, the partition key of the index, equals the value
+	Query(index, attribute, value string, ctx context.Context) ([]Item, error)
+	// all items whose attribute equals the value
+	Scan(attribute, value string, ctx context.Context) ([]Item, error)
+	Ping(ctx context.Context) error
+}
+
+// Create the repository of a resource type over the table. Users and groups share the table.
+func NewRepository(table Table, sch *Schema, resourceType string) Repository {
+	return &repository{table: table, schema: sch, resourceType: resourceType}
+}
+
+type repository struct {
+	table        Table
+	schema       *Schema
+	resourceType string
+	filters      *FilterCache
+}
+
+func (r *repository) UseFilterCache(cache *FilterCache) {
+	r.filters = cache
+}
+
+// Fails with a DuplicateError when a resource with the id exists already. Uniqueness of other attributes is
+// left to ValidateUniqueness, as indexes of DynamoDB do not enforce it.
+func (r *repository) Create(provider DataProvider, ctx context.Context) error {
+	item, err := r.toItem(provider.GetData())
+	if err != nil {
+		return err
+	}
+	err = r.table.Put(item, Condition{NotExists: true}, ctx)
+	if err == ErrConditionFailed {
+		return Error.Duplicate("id", provider.GetId())
+	}
+	return err
+}
+
+func (r *repository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	item, err := r.table.Get(r.key(id), ctx)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil || (len(version) > 0 && item[VersionAttribute] != version) {
+		return nil, Error.ResourceNotFound(id, version)
+	}
+	return r.fromItem(item)
+}
+
+func (r *repository) GetAll(ctx context.Context) ([]Complex, error) {
+	items, err := r.table.Scan(TypeAttribute, r.resourceType, ctx)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]Complex, 0, len(items))
+	for _, item := range items {
+		dp, err := r.fromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, dp.GetData())
+	}
+	return all, nil
+}
+
+func (r *repository) Count(query string, ctx context.Context) (int, error) {
+	matches, err := r.candidates(query, ctx)
+	if err != nil {
+		return 0, err
+	}
+	return NewSearchableMapRepository(r.schema, matches).Count(query, ctx)
+}
+
+// The version, when given, is compared with the stored one by a conditional write, so that concurrent
+// updates of the same version fail with ResourceNotFoundError but one.
+func (r *repository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	item, err := r.toItem(provider.GetData())
+	if err != nil {
+		return err
+	}
+	item[KeyAttribute] = r.key(id)
+	err = r.table.Put(item, Condition{Exists: true, Version: version}, ctx)
+	if err == ErrConditionFailed {
+		return Error.ResourceNotFound(id, version)
+	}
+	return err
+}
+
+func (r *repository) Delete(id, version string, ctx context.Context) error {
+	err := r.table.Delete(r.key(id), Condition{Exists: true, Version: version}, ctx)
+	if err == ErrConditionFailed {
+		return Error.ResourceNotFound(id, version)
+	}
+	return err
+}
+
+func (r *repository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	matches, err := r.candidates(payload.Filter, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewSearchableMapRepository(r.schema, matches).Search(payload, ctx)
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	return r.table.Ping(ctx)
+}
+
+// the resources that may match the filter, by id: those with the key or index value of an equality term of
+// the filter, or all resources of the type
+func (r *repository) candidates(query string, ctx context.Context) (map[string]DataProvider, error) {
+	var items []Item
+	if len(strings.TrimSpace(query)) == 0 {
+		var err error
+		if items, err = r.table.Scan(TypeAttribute, r.resourceType, ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		root, err := r.filters.Compile(query, r.schema)
+		if err != nil {
+			return nil, err
+		}
+		if items, err = r.lookup(root, ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make(map[string]DataProvider, len(items))
+	for _, item := range items {
+		if item[TypeAttribute] != r.resourceType {
+			continue
+		}
+		dp, err := r.fromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		matches[dp.GetId()] = dp
+	}
+	return matches, nil
+}
+
+// the items the most selective equality term of the filter yields, scanning when it has none
+func (r *repository) lookup(root FilterNode, ctx context.Context) ([]Item, error) {
+	attr, value := r.equalityTerm(root)
+	switch {
+	case attr == nil:
+		return r.table.Scan(TypeAttribute, r.resourceType, ctx)
+	case attr.Assist.Path == "id":
+		item, err := r.table.Get(r.key(value), ctx)
+		if err != nil || item == nil {
+			return []Item{}, err
+		}
+		return []Item{item}, nil
+	case attr.Assist.Path == "userName":
+		return r.table.Query(UserNameIndex, UserNameAttribute, r.indexKey(attr, value), ctx)
+	default:
+		return r.table.Query(ExternalIdIndex, ExternalIdAttribute, r.indexKey(attr, value), ctx)
+	}
+}
+
+// an eq term on id, userName or externalId the filter cannot match without, preferring id and userName
+func (r *repository) equalityTerm(node FilterNode) (*Attribute, string) {
+	switch node.Data() {
+	case And:
+		leftAttr, leftValue := r.equalityTerm(node.Left())
+		rightAttr, rightValue := r.equalityTerm(node.Right())
+		if rank(rightAttr) < rank(leftAttr) {
+			return rightAttr, rightValue
+		}
+		return leftAttr, leftValue
+	case Eq:
+	default:
+		return nil, ""
+	}
+
+	if node.Left() == nil || node.Left().Type() != PathOperand || node.Right() == nil || node.Right().Type() != ConstantOperand {
+		return nil, ""
+	}
+	path := node.Left().Data().(Path)
+	if path.FilterRoot() != nil {
+		return nil, ""
+	}
+	attr := r.schema.GetAttribute(path, true)
+	value, ok := node.Right().Data().(string)
+	if attr == nil || !ok {
+		return nil, ""
+	}
+	switch attr.Assist.Path {
+	case "id", "userName", "externalId":
+		return attr, value
+	default:
+		return nil, ""
+	}
+}
+
+// how selective an equality term on the attribute is, lowest first
+func rank(attr *Attribute) int {
+	if attr == nil {
+		return 3
+	}
+	switch attr.Assist.Path {
+	case "id":
+		return 0
+	case "userName":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (r *repository) key(id string) string {
+	return r.resourceType + "#" + id
+}
+
+// the value of the index attribute, folding case when the SCIM attribute ignores it
+func (r *repository) indexKey(attr *Attribute, value string) string {
+	if !attr.CaseExact {
+		value = strings.ToLower(value)
+	}
+	return r.resourceType + "#" + value
+}
+
+func (r *repository) toItem(data Complex) (Item, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := data["id"].(string)
+	item := Item{
+		KeyAttribute:  r.key(id),
+		TypeAttribute: r.resourceType,
+		DataAttribute: string(raw),
+	}
+	if meta, ok := data["meta"].(map[string]interface{}); ok {
+		if version, ok := meta["version"].(string); ok {
+			item[VersionAttribute] = version
+		}
+	}
+	for path, attribute := range map[string]string{"userName": UserNameAttribute, "externalId": ExternalIdAttribute} {
+		value, ok := data[path].(string)
+		if !ok || len(value) == 0 {
+			continue
+		}
+		if _, attr, err := CompilePath(path, r.schema); err == nil {
+			item[attribute] = r.indexKey(attr, value)
+		}
+	}
+	return item, nil
+}
+
+func (r *repository) fromItem(item Item) (DataProvider, error) {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(item[DataAttribute]), &data); err != nil {
+		return nil, Error.Text("invalid resource stored at %s: %s", item[KeyAttribute], err.Error())
+	}
+	return &Resource{Complex: Complex(data)}, nil
+}
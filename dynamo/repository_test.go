@@ -0,0 +1,207 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	. "github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestRepository_CRUD(t *testing.T) {
+	table := newFakeTable()
+	users, groups := newTestRepositories(t, table)
+	ctx := context.Background()
+
+	require.Nil(t, users.Create(testUser("6", "Alice", "a-6"), ctx))
+	item := table.items["User#6"]
+	require.NotNil(t, item)
+	assert.Equal(t, UserResourceType, item[TypeAttribute])
+	assert.Equal(t, "v1", item[VersionAttribute])
+	assert.Equal(t, "User#alice", item[UserNameAttribute])
+	assert.Equal(t, "User#a-6", item[ExternalIdAttribute])
+	assert.IsType(t, &DuplicateError{}, users.Create(testUser("6", "bob", ""), ctx))
+
+	dp, err := users.Get("6", "v1", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "Alice", dp.GetData()["userName"])
+	_, err = users.Get("6", "v0", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+	// the same id of another resource type
+	_, err = groups.Get("6", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+
+	updated := testUser("6", "alice", "")
+	updated.Complex["meta"].(map[string]interface{})["version"] = "v2"
+	assert.IsType(t, &ResourceNotFoundError{}, users.Update("6", "v0", updated, ctx))
+	assert.IsType(t, &ResourceNotFoundError{}, users.Update("7", "", updated, ctx))
+	require.Nil(t, users.Update("6", "v1", updated, ctx))
+	assert.Equal(t, "v2", table.items["User#6"][VersionAttribute])
+	_, ok := table.items["User#6"][ExternalIdAttribute]
+	assert.False(t, ok)
+
+	assert.IsType(t, &ResourceNotFoundError{}, users.Delete("6", "v1", ctx))
+	require.Nil(t, users.Delete("6", "v2", ctx))
+	assert.Empty(t, table.items)
+	assert.IsType(t, &ResourceNotFoundError{}, users.Delete("6", "", ctx))
+}
+
+func TestRepository_Search(t *testing.T) {
+	table := newFakeTable()
+	users, groups := newTestRepositories(t, table)
+	ctx := context.Background()
+	for i, name := range []string{"dave", "Carol", "bob", "alice"} {
+		require.Nil(t, users.Create(testUser(fmt.Sprint(i), name, fmt.Sprint("ext-", i)), ctx))
+	}
+	require.Nil(t, groups.Create(&Resource{Complex: Complex{
+		"schemas":     []interface{}{GroupUrn},
+		"id":          "g1",
+		"displayName": "carol",
+	}}, ctx))
+
+	for _, test := range []struct {
+		filter string
+		ids    []string
+		access string
+	}{
+		{`userName eq "carol"`, []string{"1"}, "query " + UserNameIndex},
+		{`userName eq "carol" and active eq true`, []string{"1"}, "query " + UserNameIndex},
+		{`externalId eq "ext-2" and userName eq "bob"`, []string{"2"}, "query " + UserNameIndex},
+		{`externalId eq "ext-2"`, []string{"2"}, "query " + ExternalIdIndex},
+		{`externalId eq "EXT-2"`, []string{}, "query " + ExternalIdIndex},
+		{`id eq "3" and userName eq "bob"`, []string{}, "get"},
+		{`id eq "3"`, []string{"3"}, "get"},
+		{`userName eq "carol" or userName eq "bob"`, []string{"1", "2"}, "scan"},
+		{`userName sw "a"`, []string{"3"}, "scan"},
+		{`not (userName eq "dave")`, []string{"1", "2", "3"}, "scan"},
+	} {
+		table.accesses = nil
+		lr, err := users.Search(SearchRequest{Filter: test.filter, SortBy: "id", SortOrder: "ascending", StartIndex: 1, Count: 10}, ctx)
+		require.Nil(t, err, test.filter)
+		ids := make([]string, 0)
+		for _, dp := range lr.Resources {
+			ids = append(ids, dp.GetId())
+		}
+		assert.Equal(t, test.ids, ids, test.filter)
+		assert.Equal(t, []string{test.access}, table.accesses, test.filter)
+	}
+
+	n, err := users.Count(`userName pr`, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	lr, err := users.Search(SearchRequest{SortBy: "id", SortOrder: "descending", StartIndex: 2, Count: 2}, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 4, lr.TotalResults)
+	require.Len(t, lr.Resources, 2)
+	assert.Equal(t, "bob", lr.Resources[0].GetData()["userName"])
+	assert.Equal(t, "Carol", lr.Resources[1].GetData()["userName"])
+
+	all, err := groups.GetAll(ctx)
+	require.Nil(t, err)
+	assert.Len(t, all, 1)
+
+	_, err = users.Search(SearchRequest{Filter: `userName eq`}, ctx)
+	assert.NotNil(t, err)
+}
+
+func newTestRepositories(t *testing.T, table Table) (Repository, Repository) {
+	userSchema, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	groupSchema, _, err := ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+	return NewRepository(table, userSchema, UserResourceType), NewRepository(table, groupSchema, GroupResourceType)
+}
+
+func testUser(id, userName, externalId string) *Resource {
+	data := Complex{
+		"schemas":  []interface{}{UserUrn},
+		"id":       id,
+		"userName": userName,
+		"active":   true,
+		"meta":     map[string]interface{}{"resourceType": UserResourceType, "version": "v1"},
+	}
+	if len(externalId) > 0 {
+		data["externalId"] = externalId
+	}
+	return &Resource{Complex: data}
+}
+
+// an in memory table recording how it was read
+type fakeTable struct {
+	sync.Mutex
+	items    map[string]Item
+	accesses []string
+}
+
+func newFakeTable() *fakeTable {
+	return &fakeTable{items: make(map[string]Item)}
+}
+
+func (f *fakeTable) Get(key string, ctx context.Context) (Item, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.accesses = append(f.accesses, "get")
+	return f.items[key], nil
+}
+
+func (f *fakeTable) Put(item Item, condition Condition, ctx context.Context) error {
+	f.Lock()
+	defer f.Unlock()
+	if !f.holds(item[KeyAttribute], condition) {
+		return ErrConditionFailed
+	}
+	f.items[item[KeyAttribute]] = item
+	return nil
+}
+
+func (f *fakeTable) Delete(key string, condition Condition, ctx context.Context) error {
+	f.Lock()
+	defer f.Unlock()
+	if !f.holds(key, condition) {
+		return ErrConditionFailed
+	}
+	delete(f.items, key)
+	return nil
+}
+
+func (f *fakeTable) holds(key string, condition Condition) bool {
+	existing, ok := f.items[key]
+	switch {
+	case condition.Exists && !ok, condition.NotExists && ok:
+		return false
+	case len(condition.Version) > 0:
+		return existing[VersionAttribute] == condition.Version
+	}
+	return true
+}
+
+func (f *fakeTable) Query(index, attribute, value string, ctx context.Context) ([]Item, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.accesses = append(f.accesses, "query "+index)
+	return f.matching(attribute, value), nil
+}
+
+func (f *fakeTable) Scan(attribute, value string, ctx context.Context) ([]Item, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.accesses = append(f.accesses, "scan")
+	return f.matching(attribute, value), nil
+}
+
+func (f *fakeTable) matching(attribute, value string) []Item {
+	items := make([]Item, 0)
+	for _, item := range f.items {
+		if item[attribute] == value {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (f *fakeTable) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,151 @@
+//go:build dynamodb
+// +build dynamodb
+
+package dynamo
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strings"
+)
+
+// Adapt the table with the name, i.e. dynamodb.New(session.Must(session.NewSession())). Reads are strongly
+// consistent, except for those from indexes, which DynamoDB only offers eventually consistent.
+func NewTable(client dynamodbiface.DynamoDBAPI, name string) Table {
+	return &table{client: client, name: aws.String(name)}
+}
+
+type table struct {
+	client dynamodbiface.DynamoDBAPI
+	name   *string
+}
+
+func (t *table) Get(key string, ctx context.Context) (Item, error) {
+	out, err := t.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      t.name,
+		Key:            map[string]*dynamodb.AttributeValue{KeyAttribute: {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	return fromAttributeValues(out.Item), nil
+}
+
+func (t *table) Put(item Item, condition Condition, ctx context.Context) error {
+	in := &dynamodb.PutItemInput{
+		TableName: t.name,
+		Item:      toAttributeValues(item),
+	}
+	in.ConditionExpression, in.ExpressionAttributeNames, in.ExpressionAttributeValues = conditionExpression(condition)
+	_, err := t.client.PutItemWithContext(ctx, in)
+	return conditionFailed(err)
+}
+
+func (t *table) Delete(key string, condition Condition, ctx context.Context) error {
+	in := &dynamodb.DeleteItemInput{
+		TableName: t.name,
+		Key:       map[string]*dynamodb.AttributeValue{KeyAttribute: {S: aws.String(key)}},
+	}
+	in.ConditionExpression, in.ExpressionAttributeNames, in.ExpressionAttributeValues = conditionExpression(condition)
+	_, err := t.client.DeleteItemWithContext(ctx, in)
+	return conditionFailed(err)
+}
+
+func (t *table) Query(index, attribute, value string, ctx context.Context) ([]Item, error) {
+	items := make([]Item, 0)
+	in := &dynamodb.QueryInput{
+		TableName:                 t.name,
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    aws.String("#a = :v"),
+		ExpressionAttributeNames:  map[string]*string{"#a": aws.String(attribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": {S: aws.String(value)}},
+	}
+	err := t.client.QueryPagesWithContext(ctx, in, func(out *dynamodb.QueryOutput, last bool) bool {
+		for _, each := range out.Items {
+			items = append(items, fromAttributeValues(each))
+		}
+		return true
+	})
+	return items, err
+}
+
+func (t *table) Scan(attribute, value string, ctx context.Context) ([]Item, error) {
+	items := make([]Item, 0)
+	in := &dynamodb.ScanInput{
+		TableName:                 t.name,
+		FilterExpression:          aws.String("#a = :v"),
+		ExpressionAttributeNames:  map[string]*string{"#a": aws.String(attribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": {S: aws.String(value)}},
+		ConsistentRead:            aws.Bool(true),
+	}
+	err := t.client.ScanPagesWithContext(ctx, in, func(out *dynamodb.ScanOutput, last bool) bool {
+		for _, each := range out.Items {
+			items = append(items, fromAttributeValues(each))
+		}
+		return true
+	})
+	return items, err
+}
+
+func (t *table) Ping(ctx context.Context) error {
+	_, err := t.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: t.name})
+	return err
+}
+
+func conditionExpression(condition Condition) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	terms := make([]string, 0, 2)
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	if condition.Exists {
+		terms = append(terms, "attribute_exists(#k)")
+		names["#k"] = aws.String(KeyAttribute)
+	}
+	if condition.NotExists {
+		terms = append(terms, "attribute_not_exists(#k)")
+		names["#k"] = aws.String(KeyAttribute)
+	}
+	if len(condition.Version) > 0 {
+		terms = append(terms, "#v = :v")
+		names["#v"] = aws.String(VersionAttribute)
+		values[":v"] = &dynamodb.AttributeValue{S: aws.String(condition.Version)}
+	}
+	if len(terms) == 0 {
+		return nil, nil, nil
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+	return aws.String(strings.Join(terms, " AND ")), names, values
+}
+
+func conditionFailed(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrConditionFailed
+	}
+	return err
+}
+
+func toAttributeValues(item Item) map[string]*dynamodb.AttributeValue {
+	values := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		values[k] = &dynamodb.AttributeValue{S: aws.String(v)}
+	}
+	return values
+}
+
+func fromAttributeValues(values map[string]*dynamodb.AttributeValue) Item {
+	item := make(Item, len(values))
+	for k, v := range values {
+		if v != nil && v.S != nil {
+			item[k] = *v.S
+		}
+	}
+	return item
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"context"
-	"fmt"
 	web "github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/httpadapter"
 	"github.com/davidiamyou/go-scim/mongo"
 	scim "github.com/davidiamyou/go-scim/shared"
-	"github.com/go-zoo/bone"
-	"io/ioutil"
 	"net/http"
+	"os"
+	"time"
 )
 
 // setup everything
@@ -17,6 +17,10 @@ func initConfiguration() {
 		data: map[string]interface{}{
 			"scim.resources.user.locationBase":         "http://localhost:8080/v2/Users",
 			"scim.resources.group.locationBase":        "http://localhost:8080/v2/Groups",
+			"scim.resources.operation.locationBase":    "http://localhost:8080/v2/Operations",
+			"scim.protocol.baseUrl":                    "http://localhost:8080/v2",
+			"scim.protocol.canonicalJson":              false,
+			"scim.protocol.listItemSchemas":            false,
 			"scim.resources.schema.internalRoot.path":  "../resources/schemas/root_internal.json",
 			"scim.resources.schema.internalUser.path":  "../resources/schemas/user_internal.json",
 			"scim.resources.schema.internalGroup.path": "../resources/schemas/group_internal.json",
@@ -28,6 +32,23 @@ func initConfiguration() {
 			"scim.protocol.itemsPerPage":               10,
 			"scim.protocol.uri.user":                   "/Users",
 			"scim.protocol.uri.group":                  "/Groups",
+			"scim.protocol.unknownAttributes":          scim.RejectUnknownAttributes,
+			"scim.protocol.async":                      false,
+			"scim.protocol.lenientClients":             "",
+			"scim.admin.attributeUsage":                false,
+			"scim.protocol.replace":                    scim.StrictReplace,
+			"scim.protocol.requestTimeout":             30,
+			"scim.protocol.maxRequestBytes":            1 << 20,
+			"scim.protocol.duplicateCreate":            scim.ConflictOnDuplicate,
+			"scim.protocol.etag":                       scim.ETagOptional,
+			"scim.protocol.uniquenessBatchSize":        50,
+			"scim.protocol.uniquenessWorkers":          4,
+			"scim.protocol.managerChainDepth":          20,
+			"scim.protocol.patchRetries":               3,
+			"scim.protocol.groupDisplaySyncLimit":      100,
+			"scim.protocol.filterMaxDepth":             10,
+			"scim.protocol.filterMaxClauses":           100,
+			"scim.auth.trustedProxies":                 "",
 			"mongo.url":                                "mongodb://localhost:32768/scim_example?maxPoolSize=100",
 			"mongo.db":                                 "scim_example",
 			"mongo.collection.user":                    "users",
@@ -44,12 +65,13 @@ func initConfiguration() {
 	s, _, err = scim.ParseSchema(propertySource.GetString("scim.resources.schema.internalGroup.path"))
 	web.ErrorCheck(err)
 	groupSchemaInternal = s
-	s, _, err = scim.ParseSchema(propertySource.GetString("scim.resources.schema.user.path"))
+	// no two users may share an email address
+	web.ErrorCheck(userSchemaInternal.DeclareUnique([]string{"emails.value"}, false))
+	schemaRegistry = scim.NewSchemaRegistry()
+	_, err = schemaRegistry.LoadFile(propertySource.GetString("scim.resources.schema.user.path"))
 	web.ErrorCheck(err)
-	userSchema = s
-	s, _, err = scim.ParseSchema(propertySource.GetString("scim.resources.schema.group.path"))
+	_, err = schemaRegistry.LoadFile(propertySource.GetString("scim.resources.schema.group.path"))
 	web.ErrorCheck(err)
-	groupSchema = s
 
 	userResourceType, _, err := scim.ParseResource(propertySource.GetString("scim.resources.resourceType.user"))
 	web.ErrorCheck(err)
@@ -59,6 +81,9 @@ func initConfiguration() {
 	spConfig, _, err := scim.ParseResource(propertySource.GetString("scim.resources.spConfig"))
 	web.ErrorCheck(err)
 
+	// no registerer configured, metrics are discarded; plug in a prometheus adapter here
+	metrics := scim.NewMetrics(nil)
+
 	resourceConstructor := func(c scim.Complex) scim.DataProvider { return &scim.Resource{Complex: c} }
 	userRepo, err = mongo.NewMongoRepositoryWithUrl(
 		propertySource.GetString("mongo.url"),
@@ -74,6 +99,23 @@ func initConfiguration() {
 		groupSchemaInternal,
 		resourceConstructor)
 	web.ErrorCheck(err)
+	filterCache := scim.NewFilterCache(1000, metrics)
+	for _, repo := range []scim.Repository{userRepo, groupRepo} {
+		repo.(scim.FilterCacheUser).UseFilterCache(filterCache)
+	}
+	// both collections live in the same database, so they share the circuit breaker
+	retryPolicy := scim.RetryPolicy{MaxAttempts: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, Classifier: mongo.IsTransient}
+	breaker := scim.NewCircuitBreaker(5, 30*time.Second)
+	userRepo = scim.NewRetryingRepository(userRepo, retryPolicy, breaker)
+	groupRepo = scim.NewRetryingRepository(groupRepo, retryPolicy, breaker)
+	userRepo = scim.NewInstrumentedRepository(userRepo, scim.UserResourceType, metrics)
+	groupRepo = scim.NewInstrumentedRepository(groupRepo, scim.GroupResourceType, metrics)
+	// a single instance, so an in memory cache is enough; the ttl bounds how long writes made around it go unseen
+	userRepo = scim.NewCachingRepository(userRepo, scim.NewLRUResourceCache(1000, time.Minute), scim.UserResourceType, metrics)
+	groupRepo = scim.NewCachingRepository(groupRepo, scim.NewLRUResourceCache(1000, time.Minute), scim.GroupResourceType, metrics)
+	// deprovisioned users and groups are answered with 410 Gone for a week, or until a restart
+	userRepo = scim.NewTombstoneRepository(userRepo, scim.NewMemoryTombstoneStore(), 7*24*time.Hour)
+	groupRepo = scim.NewTombstoneRepository(groupRepo, scim.NewMemoryTombstoneStore(), 7*24*time.Hour)
 	rootQueryRepo = &mongoRootQueryRepository{
 		repos: []scim.Repository{
 			userRepo,
@@ -84,57 +126,85 @@ func initConfiguration() {
 		userResourceType.GetId():  userResourceType,
 		groupResourceType.GetId(): groupResourceType,
 	})
+	itemSchemas = scim.ResourceTypeSchemas(userResourceType, groupResourceType)
 	spConfigRepo = scim.NewMapRepository(map[string]scim.DataProvider{
 		"": spConfig,
 	})
 
+	// derive displayName for clients that only send the name parts
+	deriveDisplayName, err := scim.DeriveAttribute("displayName", "${name.givenName} ${name.familyName}")
+	web.ErrorCheck(err)
+	transformers := scim.NewTransformers().Register(scim.UserResourceType, deriveDisplayName)
+
+	// users are created active unless the client says otherwise
+	defaults := scim.NewDefaults().Register(scim.UserResourceType, "active", scim.StaticDefault(true))
+
+	// build locations from the address clients used, as told by the proxy in front of the server
+	baseURL, err := scim.NewForwardedBaseURL(propertySource.GetString("scim.protocol.baseUrl"))
+	web.ErrorCheck(err)
+
+	// reject weak passwords on create, replace, patch and password change
+	passwordPolicy := &scim.PasswordPolicy{
+		MinLength:           12,
+		MinCharacterClasses: 3,
+		RejectUserName:      true,
+		Banned:              scim.NewBannedPasswordList("password1234", "qwerty123456"),
+	}
+
 	exampleServer = &simpleServer{
-		logger:              &printLogger{},
+		logger:              scim.NewTextLogger(os.Stdout, scim.LogInfo),
+		metrics:             metrics,
+		tracer:              scim.NewNoOpTracer(),
+		rateLimiter:         scim.NewTokenBucketRateLimiter(50, 100),
+		accessController:    scim.NewUnrestrictedAccessController(),
+		groupDisplayWorkers: scim.NewWorkerPool(4),
+		hooks:               passwordPolicy.Register(scim.NewHooks()),
+		transformers:        transformers,
+		defaults:            defaults,
+		idempotencyCache:    scim.NewIdempotencyCache(10 * time.Minute),
+		journal:             scim.NewMemoryJournal(24 * time.Hour),
+		baseURL:             baseURL,
 		propertySource:      propertySource,
 		idAssignment:        scim.NewIdAssignment(),
 		userMetaAssignment:  scim.NewMetaAssignment(propertySource, scim.UserResourceType),
 		groupMetaAssignment: scim.NewMetaAssignment(propertySource, scim.GroupResourceType),
 		groupAssignment:     scim.NewGroupAssignment(groupRepo),
 	}
-}
 
-func main() {
-	initConfiguration()
-	wrap := func(handler web.EndpointHandler, requestType int) http.HandlerFunc {
-		return web.Endpoint(web.InjectRequestScope(web.ErrorRecovery(handler), requestType), exampleServer)
+	// count which attributes clients read and write, reported at GET /Admin/AttributeUsage
+	if propertySource.GetBool("scim.admin.attributeUsage") {
+		exampleServer.(*simpleServer).attributeUsage = scim.NewAttributeUsage()
 	}
 
-	mux := bone.New()
-	mux.Prefix("/v2")
-
-	mux.GetFunc("/Users/:resourceId", wrap(web.GetUserByIdHandler, scim.GetUserById))
-	mux.PostFunc("/Users", wrap(web.CreateUserHandler, scim.CreateUser))
-	mux.DeleteFunc("/Users/:resourceId", wrap(web.DeleteUserByIdHandler, scim.DeleteUser))
-	mux.GetFunc("/Users", wrap(web.QueryUserHandler, scim.QueryUser))
-	mux.PostFunc("/Users/.search", wrap(web.QueryUserHandler, scim.QueryUser))
-	mux.PutFunc("/Users/:resourceId", wrap(web.ReplaceUserHandler, scim.ReplaceUser))
-	mux.PatchFunc("/Users/:resourceId", wrap(web.PatchUserHandler, scim.PatchUser))
-
-	mux.GetFunc("/Groups/:resourceId", wrap(web.GetGroupByIdHandler, scim.GetGroupById))
-	mux.PostFunc("/Groups", wrap(web.CreateGroupHandler, scim.CreateGroup))
-	mux.DeleteFunc("/Groups/:resourceId", wrap(web.DeleteGroupByIdHandler, scim.DeleteGroup))
-	mux.GetFunc("/Groups", wrap(web.QueryGroupHandler, scim.QueryGroup))
-	mux.PostFunc("/Groups/.search", wrap(web.QueryGroupHandler, scim.QueryGroup))
-	mux.PutFunc("/Groups/:resourceId", wrap(web.ReplaceGroupHandler, scim.ReplaceGroup))
-	mux.PatchFunc("/Groups/:resourceId", wrap(web.PatchGroupHandler, scim.PatchGroup))
-
-	mux.PostFunc("/Bulk", wrap(web.BulkHandler, scim.BulkOp))
-
-	mux.GetFunc("/", wrap(web.RootQueryHandler, scim.RootQuery))
-	mux.PostFunc("/.search", wrap(web.RootQueryHandler, scim.RootQuery))
-
-	mux.GetFunc("/Schemas/:resourceId", wrap(web.GetSchemaByIdHandler, scim.GetSchemaById))
-	mux.GetFunc("/Schemas", wrap(web.GetAllSchemaHandler, scim.GetAllSchema))
-
-	mux.GetFunc("/ResourceTypes", wrap(web.GetAllResourceTypeHandler, scim.GetAllResourceType))
+	// queue mutations and apply them in the background instead of within the request
+	if propertySource.GetBool("scim.protocol.async") {
+		server := exampleServer.(*simpleServer)
+		server.operationQueue = scim.NewChannelOperationQueue(100)
+		server.operationStore = scim.NewMapOperationStore()
+		workers := &scim.OperationWorkers{
+			Size:       4,
+			Queue:      server.operationQueue,
+			Store:      server.operationStore,
+			Repository: server.Repository,
+		}
+		go workers.Run(context.Background())
+	}
 
-	mux.GetFunc("/ServiceProviderConfig", wrap(web.GetServiceProviderConfigHandler, scim.GetSPConfig))
+	// forget journaled responses after a day
+	go func() {
+		for now := range time.Tick(time.Hour) {
+			exampleServer.Journal().Expire(now.Add(-24 * time.Hour))
+		}
+	}()
+}
 
+func main() {
+	initConfiguration()
+	schemaRegistry.Freeze()
+	mux := http.NewServeMux()
+	mux.Handle("/v2/", httpadapter.NewRouter(exampleServer, httpadapter.WithPrefix("/v2")))
+	// legacy SCIM 1.1 clients
+	mux.Handle("/v1/", httpadapter.NewSCIM11Handler(exampleServer, httpadapter.WithPrefix("/v1")))
 	http.ListenAndServe(":8080", mux)
 }
 
@@ -142,11 +212,12 @@ func main() {
 var (
 	rootSchemaInternal,
 	userSchemaInternal,
-	groupSchemaInternal,
-	userSchema,
-	groupSchema *scim.Schema
+	groupSchemaInternal *scim.Schema
 )
 
+// Schemas served at /Schemas
+var schemaRegistry *scim.SchemaRegistry
+
 // Repositories
 var (
 	userRepo,
@@ -156,31 +227,62 @@ var (
 	spConfigRepo scim.Repository
 )
 
+// The schema by resource type, of those in resourceTypeRepo
+var itemSchemas map[string]string
+
 var exampleServer web.ScimServer
 
 // Example server implementation
 type simpleServer struct {
 	propertySource      *mapPropertySource
-	logger              *printLogger
+	logger              scim.Logger
+	metrics             *scim.Metrics
+	tracer              scim.Tracer
+	rateLimiter         scim.RateLimiter
+	accessController    scim.AccessController
+	operationQueue      scim.OperationQueue
+	operationStore      scim.OperationStore
+	groupDisplayWorkers *scim.WorkerPool
+	hooks               *scim.Hooks
+	transformers        *scim.Transformers
+	defaults            *scim.Defaults
+	idempotencyCache    scim.IdempotencyCache
+	journal             scim.Journal
+	attributeUsage      *scim.AttributeUsage
+	operationToggles    *scim.OperationToggles
+	baseURL             scim.BaseURLProvider
 	idAssignment        scim.ReadOnlyAssignment
 	userMetaAssignment  scim.ReadOnlyAssignment
 	groupMetaAssignment scim.ReadOnlyAssignment
 	groupAssignment     scim.ReadOnlyAssignment
 }
 
-func (ss *simpleServer) Property() scim.PropertySource              { return ss.propertySource }
-func (ss *simpleServer) Logger() scim.Logger                        { return ss.logger }
-func (ss *simpleServer) WebRequest(r *http.Request) scim.WebRequest { return HttpWebRequest{Req: r} }
-func (ss *simpleServer) Schema(id string) *scim.Schema {
-	switch id {
-	case scim.UserUrn:
-		return userSchema
-	case scim.GroupUrn:
-		return groupSchema
-	default:
-		panic(scim.Error.Text("unknown schema id %s", id))
-	}
+func (ss *simpleServer) Property() scim.PropertySource            { return ss.propertySource }
+func (ss *simpleServer) Logger() scim.Logger                      { return ss.logger }
+func (ss *simpleServer) Metrics() *scim.Metrics                   { return ss.metrics }
+func (ss *simpleServer) Tracer() scim.Tracer                      { return ss.tracer }
+func (ss *simpleServer) RateLimiter() scim.RateLimiter            { return ss.rateLimiter }
+func (ss *simpleServer) AccessController() scim.AccessController  { return ss.accessController }
+func (ss *simpleServer) OperationQueue() scim.OperationQueue      { return ss.operationQueue }
+func (ss *simpleServer) OperationStore() scim.OperationStore      { return ss.operationStore }
+func (ss *simpleServer) ExportStore() scim.ExportStore            { return nil }
+func (ss *simpleServer) GroupDisplayWorkers() *scim.WorkerPool    { return ss.groupDisplayWorkers }
+func (ss *simpleServer) Hooks() *scim.Hooks                       { return ss.hooks }
+func (ss *simpleServer) Transformers() *scim.Transformers         { return ss.transformers }
+func (ss *simpleServer) Validators() *scim.Validators             { return nil }
+func (ss *simpleServer) Messages() *scim.MessageCatalog           { return nil }
+func (ss *simpleServer) Defaults() *scim.Defaults                 { return ss.defaults }
+func (ss *simpleServer) Pipelines() *web.Pipelines                { return nil }
+func (ss *simpleServer) IdempotencyCache() scim.IdempotencyCache  { return ss.idempotencyCache }
+func (ss *simpleServer) Journal() scim.Journal                    { return ss.journal }
+func (ss *simpleServer) ChangeLog() scim.ChangeLog                { return nil }
+func (ss *simpleServer) AttributeUsage() *scim.AttributeUsage     { return ss.attributeUsage }
+func (ss *simpleServer) OperationToggles() *scim.OperationToggles { return ss.operationToggles }
+func (ss *simpleServer) BaseURL() scim.BaseURLProvider            { return ss.baseURL }
+func (ss *simpleServer) WebRequest(r *http.Request) scim.WebRequest {
+	return httpadapter.NewWebRequest(r)
 }
+func (ss *simpleServer) Schemas() *scim.SchemaRegistry { return schemaRegistry }
 func (ss *simpleServer) InternalSchema(id string) *scim.Schema {
 	switch id {
 	case "":
@@ -196,6 +298,12 @@ func (ss *simpleServer) InternalSchema(id string) *scim.Schema {
 func (ss *simpleServer) CorrectCase(subj *scim.Resource, sch *scim.Schema, ctx context.Context) error {
 	return scim.CorrectCase(subj, sch, ctx)
 }
+func (ss *simpleServer) CheckUnknownAttributes(subj *scim.Resource, sch *scim.Schema, ctx context.Context) error {
+	return scim.CheckUnknownAttributes(subj, sch, ss.Property().GetString("scim.protocol.unknownAttributes"), ctx)
+}
+func (ss *simpleServer) ApplyReplacePolicy(subj *scim.Resource, ref *scim.Resource, sch *scim.Schema, ctx context.Context) error {
+	return scim.ApplyReplacePolicy(subj, ref, sch, ss.Property().GetString("scim.protocol.replace"), ctx)
+}
 func (ss *simpleServer) ApplyPatch(patch scim.Patch, subj *scim.Resource, sch *scim.Schema, ctx context.Context) error {
 	return scim.ApplyPatch(patch, subj, sch, ctx)
 }
@@ -209,7 +317,12 @@ func (ss *simpleServer) ValidateMutability(subj *scim.Resource, ref *scim.Resour
 	return scim.ValidateMutability(subj, ref, sch, ctx)
 }
 func (ss *simpleServer) ValidateUniqueness(subj *scim.Resource, sch *scim.Schema, repo scim.Repository, ctx context.Context) error {
-	return scim.ValidateUniqueness(subj, sch, repo, ctx)
+	return scim.ValidateUniqueness(subj, sch, repo, []scim.Repository{userRepo, groupRepo}, ctx)
+}
+func (ss *simpleServer) ValidateUniquenessBatch(subjs []*scim.Resource, sch *scim.Schema, repo scim.Repository, ctx context.Context) []error {
+	return scim.ValidateUniquenessBatch(subjs, sch, repo, []scim.Repository{userRepo, groupRepo},
+		ss.propertySource.GetInt("scim.protocol.uniquenessBatchSize"),
+		ss.propertySource.GetInt("scim.protocol.uniquenessWorkers"), ctx)
 }
 func (ss *simpleServer) AssignReadOnlyValue(r *scim.Resource, ctx context.Context) (err error) {
 	requestType := ctx.Value(scim.RequestType{}).(int)
@@ -221,7 +334,7 @@ func (ss *simpleServer) AssignReadOnlyValue(r *scim.Resource, ctx context.Contex
 		web.ErrorCheck(err)
 		err = ss.groupAssignment.AssignValue(r, ctx)
 		web.ErrorCheck(err)
-	case scim.ReplaceUser, scim.PatchUser:
+	case scim.ReplaceUser, scim.PatchUser, scim.ChangeUserPassword:
 		err = ss.userMetaAssignment.AssignValue(r, ctx)
 		web.ErrorCheck(err)
 		err = ss.groupAssignment.AssignValue(r, ctx)
@@ -238,7 +351,14 @@ func (ss *simpleServer) AssignReadOnlyValue(r *scim.Resource, ctx context.Contex
 	return
 }
 func (ss *simpleServer) MarshalJSON(v interface{}, sch *scim.Schema, attributes []string, excludedAttributes []string) ([]byte, error) {
-	return scim.MarshalJSON(v, sch, attributes, excludedAttributes)
+	options := make([]scim.MarshalOption, 0)
+	if ss.propertySource.GetBool("scim.protocol.canonicalJson") {
+		options = append(options, scim.CanonicalOrder())
+	}
+	if ss.propertySource.GetBool("scim.protocol.listItemSchemas") {
+		options = append(options, scim.ItemSchemas(itemSchemas))
+	}
+	return scim.MarshalJSON(v, sch, attributes, excludedAttributes, options...)
 }
 func (ss *simpleServer) Repository(identifier string) scim.Repository {
 	switch identifier {
@@ -265,51 +385,37 @@ func (mps *mapPropertySource) GetString(key string) string { return mps.Get(key)
 func (mps *mapPropertySource) GetInt(key string) int       { return mps.Get(key).(int) }
 func (mps *mapPropertySource) GetBool(key string) bool     { return mps.Get(key).(bool) }
 
-// simple logger that writes to terminal
-type printLogger struct{}
-
-func (pl *printLogger) Info(template string, args ...interface{}) {
-	fmt.Println("[INFO] "+template, args)
-}
-func (pl *printLogger) Debug(template string, args ...interface{}) {
-	fmt.Println("[DEBUG] "+template, args)
-}
-func (pl *printLogger) Error(template string, args ...interface{}) {
-	fmt.Println("[ERROR] "+template, args)
-}
-
-// http request source
-type HttpWebRequest struct{ Req *http.Request }
-
-func (hwr HttpWebRequest) Target() string            { return hwr.Req.RequestURI }
-func (hwr HttpWebRequest) Method() string            { return hwr.Req.Method }
-func (hwr HttpWebRequest) Header(name string) string { return hwr.Req.Header.Get(name) }
-func (hwr HttpWebRequest) Body() ([]byte, error)     { return ioutil.ReadAll(hwr.Req.Body) }
-func (hwr HttpWebRequest) Param(name string) string {
-	if v := hwr.Req.URL.Query().Get(name); len(v) > 0 {
-		return v
-	} else if v := bone.GetValue(hwr.Req, name); len(v) > 0 {
-		return v
-	} else {
-		return ""
-	}
-}
-
 // mongo root query repository
 type mongoRootQueryRepository struct {
 	repos []scim.Repository
 }
 
-func (m *mongoRootQueryRepository) Create(provider scim.DataProvider) error { panic("not implemented") }
-func (m *mongoRootQueryRepository) Get(id, version string) (scim.DataProvider, error) {
+func (m *mongoRootQueryRepository) Create(provider scim.DataProvider, ctx context.Context) error {
+	panic("not implemented")
+}
+func (m *mongoRootQueryRepository) Get(id, version string, ctx context.Context) (scim.DataProvider, error) {
+	panic("not implemented")
+}
+func (m *mongoRootQueryRepository) GetAll(ctx context.Context) ([]scim.Complex, error) {
 	panic("not implemented")
 }
-func (m *mongoRootQueryRepository) GetAll() ([]scim.Complex, error) { panic("not implemented") }
-func (m *mongoRootQueryRepository) Count(query string) (int, error) { panic("not implemented") }
-func (m *mongoRootQueryRepository) Update(id, version string, provider scim.DataProvider) error {
+func (m *mongoRootQueryRepository) Count(query string, ctx context.Context) (int, error) {
 	panic("not implemented")
 }
-func (m *mongoRootQueryRepository) Delete(id, version string) error { panic("not implemented") }
-func (m *mongoRootQueryRepository) Search(payload scim.SearchRequest) (*scim.ListResponse, error) {
-	return scim.CompositeSearchFunc(m.repos...)(payload)
+func (m *mongoRootQueryRepository) Update(id, version string, provider scim.DataProvider, ctx context.Context) error {
+	panic("not implemented")
+}
+func (m *mongoRootQueryRepository) Delete(id, version string, ctx context.Context) error {
+	panic("not implemented")
+}
+func (m *mongoRootQueryRepository) Ping(ctx context.Context) error {
+	for _, repo := range m.repos {
+		if err := repo.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *mongoRootQueryRepository) Search(payload scim.SearchRequest, ctx context.Context) (*scim.ListResponse, error) {
+	return scim.CompositeSearchFunc(m.repos...)(payload, ctx)
 }
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maintenance endpoints operating on the repositories as a whole. They are not part of SCIM and carry
+// no authorization of their own; mount them apart from the SCIM endpoints, behind separate authentication,
+// i.e. with httpadapter.WithAdmin.
+
+// Report the number of stored resources per resource type
+func AdminCountsHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	counts, err := shared.CountResources(adminRepositories(server), ctx)
+	ErrorCheck(err)
+	return adminResponse(counts)
+}
+
+// Rebuild the indexes of unique attributes in every repository that keeps them
+func AdminReindexHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	result := make(map[string]string)
+	for resourceType, repo := range adminRepositories(server) {
+		indexer, ok := shared.AsUniqueIndexer(repo)
+		if !ok {
+			result[resourceType] = "unsupported"
+			continue
+		}
+		ErrorCheck(indexer.ReindexUnique(ctx))
+		result[resourceType] = "reindexed"
+	}
+	return adminResponse(result)
+}
+
+// Recompute the groups attribute of all users from the members of the groups
+func AdminRebuildMembershipHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	progress, err := shared.RebuildGroupReferences(
+		server.Repository(shared.UserResourceType),
+		server.Repository(shared.GroupResourceType),
+		0, ctx)
+	ErrorCheck(err)
+	return adminResponse(map[string]int{
+		"total":   progress.Total,
+		"updated": progress.Updated,
+		"skipped": progress.Skipped,
+		"failed":  progress.Failed,
+	})
+}
+
+// Remove resources deleted more than the olderThanDays parameter ago from repositories that flag deleted
+// resources instead of removing them
+func AdminPurgeHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	days, err := strconv.Atoi(r.Param("olderThanDays"))
+	if err != nil || days < 0 {
+		panic(shared.Error.InvalidParam("olderThanDays", "non-negative integer", r.Param("olderThanDays")))
+	}
+	before := time.Now().AddDate(0, 0, -days)
+
+	result := make(map[string]interface{})
+	for resourceType, repo := range adminRepositories(server) {
+		purger, ok := shared.AsDeletedPurger(repo)
+		if !ok {
+			result[resourceType] = "unsupported"
+			continue
+		}
+		n, err := purger.PurgeDeleted(before, ctx)
+		ErrorCheck(err)
+		result[resourceType] = n
+	}
+	return adminResponse(result)
+}
+
+// Report how often clients read, wrote and filtered on each attribute of users and groups since the server
+// started, attributes never used included, when the server collects attribute usage. Only requests that
+// succeeded are counted.
+func AdminAttributeUsageHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	usage := server.AttributeUsage()
+	if usage == nil {
+		return adminResponse(map[string]string{
+			shared.UserResourceType:  "disabled",
+			shared.GroupResourceType: "disabled",
+		})
+	}
+	return adminResponse(map[string]interface{}{
+		"since":                  usage.Since().UTC().Format(time.RFC3339),
+		shared.UserResourceType:  usage.Report(shared.UserResourceType, server.InternalSchema(shared.UserUrn)),
+		shared.GroupResourceType: usage.Report(shared.GroupResourceType, server.InternalSchema(shared.GroupUrn)),
+	})
+}
+
+// Recommend indexes of the user and group repositories, from the schemas and the filters clients ran since the
+// server started, see shared.AdviseIndexes, along with the statements creating them in PostgreSQL tables users
+// and groups keeping resources in a JSONB column data. Only filtered attributes filtered at least minFilters
+// times, 1 by default, are advised.
+func AdminIndexAdviceHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	minFilters := int64(1)
+	if text := r.Param("minFilters"); len(text) > 0 {
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || n < 1 {
+			panic(shared.Error.InvalidParam("minFilters", "positive integer", text))
+		}
+		minFilters = n
+	}
+
+	type advice struct {
+		shared.IndexAdvice
+		PostgreSQL string `json:"postgresql"`
+	}
+	result := make(map[string][]advice)
+	for resourceType, urn := range map[string]string{
+		shared.UserResourceType:  shared.UserUrn,
+		shared.GroupResourceType: shared.GroupUrn,
+	} {
+		table := strings.ToLower(resourceType) + "s"
+		result[resourceType] = make([]advice, 0)
+		filtered := server.AttributeUsage().Filtered(resourceType)
+		for _, each := range shared.AdviseIndexes(server.InternalSchema(urn), filtered, minFilters) {
+			result[resourceType] = append(result[resourceType], advice{each, each.PostgreSQL(table, "data")})
+		}
+	}
+	return adminResponse(result)
+}
+
+func adminRepositories(server ScimServer) map[string]shared.Repository {
+	return map[string]shared.Repository{
+		shared.UserResourceType:  server.Repository(shared.UserResourceType),
+		shared.GroupResourceType: server.Repository(shared.GroupResourceType),
+	}
+}
+
+func adminResponse(v interface{}) *ResponseInfo {
+	jsonBytes, err := json.Marshal(v)
+	ErrorCheck(err)
+
+	ri := newResponse()
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(jsonBytes)
+	return ri
+}
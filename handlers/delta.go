@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+)
+
+// Answer a query with a since parameter, a watermark of an earlier list response, with the resources of the
+// type created or updated after it, as they are now, and the ids of those deleted as tombstones. Each
+// resource is listed once, however often it changed. A page holds the changes of up to count writes, at least
+// one; clients ask again with the watermark of the response until it is the one they asked with. Filters do
+// not apply to deleted resources and are rejected.
+func respondDelta(r shared.WebRequest, server ScimServer, ctx context.Context, resourceType string, sch *shared.Schema) (ri *ResponseInfo) {
+	ri = newResponse()
+	changeLog := server.ChangeLog()
+	if changeLog == nil {
+		panic(shared.Error.NotImplemented("delta query"))
+	}
+
+	var sr shared.SearchRequest
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		if sr, err = ParseSearchRequest(r, server); err != nil {
+			return
+		}
+		if len(sr.Filter) > 0 {
+			return shared.Error.InvalidParam("filter", "no filter with since", sr.Filter)
+		}
+		return sr.ValidateAttributes(sch)
+	})
+	ErrorCheck(err)
+
+	var (
+		changes   []*shared.ChangeEvent
+		watermark string
+	)
+	err = traceStep(server, ctx, "changelog.since", func(ctx context.Context) (err error) {
+		limit := sr.Count
+		if limit < 1 {
+			limit = 1
+		}
+		changes, watermark, err = changeLog.Since(resourceType, r.Param("since"), limit, ctx)
+		return
+	})
+	ErrorCheck(err)
+	server.AttributeUsage().RecordRead(resourceType, sr.Attributes, sch)
+
+	// the last change of every resource, in the order of the last changes
+	last := make(map[string]*shared.ChangeEvent, len(changes))
+	order := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if _, ok := last[change.Id]; ok {
+			for i, id := range order {
+				if id == change.Id {
+					order = append(order[:i], order[i+1:]...)
+					break
+				}
+			}
+		}
+		last[change.Id] = change
+		order = append(order, change.Id)
+	}
+
+	repo := server.Repository(resourceType)
+	lr := &shared.ListResponse{StartIndex: 1, Watermark: watermark, Resources: []shared.DataProvider{}}
+	for _, id := range order {
+		change := last[id]
+		if change.Op != shared.ChangeDelete {
+			var dp shared.DataProvider
+			err := traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+				dp, err = repo.Get(id, "", ctx)
+				return
+			})
+			if err == nil {
+				lr.Resources = append(lr.Resources, dp)
+				continue
+			}
+			// deleted since, the deletion follows in a later page
+			if _, ok := err.(*shared.ResourceNotFoundError); !ok {
+				ErrorCheck(err)
+			}
+			continue
+		}
+		lr.Deleted = append(lr.Deleted, shared.Tombstone{Id: id, Deleted: change.Time})
+	}
+	lr.TotalResults = len(lr.Resources)
+
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, lr, sch, ctx), sch, sr.Attributes, sr.ExcludedAttributes)
+		return
+	})
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(json)
+	return
+}
+
+// the watermark of the latest change, taken before a search so that the changes the search races with are
+// listed again by the next delta query rather than lost; empty without a change log
+func latestWatermark(server ScimServer, ctx context.Context) string {
+	changeLog := server.ChangeLog()
+	if changeLog == nil {
+		return ""
+	}
+	watermark, err := changeLog.Watermark(ctx)
+	ErrorCheck(err)
+	return watermark
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	exportBatchSize = 500
+	// the most bytes of a dump sent in one response, clients continue with a Range request
+	exportChunkBytes = 8 << 20
+)
+
+func ExportUsersHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return startExport(r, server, ctx, shared.UserResourceType, shared.UserUrn, "/Users/.export")
+}
+
+func ExportGroupsHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return startExport(r, server, ctx, shared.GroupResourceType, shared.GroupUrn, "/Groups/.export")
+}
+
+// Start dumping the resources matching the filter of the request body, all without one, in the background
+// and respond with 202 Accepted pointing at the status of the export
+func startExport(r shared.WebRequest, server ScimServer, ctx context.Context, resourceType, urn, endpoint string) (ri *ResponseInfo) {
+	ri = newResponse()
+	store := server.ExportStore()
+	if store == nil {
+		panic(shared.Error.NotImplemented("export"))
+	}
+	ErrorCheck(server.OperationToggles().Check(resourceType, "query"))
+	sch := server.InternalSchema(urn)
+
+	job := &shared.ExportJob{ResourceType: resourceType, Filter: r.Param("filter"), Format: r.Param("format")}
+	body, err := r.Body()
+	ErrorCheck(err)
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, job); err != nil {
+			panic(shared.Error.InvalidParam("export request", "JSON object with filter and format", err.Error()))
+		}
+	}
+	switch job.Format {
+	case "":
+		job.Format = shared.ExportNDJSON
+	case shared.ExportNDJSON, shared.ExportGzip, shared.ExportCBOR, shared.ExportMessagePack:
+	default:
+		panic(shared.Error.InvalidParam("format", strings.Join([]string{shared.ExportNDJSON, shared.ExportGzip, shared.ExportCBOR, shared.ExportMessagePack}, ", "), job.Format))
+	}
+	if len(job.Filter) > 0 {
+		ErrorCheck(CheckFilter(job.Filter, server, sch))
+	}
+	ErrorCheck(shared.SubmitExport(job, store, ctx))
+	id := job.Id
+
+	// the export outlives the request, but reads as the client that asked for it
+	repo := server.Repository(resourceType)
+	shared.Go(ctx, func(detached context.Context) {
+		marshal := func(dp shared.DataProvider) ([]byte, error) {
+			if codec := shared.ExportCodec(job.Format); codec != shared.JSONCodec {
+				return codec.Marshal(redact(server, dp, sch, detached), sch, []string{}, []string{})
+			}
+			return server.MarshalJSON(redact(server, dp, sch, detached), sch, []string{}, []string{})
+		}
+		if err := shared.RunExport(job, repo, store, exportBatchSize, marshal, detached); err != nil {
+			logger(server).Error("export failed", shared.LogFields(detached, "export", id, "error", err.Error())...)
+		}
+	})
+
+	status, err := shared.GetExport(id, store, ctx)
+	ErrorCheck(err)
+	jsonBytes, err := json.Marshal(status)
+	ErrorCheck(err)
+
+	ri.Status(http.StatusAccepted)
+	ri.ScimJsonHeader()
+	if base, ok := ctx.Value(shared.BaseURL{}).(string); ok {
+		ri.LocationHeader(base + "/Exports/" + id)
+	} else {
+		ri.LocationHeader(r.Target()[:strings.Index(r.Target(), endpoint)] + "/Exports/" + id)
+	}
+	ri.Body(jsonBytes)
+	return
+}
+
+// Report the status of an export, how many resources it has written and, once succeeded, the size of its
+// dump. Exports are only reported to the principal that started them.
+func GetExportByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+	store := server.ExportStore()
+	if store == nil {
+		panic(shared.Error.ResourceNotFound(r.Param("resourceId"), ""))
+	}
+
+	job, err := shared.GetExport(r.Param("resourceId"), store, ctx)
+	ErrorCheck(err)
+	jsonBytes, err := json.Marshal(job)
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(jsonBytes)
+	return
+}
+
+// Serve the dump of a succeeded export, or the single byte range the Range header asks for, so that
+// interrupted downloads resume where they stopped. Responses carry at most 8 MiB; larger dumps are served in
+// parts, 206 Partial Content with a Content-Range telling the client where to continue. If-Range with the
+// ETag of the export is honored, though a dump never changes once written. Only the principal that started
+// the export downloads it.
+func DownloadExportHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+	store := server.ExportStore()
+	if store == nil {
+		panic(shared.Error.ResourceNotFound(r.Param("resourceId"), ""))
+	}
+	job, err := shared.GetExport(r.Param("resourceId"), store, ctx)
+	ErrorCheck(err)
+	if job.Status != shared.OperationSucceeded {
+		panic(shared.Error.NotReady(job.Id, job.Status))
+	}
+
+	etag := strconv.Quote(job.Id)
+	offset, length := int64(0), job.Size
+	ranged := len(r.Header("Range")) > 0 && (len(r.Header("If-Range")) == 0 || r.Header("If-Range") == etag)
+	if ranged {
+		offset, length, err = parseByteRange(r.Header("Range"), job.Size)
+		ErrorCheck(err)
+	}
+	if length > exportChunkBytes {
+		length = exportChunkBytes
+		ranged = true
+	}
+	data, err := store.ReadRange(job.Id, offset, length)
+	ErrorCheck(err)
+
+	ri.Header("Accept-Ranges", "bytes")
+	ri.ETagHeader(etag)
+	ri.Header("Last-Modified", job.LastModified.UTC().Format(http.TimeFormat))
+	switch job.Format {
+	case shared.ExportGzip:
+		ri.Header("Content-Type", "application/gzip")
+		ri.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson.gz"`, job.Id))
+	case shared.ExportCBOR:
+		ri.Header("Content-Type", "application/cbor-seq")
+		ri.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cbor"`, job.Id))
+	case shared.ExportMessagePack:
+		ri.Header("Content-Type", "application/vnd.msgpack")
+		ri.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.msgpack"`, job.Id))
+	default:
+		ri.Header("Content-Type", "application/x-ndjson")
+		ri.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, job.Id))
+	}
+	if ranged {
+		ri.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(data))-1, job.Size))
+		ri.Status(http.StatusPartialContent)
+	} else {
+		ri.Status(http.StatusOK)
+	}
+	ri.Body(data)
+	return
+}
+
+// Delete an export and its dump once the client is done with it, rather than waiting for it to expire. Exports
+// still pending or running are not deleted.
+func DeleteExportByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+	store := server.ExportStore()
+	if store == nil {
+		panic(shared.Error.ResourceNotFound(r.Param("resourceId"), ""))
+	}
+	job, err := shared.GetExport(r.Param("resourceId"), store, ctx)
+	ErrorCheck(err)
+	if job.Status != shared.OperationSucceeded && job.Status != shared.OperationFailed {
+		panic(shared.Error.NotReady(job.Id, job.Status))
+	}
+	ErrorCheck(store.Delete(job.Id))
+
+	ri.Status(http.StatusNoContent)
+	return
+}
+
+// the offset and length of the single range of a Range header, i.e. bytes=100-199, bytes=100- or bytes=-100
+func parseByteRange(header string, size int64) (offset, length int64, err error) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, 0, shared.Error.RangeNotSatisfiable(size)
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(spec, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, shared.Error.RangeNotSatisfiable(size)
+	}
+	first, last := strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1])
+
+	if len(first) == 0 {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, shared.Error.RangeNotSatisfiable(size)
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, nil
+	}
+
+	offset, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, 0, shared.Error.RangeNotSatisfiable(size)
+	}
+	end := size - 1
+	if len(last) > 0 {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < offset {
+			return 0, 0, shared.Error.RangeNotSatisfiable(size)
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return offset, end - offset + 1, nil
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the request headers passed on to the upstream, and the response headers passed back
+var (
+	forwardedRequestHeaders  = []string{"Content-Type", "Accept", "If-Match", "If-None-Match"}
+	forwardedResponseHeaders = []string{"Content-Type", "Location", "ETag", "Retry-After"}
+)
+
+// what the writes forwarded are authorized as, see authorizeForward
+var (
+	// the schemas of the resource types
+	forwardedUrns = map[string]string{
+		shared.UserResourceType:        shared.UserUrn,
+		shared.GroupResourceType:       shared.GroupUrn,
+		shared.RoleResourceType:        shared.RoleUrn,
+		shared.EntitlementResourceType: shared.EntitlementUrn,
+	}
+	// the resource types of the endpoints a bulk request addresses, by the property naming their URI
+	forwardedBulkEndpoints = map[string]string{
+		"scim.protocol.uri.user":  shared.UserResourceType,
+		"scim.protocol.uri.group": shared.GroupResourceType,
+	}
+	// the operations of the methods of bulk operations
+	forwardedBulkOperations = map[string]string{
+		http.MethodPost:   "create",
+		http.MethodPut:    "replace",
+		http.MethodPatch:  "patch",
+		http.MethodDelete: "delete",
+	}
+)
+
+// Limits of Forward when not given
+const (
+	DefaultForwardTimeout       = 30 * time.Second
+	DefaultForwardResponseBytes = 10 << 20
+)
+
+// A handler sending the request on to the upstream SCIM server of a read only mirror, i.e. the system of
+// record whose resources the local repositories are a projection of, and answering with its response. The
+// prefix of the local endpoints is replaced by the upstream URL, i.e. https://hr.example.com/scim/v2, and the
+// token, if any, is sent as bearer token. An unreachable upstream, or one answering with more than maxBytes, is
+// answered with 503. Changes show in the mirror once the feed populating it has caught up.
+//
+// Writes are authorized before they leave the server as the handlers they stand in for would, by the operation
+// toggles and the access controller of the server, see authorizeForward. A nil client is one timing out after
+// DefaultForwardTimeout, a maxBytes of 0 is DefaultForwardResponseBytes.
+func Forward(upstream, prefix, token string, client *http.Client, maxBytes int64) EndpointHandler {
+	upstream = strings.TrimSuffix(upstream, "/")
+	prefix = strings.TrimSuffix(prefix, "/")
+	if client == nil {
+		client = &http.Client{Timeout: DefaultForwardTimeout}
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultForwardResponseBytes
+	}
+	return func(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+		ri = newResponse()
+
+		body, err := r.Body()
+		ErrorCheck(err)
+		err = traceStep(server, ctx, "authorize", func(ctx context.Context) error {
+			return authorizeForward(r, body, server, ctx)
+		})
+		ErrorCheck(err)
+		req, err := http.NewRequest(r.Method(), upstream+strings.TrimPrefix(r.Target(), prefix), bytes.NewReader(body))
+		ErrorCheck(err)
+		req = req.WithContext(ctx)
+		for _, name := range forwardedRequestHeaders {
+			if v := r.Header(name); len(v) > 0 {
+				req.Header.Set(name, v)
+			}
+		}
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		var resp *http.Response
+		err = traceStep(server, ctx, "upstream", func(ctx context.Context) (err error) {
+			resp, err = client.Do(req)
+			return
+		})
+		if err != nil {
+			logger(server).Warn("upstream unreachable", shared.LogFields(ctx, "error", err.Error())...)
+			panic(shared.Error.Unavailable(0))
+		}
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+		ErrorCheck(err)
+		if int64(len(respBody)) > maxBytes {
+			logger(server).Warn("upstream response too large", shared.LogFields(ctx, "maxBytes", maxBytes)...)
+			panic(shared.Error.Unavailable(0))
+		}
+
+		for _, name := range forwardedResponseHeaders {
+			if v := resp.Header.Get(name); len(v) > 0 {
+				ri.Header(name, v)
+			}
+		}
+		ri.Status(resp.StatusCode)
+		ri.Body(respBody)
+		return
+	}
+}
+
+// authorize a write about to be forwarded as its handler would: by the operation toggles, and by the access
+// controller on the attributes it writes, which a replace compares against the local projection of the
+// resource. The operations of a bulk request are authorized one by one.
+func authorizeForward(r shared.WebRequest, body []byte, server ScimServer, ctx context.Context) error {
+	requestType, _ := ctx.Value(shared.RequestType{}).(int)
+	resourceType, operation := shared.DescribeRequestType(requestType)
+	if operation != "bulk" {
+		id, _ := ParseIdAndVersion(r)
+		return authorizeWrite(resourceType, operation, id, body, server, ctx)
+	}
+
+	var bulk shared.BulkReq
+	if err := json.Unmarshal(body, &bulk); err != nil {
+		return shared.Error.InvalidParam("bulk request", "JSON object with Operations", err.Error())
+	}
+	for _, op := range bulk.Operations {
+		var req BulkWebRequest
+		req.Populate(op, server.Property())
+		resourceType := ""
+		for property, each := range forwardedBulkEndpoints {
+			if uri := server.Property().GetString(property); req.Target() == uri || strings.HasPrefix(req.Target(), uri+"/") {
+				resourceType = each
+			}
+		}
+		operation := forwardedBulkOperations[req.Method()]
+		if err := authorizeWrite(resourceType, operation, req.Param("resourceId"), req.body, server, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func authorizeWrite(resourceType, operation, id string, body []byte, server ScimServer, ctx context.Context) error {
+	ac := server.AccessController()
+	if operation == "changePassword" {
+		if !ac.CanWrite(shared.UserResourceType, "password", ctx) {
+			return shared.Error.Forbidden("password")
+		}
+		return nil
+	}
+	if len(resourceType) == 0 || len(operation) == 0 {
+		return nil
+	}
+	if err := server.OperationToggles().Check(resourceType, operation); err != nil {
+		return err
+	}
+
+	req := BulkWebRequest{body: body}
+	switch operation {
+	case "create", "replace":
+		resource, err := ParseBodyAsResource(req)
+		if err != nil {
+			return err
+		}
+		var reference *shared.Resource
+		if operation == "replace" {
+			if dp, err := server.Repository(resourceType).Get(id, "", ctx); err == nil {
+				reference, _ = dp.(*shared.Resource)
+			}
+		}
+		return shared.ValidateWritable(resource, reference, server.InternalSchema(forwardedUrns[resourceType]), ac, ctx)
+	case "patch":
+		mod, err := ParseModification(req)
+		if err != nil {
+			return err
+		}
+		for _, patch := range mod.Ops {
+			if err := shared.ValidatePatchWritable(patch, resourceType, ac, ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
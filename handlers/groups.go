@@ -2,241 +2,77 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"github.com/davidiamyou/go-scim/shared"
-	"net/http"
+	"reflect"
 )
 
 func CreateGroupHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
-	ri = newResponse()
-	sch := server.InternalSchema(shared.GroupUrn)
-
-	resource, err := ParseBodyAsResource(r)
-	ErrorCheck(err)
-
-	err = server.ValidateType(resource, sch, ctx)
-	ErrorCheck(err)
-
-	err = server.CorrectCase(resource, sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateRequired(resource, sch, ctx)
-	ErrorCheck(err)
-
-	repo := server.Repository(shared.GroupResourceType)
-	err = server.ValidateUniqueness(resource, sch, repo, ctx)
-	ErrorCheck(err)
-
-	err = server.AssignReadOnlyValue(resource, ctx)
-	ErrorCheck(err)
-
-	err = repo.Create(resource)
-	ErrorCheck(err)
-
-	json, err := server.MarshalJSON(resource, sch, []string{}, []string{})
-	ErrorCheck(err)
-
-	location := resource.GetData()["meta"].(map[string]interface{})["location"].(string)
-	version := resource.GetData()["meta"].(map[string]interface{})["version"].(string)
-
-	ri.Status(http.StatusCreated)
-	ri.ScimJsonHeader()
-	if len(version) > 0 {
-		ri.ETagHeader(version)
-	}
-	if len(location) > 0 {
-		ri.LocationHeader(location)
-	}
-	ri.Body(json)
-	return
+	return createResource(r, server, ctx, groupEndpoint)
 }
 
-func PatchGroupHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
-	ri = newResponse()
-	sch := server.InternalSchema(shared.GroupUrn)
-	repo := server.Repository(shared.GroupResourceType)
-
-	id, version := ParseIdAndVersion(r)
-	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
-
-	resource, err := repo.Get(id, version)
-	ErrorCheck(err)
-
-	mod, err := ParseModification(r)
-	ErrorCheck(err)
-	err = mod.Validate()
-	ErrorCheck(err)
-
-	for _, patch := range mod.Ops {
-		err = server.ApplyPatch(patch, resource.(*shared.Resource), sch, ctx)
-		ErrorCheck(err)
-	}
-
-	reference, err := repo.Get(id, version)
-	ErrorCheck(err)
-
-	err = server.ValidateType(resource.(*shared.Resource), sch, ctx)
-	ErrorCheck(err)
-
-	err = server.CorrectCase(resource.(*shared.Resource), sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateRequired(resource.(*shared.Resource), sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateMutability(resource.(*shared.Resource), reference.(*shared.Resource), sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateUniqueness(resource.(*shared.Resource), sch, repo, ctx)
-	ErrorCheck(err)
-
-	err = server.AssignReadOnlyValue(resource.(*shared.Resource), ctx)
-	ErrorCheck(err)
-
-	err = repo.Update(id, version, resource)
-	ErrorCheck(err)
-
-	json, err := server.MarshalJSON(resource, sch, []string{}, []string{})
-	ErrorCheck(err)
-
-	location := resource.GetData()["meta"].(map[string]interface{})["location"].(string)
-	newVersion := resource.GetData()["meta"].(map[string]interface{})["version"].(string)
-
-	ri.Status(http.StatusOK)
-	ri.ScimJsonHeader()
-	if len(newVersion) > 0 {
-		ri.ETagHeader(newVersion)
-	}
-	if len(location) > 0 {
-		ri.LocationHeader(location)
-	}
-	ri.Body(json)
-	return
+func GetGroupByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return getResource(r, server, ctx, groupEndpoint)
 }
 
 func ReplaceGroupHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
-	ri = newResponse()
-	sch := server.InternalSchema(shared.GroupUrn)
-	repo := server.Repository(shared.GroupResourceType)
-
-	resource, err := ParseBodyAsResource(r)
-	ErrorCheck(err)
-
-	id, version := ParseIdAndVersion(r)
-	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
-	reference, err := repo.Get(id, version)
-	ErrorCheck(err)
-
-	err = server.ValidateType(resource, sch, ctx)
-	ErrorCheck(err)
-
-	err = server.CorrectCase(resource, sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateRequired(resource, sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateMutability(resource, reference.(*shared.Resource), sch, ctx)
-	ErrorCheck(err)
-
-	err = server.ValidateUniqueness(resource, sch, repo, ctx)
-	ErrorCheck(err)
-
-	err = server.AssignReadOnlyValue(resource, ctx)
-	ErrorCheck(err)
-
-	err = repo.Update(id, version, resource)
-	ErrorCheck(err)
-
-	json, err := server.MarshalJSON(resource, sch, []string{}, []string{})
-	ErrorCheck(err)
-
-	location := resource.GetData()["meta"].(map[string]interface{})["location"].(string)
-	newVersion := resource.GetData()["meta"].(map[string]interface{})["version"].(string)
+	return replaceResource(r, server, ctx, groupEndpoint)
+}
 
-	ri.Status(http.StatusOK)
-	ri.ScimJsonHeader()
-	if len(newVersion) > 0 {
-		ri.ETagHeader(newVersion)
-	}
-	if len(location) > 0 {
-		ri.LocationHeader(location)
-	}
-	ri.Body(json)
-	return
+func PatchGroupHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return patchResource(r, server, ctx, groupEndpoint)
 }
 
 func QueryGroupHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
-	ri = newResponse()
-	sch := server.InternalSchema(shared.GroupUrn)
-
-	attributes, excludedAttributes := ParseInclusionAndExclusionAttributes(r)
-
-	sr, err := ParseSearchRequest(r, server)
-	ErrorCheck(err)
-
-	err = sr.Validate(sch)
-	ErrorCheck(err)
-
-	repo := server.Repository(shared.GroupResourceType)
-	lr, err := repo.Search(sr)
-	ErrorCheck(err)
-
-	json, err := server.MarshalJSON(lr, sch, attributes, excludedAttributes)
-	ErrorCheck(err)
-
-	ri.Status(http.StatusOK)
-	ri.ScimJsonHeader()
-	ri.Body(json)
-	return
+	return queryResource(r, server, ctx, groupEndpoint)
 }
 
 func DeleteGroupByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
-	ri = newResponse()
-
-	id, version := ParseIdAndVersion(r)
-	repo := server.Repository(shared.GroupResourceType)
-
-	err := repo.Delete(id, version)
-	ErrorCheck(err)
-
-	ri.Status(http.StatusNoContent)
-	return
+	return deleteResource(r, server, ctx, groupEndpoint)
 }
 
-func GetGroupByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
-	ri = newResponse()
-	sch := server.InternalSchema(shared.GroupUrn)
-
-	id, version := ParseIdAndVersion(r)
+// Bring the display of the groups entries of the users referring to a renamed group up to date, within the
+// request when at most scim.protocol.groupDisplaySyncLimit users refer to it and in the background otherwise,
+// leaving their entries stale for a while. Background propagations run on the GroupDisplayWorkers of the
+// server, the request propagates its own when all of them are busy. Failures are logged and do not fail the
+// update of the group, the membership rebuild of the admin endpoints repairs what is left.
+func propagateGroupDisplay(server ScimServer, ctx context.Context, group shared.DataProvider, previous interface{}) {
+	display := group.GetData()["displayName"]
+	if reflect.DeepEqual(display, previous) {
+		return
+	}
+	userRepo := server.Repository(shared.UserResourceType)
+	id := group.GetId()
 
-	if len(version) > 0 {
-		count, err := server.Repository(shared.GroupResourceType).Count(
-			fmt.Sprintf("id eq \"%s\" and meta.version eq \"%s\"", id, version),
-		)
-		if err == nil && count > 0 {
-			ri.Status(http.StatusNotModified)
+	var holders int
+	err := traceStep(server, ctx, "repository.count", func(ctx context.Context) (err error) {
+		quoted, err := shared.QuoteFilterString(id)
+		if err != nil {
 			return
 		}
+		holders, err = userRepo.Count("groups.value eq "+quoted, ctx)
+		return
+	})
+	if err != nil {
+		logger(server).Error("group display not propagated", shared.LogFields(ctx, "group", id, "error", err.Error())...)
+		return
 	}
 
-	attributes, excludedAttributes := ParseInclusionAndExclusionAttributes(r)
-
-	dp, err := server.Repository(shared.GroupResourceType).Get(id, version)
-	ErrorCheck(err)
-	location := dp.GetData()["meta"].(map[string]interface{})["location"].(string)
-
-	json, err := server.MarshalJSON(dp, sch, attributes, excludedAttributes)
-	ErrorCheck(err)
-
-	ri.Status(http.StatusOK)
-	ri.ScimJsonHeader()
-	if len(version) > 0 {
-		ri.ETagHeader(version)
+	propagate := func(workCtx context.Context) {
+		progress, err := shared.PropagateGroupDisplay(userRepo, id, display, 0, workCtx)
+		fields := shared.LogFields(ctx, "group", id, "total", progress.Total, "updated", progress.Updated,
+			"skipped", progress.Skipped, "failed", progress.Failed)
+		if err != nil || progress.Failed > 0 {
+			if err != nil {
+				fields = append(fields, "error", err.Error())
+			}
+			logger(server).Error("group display not propagated to all members", fields...)
+			return
+		}
+		logger(server).Info("propagated group display", fields...)
 	}
-	if len(location) > 0 {
-		ri.LocationHeader(location)
+	// the request is done before the members are, they must not be canceled with it
+	if holders <= server.Property().GetInt("scim.protocol.groupDisplaySyncLimit") ||
+		!server.GroupDisplayWorkers().TryGo(ctx, propagate) {
+		propagate(ctx)
 	}
-	ri.Body(json)
-	return
 }
@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupHandlers_MemberDisplay(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &notifyingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})}
+	cfg := testConfig()
+	cfg.Protocol.GroupDisplaySyncLimit = 1
+	server, err := config.NewServer(config.WithConfig(cfg), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+
+	write := func(method, target, body string) map[string]interface{} {
+		rw := scimtest.Serve(t, server.Handler(), method, target, body, nil)
+		require.True(t, rw.Code < 300, rw.Body.String())
+		return scimtest.Decode(t, rw)
+	}
+	user := func(userName string) string {
+		return write(http.MethodPost, "/v2/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "`+userName+`"}`)["id"].(string)
+	}
+	group := func(displayName string, members ...string) string {
+		values := make([]string, 0)
+		for _, member := range members {
+			values = append(values, `{"value": "`+member+`", "type": "User"}`)
+		}
+		return write(http.MethodPost, "/v2/Groups", `{
+			"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+			"displayName": "`+displayName+`",
+			"members": [`+strings.Join(values, ", ")+`]
+		}`)["id"].(string)
+	}
+	displays := func(id string) []string {
+		dp, err := users.Get(id, "", context.Background())
+		require.Nil(t, err)
+		names := make([]string, 0)
+		for _, g := range dp.GetData()["groups"].([]interface{}) {
+			names = append(names, g.(map[string]interface{})["display"].(string))
+		}
+		return names
+	}
+
+	david, alice := user("david"), user("alice")
+	small := group("small", david)
+	large := group("large", david, alice)
+	// the groups of users are assigned when they are written
+	for _, id := range []string{david, alice} {
+		write(http.MethodPatch, "/v2/Users/"+id, `{
+			"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+			"Operations": [{"op": "replace", "path": "nickName", "value": "nick"}]
+		}`)
+	}
+	assert.ElementsMatch(t, []string{"small", "large"}, displays(david))
+
+	// within the request
+	write(http.MethodPatch, "/v2/Groups/"+small, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "displayName", "value": "Small"}]
+	}`)
+	assert.ElementsMatch(t, []string{"Small", "large"}, displays(david))
+
+	// in the background, the users getting a new version
+	read, err := users.Get(alice, "", context.Background())
+	require.Nil(t, err)
+	version := read.GetData()["meta"].(map[string]interface{})["version"]
+	users.updated = make(chan string, 2)
+	write(http.MethodPut, "/v2/Groups/"+large, `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "Large",
+		"members": [{"value": "`+david+`", "type": "User"}, {"value": "`+alice+`", "type": "User"}]
+	}`)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-users.updated:
+		case <-time.After(5 * time.Second):
+			t.Fatal("members not updated")
+		}
+	}
+	assert.ElementsMatch(t, []string{"Small", "Large"}, displays(david))
+	assert.Equal(t, []string{"Large"}, displays(alice))
+	read, err = users.Get(alice, "", context.Background())
+	require.Nil(t, err)
+	assert.NotEqual(t, version, read.GetData()["meta"].(map[string]interface{})["version"])
+}
+
+func TestPatchGroupHandler_Members(t *testing.T) {
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "patched",
+		"members": [{"value": "a"}, {"value": "b"}]
+	}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+	// the instrumented repository of the server forwards the patch in place
+	_, ok := shared.AsMemberPatcher(server.Repository(shared.GroupResourceType))
+	require.True(t, ok)
+
+	patch := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "add", "path": "members", "value": [{"value": "c"}]}, {"op": "remove", "path": "members[value eq \"a\"]"}]
+	}`
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Groups/"+id, patch, nil)
+	require.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.NotEmpty(t, rw.Header().Get("ETag"))
+
+	// a client asking for attributes is answered with the group
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Groups/"+id+"?excludedAttributes=displayName", patch, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.NotEmpty(t, rw.Header().Get("ETag"))
+	group := scimtest.Decode(t, rw)
+	assert.NotContains(t, group, "displayName")
+	values := make([]string, 0)
+	for _, member := range group["members"].([]interface{}) {
+		values = append(values, member.(map[string]interface{})["value"].(string))
+	}
+	assert.Equal(t, []string{"b", "c"}, values)
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Groups/"+id+"?attributes=bogus..path", patch, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+	"time"
+)
+
+// how long the readiness probe waits for each repository to answer, within the second Kubernetes waits for
+// a probe by default
+const readinessTimeout = 500 * time.Millisecond
+
+// Liveness probe: the process is up and serving requests
+func HealthHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return healthResponse(http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// Readiness probe: the internal user and group schemas are loaded and the user and group repositories can be
+// reached within readinessTimeout. Responds 503 with the failing checks otherwise, so that no traffic is sent
+// to an unusable server.
+func ReadinessHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	checks := make(map[string]string)
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	check("schemas", schemaRegistryStatus(server))
+	for _, resourceType := range []string{shared.UserResourceType, shared.GroupResourceType} {
+		check("repository."+resourceType, pingRepository(server, resourceType, ctx))
+	}
+
+	status, body := http.StatusOK, map[string]interface{}{"status": "ready", "checks": checks}
+	if !ready {
+		status, body["status"] = http.StatusServiceUnavailable, "unavailable"
+	}
+	return healthResponse(status, body)
+}
+
+func schemaRegistryStatus(server ScimServer) error {
+	if server.Schemas() == nil || len(server.Schemas().All()) == 0 {
+		return shared.Error.Text("no schemas registered")
+	}
+	for _, urn := range []string{shared.UserUrn, shared.GroupUrn} {
+		if server.InternalSchema(urn) == nil {
+			return shared.Error.Text("internal schema %s not loaded", urn)
+		}
+	}
+	return nil
+}
+
+// the repository of a resource type the server does not provide may panic; a repository not answering in time
+// fails, even one that disregards the deadline of the context
+func pingRepository(server ScimServer, resourceType string, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- shared.Error.Text("%v", r)
+			}
+		}()
+		repo := server.Repository(resourceType)
+		if repo == nil {
+			done <- shared.Error.Text("no repository")
+			return
+		}
+		done <- repo.Ping(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return shared.Error.Timeout("the repository to answer")
+	}
+}
+
+func healthResponse(status int, body map[string]interface{}) *ResponseInfo {
+	jsonBytes, err := json.Marshal(body)
+	ErrorCheck(err)
+
+	ri := newResponse()
+	ri.Status(status)
+	ri.Header("Content-Type", "application/json")
+	ri.Body(jsonBytes)
+	return ri
+}
@@ -0,0 +1,41 @@
+package handlers_test
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadinessHandler_Timeout(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &hangingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{}), release: make(chan struct{})}
+	defer close(users.release)
+	server, err := config.NewServer(config.WithConfig(testConfig()), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+
+	// a repository that does not answer fails the probe in time
+	start := time.Now()
+	rw := scimtest.Serve(t, server.Handler(), http.MethodGet, "/v2/readyz", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code, rw.Body.String())
+	assert.Contains(t, rw.Body.String(), "Deadline exceeded")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+// a repository whose ping disregards the context and hangs until released
+type hangingRepository struct {
+	shared.Repository
+	release chan struct{}
+}
+
+func (r *hangingRepository) Ping(ctx context.Context) error {
+	<-r.release
+	return nil
+}
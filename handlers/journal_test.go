@@ -0,0 +1,105 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJournalRequests(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.Journal = true
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	user := func(userName string) string {
+		return `{"schemas": ["` + shared.UserUrn + `"], "userName": "` + userName + `"}`
+	}
+
+	// a replayed key is answered with the recorded response
+	keyed := map[string]string{"X-Journal-Key": "k1"}
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user("david"), keyed)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	created := scimtest.Decode(t, rw)
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user("david"), keyed)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.Equal(t, "true", rw.Header().Get("X-Replayed"))
+	assert.Equal(t, created["id"], scimtest.Decode(t, rw)["id"])
+
+	// a key reused for another request is refused
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user("alice"), keyed)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+
+	// a reused request id only correlates
+	correlated := map[string]string{"X-Request-Id": "sync-run-1"}
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user("alice"), correlated)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user("bob"), correlated)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.Empty(t, rw.Header().Get("X-Replayed"))
+	assert.Equal(t, "bob", scimtest.Decode(t, rw)["userName"])
+}
+
+func TestJournalRequests_Retry(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &blockingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{}), failures: 1}
+	cfg := testConfig()
+	cfg.Features.Journal = true
+	server, err := config.NewServer(config.WithConfig(cfg), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	body := `{"schemas": ["` + shared.UserUrn + `"], "userName": "david"}`
+	keyed := map[string]string{"X-Journal-Key": "k1"}
+
+	// a failed request is not recorded and can be retried
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", body, keyed)
+	require.Equal(t, http.StatusInternalServerError, rw.Code, rw.Body.String())
+
+	// replaying a request still in progress conflicts
+	users.entered, users.release = make(chan struct{}), make(chan struct{})
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", body, keyed)
+	}()
+	<-users.entered
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", body, keyed)
+	assert.Equal(t, http.StatusConflict, rw.Code, rw.Body.String())
+	close(users.release)
+	rw = <-done
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.Empty(t, rw.Header().Get("X-Replayed"))
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", body, keyed)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.Equal(t, "true", rw.Header().Get("X-Replayed"))
+}
+
+// a repository failing its first creates, then holding creates until released
+type blockingRepository struct {
+	shared.Repository
+	failures int
+	entered  chan struct{}
+	release  chan struct{}
+}
+
+func (r *blockingRepository) Create(provider shared.DataProvider, ctx context.Context) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("connection reset")
+	}
+	if r.entered != nil {
+		r.entered <- struct{}{}
+		<-r.release
+	}
+	return r.Repository.Create(provider, ctx)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+)
+
+// Returns the management chain of a user as a list response, i.e. GET /Users/{id}/managers?attributes=displayName,
+// the manager of the user first and the top of the chain last, for approval workflows. The chain follows the
+// manager attribute of the enterprise extension up to scim.protocol.managerChainDepth managers; when a cycle, a
+// manager that does not exist or the depth limit ends it, a Warning header says so.
+func GetUserManagersHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+	sch := server.InternalSchema(shared.UserUrn)
+
+	id := r.Param("resourceId")
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+
+	var sr shared.SearchRequest
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		sr, err = ParseQuery(r, server, sch, false)
+		return
+	})
+	ErrorCheck(err)
+
+	var managers []shared.DataProvider
+	if server.AccessController().CanRead(shared.UserResourceType, shared.EnterpriseUrn+".manager", ctx) {
+		var chain *shared.ManagerChain
+		err = traceStep(server, ctx, "resolveManagerChain", func(ctx context.Context) (err error) {
+			chain, err = shared.ResolveManagerChain(server.Repository(shared.UserResourceType), id,
+				server.Property().GetInt("scim.protocol.managerChainDepth"), ctx)
+			return
+		})
+		ErrorCheck(err)
+		managers = chain.Managers
+		if warning := chain.Warnings().Header(); len(warning) > 0 {
+			ri.Header("Warning", warning)
+		}
+	} else {
+		managers = []shared.DataProvider{}
+	}
+
+	lr := &shared.ListResponse{
+		Schemas:      []string{shared.ListResponseUrn},
+		TotalResults: len(managers),
+		ItemsPerPage: len(managers),
+		StartIndex:   1,
+		Resources:    managers,
+	}
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, lr, sch, ctx), sch, sr.Attributes, sr.ExcludedAttributes)
+		return
+	})
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(json)
+	return
+}
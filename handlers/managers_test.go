@@ -0,0 +1,64 @@
+package handlers_test
+
+import (
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetUserManagersHandler(t *testing.T) {
+	cfg := testConfig()
+	cfg.Schemas.Extensions = []config.Extension{{ResourceType: shared.UserResourceType, Schema: "../resources/schemas/enterprise_user.json"}}
+	cfg.Protocol.ManagerChainDepth = 2
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	create := func(userName, managerId string) string {
+		body := `{"schemas": ["` + shared.UserUrn + `", "` + shared.EnterpriseUrn + `"], "userName": "` + userName + `"`
+		if len(managerId) > 0 {
+			body += `, "` + shared.EnterpriseUrn + `": {"manager": {"value": "` + managerId + `"}}`
+		}
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", body+"}", nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)["id"].(string)
+	}
+	managers := func(id, query string) (*httptest.ResponseRecorder, []string) {
+		rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/"+id+"/managers"+query, nil, nil)
+		require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+		userNames := make([]string, 0)
+		for _, each := range scimtest.Decode(t, rw)["Resources"].([]interface{}) {
+			userNames = append(userNames, each.(map[string]interface{})["userName"].(string))
+		}
+		return rw, userNames
+	}
+	ceo := create("ceo", "")
+	cto := create("cto", ceo)
+	lead := create("lead", cto)
+	dev := create("dev", lead)
+
+	// the manager first, the top of the chain last
+	rw, userNames := managers(cto, "?attributes=userName")
+	assert.Equal(t, []string{"ceo"}, userNames)
+	assert.Empty(t, rw.Header().Get("Warning"))
+	assert.NotContains(t, rw.Body.String(), `"meta"`)
+
+	// chains longer than the depth limit are cut short, with a warning
+	rw, userNames = managers(dev, "")
+	assert.Equal(t, []string{"lead", "cto"}, userNames)
+	assert.NotEmpty(t, rw.Header().Get("Warning"))
+
+	// so are those ending at a manager that does not exist
+	rw, userNames = managers(create("intern", "gone"), "")
+	assert.Empty(t, userNames)
+	assert.Contains(t, rw.Header().Get("Warning"), "gone")
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/missing/managers", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rw.Code, rw.Body.String())
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+	"strconv"
+)
+
+// Returns a page of the members of a group as a list response, i.e. GET /Groups/{id}/members?startIndex=1&count=100,
+// so that large groups need not be transferred in full. With transitive=true, members of nested groups are
+// resolved to a flat list of non group members.
+func GetGroupMembersHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+
+	id := r.Param("resourceId")
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+
+	startIndex, count, err := parseMembersPage(r, server, ctx)
+	ErrorCheck(err)
+
+	var members []interface{}
+	var total int
+	if server.AccessController().CanRead(shared.GroupResourceType, "members", ctx) {
+		repo := server.Repository(shared.GroupResourceType)
+		if transitive, _ := strconv.ParseBool(r.Param("transitive")); transitive {
+			err = traceStep(server, ctx, "expandMembers", func(ctx context.Context) (err error) {
+				members, err = shared.ExpandMembers(repo, id, ctx)
+				return
+			})
+			ErrorCheck(err)
+			total = len(members)
+			members = shared.SliceValues(members, startIndex, count)
+		} else {
+			err = traceStep(server, ctx, "repository.getSlice", func(ctx context.Context) (err error) {
+				members, total, err = shared.SliceAttribute(repo, id, "members", startIndex, count, ctx)
+				return
+			})
+			ErrorCheck(err)
+		}
+	} else {
+		members = []interface{}{}
+	}
+
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"schemas":      []string{shared.ListResponseUrn},
+		"totalResults": total,
+		"itemsPerPage": len(members),
+		"startIndex":   startIndex,
+		"Resources":    members,
+	})
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(jsonBytes)
+	return
+}
+
+// Applies the membership delta of the request body to a group, i.e. POST /Groups/{id}/members with
+// {"add": [{"value": "..."}], "remove": ["..."]}, and responds with 204 No Content carrying the new version as
+// ETag. See ApplyMembershipDelta.
+func PatchGroupMembersHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+	id, version := ParseIdAndVersion(r)
+
+	var delta shared.MembershipDelta
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) error {
+		body, err := r.Body()
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(body, &delta); err != nil {
+			return shared.Error.InvalidParam("members delta", "JSON object with add and remove", err.Error())
+		}
+		return nil
+	})
+	ErrorCheck(err)
+
+	newVersion, err := ApplyMembershipDelta(server, id, version, delta, ctx)
+	ErrorCheck(err)
+
+	if len(newVersion) > 0 {
+		ri.ETagHeader(newVersion)
+	}
+	ri.Status(http.StatusNoContent)
+	return
+}
+
+// Apply a computed membership delta to the group of the id as a single write, so that the group changes
+// version once and a publishing repository emits a single change event, instead of a PATCH per member. Meant
+// for sync engines reconciling large groups, thousands of adds and removes per cycle. Returns the new version
+// of the group; none in dry run and queued mode.
+//
+// Where a PATCH adding and removing members would be applied in place, see MemberPatcher, so is the delta.
+// Otherwise the group is read, the delta applied to its members and the result written by a PATCH replacing
+// the members, conditional on the version read, which runs the patch pipeline and hooks as usual. Without a
+// version, the read and write are repeated up to scim.protocol.patchRetries times when the group changes in
+// between; a version, checked like If-Match with shared.CheckIfMatch, that is not current fails.
+func ApplyMembershipDelta(server ScimServer, id, version string, delta shared.MembershipDelta, ctx context.Context) (newVersion string, err error) {
+	defer recoverError(&err)
+	if _, ok := ctx.Value(shared.RequestType{}).(int); !ok {
+		ctx = context.WithValue(ctx, shared.RequestType{}, shared.PatchGroup)
+	}
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+	ErrorCheck(server.OperationToggles().Check(shared.GroupResourceType, "patch"))
+	ErrorCheck(delta.Validate())
+	if delta.IsEmpty() {
+		panic(shared.Error.InvalidParam("members delta", "members to add or remove", "none"))
+	}
+	sch := server.InternalSchema(shared.GroupUrn)
+	repo := server.Repository(shared.GroupResourceType)
+	if len(version) > 0 {
+		version = ifMatchVersion(server, ctx, repo, id, version)
+	}
+
+	ops := make([]shared.Patch, 0, 2)
+	if len(delta.Add) > 0 {
+		ops = append(ops, shared.Patch{Op: shared.Add, Path: "members"})
+	}
+	if len(delta.Remove) > 0 {
+		ops = append(ops, shared.Patch{Op: shared.Remove, Path: "members"})
+	}
+	err = traceStep(server, ctx, "authorize", func(ctx context.Context) (err error) {
+		for _, patch := range ops {
+			err = shared.ValidatePatchWritable(patch, shared.GroupResourceType, server.AccessController(), ctx)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	ErrorCheck(err)
+
+	// the regular path counts the attribute usage of the PATCH it runs
+	if patcher, ok := inPlaceMemberPatcher(server, ctx, repo, shared.GroupResourceType); ok {
+		newVersion = applyMemberPatch(server, ctx, patcher, sch, id, version, delta.Add, delta.Remove)
+		for _, patch := range ops {
+			metrics(server).PatchOps.Inc(shared.GroupResourceType, patch.Op)
+		}
+		server.AttributeUsage().RecordPatch(shared.GroupResourceType, ops, sch)
+		return
+	}
+
+	retries := server.Property().GetInt("scim.protocol.patchRetries")
+	for attempt := 1; ; attempt++ {
+		var group shared.DataProvider
+		err = traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+			group, err = repo.Get(id, version, ctx)
+			return
+		})
+		ErrorCheck(err)
+		read := version
+		if len(read) == 0 {
+			meta, _ := group.GetData()["meta"].(map[string]interface{})
+			read, _ = meta["version"].(string)
+		}
+		members, _ := group.GetData()["members"].([]interface{})
+
+		body, err := json.Marshal(shared.Modification{
+			Schemas: []string{shared.PatchOpUrn},
+			Ops:     []shared.Patch{{Op: shared.Replace, Path: "members", Value: delta.Apply(members)}},
+		})
+		ErrorCheck(err)
+		req := BulkWebRequest{
+			target:  server.Property().GetString("scim.protocol.uri.group") + "/" + id,
+			method:  http.MethodPatch,
+			headers: map[string]string{"If-Match": read},
+			params:  map[string]string{"resourceId": id},
+			body:    body,
+		}
+		ri, err := runHandler(PatchGroupHandler, req, server, ctx)
+		if err == nil {
+			return ri.GetHeader("ETag"), nil
+		}
+		if _, ok := err.(*shared.ResourceNotFoundError); !ok || len(version) > 0 {
+			return "", err
+		}
+		// the group may as well be gone
+		_, getErr := repo.Get(id, "", ctx)
+		ErrorCheck(getErr)
+		if attempt > retries {
+			return "", shared.Error.VersionConflict(id, attempt)
+		}
+	}
+}
+
+func parseMembersPage(r shared.WebRequest, server ScimServer, ctx context.Context) (startIndex, count int, err error) {
+	startIndex = 1
+	count = server.Property().GetInt("scim.protocol.itemsPerPage")
+	if v := r.Param("startIndex"); len(v) > 0 {
+		if i, err := strconv.Atoi(v); err != nil {
+			return 0, 0, shared.Error.InvalidParam("startIndex", "1-based integer", v)
+		} else if i > 1 {
+			startIndex = i
+		}
+	}
+	if v := r.Param("count"); len(v) > 0 {
+		if i, err := strconv.Atoi(v); err != nil {
+			return 0, 0, shared.Error.InvalidParam("count", "non-negative integer", v)
+		} else if i < 0 {
+			count = 0
+		} else {
+			count = i
+		}
+	}
+	if maxResults := filterMaxResults(server, ctx); maxResults > 0 && count > maxResults {
+		count = maxResults
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApplyMembershipDelta(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+	racing := &racingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{}), attribute: "displayName"}
+	events := make([]*shared.ChangeEvent, 0)
+	groups := shared.NewPublishingRepository(racing, shared.GroupResourceType, sch, shared.PublisherFunc(func(event *shared.ChangeEvent, ctx context.Context) error {
+		events = append(events, event)
+		return nil
+	}), nil, nil)
+	cfg := testConfig()
+	cfg.Features.MembershipDelta = true
+	cfg.Protocol.PatchRetries = 1
+	server, err := config.NewServer(config.WithConfig(cfg), config.WithSchema(shared.GroupResourceType, sch), config.WithRepository(shared.GroupResourceType, groups))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	members := func(id string) []string {
+		dp, err := groups.Get(id, "", context.Background())
+		require.Nil(t, err)
+		values := make([]string, 0)
+		for _, member := range dp.GetData()["members"].([]interface{}) {
+			values = append(values, member.(map[string]interface{})["value"].(string))
+		}
+		return values
+	}
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "sync",
+		"members": [{"value": "a"}, {"value": "b"}, {"value": "c"}]
+	}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	// one write and one event for the whole delta
+	events = events[:0]
+	adds := make([]string, 0)
+	for i := 0; i < 200; i++ {
+		adds = append(adds, fmt.Sprintf(`{"value": "u%d"}`, i))
+	}
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups/"+id+"/members", `{"add": [`+strings.Join(adds, ", ")+`], "remove": ["a", "c"]}`, nil)
+	require.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.NotEmpty(t, rw.Header().Get("ETag"))
+	assert.Len(t, members(id), 201)
+	assert.Equal(t, "b", members(id)[0])
+	require.Len(t, events, 1)
+	assert.Equal(t, shared.ChangeUpdate, events[0].Op)
+
+	// the delta is applied again to the group a concurrent write left behind
+	racing.races = 1
+	version, err := handlers.ApplyMembershipDelta(server, id, "", shared.MembershipDelta{
+		Add:    []interface{}{map[string]interface{}{"value": "d"}},
+		Remove: []string{"b"},
+	}, context.Background())
+	require.Nil(t, err)
+	stored, err := groups.Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, stored.GetData()["meta"].(map[string]interface{})["version"], version)
+	assert.Equal(t, "concurrent", stored.GetData()["displayName"])
+	assert.NotContains(t, members(id), "b")
+	assert.Contains(t, members(id), "d")
+	assert.Len(t, events, 2)
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups/"+id+"/members", `{"remove": ["d"]}`, map[string]string{"If-Match": `W/"stale"`})
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups/"+id+"/members", `{"add": [{"display": "no value"}]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups/"+id+"/members", `{}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+	assert.Contains(t, members(id), "d")
+}
+
+func TestPatchGroupMembersHandler_IfMatch(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.MembershipDelta = true
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "sync",
+		"members": [{"value": "a"}]
+	}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+	version := rw.Header().Get("ETag")
+
+	for _, test := range []struct {
+		ifMatch string
+		member  string
+		status  int
+	}{
+		{`W/"stale"`, "b", http.StatusPreconditionFailed},
+		{`W/"stale", ` + version, "b", http.StatusNoContent},
+		{"*", "c", http.StatusNoContent},
+	} {
+		rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Groups/"+id+"/members", `{"add": [{"value": "`+test.member+`"}]}`, map[string]string{"If-Match": test.ifMatch})
+		assert.Equal(t, test.status, rw.Code, test.ifMatch)
+	}
+	group, err := server.Repository(shared.GroupResourceType).Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.Len(t, group.GetData()["members"], 3)
+}
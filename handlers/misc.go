@@ -11,21 +11,36 @@ import (
 
 func RootQueryHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
 	ri = newResponse()
+	// the root endpoint searches users and groups alike
+	ErrorCheck(server.OperationToggles().Check(shared.UserResourceType, "query"))
+	ErrorCheck(server.OperationToggles().Check(shared.GroupResourceType, "query"))
 	sch := server.InternalSchema("")
 
-	attributes, excludedAttributes := ParseInclusionAndExclusionAttributes(r)
-
-	sr, err := ParseSearchRequest(r, server)
-	ErrorCheck(err)
-
-	err = sr.Validate(sch)
+	var sr shared.SearchRequest
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		sr, err = ParseQuery(r, server, sch, true)
+		return
+	})
 	ErrorCheck(err)
 
 	repo := server.Repository("")
-	lr, err := repo.Search(sr)
+	var lr *shared.ListResponse
+	err = traceStep(server, ctx, "repository.search", func(ctx context.Context) (err error) {
+		lr, err = searchWithMaxResults(server, repo, sr, ctx)
+		return
+	})
 	ErrorCheck(err)
+	// counted by the resource types whose schema the paths resolve against
+	for resourceType, urn := range map[string]string{shared.UserResourceType: shared.UserUrn, shared.GroupResourceType: shared.GroupUrn} {
+		server.AttributeUsage().RecordRead(resourceType, sr.Attributes, server.InternalSchema(urn))
+		server.AttributeUsage().RecordFilter(resourceType, sr.Filter, server.InternalSchema(urn))
+	}
 
-	jsonBytes, err := server.MarshalJSON(lr, sch, attributes, excludedAttributes)
+	var jsonBytes []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		jsonBytes, err = server.MarshalJSON(redact(server, lr, sch, ctx), sch, sr.Attributes, sr.ExcludedAttributes)
+		return
+	})
 	ErrorCheck(err)
 
 	ri.Status(http.StatusOK)
@@ -50,15 +65,26 @@ func BulkHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (r
 	err = bulkRequest.Validate(server.Property())
 	ErrorCheck(err)
 
+	opReqs := make([]*BulkWebRequest, 0, len(bulkRequest.Operations))
+	for _, op := range bulkRequest.Operations {
+		opReq := &BulkWebRequest{}
+		opReq.Populate(op, server.Property())
+		opReqs = append(opReqs, opReq)
+	}
+	checked := checkBulkUniqueness(opReqs, userUri, groupUri, server, ctx)
+
 	errCount := 0
 	allResps := make([]*shared.BulkRespOp, 0, len(bulkRequest.Operations))
-	for _, op := range bulkRequest.Operations {
+	for i, op := range bulkRequest.Operations {
 		if errCount > bulkRequest.FailOnErrors {
 			break
 		}
 
-		opReq := &BulkWebRequest{}
-		opReq.Populate(op, server.Property())
+		opReq := opReqs[i]
+		opCtx := ctx
+		if outcome, ok := checked[i]; ok {
+			opCtx = shared.WithCheckedUniqueness(ctx, outcome.subj, outcome.sch, outcome.err)
+		}
 
 		var handler EndpointHandler
 		switch opReq.Method() {
@@ -94,10 +120,13 @@ func BulkHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (r
 			panic(shared.Error.Text("No handler found for bulk operation"))
 		}
 
-		opRi := handler(opReq, server, ctx)
+		opRi := ErrorRecovery(handler)(opReq, server, opCtx)
 		if opRi.statusCode > 299 {
 			errCount++
 		}
+		if etagsOff(server) {
+			opRi.headers.Del("ETag")
+		}
 
 		opResp := &shared.BulkRespOp{}
 		opResp.Populate(op, opRi)
@@ -114,3 +143,52 @@ func BulkHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (r
 	ri.responseBody = respBody
 	return
 }
+
+// Validate the uniqueness of all creates in the bulk request up front, one batch per resource type, instead of
+// searching the repository once per operation. Returns the outcome by operation index; operations whose body
+// cannot be read are left out and validated by their handler as usual.
+func checkBulkUniqueness(opReqs []*BulkWebRequest, userUri, groupUri string, server ScimServer, ctx context.Context) map[int]bulkUniqueness {
+	checked := make(map[int]bulkUniqueness)
+	for _, each := range []struct {
+		uri          string
+		urn          string
+		resourceType string
+	}{
+		{userUri, shared.UserUrn, shared.UserResourceType},
+		{groupUri, shared.GroupUrn, shared.GroupResourceType},
+	} {
+		sch := server.InternalSchema(each.urn)
+		indexes := make([]int, 0)
+		subjs := make([]*shared.Resource, 0)
+		for i, opReq := range opReqs {
+			if opReq.Method() != http.MethodPost || opReq.Target() != each.uri {
+				continue
+			}
+			resource, err := ParseBodyAsResource(opReq)
+			if err != nil {
+				continue
+			}
+			if err = server.CorrectCase(resource, sch, ctx); err != nil {
+				continue
+			}
+			indexes = append(indexes, i)
+			subjs = append(subjs, resource)
+		}
+		if len(subjs) == 0 {
+			continue
+		}
+
+		errs := server.ValidateUniquenessBatch(subjs, sch, server.Repository(each.resourceType), ctx)
+		for j, i := range indexes {
+			checked[i] = bulkUniqueness{subjs[j], sch, errs[j]}
+		}
+	}
+	return checked
+}
+
+// the outcome of the uniqueness check of a create in a bulk request, for the resource it was computed for
+type bulkUniqueness struct {
+	subj *shared.Resource
+	sch  *shared.Schema
+	err  error
+}
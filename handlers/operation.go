@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+)
+
+func GetOperationByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+
+	store := server.OperationStore()
+	if store == nil {
+		panic(shared.Error.ResourceNotFound(r.Param("resourceId"), ""))
+	}
+
+	op, err := store.Get(r.Param("resourceId"))
+	ErrorCheck(err)
+	jsonBytes, err := json.Marshal(op)
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(jsonBytes)
+	return
+}
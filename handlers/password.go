@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+)
+
+// Changes the password of a user, i.e. POST /Users/{id}/.password with {"oldPassword": "...", "newPassword": "..."},
+// after verifying the old password. The new password goes through the before update hooks like any update,
+// which is where a PasswordPolicy is enforced, and the password change hooks run once it is stored. Responds
+// with 204 No Content; a wrong old password is answered with 403 Forbidden.
+func ChangeUserPasswordHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	ri = newResponse()
+	repo := server.Repository(shared.UserResourceType)
+
+	id, version := ParseIdAndVersion(r)
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+	if len(version) > 0 {
+		version = ifMatchVersion(server, ctx, repo, id, version)
+	}
+
+	var change shared.PasswordChange
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) error {
+		raw, err := r.Body()
+		if err != nil {
+			return shared.Error.Text("failed to read request: %s", err.Error())
+		}
+		if err := json.Unmarshal(raw, &change); err != nil {
+			return shared.Error.InvalidParam("request body", "json conforming to password change syntax", err.Error())
+		}
+		return change.Validate()
+	})
+	ErrorCheck(err)
+
+	if !server.AccessController().CanWrite(shared.UserResourceType, "password", ctx) {
+		panic(shared.Error.Forbidden("password"))
+	}
+
+	var reference shared.DataProvider
+	err = traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+		reference, err = repo.Get(id, version, ctx)
+		return
+	})
+	ErrorCheck(err)
+
+	resource, err := shared.ApplyPasswordChange(reference, change)
+	if err != nil {
+		logger(server).Info("rejected password change", shared.LogFields(ctx, "reason", "old password mismatch")...)
+	}
+	ErrorCheck(err)
+
+	err = traceStep(server, ctx, "assignReadOnlyValue", func(ctx context.Context) error {
+		return server.AssignReadOnlyValue(resource, ctx)
+	})
+	ErrorCheck(err)
+
+	err = traceStep(server, ctx, "hooks.beforeUpdate", func(ctx context.Context) error {
+		return server.Hooks().RunUpdate(true, shared.UserResourceType, resource, shared.AsResource(reference), ctx)
+	})
+	ErrorCheck(err)
+
+	if respondDryRun(server, ctx, ri, nil, nil) {
+		return
+	}
+
+	if enqueueOperation(server, ctx, ri, &shared.Operation{
+		Kind:         shared.OperationUpdate,
+		ResourceType: shared.UserResourceType,
+		ResourceId:   id,
+		Version:      version,
+		Resource:     resource,
+	}) {
+		return
+	}
+
+	err = traceStep(server, ctx, "repository.update", func(ctx context.Context) error {
+		return repo.Update(id, version, resource, ctx)
+	})
+	ErrorCheck(err)
+	runAfterHook(server, ctx, "hooks.afterUpdate", func(ctx context.Context) error {
+		return server.Hooks().RunUpdate(false, shared.UserResourceType, resource, shared.AsResource(reference), ctx)
+	})
+	runAfterHook(server, ctx, "hooks.passwordChange", func(ctx context.Context) error {
+		return server.Hooks().RunPasswordChange(id, ctx)
+	})
+	logger(server).Info("changed password", shared.LogFields(ctx)...)
+
+	if newVersion, ok := resource.GetData()["meta"].(map[string]interface{})["version"].(string); ok && len(newVersion) > 0 {
+		ri.ETagHeader(newVersion)
+	}
+	ri.Status(http.StatusNoContent)
+	return
+}
@@ -0,0 +1,61 @@
+package handlers_test
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestChangeUserPasswordHandler(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.ChangePassword = true
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	repo := server.Repository(shared.UserResourceType)
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"david","password":"old secret"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.NotContains(t, rw.Body.String(), "old secret")
+	id := scimtest.Decode(t, rw)["id"].(string)
+	version := rw.Header().Get("ETag")
+
+	// only the hash of the password is stored
+	stored, err := repo.Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.NotEqual(t, "old secret", stored.GetData()["password"])
+	assert.True(t, shared.VerifyPassword(stored.(*shared.Resource), "old secret"))
+
+	for _, test := range []struct {
+		body    string
+		ifMatch string
+		status  int
+	}{
+		{`{"oldPassword": "wrong", "newPassword": "new secret"}`, "", http.StatusForbidden},
+		{`{"oldPassword": "old secret"}`, "", http.StatusBadRequest},
+		{`{"oldPassword": "old secret", "newPassword": "new secret"}`, `W/"stale"`, http.StatusPreconditionFailed},
+	} {
+		rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/"+id+"/.password", test.body, map[string]string{"If-Match": test.ifMatch})
+		assert.Equal(t, test.status, rw.Code, test.body+" "+rw.Body.String())
+	}
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/missing/.password", `{"oldPassword": "old secret", "newPassword": "new secret"}`, nil)
+	assert.Equal(t, http.StatusNotFound, rw.Code, rw.Body.String())
+
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users/"+id+"/.password", `{"oldPassword": "old secret", "newPassword": "new secret"}`, map[string]string{"If-Match": version})
+	require.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.NotEmpty(t, rw.Header().Get("ETag"))
+	stored, err = repo.Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.True(t, shared.VerifyPassword(stored.(*shared.Resource), "new secret"))
+	assert.False(t, shared.VerifyPassword(stored.(*shared.Resource), "old secret"))
+
+	// If-Match is only heeded by posts to a resource, creates are no precondition
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"mary"}`, map[string]string{"If-Match": `W/"stale"`})
+	assert.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+}
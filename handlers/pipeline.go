@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	. "github.com/davidiamyou/go-scim/shared"
+	"strings"
+	"sync"
+)
+
+// Operations whose resource a pipeline validates
+const (
+	CreateOperation  = "create"
+	ReplaceOperation = "replace"
+	PatchOperation   = "patch"
+)
+
+// Names of the built-in stages, which are also the names of their trace steps
+const (
+	StageValidateType        = "validateType"
+	StageValidateValues      = "validateValues"
+	StageCorrectCase         = "correctCase"
+	StageEnforcePrimary      = "enforcePrimary"
+	StageApplyReplacePolicy  = "applyReplacePolicy"
+	StageAuthorize           = "authorize"
+	StageDefaults            = "defaults"
+	StageTransform           = "transform"
+	StageValidateRequired    = "validateRequired"
+	StageDetectReplay        = "detectReplay"
+	StageValidateMutability  = "validateMutability"
+	StageValidateUniqueness  = "validateUniqueness"
+	StageAssignReadOnlyValue = "assignReadOnlyValue"
+
+	StageDetectMembershipCycle = "detectMembershipCycle"
+	StageValidateAssignments   = "validateAssignments"
+	StageValidateUnassigned    = "validateUnassigned"
+)
+
+// The resource a pipeline validates and what it is validated against
+type Subject struct {
+	ResourceType string
+	Operation    string
+	Request      WebRequest
+	Schema       *Schema
+	Repository   Repository
+	Resource     *Resource
+	Reference    *Resource // the stored resource, nil on create
+}
+
+// A step of a pipeline. An error aborts the request.
+type Stage interface {
+	Name() string
+	Run(server ScimServer, subj *Subject, ctx context.Context) error
+}
+
+// Adapts a function to a Stage with the name
+func NewStage(name string, run func(server ScimServer, subj *Subject, ctx context.Context) error) Stage {
+	return &funcStage{name: name, run: run}
+}
+
+type funcStage struct {
+	name string
+	run  func(server ScimServer, subj *Subject, ctx context.Context) error
+}
+
+func (s *funcStage) Name() string { return s.name }
+
+func (s *funcStage) Run(server ScimServer, subj *Subject, ctx context.Context) error {
+	return s.run(server, subj, ctx)
+}
+
+// The built-in stages, by name
+var (
+	ValidateTypeStage = NewStage(StageValidateType, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.ValidateType(subj.Resource, subj.Schema, ctx)
+	})
+	// the validators the server registers by attribute path, see shared.Validators
+	ValidateValuesStage = NewStage(StageValidateValues, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.Validators().Apply(subj.ResourceType, subj.Resource, subj.Schema, ctx)
+	})
+	CorrectCaseStage = NewStage(StageCorrectCase, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.CorrectCase(subj.Resource, subj.Schema, ctx)
+	})
+	// patches enforce the primary flag per operation, see shared.ApplyPatch
+	EnforcePrimaryStage = NewStage(StageEnforcePrimary, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return EnforcePrimary(subj.Resource, subj.Reference, subj.Schema)
+	})
+	ApplyReplacePolicyStage = NewStage(StageApplyReplacePolicy, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		if subj.Reference == nil {
+			return nil
+		}
+		return server.ApplyReplacePolicy(subj.Resource, subj.Reference, subj.Schema, ctx)
+	})
+	AuthorizeStage = NewStage(StageAuthorize, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return ValidateWritable(subj.Resource, subj.Reference, subj.Schema, server.AccessController(), ctx)
+	})
+	DefaultsStage = NewStage(StageDefaults, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.Defaults().Apply(subj.ResourceType, subj.Resource, subj.Schema, subj.Request, ctx)
+	})
+	TransformStage = NewStage(StageTransform, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.Transformers().Apply(subj.ResourceType, subj.Resource, subj.Schema, ctx)
+	})
+	ValidateRequiredStage = NewStage(StageValidateRequired, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.ValidateRequired(subj.Resource, subj.Schema, ctx)
+	})
+	DetectReplayStage = NewStage(StageDetectReplay, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		key := IdempotencyKey(subj.Request, subj.ResourceType, ctx)
+		return DetectReplay(subj.Resource, subj.Repository, server.IdempotencyCache(), key, ctx)
+	})
+	ValidateMutabilityStage = NewStage(StageValidateMutability, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		if subj.Reference == nil {
+			return nil
+		}
+		return server.ValidateMutability(subj.Resource, subj.Reference, subj.Schema, ctx)
+	})
+	ValidateUniquenessStage = NewStage(StageValidateUniqueness, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.ValidateUniqueness(subj.Resource, subj.Schema, subj.Repository, ctx)
+	})
+	AssignReadOnlyValueStage = NewStage(StageAssignReadOnlyValue, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		return server.AssignReadOnlyValue(subj.Resource, ctx)
+	})
+	// not part of the default pipelines, i.e. DefaultPipeline(PatchOperation).InsertBefore(StageValidateUniqueness,
+	// DetectMembershipCycleStage) for groups. Groups being created are no member of any group yet.
+	DetectMembershipCycleStage = NewStage(StageDetectMembershipCycle, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		if subj.ResourceType != GroupResourceType || subj.Reference == nil {
+			return nil
+		}
+		members, _ := subj.Resource.Complex["members"].([]interface{})
+		return DetectMembershipCycle(subj.Repository, subj.Reference.GetId(), members, ctx)
+	})
+	// not part of the default pipelines either, for servers serving roles and entitlements: the roles and
+	// entitlements of users must refer to existing ones, see shared.ValidateAssignments
+	ValidateAssignmentsStage = NewStage(StageValidateAssignments, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		if subj.ResourceType != UserResourceType {
+			return nil
+		}
+		return ValidateAssignments(subj.Resource, server.Repository(RoleResourceType), server.Repository(EntitlementResourceType), ctx)
+	})
+	// its counterpart for roles and entitlements: one users are assigned keeps its value, see
+	// shared.ValidateUnassigned
+	ValidateUnassignedStage = NewStage(StageValidateUnassigned, func(server ScimServer, subj *Subject, ctx context.Context) error {
+		if (subj.ResourceType != RoleResourceType && subj.ResourceType != EntitlementResourceType) || subj.Reference == nil {
+			return nil
+		}
+		previous, _ := subj.Reference.Complex["value"].(string)
+		value, _ := subj.Resource.Complex["value"].(string)
+		if strings.EqualFold(previous, value) {
+			return nil
+		}
+		return ValidateUnassigned(subj.ResourceType, subj.Reference, server.Repository(UserResourceType), ctx)
+	})
+)
+
+// An ordered list of stages, run by the create, replace and patch handlers after parsing the request and
+// before the hooks. Stages are traced as steps of their name.
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: append([]Stage{}, stages...)}
+}
+
+// The pipeline the handlers run for the operation unless configured otherwise. Transformers run first, so that
+// what they derive is validated like the rest of the resource. Patches are authorized per operation before they
+// are applied, so the patch pipeline has no authorize stage.
+func DefaultPipeline(operation string) *Pipeline {
+	switch operation {
+	case CreateOperation:
+		return NewPipeline(TransformStage, ValidateTypeStage, ValidateValuesStage, CorrectCaseStage, EnforcePrimaryStage,
+			AuthorizeStage, DefaultsStage, ValidateRequiredStage, DetectReplayStage, ValidateUniquenessStage,
+			AssignReadOnlyValueStage)
+	case ReplaceOperation:
+		return NewPipeline(TransformStage, ValidateTypeStage, ValidateValuesStage, CorrectCaseStage,
+			ApplyReplacePolicyStage, EnforcePrimaryStage, AuthorizeStage, ValidateRequiredStage, ValidateMutabilityStage,
+			ValidateUniquenessStage, AssignReadOnlyValueStage)
+	case PatchOperation:
+		return NewPipeline(TransformStage, ValidateTypeStage, ValidateValuesStage, CorrectCaseStage,
+			ValidateRequiredStage, ValidateMutabilityStage, ValidateUniquenessStage, AssignReadOnlyValueStage)
+	default:
+		return NewPipeline()
+	}
+}
+
+// The names of the stages in order
+func (p *Pipeline) Names() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, stage := range p.stages {
+		names = append(names, stage.Name())
+	}
+	return names
+}
+
+func (p *Pipeline) index(name string) int {
+	for i, stage := range p.stages {
+		if stage.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add the stages at the end
+func (p *Pipeline) Append(stages ...Stage) *Pipeline {
+	p.stages = append(p.stages, stages...)
+	return p
+}
+
+// Add the stages before the named one, or at the end when the pipeline has no stage of the name
+func (p *Pipeline) InsertBefore(name string, stages ...Stage) *Pipeline {
+	i := p.index(name)
+	if i < 0 {
+		return p.Append(stages...)
+	}
+	p.stages = append(p.stages[:i], append(append([]Stage{}, stages...), p.stages[i:]...)...)
+	return p
+}
+
+// Add the stages after the named one, or at the end when the pipeline has no stage of the name
+func (p *Pipeline) InsertAfter(name string, stages ...Stage) *Pipeline {
+	i := p.index(name)
+	if i < 0 {
+		return p.Append(stages...)
+	}
+	p.stages = append(p.stages[:i+1], append(append([]Stage{}, stages...), p.stages[i+1:]...)...)
+	return p
+}
+
+// Take out the named stages, i.e. validateUniqueness for read only mirrors of another directory
+func (p *Pipeline) Remove(names ...string) *Pipeline {
+	for _, name := range names {
+		if i := p.index(name); i >= 0 {
+			p.stages = append(p.stages[:i], p.stages[i+1:]...)
+		}
+	}
+	return p
+}
+
+// Put the stage in place of the named one, or at the end when the pipeline has no stage of the name
+func (p *Pipeline) Replace(name string, stage Stage) *Pipeline {
+	i := p.index(name)
+	if i < 0 {
+		return p.Append(stage)
+	}
+	p.stages[i] = stage
+	return p
+}
+
+// Run the stages in order, stopping at the first error
+func (p *Pipeline) Run(server ScimServer, subj *Subject, ctx context.Context) error {
+	for _, stage := range p.stages {
+		err := traceStep(server, ctx, stage.Name(), func(ctx context.Context) error {
+			return stage.Run(server, subj, ctx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registry of pipelines per resource type and operation, falling back to DefaultPipeline
+type Pipelines struct {
+	sync.RWMutex
+	byKey map[string]*Pipeline
+}
+
+func NewPipelines() *Pipelines {
+	return &Pipelines{byKey: make(map[string]*Pipeline)}
+}
+
+// Use the pipeline for the operation on the resource type. The pipeline must not be changed afterwards.
+func (p *Pipelines) Set(resourceType, operation string, pipeline *Pipeline) *Pipelines {
+	p.Lock()
+	defer p.Unlock()
+	p.byKey[resourceType+":"+operation] = pipeline
+	return p
+}
+
+// Whether a pipeline is set for the operation on the resource type
+func (p *Pipelines) Has(resourceType, operation string) bool {
+	if p == nil {
+		return false
+	}
+	p.RLock()
+	defer p.RUnlock()
+	_, ok := p.byKey[resourceType+":"+operation]
+	return ok
+}
+
+// The pipeline of the operation on the resource type
+func (p *Pipelines) Get(resourceType, operation string) *Pipeline {
+	if p != nil {
+		p.RLock()
+		pipeline, ok := p.byKey[resourceType+":"+operation]
+		p.RUnlock()
+		if ok {
+			return pipeline
+		}
+	}
+	return DefaultPipeline(operation)
+}
@@ -10,15 +10,23 @@ func GetAllResourceTypeHandler(r shared.WebRequest, server ScimServer, ctx conte
 	ri = newResponse()
 
 	repo := server.Repository(shared.ResourceTypeResourceType)
-	userResourceType, err := repo.Get(shared.UserResourceType, "")
+	userResourceType, err := repo.Get(shared.UserResourceType, "", ctx)
 	ErrorCheck(err)
-	groupResourceType, err := repo.Get(shared.GroupResourceType, "")
+	groupResourceType, err := repo.Get(shared.GroupResourceType, "", ctx)
 	ErrorCheck(err)
 
-	jsonBytes, err := server.MarshalJSON([]interface{}{
+	resourceTypes := []interface{}{
 		userResourceType.GetData(),
 		groupResourceType.GetData(),
-	}, nil, nil, nil)
+	}
+	// roles and entitlements are only described by servers serving them
+	for _, id := range []string{shared.RoleResourceType, shared.EntitlementResourceType} {
+		if dp, err := repo.Get(id, "", ctx); err == nil {
+			resourceTypes = append(resourceTypes, dp.GetData())
+		}
+	}
+
+	jsonBytes, err := server.MarshalJSON(resourceTypes, nil, nil, nil)
 	ErrorCheck(err)
 
 	ri.Status(http.StatusOK)
@@ -0,0 +1,525 @@
+package handlers
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/shared"
+	"net/http"
+)
+
+// What sets the handlers of a resource type apart. Users, groups, roles and entitlements are otherwise served
+// alike: the functions below parse the request, run the pipeline and the hooks of the resource type and
+// write the repository.
+type resourceEndpoint struct {
+	resourceType string
+	urn          string
+	// queries with since are answered with the changes after the watermark, see respondDelta, and list
+	// responses carry the latest watermark
+	delta bool
+	// run after a resource was replaced or patched, given the stored resource it replaced
+	updated func(server ScimServer, ctx context.Context, resource, reference shared.DataProvider)
+	// run before a resource is deleted, an error refuses the delete
+	deleting func(server ScimServer, ctx context.Context, repo shared.Repository, id, version string) error
+}
+
+var (
+	userEndpoint  = &resourceEndpoint{resourceType: shared.UserResourceType, urn: shared.UserUrn, delta: true}
+	groupEndpoint = &resourceEndpoint{
+		resourceType: shared.GroupResourceType,
+		urn:          shared.GroupUrn,
+		delta:        true,
+		updated: func(server ScimServer, ctx context.Context, resource, reference shared.DataProvider) {
+			propagateGroupDisplay(server, ctx, resource, reference.GetData()["displayName"])
+		},
+	}
+	roleEndpoint = &resourceEndpoint{
+		resourceType: shared.RoleResourceType,
+		urn:          shared.RoleUrn,
+		deleting:     refuseAssigned(shared.RoleResourceType),
+	}
+	entitlementEndpoint = &resourceEndpoint{
+		resourceType: shared.EntitlementResourceType,
+		urn:          shared.EntitlementUrn,
+		deleting:     refuseAssigned(shared.EntitlementResourceType),
+	}
+)
+
+// Users assigned a deleted role or entitlement would fail validation on every later write, they are deleted
+// once no user carries their value anymore
+func refuseAssigned(resourceType string) func(server ScimServer, ctx context.Context, repo shared.Repository, id, version string) error {
+	return func(server ScimServer, ctx context.Context, repo shared.Repository, id, version string) error {
+		return traceStep(server, ctx, "validateUnassigned", func(ctx context.Context) error {
+			assigned, err := repo.Get(id, version, ctx)
+			if err != nil {
+				return err
+			}
+			return shared.ValidateUnassigned(resourceType, assigned, server.Repository(shared.UserResourceType), ctx)
+		})
+	}
+}
+
+func createResource(r shared.WebRequest, server ScimServer, ctx context.Context, e *resourceEndpoint) (ri *ResponseInfo) {
+	ri = newResponse()
+	ErrorCheck(server.OperationToggles().Check(e.resourceType, "create"))
+	sch := server.InternalSchema(e.urn)
+
+	var resource *shared.Resource
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		resource, err = ParseBodyAsResource(r)
+		if err != nil {
+			return
+		}
+		return server.CheckUnknownAttributes(resource, sch, ctx)
+	})
+	ErrorCheck(err)
+	recordWrite := server.AttributeUsage().PendingWrite(e.resourceType, resource, sch)
+
+	repo := server.Repository(e.resourceType)
+	err = server.Pipelines().Get(e.resourceType, CreateOperation).Run(server, &Subject{
+		ResourceType: e.resourceType,
+		Operation:    CreateOperation,
+		Request:      r,
+		Schema:       sch,
+		Repository:   repo,
+		Resource:     resource,
+	}, ctx)
+	if r.Header("If-None-Match") != "*" && respondExisting(server, ctx, ri, err, repo, sch) {
+		return
+	}
+	ErrorCheck(err)
+
+	err = traceStep(server, ctx, "hooks.beforeCreate", func(ctx context.Context) error {
+		return server.Hooks().RunCreate(true, e.resourceType, resource, ctx)
+	})
+	ErrorCheck(err)
+
+	if respondDryRun(server, ctx, ri, resource, sch) {
+		return
+	}
+
+	if cache := server.IdempotencyCache(); cache != nil {
+		if key := shared.IdempotencyKey(r, e.resourceType, ctx); len(key) > 0 {
+			cache.Put(key, resource.GetId())
+		}
+	}
+
+	if enqueueOperation(server, ctx, ri, &shared.Operation{
+		Kind:         shared.OperationCreate,
+		ResourceType: e.resourceType,
+		Resource:     resource,
+	}) {
+		recordWrite()
+		return
+	}
+
+	err = traceStep(server, ctx, "repository.create", func(ctx context.Context) error {
+		return repo.Create(resource, ctx)
+	})
+	ErrorCheck(err)
+	recordWrite()
+	runAfterHook(server, ctx, "hooks.afterCreate", func(ctx context.Context) error {
+		return server.Hooks().RunCreate(false, e.resourceType, resource, ctx)
+	})
+
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, resource, sch, ctx), sch, []string{}, []string{})
+		return
+	})
+	ErrorCheck(err)
+
+	location := resource.GetData()["meta"].(map[string]interface{})["location"].(string)
+	version := resource.GetData()["meta"].(map[string]interface{})["version"].(string)
+
+	ri.Status(http.StatusCreated)
+	ri.ScimJsonHeader()
+	if len(version) > 0 {
+		ri.ETagHeader(version)
+	}
+	if len(location) > 0 {
+		ri.LocationHeader(location)
+	}
+	ri.Body(json)
+	return
+}
+
+func patchResource(r shared.WebRequest, server ScimServer, ctx context.Context, e *resourceEndpoint) (ri *ResponseInfo) {
+	ri = newResponse()
+	ErrorCheck(server.OperationToggles().Check(e.resourceType, "patch"))
+	sch := server.InternalSchema(e.urn)
+	repo := server.Repository(e.resourceType)
+
+	id, version := ParseIdAndVersion(r)
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+	if len(version) > 0 {
+		version = ifMatchVersion(server, ctx, repo, id, version)
+	}
+
+	var mod shared.Modification
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		mod, err = ParseModification(r)
+		if err != nil {
+			return
+		}
+		return mod.Validate()
+	})
+	ErrorCheck(err)
+
+	err = traceStep(server, ctx, "authorize", func(ctx context.Context) (err error) {
+		for _, patch := range mod.Ops {
+			err = shared.ValidatePatchWritable(patch, e.resourceType, server.AccessController(), ctx)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	ErrorCheck(err)
+
+	if patchMembers(r, server, ctx, ri, repo, e.resourceType, sch, id, version, mod) {
+		return
+	}
+
+	var resource, reference *shared.Resource
+	// without If-Match, a patch losing the race against a concurrent write is applied again, see retryPatch
+	for attempt := 1; ; attempt++ {
+		var stored shared.DataProvider
+		err = traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+			stored, err = repo.Get(id, version, ctx)
+			return
+		})
+		ErrorCheck(err)
+		expected := patchVersion(server, version, stored)
+		// repositories may hand out the resource they store, which a failed patch must not leave half applied:
+		// the patch is applied to a copy, the snapshot is what mutability and the hooks compare against
+		reference = shared.AsResource(stored).DeepCopy()
+		resource = reference.DeepCopy()
+
+		err = traceStep(server, ctx, "applyPatch", func(ctx context.Context) (err error) {
+			for _, patch := range mod.Ops {
+				err = server.ApplyPatch(patch, resource, sch, ctx)
+				if err != nil {
+					return
+				}
+				metrics(server).PatchOps.Inc(e.resourceType, patch.Op)
+			}
+			return
+		})
+		ErrorCheck(err)
+
+		err = server.Pipelines().Get(e.resourceType, PatchOperation).Run(server, &Subject{
+			ResourceType: e.resourceType,
+			Operation:    PatchOperation,
+			Request:      r,
+			Schema:       sch,
+			Repository:   repo,
+			Resource:     resource,
+			Reference:    reference,
+		}, ctx)
+		ErrorCheck(err)
+
+		err = traceStep(server, ctx, "hooks.beforeUpdate", func(ctx context.Context) error {
+			return server.Hooks().RunUpdate(true, e.resourceType, resource, reference, ctx)
+		})
+		ErrorCheck(err)
+
+		if respondDryRun(server, ctx, ri, resource, sch) {
+			return
+		}
+
+		if enqueueOperation(server, ctx, ri, &shared.Operation{
+			Kind:         shared.OperationUpdate,
+			ResourceType: e.resourceType,
+			ResourceId:   id,
+			Version:      version,
+			Resource:     resource,
+		}) {
+			server.AttributeUsage().RecordPatch(e.resourceType, mod.Ops, sch)
+			return
+		}
+
+		err = traceStep(server, ctx, "repository.update", func(ctx context.Context) error {
+			return repo.Update(id, expected, resource, ctx)
+		})
+		if !retryPatch(server, ctx, repo, id, version, attempt, err) {
+			break
+		}
+	}
+	server.AttributeUsage().RecordPatch(e.resourceType, mod.Ops, sch)
+	runAfterHook(server, ctx, "hooks.afterUpdate", func(ctx context.Context) error {
+		return server.Hooks().RunUpdate(false, e.resourceType, resource, reference, ctx)
+	})
+	if e.updated != nil {
+		e.updated(server, ctx, resource, reference)
+	}
+
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, resource, sch, ctx), sch, []string{}, []string{})
+		return
+	})
+	ErrorCheck(err)
+
+	location := resource.GetData()["meta"].(map[string]interface{})["location"].(string)
+	newVersion := resource.GetData()["meta"].(map[string]interface{})["version"].(string)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	if len(newVersion) > 0 {
+		ri.ETagHeader(newVersion)
+	}
+	if len(location) > 0 {
+		ri.LocationHeader(location)
+	}
+	ri.Body(json)
+	return
+}
+
+func replaceResource(r shared.WebRequest, server ScimServer, ctx context.Context, e *resourceEndpoint) (ri *ResponseInfo) {
+	ri = newResponse()
+	ErrorCheck(server.OperationToggles().Check(e.resourceType, "replace"))
+	sch := server.InternalSchema(e.urn)
+	repo := server.Repository(e.resourceType)
+
+	var resource *shared.Resource
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		resource, err = ParseBodyAsResource(r)
+		if err != nil {
+			return
+		}
+		return server.CheckUnknownAttributes(resource, sch, ctx)
+	})
+	ErrorCheck(err)
+
+	recordWrite := server.AttributeUsage().PendingWrite(e.resourceType, resource, sch)
+
+	id, version := ParseIdAndVersion(r)
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+	if len(version) > 0 {
+		version = ifMatchVersion(server, ctx, repo, id, version)
+	}
+
+	var reference shared.DataProvider
+	err = traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+		reference, err = repo.Get(id, version, ctx)
+		return
+	})
+	ErrorCheck(err)
+
+	err = server.Pipelines().Get(e.resourceType, ReplaceOperation).Run(server, &Subject{
+		ResourceType: e.resourceType,
+		Operation:    ReplaceOperation,
+		Request:      r,
+		Schema:       sch,
+		Repository:   repo,
+		Resource:     resource,
+		Reference:    shared.AsResource(reference),
+	}, ctx)
+	ErrorCheck(err)
+
+	err = traceStep(server, ctx, "hooks.beforeUpdate", func(ctx context.Context) error {
+		return server.Hooks().RunUpdate(true, e.resourceType, resource, shared.AsResource(reference), ctx)
+	})
+	ErrorCheck(err)
+
+	if respondDryRun(server, ctx, ri, resource, sch) {
+		return
+	}
+
+	if enqueueOperation(server, ctx, ri, &shared.Operation{
+		Kind:         shared.OperationUpdate,
+		ResourceType: e.resourceType,
+		ResourceId:   id,
+		Version:      version,
+		Resource:     resource,
+	}) {
+		recordWrite()
+		return
+	}
+
+	err = traceStep(server, ctx, "repository.update", func(ctx context.Context) error {
+		return repo.Update(id, version, resource, ctx)
+	})
+	ErrorCheck(err)
+	recordWrite()
+	runAfterHook(server, ctx, "hooks.afterUpdate", func(ctx context.Context) error {
+		return server.Hooks().RunUpdate(false, e.resourceType, resource, shared.AsResource(reference), ctx)
+	})
+	if e.updated != nil {
+		e.updated(server, ctx, resource, reference)
+	}
+
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, resource, sch, ctx), sch, []string{}, []string{})
+		return
+	})
+	ErrorCheck(err)
+
+	location := resource.GetData()["meta"].(map[string]interface{})["location"].(string)
+	newVersion := resource.GetData()["meta"].(map[string]interface{})["version"].(string)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	if len(newVersion) > 0 {
+		ri.ETagHeader(newVersion)
+	}
+	if len(location) > 0 {
+		ri.LocationHeader(location)
+	}
+	ri.Body(json)
+	return
+}
+
+func queryResource(r shared.WebRequest, server ScimServer, ctx context.Context, e *resourceEndpoint) (ri *ResponseInfo) {
+	ri = newResponse()
+	ErrorCheck(server.OperationToggles().Check(e.resourceType, "query"))
+	sch := server.InternalSchema(e.urn)
+	if e.delta && len(r.Param("since")) > 0 {
+		return respondDelta(r, server, ctx, e.resourceType, sch)
+	}
+
+	var sr shared.SearchRequest
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		sr, err = ParseQuery(r, server, sch, true)
+		return
+	})
+	ErrorCheck(err)
+
+	repo := server.Repository(e.resourceType)
+	var watermark string
+	if e.delta {
+		watermark = latestWatermark(server, ctx)
+	}
+	var lr *shared.ListResponse
+	err = traceStep(server, ctx, "repository.search", func(ctx context.Context) (err error) {
+		lr, err = searchWithMaxResults(server, repo, sr, ctx)
+		return
+	})
+	ErrorCheck(err)
+	server.AttributeUsage().RecordRead(e.resourceType, sr.Attributes, sch)
+	server.AttributeUsage().RecordFilter(e.resourceType, sr.Filter, sch)
+	lr.Watermark = watermark
+
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, lr, sch, ctx), sch, sr.Attributes, sr.ExcludedAttributes)
+		return
+	})
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(json)
+	return
+}
+
+func deleteResource(r shared.WebRequest, server ScimServer, ctx context.Context, e *resourceEndpoint) (ri *ResponseInfo) {
+	ri = newResponse()
+	ErrorCheck(server.OperationToggles().Check(e.resourceType, "delete"))
+
+	id, version := ParseIdAndVersion(r)
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+	repo := server.Repository(e.resourceType)
+	version = deleteVersion(server, ctx, repo, id, version)
+	if e.deleting != nil {
+		ErrorCheck(e.deleting(server, ctx, repo, id, version))
+	}
+
+	err := traceStep(server, ctx, "hooks.beforeDelete", func(ctx context.Context) error {
+		return server.Hooks().RunDelete(true, e.resourceType, id, ctx)
+	})
+	ErrorCheck(err)
+
+	if shared.IsDryRun(ctx) {
+		err := traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+			_, err = repo.Get(id, version, ctx)
+			return
+		})
+		ErrorCheck(err)
+		respondDryRun(server, ctx, ri, nil, nil)
+		return
+	}
+
+	if enqueueOperation(server, ctx, ri, &shared.Operation{
+		Kind:         shared.OperationDelete,
+		ResourceType: e.resourceType,
+		ResourceId:   id,
+		Version:      version,
+	}) {
+		return
+	}
+
+	err = traceStep(server, ctx, "repository.delete", func(ctx context.Context) error {
+		return repo.Delete(id, version, ctx)
+	})
+	ErrorCheck(err)
+	runAfterHook(server, ctx, "hooks.afterDelete", func(ctx context.Context) error {
+		return server.Hooks().RunDelete(false, e.resourceType, id, ctx)
+	})
+
+	// the version deleted, for audit logs of the client
+	if len(version) > 0 {
+		ri.ETagHeader(version)
+	}
+	ri.Status(http.StatusNoContent)
+	return
+}
+
+func getResource(r shared.WebRequest, server ScimServer, ctx context.Context, e *resourceEndpoint) (ri *ResponseInfo) {
+	ri = newResponse()
+	ErrorCheck(server.OperationToggles().Check(e.resourceType, "get"))
+	sch := server.InternalSchema(e.urn)
+	repo := server.Repository(e.resourceType)
+
+	id, version := ParseIdAndVersion(r)
+	ctx = context.WithValue(ctx, shared.ResourceId{}, id)
+
+	var sr shared.SearchRequest
+	err := traceStep(server, ctx, "parse", func(ctx context.Context) (err error) {
+		sr, err = ParseQuery(r, server, sch, false)
+		return
+	})
+	ErrorCheck(err)
+
+	if len(version) > 0 {
+		var exists bool
+		err := traceStep(server, ctx, "repository.exists", func(ctx context.Context) (err error) {
+			exists, err = shared.ResourceExists(repo, id, version, ctx)
+			return
+		})
+		if err == nil && exists {
+			ri.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	var dp shared.DataProvider
+	err = traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+		dp, err = repo.Get(id, "", ctx)
+		return
+	})
+	ErrorCheck(err)
+	server.AttributeUsage().RecordRead(e.resourceType, sr.Attributes, sch)
+	location := shared.ResourceLocation(dp, server.Property(), ctx)
+
+	var json []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		json, err = server.MarshalJSON(redact(server, dp, sch, ctx), sch, sr.Attributes, sr.ExcludedAttributes)
+		return
+	})
+	ErrorCheck(err)
+
+	// the version of the resource served, not the one the client asked about
+	version = dp.GetData()["meta"].(map[string]interface{})["version"].(string)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	if len(version) > 0 {
+		ri.ETagHeader(version)
+	}
+	if len(location) > 0 {
+		ri.LocationHeader(location)
+	}
+	ri.Body(json)
+	return
+}
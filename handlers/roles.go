@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/shared"
+)
+
+// Roles and entitlements are plain resources, validated through the pipelines of their resource type like
+// users and groups. Those assigned to users are not deleted, see refuseAssigned.
+
+func CreateRoleHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return createResource(r, server, ctx, roleEndpoint)
+}
+
+func GetRoleByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return getResource(r, server, ctx, roleEndpoint)
+}
+
+func ReplaceRoleHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return replaceResource(r, server, ctx, roleEndpoint)
+}
+
+func PatchRoleHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return patchResource(r, server, ctx, roleEndpoint)
+}
+
+func QueryRoleHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return queryResource(r, server, ctx, roleEndpoint)
+}
+
+func DeleteRoleByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return deleteResource(r, server, ctx, roleEndpoint)
+}
+
+func CreateEntitlementHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return createResource(r, server, ctx, entitlementEndpoint)
+}
+
+func GetEntitlementByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return getResource(r, server, ctx, entitlementEndpoint)
+}
+
+func ReplaceEntitlementHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return replaceResource(r, server, ctx, entitlementEndpoint)
+}
+
+func PatchEntitlementHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return patchResource(r, server, ctx, entitlementEndpoint)
+}
+
+func QueryEntitlementHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return queryResource(r, server, ctx, entitlementEndpoint)
+}
+
+func DeleteEntitlementByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
+	return deleteResource(r, server, ctx, entitlementEndpoint)
+}
@@ -8,10 +8,11 @@ import (
 
 func GetAllSchemaHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
 	ri = newResponse()
-	jsonBytes, err := server.MarshalJSON([]interface{}{
-		server.Schema(shared.UserUrn),
-		server.Schema(shared.GroupUrn),
-	}, nil, nil, nil)
+	schemas := make([]interface{}, 0)
+	for _, sch := range server.Schemas().All() {
+		schemas = append(schemas, sch)
+	}
+	jsonBytes, err := server.MarshalJSON(schemas, nil, nil, nil)
 	ErrorCheck(err)
 
 	ri.Status(http.StatusOK)
@@ -23,18 +24,13 @@ func GetSchemaByIdHandler(r shared.WebRequest, server ScimServer, ctx context.Co
 	ri = newResponse()
 	id, _ := ParseIdAndVersion(r)
 
-	switch id {
-	case shared.UserUrn:
-		jsonBytes, err := server.MarshalJSON(server.Schema(shared.UserUrn), nil, nil, nil)
-		ErrorCheck(err)
-		ri.Body(jsonBytes)
-	case shared.GroupUrn:
-		jsonBytes, err := server.MarshalJSON(server.Schema(shared.GroupUrn), nil, nil, nil)
-		ErrorCheck(err)
-		ri.Body(jsonBytes)
-	default:
+	sch := server.Schemas().Get(id)
+	if sch == nil {
 		panic(shared.Error.ResourceNotFound(id, ""))
 	}
+	jsonBytes, err := server.MarshalJSON(sch, nil, nil, nil)
+	ErrorCheck(err)
+	ri.Body(jsonBytes)
 
 	ri.Status(http.StatusOK)
 	return
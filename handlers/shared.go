@@ -1,30 +1,63 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	. "github.com/davidiamyou/go-scim/shared"
 	"github.com/satori/go.uuid"
+	"io"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // interface for server, provides all necessary components for processing
+//
+// Handlers call the methods concurrently from the first request on, without synchronization. What the
+// methods return must therefore not change once the server serves: the schemas, repositories and
+// utilities are set up before. The registries returned, i.e. Hooks, Transformers, Validators and
+// Pipelines, synchronize themselves and may be added to later; the SchemaRegistry only until it is frozen.
 type ScimServer interface {
 	// utilities
 	Property() PropertySource
 	Logger() Logger
+	Metrics() *Metrics
+	Tracer() Tracer
+	RateLimiter() RateLimiter
+	AccessController() AccessController
+	OperationQueue() OperationQueue
+	OperationStore() OperationStore
+	ExportStore() ExportStore         // nil when exports are not supported
+	GroupDisplayWorkers() *WorkerPool // propagate the display of renamed groups, within the request if nil
+	Hooks() *Hooks
+	Transformers() *Transformers
+	Validators() *Validators
+	Defaults() *Defaults
+	Pipelines() *Pipelines
+	IdempotencyCache() IdempotencyCache
+	Journal() Journal
+	ChangeLog() ChangeLog // the changes delta queries are answered from, nil when they are not supported
+	AttributeUsage() *AttributeUsage
+	OperationToggles() *OperationToggles
+	BaseURL() BaseURLProvider
+	Messages() *MessageCatalog // translations of error details, nil for English only
 	WebRequest(r *http.Request) WebRequest
 
 	// schema
-	Schema(id string) *Schema
+	Schemas() *SchemaRegistry
 	InternalSchema(id string) *Schema
 
 	// case
 	CorrectCase(subj *Resource, sch *Schema, ctx context.Context) error
+	CheckUnknownAttributes(subj *Resource, sch *Schema, ctx context.Context) error
+	ApplyReplacePolicy(subj *Resource, ref *Resource, sch *Schema, ctx context.Context) error
 
 	// patch
 	ApplyPatch(patch Patch, subj *Resource, sch *Schema, ctx context.Context) error
@@ -34,6 +67,7 @@ type ScimServer interface {
 	ValidateRequired(subj *Resource, sch *Schema, ctx context.Context) error
 	ValidateMutability(subj *Resource, ref *Resource, sch *Schema, ctx context.Context) error
 	ValidateUniqueness(subj *Resource, sch *Schema, repo Repository, ctx context.Context) error
+	ValidateUniquenessBatch(subjs []*Resource, sch *Schema, repo Repository, ctx context.Context) []error
 
 	// read only generation
 	AssignReadOnlyValue(r *Resource, ctx context.Context) error
@@ -56,6 +90,34 @@ func ErrorCheck(err error) {
 	}
 }
 
+// turn the panic of a failed step back into the error it was raised with, for handlers called through the Go
+// API rather than served; deferred as defer recoverError(&err)
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(error)
+		if !ok {
+			panic(r)
+		}
+		*err = e
+	}
+}
+
+// run the handler, returning the error it failed with instead of an error response
+func runHandler(handler EndpointHandler, req WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo, err error) {
+	defer recoverError(&err)
+	return handler(req, server, ctx), nil
+}
+
+// Run the handler of a request the server makes of itself, i.e. the writes of a replication agent, so that they
+// are validated, hooked and published like those of clients. The request gets the request type unless the
+// context has one; the error the handler fails with is returned instead of an error response.
+func RunHandler(handler EndpointHandler, requestType int, req WebRequest, server ScimServer, ctx context.Context) (*ResponseInfo, error) {
+	if _, ok := ctx.Value(RequestType{}).(int); !ok {
+		ctx = context.WithValue(ctx, RequestType{}, requestType)
+	}
+	return runHandler(handler, req, server, ctx)
+}
+
 var (
 	errorTemplate    = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:Error"], "Status": "%d", "scimType":"%s", "detail":"%s"}`
 	errorTemplateAlt = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:Error"], "Status": "%d", "detail":"%s"}`
@@ -68,6 +130,23 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 				info = newResponse()
 				info.ScimJsonHeader()
 
+				if r == context.DeadlineExceeded {
+					r = Error.Timeout("the request")
+				}
+				if notFound, ok := r.(*ResourceNotFoundError); ok {
+					if gone := goneError(req, server, notFound, ctx); gone != nil {
+						r = gone
+					}
+				}
+
+				detail := errorDetail(r)
+				if err, ok := r.(error); ok && server != nil {
+					if localized, language := server.Messages().Localize(err, req.Header("Accept-Language")); len(language) > 0 {
+						detail = escapeDetail(localized)
+						info.Header("Content-Language", language)
+					}
+				}
+
 				switch r.(type) {
 				case *InvalidPathError:
 					info.Status(http.StatusBadRequest)
@@ -76,17 +155,21 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusBadRequest,
 							"invalidPath",
-							r.(error).Error()),
+							detail),
 					))
 
 				case *InvalidFilterError:
+					if requestType, ok := ctx.Value(RequestType{}).(int); ok {
+						resourceType, _ := DescribeRequestType(requestType)
+						metrics(server).FilterParseFailures.Inc(resourceType)
+					}
 					info.Status(http.StatusBadRequest)
 					info.Body([]byte(
 						fmt.Sprintf(
 							errorTemplate,
 							http.StatusBadRequest,
 							"invalidFilter",
-							r.(error).Error()),
+							detail),
 					))
 
 				case *InvalidTypeError:
@@ -96,7 +179,7 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusBadRequest,
 							"invalidSyntax",
-							r.(error).Error()),
+							detail),
 					))
 
 				case *NoAttributeError:
@@ -106,7 +189,17 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusBadRequest,
 							"invalidSyntax",
-							r.(error).Error()),
+							detail),
+					))
+
+				case *UnknownAttributeError:
+					info.Status(http.StatusBadRequest)
+					info.Body([]byte(
+						fmt.Sprintf(
+							errorTemplate,
+							http.StatusBadRequest,
+							"invalidValue",
+							detail),
 					))
 
 				case *MissingRequiredPropertyError:
@@ -116,7 +209,7 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusBadRequest,
 							"invalidValue",
-							r.(error).Error()),
+							detail),
 					))
 
 				case *MutabilityViolationError:
@@ -126,7 +219,17 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusBadRequest,
 							"mutability",
-							r.(error).Error()),
+							detail),
+					))
+
+				case *MembershipCycleError:
+					info.Status(http.StatusBadRequest)
+					info.Body([]byte(
+						fmt.Sprintf(
+							errorTemplate,
+							http.StatusBadRequest,
+							"invalidValue",
+							detail),
 					))
 
 				case *InvalidParamError:
@@ -136,12 +239,12 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusBadRequest,
 							"invalidValue",
-							r.(error).Error()),
+							detail),
 					))
 
 				case *ResourceNotFoundError:
 					switch req.Method() {
-					case http.MethodPut, http.MethodPatch, http.MethodDelete:
+					case http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodPost:
 						_, version := ParseIdAndVersion(req)
 						if len(version) == 0 {
 							info.Status(http.StatusNotFound)
@@ -151,7 +254,15 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 					default:
 						info.Status(http.StatusNotFound)
 					}
-					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, info.statusCode, r.(error).Error())))
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, info.statusCode, detail)))
+
+				case *GoneError:
+					info.Status(http.StatusGone)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusGone, detail)))
+
+				case *VersionConflictError:
+					info.Status(http.StatusConflict)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusConflict, detail)))
 
 				case *DuplicateError:
 					info.Status(http.StatusConflict)
@@ -160,44 +271,513 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 							errorTemplate,
 							http.StatusConflict,
 							"uniqueness",
-							r.(error).Error()),
+							detail),
+					))
+
+				case *TooManyError:
+					info.Status(http.StatusBadRequest)
+					info.Body([]byte(
+						fmt.Sprintf(
+							errorTemplate,
+							http.StatusBadRequest,
+							"tooMany",
+							detail),
 					))
 
+				case *ForbiddenError:
+					info.Status(http.StatusForbidden)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusForbidden, detail)))
+
+				case *RateLimitedError:
+					info.Status(http.StatusTooManyRequests)
+					info.Header("Retry-After", strconv.Itoa(int(math.Ceil(r.(*RateLimitedError).RetryAfter.Seconds()))))
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusTooManyRequests, detail)))
+
+				case *UnsupportedMediaTypeError:
+					info.Status(http.StatusUnsupportedMediaType)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusUnsupportedMediaType, detail)))
+
+				case *NotAcceptableError:
+					info.Status(http.StatusNotAcceptable)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusNotAcceptable, detail)))
+
+				case *PayloadTooLargeError:
+					info.Status(http.StatusRequestEntityTooLarge)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusRequestEntityTooLarge, detail)))
+
+				case *UnavailableError:
+					info.Status(http.StatusServiceUnavailable)
+					info.Header("Retry-After", strconv.Itoa(int(math.Ceil(r.(*UnavailableError).RetryAfter.Seconds()))))
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusServiceUnavailable, detail)))
+
+				case *TimeoutError:
+					info.Status(http.StatusGatewayTimeout)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusGatewayTimeout, detail)))
+
+				case *NotImplementedError:
+					info.Status(http.StatusNotImplemented)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusNotImplemented, detail)))
+
+				case *NotReadyError:
+					info.Status(http.StatusConflict)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusConflict, detail)))
+
+				case *InUseError:
+					info.Status(http.StatusConflict)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusConflict, detail)))
+
+				case *PreconditionRequiredError:
+					info.Status(http.StatusPreconditionRequired)
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusPreconditionRequired, detail)))
+
+				case *RangeNotSatisfiableError:
+					info.Status(http.StatusRequestedRangeNotSatisfiable)
+					info.Header("Content-Range", fmt.Sprintf("bytes */%d", r.(*RangeNotSatisfiableError).Size))
+					info.Body([]byte(fmt.Sprintf(errorTemplateAlt, http.StatusRequestedRangeNotSatisfiable, detail)))
+
 				default:
 					info.Status(http.StatusInternalServerError)
 					info.Body([]byte(fmt.Sprintf(
 						errorTemplateAlt,
 						http.StatusInternalServerError,
-						r.(error).Error()),
+						detail),
 					))
 				}
+
+				info.responseBody = withRequestId(info.responseBody, ctx)
+
+				// client errors, i.e. failed validations, are expected and logged as information only
+				fields := LogFields(ctx, "status", info.statusCode, "error", fmt.Sprint(r))
+				if id, _ := ParseIdAndVersion(req); len(id) > 0 && ctx.Value(ResourceId{}) == nil {
+					fields = append(fields, LogFieldResourceId, id)
+				}
+				if info.statusCode >= http.StatusInternalServerError && info.statusCode != http.StatusNotImplemented {
+					logger(server).Error("request failed", fields...)
+				} else {
+					logger(server).Info("request rejected", fields...)
+				}
 			}
 		}()
 		return next(req, server, ctx)
 	}
 }
 
+// the error body with the id of the request as its requestId, a vendor extension of the error schema letting
+// clients quote the id of a failed request even when they did not send one
+func withRequestId(body []byte, ctx context.Context) []byte {
+	requestId := RequestIdOf(ctx)
+	end := bytes.LastIndexByte(body, '}')
+	if len(requestId) == 0 || end < 0 {
+		return body
+	}
+	quoted, _ := json.Marshal(requestId)
+	extended := append([]byte{}, body[:end]...)
+	extended = append(extended, `, "requestId":`...)
+	extended = append(extended, quoted...)
+	return append(extended, body[end:]...)
+}
+
+// the logger of the server, discarding messages when there is no server or it has no logger
+func logger(server ScimServer) Logger {
+	if server == nil || server.Logger() == nil {
+		return NewNoOpLogger()
+	}
+	return server.Logger()
+}
+
+var noOpMetrics = NewMetrics(nil)
+
+// the metrics of the server, discarding every observation for servers without
+func metrics(server ScimServer) *Metrics {
+	if server == nil || server.Metrics() == nil {
+		return noOpMetrics
+	}
+	return server.Metrics()
+}
+
+// Run the hook on the response to the request just before it is written, error responses included. Lets
+// wrappers and pipeline stages without access to the response add headers to it, i.e. rate limit
+// information, correlation ids or deprecation warnings. Hooks run in the order they were registered, by
+// InjectRequestScope; returns false, not registering the hook, for contexts of requests it did not scope.
+func OnResponse(ctx context.Context, hook func(ri *ResponseInfo)) bool {
+	hooks, ok := ctx.Value(responseHooksKey{}).(*responseHooks)
+	if !ok {
+		return false
+	}
+	hooks.Lock()
+	defer hooks.Unlock()
+	hooks.hooks = append(hooks.hooks, hook)
+	return true
+}
+
+type responseHooksKey struct{}
+
+// the hooks registered with OnResponse
+type responseHooks struct {
+	sync.Mutex
+	hooks []func(ri *ResponseInfo)
+}
+
+func (h *responseHooks) run(ri *ResponseInfo) {
+	h.Lock()
+	hooks := h.hooks
+	h.Unlock()
+	for _, hook := range hooks {
+		hook(ri)
+	}
+}
+
+// the message of the recovered error, escaped to fit the detail of the error templates
+func errorDetail(r interface{}) string {
+	return escapeDetail(r.(error).Error())
+}
+
+func escapeDetail(detail string) string {
+	quoted, _ := json.Marshal(detail)
+	return string(quoted[1 : len(quoted)-1])
+}
+
 func InjectRequestScope(next EndpointHandler, requestType int) EndpointHandler {
 	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
-		ctx = context.WithValue(ctx, RequestId{}, uuid.NewV4().String())
+		requestId := req.Header("X-Request-Id")
+		if !ValidRequestId(requestId) {
+			requestId = uuid.NewV4().String()
+		}
+		ctx = context.WithValue(ctx, RequestId{}, requestId)
 		ctx = context.WithValue(ctx, RequestTimestamp{}, time.Now().Unix())
 		ctx = context.WithValue(ctx, RequestType{}, requestType)
+		if provider := server.BaseURL(); provider != nil {
+			ctx = context.WithValue(ctx, BaseURL{}, strings.TrimSuffix(provider.BaseURL(req), "/"))
+		}
+		if dryRun, _ := strconv.ParseBool(req.Param("dryRun")); dryRun || strings.ToLower(req.Header("X-Dry-Run")) == "true" {
+			ctx = context.WithValue(ctx, DryRun{}, true)
+		}
+		if ps := server.Property(); ps != nil && IsLenientClient(ps.GetString("scim.protocol.lenientClients"), ctx) {
+			ctx = WithLenientValidation(ctx)
+		}
+
+		hooks := &responseHooks{}
+		ctx = context.WithValue(ctx, responseHooksKey{}, hooks)
+
+		info = next(req, server, ctx)
+		if warnings := LenientWarnings(ctx).Messages(); len(warnings) > 0 && info != nil {
+			logger(server).Warn("repaired invalid request", LogFields(ctx, "warnings", strings.Join(warnings, "; "))...)
+			info.Header("Warning", LenientWarnings(ctx).Header())
+		}
+		if info != nil {
+			info.Header("X-Request-Id", requestId)
+			hooks.run(info)
+		}
+		return
+	}
+}
+
+// record and log request count, status and latency, must be placed inside InjectRequestScope
+// and outside ErrorRecovery so that the request type and the final status are known
+func Instrument(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		requestType, _ := ctx.Value(RequestType{}).(int)
+		resourceType, operation := DescribeRequestType(requestType)
+		start := time.Now()
+		defer func() {
+			status := http.StatusInternalServerError
+			if info != nil {
+				status = info.statusCode
+			}
+			metrics(server).Requests.Inc(resourceType, operation, strconv.Itoa(status))
+			metrics(server).RequestDuration.Observe(time.Since(start).Seconds(), resourceType, operation)
+			logger(server).Info("handled request", LogFields(ctx, "method", req.Method(), "status", status, "duration", time.Since(start))...)
+		}()
 		return next(req, server, ctx)
 	}
 }
 
+// reject request bodies that are not SCIM or plain JSON with 415 and requests whose Accept header
+// rules out JSON with 406, must be placed inside ErrorRecovery
+func Negotiate(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		ErrorCheck(CheckContentType(req))
+		ErrorCheck(CheckAccept(req))
+		return next(req, server, ctx)
+	}
+}
+
+// reject request bodies larger than the number of bytes configured under scim.protocol.maxRequestBytes with
+// 413, must be placed inside ErrorRecovery; a value of 0 leaves the body size unlimited. Bodies announcing
+// their size are rejected before they are read, the others are read no further than the limit when the
+// request is a BodyLimiter.
+func LimitBody(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		maxBytes := server.Property().GetInt("scim.protocol.maxRequestBytes")
+		if maxBytes <= 0 {
+			return next(req, server, ctx)
+		}
+
+		if length, err := strconv.Atoi(req.Header("Content-Length")); err == nil && length > maxBytes {
+			panic(Error.PayloadTooLarge(maxBytes))
+		}
+		var body []byte
+		var err error
+		if limiter, ok := req.(BodyLimiter); ok {
+			body, err = limiter.LimitedBody(maxBytes)
+		} else {
+			body, err = req.Body()
+		}
+		ErrorCheck(err)
+		if len(body) > maxBytes {
+			panic(Error.PayloadTooLarge(maxBytes))
+		}
+		return next(&bufferedRequest{WebRequest: req, body: body}, server, ctx)
+	}
+}
+
+// a web request whose body has been read already
+type bufferedRequest struct {
+	WebRequest
+	body []byte
+}
+
+func (r *bufferedRequest) Body() ([]byte, error) { return r.body, nil }
+
+// responses smaller than this are not worth compressing
+const compressMinBytes = 1024
+
+// gzip the response body when the client accepts it, must be placed outside ErrorRecovery so that
+// error responses are covered too
+func Compress(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		info = next(req, server, ctx)
+		// byte ranges refer to the content as it is, encoding it would shift them
+		if info == nil || len(info.responseBody) < compressMinBytes || !AcceptsGzip(req) || len(info.GetHeader("Accept-Ranges")) > 0 {
+			return
+		}
+
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(info.responseBody); err != nil {
+			return
+		}
+		if err := gz.Close(); err != nil {
+			return
+		}
+		info.Header("Content-Encoding", "gzip")
+		info.Header("Vary", "Accept-Encoding")
+		info.Body(buf.Bytes())
+		return
+	}
+}
+
+// reject the request when the client identified by RateLimitKey has exhausted its quota, forwarded addresses
+// trusted from the comma separated proxies of scim.auth.trustedProxies only; must be placed inside ErrorRecovery
+func RateLimit(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		var proxies []string
+		if trusted := server.Property().GetString("scim.auth.trustedProxies"); len(trusted) > 0 {
+			proxies = strings.Split(trusted, ",")
+		}
+		if allowed, retryAfter := server.RateLimiter().Allow(RateLimitKey(req, ctx, proxies)); !allowed {
+			panic(Error.RateLimited(retryAfter))
+		}
+		return next(req, server, ctx)
+	}
+}
+
+// answer a mutating request whose X-Journal-Key was used before with the response recorded in the server's
+// Journal instead of executing it again, and record the response otherwise; must be placed inside ErrorRecovery
+// and LimitBody. Responses with a 5xx status are not recorded, so that such requests can be retried. Reusing
+// a key for a different request fails with 400, replaying a request still in progress with 409.
+func JournalRequests(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		journal := server.Journal()
+		key := JournalKey(req, ctx)
+		if journal == nil || len(key) == 0 || !isMutation(ctx) {
+			return next(req, server, ctx)
+		}
+
+		body, err := req.Body()
+		ErrorCheck(err)
+		req = &bufferedRequest{WebRequest: req, body: body}
+		fingerprint := RequestFingerprint(req, body, ctx)
+
+		entry, err := journal.Begin(key, fingerprint, ctx)
+		ErrorCheck(err)
+		if entry != nil {
+			if entry.Fingerprint != fingerprint {
+				panic(Error.InvalidParam("X-Journal-Key", "a key not used for a different request", req.Header("X-Journal-Key")))
+			}
+			if entry.InProgress() {
+				panic(Error.Duplicate("X-Journal-Key", req.Header("X-Journal-Key")))
+			}
+			info = newResponse().Status(entry.Status)
+			for k, v := range entry.Headers {
+				info.Header(k, v)
+			}
+			info.Header("X-Replayed", "true")
+			return info.Body(entry.Body)
+		}
+
+		info = ErrorRecovery(next)(req, server, ctx)
+		if info.statusCode >= http.StatusInternalServerError {
+			if err := journal.Abort(key, ctx); err != nil {
+				logger(server).Error("failed to release journal key", LogFields(ctx, "key", key, "error", err.Error())...)
+			}
+			return
+		}
+		// wrappers further out, i.e. Compress, modify the response after it is recorded
+		err = journal.Complete(&JournalEntry{
+			Key:         key,
+			Fingerprint: fingerprint,
+			Status:      info.statusCode,
+			Headers:     info.GetHeaders(),
+			Body:        info.responseBody,
+		}, ctx)
+		if err != nil {
+			logger(server).Error("failed to record response", LogFields(ctx, "key", key, "error", err.Error())...)
+		}
+		return
+	}
+}
+
+func isMutation(ctx context.Context) bool {
+	requestType, _ := ctx.Value(RequestType{}).(int)
+	switch requestType {
+	case CreateUser, ReplaceUser, PatchUser, DeleteUser, ChangeUserPassword, CreateGroup, ReplaceGroup, PatchGroup, DeleteGroup, BulkOp,
+		CreateRole, ReplaceRole, PatchRole, DeleteRole, CreateEntitlement, ReplaceEntitlement, PatchEntitlement, DeleteEntitlement:
+		return true
+	default:
+		return false
+	}
+}
+
+// bound the request by the number of seconds configured under scim.protocol.requestTimeout, so that
+// repositories give up on slow calls; a value of 0 leaves the request unbounded
+func Timeout(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		if seconds := server.Property().GetInt("scim.protocol.requestTimeout"); seconds > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+			defer cancel()
+		}
+		return next(req, server, ctx)
+	}
+}
+
+// continue the trace of the incoming request and wrap the whole request in a span, must be placed
+// inside InjectRequestScope so that the span can be annotated with the request type
+func Trace(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) (info *ResponseInfo) {
+		ctx = server.Tracer().Extract(ctx, req.Header)
+		attributes := TraceAttributes(ctx)
+		ctx, span := server.Tracer().Start(ctx, "scim."+attributes[TraceAttrOperation], attributes)
+		defer func() {
+			if info != nil {
+				span.SetAttribute(TraceAttrStatus, strconv.Itoa(info.statusCode))
+			}
+			span.End()
+		}()
+		return next(req, server, ctx)
+	}
+}
+
+// wrap the handler with the standard chain of request scope, tracing, metrics, compression, error recovery,
+// content negotiation, body limit, rate limiting, request journaling and timeout, in the order the wrappers require
+func Chain(handler EndpointHandler, requestType int) EndpointHandler {
+	return ChainWith(handler, requestType)
+}
+
+// Chain, with the adapters, i.e. of the quirks of clients, wrapping what follows the body limit, the first
+// outermost, so that they see negotiated bodies within the limit and their failures are recovered
+func ChainWith(handler EndpointHandler, requestType int, adapters ...func(next EndpointHandler) EndpointHandler) EndpointHandler {
+	adapted := RateLimit(JournalRequests(Timeout(handler)))
+	for i := len(adapters) - 1; i >= 0; i-- {
+		adapted = adapters[i](adapted)
+	}
+	return InjectRequestScope(Trace(Instrument(Compress(ErrorRecovery(Negotiate(LimitBody(OmitETags(adapted))))))), requestType)
+}
+
+// drop the ETag header of the response under scim.protocol.etag set to off; the versions of the resources
+// are kept, in meta.version, whatever the property says
+func OmitETags(next EndpointHandler) EndpointHandler {
+	return func(req WebRequest, server ScimServer, ctx context.Context) *ResponseInfo {
+		ri := next(req, server, ctx)
+		if etagsOff(server) {
+			ri.headers.Del("ETag")
+		}
+		return ri
+	}
+}
+
+func etagsOff(server ScimServer) bool {
+	return server.Property().GetString("scim.protocol.etag") == ETagOff
+}
+
+// run a single handler step in its own span and with its pprof labels, logging its outcome and duration at
+// debug level. Repository calls being steps named repository.*, this also logs their timings.
+func traceStep(server ScimServer, ctx context.Context, name string, step func(ctx context.Context) error) error {
+	start := time.Now()
+	err := ProfileStep(ctx, name, func(ctx context.Context) error {
+		return TraceStep(server.Tracer(), ctx, name, step)
+	})
+	if err != nil {
+		logger(server).Debug("step failed", LogFields(ctx, "step", name, "duration", time.Since(start), "error", err.Error())...)
+	} else {
+		logger(server).Debug("step completed", LogFields(ctx, "step", name, "duration", time.Since(start))...)
+	}
+	return err
+}
+
 func Endpoint(next EndpointHandler, server ScimServer) http.HandlerFunc {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		ctx := context.Background()
-		resp := next(server.WebRequest(req), server, ctx)
-		rw.WriteHeader(resp.statusCode)
-		for k, v := range resp.headers {
-			rw.Header().Set(k, v)
-		}
-		rw.Write(resp.responseBody)
+		ctx := context.WithValue(req.Context(), RemoteAddr{}, req.RemoteAddr)
+		WriteResponse(rw, next(server.WebRequest(req), server, ctx))
 	})
 }
 
+// Write the status, all values of the headers, the body and the trailers of the response
+func WriteResponse(rw http.ResponseWriter, ri *ResponseInfo) {
+	for k, values := range ri.headers {
+		rw.Header()[k] = append([]string(nil), values...)
+	}
+	trailers := make([]string, 0, len(ri.trailers))
+	for k := range ri.trailers {
+		trailers = append(trailers, k)
+	}
+	if len(trailers) > 0 {
+		sort.Strings(trailers)
+		rw.Header().Set("Trailer", strings.Join(trailers, ", "))
+	}
+	rw.WriteHeader(ri.statusCode)
+	rw.Write(ri.responseBody)
+	for k, values := range ri.trailers {
+		rw.Header()[k] = append([]string(nil), values...)
+	}
+}
+
+// The GoneError of the requested user or group when its repository remembers deleting it, nil otherwise
+func goneError(req WebRequest, server ScimServer, err *ResourceNotFoundError, ctx context.Context) error {
+	requestType, ok := ctx.Value(RequestType{}).(int)
+	if !ok {
+		return nil
+	}
+	resourceType, _ := DescribeRequestType(requestType)
+	if resourceType != UserResourceType && resourceType != GroupResourceType {
+		return nil
+	}
+	id, _ := ParseIdAndVersion(req)
+	if len(id) == 0 || (len(err.Id) > 0 && err.Id != id) {
+		return nil
+	}
+	reader, ok := server.Repository(resourceType).(DeletionReader)
+	if !ok {
+		return nil
+	}
+	deleted, ok, e := reader.DeletedAt(id, ctx)
+	if e != nil || !ok {
+		return nil
+	}
+	return Error.Gone(id, deleted)
+}
+
 func ParseIdAndVersion(req WebRequest) (id, version string) {
 	id = req.Param("resourceId")
 	switch req.Method() {
@@ -205,6 +785,11 @@ func ParseIdAndVersion(req WebRequest) (id, version string) {
 		version = req.Header("If-None-Match")
 	case http.MethodPut, http.MethodPatch, http.MethodDelete:
 		version = req.Header("If-Match")
+	case http.MethodPost:
+		// actions on a resource, i.e. changing the password of a user, not creates, searches or bulk requests
+		if len(id) > 0 {
+			version = req.Header("If-Match")
+		}
 	}
 	return
 }
@@ -215,6 +800,70 @@ func ParseInclusionAndExclusionAttributes(req WebRequest) (attributes, excludedA
 	return
 }
 
+// Parse and validate the query parameters of a request against the schema. A search, by GET or by POST to
+// .search, heeds all of them; a GET by id only heeds attributes and excludedAttributes. Malformed parameters
+// fail with errors answered with 400: invalidFilter for the filter, invalidValue or invalidPath for the others.
+// Filters are checked with CheckFilter.
+func ParseQuery(req WebRequest, server ScimServer, sch *Schema, search bool) (SearchRequest, error) {
+	if !search {
+		sr := SearchRequest{}
+		sr.Attributes, sr.ExcludedAttributes = ParseInclusionAndExclusionAttributes(req)
+		if err := sr.ValidateAttributes(sch); err != nil {
+			return SearchRequest{}, err
+		}
+		return sr, nil
+	}
+
+	sr, err := ParseSearchRequest(req, server)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	if err := sr.Validate(sch); err != nil {
+		return SearchRequest{}, err
+	}
+	if err := CheckFilter(sr.Filter, server, sch); err != nil {
+		return SearchRequest{}, err
+	}
+	return sr, nil
+}
+
+// Compile the filter of a client against the schema and check it against the limits of the server,
+// scim.protocol.filterMaxDepth and scim.protocol.filterMaxClauses, failing with invalidFilter, and for
+// comparisons of attributes that are never returned, failing with a forbidden error
+func CheckFilter(text string, server ScimServer, sch *Schema) error {
+	var (
+		root FilterNode
+		err  error
+	)
+	if sch == nil {
+		root, err = NewFilter(text)
+	} else {
+		root, err = CompileFilter(text, sch)
+	}
+	if err != nil {
+		return err
+	}
+	limits := FilterLimits{
+		MaxDepth:   server.Property().GetInt("scim.protocol.filterMaxDepth"),
+		MaxClauses: server.Property().GetInt("scim.protocol.filterMaxClauses"),
+	}
+	if err := limits.Check(text, root); err != nil {
+		return err
+	}
+	if sch == nil {
+		return nil
+	}
+	return CheckFilterReturnable(root, sch)
+}
+
+// the filter in canonical form for logs, as it is when it does not parse
+func logFilter(text string) string {
+	if root, err := NewFilter(text); err == nil {
+		return CanonicalFilter(root)
+	}
+	return text
+}
+
 func ParseBodyAsResource(req WebRequest) (*Resource, error) {
 	raw, err := req.Body()
 	if err != nil {
@@ -222,7 +871,7 @@ func ParseBodyAsResource(req WebRequest) (*Resource, error) {
 	}
 
 	data := make(map[string]interface{}, 0)
-	err = json.Unmarshal(raw, &data)
+	err = unmarshalNumbers(raw, &data)
 	if err != nil {
 		return nil, Error.InvalidParam("request body", "json conforming to resource syntax", err.Error())
 	}
@@ -236,13 +885,27 @@ func ParseModification(req WebRequest) (Modification, error) {
 	if err != nil {
 		return Modification{}, err
 	}
-	err = json.Unmarshal(reqBody, &m)
+	err = unmarshalNumbers(reqBody, &m)
 	if err != nil {
 		return Modification{}, err
 	}
 	return m, nil
 }
 
+// Decode like json.Unmarshal, but with numbers as json.Number, so that integers keep every digit until
+// ValidateType and CorrectCase bring them to the type of their attribute
+func unmarshalNumbers(raw []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after top-level value")
+	}
+	return nil
+}
+
 func ParseSearchRequest(req WebRequest, server ScimServer) (SearchRequest, error) {
 	switch req.Method() {
 	case http.MethodGet:
@@ -251,8 +914,7 @@ func ParseSearchRequest(req WebRequest, server ScimServer) (SearchRequest, error
 			StartIndex: 1,
 			Count:      server.Property().GetInt("scim.protocol.itemsPerPage"),
 		}
-		sr.Attributes = strings.Split(req.Param("attributes"), ",")
-		sr.ExcludedAttributes = strings.Split(req.Param("excludedAttributes"), ",")
+		sr.Attributes, sr.ExcludedAttributes = ParseInclusionAndExclusionAttributes(req)
 		sr.Filter = req.Param("filter")
 		sr.SortBy = req.Param("sortBy")
 		sr.SortOrder = req.Param("sortOrder")
@@ -291,26 +953,376 @@ func ParseSearchRequest(req WebRequest, server ScimServer) (SearchRequest, error
 		}
 		err = json.Unmarshal(reqBody, &sr)
 		if err != nil {
-			return SearchRequest{}, err
+			return SearchRequest{}, Error.InvalidParam("request body", "json conforming to search request syntax", err.Error())
+		}
+		if sr.StartIndex < 1 {
+			sr.StartIndex = 1
+		}
+		if sr.Count < 0 {
+			sr.Count = 0
 		}
 		return sr, nil
 
 	default:
-		return SearchRequest{}, Error.Text("%s method is not supported for search request", req.Method)
+		return SearchRequest{}, Error.Text("%s method is not supported for search request", req.Method())
+	}
+}
+
+// run after hooks once the write has succeeded, their errors are logged but no longer fail the request
+func runAfterHook(server ScimServer, ctx context.Context, name string, hook func(ctx context.Context) error) {
+	if err := traceStep(server, ctx, name, hook); err != nil {
+		logger(server).Error("after hook failed", LogFields(ctx, "hook", name, "error", err.Error())...)
+	}
+}
+
+// In dry run mode, respond with the resource as it would have been stored instead of persisting it, or
+// with 204 No Content when there is no resource to show. Returns false when the mutation should proceed.
+func respondDryRun(server ScimServer, ctx context.Context, ri *ResponseInfo, resource DataProvider, sch *Schema) bool {
+	if !IsDryRun(ctx) {
+		return false
+	}
+
+	ri.Header("X-Dry-Run", "true")
+	if resource == nil {
+		ri.Status(http.StatusNoContent)
+		return true
+	}
+
+	var jsonBytes []byte
+	err := traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		jsonBytes, err = server.MarshalJSON(redact(server, resource, sch, ctx), sch, []string{}, []string{})
+		return
+	})
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(jsonBytes)
+	return true
+}
+
+// When the server is configured to answer duplicate creates with the existing resource and the uniqueness
+// validation of a create failed on a resource that could be identified, respond with that resource instead of
+// a conflict. Returns false when the caller should handle err itself.
+func respondExisting(server ScimServer, ctx context.Context, ri *ResponseInfo, err error, repo Repository, sch *Schema) bool {
+	dup, ok := err.(*DuplicateError)
+	if !ok || len(dup.ExistingId) == 0 ||
+		server.Property().GetString("scim.protocol.duplicateCreate") != ReturnExistingOnDuplicate {
+		return false
+	}
+
+	var existing DataProvider
+	err = traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+		existing, err = repo.Get(dup.ExistingId, "", ctx)
+		return
+	})
+	ErrorCheck(err)
+
+	var jsonBytes []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		jsonBytes, err = server.MarshalJSON(redact(server, existing, sch, ctx), sch, []string{}, []string{})
+		return
+	})
+	ErrorCheck(err)
+
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	if meta, ok := existing.GetData()["meta"].(map[string]interface{}); ok {
+		if version, _ := meta["version"].(string); len(version) > 0 {
+			ri.ETagHeader(version)
+		}
+		if location := ResourceLocation(existing, server.Property(), ctx); len(location) > 0 {
+			ri.LocationHeader(location)
+		}
+	}
+	ri.Body(jsonBytes)
+	return true
+}
+
+// Check the If-Match of a delete against the stored resource, see CheckIfMatch, and return the version to
+// delete, which the response reports. The check and the delete are not atomic: the repository deleting only
+// the version given is what makes a concurrent write fail the delete with 412 rather than be deleted. If-Match
+// "*" deletes whatever version is stored, like no If-Match, which fails with 428 Precondition Required under
+// scim.protocol.etag set to required; both return an empty version.
+func deleteVersion(server ScimServer, ctx context.Context, repo Repository, id, version string) string {
+	if len(version) == 0 {
+		if server.Property().GetString("scim.protocol.etag") == ETagRequired {
+			ErrorCheck(Error.PreconditionRequired(id))
+		}
+		return ""
+	}
+	matched := ifMatchVersion(server, ctx, repo, id, version)
+	for _, each := range strings.Split(version, ",") {
+		if strings.TrimSpace(each) == "*" {
+			return ""
+		}
 	}
+	return matched
+}
+
+// Check an If-Match against the stored resource in the handler, see CheckIfMatch, rather than leaving it to
+// repositories, which may not compare versions, and return the stored version it matches.
+func ifMatchVersion(server ScimServer, ctx context.Context, repo Repository, id, ifMatch string) string {
+	var stored DataProvider
+	err := traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+		stored, err = repo.Get(id, "", ctx)
+		return
+	})
+	ErrorCheck(err)
+	version, err := CheckIfMatch(ifMatch, stored)
+	ErrorCheck(err)
+	return version
+}
+
+// The version a PATCH updates the resource read at: the one of If-Match if given, otherwise, with
+// scim.protocol.patchRetries set, the version read, so that a concurrent write is detected instead of
+// overwritten.
+func patchVersion(server ScimServer, version string, resource DataProvider) string {
+	if len(version) > 0 || server.Property().GetInt("scim.protocol.patchRetries") <= 0 {
+		return version
+	}
+	meta, _ := resource.GetData()["meta"].(map[string]interface{})
+	read, _ := meta["version"].(string)
+	return read
+}
+
+// Tell whether a PATCH is to be applied again to a fresh read of the resource, after its update of the
+// version read failed because the resource was changed meanwhile. Without If-Match, this is the case up to
+// scim.protocol.patchRetries times; a VersionConflictError is raised afterwards. Other errors are raised as
+// they are.
+func retryPatch(server ScimServer, ctx context.Context, repo Repository, id, version string, attempt int, err error) bool {
+	if _, ok := err.(*ResourceNotFoundError); !ok || len(version) > 0 {
+		ErrorCheck(err)
+		return false
+	}
+	retries := server.Property().GetInt("scim.protocol.patchRetries")
+	if retries <= 0 {
+		ErrorCheck(err)
+	}
+	// the resource may as well be gone
+	_, getErr := repo.Get(id, "", ctx)
+	ErrorCheck(getErr)
+	if attempt > retries {
+		ErrorCheck(Error.VersionConflict(id, attempt))
+	}
+	logger(server).Debug("patch retried after a version conflict", LogFields(ctx, "id", id, "attempt", attempt+1)...)
+	return true
+}
+
+// When all operations of a group patch add or remove members and the repository is a MemberPatcher, apply
+// them in place instead of loading and rewriting the group, which is slow for large groups, and respond with
+// 204 No Content, or with the group read back when the client asked for attributes. Returns false when the patch has to take the regular path: for other patches, in dry run
+// and queued mode, and when update hooks, transformers, validators or a patch pipeline expecting the whole group
+// are registered.
+func patchMembers(r WebRequest, server ScimServer, ctx context.Context, ri *ResponseInfo, repo Repository, resourceType string, sch *Schema, id, version string, mod Modification) bool {
+	patcher, ok := inPlaceMemberPatcher(server, ctx, repo, resourceType)
+	if !ok {
+		return false
+	}
+	adds, removes, ok := MemberDelta(mod.Ops)
+	if !ok {
+		return false
+	}
+	var query SearchRequest
+	respond := len(r.Param("attributes")) > 0 || len(r.Param("excludedAttributes")) > 0
+	if respond {
+		var err error
+		query, err = ParseQuery(r, server, sch, false)
+		ErrorCheck(err)
+	}
+
+	newVersion := applyMemberPatch(server, ctx, patcher, sch, id, version, adds, removes)
+	for _, patch := range mod.Ops {
+		metrics(server).PatchOps.Inc(resourceType, patch.Op)
+	}
+	server.AttributeUsage().RecordPatch(resourceType, mod.Ops, sch)
+
+	if !respond {
+		if len(newVersion) > 0 {
+			ri.ETagHeader(newVersion)
+		}
+		ri.Status(http.StatusNoContent)
+		return true
+	}
+
+	var resource DataProvider
+	err := traceStep(server, ctx, "repository.get", func(ctx context.Context) (err error) {
+		resource, err = repo.Get(id, "", ctx)
+		return
+	})
+	ErrorCheck(err)
+	var body []byte
+	err = traceStep(server, ctx, "marshal", func(ctx context.Context) (err error) {
+		body, err = server.MarshalJSON(redact(server, resource, sch, ctx), sch, query.Attributes, query.ExcludedAttributes)
+		return
+	})
+	ErrorCheck(err)
+	meta, _ := resource.GetData()["meta"].(map[string]interface{})
+	// the version read, later than the one written when a concurrent write came in between
+	if version, _ := meta["version"].(string); len(version) > 0 {
+		ri.ETagHeader(version)
+	}
+	if location, _ := meta["location"].(string); len(location) > 0 {
+		ri.LocationHeader(location)
+	}
+	ri.Status(http.StatusOK)
+	ri.ScimJsonHeader()
+	ri.Body(body)
+	return true
+}
+
+// the repository as MemberPatcher, when group patches that only add and remove members may be applied in place
+func inPlaceMemberPatcher(server ScimServer, ctx context.Context, repo Repository, resourceType string) (MemberPatcher, bool) {
+	patcher, ok := AsMemberPatcher(repo)
+	if !ok || resourceType != GroupResourceType || IsDryRun(ctx) || server.OperationQueue() != nil ||
+		server.Hooks().HasUpdateHooks(resourceType) || server.Transformers().Has(resourceType) ||
+		server.Validators().Has(resourceType) || server.Pipelines().Has(resourceType, PatchOperation) {
+		return nil, false
+	}
+	return patcher, true
+}
+
+// validate the added members and apply the members to the group in place, returning the new version
+func applyMemberPatch(server ScimServer, ctx context.Context, patcher MemberPatcher, sch *Schema, id, version string, adds []interface{}, removes []string) string {
+	// the added members are validated on their own
+	added := &Resource{Complex: Complex{"members": adds}}
+	err := traceStep(server, ctx, "validateType", func(ctx context.Context) error {
+		return server.ValidateType(added, sch, ctx)
+	})
+	ErrorCheck(err)
+	err = traceStep(server, ctx, "correctCase", func(ctx context.Context) error {
+		return server.CorrectCase(added, sch, ctx)
+	})
+	ErrorCheck(err)
+	adds, _ = added.Complex["members"].([]interface{})
+
+	// read only values are assigned to a stand-in of the group, of which only meta is stored
+	standIn := &Resource{Complex: Complex{"id": id, "meta": map[string]interface{}{}}}
+	err = traceStep(server, ctx, "assignReadOnlyValue", func(ctx context.Context) error {
+		return server.AssignReadOnlyValue(standIn, ctx)
+	})
+	ErrorCheck(err)
+	meta, _ := standIn.Complex["meta"].(map[string]interface{})
+	delete(meta, "created")
+
+	err = traceStep(server, ctx, "repository.patchMembers", func(ctx context.Context) error {
+		return patcher.PatchMembers(id, version, adds, removes, meta, ctx)
+	})
+	ErrorCheck(err)
+
+	newVersion, _ := meta["version"].(string)
+	return newVersion
+}
+
+// In queued provisioning mode, i.e. when the server has an operation queue, submit the validated mutation
+// and respond with 202 Accepted pointing at the operation status resource. Returns false when the server
+// provisions synchronously and the caller should apply the mutation itself.
+func enqueueOperation(server ScimServer, ctx context.Context, ri *ResponseInfo, op *Operation) bool {
+	queue := server.OperationQueue()
+	if queue == nil {
+		return false
+	}
+
+	err := traceStep(server, ctx, "queue.enqueue", func(ctx context.Context) error {
+		return SubmitOperation(op, queue, server.OperationStore())
+	})
+	if err != nil {
+		logger(server).Warn("operation not queued", LogFields(ctx, "operation", op.Id, "error", err.Error())...)
+	}
+	ErrorCheck(err)
+
+	// the worker may already be processing op, report from the store instead
+	status, err := server.OperationStore().Get(op.Id)
+	ErrorCheck(err)
+	jsonBytes, err := json.Marshal(status)
+	ErrorCheck(err)
+
+	ri.Status(http.StatusAccepted)
+	ri.ScimJsonHeader()
+	if base, ok := ctx.Value(BaseURL{}).(string); ok {
+		ri.LocationHeader(base + "/Operations/" + op.Id)
+	} else {
+		ri.LocationHeader(strings.TrimSuffix(server.Property().GetString("scim.resources.operation.locationBase"), "/") + "/" + op.Id)
+	}
+	ri.Body(jsonBytes)
+	return true
+}
+
+// hide the attributes the caller may not read from a resource or the resources of a list response, and
+// point their meta.location at the base URL of the request
+func redact(server ScimServer, v interface{}, sch *Schema, ctx context.Context) interface{} {
+	ac := server.AccessController()
+	switch v.(type) {
+	case *ListResponse:
+		lr := *(v.(*ListResponse))
+		resources := make([]DataProvider, 0, len(lr.Resources))
+		for _, dp := range lr.Resources {
+			resources = append(resources, RedactUnreadable(Relocate(dp, server.Property(), ctx), sch, ac, ctx))
+		}
+		lr.Resources = resources
+		return &lr
+	case DataProvider:
+		return RedactUnreadable(Relocate(v.(DataProvider), server.Property(), ctx), sch, ac, ctx)
+	}
+	return v
+}
+
+// search the repository, capping the page size at filter.maxResults advertised in the service provider
+// config. When the client asked for more than the cap and the filter indeed yields more, the search is
+// rejected with a tooMany error instead of silently returning a truncated list.
+func searchWithMaxResults(server ScimServer, repo Repository, sr SearchRequest, ctx context.Context) (*ListResponse, error) {
+	maxResults := filterMaxResults(server, ctx)
+	exceeded := false
+	if maxResults > 0 && sr.Count > maxResults {
+		exceeded = true
+		sr.Count = maxResults
+	}
+
+	lr, err := repo.Search(sr, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lr.Partial {
+		logger(server).Warn("search timed out, returning partial results", LogFields(ctx, "filter", logFilter(sr.Filter))...)
+	}
+
+	if exceeded && lr.TotalResults-(sr.StartIndex-1) > maxResults {
+		return nil, Error.TooMany(maxResults)
+	}
+	return lr, nil
+}
+
+// the filter.maxResults setting advertised in the service provider config, 0 if not set
+func filterMaxResults(server ScimServer, ctx context.Context) int {
+	spConfig, err := server.Repository(ServiceProviderConfigResourceType).Get("", "", ctx)
+	if err != nil {
+		return 0
+	}
+	if filter, ok := spConfig.GetData()["filter"].(map[string]interface{}); ok {
+		switch v := filter["maxResults"].(type) {
+		case float64:
+			return int(v)
+		case int:
+			return v
+		case int64:
+			return int(v)
+		}
+	}
+	return 0
 }
 
 // response info
 type ResponseInfo struct {
 	statusCode   int
-	headers      map[string]string
+	headers      http.Header
+	trailers     http.Header
 	responseBody []byte
 }
 
 func newResponse() *ResponseInfo {
 	return &ResponseInfo{
 		statusCode:   http.StatusOK,
-		headers:      map[string]string{},
+		headers:      http.Header{},
 		responseBody: nil,
 	}
 }
@@ -320,7 +1332,25 @@ func (ri *ResponseInfo) GetStatus() int {
 }
 
 func (ri *ResponseInfo) GetHeader(name string) string {
-	return ri.headers[name]
+	return ri.headers.Get(name)
+}
+
+// The first value of every header, see GetHeaderValues for all of them
+func (ri *ResponseInfo) GetHeaders() map[string]string {
+	headers := make(map[string]string, len(ri.headers))
+	for k := range ri.headers {
+		headers[k] = ri.headers.Get(k)
+	}
+	return headers
+}
+
+func (ri *ResponseInfo) GetHeaderValues() http.Header {
+	return ri.headers
+}
+
+// The headers to send after the body, nil if none
+func (ri *ResponseInfo) GetTrailers() http.Header {
+	return ri.trailers
 }
 
 func (ri *ResponseInfo) GetBody() []byte {
@@ -333,22 +1363,39 @@ func (ri *ResponseInfo) Status(statusCode int) *ResponseInfo {
 }
 
 func (ri *ResponseInfo) ScimJsonHeader() *ResponseInfo {
-	ri.headers["Content-Type"] = "application/scim+json"
+	ri.headers.Set("Content-Type", ScimMediaType)
 	return ri
 }
 
 func (ri *ResponseInfo) LocationHeader(location string) *ResponseInfo {
-	ri.headers["Location"] = location
+	ri.headers.Set("Location", location)
 	return ri
 }
 
 func (ri *ResponseInfo) ETagHeader(version string) *ResponseInfo {
-	ri.headers["ETag"] = version
+	ri.headers.Set("ETag", version)
 	return ri
 }
 
+// Set the header, replacing the values it has
 func (ri *ResponseInfo) Header(k, v string) *ResponseInfo {
-	ri.headers[k] = v
+	ri.headers.Set(k, v)
+	return ri
+}
+
+// Add a value to the header, i.e. a second Warning
+func (ri *ResponseInfo) AddHeader(k, v string) *ResponseInfo {
+	ri.headers.Add(k, v)
+	return ri
+}
+
+// Set a header to send after the body, i.e. a checksum or the time the response took. Clients may ignore
+// trailers, and HTTP/1.0 ones cannot receive them.
+func (ri *ResponseInfo) Trailer(k, v string) *ResponseInfo {
+	if ri.trailers == nil {
+		ri.trailers = http.Header{}
+	}
+	ri.trailers.Set(k, v)
 	return ri
 }
 
@@ -366,14 +1413,29 @@ type BulkWebRequest struct {
 	body    []byte
 }
 
+// A request to run a handler with, see RunHandler; the id of the resource it targets, if any, is the
+// resourceId parameter
+func NewWebRequest(method, target, resourceId string, headers map[string]string, body []byte) BulkWebRequest {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	return BulkWebRequest{
+		target:  target,
+		method:  method,
+		headers: headers,
+		params:  map[string]string{"resourceId": resourceId},
+		body:    body,
+	}
+}
+
 func (bwr BulkWebRequest) Target() string            { return bwr.target }
 func (bwr BulkWebRequest) Method() string            { return bwr.method }
 func (bwr BulkWebRequest) Header(name string) string { return bwr.headers[name] }
 func (bwr BulkWebRequest) Param(name string) string  { return bwr.params[name] }
 func (bwr BulkWebRequest) Body() ([]byte, error)     { return bwr.body, nil }
-func (bwr BulkWebRequest) Populate(op BulkReqOp, ps PropertySource) {
+func (bwr *BulkWebRequest) Populate(op BulkReqOp, ps PropertySource) {
 	userUri := ps.GetString("scim.protocol.uri.user")
-	groupUri := ps.GetString("scim.protocol.uri.user")
+	groupUri := ps.GetString("scim.protocol.uri.group")
 
 	bwr.target = op.Path
 	bwr.method = strings.ToUpper(op.Method)
@@ -0,0 +1,168 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// the handlers are tested through servers as config builds them, on the memory repository
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Schemas = config.SchemaConfig{
+		Root:          "../resources/schemas/root_internal.json",
+		User:          "../resources/schemas/user_internal.json",
+		Group:         "../resources/schemas/group_internal.json",
+		Role:          "../resources/schemas/role_internal.json",
+		Entitlement:   "../resources/schemas/entitlement_internal.json",
+		Served:        []string{"../resources/schemas/user.json", "../resources/schemas/group.json"},
+		ResourceTypes: []string{"../resources/resource_types/user.json", "../resources/resource_types/group.json"},
+		SPConfig:      "../resources/sp_config/sp_config.json",
+	}
+	cfg.Repository.Kind = config.MemoryRepository
+	cfg.LogLevel = "error"
+	return cfg
+}
+
+func TestRespondDryRun(t *testing.T) {
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	dryRun := map[string]string{"X-Dry-Run": "true"}
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "david"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+	// a copy, in case a dry run changed the stored resource in place
+	stored := func() map[string]interface{} {
+		dp, err := server.Repository(shared.UserResourceType).Get(id, "", context.Background())
+		require.Nil(t, err)
+		return (&shared.Resource{Complex: dp.GetData()}).DeepCopy().GetData()
+	}
+	count := func() interface{} {
+		rw := scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`userName pr`), nil, nil)
+		require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)["totalResults"]
+	}
+	before := stored()
+
+	// responds with the resource as it would have been stored, by the parameter or the header
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users?dryRun=true", `{"schemas": ["`+shared.UserUrn+`"], "userName": "alice", "password": "t0ps3cret"}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, "true", rw.Header().Get("X-Dry-Run"))
+	assert.Empty(t, rw.Header().Get("Location"))
+	created := scimtest.Decode(t, rw)
+	assert.Equal(t, "alice", created["userName"])
+	assert.NotEmpty(t, created["id"])
+	assert.NotContains(t, created, "password")
+	assert.Equal(t, float64(1), count())
+
+	rw = scimtest.Serve(t, handler, http.MethodPut, "/v2/Users/"+id, `{"schemas": ["`+shared.UserUrn+`"], "userName": "david", "nickName": "dave"}`, dryRun)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, "true", rw.Header().Get("X-Dry-Run"))
+	assert.Equal(t, "dave", scimtest.Decode(t, rw)["nickName"])
+	assert.Equal(t, before, stored())
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [{"op": "replace", "path": "displayName", "value": "David"}]
+	}`, dryRun)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, "true", rw.Header().Get("X-Dry-Run"))
+	assert.Equal(t, "David", scimtest.Decode(t, rw)["displayName"])
+	assert.Equal(t, before, stored())
+
+	rw = scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, dryRun)
+	assert.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.Equal(t, "true", rw.Header().Get("X-Dry-Run"))
+	assert.Equal(t, before, stored())
+	assert.Equal(t, float64(1), count())
+
+	// validations still apply
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users?dryRun=true", `{"schemas": ["`+shared.UserUrn+`"], "userName": "david"}`, nil)
+	assert.Equal(t, http.StatusConflict, rw.Code, rw.Body.String())
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/missing", nil, dryRun).Code)
+}
+
+// a repository reporting the ids of the resources updated
+type notifyingRepository struct {
+	shared.Repository
+	updated chan string
+}
+
+func (r *notifyingRepository) Update(id, version string, provider shared.DataProvider, ctx context.Context) error {
+	if err := r.Repository.Update(id, version, provider, ctx); err != nil {
+		return err
+	}
+	if r.updated != nil {
+		r.updated <- id
+	}
+	return nil
+}
+
+// a repository handing out the resources it stores rather than copies of them, like a memory store may
+type sharingRepository struct {
+	shared.Repository
+	stored map[string]shared.DataProvider
+}
+
+func (r *sharingRepository) Get(id, version string, ctx context.Context) (shared.DataProvider, error) {
+	if stored, ok := r.stored[id]; ok {
+		return stored, nil
+	}
+	stored, err := r.Repository.Get(id, version, ctx)
+	if err == nil {
+		r.stored[id] = stored
+	}
+	return stored, err
+}
+
+func (r *sharingRepository) Update(id, version string, provider shared.DataProvider, ctx context.Context) error {
+	delete(r.stored, id)
+	return r.Repository.Update(id, version, provider, ctx)
+}
+
+// a map repository, which checks versions like a database does, of which the first updates lose the race against
+// a concurrent write
+type racingRepository struct {
+	shared.Repository
+	races  int
+	writes int
+	gets   int
+	// the attribute the concurrent writes set, nickName if empty
+	attribute string
+}
+
+func (r *racingRepository) Get(id, version string, ctx context.Context) (shared.DataProvider, error) {
+	r.gets++
+	return r.Repository.Get(id, version, ctx)
+}
+
+func (r *racingRepository) Update(id, version string, provider shared.DataProvider, ctx context.Context) error {
+	if r.races > 0 {
+		r.races--
+		r.writes++
+		stored, err := r.Repository.Get(id, "", ctx)
+		if err != nil {
+			return err
+		}
+		if len(r.attribute) > 0 {
+			stored.GetData()[r.attribute] = "concurrent"
+		} else {
+			stored.GetData()["nickName"] = "concurrent"
+		}
+		stored.GetData()["meta"].(map[string]interface{})["version"] = fmt.Sprintf("W/\"concurrent-%d\"", r.writes)
+		if err := r.Repository.Update(id, "", stored, ctx); err != nil {
+			return err
+		}
+	}
+	return r.Repository.Update(id, version, provider, ctx)
+}
@@ -6,16 +6,69 @@ import (
 	"net/http"
 )
 
+// the features of the service provider configuration that are gone once the operation is turned off for all
+// resource types
+var featureOperations = map[string]string{
+	"patch":  "patch",
+	"filter": "query",
+}
+
 func GetServiceProviderConfigHandler(r shared.WebRequest, server ScimServer, ctx context.Context) (ri *ResponseInfo) {
 	ri = newResponse()
 
 	repo := server.Repository(shared.ServiceProviderConfigResourceType)
-	spConfig, err := repo.Get("", "")
+	spConfig, err := repo.Get("", "", ctx)
 	ErrorCheck(err)
-	jsonBytes, err := server.MarshalJSON(spConfig.GetData(), nil, nil, nil)
+	jsonBytes, err := server.MarshalJSON(applyOperationToggles(spConfig.GetData(), server.OperationToggles()), nil, nil, nil)
 	ErrorCheck(err)
 
 	ri.Status(http.StatusOK)
 	ri.Body(jsonBytes)
 	return
 }
+
+// a copy of the service provider configuration reflecting the disabled operations: features no resource type
+// supports anymore are unsupported, and those of only some resource types are listed per resource type
+func applyOperationToggles(data shared.Complex, toggles *shared.OperationToggles) shared.Complex {
+	if toggles == nil {
+		return data
+	}
+	resourceTypes := []string{shared.UserResourceType, shared.GroupResourceType}
+	copied := make(shared.Complex, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+
+	for name, op := range featureOperations {
+		enabled := false
+		for _, rt := range resourceTypes {
+			enabled = enabled || toggles.Enabled(rt, op)
+		}
+		if enabled {
+			continue
+		}
+		feature := make(map[string]interface{})
+		if original, ok := data[name].(map[string]interface{}); ok {
+			for k, v := range original {
+				feature[k] = v
+			}
+		}
+		feature["supported"] = false
+		copied[name] = feature
+	}
+
+	disabled := make(map[string]interface{})
+	for _, rt := range resourceTypes {
+		if ops := toggles.Disabled(rt); len(ops) > 0 {
+			list := make([]interface{}, 0, len(ops))
+			for _, op := range ops {
+				list = append(list, op)
+			}
+			disabled[rt] = list
+		}
+	}
+	if len(disabled) > 0 {
+		copied["disabledOperations"] = disabled
+	}
+	return copied
+}
@@ -0,0 +1,417 @@
+package handlers_test
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/config"
+	"github.com/davidiamyou/go-scim/scimtest"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDeleteUserByIdHandler_IfMatch(t *testing.T) {
+	create := func(handler http.Handler, userName string) (string, string) {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"`+userName+`"}`, nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)["id"].(string), rw.Header().Get("ETag")
+	}
+	ifMatch := func(version string) map[string]string {
+		return map[string]string{"If-Match": version}
+	}
+
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	id, version := create(handler, "bjensen")
+	require.NotEmpty(t, version)
+
+	assert.Equal(t, http.StatusPreconditionFailed, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, ifMatch(`W/"stale"`)).Code)
+	rw := scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, ifMatch(version))
+	assert.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.Equal(t, version, rw.Header().Get("ETag"))
+	// no version of a resource that is gone matches, as RFC 7232 section 3.1 prescribes
+	assert.Equal(t, http.StatusPreconditionFailed, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, ifMatch(version)).Code)
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, nil).Code)
+
+	// without If-Match, deletes remove whatever version is stored
+	id, _ = create(handler, "mary")
+	rw = scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, nil)
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	assert.Empty(t, rw.Header().Get("ETag"))
+
+	cfg := testConfig()
+	cfg.Features.ETag = shared.ETagRequired
+	server, err = config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler = server.Handler()
+	id, version = create(handler, "bjensen")
+	assert.Equal(t, http.StatusPreconditionRequired, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, nil).Code)
+	rw = scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, ifMatch("*"))
+	assert.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	assert.Empty(t, rw.Header().Get("ETag"), "whatever version is stored is deleted")
+}
+
+func TestDeleteUserByIdHandler_ConcurrentWrite(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &overtakingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})}
+	server, err := config.NewServer(config.WithConfig(testConfig()), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	create := func() (string, string) {
+		rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"david"}`, nil)
+		require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+		return scimtest.Decode(t, rw)["id"].(string), rw.Header().Get("ETag")
+	}
+
+	// a write between the check of If-Match and the delete fails the delete rather than being deleted
+	id, version := create()
+	rw := scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, map[string]string{"If-Match": version})
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code, rw.Body.String())
+	_, err = users.Get(id, "", context.Background())
+	assert.Nil(t, err)
+	require.Nil(t, users.Repository.Delete(id, "", context.Background()))
+
+	// If-Match "*" deletes whatever version is stored
+	id, _ = create()
+	rw = scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	_, err = users.Get(id, "", context.Background())
+	assert.IsType(t, &shared.ResourceNotFoundError{}, err)
+}
+
+// a map repository of which every delete is overtaken by a write of the resource
+type overtakingRepository struct {
+	shared.Repository
+}
+
+func (r *overtakingRepository) Delete(id, version string, ctx context.Context) error {
+	dp, err := r.Repository.Get(id, "", ctx)
+	if err != nil {
+		return err
+	}
+	dp.GetData()["meta"].(map[string]interface{})["version"] = `W/"overtaken"`
+	if err := r.Repository.Update(id, "", dp, ctx); err != nil {
+		return err
+	}
+	return r.Repository.Delete(id, version, ctx)
+}
+
+func TestUserHandlers_Tombstones(t *testing.T) {
+	cfg := testConfig()
+	cfg.Repository.Tombstones = time.Hour
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	user := `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "david"}`
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", user, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	require.Equal(t, http.StatusNoContent, scimtest.Serve(t, handler, http.MethodDelete, "/v2/Users/"+id, nil, nil).Code)
+
+	patch := `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "active", "value": false}]}`
+	for _, test := range []struct {
+		method string
+		body   interface{}
+	}{
+		{http.MethodGet, nil},
+		{http.MethodPut, user},
+		{http.MethodPatch, patch},
+		{http.MethodDelete, nil},
+	} {
+		rw = scimtest.Serve(t, handler, test.method, "/v2/Users/"+id, test.body, nil)
+		assert.Equal(t, http.StatusGone, rw.Code, test.method)
+		assert.Contains(t, rw.Body.String(), "was deleted at", test.method)
+	}
+
+	// ids that never existed are still not found
+	assert.Equal(t, http.StatusNotFound, scimtest.Serve(t, handler, http.MethodGet, "/v2/Users/missing", nil, nil).Code)
+}
+
+func TestPatchUserHandler_Retries(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &racingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})}
+	cfg := testConfig()
+	cfg.Protocol.PatchRetries = 3
+	server, err := config.NewServer(config.WithConfig(cfg), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "david"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+	patch := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "displayName", "value": "David"}]
+	}`
+
+	// the resource is fetched once, its copy serving as the reference
+	users.gets = 0
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, patch, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, 1, users.gets)
+
+	// applied again on top of the concurrent writes
+	users.races = 3
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, patch, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	stored, err := users.Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "David", stored.GetData()["displayName"])
+	assert.Equal(t, "concurrent", stored.GetData()["nickName"])
+
+	users.races = 4
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, patch, nil)
+	assert.Equal(t, http.StatusConflict, rw.Code, rw.Body.String())
+
+	// the version asked for is not replaced by a later one
+	users.races = 1
+	stored, _ = users.Get(id, "", context.Background())
+	version := stored.GetData()["meta"].(map[string]interface{})["version"].(string)
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, patch, map[string]string{"If-Match": version})
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code, rw.Body.String())
+}
+
+func TestPatchUserHandler_RetriesConflicts(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})
+	// the first update of nickName loses the race against a concurrent write to the repository
+	races := 1
+	hooks := shared.NewHooks().BeforeUpdate(shared.UserResourceType, func(resource *shared.Resource, reference *shared.Resource, ctx context.Context) error {
+		if races == 0 {
+			return nil
+		}
+		races--
+		stored, err := users.Get(resource.GetId(), "", ctx)
+		if err != nil {
+			return err
+		}
+		stored.GetData()["displayName"] = "concurrent"
+		stored.GetData()["meta"].(map[string]interface{})["version"] = "W/\"concurrent\""
+		return users.Update(resource.GetId(), "", stored, ctx)
+	})
+	cfg := testConfig()
+	cfg.Protocol.PatchRetries = 1
+	server, err := config.NewServer(config.WithConfig(cfg), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users), config.WithHooks(hooks))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "david"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "nickName", "value": "dave"}]
+	}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, 0, races)
+	stored, err := users.Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "concurrent", stored.GetData()["displayName"])
+	assert.Equal(t, "dave", stored.GetData()["nickName"])
+	assert.NotEqual(t, "W/\"concurrent\"", stored.GetData()["meta"].(map[string]interface{})["version"])
+}
+
+func TestUserHandlers_IfMatch(t *testing.T) {
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"david"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+	version := rw.Header().Get("ETag")
+
+	patch := `{"schemas": ["` + shared.PatchOpUrn + `"], "Operations": [{"op": "replace", "path": "nickName", "value": "dave"}]}`
+	replace := `{"schemas":["` + shared.UserUrn + `"],"userName":"david"}`
+	for _, test := range []struct {
+		method  string
+		body    string
+		ifMatch string
+		status  int
+	}{
+		{http.MethodPatch, patch, `W/"stale"`, http.StatusPreconditionFailed},
+		{http.MethodPut, replace, `W/"stale"`, http.StatusPreconditionFailed},
+		{http.MethodPatch, patch, `W/"stale", ` + version, http.StatusOK},
+		{http.MethodPut, replace, "*", http.StatusOK},
+	} {
+		rw = scimtest.Serve(t, handler, test.method, "/v2/Users/"+id, test.body, map[string]string{"If-Match": test.ifMatch})
+		assert.Equal(t, test.status, rw.Code, test.method+" "+test.ifMatch+" "+rw.Body.String())
+	}
+	rw = scimtest.Serve(t, handler, http.MethodPut, "/v2/Users/missing", replace, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code, rw.Body.String())
+}
+
+func TestPatchUserHandler_ImplicitReplace(t *testing.T) {
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"bjensen","nickName":"Babs"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	// the attributes of the value are replaced, those it leaves out kept
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [{"op": "replace", "value": {"displayName": "Barbara Jensen", "name": {"givenName": "Barbara"}}}]
+	}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	user := scimtest.Decode(t, rw)
+	assert.Equal(t, "Barbara Jensen", user["displayName"])
+	assert.Equal(t, "Barbara", user["name"].(map[string]interface{})["givenName"])
+	assert.Equal(t, "Babs", user["nickName"])
+
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [{"op": "replace", "value": "Barbara Jensen"}]
+	}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+}
+
+func TestPatchUserHandler_FailedPatch(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &sharingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{}), stored: map[string]shared.DataProvider{}}
+	server, err := config.NewServer(config.WithConfig(testConfig()), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"david","nickName":"david"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	// the operation that did apply is not left behind in the resource the repository stores
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [
+			{"op": "replace", "path": "nickName", "value": "dave"},
+			{"op": "replace", "path": "unknown", "value": "dave"}
+		]
+	}`, nil)
+	require.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+	stored, err := users.Get(id, "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "david", stored.GetData()["nickName"])
+}
+
+func TestUserHandlers_OtherDataProvider(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := &wrappingRepository{Repository: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})}
+	server, err := config.NewServer(config.WithConfig(testConfig()), config.WithSchema(shared.UserResourceType, sch), config.WithRepository(shared.UserResourceType, users))
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas":["`+shared.UserUrn+`"],"userName":"david"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	// repositories handing out data providers other than shared.Resource are patched and replaced
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [{"op": "replace", "path": "nickName", "value": "dave"}]
+	}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, "dave", scimtest.Decode(t, rw)["nickName"])
+
+	rw = scimtest.Serve(t, handler, http.MethodPut, "/v2/Users/"+id, `{"schemas":["`+shared.UserUrn+`"],"userName":"david","nickName":"d"}`, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, "d", scimtest.Decode(t, rw)["nickName"])
+}
+
+// a repository handing out its resources as another data provider
+type wrappingRepository struct {
+	shared.Repository
+}
+
+func (r *wrappingRepository) Get(id, version string, ctx context.Context) (shared.DataProvider, error) {
+	dp, err := r.Repository.Get(id, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wrappedResource{dp}, nil
+}
+
+type wrappedResource struct {
+	shared.DataProvider
+}
+
+func TestUserHandlers_AttributeUsage(t *testing.T) {
+	cfg := testConfig()
+	cfg.Features.AttributeUsage = true
+	server, err := config.Build(cfg)
+	require.Nil(t, err)
+	defer server.Close()
+	handler := server.Handler()
+	usage := func() map[string]shared.AttributeUsageStat {
+		stats := make(map[string]shared.AttributeUsageStat)
+		for _, stat := range server.AttributeUsage().Report(shared.UserResourceType, server.InternalSchema(shared.UserUrn)) {
+			stats[stat.Path] = stat
+		}
+		return stats
+	}
+
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "david", "nickName": "dave"}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := scimtest.Decode(t, rw)["id"].(string)
+
+	// rejected writes are not counted
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "david", "nickName": "dave"}`, nil)
+	require.Equal(t, http.StatusConflict, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodPatch, "/v2/Users/"+id, `{
+		"schemas": ["`+shared.PatchOpUrn+`"],
+		"Operations": [{"op": "replace", "path": "nickName", "value": "dave"}, {"op": "replace", "path": "active", "value": "maybe"}]
+	}`, nil)
+	require.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+	assert.Equal(t, int64(1), usage()["userName"].Writes)
+	assert.Equal(t, int64(1), usage()["nickName"].Writes)
+
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`nickName eq "dave"`)+"&attributes=userName", nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	rw = scimtest.Serve(t, handler, http.MethodGet, "/v2/Users?filter="+url.QueryEscape(`nickName eq`), nil, nil)
+	require.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+	assert.Equal(t, int64(1), usage()["nickName"].Filters)
+	assert.Equal(t, int64(1), usage()["userName"].Reads)
+}
+
+func TestUserHandlers_Transformers(t *testing.T) {
+	server, err := config.Build(testConfig())
+	require.Nil(t, err)
+	defer server.Close()
+	lowerCase, err := shared.MatchPattern("^[a-z.]+$", "lower case")
+	require.Nil(t, err)
+	nickName, err := shared.DeriveAttribute("nickName", "${name.givenName}.${name.familyName}")
+	require.Nil(t, err)
+	server.Validators().Register(shared.UserResourceType, "nickName", lowerCase)
+	server.Transformers().Register(shared.UserResourceType, shared.LowerCaseAttribute("userName"), nickName)
+	handler := server.Handler()
+
+	// transformers find attributes whatever their case
+	rw := scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "UserName": "David", "name": {"givenName": "david", "familyName": "qiu"}}`, nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.Equal(t, "david", scimtest.Decode(t, rw)["userName"])
+	assert.Equal(t, "david.qiu", scimtest.Decode(t, rw)["nickName"])
+
+	// and what they assign is validated
+	rw = scimtest.Serve(t, handler, http.MethodPost, "/v2/Users", `{"schemas": ["`+shared.UserUrn+`"], "userName": "alice", "name": {"givenName": "Alice", "familyName": "Liddell"}}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+}
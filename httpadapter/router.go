@@ -0,0 +1,305 @@
+// Package httpadapter mounts the SCIM endpoints on the standard net/http stack.
+package httpadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Customizes the router returned by NewRouter
+type Option func(rt *router)
+
+// Serve all endpoints below the prefix, e.g. /v2
+func WithPrefix(prefix string) Option {
+	return func(rt *router) {
+		rt.prefix = "/" + strings.Trim(prefix, "/")
+		if rt.prefix == "/" {
+			rt.prefix = ""
+		}
+	}
+}
+
+// Wrap every handler with the wrapper instead of handlers.Chain
+func WithWrapper(wrap func(handler handlers.EndpointHandler, requestType int) handlers.EndpointHandler) Option {
+	return func(rt *router) {
+		rt.wrap = wrap
+	}
+}
+
+// Serve the maintenance endpoints below /Admin, which are off by default: GET /Admin/Counts,
+// POST /Admin/Reindex, POST /Admin/RebuildMembership, POST /Admin/Purge?olderThanDays=N,
+// GET /Admin/AttributeUsage and GET /Admin/IndexAdvice?minFilters=N.
+// Requests are let through only when authorize approves them, independent of how SCIM requests are
+// authenticated; the others receive 401.
+func WithAdmin(authorize func(req *http.Request) bool) Option {
+	return func(rt *router) {
+		rt.authorizeAdmin = authorize
+	}
+}
+
+// Serve the Role and Entitlement endpoints, /Roles and /Entitlements, which are off by default. The server
+// must have the repositories and internal schemas of both resource types.
+func WithRoles() Option {
+	return func(rt *router) {
+		rt.roles = true
+	}
+}
+
+// Serve POST /Groups/{id}/members, applying a membership delta to a group in a single write, which is off by
+// default, see handlers.ApplyMembershipDelta
+func WithMembershipDelta() Option {
+	return func(rt *router) {
+		rt.membershipDelta = true
+	}
+}
+
+// Returns a handler serving the User, Group, discovery, Bulk, Operations and Exports endpoints of the server, along with
+// the /healthz and /readyz probes.
+// Requests with a method the path does not support receive 405 with an Allow header; content negotiation
+// is left to the handlers.Negotiate wrapper, which handlers.Chain includes.
+func NewRouter(server handlers.ScimServer, opts ...Option) http.Handler {
+	rt := &router{server: server, wrap: handlers.Chain}
+	for _, opt := range opts {
+		opt(rt)
+	}
+
+	rt.handle(http.MethodGet, "/Users/:resourceId", handlers.GetUserByIdHandler, shared.GetUserById)
+	rt.handle(http.MethodPost, "/Users", handlers.CreateUserHandler, shared.CreateUser)
+	rt.handle(http.MethodDelete, "/Users/:resourceId", handlers.DeleteUserByIdHandler, shared.DeleteUser)
+	rt.handle(http.MethodGet, "/Users", handlers.QueryUserHandler, shared.QueryUser)
+	rt.handle(http.MethodPost, "/Users/.search", handlers.QueryUserHandler, shared.QueryUser)
+	rt.handle(http.MethodPost, "/Users/.export", handlers.ExportUsersHandler, shared.ExportUsers)
+	rt.handle(http.MethodPut, "/Users/:resourceId", handlers.ReplaceUserHandler, shared.ReplaceUser)
+	rt.handle(http.MethodPatch, "/Users/:resourceId", handlers.PatchUserHandler, shared.PatchUser)
+	rt.handle(http.MethodPost, "/Users/:resourceId/.password", handlers.ChangeUserPasswordHandler, shared.ChangeUserPassword)
+	rt.handle(http.MethodGet, "/Users/:resourceId/managers", handlers.GetUserManagersHandler, shared.GetUserManagers)
+
+	rt.handle(http.MethodGet, "/Groups/:resourceId", handlers.GetGroupByIdHandler, shared.GetGroupById)
+	rt.handle(http.MethodGet, "/Groups/:resourceId/members", handlers.GetGroupMembersHandler, shared.GetGroupMembers)
+	rt.handle(http.MethodPost, "/Groups", handlers.CreateGroupHandler, shared.CreateGroup)
+	rt.handle(http.MethodDelete, "/Groups/:resourceId", handlers.DeleteGroupByIdHandler, shared.DeleteGroup)
+	rt.handle(http.MethodGet, "/Groups", handlers.QueryGroupHandler, shared.QueryGroup)
+	rt.handle(http.MethodPost, "/Groups/.search", handlers.QueryGroupHandler, shared.QueryGroup)
+	rt.handle(http.MethodPost, "/Groups/.export", handlers.ExportGroupsHandler, shared.ExportGroups)
+	rt.handle(http.MethodPut, "/Groups/:resourceId", handlers.ReplaceGroupHandler, shared.ReplaceGroup)
+	rt.handle(http.MethodPatch, "/Groups/:resourceId", handlers.PatchGroupHandler, shared.PatchGroup)
+
+	rt.handle(http.MethodPost, "/Bulk", handlers.BulkHandler, shared.BulkOp)
+
+	rt.handle(http.MethodGet, "/", handlers.RootQueryHandler, shared.RootQuery)
+	rt.handle(http.MethodPost, "/.search", handlers.RootQueryHandler, shared.RootQuery)
+
+	rt.handle(http.MethodGet, "/Schemas/:resourceId", handlers.GetSchemaByIdHandler, shared.GetSchemaById)
+	rt.handle(http.MethodGet, "/Schemas", handlers.GetAllSchemaHandler, shared.GetAllSchema)
+	rt.handle(http.MethodGet, "/ResourceTypes", handlers.GetAllResourceTypeHandler, shared.GetAllResourceType)
+	rt.handle(http.MethodGet, "/ServiceProviderConfig", handlers.GetServiceProviderConfigHandler, shared.GetSPConfig)
+
+	rt.handle(http.MethodGet, "/Operations/:resourceId", handlers.GetOperationByIdHandler, shared.GetOperationById)
+	rt.handle(http.MethodGet, "/Exports/:resourceId", handlers.GetExportByIdHandler, shared.GetExportById)
+	rt.handle(http.MethodGet, "/Exports/:resourceId/download", handlers.DownloadExportHandler, shared.DownloadExport)
+	rt.handle(http.MethodDelete, "/Exports/:resourceId", handlers.DeleteExportByIdHandler, shared.DeleteExport)
+
+	if rt.membershipDelta {
+		rt.handle(http.MethodPost, "/Groups/:resourceId/members", handlers.PatchGroupMembersHandler, shared.PatchGroup)
+	}
+
+	if rt.roles {
+		rt.handle(http.MethodGet, "/Roles/:resourceId", handlers.GetRoleByIdHandler, shared.GetRoleById)
+		rt.handle(http.MethodPost, "/Roles", handlers.CreateRoleHandler, shared.CreateRole)
+		rt.handle(http.MethodDelete, "/Roles/:resourceId", handlers.DeleteRoleByIdHandler, shared.DeleteRole)
+		rt.handle(http.MethodGet, "/Roles", handlers.QueryRoleHandler, shared.QueryRole)
+		rt.handle(http.MethodPost, "/Roles/.search", handlers.QueryRoleHandler, shared.QueryRole)
+		rt.handle(http.MethodPut, "/Roles/:resourceId", handlers.ReplaceRoleHandler, shared.ReplaceRole)
+		rt.handle(http.MethodPatch, "/Roles/:resourceId", handlers.PatchRoleHandler, shared.PatchRole)
+
+		rt.handle(http.MethodGet, "/Entitlements/:resourceId", handlers.GetEntitlementByIdHandler, shared.GetEntitlementById)
+		rt.handle(http.MethodPost, "/Entitlements", handlers.CreateEntitlementHandler, shared.CreateEntitlement)
+		rt.handle(http.MethodDelete, "/Entitlements/:resourceId", handlers.DeleteEntitlementByIdHandler, shared.DeleteEntitlement)
+		rt.handle(http.MethodGet, "/Entitlements", handlers.QueryEntitlementHandler, shared.QueryEntitlement)
+		rt.handle(http.MethodPost, "/Entitlements/.search", handlers.QueryEntitlementHandler, shared.QueryEntitlement)
+		rt.handle(http.MethodPut, "/Entitlements/:resourceId", handlers.ReplaceEntitlementHandler, shared.ReplaceEntitlement)
+		rt.handle(http.MethodPatch, "/Entitlements/:resourceId", handlers.PatchEntitlementHandler, shared.PatchEntitlement)
+	}
+
+	// probes bypass the wrapper, they must neither be rate limited nor negotiate content
+	rt.routes = append(rt.routes,
+		&route{method: http.MethodGet, segments: splitPath("/healthz"), handler: handlers.ErrorRecovery(handlers.HealthHandler)},
+		&route{method: http.MethodGet, segments: splitPath("/readyz"), handler: handlers.ErrorRecovery(handlers.ReadinessHandler)},
+	)
+
+	if rt.authorizeAdmin != nil {
+		rt.handleAdmin(http.MethodGet, "/Admin/Counts", handlers.AdminCountsHandler)
+		rt.handleAdmin(http.MethodPost, "/Admin/Reindex", handlers.AdminReindexHandler)
+		rt.handleAdmin(http.MethodPost, "/Admin/RebuildMembership", handlers.AdminRebuildMembershipHandler)
+		rt.handleAdmin(http.MethodPost, "/Admin/Purge", handlers.AdminPurgeHandler)
+		rt.handleAdmin(http.MethodGet, "/Admin/AttributeUsage", handlers.AdminAttributeUsageHandler)
+		rt.handleAdmin(http.MethodGet, "/Admin/IndexAdvice", handlers.AdminIndexAdviceHandler)
+	}
+
+	// literal segments win over parameters, i.e. /Users/.search over /Users/:resourceId
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return literals(rt.routes[i]) > literals(rt.routes[j])
+	})
+	return rt
+}
+
+type router struct {
+	server         handlers.ScimServer
+	prefix         string
+	wrap           func(handler handlers.EndpointHandler, requestType int) handlers.EndpointHandler
+	authorizeAdmin func(req *http.Request) bool
+	roles          bool
+	routes         []*route
+
+	membershipDelta bool
+}
+
+type route struct {
+	method    string
+	segments  []string
+	handler   handlers.EndpointHandler
+	authorize func(req *http.Request) bool
+}
+
+func (rt *router) handle(method, pattern string, handler handlers.EndpointHandler, requestType int) {
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		segments: splitPath(pattern),
+		handler:  rt.wrap(handler, requestType),
+	})
+}
+
+func (rt *router) handleAdmin(method, pattern string, handler handlers.EndpointHandler) {
+	rt.handle(method, pattern, handler, shared.Maintenance)
+	rt.routes[len(rt.routes)-1].authorize = rt.authorizeAdmin
+}
+
+// match the path against the pattern segments, collecting the values of :param segments
+func (r *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, ":") {
+			params[s[1:]] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (rt *router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	if len(rt.prefix) > 0 {
+		if path != rt.prefix && !strings.HasPrefix(path, rt.prefix+"/") {
+			writeError(rw, http.StatusNotFound, "No endpoint at "+path)
+			return
+		}
+		path = strings.TrimPrefix(path, rt.prefix)
+	}
+	segments := splitPath(path)
+
+	allowed := make([]string, 0)
+	for _, r := range rt.routes {
+		params, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		if r.method != req.Method {
+			allowed = append(allowed, r.method)
+			continue
+		}
+		if r.authorize != nil && !r.authorize(req) {
+			writeError(rw, http.StatusUnauthorized, "Not authorized for "+path)
+			return
+		}
+		ctx := context.WithValue(req.Context(), pathParams{}, params)
+		req = req.WithContext(context.WithValue(ctx, shared.RemoteAddr{}, req.RemoteAddr))
+		handlers.WriteResponse(rw, r.handler(NewWebRequest(req), rt.server, req.Context()))
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		rw.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(rw, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s not allowed at %s", req.Method, path))
+		return
+	}
+	writeError(rw, http.StatusNotFound, "No endpoint at "+path)
+}
+
+func literals(r *route) int {
+	n := 0
+	for _, s := range r.segments {
+		if !strings.HasPrefix(s, ":") {
+			n++
+		}
+	}
+	return n
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if len(trimmed) == 0 {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+func writeError(rw http.ResponseWriter, status int, detail string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"schemas": []string{shared.ErrorUrn},
+		"Status":  strconv.Itoa(status),
+		"detail":  detail,
+	})
+	rw.Header().Set("Content-Type", "application/scim+json")
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+type pathParams struct{}
+
+// read the body through http.MaxBytesReader, failing with PayloadTooLargeError once it exceeds maxBytes
+func readLimited(rw http.ResponseWriter, body io.ReadCloser, maxBytes int) ([]byte, error) {
+	read, err := ioutil.ReadAll(http.MaxBytesReader(rw, body, int64(maxBytes)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, shared.Error.PayloadTooLarge(maxBytes)
+	}
+	return read, err
+}
+
+// Adapts an http request to a WebRequest. Params are looked up in the query first and then among
+// the path parameters the router extracted.
+func NewWebRequest(req *http.Request) shared.WebRequest {
+	return webRequest{req}
+}
+
+type webRequest struct{ req *http.Request }
+
+func (r webRequest) Target() string            { return r.req.RequestURI }
+func (r webRequest) Method() string            { return r.req.Method }
+func (r webRequest) Header(name string) string { return r.req.Header.Get(name) }
+func (r webRequest) Body() ([]byte, error)     { return ioutil.ReadAll(r.req.Body) }
+func (r webRequest) LimitedBody(maxBytes int) ([]byte, error) {
+	return readLimited(nil, r.req.Body, maxBytes)
+}
+func (r webRequest) Param(name string) string {
+	if v := r.req.URL.Query().Get(name); len(v) > 0 {
+		return v
+	}
+	params, _ := r.req.Context().Value(pathParams{}).(map[string]string)
+	return params[name]
+}
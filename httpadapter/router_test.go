@@ -0,0 +1,129 @@
+package httpadapter
+
+import (
+	"context"
+	"fmt"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	// echo the request type and the resource id instead of running the handler
+	router := NewRouter(nil, WithPrefix("/v2"), WithWrapper(
+		func(handler handlers.EndpointHandler, requestType int) handlers.EndpointHandler {
+			return handlers.ErrorRecovery(handlers.Negotiate(
+				func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+					ri := &handlers.ResponseInfo{}
+					return ri.Status(http.StatusOK).Body([]byte(fmt.Sprintf("%d %s", requestType, r.Param("resourceId"))))
+				}))
+		}))
+
+	for _, test := range []struct {
+		method  string
+		target  string
+		headers map[string]string
+		status  int
+		body    string
+		allow   string
+	}{
+		{http.MethodGet, "/v2/Users/foo", nil, http.StatusOK, fmt.Sprintf("%d foo", shared.GetUserById), ""},
+		{http.MethodGet, "/v2/Groups/foo/members", nil, http.StatusOK, fmt.Sprintf("%d foo", shared.GetGroupMembers), ""},
+		{http.MethodGet, "/v2/Users/foo/managers", nil, http.StatusOK, fmt.Sprintf("%d foo", shared.GetUserManagers), ""},
+		{http.MethodPost, "/v2/Users/foo/.password", map[string]string{"Content-Type": "application/scim+json"}, http.StatusOK, fmt.Sprintf("%d foo", shared.ChangeUserPassword), ""},
+		{http.MethodPost, "/v2/Users/.search", map[string]string{"Content-Type": "application/scim+json; charset=utf-8"}, http.StatusOK, fmt.Sprintf("%d ", shared.QueryUser), ""},
+		{http.MethodGet, "/v2/", nil, http.StatusOK, fmt.Sprintf("%d ", shared.RootQuery), ""},
+		{http.MethodPost, "/v2/Users/foo", map[string]string{"Content-Type": "application/json"}, http.StatusMethodNotAllowed, "", "DELETE, GET, PATCH, PUT"},
+		{http.MethodGet, "/v2/Unknown", nil, http.StatusNotFound, "", ""},
+		{http.MethodGet, "/v2/healthz", map[string]string{"Accept": "text/plain"}, http.StatusOK, `{"status":"ok"}`, ""},
+		{http.MethodGet, "/Users/foo", nil, http.StatusNotFound, "", ""},
+		{http.MethodPost, "/v2/Users", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType, "", ""},
+		{http.MethodGet, "/v2/Users/foo", map[string]string{"Accept": "text/html"}, http.StatusNotAcceptable, "", ""},
+		{http.MethodGet, "/v2/Users/foo", map[string]string{"Accept": "text/html, application/*;q=0.5"}, http.StatusOK, fmt.Sprintf("%d foo", shared.GetUserById), ""},
+	} {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader("{}"))
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+
+		assert.Equal(t, test.status, rw.Code, test.method+" "+test.target)
+		if len(test.body) > 0 {
+			assert.Equal(t, test.body, rw.Body.String())
+		}
+		assert.Equal(t, test.allow, rw.Header().Get("Allow"))
+	}
+}
+
+func TestWebRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/Users/foo?attributes=userName", nil)
+	req = req.WithContext(context.WithValue(req.Context(), pathParams{}, map[string]string{"resourceId": "foo"}))
+	req.Header.Set("If-None-Match", "W/\"1\"")
+
+	wr := NewWebRequest(req)
+	assert.Equal(t, "foo", wr.Param("resourceId"))
+	assert.Equal(t, "userName", wr.Param("attributes"))
+	assert.Equal(t, "", wr.Param("filter"))
+	assert.Equal(t, "W/\"1\"", wr.Header("If-None-Match"))
+
+	// a body beyond the limit is not read in full
+	body := &countingReader{Reader: strings.NewReader(strings.Repeat("x", 1<<20))}
+	wr = NewWebRequest(httptest.NewRequest(http.MethodPost, "/Users", body))
+	_, err := wr.(shared.BodyLimiter).LimitedBody(10)
+	assert.IsType(t, &shared.PayloadTooLargeError{}, err)
+	assert.True(t, body.n < 1<<20, body.n)
+	read, err := NewWebRequest(httptest.NewRequest(http.MethodPost, "/Users", strings.NewReader("{}"))).(shared.BodyLimiter).LimitedBody(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(read))
+}
+
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestRouter_Admin(t *testing.T) {
+	echo := WithWrapper(func(handler handlers.EndpointHandler, requestType int) handlers.EndpointHandler {
+		return func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+			ri := &handlers.ResponseInfo{}
+			return ri.Status(http.StatusOK).Body([]byte(fmt.Sprintf("%d", requestType)))
+		}
+	})
+
+	// off by default
+	rw := httptest.NewRecorder()
+	NewRouter(nil, echo).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/Admin/Counts", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	router := NewRouter(nil, echo, WithAdmin(func(req *http.Request) bool {
+		return req.Header.Get("X-Admin-Token") == "secret"
+	}))
+
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/Admin/Reindex", nil))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/Admin/Purge?olderThanDays=30", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, fmt.Sprintf("%d", shared.Maintenance), rw.Body.String())
+
+	// SCIM endpoints are not subject to the admin authorization
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/Users/foo", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+}
@@ -0,0 +1,397 @@
+package httpadapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns a handler serving SCIM 1.1 clients, such as older provisioning connectors, from the SCIM 2.0
+// pipeline of the server: requests are translated to 2.0, served by a router built with the options, i.e.
+// WithPrefix("/v1"), and the responses translated back. The translation covers
+//
+//   - the schemas: urn:scim:schemas:core:1.0 and urn:scim:schemas:extension:enterprise:1.0, also as the key of
+//     the enterprise extension, stand for the core User and Group schemas and the enterprise extension of 2.0;
+//   - PATCH: the 1.1 body, a partial resource whose attributes are replaced, whose multiValued attributes are
+//     added to except for elements marked "operation": "delete", which are removed by value, and whose
+//     meta.attributes are removed, becomes a 2.0 PatchOp;
+//   - errors, which take the 1.1 form {"Errors": [{"description": ..., "code": ...}]};
+//   - the /ServiceProviderConfigs endpoint, served by /ServiceProviderConfig;
+//   - locations: when the server has a BaseURL provider, URLs below the 2.0 base URL are moved below the path
+//     of the 1.1 endpoints on the same host.
+//
+// Users, Groups and the service provider configuration are served; other endpoints, among them Bulk and the
+// 1.1 schema endpoints, receive 501.
+func NewSCIM11Handler(server handlers.ScimServer, opts ...Option) http.Handler {
+	rt := NewRouter(server, opts...).(*router)
+	return &scim11Handler{server: server, next: rt, prefix: rt.prefix}
+}
+
+type scim11Handler struct {
+	server handlers.ScimServer
+	next   http.Handler
+	prefix string
+}
+
+func (h *scim11Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	if len(h.prefix) > 0 {
+		if path != h.prefix && !strings.HasPrefix(path, h.prefix+"/") {
+			writeError11(rw, http.StatusNotFound, "No endpoint at "+path)
+			return
+		}
+		path = strings.TrimPrefix(path, h.prefix)
+	}
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		writeError11(rw, http.StatusNotImplemented, "SCIM 1.1 is not supported at "+req.URL.Path)
+		return
+	}
+
+	var urn string
+	switch segments[0] {
+	case "Users":
+		urn = shared.UserUrn
+	case "Groups":
+		urn = shared.GroupUrn
+	case "ServiceProviderConfigs":
+		segments[0] = "ServiceProviderConfig"
+	default:
+		writeError11(rw, http.StatusNotImplemented, "SCIM 1.1 is not supported at "+req.URL.Path)
+		return
+	}
+
+	// the body is read before the pipeline of the router, which limits it, so it is limited here alike
+	var body []byte
+	var err error
+	if maxBytes := h.server.Property().GetInt("scim.protocol.maxRequestBytes"); maxBytes > 0 {
+		body, err = readLimited(rw, req.Body, maxBytes)
+	} else {
+		body, err = ioutil.ReadAll(req.Body)
+	}
+	if _, ok := err.(*shared.PayloadTooLargeError); ok {
+		writeError11(rw, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		writeError11(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body) > 0 && len(urn) > 0 {
+		if body, err = h.translateRequest(req.Method, body, urn); err != nil {
+			writeError11(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	forwarded := req.Clone(req.Context())
+	forwarded.URL.Path = h.prefix + "/" + strings.Join(segments, "/")
+	forwarded.URL.RawPath = ""
+	forwarded.RequestURI = forwarded.URL.RequestURI()
+	forwarded.Body = ioutil.NopCloser(bytes.NewReader(body))
+	forwarded.ContentLength = int64(len(body))
+	forwarded.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// the response body is translated, it must not be compressed
+	forwarded.Header.Del("Accept-Encoding")
+	if len(body) > 0 {
+		forwarded.Header.Set("Content-Type", shared.ScimMediaType)
+	}
+
+	rec := &responseBuffer{header: make(http.Header), status: http.StatusOK}
+	h.next.ServeHTTP(rec, forwarded)
+
+	from, to := h.baseURLs(req)
+	for k, v := range rec.header {
+		rw.Header()[k] = v
+	}
+	if location := rec.header.Get("Location"); len(location) > 0 {
+		rw.Header().Set("Location", relocate11(location, from, to))
+	}
+	rw.Header().Del("Content-Length")
+	out := rec.body.Bytes()
+	if len(out) > 0 {
+		rw.Header().Set("Content-Type", shared.JsonMediaType)
+		out = translateResponse(out, from, to)
+	}
+	rw.WriteHeader(rec.status)
+	rw.Write(out)
+}
+
+// the 2.0 base URL of the request and the base URL of the 1.1 endpoints on the same host, empty when the
+// server has no BaseURL provider
+func (h *scim11Handler) baseURLs(req *http.Request) (string, string) {
+	provider := h.server.BaseURL()
+	if provider == nil {
+		return "", ""
+	}
+	from := strings.TrimSuffix(provider.BaseURL(NewWebRequest(req)), "/")
+	u, err := url.Parse(from)
+	if err != nil || len(from) == 0 {
+		return "", ""
+	}
+	u.Path, u.RawPath = h.prefix, ""
+	return from, u.String()
+}
+
+func (h *scim11Handler) translateRequest(method string, body []byte, urn string) ([]byte, error) {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(body, &data); err != nil {
+		// left to the 2.0 pipeline to report
+		return body, nil
+	}
+	switch method {
+	case http.MethodPost, http.MethodPut:
+		return json.Marshal(resourceFrom11(data, urn))
+	case http.MethodPatch:
+		patch, err := patchFrom11(data, h.server.InternalSchema(urn))
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(patch)
+	default:
+		return body, nil
+	}
+}
+
+// the 2.0 form of a 1.1 resource of the schema
+func resourceFrom11(data map[string]interface{}, urn string) map[string]interface{} {
+	if ext, ok := data[shared.Enterprise11Urn]; ok {
+		data[shared.EnterpriseUrn] = ext
+		delete(data, shared.Enterprise11Urn)
+	}
+	schemas := []interface{}{urn}
+	if _, ok := data[shared.EnterpriseUrn]; ok {
+		schemas = append(schemas, shared.EnterpriseUrn)
+	}
+	data["schemas"] = schemas
+	return data
+}
+
+// the 2.0 PatchOp of a 1.1 PATCH body: the removals of meta.attributes first, then the attributes in the
+// order of their names
+func patchFrom11(data map[string]interface{}, sch *shared.Schema) (map[string]interface{}, error) {
+	ops := make([]interface{}, 0)
+	if meta, ok := data["meta"].(map[string]interface{}); ok {
+		removed, _ := meta["attributes"].([]interface{})
+		for _, each := range removed {
+			path, ok := each.(string)
+			if !ok {
+				return nil, shared.Error.InvalidParam("meta.attributes", "attribute paths", fmt.Sprintf("%v", each))
+			}
+			if strings.HasPrefix(path, shared.Enterprise11Urn) {
+				path = shared.EnterpriseUrn + strings.TrimPrefix(path, shared.Enterprise11Urn)
+			}
+			ops = append(ops, map[string]interface{}{"op": "remove", "path": path})
+		}
+	}
+
+	attributes := make(map[string]interface{})
+	for key, value := range data {
+		switch key {
+		case "schemas", "meta", "id":
+		case shared.Enterprise11Urn, shared.EnterpriseUrn:
+			ext, _ := value.(map[string]interface{})
+			for sub, v := range ext {
+				attributes[shared.EnterpriseUrn+":"+sub] = v
+			}
+		default:
+			attributes[key] = value
+		}
+	}
+	paths := make([]string, 0, len(attributes))
+	for path := range attributes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		attributeOps, err := patchOps11(path, attributes[path], sch)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, attributeOps...)
+	}
+	return map[string]interface{}{"schemas": []interface{}{shared.PatchOpUrn}, "Operations": ops}, nil
+}
+
+func patchOps11(path string, value interface{}, sch *shared.Schema) ([]interface{}, error) {
+	_, attr, err := shared.CompilePath(path, sch)
+	if err != nil {
+		// left to the 2.0 pipeline to report
+		return []interface{}{map[string]interface{}{"op": "replace", "path": path, "value": value}}, nil
+	}
+
+	elements, isList := value.([]interface{})
+	if attr.MultiValued && isList {
+		ops := make([]interface{}, 0)
+		added := make([]interface{}, 0, len(elements))
+		for _, elem := range elements {
+			e, ok := elem.(map[string]interface{})
+			if !ok {
+				added = append(added, elem)
+				continue
+			}
+			operation, _ := e["operation"].(string)
+			delete(e, "operation")
+			if operation != "delete" {
+				added = append(added, e)
+				continue
+			}
+			v, ok := e["value"]
+			if !ok {
+				return nil, shared.Error.InvalidParam(path, "value of the element to delete", "none")
+			}
+			literal, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, map[string]interface{}{"op": "remove", "path": fmt.Sprintf("%s[value eq %s]", path, literal)})
+		}
+		if len(added) > 0 {
+			ops = append(ops, map[string]interface{}{"op": "add", "path": path, "value": added})
+		}
+		return ops, nil
+	}
+
+	// complex attributes are merged, sub attributes not given keep their values
+	if sub, ok := value.(map[string]interface{}); ok && attr.ExpectsComplex() && !attr.MultiValued {
+		names := make([]string, 0, len(sub))
+		for name := range sub {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		ops := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			ops = append(ops, map[string]interface{}{"op": "replace", "path": path + "." + name, "value": sub[name]})
+		}
+		return ops, nil
+	}
+	return []interface{}{map[string]interface{}{"op": "replace", "path": path, "value": value}}, nil
+}
+
+// the 1.1 form of a 2.0 response body; bodies that are not JSON objects are returned as they are
+func translateResponse(body []byte, from, to string) []byte {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+
+	var out interface{}
+	if isError(data) {
+		status, _ := data["status"].(string)
+		if len(status) == 0 {
+			status, _ = data["Status"].(string)
+		}
+		detail, _ := data["detail"].(string)
+		out = map[string]interface{}{
+			"Errors": []interface{}{map[string]interface{}{"description": detail, "code": status}},
+		}
+	} else {
+		out = relocateAll11(resourceTo11(data), from, to)
+	}
+	translated, err := json.Marshal(out)
+	if err != nil {
+		return body
+	}
+	return translated
+}
+
+func isError(data map[string]interface{}) bool {
+	schemas, _ := data["schemas"].([]interface{})
+	for _, urn := range schemas {
+		if urn == shared.ErrorUrn {
+			return true
+		}
+	}
+	return false
+}
+
+// the 1.1 form of a 2.0 resource or list response
+func resourceTo11(data map[string]interface{}) map[string]interface{} {
+	if ext, ok := data[shared.EnterpriseUrn]; ok {
+		data[shared.Enterprise11Urn] = ext
+		delete(data, shared.EnterpriseUrn)
+	}
+	if schemas, ok := data["schemas"].([]interface{}); ok {
+		translated := make([]interface{}, 0, len(schemas))
+		seen := make(map[interface{}]bool)
+		for _, urn := range schemas {
+			switch urn {
+			case shared.EnterpriseUrn:
+				urn = shared.Enterprise11Urn
+			default:
+				urn = shared.Core11Urn
+			}
+			if !seen[urn] {
+				seen[urn] = true
+				translated = append(translated, urn)
+			}
+		}
+		data["schemas"] = translated
+	}
+	if meta, ok := data["meta"].(map[string]interface{}); ok {
+		delete(meta, "resourceType")
+	}
+	if resources, ok := data["Resources"].([]interface{}); ok {
+		for _, each := range resources {
+			if resource, ok := each.(map[string]interface{}); ok {
+				resourceTo11(resource)
+			}
+		}
+	}
+	return data
+}
+
+// move all URLs below the 2.0 base URL below the 1.1 one
+func relocateAll11(v interface{}, from, to string) interface{} {
+	if len(from) == 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case string:
+		return relocate11(v, from, to)
+	case []interface{}:
+		for i := range v {
+			v[i] = relocateAll11(v[i], from, to)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = relocateAll11(v[k], from, to)
+		}
+	}
+	return v
+}
+
+func relocate11(s, from, to string) string {
+	if len(from) > 0 && strings.HasPrefix(s, from+"/") {
+		return to + strings.TrimPrefix(s, from)
+	}
+	return s
+}
+
+func writeError11(rw http.ResponseWriter, status int, detail string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"Errors": []interface{}{map[string]interface{}{"description": detail, "code": strconv.Itoa(status)}},
+	})
+	rw.Header().Set("Content-Type", shared.JsonMediaType)
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+// buffers the response of the 2.0 router for translation
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header         { return b.header }
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *responseBuffer) WriteHeader(status int)      { b.status = status }
@@ -0,0 +1,72 @@
+package httpadapter
+
+import (
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPatchFrom11(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+
+	data := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal([]byte(`{
+		"schemas": ["urn:scim:schemas:core:1.0"],
+		"displayName": "Admins",
+		"members": [
+			{"value": "2819c223", "operation": "delete"},
+			{"value": "902c246b", "display": "Babs"}
+		],
+		"meta": {"attributes": ["externalId"]}
+	}`), &data))
+	patch, err := patchFrom11(data, sch)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"schemas": []interface{}{shared.PatchOpUrn},
+		"Operations": []interface{}{
+			map[string]interface{}{"op": "remove", "path": "externalId"},
+			map[string]interface{}{"op": "replace", "path": "displayName", "value": "Admins"},
+			map[string]interface{}{"op": "remove", "path": `members[value eq "2819c223"]`},
+			map[string]interface{}{"op": "add", "path": "members", "value": []interface{}{
+				map[string]interface{}{"value": "902c246b", "display": "Babs"},
+			}},
+		},
+	}, patch)
+
+	_, err = patchFrom11(map[string]interface{}{
+		"members": []interface{}{map[string]interface{}{"operation": "delete"}},
+	}, sch)
+	assert.NotNil(t, err)
+}
+
+func TestTranslateResponse(t *testing.T) {
+	for _, test := range []struct {
+		body   string
+		expect string
+	}{
+		{
+			`{"schemas":["` + shared.UserUrn + `","` + shared.EnterpriseUrn + `"],"id":"1",` +
+				`"meta":{"resourceType":"User","location":"https://example.com/v2/Users/1"},` +
+				`"` + shared.EnterpriseUrn + `":{"employeeNumber":"7"}}`,
+			`{"schemas":["` + shared.Core11Urn + `","` + shared.Enterprise11Urn + `"],"id":"1",` +
+				`"meta":{"location":"https://example.com/v1/Users/1"},` +
+				`"` + shared.Enterprise11Urn + `":{"employeeNumber":"7"}}`,
+		},
+		{
+			`{"schemas":["` + shared.ListResponseUrn + `"],"totalResults":1,` +
+				`"Resources":[{"schemas":["` + shared.GroupUrn + `"],"id":"g","meta":{"resourceType":"Group"}}]}`,
+			`{"schemas":["` + shared.Core11Urn + `"],"totalResults":1,` +
+				`"Resources":[{"schemas":["` + shared.Core11Urn + `"],"id":"g","meta":{}}]}`,
+		},
+		{
+			`{"schemas": ["` + shared.ErrorUrn + `"], "Status": "409", "scimType":"uniqueness", "detail":"taken"}`,
+			`{"Errors":[{"code":"409","description":"taken"}]}`,
+		},
+	} {
+		assert.JSONEq(t, test.expect, string(translateResponse([]byte(test.body), "https://example.com/v2", "https://example.com/v1")))
+	}
+	assert.Equal(t, "not json", string(translateResponse([]byte("not json"), "", "")))
+}
@@ -0,0 +1,114 @@
+//go:build ldap
+// +build ldap
+
+package ldap
+
+import (
+	"context"
+	. "github.com/davidiamyou/go-scim/shared"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// Dial the directory at the url, i.e. 'ldaps://ldap.example.com', and bind with the credentials. The
+// connection is shared by all requests; it is not re-established when the directory closes it.
+func Dial(url, bindDN, password string) (Directory, error) {
+	conn, err := goldap.DialURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(bindDN, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &directory{conn: conn}, nil
+}
+
+// Adapt an established connection
+func NewDirectory(conn *goldap.Conn) Directory {
+	return &directory{conn: conn}
+}
+
+type directory struct {
+	conn *goldap.Conn
+}
+
+func (d *directory) Search(baseDN, filter string, ctx context.Context) ([]*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result, err := d.conn.Search(goldap.NewSearchRequest(
+		baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{"*", "+"}, nil))
+	if err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+			return []*Entry{}, nil
+		}
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		entry := &Entry{DN: e.DN, Attributes: make(map[string][]string, len(e.Attributes))}
+		for _, attr := range e.Attributes {
+			entry.Attributes[attr.Name] = attr.Values
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (d *directory) Add(entry *Entry, ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	req := goldap.NewAddRequest(entry.DN, nil)
+	for name, values := range entry.Attributes {
+		if len(values) > 0 {
+			req.Attribute(name, values)
+		}
+	}
+	if err := d.conn.Add(req); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultEntryAlreadyExists) {
+			return Error.Duplicate("dn", entry.DN)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *directory) Modify(dn string, attributes map[string][]string, ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	req := goldap.NewModifyRequest(dn, nil)
+	for name, values := range attributes {
+		// replacing with no values removes the attribute, and is a no-op when it is absent already
+		req.Replace(name, values)
+	}
+	return d.notFound(dn, d.conn.Modify(req))
+}
+
+func (d *directory) Delete(dn string, ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.notFound(dn, d.conn.Del(goldap.NewDelRequest(dn, nil)))
+}
+
+// read the root DSE
+func (d *directory) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := d.conn.Search(goldap.NewSearchRequest(
+		"", goldap.ScopeBaseObject, goldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"1.1"}, nil))
+	return err
+}
+
+func (d *directory) notFound(dn string, err error) error {
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+		return Error.ResourceNotFound(dn, "")
+	}
+	return err
+}
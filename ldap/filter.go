@@ -0,0 +1,213 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	. "github.com/davidiamyou/go-scim/shared"
+	"sort"
+	"strings"
+)
+
+// Translate the SCIM filter into an LDAP search filter (RFC 4515) over the entries of the resource type.
+// Only mapped attributes, id, members.value and groups.value can be filtered on; the latter two compare
+// the DN of the referenced entry. Whether comparisons ignore case is up to the matching rules of the
+// LDAP attributes, not the caseExact of the SCIM ones.
+func (r *repository) searchFilter(query string, ctx context.Context) (string, error) {
+	if len(strings.TrimSpace(query)) == 0 {
+		return r.typeFilter(), nil
+	}
+	root, err := r.filters.Compile(query, r.schema)
+	if err != nil {
+		return "", err
+	}
+	translated, err := r.translate(root, query, ctx)
+	if err != nil {
+		return "", err
+	}
+	return and(r.typeFilter(), translated), nil
+}
+
+func (r *repository) translate(node FilterNode, query string, ctx context.Context) (string, error) {
+	switch node.Data() {
+	case And, Or:
+		left, err := r.translate(node.Left(), query, ctx)
+		if err != nil {
+			return "", err
+		}
+		right, err := r.translate(node.Right(), query, ctx)
+		if err != nil {
+			return "", err
+		}
+		if node.Data() == And {
+			return and(left, right), nil
+		}
+		return "(|" + left + right + ")", nil
+	case Not:
+		operand, err := r.translate(node.Left(), query, ctx)
+		if err != nil {
+			return "", err
+		}
+		return not(operand), nil
+	}
+
+	if node.Type() != RelationalOperator || node.Left() == nil || node.Left().Type() != PathOperand {
+		return "", Error.InvalidFilter(query, fmt.Sprintf("unexpected %v", node.Data()))
+	}
+	path := node.Left().Data().(Path)
+	if path.FilterRoot() != nil {
+		return "", Error.InvalidFilter(query, "value filters are not supported by the directory")
+	}
+	attr := r.schema.GetAttribute(path, true)
+	if attr == nil {
+		return "", Error.InvalidFilter(query, "no attribute found for path "+path.CollectValue())
+	}
+
+	value := ""
+	if node.Data() != Pr {
+		if node.Right() == nil || node.Right().Type() != ConstantOperand {
+			return "", Error.InvalidFilter(query, fmt.Sprintf("%v expects a value", node.Data()))
+		}
+		value = formatValue(node.Right().Data())
+	}
+
+	if ldapName, ok := r.references[attr]; ok {
+		return r.translateReference(node.Data(), ldapName, value, query, ctx)
+	}
+
+	ldapNames := make([]string, 0, 1)
+	switch {
+	case attr.Assist.Path == "id":
+		ldapNames = append(ldapNames, r.mapping.IdAttribute)
+	case r.attributes[attr] != nil:
+		ldapNames = append(ldapNames, r.attributes[attr].ldapName)
+	case attr.ExpectsComplex() && node.Data() == Pr:
+		// present when any of the mapped sub attributes is
+		for _, mapped := range r.attributes {
+			if strings.HasPrefix(mapped.attr.Assist.FullPath, attr.Assist.FullPath+".") {
+				ldapNames = append(ldapNames, mapped.ldapName)
+			}
+		}
+	}
+	if len(ldapNames) == 0 {
+		return "", Error.InvalidFilter(query, path.CollectValue()+" is not mapped to the directory")
+	}
+
+	if len(ldapNames) > 1 {
+		sort.Strings(ldapNames)
+		sb := strings.Builder{}
+		sb.WriteString("(|")
+		for _, ldapName := range ldapNames {
+			sb.WriteString("(" + ldapName + "=*)")
+		}
+		sb.WriteString(")")
+		return sb.String(), nil
+	}
+	return relation(node.Data(), ldapNames[0], value, query)
+}
+
+// members.value and groups.value compare the DN of the referenced entry
+func (r *repository) translateReference(op interface{}, ldapName, id, query string, ctx context.Context) (string, error) {
+	switch op {
+	case Pr:
+		return "(" + ldapName + "=*)", nil
+	case Eq, Ne:
+		dn, err := r.dnOf(id, ctx)
+		if err != nil {
+			return "", err
+		}
+		matches := equals(ldapName, dn)
+		if len(dn) == 0 {
+			matches = nothing
+		}
+		if op == Ne {
+			return not(matches), nil
+		}
+		return matches, nil
+	default:
+		return "", Error.InvalidFilter(query, fmt.Sprintf("%v is not supported on references", op))
+	}
+}
+
+func relation(op interface{}, ldapName, value, query string) (string, error) {
+	escaped := escapeFilter(value)
+	switch op {
+	case Eq:
+		return equals(ldapName, value), nil
+	case Ne:
+		return not(equals(ldapName, value)), nil
+	case Co:
+		return "(" + ldapName + "=*" + escaped + "*)", nil
+	case Sw:
+		return "(" + ldapName + "=" + escaped + "*)", nil
+	case Ew:
+		return "(" + ldapName + "=*" + escaped + ")", nil
+	case Pr:
+		return "(" + ldapName + "=*)", nil
+	case Ge:
+		return "(" + ldapName + ">=" + escaped + ")", nil
+	case Le:
+		return "(" + ldapName + "<=" + escaped + ")", nil
+	case Gt:
+		// LDAP has no strict ordering
+		return and("("+ldapName+">="+escaped+")", not(equals(ldapName, value))), nil
+	case Lt:
+		return and("("+ldapName+"<="+escaped+")", not(equals(ldapName, value))), nil
+	default:
+		return "", Error.InvalidFilter(query, fmt.Sprintf("unsupported operator %v", op))
+	}
+}
+
+// matches no entry
+const nothing = "(!(objectClass=*))"
+
+func equals(ldapName, value string) string {
+	return "(" + ldapName + "=" + escapeFilter(value) + ")"
+}
+
+func and(left, right string) string {
+	return "(&" + left + right + ")"
+}
+
+func not(operand string) string {
+	return "(!" + operand + ")"
+}
+
+// escape the value for use in a search filter, RFC 4515 section 3
+func escapeFilter(value string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			sb.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// escape the value for use as an attribute value of a DN, RFC 4514 section 2.4
+func escapeDN(value string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == 0:
+			sb.WriteString("\\00")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// whether the DN is the base DN or below it
+func underDN(dn, base string) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	return dn == base || strings.HasSuffix(dn, ","+base)
+}
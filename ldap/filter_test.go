@@ -0,0 +1,64 @@
+package ldap
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSearchFilter(t *testing.T) {
+	dir := newFakeDirectory()
+	users, groups := newTestRepositories(t, dir)
+	ctx := context.Background()
+	assert.Nil(t, users.Create(testUser("6", "alice"), ctx))
+
+	for _, test := range []struct {
+		repo   *repository
+		filter string
+		expect string
+		err    bool
+	}{
+		{repo: users, filter: "", expect: "(objectClass=inetOrgPerson)"},
+		{repo: users, filter: `userName eq "alice"`, expect: "(&(objectClass=inetOrgPerson)(uid=alice))"},
+		{repo: users, filter: `USERNAME ne "alice"`, expect: "(&(objectClass=inetOrgPerson)(!(uid=alice)))"},
+		{repo: users, filter: `id pr`, expect: "(&(objectClass=inetOrgPerson)(employeeNumber=*))"},
+		{
+			repo:   users,
+			filter: `userName sw "a*" and (name.familyName co "b" or emails.value ew "@example.com")`,
+			expect: `(&(objectClass=inetOrgPerson)(&(uid=a\2a*)(|(sn=*b*)(mail=*@example.com))))`,
+		},
+		{repo: users, filter: `not (userName eq "x\y")`, expect: `(&(objectClass=inetOrgPerson)(!(uid=x\5cy)))`},
+		{repo: users, filter: `name.familyName gt "m"`, expect: "(&(objectClass=inetOrgPerson)(&(sn>=m)(!(sn=m))))"},
+		{repo: users, filter: `name.familyName le "m"`, expect: "(&(objectClass=inetOrgPerson)(sn<=m))"},
+		{repo: users, filter: `active eq true`, expect: "(&(objectClass=inetOrgPerson)(pwdActive=TRUE))"},
+		{repo: users, filter: `name pr`, expect: "(&(objectClass=inetOrgPerson)(|(givenName=*)(sn=*)))"},
+		{repo: users, filter: `title eq "boss"`, err: true},
+		{repo: users, filter: `emails[type eq "work"]`, err: true},
+		{repo: groups, filter: `members.value eq "6"`, expect: "(&(objectClass=groupOfNames)(member=uid=alice,ou=people,dc=example,dc=com))"},
+		{repo: groups, filter: `members.value eq "unknown"`, expect: "(&(objectClass=groupOfNames)(!(objectClass=*)))"},
+		{repo: groups, filter: `members.value sw "6"`, err: true},
+	} {
+		filter, err := test.repo.searchFilter(test.filter, ctx)
+		if test.err {
+			assert.NotNil(t, err, test.filter)
+		} else {
+			assert.Nil(t, err, test.filter)
+			assert.Equal(t, test.expect, filter, test.filter)
+		}
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	for _, test := range []struct {
+		value  string
+		expect string
+	}{
+		{"alice", "alice"},
+		{"Smith, John", `Smith\, John`},
+		{" #lead", `\ #lead`},
+		{"#trail ", `\#trail\ `},
+		{`a+b="c"`, `a\+b\=\"c\"`},
+	} {
+		assert.Equal(t, test.expect, escapeDN(test.value))
+	}
+}
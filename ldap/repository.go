@@ -0,0 +1,613 @@
+// Package ldap fronts an existing LDAP directory with the SCIM server: users and groups are read from and
+// written to directory entries according to a configurable mapping, SCIM filters are translated into LDAP
+// search filters and group members are stored as the DNs of their entries.
+//
+// The repository talks to the directory through the Directory interface. An implementation on top of
+// github.com/go-ldap/ldap/v3 is only built with the ldap build tag:
+//
+//	go build -tags ldap ./...
+package ldap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	. "github.com/davidiamyou/go-scim/shared"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An entry of the directory; attribute names are matched case insensitively, as LDAP does
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// the values of the attribute
+func (e *Entry) Values(name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// The operations the repository needs from a directory connection
+type Directory interface {
+	// entries below and including baseDN that match the filter, with all user and requested operational attributes
+	Search(baseDN, filter string, ctx context.Context) ([]*Entry, error)
+	Add(entry *Entry, ctx context.Context) error
+	// replace the values of the attributes, attributes without values are removed from the entry
+	Modify(dn string, attributes map[string][]string, ctx context.Context) error
+	Delete(dn string, ctx context.Context) error
+	Ping(ctx context.Context) error
+}
+
+// How the resources of a type map to directory entries, i.e. for users:
+//
+//	Mapping{
+//		BaseDN:            "ou=people,dc=example,dc=com",
+//		DNTemplate:        "uid={userName},ou=people,dc=example,dc=com",
+//		ObjectClasses:     []string{"inetOrgPerson"},
+//		IdAttribute:       "employeeNumber",
+//		MetaAttribute:     "description",
+//		Attributes:        map[string]string{"userName": "uid", "name.familyName": "sn", "emails.value": "mail"},
+//		MemberOfAttribute: "memberOf",
+//	}
+//
+// Attributes in the DN template must not change once the entry is created, as entries are not renamed.
+type Mapping struct {
+	BaseDN            string            // entries of the resource type are searched below it
+	DNTemplate        string            // DN of new entries, {path} placeholders are replaced by attribute values
+	ObjectClasses     []string          // object classes of new entries, the first one tells the entries of the type apart
+	IdAttribute       string            // holds the SCIM id
+	MetaAttribute     string            // holds the SCIM meta attribute as JSON
+	Attributes        map[string]string // SCIM attribute path to LDAP attribute; 'emails.value' maps the values of all emails
+	MemberAttribute   string            // groups: holds the DNs of the members, i.e. 'member'
+	MemberOfAttribute string            // users: holds the DNs of the groups of the user, i.e. 'memberOf'
+}
+
+// Create the user and group repositories over the directory. The repositories resolve the DNs of members
+// and groups through each other.
+func NewRepositories(dir Directory, users, groups Mapping, userSchema, groupSchema *Schema) (Repository, Repository, error) {
+	userRepo, err := newRepository(dir, users, userSchema, UserResourceType)
+	if err != nil {
+		return nil, nil, err
+	}
+	groupRepo, err := newRepository(dir, groups, groupSchema, GroupResourceType)
+	if err != nil {
+		return nil, nil, err
+	}
+	userRepo.peers = []*repository{userRepo, groupRepo}
+	groupRepo.peers = userRepo.peers
+	return userRepo, groupRepo, nil
+}
+
+func newRepository(dir Directory, mapping Mapping, sch *Schema, resourceType string) (*repository, error) {
+	switch {
+	case len(mapping.BaseDN) == 0, len(mapping.DNTemplate) == 0:
+		return nil, Error.InvalidParam("ldap mapping of "+resourceType, "base and template DN", "none")
+	case len(mapping.ObjectClasses) == 0:
+		return nil, Error.InvalidParam("ldap mapping of "+resourceType, "object classes", "none")
+	case len(mapping.IdAttribute) == 0, len(mapping.MetaAttribute) == 0:
+		return nil, Error.InvalidParam("ldap mapping of "+resourceType, "id and meta attributes", "none")
+	}
+
+	r := &repository{
+		dir:          dir,
+		mapping:      mapping,
+		schema:       sch,
+		resourceType: resourceType,
+		attributes:   make(map[*Attribute]*mappedAttribute),
+		positional:   make(map[*Attribute][]*mappedAttribute),
+	}
+	for path, ldapName := range mapping.Attributes {
+		ap, err := ParseAttributePath(path)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := ap.Resolve(sch)
+		if err != nil {
+			return nil, err
+		}
+		if ap.Filter != nil || attr.ExpectsComplex() {
+			return nil, Error.InvalidParam("ldap mapping of "+resourceType, "path to a simple attribute", path)
+		}
+
+		mapped := &mappedAttribute{path: ap, attr: attr, ldapName: ldapName, multiValued: attr.MultiValued}
+		if len(ap.SubAttribute) > 0 {
+			parent := *ap
+			parent.SubAttribute = ""
+			parentAttr, err := parent.Resolve(sch)
+			if err != nil {
+				return nil, err
+			}
+			mapped.multiValued = parentAttr.MultiValued
+			if mapped.multiValued {
+				r.positional[parentAttr] = append(r.positional[parentAttr], mapped)
+			}
+		}
+		r.attributes[attr] = mapped
+	}
+	for parentAttr, group := range r.positional {
+		if len(group) < 2 {
+			delete(r.positional, parentAttr)
+		}
+	}
+
+	r.references = make(map[*Attribute]string)
+	if len(mapping.MemberAttribute) > 0 {
+		if _, attr, err := CompilePath("members.value", sch); err == nil {
+			r.references[attr] = mapping.MemberAttribute
+		}
+	}
+	if len(mapping.MemberOfAttribute) > 0 {
+		if _, attr, err := CompilePath("groups.value", sch); err == nil {
+			r.references[attr] = mapping.MemberOfAttribute
+		}
+	}
+	return r, nil
+}
+
+type repository struct {
+	dir          Directory
+	mapping      Mapping
+	schema       *Schema
+	resourceType string
+	attributes   map[*Attribute]*mappedAttribute // keyed by the schema attribute the path resolves to
+	references   map[*Attribute]string           // members.value and groups.value to the attribute holding the DNs
+	peers        []*repository
+	filters      *FilterCache
+	// sub attributes of the elements of a multiValued attribute mapped along with others of the same elements,
+	// whose n-th values make up the n-th element, by the multiValued attribute
+	positional map[*Attribute][]*mappedAttribute
+}
+
+func (r *repository) UseFilterCache(cache *FilterCache) {
+	r.filters = cache
+}
+
+type mappedAttribute struct {
+	path        *AttributePath
+	attr        *Attribute
+	ldapName    string
+	multiValued bool // the attribute, or the attribute it is a sub attribute of, is multiValued
+}
+
+func (r *repository) Create(provider DataProvider, ctx context.Context) error {
+	entry, err := r.toEntry(provider.GetData(), ctx)
+	if err != nil {
+		return err
+	}
+	entry.DN, err = r.newDN(provider.GetData())
+	if err != nil {
+		return err
+	}
+	entry.Attributes["objectClass"] = r.mapping.ObjectClasses
+	return r.dir.Add(entry, ctx)
+}
+
+func (r *repository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	entry, err := r.find(id, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.fromEntry(entry, ctx)
+}
+
+func (r *repository) GetAll(ctx context.Context) ([]Complex, error) {
+	entries, err := r.dir.Search(r.mapping.BaseDN, r.typeFilter(), ctx)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]Complex, 0, len(entries))
+	for _, entry := range entries {
+		dp, err := r.fromEntry(entry, ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, dp.GetData())
+	}
+	return all, nil
+}
+
+func (r *repository) Count(query string, ctx context.Context) (int, error) {
+	filter, err := r.searchFilter(query, ctx)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := r.dir.Search(r.mapping.BaseDN, filter, ctx)
+	return len(entries), err
+}
+
+// The version is compared with the stored meta.version before the entry is modified; the directory offers
+// no atomic compare and set, so concurrent writes may still interleave.
+func (r *repository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	existing, err := r.find(id, version, ctx)
+	if err != nil {
+		return err
+	}
+	entry, err := r.toEntry(provider.GetData(), ctx)
+	if err != nil {
+		return err
+	}
+	// attributes that are no longer assigned are removed
+	for _, mapped := range r.attributes {
+		if _, ok := entry.Attributes[mapped.ldapName]; !ok {
+			entry.Attributes[mapped.ldapName] = []string{}
+		}
+	}
+	if len(r.mapping.MemberAttribute) > 0 {
+		if _, ok := entry.Attributes[r.mapping.MemberAttribute]; !ok {
+			entry.Attributes[r.mapping.MemberAttribute] = []string{}
+		}
+	}
+	return r.dir.Modify(existing.DN, entry.Attributes, ctx)
+}
+
+func (r *repository) Delete(id, version string, ctx context.Context) error {
+	existing, err := r.find(id, version, ctx)
+	if err != nil {
+		return err
+	}
+	return r.dir.Delete(existing.DN, ctx)
+}
+
+// Entries matching the translated filter are sorted and paged in memory, like the map repository does
+func (r *repository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	filter, err := r.searchFilter(payload.Filter, ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.dir.Search(r.mapping.BaseDN, filter, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[string]DataProvider, len(entries))
+	for _, entry := range entries {
+		dp, err := r.fromEntry(entry, ctx)
+		if err != nil {
+			return nil, err
+		}
+		matches[dp.GetId()] = dp
+	}
+	payload.Filter = ""
+	return NewSearchableMapRepository(r.schema, matches).Search(payload, ctx)
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	return r.dir.Ping(ctx)
+}
+
+// the entry of the resource, or a ResourceNotFoundError when there is none at the version
+func (r *repository) find(id, version string, ctx context.Context) (*Entry, error) {
+	entries, err := r.dir.Search(r.mapping.BaseDN, and(r.typeFilter(), equals(r.mapping.IdAttribute, id)), ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, Error.ResourceNotFound(id, version)
+	}
+	if len(version) > 0 {
+		meta, _ := r.meta(entries[0])
+		if stored, _ := meta["version"].(string); stored != version {
+			return nil, Error.ResourceNotFound(id, version)
+		}
+	}
+	return entries[0], nil
+}
+
+func (r *repository) typeFilter() string {
+	return equals("objectClass", r.mapping.ObjectClasses[0])
+}
+
+func (r *repository) meta(entry *Entry) (map[string]interface{}, error) {
+	values := entry.Values(r.mapping.MetaAttribute)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	meta := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(values[0]), &meta); err != nil {
+		return nil, Error.Text("invalid meta stored in %s of %s: %s", r.mapping.MetaAttribute, entry.DN, err.Error())
+	}
+	return meta, nil
+}
+
+// the DN of a new entry, from the template
+func (r *repository) newDN(data Complex) (string, error) {
+	dn := r.mapping.DNTemplate
+	for {
+		start := strings.Index(dn, "{")
+		if start < 0 {
+			return dn, nil
+		}
+		end := strings.Index(dn[start:], "}")
+		if end < 0 {
+			return "", Error.InvalidParam("ldap DN template", "closed placeholders", r.mapping.DNTemplate)
+		}
+		path := dn[start+1 : start+end]
+
+		value := ""
+		if _, attr, err := CompilePath(path, r.schema); err != nil {
+			return "", err
+		} else if attr.Assist.Path == "id" {
+			value, _ = data["id"].(string)
+		} else if mapped, ok := r.attributes[attr]; ok {
+			if values := mapped.values(data); len(values) > 0 {
+				value = values[0]
+			}
+		}
+		if len(value) == 0 {
+			return "", Error.InvalidParam("ldap DN template", "value for "+path, "none")
+		}
+		dn = dn[:start] + escapeDN(value) + dn[start+end+1:]
+	}
+}
+
+func (r *repository) toEntry(data Complex, ctx context.Context) (*Entry, error) {
+	entry := &Entry{Attributes: make(map[string][]string)}
+	id, _ := data["id"].(string)
+	entry.Attributes[r.mapping.IdAttribute] = []string{id}
+
+	if meta, ok := data["meta"]; ok {
+		raw, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		entry.Attributes[r.mapping.MetaAttribute] = []string{string(raw)}
+	}
+
+	// elements lacking a sub attribute would shift the values of the others onto the wrong elements
+	for parentAttr, group := range r.positional {
+		for _, mapped := range group {
+			if len(mapped.values(data)) != len(mapped.elements(data)) {
+				return nil, Error.InvalidParam(parentAttr.Assist.Path, "elements that all have "+positionalNames(group),
+					"an element without "+mapped.path.SubAttribute)
+			}
+		}
+	}
+	for _, mapped := range r.attributes {
+		if values := mapped.values(data); len(values) > 0 {
+			entry.Attributes[mapped.ldapName] = append(entry.Attributes[mapped.ldapName], values...)
+		}
+	}
+
+	if len(r.mapping.MemberAttribute) > 0 {
+		members, _ := data["members"].([]interface{})
+		dns := make([]string, 0, len(members))
+		for _, member := range members {
+			m, _ := member.(map[string]interface{})
+			value, _ := m["value"].(string)
+			dn, err := r.dnOf(value, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(dn) == 0 {
+				return nil, Error.InvalidParam("member", "id of an existing resource", value)
+			}
+			dns = append(dns, dn)
+		}
+		if len(dns) > 0 {
+			entry.Attributes[r.mapping.MemberAttribute] = dns
+		}
+	}
+	return entry, nil
+}
+
+func (r *repository) fromEntry(entry *Entry, ctx context.Context) (DataProvider, error) {
+	data := Complex{"schemas": []interface{}{r.schema.Id}}
+	if ids := entry.Values(r.mapping.IdAttribute); len(ids) > 0 {
+		data["id"] = ids[0]
+	}
+	meta, err := r.meta(entry)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		data["meta"] = meta
+	}
+
+	// entries changed in the directory may hold more values for some sub attributes than for others, which
+	// cannot be told apart
+	for parentAttr, group := range r.positional {
+		for _, mapped := range group[1:] {
+			if a, b := len(entry.Values(group[0].ldapName)), len(entry.Values(mapped.ldapName)); a != b {
+				return nil, Error.Text("%s of %s hold %d and %d values, the elements of %s cannot be told apart",
+					group[0].ldapName, mapped.ldapName, a, b, parentAttr.Assist.Path)
+			}
+		}
+	}
+
+	// in a stable order, so that sub attributes of the same elements are filled alike every time
+	mappings := make([]*mappedAttribute, 0, len(r.attributes))
+	for _, mapped := range r.attributes {
+		mappings = append(mappings, mapped)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].attr.Assist.FullPath < mappings[j].attr.Assist.FullPath
+	})
+	for _, mapped := range mappings {
+		if values := entry.Values(mapped.ldapName); len(values) > 0 {
+			mapped.assign(data, values)
+		}
+	}
+
+	if len(r.mapping.MemberAttribute) > 0 {
+		members, err := r.referencesOf(entry.Values(r.mapping.MemberAttribute), func(peer *repository) interface{} {
+			return peer.resourceType
+		}, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) > 0 {
+			data["members"] = members
+		}
+	}
+	if len(r.mapping.MemberOfAttribute) > 0 {
+		groups, err := r.referencesOf(entry.Values(r.mapping.MemberOfAttribute), func(peer *repository) interface{} {
+			return "direct"
+		}, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) > 0 {
+			data["groups"] = groups
+		}
+	}
+	return &Resource{Complex: data}, nil
+}
+
+// the DN of the user or group with the id, empty when there is none
+func (r *repository) dnOf(id string, ctx context.Context) (string, error) {
+	for _, peer := range r.peers {
+		entries, err := r.dir.Search(peer.mapping.BaseDN, and(peer.typeFilter(), equals(peer.mapping.IdAttribute, id)), ctx)
+		if err != nil {
+			return "", err
+		}
+		if len(entries) > 0 {
+			return entries[0].DN, nil
+		}
+	}
+	return "", nil
+}
+
+// references to the users and groups of the DNs; DNs of entries the server does not know are left out
+func (r *repository) referencesOf(dns []string, typeOf func(peer *repository) interface{}, ctx context.Context) ([]interface{}, error) {
+	refs := make([]interface{}, 0, len(dns))
+	for _, dn := range dns {
+		for _, peer := range r.peers {
+			if !underDN(dn, peer.mapping.BaseDN) {
+				continue
+			}
+			entries, err := r.dir.Search(dn, peer.typeFilter(), ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if ids := entry.Values(peer.mapping.IdAttribute); strings.EqualFold(entry.DN, dn) && len(ids) > 0 {
+					refs = append(refs, map[string]interface{}{"value": ids[0], "type": typeOf(peer)})
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
+// the elements of the attribute the values are taken from, the attribute itself when it is singular
+func (m *mappedAttribute) elements(data Complex) []interface{} {
+	container := map[string]interface{}(data)
+	if len(m.path.URN) > 0 {
+		ext, _ := data[m.path.URN].(map[string]interface{})
+		container = ext
+	}
+	v, ok := container[m.path.Attribute]
+	if !ok || v == nil {
+		return nil
+	}
+	if mv, ok := v.([]interface{}); ok {
+		return mv
+	}
+	return []interface{}{v}
+}
+
+// the values of the attribute in LDAP syntax
+func (m *mappedAttribute) values(data Complex) []string {
+	elements := m.elements(data)
+	values := make([]string, 0, len(elements))
+	for _, elem := range elements {
+		if len(m.path.SubAttribute) > 0 {
+			c, _ := elem.(map[string]interface{})
+			elem = c[m.path.SubAttribute]
+		}
+		if elem != nil {
+			values = append(values, formatValue(elem))
+		}
+	}
+	return values
+}
+
+// set the attribute from values in LDAP syntax
+func (m *mappedAttribute) assign(data Complex, values []string) {
+	container := map[string]interface{}(data)
+	if len(m.path.URN) > 0 {
+		ext, ok := data[m.path.URN].(map[string]interface{})
+		if !ok {
+			ext = make(map[string]interface{})
+			data[m.path.URN] = ext
+		}
+		container = ext
+	}
+
+	typed := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		typed = append(typed, parseValue(value, m.attr))
+	}
+
+	switch {
+	case len(m.path.SubAttribute) == 0 && m.multiValued:
+		container[m.path.Attribute] = typed
+	case len(m.path.SubAttribute) == 0:
+		container[m.path.Attribute] = typed[0]
+	case m.multiValued:
+		// other sub attributes of the elements may be mapped as well, the n-th values make up the n-th element
+		elements, _ := container[m.path.Attribute].([]interface{})
+		for i, v := range typed {
+			if i < len(elements) {
+				elements[i].(map[string]interface{})[m.path.SubAttribute] = v
+			} else {
+				elements = append(elements, map[string]interface{}{m.path.SubAttribute: v})
+			}
+		}
+		container[m.path.Attribute] = elements
+	default:
+		c, ok := container[m.path.Attribute].(map[string]interface{})
+		if !ok {
+			c = make(map[string]interface{})
+			container[m.path.Attribute] = c
+		}
+		c[m.path.SubAttribute] = typed[0]
+	}
+}
+
+// the names of the sub attributes, sorted
+func positionalNames(group []*mappedAttribute) string {
+	names := make([]string, 0, len(group))
+	for _, mapped := range group {
+		names = append(names, mapped.path.SubAttribute)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " and ")
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func parseValue(value string, attr *Attribute) interface{} {
+	switch attr.Type {
+	case TypeBoolean:
+		return strings.EqualFold(value, "TRUE")
+	case TypeInteger:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case TypeDecimal:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
@@ -0,0 +1,378 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	. "github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRepository_CRUD(t *testing.T) {
+	dir := newFakeDirectory()
+	users, _ := newTestRepositories(t, dir)
+	ctx := context.Background()
+
+	require.Nil(t, users.Create(testUser("6", "alice"), ctx))
+	entry := dir.entries["uid=alice,ou=people,dc=example,dc=com"]
+	require.NotNil(t, entry)
+	assert.Equal(t, []string{"inetOrgPerson"}, entry.Values("objectClass"))
+	assert.Equal(t, []string{"alice@example.com", "alice@home.com"}, entry.Values("mail"))
+	assert.Equal(t, []string{"TRUE"}, entry.Values("pwdActive"))
+
+	dp, err := users.Get("6", "v1", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "alice", dp.GetData()["userName"])
+	assert.Equal(t, true, dp.GetData()["active"])
+	assert.Equal(t, map[string]interface{}{"givenName": "Alice", "familyName": "Doe"}, dp.GetData()["name"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"value": "alice@example.com", "type": "work"},
+		map[string]interface{}{"value": "alice@home.com", "type": "home"},
+	}, dp.GetData()["emails"])
+	assert.Equal(t, "v1", dp.GetData()["meta"].(map[string]interface{})["version"])
+
+	_, err = users.Get("6", "v0", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+
+	updated := testUser("6", "alice")
+	delete(updated.Complex, "emails")
+	updated.Complex["meta"].(map[string]interface{})["version"] = "v2"
+	assert.IsType(t, &ResourceNotFoundError{}, users.Update("6", "v0", updated, ctx))
+	require.Nil(t, users.Update("6", "v1", updated, ctx))
+	assert.Nil(t, entry.Values("mail"))
+	dp, err = users.Get("6", "", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, dp.GetData()["emails"])
+
+	require.Nil(t, users.Delete("6", "v2", ctx))
+	assert.Empty(t, dir.entries)
+	_, err = users.Get("6", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+}
+
+func TestRepository_Positional(t *testing.T) {
+	dir := newFakeDirectory()
+	users, _ := newTestRepositories(t, dir)
+	ctx := context.Background()
+
+	// an email without a type would lend the type of the next email to this one
+	user := testUser("6", "alice")
+	delete(user.Complex["emails"].([]interface{})[0].(map[string]interface{}), "type")
+	err := users.Create(user, ctx)
+	assert.IsType(t, &InvalidParamError{}, err)
+	assert.Empty(t, dir.entries)
+
+	// entries changed in the directory are refused rather than mapped wrongly
+	require.Nil(t, users.Create(testUser("6", "alice"), ctx))
+	dir.entries["uid=alice,ou=people,dc=example,dc=com"].Attributes["mailType"] = []string{"home"}
+	_, err = users.Get("6", "", ctx)
+	assert.NotNil(t, err)
+}
+
+func TestRepository_Membership(t *testing.T) {
+	dir := newFakeDirectory()
+	users, groups := newTestRepositories(t, dir)
+	ctx := context.Background()
+
+	require.Nil(t, users.Create(testUser("6", "alice"), ctx))
+	require.Nil(t, users.Create(testUser("7", "bob"), ctx))
+	require.Nil(t, groups.Create(testGroup("g1", "admins", "6", "7"), ctx))
+	assert.Equal(t, []string{
+		"uid=alice,ou=people,dc=example,dc=com",
+		"uid=bob,ou=people,dc=example,dc=com",
+	}, dir.entries["cn=admins,ou=groups,dc=example,dc=com"].Values("member"))
+
+	assert.NotNil(t, groups.Create(testGroup("g2", "others", "unknown"), ctx))
+
+	dp, err := groups.Get("g1", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"value": "6", "type": UserResourceType},
+		map[string]interface{}{"value": "7", "type": UserResourceType},
+	}, dp.GetData()["members"])
+
+	dp, err = users.Get("7", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "g1", "type": "direct"}}, dp.GetData()["groups"])
+
+	lr, err := groups.Search(SearchRequest{Filter: `members.value eq "7"`}, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, lr.TotalResults)
+
+	require.Nil(t, groups.Update("g1", "", testGroup("g1", "admins", "6"), ctx))
+	dp, err = users.Get("7", "", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, dp.GetData()["groups"])
+}
+
+func TestRepository_Search(t *testing.T) {
+	dir := newFakeDirectory()
+	users, _ := newTestRepositories(t, dir)
+	ctx := context.Background()
+	for i, name := range []string{"dave", "carol", "bob", "alice"} {
+		require.Nil(t, users.Create(testUser(fmt.Sprint(i), name), ctx))
+	}
+
+	n, err := users.Count(`userName sw "a" or userName sw "b"`, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	lr, err := users.Search(SearchRequest{
+		Filter:     `userName pr`,
+		SortBy:     "userName",
+		SortOrder:  "ascending",
+		StartIndex: 2,
+		Count:      2,
+	}, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 4, lr.TotalResults)
+	require.Len(t, lr.Resources, 2)
+	assert.Equal(t, "bob", lr.Resources[0].GetData()["userName"])
+	assert.Equal(t, "carol", lr.Resources[1].GetData()["userName"])
+
+	_, err = users.Search(SearchRequest{Filter: `title eq "x"`}, ctx)
+	assert.NotNil(t, err)
+}
+
+func TestNewRepositories(t *testing.T) {
+	userSchema, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	groupSchema, _, err := ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		name  string
+		users Mapping
+	}{
+		{"no meta", Mapping{BaseDN: "dc=x", DNTemplate: "uid={userName},dc=x", ObjectClasses: []string{"a"}, IdAttribute: "id"}},
+		{"complex path", Mapping{BaseDN: "dc=x", DNTemplate: "uid={userName},dc=x", ObjectClasses: []string{"a"},
+			IdAttribute: "id", MetaAttribute: "m", Attributes: map[string]string{"name": "cn"}}},
+		{"unknown path", Mapping{BaseDN: "dc=x", DNTemplate: "uid={userName},dc=x", ObjectClasses: []string{"a"},
+			IdAttribute: "id", MetaAttribute: "m", Attributes: map[string]string{"foo": "cn"}}},
+	} {
+		_, _, err := NewRepositories(newFakeDirectory(), test.users, testGroupMapping, userSchema, groupSchema)
+		assert.NotNil(t, err, test.name)
+	}
+}
+
+var (
+	testUserMapping = Mapping{
+		BaseDN:        "ou=people,dc=example,dc=com",
+		DNTemplate:    "uid={userName},ou=people,dc=example,dc=com",
+		ObjectClasses: []string{"inetOrgPerson"},
+		IdAttribute:   "employeeNumber",
+		MetaAttribute: "description",
+		Attributes: map[string]string{
+			"userName":        "uid",
+			"name.givenName":  "givenName",
+			"name.familyName": "sn",
+			"emails.value":    "mail",
+			"emails.type":     "mailType",
+			"active":          "pwdActive",
+		},
+		MemberOfAttribute: "memberOf",
+	}
+	testGroupMapping = Mapping{
+		BaseDN:          "ou=groups,dc=example,dc=com",
+		DNTemplate:      "cn={displayName},ou=groups,dc=example,dc=com",
+		ObjectClasses:   []string{"groupOfNames"},
+		IdAttribute:     "businessCategory",
+		MetaAttribute:   "description",
+		Attributes:      map[string]string{"displayName": "cn"},
+		MemberAttribute: "member",
+	}
+)
+
+func newTestRepositories(t *testing.T, dir Directory) (*repository, *repository) {
+	userSchema, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	groupSchema, _, err := ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+	users, groups, err := NewRepositories(dir, testUserMapping, testGroupMapping, userSchema, groupSchema)
+	require.Nil(t, err)
+	return users.(*repository), groups.(*repository)
+}
+
+func testUser(id, userName string) *Resource {
+	first := strings.ToUpper(userName[:1]) + userName[1:]
+	return &Resource{Complex: Complex{
+		"schemas":  []interface{}{UserUrn},
+		"id":       id,
+		"userName": userName,
+		"name":     map[string]interface{}{"givenName": first, "familyName": "Doe"},
+		"emails": []interface{}{
+			map[string]interface{}{"value": userName + "@example.com", "type": "work"},
+			map[string]interface{}{"value": userName + "@home.com", "type": "home"},
+		},
+		"active": true,
+		"meta":   map[string]interface{}{"resourceType": UserResourceType, "version": "v1"},
+	}}
+}
+
+func testGroup(id, name string, members ...string) *Resource {
+	values := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		values = append(values, map[string]interface{}{"value": member})
+	}
+	return &Resource{Complex: Complex{
+		"schemas":     []interface{}{GroupUrn},
+		"id":          id,
+		"displayName": name,
+		"members":     values,
+		"meta":        map[string]interface{}{"resourceType": GroupResourceType, "version": "v1"},
+	}}
+}
+
+// an in memory directory evaluating the search filters the repository produces; memberOf is maintained
+// from the member attribute of groups, like the memberof overlay of OpenLDAP does
+type fakeDirectory struct {
+	sync.Mutex
+	entries map[string]*Entry
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{entries: make(map[string]*Entry)}
+}
+
+func (d *fakeDirectory) Search(baseDN, filter string, ctx context.Context) ([]*Entry, error) {
+	d.Lock()
+	defer d.Unlock()
+	dns := make([]string, 0)
+	for dn := range d.entries {
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+
+	found := make([]*Entry, 0)
+	for _, dn := range dns {
+		if !underDN(dn, baseDN) {
+			continue
+		}
+		entry := &Entry{DN: dn, Attributes: map[string][]string{}}
+		for k, v := range d.entries[dn].Attributes {
+			entry.Attributes[k] = v
+		}
+		for _, group := range d.entries {
+			for _, member := range group.Values("member") {
+				if strings.EqualFold(member, dn) {
+					entry.Attributes["memberOf"] = append(entry.Attributes["memberOf"], group.DN)
+				}
+			}
+		}
+		if ok, _ := matchFilter(filter, entry); ok {
+			found = append(found, entry)
+		}
+	}
+	return found, nil
+}
+
+func (d *fakeDirectory) Add(entry *Entry, ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.entries[entry.DN]; ok {
+		return Error.Duplicate("dn", entry.DN)
+	}
+	d.entries[entry.DN] = entry
+	return nil
+}
+
+func (d *fakeDirectory) Modify(dn string, attributes map[string][]string, ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	entry, ok := d.entries[dn]
+	if !ok {
+		return Error.ResourceNotFound(dn, "")
+	}
+	for name, values := range attributes {
+		if len(values) == 0 {
+			delete(entry.Attributes, name)
+		} else {
+			entry.Attributes[name] = values
+		}
+	}
+	return nil
+}
+
+func (d *fakeDirectory) Delete(dn string, ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.entries[dn]; !ok {
+		return Error.ResourceNotFound(dn, "")
+	}
+	delete(d.entries, dn)
+	return nil
+}
+
+func (d *fakeDirectory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// evaluate the filter at the start of the text on the entry, returning the rest of the text
+func matchFilter(text string, entry *Entry) (bool, string) {
+	text = text[1:] // (
+	switch text[0] {
+	case '&', '|':
+		op, rest := text[0], text[1:]
+		result := op == '&'
+		for rest[0] == '(' {
+			var ok bool
+			ok, rest = matchFilter(rest, entry)
+			if op == '&' {
+				result = result && ok
+			} else {
+				result = result || ok
+			}
+		}
+		return result, rest[1:]
+	case '!':
+		ok, rest := matchFilter(text[1:], entry)
+		return !ok, rest[1:]
+	}
+
+	end := strings.Index(text, ")")
+	item, rest := text[:end], text[end+1:]
+	i := strings.IndexAny(item, "<>=")
+	name, op, value := item[:i], item[i:i+1], item[i+1:]
+	if op != "=" {
+		value = value[1:]
+	}
+	for _, v := range entry.Values(name) {
+		v = strings.ToLower(v)
+		switch op {
+		case "<":
+			if v <= unescapeFilter(value) {
+				return true, rest
+			}
+		case ">":
+			if v >= unescapeFilter(value) {
+				return true, rest
+			}
+		default:
+			parts := strings.Split(value, "*")
+			if !strings.HasPrefix(v, unescapeFilter(parts[0])) {
+				continue
+			}
+			if len(parts) == 1 && v != unescapeFilter(parts[0]) {
+				continue
+			}
+			if len(parts) > 1 && !strings.HasSuffix(v, unescapeFilter(parts[len(parts)-1])) {
+				continue
+			}
+			return true, rest
+		}
+	}
+	return false, rest
+}
+
+func unescapeFilter(value string) string {
+	for _, c := range []string{"5c", "2a", "28", "29", "00"} {
+		var b byte
+		fmt.Sscanf(c, "%x", &b)
+		value = strings.Replace(value, `\`+c, string([]byte{b}), -1)
+	}
+	return strings.ToLower(value)
+}
@@ -0,0 +1,120 @@
+package mongo
+
+import (
+	"context"
+	. "github.com/davidiamyou/go-scim/shared"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Returns a journal keeping one document per request id in a collection. A TTL index on the time the request
+// was received removes entries after the retention; as MongoDB removes expired documents only once a minute,
+// Begin disregards entries past the retention that are still around.
+func NewJournal(session *mgo.Session, db, collection string, retention time.Duration) (Journal, error) {
+	j := &journal{repository: &repository{session: session, db: db, collection: collection}, retention: retention}
+	c, cleanUp := j.getCollection(context.Background())
+	defer cleanUp()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"time"}, ExpireAfter: retention, Background: true}); err != nil {
+		return nil, j.handleError(err)
+	}
+	return j, nil
+}
+
+type journal struct {
+	*repository
+	retention time.Duration
+}
+
+type journalDocument struct {
+	Key         string            `bson:"_id"`
+	Fingerprint string            `bson:"fingerprint"`
+	Status      int               `bson:"status"`
+	Headers     map[string]string `bson:"headers,omitempty"`
+	Body        []byte            `bson:"body,omitempty"`
+	Time        time.Time         `bson:"time"`
+}
+
+func (j *journal) Begin(key, fingerprint string, ctx context.Context) (*JournalEntry, error) {
+	c, cleanUp := j.getCollection(ctx)
+	defer cleanUp()
+
+	// a second attempt follows the removal of an expired entry
+	for attempt := 0; attempt < 2; attempt++ {
+		err := j.withContext(ctx, func() error {
+			return c.Insert(journalDocument{Key: key, Fingerprint: fingerprint, Time: time.Now().UTC()})
+		})
+		if err == nil {
+			return nil, nil
+		}
+		if !mgo.IsDup(err) {
+			return nil, j.handleError(err)
+		}
+
+		doc := journalDocument{}
+		err = j.withContext(ctx, func() error {
+			return c.FindId(key).One(&doc)
+		})
+		switch {
+		case err == mgo.ErrNotFound:
+			continue
+		case err != nil:
+			return nil, j.handleError(err)
+		}
+		if time.Since(doc.Time) < j.retention {
+			return &JournalEntry{
+				Key:         doc.Key,
+				Fingerprint: doc.Fingerprint,
+				Status:      doc.Status,
+				Headers:     doc.Headers,
+				Body:        doc.Body,
+				Time:        doc.Time.UTC(),
+			}, nil
+		}
+		err = j.withContext(ctx, func() error {
+			return c.Remove(bson.M{"_id": key, "time": doc.Time})
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, j.handleError(err)
+		}
+	}
+	return nil, Error.Text("failed to reserve request id %s", key)
+}
+
+func (j *journal) Complete(entry *JournalEntry, ctx context.Context) error {
+	c, cleanUp := j.getCollection(ctx)
+	defer cleanUp()
+
+	err := j.withContext(ctx, func() error {
+		return c.Update(bson.M{"_id": entry.Key, "status": 0}, bson.M{"$set": bson.M{
+			"status":  entry.Status,
+			"headers": entry.Headers,
+			"body":    entry.Body,
+		}})
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return j.handleError(err)
+}
+
+func (j *journal) Abort(key string, ctx context.Context) error {
+	c, cleanUp := j.getCollection(ctx)
+	defer cleanUp()
+
+	err := j.withContext(ctx, func() error {
+		return c.Remove(bson.M{"_id": key, "status": 0})
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return j.handleError(err)
+}
+
+func (j *journal) Expire(before time.Time) error {
+	c, cleanUp := j.getCollection(context.Background())
+	defer cleanUp()
+
+	_, err := c.RemoveAll(bson.M{"time": bson.M{"$lt": before.UTC()}})
+	return j.handleError(err)
+}
@@ -0,0 +1,62 @@
+package mongo
+
+import (
+	"context"
+	. "github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+const journalCollection = "journal"
+
+func TestJournal(t *testing.T) {
+	defer testSession.Copy().DB(dbName).C(journalCollection).DropCollection()
+	ctx := context.Background()
+	journal, err := NewJournal(testSession, dbName, journalCollection, time.Hour)
+	require.Nil(t, err)
+
+	entry, err := journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+
+	// replayed while in progress
+	entry, err = journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.InProgress())
+
+	require.Nil(t, journal.Complete(&JournalEntry{Key: "a", Fingerprint: "f1", Status: 201, Body: []byte("{}")}, ctx))
+	entry, err = journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, 201, entry.Status)
+	assert.Equal(t, "{}", string(entry.Body))
+
+	// completed entries are not released, those in progress are
+	require.Nil(t, journal.Abort("a", ctx))
+	entry, err = journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	assert.NotNil(t, entry)
+	entry, err = journal.Begin("b", "f1", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+	require.Nil(t, journal.Abort("b", ctx))
+	entry, err = journal.Begin("b", "f2", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+
+	// an entry past the retention the TTL monitor did not remove yet is disregarded
+	c := testSession.Copy().DB(dbName).C(journalCollection)
+	require.Nil(t, c.UpdateId("a", bson.M{"$set": bson.M{"time": time.Now().Add(-2 * time.Hour).UTC()}}))
+	entry, err = journal.Begin("a", "f2", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+
+	require.Nil(t, journal.Expire(time.Now().Add(time.Minute)))
+	count, err := c.Count()
+	require.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,104 @@
+package mongo
+
+import (
+	"context"
+	. "github.com/davidiamyou/go-scim/shared"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Returns an outbox keeping the pending change events in a collection, one document per event keyed by an
+// object id, so that they are read back in the order they were appended.
+func NewOutbox(session *mgo.Session, db, collection string) Outbox {
+	return &outbox{repository: &repository{session: session, db: db, collection: collection}}
+}
+
+type outbox struct {
+	*repository
+}
+
+type outboxDocument struct {
+	Key   bson.ObjectId `bson:"_id"`
+	Event *ChangeEvent  `bson:"event"`
+}
+
+func (o *outbox) Append(event *ChangeEvent, ctx context.Context) (string, error) {
+	c, cleanUp := o.getCollection(ctx)
+	defer cleanUp()
+
+	doc := outboxDocument{Key: bson.NewObjectId(), Event: event}
+	err := o.withContext(ctx, func() error {
+		return c.Insert(doc)
+	})
+	if err != nil {
+		return "", o.handleError(err)
+	}
+	return doc.Key.Hex(), nil
+}
+
+func (o *outbox) Pending(limit int, ctx context.Context) ([]OutboxEntry, error) {
+	c, cleanUp := o.getCollection(ctx)
+	defer cleanUp()
+
+	docs := make([]outboxDocument, 0)
+	err := o.withContext(ctx, func() error {
+		return withMaxTime(c.Find(nil).Sort("_id").Limit(limit), ctx).All(&docs)
+	})
+	if err != nil {
+		return nil, o.handleError(err)
+	}
+
+	entries := make([]OutboxEntry, 0, len(docs))
+	for _, doc := range docs {
+		// bson decodes time in the local zone and nested documents as bson.M
+		doc.Event.Time = doc.Event.Time.UTC()
+		if doc.Event.Document != nil {
+			doc.Event.Document = Complex(toMap(doc.Event.Document).(map[string]interface{}))
+		}
+		entries = append(entries, OutboxEntry{Key: doc.Key.Hex(), Event: doc.Event})
+	}
+	return entries, nil
+}
+
+func (o *outbox) Done(key string, ctx context.Context) error {
+	if !bson.IsObjectIdHex(key) {
+		return nil
+	}
+	c, cleanUp := o.getCollection(ctx)
+	defer cleanUp()
+
+	err := o.withContext(ctx, func() error {
+		return c.RemoveId(bson.ObjectIdHex(key))
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return o.handleError(err)
+}
+
+// convert the bson.M documents the driver decodes nested values into to plain maps
+func toMap(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.M:
+		return toMap(map[string]interface{}(v))
+	case Complex:
+		return toMap(map[string]interface{}(v))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = toMap(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = toMap(e)
+		}
+		return a
+	case time.Time:
+		return v.UTC()
+	default:
+		return v
+	}
+}
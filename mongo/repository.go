@@ -1,10 +1,14 @@
 package mongo
 
 import (
+	"context"
 	"fmt"
 	. "github.com/davidiamyou/go-scim/shared"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"net"
+	"strings"
+	"time"
 )
 
 func NewMongoRepositoryWithUrl(url, db, collection string, sch *Schema, constructor func(Complex) DataProvider) (Repository, error) {
@@ -53,13 +57,64 @@ type repository struct {
 	constructor func(Complex) DataProvider
 	dialInfo    *mgo.DialInfo
 	session     *mgo.Session
+	filters     *FilterCache
 }
 
-func (r *repository) getCollection() (*mgo.Collection, func()) {
+// Close the session of the repository, once no call is in flight anymore. The repository is unusable afterwards.
+func (r *repository) Close() error {
+	r.session.Close()
+	return nil
+}
+
+func (r *repository) UseFilterCache(cache *FilterCache) {
+	r.filters = cache
+}
+
+// the mongo query of the filter, translated once per filter while it stays in the cache
+func (r *repository) query(filter string) (bson.M, error) {
+	q, err := r.filters.Query(filter, r.schema, "mongo", func(root FilterNode) (interface{}, error) {
+		return transformToMongoQuery(root, r.schema)
+	})
+	if err != nil {
+		return bson.M{}, err
+	}
+	return q.(bson.M), nil
+}
+
+// copy the session for a single call. When the context carries a deadline, the socket timeout is
+// shortened to it so that a slow server cannot keep the call waiting past the deadline.
+func (r *repository) getCollection(ctx context.Context) (*mgo.Collection, func()) {
 	s := r.session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetSocketTimeout(time.Until(deadline))
+	}
 	return s.DB(r.db).C(r.collection), func() { s.Close() }
 }
 
+// run the call in the background and give up as soon as the context is done; closing the
+// session copy afterwards aborts the call that is still outstanding
+func (r *repository) withContext(ctx context.Context, call func() error) error {
+	if err := ctx.Err(); err != nil {
+		return r.handleError(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return r.handleError(ctx.Err())
+	}
+}
+
+// bound a query by the time left until the deadline, so that the server also stops working on it
+func withMaxTime(query *mgo.Query, ctx context.Context) *mgo.Query {
+	if deadline, ok := ctx.Deadline(); ok {
+		return query.SetMaxTime(time.Until(deadline))
+	}
+	return query
+}
+
 func (r *repository) construct(c Complex) DataProvider {
 	if r.constructor != nil {
 		return r.constructor(c)
@@ -68,15 +123,157 @@ func (r *repository) construct(c Complex) DataProvider {
 	}
 }
 
+// index meta.lastModified for delta queries, see hintOf
 func (r *repository) ensureIndexes() error {
+	c, cleanUp := r.getCollection(context.Background())
+	defer cleanUp()
+	return c.EnsureIndex(mgo.Index{Key: []string{lastModifiedKey}, Background: true})
+}
+
+// Index every attribute marked unique, so that uniqueness validation does not scan the collection. The
+// indexes are not unique themselves: values compare case insensitively unless the attribute is caseExact,
+// which the validation takes care of. Attributes of extension namespaces are not indexed, their URN holds
+// periods that cannot be part of an index key.
+func (r *repository) ReindexUnique(ctx context.Context) error {
+	if r.schema == nil {
+		return nil
+	}
+
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	for _, key := range uniqueKeys(r.schema.ToAttribute(), "") {
+		err := r.withContext(ctx, func() error {
+			return c.EnsureIndex(mgo.Index{Key: []string{key}, Background: true})
+		})
+		if err != nil {
+			return r.handleError(err)
+		}
+	}
+	for _, keys := range constraintKeys(r.schema) {
+		err := r.withContext(ctx, func() error {
+			return c.EnsureIndex(mgo.Index{Key: keys, Background: true})
+		})
+		if err != nil {
+			return r.handleError(err)
+		}
+	}
+	return nil
+}
+
+// Create the advised indexes, see AdviseIndexes, in the background. Like those of ReindexUnique they are
+// neither unique, sparse nor collated, so that both build the same index of an attribute: filters on attributes
+// that are not caseExact match case insensitive regular expressions, which scan the index rather than the
+// collection. Indexes on extension attributes, and on more than one multiValued attribute, are skipped.
+func (r *repository) EnsureIndexes(advice []IndexAdvice, ctx context.Context) error {
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	for _, each := range advice {
+		index, ok := r.advisedIndex(each)
+		if !ok {
+			continue
+		}
+		err := r.withContext(ctx, func() error {
+			return c.EnsureIndex(index)
+		})
+		if err != nil {
+			return r.handleError(err)
+		}
+	}
 	return nil
 }
 
+func (r *repository) advisedIndex(advice IndexAdvice) (mgo.Index, bool) {
+	multiValued := 0
+	for _, path := range advice.Paths {
+		if strings.Contains(path, ":") {
+			return mgo.Index{}, false
+		}
+		if r.schema != nil {
+			if attr := r.schema.ToAttribute().SubAttribute(strings.Split(path, ".")[0]); attr != nil && attr.MultiValued {
+				multiValued++
+			}
+		}
+	}
+	if len(advice.Paths) == 0 || multiValued > 1 {
+		return mgo.Index{}, false
+	}
+	return mgo.Index{Key: advice.Paths, Background: true}, true
+}
+
+// the keys of a compound index per constraint declared on the schema
+func constraintKeys(sch *Schema) [][]string {
+	indexes := make([][]string, 0)
+	for _, constraint := range sch.Constraints {
+		if keys, ok := constraintIndex(sch, constraint); ok {
+			indexes = append(indexes, keys)
+		}
+	}
+	return indexes
+}
+
+// Constraints on extension attributes are not indexed, like unique extension attributes, and neither are those
+// on more than one multiValued attribute, which mongo cannot index together.
+func constraintIndex(sch *Schema, constraint *UniqueConstraint) ([]string, bool) {
+	keys := make([]string, 0)
+	multiValued := 0
+	for _, names := range constraint.Paths() {
+		if strings.Contains(names[0], ":") {
+			return nil, false
+		}
+		if attr := sch.ToAttribute().SubAttribute(names[0]); attr != nil && attr.MultiValued {
+			multiValued++
+		}
+		keys = append(keys, strings.Join(names, "."))
+	}
+	return keys, len(keys) > 0 && multiValued <= 1
+}
+
+func uniqueKeys(guide *Attribute, prefix string) []string {
+	keys := make([]string, 0)
+	for _, attr := range guide.SubAttributes {
+		if strings.Contains(attr.Name, ":") {
+			continue
+		}
+		key := attr.Name
+		if len(prefix) > 0 {
+			key = prefix + "." + attr.Name
+		}
+		switch attr.Uniqueness {
+		case Server, Global:
+			keys = append(keys, key)
+		}
+		if attr.ExpectsComplex() {
+			keys = append(keys, uniqueKeys(attr, key)...)
+		}
+	}
+	return keys
+}
+
+// ErrorClassifier for NewRetryingRepository over mongo repositories: besides the errors IsTransientError
+// deems transient, mgo failing to reach a server or using a session closed by a failover are transient.
+func IsTransient(err error) bool {
+	if IsTransientError(err) {
+		return true
+	}
+	switch err.Error() {
+	case "no reachable servers", "Closed explicitly":
+		return true
+	}
+	return false
+}
+
 func (r *repository) handleError(err error, args ...interface{}) error {
 	if err == nil {
 		return nil
 	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return Error.Timeout("the database")
+	}
 	switch {
+	case err == context.DeadlineExceeded:
+		return Error.Timeout("the database")
 	case err.Error() == "not found":
 		if len(args) > 1 {
 			return Error.ResourceNotFound(
@@ -95,15 +292,17 @@ func (r *repository) handleError(err error, args ...interface{}) error {
 	}
 }
 
-func (r *repository) Create(provider DataProvider) error {
-	c, cleanUp := r.getCollection()
+func (r *repository) Create(provider DataProvider, ctx context.Context) error {
+	c, cleanUp := r.getCollection(ctx)
 	defer cleanUp()
 
-	return r.handleError(c.Insert(provider.GetData()))
+	return r.withContext(ctx, func() error {
+		return r.handleError(c.Insert(provider.GetData()))
+	})
 }
 
-func (r *repository) Get(id, version string) (DataProvider, error) {
-	c, cleanUp := r.getCollection()
+func (r *repository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	c, cleanUp := r.getCollection(ctx)
 	defer cleanUp()
 
 	data := make(map[string]interface{}, 0)
@@ -113,7 +312,9 @@ func (r *repository) Get(id, version string) (DataProvider, error) {
 	} else {
 		query = bson.M{"id": id, "meta.version": version}
 	}
-	err := c.Find(query).One(&data)
+	err := r.withContext(ctx, func() error {
+		return withMaxTime(c.Find(query), ctx).One(&data)
+	})
 	if err != nil {
 		return nil, r.handleError(err, id)
 	}
@@ -122,25 +323,150 @@ func (r *repository) Get(id, version string) (DataProvider, error) {
 	return r.construct(Complex(data)), nil
 }
 
-func (r *repository) GetAll() ([]Complex, error) {
+func (r *repository) Exists(id, version string, ctx context.Context) (bool, error) {
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	var query bson.M
+	if len(version) == 0 {
+		query = bson.M{"id": id}
+	} else {
+		query = bson.M{"id": id, "meta.version": version}
+	}
+	var count int
+	err := r.withContext(ctx, func() (err error) {
+		count, err = withMaxTime(c.Find(query).Limit(1), ctx).Count()
+		return
+	})
+	return count > 0, r.handleError(err)
+}
+
+func (r *repository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	var count int
+	err := r.withContext(ctx, func() (err error) {
+		count, err = withMaxTime(c.Find(bson.M{"id": bson.M{"$in": ids}}), ctx).Count()
+		return
+	})
+	return count, r.handleError(err)
+}
+
+func (r *repository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	project := bson.M{
+		"_id":   0,
+		"total": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$" + attribute, []interface{}{}}}},
+	}
+	if count > 0 {
+		project["values"] = bson.M{"$slice": []interface{}{
+			bson.M{"$ifNull": []interface{}{"$" + attribute, []interface{}{}}},
+			startIndex - 1,
+			count,
+		}}
+	}
+
+	result := struct {
+		Total  int           `bson:"total"`
+		Values []interface{} `bson:"values"`
+	}{}
+	err := r.withContext(ctx, func() error {
+		return c.Pipe([]bson.M{
+			{"$match": bson.M{"id": id}},
+			{"$project": project},
+		}).One(&result)
+	})
+	if err != nil {
+		return nil, 0, r.handleError(err, id)
+	}
+	if result.Values == nil {
+		result.Values = []interface{}{}
+	}
+	return result.Values, result.Total, nil
+}
+
+// Pull the removed members and those about to be re-added while checking the version and setting meta, then
+// push each added member unless a concurrent request added it meanwhile. The pushes do not depend on the
+// version, so that an add is not lost when a concurrent write moves the group to another version between the
+// steps; they set meta again, the group having changed since that write. The steps are not atomic, so a reader
+// may briefly miss a re-added member.
+func (r *repository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	query := bson.M{"id": id}
+	if len(version) > 0 {
+		query["meta.version"] = version
+	}
+	pull := make([]string, 0, len(removes)+len(adds))
+	pull = append(pull, removes...)
+	for _, member := range adds {
+		pull = append(pull, member.(map[string]interface{})["value"].(string))
+	}
+	update := bson.M{"$pull": bson.M{"members": bson.M{"value": bson.M{"$in": pull}}}}
+	set := bson.M{}
+	for k, v := range meta {
+		set["meta."+k] = v
+	}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	err := r.withContext(ctx, func() error {
+		return c.Update(query, update)
+	})
+	if err != nil {
+		return r.handleError(err, id, version)
+	}
+	if len(adds) == 0 {
+		return nil
+	}
+
+	err = r.withContext(ctx, func() error {
+		bulk := c.Bulk()
+		bulk.Unordered()
+		for _, member := range adds {
+			value := member.(map[string]interface{})["value"]
+			push := bson.M{"$push": bson.M{"members": member}}
+			if len(set) > 0 {
+				push["$set"] = set
+			}
+			bulk.Update(bson.M{"id": id, "members.value": bson.M{"$ne": value}}, push)
+		}
+		_, err := bulk.Run()
+		return err
+	})
+	return r.handleError(err, id)
+}
+
+func (r *repository) GetAll(ctx context.Context) ([]Complex, error) {
 	panic("not supported")
 }
 
-func (r *repository) Count(query string) (int, error) {
-	q, err := convertToMongoQuery(query, r.schema)
+func (r *repository) Count(query string, ctx context.Context) (int, error) {
+	q, err := r.query(query)
 	if err != nil {
 		return 0, r.handleError(err)
 	}
 
-	c, cleanUp := r.getCollection()
+	c, cleanUp := r.getCollection(ctx)
 	defer cleanUp()
 
-	count, err := c.Find(q).Count()
+	var count int
+	err = r.withContext(ctx, func() (err error) {
+		count, err = withMaxTime(c.Find(q), ctx).Count()
+		return
+	})
 	return count, r.handleError(err)
 }
 
-func (r *repository) Update(id, version string, provider DataProvider) error {
-	c, cleanUp := r.getCollection()
+func (r *repository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	c, cleanUp := r.getCollection(ctx)
 	defer cleanUp()
 
 	var query bson.M
@@ -149,12 +475,14 @@ func (r *repository) Update(id, version string, provider DataProvider) error {
 	} else {
 		query = bson.M{"id": id, "meta.version": version}
 	}
-	err := c.Update(query, provider.GetData())
+	err := r.withContext(ctx, func() error {
+		return c.Update(query, provider.GetData())
+	})
 	return r.handleError(err, provider.GetId())
 }
 
-func (r *repository) Delete(id, version string) error {
-	c, cleanUp := r.getCollection()
+func (r *repository) Delete(id, version string, ctx context.Context) error {
+	c, cleanUp := r.getCollection(ctx)
 	defer cleanUp()
 
 	var query bson.M
@@ -163,37 +491,70 @@ func (r *repository) Delete(id, version string) error {
 	} else {
 		query = bson.M{"id": id, "meta.version": version}
 	}
-	err := c.Remove(query)
+	err := r.withContext(ctx, func() error {
+		return c.Remove(query)
+	})
 	return r.handleError(err, id)
 }
 
-func (r *repository) Search(payload SearchRequest) (*ListResponse, error) {
-	c, cleanUp := r.getCollection()
+func (r *repository) Ping(ctx context.Context) error {
+	c, cleanUp := r.getCollection(ctx)
+	defer cleanUp()
+
+	return r.withContext(ctx, func() error {
+		return r.handleError(c.Database.Session.Ping())
+	})
+}
+
+func (r *repository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	c, cleanUp := r.getCollection(ctx)
 	defer cleanUp()
 
-	q, err := convertToMongoQuery(payload.Filter, r.schema)
+	q, err := r.query(payload.Filter)
 	if err != nil {
 		return nil, r.handleError(err)
 	}
 
-	totalResults, err := c.Find(q).Count()
+	var totalResults int
+	err = r.withContext(ctx, func() (err error) {
+		totalResults, err = withMaxTime(c.Find(q), ctx).Count()
+		return
+	})
 	if err != nil {
 		return nil, r.handleError(err)
 	}
+	partial := AcceptsPartialResults(ctx)
 
-	query := c.Find(q)
+	// count=0 only asks for totalResults, and mgo treats a limit of 0 as no limit at all
+	if payload.Count <= 0 {
+		return &ListResponse{
+			Schemas:      []string{ListResponseUrn},
+			StartIndex:   payload.StartIndex,
+			ItemsPerPage: 0,
+			TotalResults: totalResults,
+			Resources:    []DataProvider{},
+		}, nil
+	}
+
+	query := withMaxTime(c.Find(q), ctx)
+	if hint := hintOf(q, payload.SortBy); len(hint) > 0 {
+		query = query.Hint(hint)
+	}
 	if len(payload.SortBy) > 0 {
-		if payload.Ascending() {
-			query = query.Sort(payload.SortBy)
-		} else {
-			query = query.Sort("-" + payload.SortBy)
-		}
+		query = query.Sort(sortKeys(payload.SortBy, payload.Ascending())...)
 	}
 	query = query.Skip(payload.StartIndex - 1)
 	query = query.Limit(payload.Count)
 
 	listData := make([]map[string]interface{}, 0)
-	err = query.Iter().All(&listData)
+	timedOut := false
+	if partial {
+		listData, timedOut, err = r.readUntilDone(query, ctx)
+	} else {
+		err = r.withContext(ctx, func() error {
+			return query.Iter().All(&listData)
+		})
+	}
 	if err != nil {
 		return nil, r.handleError(err)
 	}
@@ -206,8 +567,40 @@ func (r *repository) Search(payload SearchRequest) (*ListResponse, error) {
 	return &ListResponse{
 		Schemas:      []string{ListResponseUrn},
 		StartIndex:   payload.StartIndex,
-		ItemsPerPage: payload.Count,
+		ItemsPerPage: len(results),
 		TotalResults: totalResults,
 		Resources:    results,
+		Partial:      timedOut,
 	}, nil
 }
+
+// the order of documents sorted by sortBy, see documentOrder
+func (r *repository) SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	return documentOrder(sortBy, ascending), nil
+}
+
+// read the documents of the query until it is exhausted or the context is done, telling whether the context
+// cut it short. Documents are read one at a time, so those read before the deadline are kept, and the server
+// stops the query by its max time.
+func (r *repository) readUntilDone(query *mgo.Query, ctx context.Context) ([]map[string]interface{}, bool, error) {
+	listData := make([]map[string]interface{}, 0)
+	iter := query.Iter()
+	for ctx.Err() == nil {
+		elem := make(map[string]interface{})
+		if !iter.Next(&elem) {
+			break
+		}
+		listData = append(listData, elem)
+	}
+	err := iter.Close()
+	if ctx.Err() != nil || isMaxTimeExpired(err) {
+		return listData, true, nil
+	}
+	return listData, false, err
+}
+
+// the error of a query the server stopped at its max time
+func isMaxTimeExpired(err error) bool {
+	qerr, ok := err.(*mgo.QueryError)
+	return ok && qerr.Code == 50
+}
@@ -1,6 +1,7 @@
 package mongo
 
 import (
+	"context"
 	"fmt"
 	. "github.com/davidiamyou/go-scim/shared"
 	"github.com/stretchr/testify/assert"
@@ -10,6 +11,7 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 )
 
 var (
@@ -56,7 +58,7 @@ func TestRepository_Create(t *testing.T) {
 	require.Nil(t, err)
 
 	repo := getTestRepository(sch)
-	repo.Create(r)
+	repo.Create(r, context.Background())
 
 	count, err := testSession.Copy().DB(dbName).C(collectionName).Count()
 	assert.Nil(t, err)
@@ -119,7 +121,7 @@ func TestRepository_Get(t *testing.T) {
 			},
 		},
 	} {
-		test.assertion(repo.Get(test.id, test.version))
+		test.assertion(repo.Get(test.id, test.version, context.Background()))
 	}
 }
 
@@ -134,16 +136,30 @@ func TestRepository_Count(t *testing.T) {
 
 	repo := getTestRepository(sch)
 
-	count, err := repo.Count("id pr")
+	count, err := repo.Count("id pr", context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, 0, count)
 
 	testSession.Copy().DB(dbName).C(collectionName).Insert(r.Complex)
-	count, err = repo.Count("id pr")
+	count, err = repo.Count("id pr", context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, 1, count)
 }
 
+func TestRepository_CountDeadlineExceeded(t *testing.T) {
+	defer cleanUp()
+
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	repo := getTestRepository(sch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = repo.Count("id pr", ctx)
+	assert.IsType(t, &TimeoutError{}, err)
+}
+
 func TestRepository_Update(t *testing.T) {
 	defer cleanUp()
 
@@ -159,9 +175,9 @@ func TestRepository_Update(t *testing.T) {
 
 	r.Complex["userName"] = "foo"
 	version := r.GetData()["meta"].(map[string]interface{})["version"].(string)
-	repo.Update(r.GetId(), version, r)
+	repo.Update(r.GetId(), version, r, context.Background())
 
-	r0, err := repo.Get(r.GetId(), "")
+	r0, err := repo.Get(r.GetId(), "", context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, r.GetId(), r0.GetId())
 	assert.Equal(t, "foo", r0.GetData()["userName"])
@@ -177,12 +193,12 @@ func TestRepository_Delete(t *testing.T) {
 	require.Nil(t, err)
 
 	repo := getTestRepository(sch)
-	err = repo.Delete(r.GetId(), "")
+	err = repo.Delete(r.GetId(), "", context.Background())
 	assert.NotNil(t, err)
 	assert.IsType(t, &ResourceNotFoundError{}, err)
 
 	testSession.Copy().DB(dbName).C(collectionName).Insert(r.Complex)
-	err = repo.Delete(r.GetId(), "")
+	err = repo.Delete(r.GetId(), "", context.Background())
 	assert.Nil(t, err)
 
 	count, err := testSession.Copy().DB(dbName).C(collectionName).Count()
@@ -243,7 +259,7 @@ func TestRepository_Search(t *testing.T) {
 			},
 		},
 	} {
-		test.assertion(repo.Search(test.payload))
+		test.assertion(repo.Search(test.payload, context.Background()))
 	}
 }
 
@@ -0,0 +1,79 @@
+package mongo
+
+import (
+	"context"
+	. "github.com/davidiamyou/go-scim/shared"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Returns a tombstone store keeping one document per deleted resource in a collection, for the tombstones to be
+// shared by all server instances and to survive restarts. A TTL index on the time of the deletion removes them
+// after the retention. Close closes the session.
+func NewTombstoneStore(session *mgo.Session, db, collection string, retention time.Duration) (TombstoneStore, error) {
+	s := &tombstoneStore{repository: &repository{session: session, db: db, collection: collection}}
+	c, cleanUp := s.getCollection(context.Background())
+	defer cleanUp()
+	if err := c.EnsureIndex(mgo.Index{Key: []string{"deleted"}, ExpireAfter: retention, Background: true}); err != nil {
+		return nil, s.handleError(err)
+	}
+	return s, nil
+}
+
+type tombstoneStore struct {
+	*repository
+}
+
+type tombstoneDocument struct {
+	Id      string    `bson:"_id"`
+	Deleted time.Time `bson:"deleted"`
+}
+
+func (s *tombstoneStore) Put(id string, deleted time.Time, ctx context.Context) error {
+	c, cleanUp := s.getCollection(ctx)
+	defer cleanUp()
+
+	return s.handleError(s.withContext(ctx, func() error {
+		_, err := c.UpsertId(id, tombstoneDocument{Id: id, Deleted: deleted.UTC()})
+		return err
+	}))
+}
+
+func (s *tombstoneStore) Get(id string, ctx context.Context) (time.Time, bool, error) {
+	c, cleanUp := s.getCollection(ctx)
+	defer cleanUp()
+
+	doc := tombstoneDocument{}
+	err := s.withContext(ctx, func() error {
+		return c.FindId(id).One(&doc)
+	})
+	switch {
+	case err == mgo.ErrNotFound:
+		return time.Time{}, false, nil
+	case err != nil:
+		return time.Time{}, false, s.handleError(err)
+	}
+	return doc.Deleted.UTC(), true, nil
+}
+
+func (s *tombstoneStore) Remove(id string, ctx context.Context) error {
+	c, cleanUp := s.getCollection(ctx)
+	defer cleanUp()
+
+	err := s.withContext(ctx, func() error {
+		return c.RemoveId(id)
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return s.handleError(err)
+}
+
+func (s *tombstoneStore) Expire(before time.Time) error {
+	c, cleanUp := s.getCollection(context.Background())
+	defer cleanUp()
+
+	_, err := c.RemoveAll(bson.M{"deleted": bson.M{"$lt": before.UTC()}})
+	return s.handleError(err)
+}
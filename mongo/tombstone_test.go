@@ -0,0 +1,44 @@
+package mongo
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const tombstoneCollection = "user_tombstones"
+
+func TestTombstoneStore(t *testing.T) {
+	defer testSession.Copy().DB(dbName).C(tombstoneCollection).DropCollection()
+	ctx := context.Background()
+	store, err := NewTombstoneStore(testSession.Copy(), dbName, tombstoneCollection, time.Hour)
+	require.Nil(t, err)
+
+	_, ok, err := store.Get("foo", ctx)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	deleted := time.Now().Truncate(time.Millisecond).UTC()
+	require.Nil(t, store.Put("foo", deleted, ctx))
+	require.Nil(t, store.Put("bar", deleted.Add(-2*time.Hour), ctx))
+	at, ok, err := store.Get("foo", ctx)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, deleted, at)
+
+	// deleted again, the time is replaced
+	require.Nil(t, store.Put("foo", deleted.Add(time.Minute), ctx))
+	at, _, _ = store.Get("foo", ctx)
+	assert.Equal(t, deleted.Add(time.Minute), at)
+
+	require.Nil(t, store.Expire(deleted.Add(-time.Hour)))
+	_, ok, _ = store.Get("bar", ctx)
+	assert.False(t, ok)
+
+	require.Nil(t, store.Remove("foo", ctx))
+	require.Nil(t, store.Remove("foo", ctx))
+	_, ok, _ = store.Get("foo", ctx)
+	assert.False(t, ok)
+}
@@ -5,10 +5,21 @@ import (
 	. "github.com/davidiamyou/go-scim/shared"
 	"gopkg.in/mgo.v2/bson"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 )
 
 func convertToMongoQuery(query string, guide AttributeSource) (m bson.M, err error) {
+	q, err := CompileFilter(query, guide)
+	if err != nil {
+		return bson.M{}, err
+	}
+	return transformToMongoQuery(q, guide)
+}
+
+// translate a compiled filter to a mongo query
+func transformToMongoQuery(q FilterNode, guide AttributeSource) (m bson.M, err error) {
 	m = bson.M{}
 
 	defer func() {
@@ -22,17 +33,34 @@ func convertToMongoQuery(query string, guide AttributeSource) (m bson.M, err err
 		}
 	}()
 
-	q, err := NewFilter(query)
-	if err != nil {
-		return
-	}
-	q.CorrectCase(guide)
-
 	m = transformInstance.do(q, guide)
 	err = nil
 	return
 }
 
+// Translates a registered filter operator, see shared.RegisterFilterOperator, applied to the attribute and the
+// value of the filter to a query, i.e. one matching the field attr.Assist.Path against a regular expression
+type FilterTranslation func(attr *Attribute, operand interface{}) (bson.M, error)
+
+var filterTranslations = struct {
+	sync.RWMutex
+	byName map[string]FilterTranslation
+}{byName: make(map[string]FilterTranslation)}
+
+// Make the repository support the registered filter operator of the name. Filters using operators without
+// translation fail with InvalidFilterError.
+func RegisterFilterTranslation(name string, translate FilterTranslation) {
+	filterTranslations.Lock()
+	defer filterTranslations.Unlock()
+	filterTranslations.byName[strings.ToLower(name)] = translate
+}
+
+func lookupFilterTranslation(name string) FilterTranslation {
+	filterTranslations.RLock()
+	defer filterTranslations.RUnlock()
+	return filterTranslations.byName[strings.ToLower(name)]
+}
+
 var (
 	singleTransform   sync.Once
 	transformInstance *transform
@@ -80,6 +108,12 @@ func (t *transform) do(root FilterNode, guide AttributeSource) bson.M {
 		}
 	}
 
+	if attr != nil && attr.Type == TypeDateTime {
+		if m, ok := t.dateTime(root, attr); ok {
+			return m
+		}
+	}
+
 	switch root.Data() {
 	case And:
 		return bson.M{
@@ -251,12 +285,224 @@ func (t *transform) do(root FilterNode, guide AttributeSource) bson.M {
 		}
 		return bson.M{"$and": criterion}
 	default:
+		if translate := lookupFilterTranslation(fmt.Sprint(root.Data())); translate != nil && attr != nil {
+			m, err := translate(attr, root.Right().Data())
+			t.throwIfError(err)
+			return m
+		}
 		t.throwIfError(Error.InvalidFilter("", fmt.Sprintf("unknown operator %v", root.Data())))
 	}
 
 	return nil
 }
 
+// dateTime values are stored in UTC to the millisecond (see FormatDateTime), so the operand is normalized alike
+// and compared as is rather than by case insensitive regular expression
+func (t *transform) dateTime(root FilterNode, attr *Attribute) (bson.M, bool) {
+	var operator string
+	switch root.Data() {
+	case Eq:
+		operator = "$eq"
+	case Ne:
+		operator = "$ne"
+	case Gt:
+		operator = "$gt"
+	case Ge:
+		operator = "$gte"
+	case Lt:
+		operator = "$lt"
+	case Le:
+		operator = "$lte"
+	default:
+		return nil, false
+	}
+
+	text, ok := root.Right().Data().(string)
+	if !ok {
+		return nil, false
+	}
+	return bson.M{attr.Assist.Path: bson.M{operator: NormalizeDateTime(text)}}, true
+}
+
+// The index queries are to be hinted to, if any. Delta queries of identity providers syncing the changes
+// since their last run, i.e. 'meta.lastModified gt "2021-06-01T07:00:00Z"', are hinted to the index of
+// meta.lastModified, unless sorted by another attribute, which the planner may prefer an index of.
+func hintOf(q bson.M, sortBy string) string {
+	if len(sortBy) > 0 && !strings.EqualFold(sortBy, lastModifiedKey) {
+		return ""
+	}
+	if !onlyOn(q, lastModifiedKey) {
+		return ""
+	}
+	return lastModifiedKey
+}
+
+const lastModifiedKey = "meta.lastModified"
+
+// whether the query has conditions on the key and on no other
+func onlyOn(q bson.M, key string) bool {
+	if len(q) != 1 {
+		return false
+	}
+	if _, ok := q[key]; ok {
+		return true
+	}
+	and, ok := q["$and"].([]interface{})
+	if !ok || len(and) == 0 {
+		return false
+	}
+	for _, each := range and {
+		if m, ok := each.(bson.M); !ok || !onlyOn(m, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// The keys documents are sorted by for sortBy, the id breaking ties so that pages are stable. The id is not
+// indexed along with other attributes, so ties are sorted in memory.
+func sortKeys(sortBy string, ascending bool) []string {
+	keys := []string{sortBy}
+	if sortBy != "id" {
+		keys = append(keys, "id")
+	}
+	if !ascending {
+		for i, key := range keys {
+			keys[i] = "-" + key
+		}
+	}
+	return keys
+}
+
+// The order of documents sorted by sortKeys, the way MongoDB compares the values of a field: documents lacking
+// it as null, values of different BSON types by the rank of the type, strings by byte as no collation is set,
+// and arrays by their least element ascending and their greatest descending.
+func documentOrder(sortBy string, ascending bool) func(a, b DataProvider) int {
+	path := strings.Split(sortBy, ".")
+	return func(a, b DataProvider) int {
+		if !ascending {
+			a, b = b, a
+		}
+		if c := compareBSON(sortValue(a, path, ascending), sortValue(b, path, ascending)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.GetId(), b.GetId())
+	}
+}
+
+// the value of the path the document sorts by, the least or greatest of arrays, nil if there is none
+func sortValue(dp DataProvider, path []string, ascending bool) interface{} {
+	var sorted interface{}
+	first := true
+	var visit func(v interface{}, path []string)
+	visit = func(v interface{}, path []string) {
+		if array, ok := v.([]interface{}); ok {
+			for _, each := range array {
+				visit(each, path)
+			}
+			return
+		}
+		if len(path) > 0 {
+			if m, ok := v.(map[string]interface{}); ok {
+				visit(m[path[0]], path[1:])
+			} else if m, ok := v.(bson.M); ok {
+				visit(m[path[0]], path[1:])
+			}
+			return
+		}
+		if c := compareBSON(v, sorted); first || (ascending && c < 0) || (!ascending && c > 0) {
+			sorted, first = v, false
+		}
+	}
+	visit(map[string]interface{}(dp.GetData()), path)
+	return sorted
+}
+
+// -1, 0 or 1 as MongoDB orders the values, of the types the driver decodes documents into
+func compareBSON(a, b interface{}) int {
+	if ra, rb := bsonRank(a), bsonRank(b); ra != rb {
+		return compareInts(ra, rb)
+	}
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		return compareInts(boolRank(a), boolRank(b.(bool)))
+	case time.Time:
+		switch {
+		case a.Before(b.(time.Time)):
+			return -1
+		case a.After(b.(time.Time)):
+			return 1
+		}
+		return 0
+	case nil:
+		return 0
+	}
+	if fa, ok := number(a); ok {
+		fb, _ := number(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// the rank of the BSON type of the value among those sorted, see the comparison/sort order of MongoDB
+func bsonRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case string:
+		return 3
+	case map[string]interface{}, bson.M:
+		return 4
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	}
+	if _, ok := number(v); ok {
+		return 2
+	}
+	return 5
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
 func (t *transform) throwIfError(err error) {
 	if err != nil {
 		panic(err)
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"gopkg.in/mgo.v2/bson"
 	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -87,3 +88,114 @@ func TestConvertToMongoQuery(t *testing.T) {
 		test.assertion(convertToMongoQuery(test.queryText, sch))
 	}
 }
+
+func TestConvertToMongoQuery_RegisteredOperator(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	require.Nil(t, RegisterFilterOperator(&FilterOperator{Name: "re", Types: []string{TypeString}}))
+	require.Nil(t, RegisterFilterOperator(&FilterOperator{Name: "untranslated"}))
+	RegisterFilterTranslation("re", func(attr *Attribute, operand interface{}) (bson.M, error) {
+		return bson.M{attr.Assist.Path: bson.M{"$regex": bson.RegEx{Pattern: operand.(string)}}}, nil
+	})
+
+	result, err := convertToMongoQuery(`displayName re "^J.+n$"`, sch)
+	require.Nil(t, err)
+	assert.Equal(t, bson.M{"displayName": bson.M{"$regex": bson.RegEx{Pattern: "^J.+n$"}}}, result)
+
+	_, err = convertToMongoQuery(`displayName untranslated "John"`, sch)
+	assert.IsType(t, &InvalidFilterError{}, err)
+}
+
+func TestConvertToMongoQuery_Meta(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		queryText string
+		expect    bson.M
+	}{
+		{
+			`meta.lastModified gt "2021-06-01T12:00:00+05:00"`,
+			bson.M{"meta.lastModified": bson.M{"$gt": "2021-06-01T07:00:00.000Z"}},
+		},
+		{
+			// stored values are to the millisecond, whatever the precision of the operand
+			`meta.lastModified ge "2021-06-01T07:00:00.5Z"`,
+			bson.M{"meta.lastModified": bson.M{"$gte": "2021-06-01T07:00:00.500Z"}},
+		},
+		{
+			`meta.created eq "2021-06-01T07:00:00Z"`,
+			bson.M{"meta.created": bson.M{"$eq": "2021-06-01T07:00:00.000Z"}},
+		},
+		{
+			`meta.lastModified le "2021-06-01T07:00:00.0001Z"`,
+			bson.M{"meta.lastModified": bson.M{"$lte": "2021-06-01T07:00:00.000Z"}},
+		},
+	} {
+		result, err := convertToMongoQuery(test.queryText, sch)
+		require.Nil(t, err, test.queryText)
+		assert.Equal(t, test.expect, result, test.queryText)
+	}
+
+	for _, test := range []struct {
+		queryText string
+		sortBy    string
+		hint      string
+	}{
+		{`meta.lastModified gt "2021-06-01T07:00:00Z"`, "", "meta.lastModified"},
+		{`meta.lastModified gt "2021-06-01T07:00:00Z" and meta.lastModified le "2021-07-01T07:00:00Z"`, "meta.lastModified", "meta.lastModified"},
+		{`meta.lastModified gt "2021-06-01T07:00:00Z"`, "userName", ""},
+		{`meta.lastModified gt "2021-06-01T07:00:00Z" and userName eq "david"`, "", ""},
+		{`meta.lastModified gt "2021-06-01T07:00:00Z" or meta.created gt "2021-06-01T07:00:00Z"`, "", ""},
+	} {
+		q, err := convertToMongoQuery(test.queryText, sch)
+		require.Nil(t, err, test.queryText)
+		assert.Equal(t, test.hint, hintOf(q, test.sortBy), test.queryText)
+	}
+}
+
+func TestDocumentOrder(t *testing.T) {
+	assert.Equal(t, []string{"userName", "id"}, sortKeys("userName", true))
+	assert.Equal(t, []string{"-meta.lastModified", "-id"}, sortKeys("meta.lastModified", false))
+	assert.Equal(t, []string{"-id"}, sortKeys("id", false))
+
+	user := func(id string, data map[string]interface{}) DataProvider {
+		data["id"] = id
+		return &Resource{Complex: Complex(data)}
+	}
+	users := []DataProvider{
+		user("a", map[string]interface{}{"userName": "b"}),
+		user("b", map[string]interface{}{"userName": "B"}),
+		user("c", map[string]interface{}{}),
+		user("d", map[string]interface{}{"userName": 7}),
+		user("e", map[string]interface{}{"userName": "b"}),
+		user("f", map[string]interface{}{"userName": []interface{}{"a", "z"}}),
+	}
+	sorted := func(sortBy string, ascending bool) []string {
+		compare := documentOrder(sortBy, ascending)
+		ordered := append([]DataProvider{}, users...)
+		sort.Slice(ordered, func(i, j int) bool { return compare(ordered[i], ordered[j]) < 0 })
+		ids := make([]string, 0)
+		for _, dp := range ordered {
+			ids = append(ids, dp.GetId())
+		}
+		return ids
+	}
+	// missing values first, numbers before strings, which compare by byte, arrays by their least element
+	// ascending and their greatest descending, ties by id
+	assert.Equal(t, []string{"c", "d", "b", "f", "a", "e"}, sorted("userName", true))
+	assert.Equal(t, []string{"f", "e", "a", "b", "d", "c"}, sorted("userName", false))
+
+	// paths reach into arrays of objects
+	emails := func(values ...string) map[string]interface{} {
+		all := make([]interface{}, 0)
+		for _, value := range values {
+			all = append(all, map[string]interface{}{"value": value})
+		}
+		return map[string]interface{}{"emails": all}
+	}
+	users = []DataProvider{user("a", emails("m", "x")), user("b", emails("n")), user("c", emails("c", "o"))}
+	assert.Equal(t, []string{"c", "a", "b"}, sorted("emails.value", true))
+	assert.Equal(t, []string{"a", "c", "b"}, sorted("emails.value", false))
+}
@@ -0,0 +1,41 @@
+//go:build kafka
+// +build kafka
+
+package publish
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/segmentio/kafka-go"
+)
+
+// Returns a publisher writing the events to the Kafka topic of their resource type, see Destination. The
+// resource id is the message key, so that the events of a resource land on one partition and keep their order.
+// The writer must not have a topic of its own; it is closed by the caller.
+func NewKafkaPublisher(writer *kafka.Writer, topicPrefix string) shared.Publisher {
+	return shared.PublisherFunc(func(event *shared.ChangeEvent, ctx context.Context) error {
+		body, err := Encode(event)
+		if err != nil {
+			return err
+		}
+		return writer.WriteMessages(ctx, kafka.Message{
+			Topic: Destination(topicPrefix, event),
+			Key:   []byte(event.Id),
+			Value: body,
+			Headers: []kafka.Header{
+				{Key: "eventId", Value: []byte(event.EventId)},
+				{Key: "op", Value: []byte(event.Op)},
+			},
+		})
+	})
+}
+
+// Returns a writer to the brokers that waits for all in-sync replicas to acknowledge every message and
+// partitions by key
+func NewKafkaWriter(brokers ...string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+}
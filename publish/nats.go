@@ -0,0 +1,24 @@
+//go:build nats
+// +build nats
+
+package publish
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/nats-io/nats.go"
+)
+
+// Returns a publisher sending the events to the JetStream subject of their resource type, see Destination.
+// Publish waits for the stream to acknowledge the event; the event id is the message id, so that the stream
+// drops events the outbox relay delivers again within its duplicate window.
+func NewNATSPublisher(js nats.JetStreamContext, subjectPrefix string) shared.Publisher {
+	return shared.PublisherFunc(func(event *shared.ChangeEvent, ctx context.Context) error {
+		body, err := Encode(event)
+		if err != nil {
+			return err
+		}
+		_, err = js.Publish(Destination(subjectPrefix, event), body, nats.MsgId(event.EventId), nats.Context(ctx))
+		return err
+	})
+}
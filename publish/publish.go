@@ -0,0 +1,30 @@
+// Package publish delivers the change events of shared.NewPublishingRepository to message brokers and webhooks.
+// Every broker client is an optional dependency behind a build tag of its own: build with -tags kafka for
+// NewKafkaPublisher and with -tags nats for NewNATSPublisher. NewWebhookPublisher needs none.
+package publish
+
+import (
+	"github.com/davidiamyou/go-scim/shared"
+	"strings"
+)
+
+// The topic or subject of the events of a resource type: the prefix followed by the lower cased resource
+// type, i.e. 'scim.user' for the prefix 'scim'
+func Destination(prefix string, event *shared.ChangeEvent) string {
+	if len(prefix) == 0 {
+		return strings.ToLower(event.ResourceType)
+	}
+	return prefix + "." + strings.ToLower(event.ResourceType)
+}
+
+// The message body of the event, as JSON, the document written as the publishing repository masked it
+func Encode(event *shared.ChangeEvent) ([]byte, error) {
+	return EncodeWith(shared.JSONCodec, event, nil)
+}
+
+// The message body of the event in the format of the codec, i.e. shared.CBORCodec for sinks of high
+// throughput. The document is written as a resource of the schema, of the resource type of the event, in
+// schema order and without the attributes it never returns; as it is if the schema is nil.
+func EncodeWith(codec shared.Codec, event *shared.ChangeEvent, sch *shared.Schema) ([]byte, error) {
+	return codec.Marshal(event, sch, nil, nil)
+}
@@ -0,0 +1,48 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Returns a publisher posting the events to the URL of a webhook, one request per event with the event as
+// body, see Encode. The secret, unless empty, is sent in the X-Webhook-Secret header, which agent.Webhook
+// checks, and the event id in the X-Event-Id header, for receivers to drop redeliveries. Responses other than
+// 2xx fail the publish. A nil client is one giving up after ten seconds.
+func NewWebhookPublisher(url, secret string, client *http.Client) shared.Publisher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return shared.PublisherFunc(func(event *shared.ChangeEvent, ctx context.Context) error {
+		body, err := Encode(event)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Event-Id", event.EventId)
+		if len(secret) > 0 {
+			req.Header.Set("X-Webhook-Secret", secret)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook %s answered %s", url, resp.Status)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,46 @@
+// Package rpc exposes the provisioning pipeline over gRPC, so that internal services can provision
+// identities without going through HTTP and JSON. Calls are turned into web requests and run through
+// the very handlers the HTTP API uses, sharing the ScimServer configuration, validation and hooks.
+//
+// The gRPC service is only built with the grpc build tag, after generating the protobuf code:
+//
+//	go generate ./rpc && go build -tags grpc ./...
+//
+// CI does both, see .travis.yml.
+package rpc
+
+//go:generate protoc --go_out=plugins=grpc:. scim.proto
+
+import (
+	"context"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"strings"
+)
+
+// A WebRequest assembled from an RPC message instead of read off the wire
+type Request struct {
+	Verb    string
+	Path    string
+	Params  map[string]string
+	Headers map[string]string // names are matched case insensitively
+	Payload []byte
+}
+
+func (r *Request) Target() string           { return r.Path }
+func (r *Request) Method() string           { return r.Verb }
+func (r *Request) Param(name string) string { return r.Params[name] }
+func (r *Request) Body() ([]byte, error)    { return r.Payload, nil }
+func (r *Request) Header(name string) string {
+	for k, v := range r.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Run the handler through the same wrappers the HTTP endpoints are built from, see handlers.Chain
+func Invoke(handler handlers.EndpointHandler, requestType int, req shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+	return handlers.Chain(handler, requestType)(req, server, ctx)
+}
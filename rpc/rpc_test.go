@@ -0,0 +1,26 @@
+package rpc
+
+import (
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	req := &Request{
+		Verb:    http.MethodPut,
+		Params:  map[string]string{"resourceId": "foo"},
+		Headers: map[string]string{"if-match": "W/\"1\""},
+		Payload: []byte(`{"id":"foo"}`),
+	}
+
+	id, version := handlers.ParseIdAndVersion(req)
+	assert.Equal(t, "foo", id)
+	assert.Equal(t, "W/\"1\"", version)
+	assert.Equal(t, "", req.Header("Idempotency-Key"))
+
+	body, err := req.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"foo"}`, string(body))
+}
@@ -0,0 +1,270 @@
+//go:build grpc
+// +build grpc
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// Register the Provisioning service backed by the server with the gRPC server
+func Register(s *grpc.Server, server handlers.ScimServer) {
+	RegisterProvisioningServer(s, NewService(server))
+}
+
+// Implements the Provisioning service on top of the handlers of the HTTP API
+type Service struct {
+	server handlers.ScimServer
+}
+
+func NewService(server handlers.ScimServer) *Service {
+	return &Service{server: server}
+}
+
+func (s *Service) CreateUser(ctx context.Context, in *CreateRequest) (*Resource, error) {
+	return s.create(handlers.CreateUserHandler, shared.CreateUser, in, ctx)
+}
+
+func (s *Service) GetUser(ctx context.Context, in *GetRequest) (*Resource, error) {
+	return s.get(handlers.GetUserByIdHandler, shared.GetUserById, in, ctx)
+}
+
+func (s *Service) ReplaceUser(ctx context.Context, in *ReplaceRequest) (*Resource, error) {
+	return s.replace(handlers.ReplaceUserHandler, shared.ReplaceUser, in, ctx)
+}
+
+func (s *Service) PatchUser(ctx context.Context, in *PatchRequest) (*Resource, error) {
+	return s.patch(handlers.PatchUserHandler, shared.PatchUser, in, ctx)
+}
+
+func (s *Service) DeleteUser(ctx context.Context, in *DeleteRequest) (*DeleteResponse, error) {
+	return s.delete(handlers.DeleteUserByIdHandler, shared.DeleteUser, in, ctx)
+}
+
+func (s *Service) SearchUsers(ctx context.Context, in *SearchRequest) (*ListResponse, error) {
+	return s.search(handlers.QueryUserHandler, shared.QueryUser, in, ctx)
+}
+
+func (s *Service) CreateGroup(ctx context.Context, in *CreateRequest) (*Resource, error) {
+	return s.create(handlers.CreateGroupHandler, shared.CreateGroup, in, ctx)
+}
+
+func (s *Service) GetGroup(ctx context.Context, in *GetRequest) (*Resource, error) {
+	return s.get(handlers.GetGroupByIdHandler, shared.GetGroupById, in, ctx)
+}
+
+func (s *Service) ReplaceGroup(ctx context.Context, in *ReplaceRequest) (*Resource, error) {
+	return s.replace(handlers.ReplaceGroupHandler, shared.ReplaceGroup, in, ctx)
+}
+
+func (s *Service) PatchGroup(ctx context.Context, in *PatchRequest) (*Resource, error) {
+	return s.patch(handlers.PatchGroupHandler, shared.PatchGroup, in, ctx)
+}
+
+func (s *Service) DeleteGroup(ctx context.Context, in *DeleteRequest) (*DeleteResponse, error) {
+	return s.delete(handlers.DeleteGroupByIdHandler, shared.DeleteGroup, in, ctx)
+}
+
+func (s *Service) SearchGroups(ctx context.Context, in *SearchRequest) (*ListResponse, error) {
+	return s.search(handlers.QueryGroupHandler, shared.QueryGroup, in, ctx)
+}
+
+func (s *Service) create(handler handlers.EndpointHandler, requestType int, in *CreateRequest, ctx context.Context) (*Resource, error) {
+	req := newRequest(http.MethodPost, ctx)
+	req.Payload = in.GetResource().GetAttributes()
+	return toResource(Invoke(handler, requestType, req, s.server, ctx))
+}
+
+func (s *Service) get(handler handlers.EndpointHandler, requestType int, in *GetRequest, ctx context.Context) (*Resource, error) {
+	req := newRequest(http.MethodGet, ctx)
+	req.Params["resourceId"] = in.GetId()
+	req.Params["attributes"] = strings.Join(in.GetAttributes(), ",")
+	req.Params["excludedAttributes"] = strings.Join(in.GetExcludedAttributes(), ",")
+	if len(in.GetVersion()) > 0 {
+		req.Headers["If-None-Match"] = in.GetVersion()
+	}
+
+	ri := Invoke(handler, requestType, req, s.server, ctx)
+	if ri.GetStatus() == http.StatusNotModified {
+		return &Resource{Id: in.GetId(), Version: in.GetVersion()}, nil
+	}
+	return toResource(ri)
+}
+
+func (s *Service) replace(handler handlers.EndpointHandler, requestType int, in *ReplaceRequest, ctx context.Context) (*Resource, error) {
+	req := newRequest(http.MethodPut, ctx)
+	req.Params["resourceId"] = in.GetResource().GetId()
+	req.Headers["If-Match"] = in.GetResource().GetVersion()
+	req.Payload = in.GetResource().GetAttributes()
+	return toResource(Invoke(handler, requestType, req, s.server, ctx))
+}
+
+func (s *Service) patch(handler handlers.EndpointHandler, requestType int, in *PatchRequest, ctx context.Context) (*Resource, error) {
+	operations := make([]map[string]interface{}, 0, len(in.GetPatch().GetOperations()))
+	for _, op := range in.GetPatch().GetOperations() {
+		operation := map[string]interface{}{"op": op.GetOp(), "path": op.GetPath()}
+		if len(op.GetValue()) > 0 {
+			operation["value"] = json.RawMessage(op.GetValue())
+		}
+		operations = append(operations, operation)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"schemas":    []string{shared.PatchOpUrn},
+		"Operations": operations,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid patch value: %s", err.Error())
+	}
+
+	req := newRequest(http.MethodPatch, ctx)
+	req.Params["resourceId"] = in.GetId()
+	req.Headers["If-Match"] = in.GetVersion()
+	req.Payload = payload
+	return toResource(Invoke(handler, requestType, req, s.server, ctx))
+}
+
+func (s *Service) delete(handler handlers.EndpointHandler, requestType int, in *DeleteRequest, ctx context.Context) (*DeleteResponse, error) {
+	req := newRequest(http.MethodDelete, ctx)
+	req.Params["resourceId"] = in.GetId()
+	req.Headers["If-Match"] = in.GetVersion()
+
+	ri := Invoke(handler, requestType, req, s.server, ctx)
+	if ri.GetStatus() >= http.StatusBadRequest {
+		return nil, toError(ri)
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *Service) search(handler handlers.EndpointHandler, requestType int, in *SearchRequest, ctx context.Context) (*ListResponse, error) {
+	payload, err := json.Marshal(shared.SearchRequest{
+		Schemas:            []string{shared.SearchUrn},
+		Filter:             in.GetFilter(),
+		SortBy:             in.GetSortBy(),
+		SortOrder:          in.GetSortOrder(),
+		StartIndex:         int(in.GetStartIndex()),
+		Count:              int(in.GetCount()),
+		Attributes:         in.GetAttributes(),
+		ExcludedAttributes: in.GetExcludedAttributes(),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid search request: %s", err.Error())
+	}
+
+	req := newRequest(http.MethodPost, ctx)
+	req.Path = "/.search"
+	req.Payload = payload
+
+	ri := Invoke(handler, requestType, req, s.server, ctx)
+	if ri.GetStatus() >= http.StatusBadRequest {
+		return nil, toError(ri)
+	}
+
+	lr := struct {
+		TotalResults int               `json:"totalResults"`
+		StartIndex   int               `json:"startIndex"`
+		ItemsPerPage int               `json:"itemsPerPage"`
+		Resources    []json.RawMessage `json:"Resources"`
+	}{}
+	if err := json.Unmarshal(ri.GetBody(), &lr); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid list response: %s", err.Error())
+	}
+	resp := &ListResponse{
+		TotalResults: int32(lr.TotalResults),
+		StartIndex:   int32(lr.StartIndex),
+		ItemsPerPage: int32(lr.ItemsPerPage),
+		Resources:    make([]*Resource, 0, len(lr.Resources)),
+	}
+	for _, raw := range lr.Resources {
+		resource, err := decodeResource(raw)
+		if err != nil {
+			return nil, err
+		}
+		resp.Resources = append(resp.Resources, resource)
+	}
+	return resp, nil
+}
+
+// start a request carrying the incoming gRPC metadata as headers, so that trace propagation, idempotency keys
+// and the like work as they do over HTTP
+func newRequest(method string, ctx context.Context) *Request {
+	req := &Request{
+		Verb:    method,
+		Params:  map[string]string{},
+		Headers: map[string]string{},
+	}
+	if method != http.MethodGet && method != http.MethodDelete {
+		req.Headers["Content-Type"] = shared.ScimMediaType
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if len(v) > 0 {
+				req.Headers[k] = v[0]
+			}
+		}
+	}
+	// gRPC compresses on its own, responses are decoded here
+	delete(req.Headers, "accept-encoding")
+	return req
+}
+
+func toResource(ri *handlers.ResponseInfo) (*Resource, error) {
+	if ri.GetStatus() >= http.StatusBadRequest {
+		return nil, toError(ri)
+	}
+	return decodeResource(ri.GetBody())
+}
+
+func decodeResource(raw []byte) (*Resource, error) {
+	data := struct {
+		Id   string `json:"id"`
+		Meta struct {
+			Version string `json:"version"`
+		} `json:"meta"`
+	}{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid resource: %s", err.Error())
+		}
+	}
+	return &Resource{Id: data.Id, Version: data.Meta.Version, Attributes: raw}, nil
+}
+
+// translate the SCIM error response into a gRPC status
+func toError(ri *handlers.ResponseInfo) error {
+	body := struct {
+		Detail string `json:"detail"`
+	}{}
+	json.Unmarshal(ri.GetBody(), &body)
+
+	code := codes.Internal
+	switch ri.GetStatus() {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Errorf(code, "%s", body.Detail)
+}
@@ -0,0 +1,318 @@
+package scimtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// Checks a repository of users against the contract of shared.Repository: resources are stored and read back
+// by id, Count and Search evaluate filters, Search sorts and pages, concurrent writes are neither lost nor
+// applied twice, and missing resources and malformed filters are reported as ResourceNotFoundError and
+// InvalidFilterError. Every case starts from an empty repository.
+type RepositoryContract struct {
+	// an empty repository for the internal user schema, i.e. resources/schemas/user_internal.json
+	New func() shared.Repository
+	// whether Get, Update and Delete compare the version argument with meta.version
+	Versioned bool
+}
+
+func (c *RepositoryContract) Run(t *testing.T) {
+	t.Run("CRUD", c.TestCRUD)
+	t.Run("Count", c.TestCount)
+	t.Run("Search", c.TestSearch)
+	t.Run("Versions", c.TestVersions)
+	t.Run("Filters", c.TestFilters)
+	t.Run("Pagination", c.TestPagination)
+	t.Run("Projection", c.TestProjection)
+	t.Run("ConcurrentWrites", c.TestConcurrentWrites)
+	t.Run("Errors", c.TestErrors)
+}
+
+// Check the repository against the whole contract, versions included. For a third party implementation of
+// shared.Repository, i.e. on SQL:
+//
+//	func TestRepository(t *testing.T) {
+//		scimtest.RepositoryTestSuite(t, func() shared.Repository {
+//			return newSQLRepository(t, "../resources/schemas/user_internal.json")
+//		})
+//	}
+func RepositoryTestSuite(t *testing.T, newRepo func() shared.Repository) {
+	(&RepositoryContract{New: newRepo, Versioned: true}).Run(t)
+}
+
+func (c *RepositoryContract) TestCRUD(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	require.Nil(t, repo.Ping(ctx))
+
+	require.Nil(t, repo.Create(contractUser("1", "alice", "v1"), ctx))
+	dp, err := repo.Get("1", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "1", dp.GetId())
+	assert.Equal(t, "alice", dp.GetData()["userName"])
+
+	require.Nil(t, repo.Update("1", "", contractUser("1", "alicia", "v2"), ctx))
+	dp, err = repo.Get("1", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "alicia", dp.GetData()["userName"])
+
+	require.Nil(t, repo.Delete("1", "", ctx))
+	_, err = repo.Get("1", "", ctx)
+	assert.IsType(t, &shared.ResourceNotFoundError{}, err, "get after delete")
+	assert.IsType(t, &shared.ResourceNotFoundError{}, repo.Update("1", "", contractUser("1", "alice", "v3"), ctx), "update after delete")
+	assert.IsType(t, &shared.ResourceNotFoundError{}, repo.Delete("1", "", ctx), "delete after delete")
+}
+
+func (c *RepositoryContract) TestCount(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	for i, userName := range []string{"alice", "bob", "carol"} {
+		require.Nil(t, repo.Create(contractUser(fmt.Sprint(i), userName, "v1"), ctx))
+	}
+
+	for _, test := range []struct {
+		filter string
+		count  int
+	}{
+		{"id pr", 3},
+		{`userName eq "bob"`, 1},
+		{`userName eq "BOB"`, 1},
+		{`userName sw "a" or userName sw "c"`, 2},
+		{`not (userName eq "alice")`, 2},
+		{`userName eq "dave"`, 0},
+	} {
+		n, err := repo.Count(test.filter, ctx)
+		assert.Nil(t, err, test.filter)
+		assert.Equal(t, test.count, n, test.filter)
+	}
+}
+
+func (c *RepositoryContract) TestSearch(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	for i, userName := range []string{"dave", "bob", "erin", "alice", "carol"} {
+		require.Nil(t, repo.Create(contractUser(fmt.Sprint(i), userName, "v1"), ctx))
+	}
+
+	lr, err := repo.Search(shared.SearchRequest{
+		Filter:     "id pr",
+		SortBy:     "userName",
+		SortOrder:  "ascending",
+		StartIndex: 2,
+		Count:      2,
+	}, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 5, lr.TotalResults)
+	require.Len(t, lr.Resources, 2)
+	assert.Equal(t, "bob", lr.Resources[0].GetData()["userName"])
+	assert.Equal(t, "carol", lr.Resources[1].GetData()["userName"])
+
+	lr, err = repo.Search(shared.SearchRequest{
+		Filter:     `userName sw "c" or userName sw "d"`,
+		SortBy:     "userName",
+		SortOrder:  "descending",
+		StartIndex: 1,
+		Count:      10,
+	}, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 2, lr.TotalResults)
+	require.Len(t, lr.Resources, 2)
+	assert.Equal(t, "dave", lr.Resources[0].GetData()["userName"])
+	assert.Equal(t, "carol", lr.Resources[1].GetData()["userName"])
+}
+
+func (c *RepositoryContract) TestVersions(t *testing.T) {
+	if !c.Versioned {
+		t.Skip("the repository ignores versions")
+	}
+	ctx := context.Background()
+	repo := c.New()
+	require.Nil(t, repo.Create(contractUser("1", "alice", "v1"), ctx))
+
+	_, err := repo.Get("1", "v0", ctx)
+	assert.IsType(t, &shared.ResourceNotFoundError{}, err, "get of a stale version")
+	_, err = repo.Get("1", "v1", ctx)
+	assert.Nil(t, err, "get of the current version")
+
+	assert.IsType(t, &shared.ResourceNotFoundError{}, repo.Update("1", "v0", contractUser("1", "alicia", "v2"), ctx), "update of a stale version")
+	require.Nil(t, repo.Update("1", "v1", contractUser("1", "alicia", "v2"), ctx), "update of the current version")
+	assert.IsType(t, &shared.ResourceNotFoundError{}, repo.Delete("1", "v1", ctx), "delete of a stale version")
+	assert.Nil(t, repo.Delete("1", "v2", ctx), "delete of the current version")
+}
+
+func (c *RepositoryContract) TestFilters(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	for i, userName := range []string{"alice", "bob", "carol", "dave"} {
+		user := contractUser(fmt.Sprint(i), userName, "v1")
+		user.Complex["emails"] = []interface{}{
+			map[string]interface{}{"value": userName + "@example.com", "type": "work"},
+			map[string]interface{}{"value": userName + "@home.example.org", "type": "home"},
+		}
+		if i%2 == 0 {
+			user.Complex["displayName"] = userName
+		}
+		require.Nil(t, repo.Create(user, ctx))
+	}
+
+	for _, test := range []struct {
+		filter string
+		count  int
+	}{
+		{`userName co "a"`, 3},
+		{`userName ew "E"`, 2},
+		{`userName ne "alice"`, 3},
+		{`userName gt "bob"`, 2},
+		{`userName ge "bob"`, 3},
+		{`userName lt "carol"`, 2},
+		{`userName le "carol"`, 3},
+		{`displayName pr`, 2},
+		{`not (displayName pr)`, 2},
+		{`emails.value eq "bob@home.example.org"`, 1},
+		{`emails.type eq "work" and emails.value sw "c"`, 1},
+		{`(userName sw "a" or userName sw "b") and displayName pr`, 1},
+		{`id eq "2"`, 1},
+	} {
+		n, err := repo.Count(test.filter, ctx)
+		assert.Nil(t, err, test.filter)
+		assert.Equal(t, test.count, n, test.filter)
+	}
+}
+
+func (c *RepositoryContract) TestPagination(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	for i, userName := range []string{"alice", "bob", "carol"} {
+		require.Nil(t, repo.Create(contractUser(fmt.Sprint(i), userName, "v1"), ctx))
+	}
+
+	for _, test := range []struct {
+		startIndex int
+		count      int
+		userNames  []string
+	}{
+		{1, 10, []string{"alice", "bob", "carol"}},
+		{3, 10, []string{"carol"}},
+		{4, 10, []string{}},
+		{1, 0, []string{}},
+	} {
+		lr, err := repo.Search(shared.SearchRequest{
+			Filter:     "id pr",
+			SortBy:     "userName",
+			StartIndex: test.startIndex,
+			Count:      test.count,
+		}, ctx)
+		require.Nil(t, err)
+		assert.Equal(t, 3, lr.TotalResults, "totalResults counts all matches")
+		userNames := make([]string, 0)
+		for _, dp := range lr.Resources {
+			userNames = append(userNames, fmt.Sprint(dp.GetData()["userName"]))
+		}
+		assert.Equal(t, test.userNames, userNames, "startIndex %d, count %d", test.startIndex, test.count)
+	}
+}
+
+// Projection is the business of the handlers: a repository may return fewer attributes than stored, but not
+// fewer than those asked for, nor the id and meta the handlers rely on.
+func (c *RepositoryContract) TestProjection(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	user := contractUser("1", "alice", "v1")
+	user.Complex["displayName"] = "Alice"
+	require.Nil(t, repo.Create(user, ctx))
+
+	lr, err := repo.Search(shared.SearchRequest{
+		Filter:             `userName eq "alice"`,
+		Attributes:         []string{"displayName"},
+		ExcludedAttributes: []string{"userName"},
+		StartIndex:         1,
+		Count:              10,
+	}, ctx)
+	require.Nil(t, err)
+	require.Len(t, lr.Resources, 1)
+	data := lr.Resources[0].GetData()
+	assert.Equal(t, "1", lr.Resources[0].GetId())
+	assert.Equal(t, "Alice", data["displayName"])
+	meta, _ := data["meta"].(map[string]interface{})
+	assert.Equal(t, "v1", meta["version"])
+}
+
+func (c *RepositoryContract) TestConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	const n = 10
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.Create(contractUser(fmt.Sprint(i), fmt.Sprintf("user%d", i), "v1"), ctx)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		assert.Nil(t, err, "create %d", i)
+	}
+	count, err := repo.Count("id pr", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, n, count, "no create is lost")
+
+	if !c.Versioned {
+		return
+	}
+	// of the updates of the same version, one wins and the others are stale
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.Update("0", "v1", contractUser("0", fmt.Sprintf("winner%d", i), fmt.Sprintf("v2-%d", i)), ctx)
+		}(i)
+	}
+	wg.Wait()
+	winners := 0
+	for _, err := range errs {
+		if err == nil {
+			winners++
+		} else {
+			assert.IsType(t, &shared.ResourceNotFoundError{}, err, "update of a stale version")
+		}
+	}
+	assert.Equal(t, 1, winners, "updates of the same version")
+}
+
+func (c *RepositoryContract) TestErrors(t *testing.T) {
+	ctx := context.Background()
+	repo := c.New()
+	require.Nil(t, repo.Create(contractUser("1", "alice", "v1"), ctx))
+
+	_, err := repo.Get("2", "", ctx)
+	assert.IsType(t, &shared.ResourceNotFoundError{}, err, "get of a missing resource")
+	assert.IsType(t, &shared.ResourceNotFoundError{}, repo.Update("2", "", contractUser("2", "bob", "v1"), ctx), "update of a missing resource")
+	assert.IsType(t, &shared.ResourceNotFoundError{}, repo.Delete("2", "", ctx), "delete of a missing resource")
+
+	for _, filter := range []string{`userName eq`, `userName xx "alice"`, `(userName eq "alice"`} {
+		_, err := repo.Count(filter, ctx)
+		assert.IsType(t, &shared.InvalidFilterError{}, err, "count %s", filter)
+		_, err = repo.Search(shared.SearchRequest{Filter: filter, StartIndex: 1, Count: 10}, ctx)
+		assert.IsType(t, &shared.InvalidFilterError{}, err, "search %s", filter)
+	}
+}
+
+func contractUser(id, userName, version string) *shared.Resource {
+	return &shared.Resource{Complex: shared.Complex{
+		"schemas":  []interface{}{shared.UserUrn},
+		"id":       id,
+		"userName": userName,
+		"meta": map[string]interface{}{
+			"resourceType": shared.UserResourceType,
+			"version":      version,
+		},
+	}}
+}
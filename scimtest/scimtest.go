@@ -0,0 +1,396 @@
+// Package scimtest checks service providers built from this module against RFC 7644, and repositories against
+// the contract of shared.Repository the handlers rely on. Run it from the tests of a custom server or
+// repository, i.e. in CI:
+//
+//	func TestConformance(t *testing.T) {
+//		suite := &scimtest.Suite{Handler: httpadapter.NewRouter(server, httpadapter.WithPrefix("/v2")), Prefix: "/v2"}
+//		suite.Run(t)
+//	}
+//
+// The suite creates users with unique user names and deletes them again, so it can run against a server
+// holding other data.
+package scimtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	errorUrn   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	listUrn    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	patchOpUrn = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	searchUrn  = "urn:ietf:params:scim:api:messages:2.0:SearchRequest"
+)
+
+// Conformance suite for the /Users endpoints of a service provider
+type Suite struct {
+	Handler http.Handler
+	Prefix  string      // path the endpoints are served below, i.e. '/v2'
+	Header  http.Header // sent with every request, i.e. credentials
+	// the body of a valid user with the user name; defaults to the user name alone, servers requiring other
+	// attributes provide them here
+	NewUser func(userName string) map[string]interface{}
+}
+
+// Run every case of the suite as a subtest
+func (s *Suite) Run(t *testing.T) {
+	t.Run("CRUD", s.TestCRUD)
+	t.Run("Filter", s.TestFilter)
+	t.Run("Patch", s.TestPatch)
+	t.Run("Errors", s.TestErrors)
+	t.Run("Pagination", s.TestPagination)
+	t.Run("ETags", s.TestETags)
+}
+
+// Create, read, replace and delete a user (RFC 7644 section 3.3, 3.4.1, 3.5.1 and 3.6)
+func (s *Suite) TestCRUD(t *testing.T) {
+	userName := uniqueName()
+	rw, created := s.do(t, http.MethodPost, "/Users", s.user(userName), nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	assert.True(t, strings.HasPrefix(rw.Header().Get("Content-Type"), shared.ScimMediaType))
+	id, _ := lookup(created, "id").(string)
+	require.NotEmpty(t, id)
+	defer s.delete(t, id)
+
+	assert.Contains(t, lookup(created, "schemas"), shared.UserUrn)
+	assert.Equal(t, userName, lookup(created, "userName"))
+	meta, _ := lookup(created, "meta").(map[string]interface{})
+	assert.Equal(t, shared.UserResourceType, lookup(meta, "resourceType"))
+	assert.NotEmpty(t, lookup(meta, "created"))
+	assert.NotEmpty(t, lookup(meta, "location"))
+	assert.Equal(t, lookup(meta, "location"), rw.Header().Get("Location"))
+
+	rw, fetched := s.do(t, http.MethodGet, "/Users/"+id, nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, id, lookup(fetched, "id"))
+	assert.Equal(t, userName, lookup(fetched, "userName"))
+
+	replacement := s.user(userName)
+	replacement["displayName"] = "Replaced"
+	rw, replaced := s.do(t, http.MethodPut, "/Users/"+id, replacement, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, id, lookup(replaced, "id"))
+	assert.Equal(t, "Replaced", lookup(replaced, "displayName"))
+
+	rw, projected := s.do(t, http.MethodGet, "/Users/"+id+"?attributes=userName", nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, id, lookup(projected, "id"), "id is always returned")
+	assert.Equal(t, userName, lookup(projected, "userName"))
+	assert.Nil(t, lookup(projected, "displayName"), "attributes not requested are left out")
+
+	rw, _ = s.do(t, http.MethodDelete, "/Users/"+id, nil, nil)
+	require.Equal(t, http.StatusNoContent, rw.Code, rw.Body.String())
+	rw, body := s.do(t, http.MethodGet, "/Users/"+id, nil, nil)
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	s.assertError(t, body, http.StatusNotFound, "")
+}
+
+// Filter users with the operators of RFC 7644 section 3.4.2.2, by GET and by POST to .search
+func (s *Suite) TestFilter(t *testing.T) {
+	prefix := uniqueName()
+	for _, suffix := range []string{"a", "b", "c"} {
+		defer s.delete(t, s.create(t, prefix+"-"+suffix))
+	}
+
+	for _, test := range []struct {
+		filter string
+		total  int
+	}{
+		{fmt.Sprintf(`userName eq "%s-b"`, prefix), 1},
+		{fmt.Sprintf(`userName eq "%s-B"`, strings.ToUpper(prefix)), 1},
+		{fmt.Sprintf(`userName sw "%s"`, prefix), 3},
+		{fmt.Sprintf(`userName sw "%s" and not (userName eq "%s-a")`, prefix, prefix), 2},
+		{fmt.Sprintf(`userName eq "%s-a" or userName ew "%s-c"`, prefix, prefix), 2},
+		{fmt.Sprintf(`userName sw "%s" and userName co "-b"`, prefix), 1},
+		{fmt.Sprintf(`userName sw "%s" and userName gt "%s-a"`, prefix, prefix), 2},
+		{fmt.Sprintf(`userName eq "%s-z"`, prefix), 0},
+	} {
+		rw, body := s.do(t, http.MethodGet, "/Users?filter="+url.QueryEscape(test.filter), nil, nil)
+		if assert.Equal(t, http.StatusOK, rw.Code, test.filter) {
+			assert.Contains(t, lookup(body, "schemas"), listUrn, test.filter)
+			assert.EqualValues(t, test.total, lookup(body, "totalResults"), test.filter)
+		}
+	}
+
+	rw, body := s.do(t, http.MethodPost, "/Users/.search", map[string]interface{}{
+		"schemas": []interface{}{searchUrn},
+		"filter":  fmt.Sprintf(`userName sw "%s"`, prefix),
+	}, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.EqualValues(t, 3, lookup(body, "totalResults"))
+
+	rw, body = s.do(t, http.MethodGet, "/Users?filter="+url.QueryEscape("userName eq"), nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	s.assertError(t, body, http.StatusBadRequest, "invalidFilter")
+
+	rw, body = s.do(t, http.MethodPost, "/Users/.search", map[string]interface{}{
+		"schemas": []interface{}{searchUrn},
+		"filter":  "userName eq",
+	}, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	s.assertError(t, body, http.StatusBadRequest, "invalidFilter")
+}
+
+// Add, replace and remove attributes with PATCH (RFC 7644 section 3.5.2)
+func (s *Suite) TestPatch(t *testing.T) {
+	id := s.create(t, uniqueName())
+	defer s.delete(t, id)
+
+	patched := s.patch(t, id, http.StatusOK,
+		map[string]interface{}{"op": "add", "path": "displayName", "value": "Patched"},
+		map[string]interface{}{"op": "add", "path": "emails", "value": []interface{}{
+			map[string]interface{}{"value": "work@example.com", "type": "work"},
+			map[string]interface{}{"value": "home@example.com", "type": "home"},
+		}},
+	)
+	assert.Equal(t, "Patched", lookup(patched, "displayName"))
+	assert.Len(t, lookup(patched, "emails"), 2)
+
+	patched = s.patch(t, id, http.StatusOK,
+		map[string]interface{}{"op": "replace", "path": `emails[type eq "work"].value`, "value": "office@example.com"},
+		map[string]interface{}{"op": "remove", "path": `emails[type eq "home"]`},
+	)
+	emails, _ := lookup(patched, "emails").([]interface{})
+	require.Len(t, emails, 1)
+	assert.Equal(t, "office@example.com", lookup(emails[0].(map[string]interface{}), "value"))
+
+	patched = s.patch(t, id, http.StatusOK, map[string]interface{}{"op": "remove", "path": "displayName"})
+	assert.Nil(t, lookup(patched, "displayName"))
+
+	rw, fetched := s.do(t, http.MethodGet, "/Users/"+id, nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Nil(t, lookup(fetched, "displayName"), "patches are persisted")
+	assert.Len(t, lookup(fetched, "emails"), 1, "patches are persisted")
+
+	s.patch(t, id, http.StatusBadRequest, map[string]interface{}{"op": "rename", "path": "displayName", "value": "x"})
+	s.patch(t, id, http.StatusBadRequest, map[string]interface{}{"op": "add", "path": "unknown(", "value": "x"})
+}
+
+// Error responses of RFC 7644 section 3.12
+func (s *Suite) TestErrors(t *testing.T) {
+	userName := uniqueName()
+	defer s.delete(t, s.create(t, userName))
+
+	rw, body := s.do(t, http.MethodPost, "/Users", s.user(userName), nil)
+	assert.Equal(t, http.StatusConflict, rw.Code, "duplicate userName")
+	s.assertError(t, body, http.StatusConflict, "uniqueness")
+
+	missing := s.user(uniqueName())
+	missing["userName"] = ""
+	rw, body = s.do(t, http.MethodPost, "/Users", missing, nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, "empty userName")
+	s.assertError(t, body, http.StatusBadRequest, "")
+
+	rw, body = s.do(t, http.MethodPost, "/Users", "{", nil)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, "malformed body")
+	s.assertError(t, body, http.StatusBadRequest, "")
+
+	unknown := uniqueName()
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		rw, body = s.do(t, method, "/Users/"+unknown, s.user(unknown), nil)
+		assert.Equal(t, http.StatusNotFound, rw.Code, method+" unknown id")
+		s.assertError(t, body, http.StatusNotFound, "")
+	}
+}
+
+// Paging through sorted results (RFC 7644 section 3.4.2.3 and 3.4.2.4)
+func (s *Suite) TestPagination(t *testing.T) {
+	prefix := uniqueName()
+	for i := 0; i < 5; i++ {
+		defer s.delete(t, s.create(t, fmt.Sprintf("%s-%d", prefix, i)))
+	}
+	filter := url.QueryEscape(fmt.Sprintf(`userName sw "%s"`, prefix))
+
+	rw, body := s.do(t, http.MethodGet, "/Users?sortBy=userName&startIndex=2&count=2&filter="+filter, nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.EqualValues(t, 5, lookup(body, "totalResults"))
+	assert.EqualValues(t, 2, lookup(body, "itemsPerPage"))
+	assert.EqualValues(t, 2, lookup(body, "startIndex"))
+	resources, _ := lookup(body, "Resources").([]interface{})
+	require.Len(t, resources, 2)
+	assert.Equal(t, prefix+"-1", lookup(resources[0].(map[string]interface{}), "userName"))
+	assert.Equal(t, prefix+"-2", lookup(resources[1].(map[string]interface{}), "userName"))
+
+	rw, body = s.do(t, http.MethodGet, "/Users?sortBy=userName&sortOrder=descending&count=1&filter="+filter, nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	resources, _ = lookup(body, "Resources").([]interface{})
+	require.Len(t, resources, 1)
+	assert.Equal(t, prefix+"-4", lookup(resources[0].(map[string]interface{}), "userName"))
+
+	rw, body = s.do(t, http.MethodGet, "/Users?startIndex=5&count=10&filter="+filter, nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Len(t, lookup(body, "Resources"), 1, "the last page holds what is left")
+
+	rw, body = s.do(t, http.MethodGet, "/Users?count=0&filter="+filter, nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.EqualValues(t, 5, lookup(body, "totalResults"), "count=0 only reports the total")
+	assert.Empty(t, lookup(body, "Resources"), "count=0 only reports the total")
+}
+
+// Versioned reads and conditional writes (RFC 7644 section 3.14), skipped when the service provider
+// configuration reports ETags as unsupported
+func (s *Suite) TestETags(t *testing.T) {
+	rw, config := s.do(t, http.MethodGet, "/ServiceProviderConfig", nil, nil)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	if etag, _ := lookup(config, "etag").(map[string]interface{}); lookup(etag, "supported") != true {
+		t.Skip("etag is not supported")
+	}
+
+	userName := uniqueName()
+	rw, created := s.do(t, http.MethodPost, "/Users", s.user(userName), nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id, _ := lookup(created, "id").(string)
+	defer s.delete(t, id)
+	version := rw.Header().Get("ETag")
+	require.NotEmpty(t, version)
+	assert.Equal(t, version, lookup(lookup(created, "meta").(map[string]interface{}), "version"))
+
+	rw, _ = s.do(t, http.MethodGet, "/Users/"+id, nil, map[string]string{"If-None-Match": version})
+	assert.Equal(t, http.StatusNotModified, rw.Code, "If-None-Match of the current version")
+
+	replacement := s.user(userName)
+	replacement["displayName"] = "Versioned"
+	rw, body := s.do(t, http.MethodPut, "/Users/"+id, replacement, map[string]string{"If-Match": "W/\"stale\""})
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code, "If-Match of a stale version")
+	s.assertError(t, body, http.StatusPreconditionFailed, "")
+
+	rw, _ = s.do(t, http.MethodPut, "/Users/"+id, replacement, map[string]string{"If-Match": version})
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	newVersion := rw.Header().Get("ETag")
+	assert.NotEmpty(t, newVersion)
+
+	rw, _ = s.do(t, http.MethodDelete, "/Users/"+id, nil, map[string]string{"If-Match": "W/\"stale\""})
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code, "delete with a stale version")
+	rw, _ = s.do(t, http.MethodDelete, "/Users/"+id, nil, map[string]string{"If-Match": newVersion})
+	assert.Equal(t, http.StatusNoContent, rw.Code, "delete with the current version")
+}
+
+func (s *Suite) user(userName string) map[string]interface{} {
+	if s.NewUser != nil {
+		return s.NewUser(userName)
+	}
+	return map[string]interface{}{
+		"schemas":  []interface{}{shared.UserUrn},
+		"userName": userName,
+	}
+}
+
+func (s *Suite) create(t *testing.T, userName string) string {
+	rw, body := s.do(t, http.MethodPost, "/Users", s.user(userName), nil)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id, _ := lookup(body, "id").(string)
+	require.NotEmpty(t, id)
+	return id
+}
+
+// remove a user the case created, whether or not the case got to delete it itself
+func (s *Suite) delete(t *testing.T, id string) {
+	if rw, _ := s.do(t, http.MethodDelete, "/Users/"+id, nil, nil); rw.Code != http.StatusNoContent && rw.Code != http.StatusNotFound {
+		t.Errorf("cleaning up user %s: status %d", id, rw.Code)
+	}
+}
+
+func (s *Suite) patch(t *testing.T, id string, status int, ops ...map[string]interface{}) map[string]interface{} {
+	operations := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		operations = append(operations, op)
+	}
+	rw, body := s.do(t, http.MethodPatch, "/Users/"+id, map[string]interface{}{
+		"schemas":    []interface{}{patchOpUrn},
+		"Operations": operations,
+	}, nil)
+	require.Equal(t, status, rw.Code, rw.Body.String())
+	if status == http.StatusOK {
+		return body
+	}
+	s.assertError(t, body, status, "")
+	return nil
+}
+
+// send the request below the prefix of the suite, with the headers of the suite, and decode the JSON body, if any
+func (s *Suite) do(t *testing.T, method, path string, body interface{}, header map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	rw := serve(t, s.Handler, method, s.Prefix+path, body, s.Header, header)
+	decoded := make(map[string]interface{})
+	if rw.Body.Len() > 0 && rw.Code != http.StatusNotModified {
+		assert.Nil(t, json.Unmarshal(rw.Body.Bytes(), &decoded), "%s %s responded with invalid JSON: %s", method, path, rw.Body.String())
+	}
+	return rw, decoded
+}
+
+// Send a request to the handler, for the tests of servers built from this module. A string body is sent as is,
+// other bodies are encoded as JSON.
+//
+//	rw := scimtest.Serve(t, server.Handler(), http.MethodGet, "/v2/Users/"+id, nil, nil)
+//	user := scimtest.Decode(t, rw)
+func Serve(t *testing.T, handler http.Handler, method, target string, body interface{}, header map[string]string) *httptest.ResponseRecorder {
+	return serve(t, handler, method, target, body, nil, header)
+}
+
+// Decode the JSON body of the response, failing the test if it is not JSON
+func Decode(t *testing.T, rw *httptest.ResponseRecorder) map[string]interface{} {
+	decoded := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal(rw.Body.Bytes(), &decoded), rw.Body.String())
+	return decoded
+}
+
+func serve(t *testing.T, handler http.Handler, method, target string, body interface{}, defaults http.Header, header map[string]string) *httptest.ResponseRecorder {
+	var raw []byte
+	switch b := body.(type) {
+	case nil:
+	case string:
+		raw = []byte(b)
+	default:
+		var err error
+		raw, err = json.Marshal(b)
+		require.Nil(t, err)
+	}
+
+	req := httptest.NewRequest(method, target, bytes.NewReader(raw))
+	for name, values := range defaults {
+		req.Header[name] = values
+	}
+	if len(raw) > 0 {
+		req.Header.Set("Content-Type", shared.ScimMediaType)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+// an error response carries the error schema and the status, and the scimType when one is expected
+func (s *Suite) assertError(t *testing.T, body map[string]interface{}, status int, scimType string) {
+	assert.Contains(t, lookup(body, "schemas"), errorUrn)
+	assert.Equal(t, fmt.Sprint(status), fmt.Sprint(lookup(body, "status")))
+	if len(scimType) > 0 {
+		assert.Equal(t, scimType, lookup(body, "scimType"))
+	}
+}
+
+// the value of the attribute; attribute names are case insensitive (RFC 7643 section 2.1)
+func lookup(m map[string]interface{}, name string) interface{} {
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// no dashes, so that filters on the "-a", "-b" and "-c" suffixes match the suffixes only
+func uniqueName() string {
+	return "scimtest" + strings.Replace(uuid.NewV4().String(), "-", "", -1)[:12]
+}
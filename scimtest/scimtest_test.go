@@ -0,0 +1,412 @@
+package scimtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/davidiamyou/go-scim/handlers"
+	"github.com/davidiamyou/go-scim/httpadapter"
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	suite := &Suite{Handler: httpadapter.NewRouter(newTestServer(t), httpadapter.WithPrefix("/v2")), Prefix: "/v2"}
+	suite.Run(t)
+}
+
+func TestRepositoryContract(t *testing.T) {
+	sch, _, err := shared.ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	RepositoryTestSuite(t, func() shared.Repository {
+		return &versionedRepository{repo: shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{})}
+	})
+	RepositoryTestSuite(t, func() shared.Repository {
+		return &versionedRepository{repo: shared.NewCompactRepository(shared.NewSearchableMapRepository(sch, map[string]shared.DataProvider{}), sch)}
+	})
+}
+
+func TestSCIM11(t *testing.T) {
+	server := newTestServer(t)
+	server.baseURL = shared.NewStaticBaseURL("http://localhost/v2")
+	handler := httpadapter.NewSCIM11Handler(server, httpadapter.WithPrefix("/v1"))
+
+	do := func(method, target string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		data := make(map[string]interface{})
+		require.Nil(t, json.Unmarshal(rw.Body.Bytes(), &data), rw.Body.String())
+		return rw, data
+	}
+
+	rw, user := do(http.MethodPost, "/v1/Users", `{
+		"schemas": ["urn:scim:schemas:core:1.0"],
+		"userName": "bjensen",
+		"name": {"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": [{"value": "bjensen@example.com", "type": "work"}]
+	}`)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+	id := user["id"].(string)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "http://localhost/v1/Users/"+id, rw.Header().Get("Location"))
+	assert.Equal(t, []interface{}{shared.Core11Urn}, user["schemas"])
+	meta := user["meta"].(map[string]interface{})
+	assert.Equal(t, "http://localhost/v1/Users/"+id, meta["location"])
+	assert.Nil(t, meta["resourceType"])
+
+	rw, user = do(http.MethodPatch, "/v1/Users/"+id, `{
+		"schemas": ["urn:scim:schemas:core:1.0"],
+		"name": {"givenName": "Babs"},
+		"emails": [
+			{"value": "bjensen@example.com", "operation": "delete"},
+			{"value": "babs@example.com", "type": "home"}
+		],
+		"meta": {"attributes": ["nickName"]}
+	}`)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+	assert.Equal(t, map[string]interface{}{"givenName": "Babs", "familyName": "Jensen"}, user["name"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "babs@example.com", "type": "home"}}, user["emails"])
+
+	rw, list := do(http.MethodGet, "/v1/Users?filter="+url.QueryEscape(`userName eq "bjensen"`), "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, []interface{}{shared.Core11Urn}, list["schemas"])
+	assert.Equal(t, float64(1), list["totalResults"])
+
+	rw, errs := do(http.MethodGet, "/v1/Users/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	require.Len(t, errs["Errors"], 1)
+	assert.Equal(t, "404", errs["Errors"].([]interface{})[0].(map[string]interface{})["code"])
+
+	rw, spConfig := do(http.MethodGet, "/v1/ServiceProviderConfigs", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, []interface{}{shared.Core11Urn}, spConfig["schemas"])
+
+	rw, _ = do(http.MethodPost, "/v1/Bulk", "{}")
+	assert.Equal(t, http.StatusNotImplemented, rw.Code)
+
+	// bodies are limited before they are translated
+	rw, errs = do(http.MethodPost, "/v1/Users", `{"userName": "`+strings.Repeat("x", 1<<20)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+	assert.Equal(t, "413", errs["Errors"].([]interface{})[0].(map[string]interface{})["code"])
+}
+
+func TestPipelines(t *testing.T) {
+	pipeline := handlers.DefaultPipeline(handlers.CreateOperation).
+		Remove(handlers.StageDetectReplay, handlers.StageValidateUniqueness).
+		InsertBefore(handlers.StageValidateRequired, handlers.NewStage("rejectAdmin", func(server handlers.ScimServer, subj *handlers.Subject, ctx context.Context) error {
+			if subj.Resource.Complex["userName"] == "admin" {
+				return shared.Error.InvalidParam("userName", "other than admin", "admin")
+			}
+			return nil
+		}))
+	assert.Equal(t, []string{
+		"transform", "validateType", "validateValues", "correctCase", "enforcePrimary", "authorize", "defaults",
+		"rejectAdmin", "validateRequired", "assignReadOnlyValue",
+	}, pipeline.Names())
+
+	server := newTestServer(t)
+	server.pipelines = handlers.NewPipelines().Set(shared.UserResourceType, handlers.CreateOperation, pipeline)
+	assert.True(t, server.pipelines.Has(shared.UserResourceType, handlers.CreateOperation))
+	assert.False(t, server.pipelines.Has(shared.GroupResourceType, handlers.CreateOperation))
+	handler := httpadapter.NewRouter(server, httpadapter.WithPrefix("/v2"))
+
+	create := func(resourceType, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v2/"+resourceType, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/scim+json")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	user := func(userName string) string {
+		return `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"` + userName + `"}`
+	}
+	group := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:Group"],"displayName":"admins"}`
+
+	// uniqueness is left to the directory mirrored
+	assert.Equal(t, http.StatusCreated, create("Users", user("bjensen")))
+	assert.Equal(t, http.StatusCreated, create("Users", user("bjensen")))
+	assert.Equal(t, http.StatusBadRequest, create("Users", user("admin")))
+	assert.Equal(t, http.StatusCreated, create("Groups", group))
+}
+
+func TestResponseHooks(t *testing.T) {
+	// a wrapper adding rate limit headers to every response, error responses included
+	handler := httpadapter.NewRouter(newTestServer(t), httpadapter.WithPrefix("/v2"), httpadapter.WithWrapper(
+		func(handler handlers.EndpointHandler, requestType int) handlers.EndpointHandler {
+			return handlers.Chain(func(r shared.WebRequest, server handlers.ScimServer, ctx context.Context) *handlers.ResponseInfo {
+				assert.True(t, handlers.OnResponse(ctx, func(ri *handlers.ResponseInfo) {
+					ri.AddHeader("X-RateLimit", "limit=100").AddHeader("X-RateLimit", "remaining=99")
+					ri.Trailer("X-Checksum", fmt.Sprintf("%d", len(ri.GetBody())))
+				}))
+				return handler(r, server, ctx)
+			}, requestType)
+		}))
+	assert.False(t, handlers.OnResponse(context.Background(), func(ri *handlers.ResponseInfo) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/Users/missing", nil)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	assert.Equal(t, []string{"limit=100", "remaining=99"}, rw.Header()["X-Ratelimit"])
+	assert.Equal(t, "X-Checksum", rw.Header().Get("Trailer"))
+	assert.Equal(t, fmt.Sprintf("%d", rw.Body.Len()), rw.Result().Trailer.Get("X-Checksum"))
+}
+
+func TestNilMetrics(t *testing.T) {
+	// servers without metrics are instrumented alike
+	server := newTestServer(t)
+	server.metrics = nil
+	handler := httpadapter.NewRouter(server, httpadapter.WithPrefix("/v2"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/Users?filter="+url.QueryEscape("userName zz"), nil)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code, rw.Body.String())
+}
+
+// the map repository, made safe for concurrent use and checking versions
+type versionedRepository struct {
+	sync.Mutex
+	repo shared.Repository
+}
+
+func (r *versionedRepository) check(id, version string, ctx context.Context) error {
+	dp, err := r.repo.Get(id, version, ctx)
+	if err != nil || len(version) == 0 {
+		return err
+	}
+	if meta, _ := dp.GetData()["meta"].(map[string]interface{}); meta["version"] != version {
+		return shared.Error.ResourceNotFound(id, version)
+	}
+	return nil
+}
+
+func (r *versionedRepository) Create(provider shared.DataProvider, ctx context.Context) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.repo.Create(provider, ctx)
+}
+
+func (r *versionedRepository) Get(id, version string, ctx context.Context) (shared.DataProvider, error) {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.check(id, version, ctx); err != nil {
+		return nil, err
+	}
+	return r.repo.Get(id, version, ctx)
+}
+
+func (r *versionedRepository) GetAll(ctx context.Context) ([]shared.Complex, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.repo.GetAll(ctx)
+}
+
+func (r *versionedRepository) Count(query string, ctx context.Context) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.repo.Count(query, ctx)
+}
+
+func (r *versionedRepository) Update(id, version string, provider shared.DataProvider, ctx context.Context) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.check(id, version, ctx); err != nil {
+		return err
+	}
+	return r.repo.Update(id, version, provider, ctx)
+}
+
+func (r *versionedRepository) Delete(id, version string, ctx context.Context) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.check(id, version, ctx); err != nil {
+		return err
+	}
+	return r.repo.Delete(id, version, ctx)
+}
+
+func (r *versionedRepository) Search(payload shared.SearchRequest, ctx context.Context) (*shared.ListResponse, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.repo.Search(payload, ctx)
+}
+
+func (r *versionedRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+// a server over in memory repositories, configured like the example server
+type testServer struct {
+	properties          mapPropertySource
+	schemas             *shared.SchemaRegistry
+	internalSchemas     map[string]*shared.Schema
+	repos               map[string]shared.Repository
+	metrics             *shared.Metrics
+	hooks               *shared.Hooks
+	userMetaAssignment  shared.ReadOnlyAssignment
+	groupMetaAssignment shared.ReadOnlyAssignment
+	groupAssignment     shared.ReadOnlyAssignment
+	baseURL             shared.BaseURLProvider
+	pipelines           *handlers.Pipelines
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ss := &testServer{
+		properties: mapPropertySource{
+			"scim.resources.user.locationBase":      "http://localhost/v2/Users",
+			"scim.resources.group.locationBase":     "http://localhost/v2/Groups",
+			"scim.resources.operation.locationBase": "http://localhost/v2/Operations",
+			"scim.protocol.itemsPerPage":            10,
+			"scim.protocol.uri.user":                "/Users",
+			"scim.protocol.uri.group":               "/Groups",
+			"scim.protocol.unknownAttributes":       shared.RejectUnknownAttributes,
+			"scim.protocol.replace":                 shared.StrictReplace,
+			"scim.protocol.requestTimeout":          30,
+			"scim.protocol.maxRequestBytes":         1 << 20,
+			"scim.protocol.duplicateCreate":         shared.ConflictOnDuplicate,
+		},
+		schemas:         shared.NewSchemaRegistry(),
+		internalSchemas: make(map[string]*shared.Schema),
+		repos:           make(map[string]shared.Repository),
+		metrics:         shared.NewMetrics(nil),
+		hooks:           shared.NewHooks(),
+	}
+
+	for id, path := range map[string]string{
+		"":              "../resources/schemas/root_internal.json",
+		shared.UserUrn:  "../resources/schemas/user_internal.json",
+		shared.GroupUrn: "../resources/schemas/group_internal.json",
+	} {
+		sch, _, err := shared.ParseSchema(path)
+		require.Nil(t, err)
+		ss.internalSchemas[id] = sch
+	}
+	for _, path := range []string{"../resources/schemas/user.json", "../resources/schemas/group.json"} {
+		_, err := ss.schemas.LoadFile(path)
+		require.Nil(t, err)
+	}
+
+	resourceTypes := make(map[string]shared.DataProvider)
+	for _, path := range []string{"../resources/resource_types/user.json", "../resources/resource_types/group.json"} {
+		rt, _, err := shared.ParseResource(path)
+		require.Nil(t, err)
+		resourceTypes[rt.GetId()] = rt
+	}
+	spConfig, _, err := shared.ParseResource("../resources/sp_config/sp_config.json")
+	require.Nil(t, err)
+
+	userRepo := &versionedRepository{repo: shared.NewSearchableMapRepository(ss.internalSchemas[shared.UserUrn], map[string]shared.DataProvider{})}
+	groupRepo := &versionedRepository{repo: shared.NewSearchableMapRepository(ss.internalSchemas[shared.GroupUrn], map[string]shared.DataProvider{})}
+	ss.repos[shared.UserResourceType] = userRepo
+	ss.repos[shared.GroupResourceType] = groupRepo
+	ss.repos[shared.ResourceTypeResourceType] = shared.NewMapRepository(resourceTypes)
+	ss.repos[shared.ServiceProviderConfigResourceType] = shared.NewMapRepository(map[string]shared.DataProvider{"": spConfig})
+
+	ss.userMetaAssignment = shared.NewMetaAssignment(ss.properties, shared.UserResourceType)
+	ss.groupMetaAssignment = shared.NewMetaAssignment(ss.properties, shared.GroupResourceType)
+	ss.groupAssignment = shared.NewGroupAssignment(groupRepo)
+	return ss
+}
+
+func (ss *testServer) Property() shared.PropertySource { return ss.properties }
+func (ss *testServer) Logger() shared.Logger           { return shared.NewNoOpLogger() }
+func (ss *testServer) Metrics() *shared.Metrics        { return ss.metrics }
+func (ss *testServer) Tracer() shared.Tracer           { return shared.NewNoOpTracer() }
+func (ss *testServer) RateLimiter() shared.RateLimiter { return shared.NewUnlimitedRateLimiter() }
+func (ss *testServer) AccessController() shared.AccessController {
+	return shared.NewUnrestrictedAccessController()
+}
+func (ss *testServer) OperationQueue() shared.OperationQueue      { return nil }
+func (ss *testServer) OperationStore() shared.OperationStore      { return nil }
+func (ss *testServer) ExportStore() shared.ExportStore            { return nil }
+func (ss *testServer) GroupDisplayWorkers() *shared.WorkerPool    { return nil }
+func (ss *testServer) Hooks() *shared.Hooks                       { return ss.hooks }
+func (ss *testServer) Transformers() *shared.Transformers         { return nil }
+func (ss *testServer) Validators() *shared.Validators             { return nil }
+func (ss *testServer) Messages() *shared.MessageCatalog           { return nil }
+func (ss *testServer) Defaults() *shared.Defaults                 { return nil }
+func (ss *testServer) Pipelines() *handlers.Pipelines             { return ss.pipelines }
+func (ss *testServer) IdempotencyCache() shared.IdempotencyCache  { return nil }
+func (ss *testServer) Journal() shared.Journal                    { return nil }
+func (ss *testServer) ChangeLog() shared.ChangeLog                { return nil }
+func (ss *testServer) AttributeUsage() *shared.AttributeUsage     { return nil }
+func (ss *testServer) OperationToggles() *shared.OperationToggles { return nil }
+func (ss *testServer) BaseURL() shared.BaseURLProvider            { return ss.baseURL }
+func (ss *testServer) WebRequest(r *http.Request) shared.WebRequest {
+	return httpadapter.NewWebRequest(r)
+}
+func (ss *testServer) Schemas() *shared.SchemaRegistry         { return ss.schemas }
+func (ss *testServer) InternalSchema(id string) *shared.Schema { return ss.internalSchemas[id] }
+func (ss *testServer) CorrectCase(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.CorrectCase(subj, sch, ctx)
+}
+func (ss *testServer) CheckUnknownAttributes(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.CheckUnknownAttributes(subj, sch, ss.properties.GetString("scim.protocol.unknownAttributes"), ctx)
+}
+func (ss *testServer) ApplyReplacePolicy(subj *shared.Resource, ref *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ApplyReplacePolicy(subj, ref, sch, ss.properties.GetString("scim.protocol.replace"), ctx)
+}
+func (ss *testServer) ApplyPatch(patch shared.Patch, subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ApplyPatch(patch, subj, sch, ctx)
+}
+func (ss *testServer) ValidateType(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ValidateType(subj, sch, ctx)
+}
+func (ss *testServer) ValidateRequired(subj *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ValidateRequired(subj, sch, ctx)
+}
+func (ss *testServer) ValidateMutability(subj *shared.Resource, ref *shared.Resource, sch *shared.Schema, ctx context.Context) error {
+	return shared.ValidateMutability(subj, ref, sch, ctx)
+}
+func (ss *testServer) ValidateUniqueness(subj *shared.Resource, sch *shared.Schema, repo shared.Repository, ctx context.Context) error {
+	return shared.ValidateUniqueness(subj, sch, repo, nil, ctx)
+}
+func (ss *testServer) ValidateUniquenessBatch(subjs []*shared.Resource, sch *shared.Schema, repo shared.Repository, ctx context.Context) []error {
+	return shared.ValidateUniquenessBatch(subjs, sch, repo, nil, 0, 0, ctx)
+}
+func (ss *testServer) AssignReadOnlyValue(r *shared.Resource, ctx context.Context) error {
+	assignments := map[int][]shared.ReadOnlyAssignment{
+		shared.CreateUser:         {shared.NewIdAssignment(), ss.userMetaAssignment, ss.groupAssignment},
+		shared.ReplaceUser:        {ss.userMetaAssignment, ss.groupAssignment},
+		shared.PatchUser:          {ss.userMetaAssignment, ss.groupAssignment},
+		shared.ChangeUserPassword: {ss.userMetaAssignment},
+		shared.CreateGroup:        {shared.NewIdAssignment(), ss.groupMetaAssignment},
+		shared.ReplaceGroup:       {ss.groupMetaAssignment},
+		shared.PatchGroup:         {ss.groupMetaAssignment},
+	}
+	for _, assignment := range assignments[ctx.Value(shared.RequestType{}).(int)] {
+		if err := assignment.AssignValue(r, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (ss *testServer) MarshalJSON(v interface{}, sch *shared.Schema, attributes []string, excludedAttributes []string) ([]byte, error) {
+	return shared.MarshalJSON(v, sch, attributes, excludedAttributes)
+}
+func (ss *testServer) Repository(identifier string) shared.Repository {
+	if repo, ok := ss.repos[identifier]; ok {
+		return repo
+	}
+	panic(shared.Error.Text("no repo matches identifier %s", identifier))
+}
+
+var _ handlers.ScimServer = &testServer{}
+
+type mapPropertySource map[string]interface{}
+
+func (mps mapPropertySource) Get(key string) interface{}  { return mps[key] }
+func (mps mapPropertySource) GetString(key string) string { s, _ := mps[key].(string); return s }
+func (mps mapPropertySource) GetInt(key string) int       { i, _ := mps[key].(int); return i }
+func (mps mapPropertySource) GetBool(key string) bool     { b, _ := mps[key].(bool); return b }
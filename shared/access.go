@@ -0,0 +1,298 @@
+package shared
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// Decides which attribute paths of a resource type the caller of a request may read and write.
+// Paths are relative to the resource, i.e. 'name.familyName' or the URN of an extension namespace.
+type AccessController interface {
+	CanRead(resourceType, path string, ctx context.Context) bool
+	CanWrite(resourceType, path string, ctx context.Context) bool
+}
+
+// Returns an access controller that grants everything
+func NewUnrestrictedAccessController() AccessController {
+	return unrestrictedAccessController{}
+}
+
+type unrestrictedAccessController struct{}
+
+func (c unrestrictedAccessController) CanRead(resourceType, path string, ctx context.Context) bool {
+	return true
+}
+func (c unrestrictedAccessController) CanWrite(resourceType, path string, ctx context.Context) bool {
+	return true
+}
+
+// Grants read and write access on attribute paths of a resource type to principals or scopes.
+// A granted path covers all of its sub attributes; '*' grants every path, principal, scope or
+// resource type. Writable paths are readable as well.
+type AccessPolicy struct {
+	Principals   []string
+	Scopes       []string
+	ResourceType string
+	Readable     []string
+	Writable     []string
+}
+
+// Returns an access controller that denies everything not granted by one of the policies.
+// The caller is identified by the Principal and Scopes context values.
+func NewPolicyAccessController(policies ...AccessPolicy) AccessController {
+	return &policyAccessController{policies: policies}
+}
+
+type policyAccessController struct {
+	policies []AccessPolicy
+}
+
+func (c *policyAccessController) CanRead(resourceType, path string, ctx context.Context) bool {
+	for _, policy := range c.applicable(resourceType, ctx) {
+		if grantsPath(policy.Readable, path) || grantsPath(policy.Writable, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *policyAccessController) CanWrite(resourceType, path string, ctx context.Context) bool {
+	for _, policy := range c.applicable(resourceType, ctx) {
+		if grantsPath(policy.Writable, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *policyAccessController) applicable(resourceType string, ctx context.Context) []AccessPolicy {
+	principal, _ := ctx.Value(Principal{}).(string)
+	scopes, _ := ctx.Value(Scopes{}).([]string)
+
+	policies := make([]AccessPolicy, 0)
+	for _, policy := range c.policies {
+		if policy.ResourceType != "*" && policy.ResourceType != resourceType {
+			continue
+		}
+		if containsOrWildcard(policy.Principals, principal) {
+			policies = append(policies, policy)
+			continue
+		}
+		for _, scope := range scopes {
+			if containsOrWildcard(policy.Scopes, scope) {
+				policies = append(policies, policy)
+				break
+			}
+		}
+	}
+	return policies
+}
+
+func containsOrWildcard(candidates []string, value string) bool {
+	for _, candidate := range candidates {
+		if candidate == "*" || (len(value) > 0 && candidate == value) {
+			return true
+		}
+	}
+	return false
+}
+
+func grantsPath(granted []string, path string) bool {
+	path = strings.ToLower(path)
+	for _, g := range granted {
+		g = strings.ToLower(g)
+		if g == "*" || g == path || strings.HasPrefix(path, g+".") || strings.HasPrefix(path, g+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the resource without the attributes the caller may not read.
+// id and schemas are always kept.
+func RedactUnreadable(dp DataProvider, sch *Schema, ac AccessController, ctx context.Context) DataProvider {
+	resourceType := resourceTypeOf(dp, ctx)
+	redacted := redactComplex(dp.GetData(), sch.ToAttribute(), "", func(path string, _ *Attribute) bool {
+		switch path {
+		case "id", "schemas":
+			return true
+		}
+		return ac.CanRead(resourceType, path, ctx)
+	})
+	return &Resource{Complex: Complex(redacted)}
+}
+
+// Copies what canRead grants of the data. Keys the guide does not define, i.e. extension namespaces missing
+// from the schema, are asked for by their key with a nil attribute and kept or dropped as a whole.
+func redactComplex(data map[string]interface{}, guide *Attribute, prefix string, canRead func(path string, attr *Attribute) bool) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		attr := guide.SubAttribute(k)
+		if attr == nil {
+			if canRead(joinAttributePath(prefix, k), nil) {
+				copied[k] = v
+			}
+			continue
+		}
+
+		path := joinAttributePath(prefix, attr.Name)
+		if canRead(path, attr) {
+			copied[k] = v
+			continue
+		}
+		if attr.Type != TypeComplex {
+			continue
+		}
+
+		switch v.(type) {
+		case map[string]interface{}:
+			if sub := redactComplex(v.(map[string]interface{}), attr, path, canRead); len(sub) > 0 {
+				copied[k] = sub
+			}
+		case []interface{}:
+			elems := make([]interface{}, 0)
+			for _, elem := range v.([]interface{}) {
+				if m, ok := elem.(map[string]interface{}); ok {
+					if sub := redactComplex(m, attr, path, canRead); len(sub) > 0 {
+						elems = append(elems, sub)
+					}
+				}
+			}
+			if len(elems) > 0 {
+				copied[k] = elems
+			}
+		}
+	}
+	return copied
+}
+
+// Verify that the resource only changes attributes the caller may write, compared to the reference.
+// Attributes the caller may not write but that are absent from the resource are carried over from
+// the reference, so that a replace does not clear what the caller could not see. A nil reference
+// denotes a resource being created. Read only attributes are left to the mutability validation.
+func ValidateWritable(subj *Resource, ref *Resource, sch *Schema, ac AccessController, ctx context.Context) error {
+	resourceType := resourceTypeOf(subj, ctx)
+	var refData map[string]interface{}
+	if ref != nil {
+		refData = ref.Complex
+	}
+	return validateWritableComplex(subj.Complex, refData, sch.ToAttribute(), "", func(path string) bool {
+		return ac.CanWrite(resourceType, path, ctx)
+	})
+}
+
+func validateWritableComplex(data, ref map[string]interface{}, guide *Attribute, prefix string, canWrite func(path string) bool) error {
+	for _, attr := range guide.SubAttributes {
+		if attr.Mutability == ReadOnly {
+			continue
+		}
+
+		path := joinAttributePath(prefix, attr.Name)
+		switch path {
+		case "id", "schemas":
+			continue
+		}
+		if canWrite(path) {
+			continue
+		}
+
+		k, v, present := entryByName(data, attr.Name)
+		_, refV, refPresent := entryByName(ref, attr.Name)
+
+		if !present {
+			if refPresent && data != nil {
+				data[attr.Name] = refV
+			}
+			continue
+		}
+
+		if attr.Type == TypeComplex && !attr.MultiValued {
+			if m, ok := v.(map[string]interface{}); ok {
+				refM, _ := refV.(map[string]interface{})
+				if err := validateWritableComplex(m, refM, attr, path, canWrite); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if !refPresent || !reflect.DeepEqual(v, refV) {
+			return Error.Forbidden(joinAttributePath(prefix, k))
+		}
+	}
+
+	// keys the guide does not define are judged by their key, as a whole
+	for k, v := range data {
+		if guide.SubAttribute(k) != nil || canWrite(joinAttributePath(prefix, k)) {
+			continue
+		}
+		if refV, ok := ref[k]; !ok || !reflect.DeepEqual(v, refV) {
+			return Error.Forbidden(joinAttributePath(prefix, k))
+		}
+	}
+	for k, refV := range ref {
+		if _, present := data[k]; present || data == nil || guide.SubAttribute(k) != nil {
+			continue
+		}
+		if !canWrite(joinAttributePath(prefix, k)) {
+			data[k] = refV
+		}
+	}
+	return nil
+}
+
+// Verify that the patch only targets attributes the caller may write
+func ValidatePatchWritable(patch Patch, resourceType string, ac AccessController, ctx context.Context) error {
+	if len(patch.Path) > 0 {
+		path := stripPathFilter(patch.Path)
+		if !ac.CanWrite(resourceType, path, ctx) {
+			return Error.Forbidden(path)
+		}
+		return nil
+	}
+
+	if m, ok := patch.Value.(map[string]interface{}); ok {
+		for k := range m {
+			if !ac.CanWrite(resourceType, stripPathFilter(k), ctx) {
+				return Error.Forbidden(k)
+			}
+		}
+	}
+	return nil
+}
+
+// emails[type eq "work"].value becomes emails.value
+func stripPathFilter(path string) string {
+	for {
+		start := strings.Index(path, "[")
+		if start < 0 {
+			return path
+		}
+		end := strings.Index(path[start:], "]")
+		if end < 0 {
+			return path[:start]
+		}
+		path = path[:start] + path[start+end+1:]
+	}
+}
+
+func resourceTypeOf(dp DataProvider, ctx context.Context) string {
+	if meta, ok := dp.GetData()["meta"].(map[string]interface{}); ok {
+		if resourceType, ok := meta["resourceType"].(string); ok && len(resourceType) > 0 {
+			return resourceType
+		}
+	}
+	if requestType, ok := ctx.Value(RequestType{}).(int); ok {
+		resourceType, _ := DescribeRequestType(requestType)
+		return resourceType
+	}
+	return ""
+}
+
+func joinAttributePath(prefix, name string) string {
+	if len(prefix) == 0 {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,189 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPolicyAccessController(t *testing.T) {
+	ac := NewPolicyAccessController(
+		AccessPolicy{
+			Scopes:       []string{"hr"},
+			ResourceType: UserResourceType,
+			Writable:     []string{"name", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"},
+		},
+		AccessPolicy{
+			Principals:   []string{"helpdesk"},
+			ResourceType: "*",
+			Readable:     []string{"*"},
+		},
+	)
+
+	hr := context.WithValue(context.Background(), Scopes{}, []string{"hr"})
+	assert.True(t, ac.CanWrite(UserResourceType, "name.familyName", hr))
+	assert.True(t, ac.CanRead(UserResourceType, "name", hr))
+	assert.True(t, ac.CanWrite(UserResourceType, "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber", hr))
+	assert.False(t, ac.CanWrite(UserResourceType, "nameless", hr))
+	assert.False(t, ac.CanRead(UserResourceType, "emails", hr))
+	assert.False(t, ac.CanWrite(GroupResourceType, "name", hr))
+
+	helpdesk := context.WithValue(context.Background(), Principal{}, "helpdesk")
+	assert.True(t, ac.CanRead(GroupResourceType, "members", helpdesk))
+	assert.False(t, ac.CanWrite(UserResourceType, "name", helpdesk))
+
+	assert.False(t, ac.CanRead(UserResourceType, "name", context.Background()))
+}
+
+func TestRedactUnreadable(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+
+	ac := NewPolicyAccessController(AccessPolicy{
+		Principals:   []string{"*"},
+		ResourceType: "*",
+		Readable:     []string{"userName", "name.familyName", "emails.value"},
+	})
+	redacted := RedactUnreadable(r, sch, ac, context.Background()).GetData()
+
+	assert.Equal(t, r.Complex["id"], redacted["id"])
+	assert.Equal(t, "david@example.com", redacted["userName"])
+	assert.Equal(t, map[string]interface{}{"familyName": "Qiu"}, redacted["name"])
+	assert.Equal(t, map[string]interface{}{"value": "david@example.com"}, redacted["emails"].([]interface{})[0])
+	assert.NotContains(t, redacted, "displayName")
+
+	// original is untouched
+	assert.Equal(t, "David Qiu", r.Complex["displayName"])
+	assert.Equal(t, "David", r.Complex["name"].(map[string]interface{})["givenName"])
+}
+
+func TestValidateWritable(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	ac := NewPolicyAccessController(AccessPolicy{
+		Principals:   []string{"*"},
+		ResourceType: "*",
+		Writable:     []string{"name.givenName"},
+	})
+
+	for _, test := range []struct {
+		getResource func(r *Resource) *Resource
+		assertion   func(r *Resource, err error)
+	}{
+		{
+			// unchanged
+			func(r *Resource) *Resource {
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			// writable attribute changed
+			func(r *Resource) *Resource {
+				r.Complex["name"].(map[string]interface{})["givenName"] = "Dave"
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			// forbidden attribute changed
+			func(r *Resource) *Resource {
+				r.Complex["displayName"] = "Dave"
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.IsType(t, &ForbiddenError{}, err)
+				assert.Equal(t, "displayName", err.(*ForbiddenError).Path)
+			},
+		},
+		{
+			// forbidden attribute omitted is carried over
+			func(r *Resource) *Resource {
+				delete(r.Complex, "displayName")
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "David Qiu", r.Complex["displayName"])
+			},
+		},
+	} {
+		ref, _, err := ParseResource("../resources/tests/user_1.json")
+		require.Nil(t, err)
+		r, _, err := ParseResource("../resources/tests/user_1.json")
+		require.Nil(t, err)
+
+		r = test.getResource(r)
+		test.assertion(r, ValidateWritable(r, ref, sch, ac, context.Background()))
+	}
+}
+
+func TestPolicyAccessController_UndefinedAttributes(t *testing.T) {
+	// the production schema defines no enterprise extension, whose namespace is kept as an unknown attribute
+	sch, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	enterprise := "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	require.Nil(t, sch.ToAttribute().SubAttribute(enterprise))
+	resource := func() *Resource {
+		return &Resource{Complex: Complex{
+			"schemas":  []interface{}{UserUrn, enterprise},
+			"id":       "1",
+			"userName": "david",
+			"meta":     map[string]interface{}{"resourceType": UserResourceType},
+			enterprise: map[string]interface{}{"employeeNumber": "42", "costCenter": "R&D"},
+		}}
+	}
+	ctx := context.Background()
+	userNameOnly := NewPolicyAccessController(AccessPolicy{
+		Principals:   []string{"*"},
+		ResourceType: UserResourceType,
+		Writable:     []string{"userName"},
+	})
+	hr := NewPolicyAccessController(AccessPolicy{
+		Principals:   []string{"*"},
+		ResourceType: UserResourceType,
+		Writable:     []string{"userName", enterprise},
+	})
+
+	redacted := RedactUnreadable(resource(), sch, userNameOnly, ctx).GetData()
+	assert.Equal(t, "david", redacted["userName"])
+	assert.NotContains(t, redacted, enterprise)
+	assert.Contains(t, RedactUnreadable(resource(), sch, hr, ctx).GetData(), enterprise)
+
+	// changing the namespace is denied unless granted
+	changed := resource()
+	changed.Complex[enterprise] = map[string]interface{}{"employeeNumber": "43"}
+	err = ValidateWritable(changed, resource(), sch, userNameOnly, ctx)
+	assert.IsType(t, &ForbiddenError{}, err)
+	assert.Equal(t, enterprise, err.(*ForbiddenError).Path)
+	assert.Nil(t, ValidateWritable(changed, resource(), sch, hr, ctx))
+	assert.IsType(t, &ForbiddenError{}, ValidateWritable(resource(), nil, sch, userNameOnly, ctx))
+
+	// omitting it is carried over
+	omitted := resource()
+	delete(omitted.Complex, enterprise)
+	assert.Nil(t, ValidateWritable(omitted, resource(), sch, userNameOnly, ctx))
+	assert.Equal(t, resource().Complex[enterprise], omitted.Complex[enterprise])
+	assert.Nil(t, ValidateWritable(resource(), resource(), sch, userNameOnly, ctx))
+}
+
+func TestValidatePatchWritable(t *testing.T) {
+	ac := NewPolicyAccessController(AccessPolicy{
+		Principals:   []string{"*"},
+		ResourceType: UserResourceType,
+		Writable:     []string{"emails.value"},
+	})
+	ctx := context.Background()
+
+	assert.Nil(t, ValidatePatchWritable(Patch{Op: Replace, Path: "emails[type eq \"work\"].value", Value: "a@b.com"}, UserResourceType, ac, ctx))
+	assert.IsType(t, &ForbiddenError{}, ValidatePatchWritable(Patch{Op: Replace, Path: "emails[type eq \"work\"].type", Value: "home"}, UserResourceType, ac, ctx))
+	assert.IsType(t, &ForbiddenError{}, ValidatePatchWritable(Patch{Op: Add, Value: map[string]interface{}{"displayName": "Dave"}}, UserResourceType, ac, ctx))
+}
@@ -0,0 +1,70 @@
+package shared
+
+import "context"
+
+// Fail with an invalid value of roles.value or entitlements.value when an entry of the roles or entitlements of
+// the user refers to no Role or Entitlement of the value in the repository. Entries without a value are left to
+// ValidateRequired; a nil repository leaves the entries of its attribute unchecked.
+func ValidateAssignments(user *Resource, roles, entitlements Repository, ctx context.Context) error {
+	if err := validateAssigned(user, "roles", RoleResourceType, roles, ctx); err != nil {
+		return err
+	}
+	return validateAssigned(user, "entitlements", EntitlementResourceType, entitlements, ctx)
+}
+
+// Fail with InUseError when users carry the value of the Role or Entitlement, of the resource type, in their
+// roles or entitlements, so that it is neither deleted nor given another value from under them
+func ValidateUnassigned(resourceType string, assigned DataProvider, users Repository, ctx context.Context) error {
+	attribute := "roles"
+	if resourceType == EntitlementResourceType {
+		attribute = "entitlements"
+	}
+	value, _ := assigned.GetData()["value"].(string)
+	if len(value) == 0 || users == nil {
+		return nil
+	}
+	quoted, err := QuoteFilterString(value)
+	if err != nil {
+		return err
+	}
+	holders, err := users.Count(attribute+".value eq "+quoted, ctx)
+	if err != nil {
+		return err
+	}
+	if holders > 0 {
+		return Error.InUse(assigned.GetId(), holders)
+	}
+	return nil
+}
+
+func validateAssigned(user *Resource, attribute, resourceType string, repo Repository, ctx context.Context) error {
+	if repo == nil {
+		return nil
+	}
+	entries, _ := user.Complex[attribute].([]interface{})
+	checked := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		m, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, _ := m["value"].(string)
+		if len(value) == 0 || checked[value] {
+			continue
+		}
+		checked[value] = true
+
+		quoted, err := QuoteFilterString(value)
+		if err != nil {
+			return Error.InvalidParam(attribute+".value", "the value of an existing "+resourceType, value)
+		}
+		count, err := repo.Count("value eq "+quoted, ctx)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return Error.InvalidParam(attribute+".value", "the value of an existing "+resourceType, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValidateAssignments(t *testing.T) {
+	roleSchema, _, err := ParseSchema("../resources/schemas/role_internal.json")
+	require.Nil(t, err)
+	entitlementSchema, _, err := ParseSchema("../resources/schemas/entitlement_internal.json")
+	require.Nil(t, err)
+	roles := NewSearchableMapRepository(roleSchema, map[string]DataProvider{
+		"r1": &Resource{Complex: Complex{"id": "r1", "value": "admin", "displayName": "Administrator"}},
+	})
+	entitlements := NewSearchableMapRepository(entitlementSchema, map[string]DataProvider{
+		"e1": &Resource{Complex: Complex{"id": "e1", "value": "vpn", "displayName": "VPN access"}},
+	})
+	user := func(roles, entitlements []interface{}) *Resource {
+		return &Resource{Complex: Complex{"userName": "bjensen", "roles": roles, "entitlements": entitlements}}
+	}
+	entry := func(value string) map[string]interface{} {
+		return map[string]interface{}{"value": value}
+	}
+	ctx := context.Background()
+
+	assert.Nil(t, ValidateAssignments(user(nil, nil), roles, entitlements, ctx))
+	assert.Nil(t, ValidateAssignments(user([]interface{}{entry("admin"), entry("admin")}, []interface{}{entry("vpn")}), roles, entitlements, ctx))
+	// entries without a value are left to ValidateRequired
+	assert.Nil(t, ValidateAssignments(user([]interface{}{map[string]interface{}{"display": "Admin"}}, nil), roles, entitlements, ctx))
+
+	err = ValidateAssignments(user([]interface{}{entry("admin"), entry("auditor")}, nil), roles, entitlements, ctx)
+	assert.Equal(t, Error.InvalidParam("roles.value", "the value of an existing Role", "auditor"), err)
+	err = ValidateAssignments(user(nil, []interface{}{entry("wifi")}), roles, entitlements, ctx)
+	assert.Equal(t, Error.InvalidParam("entitlements.value", "the value of an existing Entitlement", "wifi"), err)
+	assert.Nil(t, ValidateAssignments(user(nil, []interface{}{entry("wifi")}), roles, nil, ctx))
+	// a value cannot alter the filter looking for it
+	err = ValidateAssignments(user([]interface{}{entry(`x" or value pr or value eq "y`)}, nil), roles, entitlements, ctx)
+	assert.Equal(t, Error.InvalidParam("roles.value", "the value of an existing Role", `x" or value pr or value eq "y`), err)
+}
+
+func TestValidateUnassigned(t *testing.T) {
+	userSchema, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	users := NewSearchableMapRepository(userSchema, map[string]DataProvider{
+		"u1": &Resource{Complex: Complex{
+			"id":           "u1",
+			"userName":     "bjensen",
+			"roles":        []interface{}{map[string]interface{}{"value": "admin"}},
+			"entitlements": []interface{}{map[string]interface{}{"value": "vpn"}},
+		}},
+	})
+	ctx := context.Background()
+	resource := func(id, value string) *Resource {
+		return &Resource{Complex: Complex{"id": id, "value": value}}
+	}
+
+	assert.Equal(t, Error.InUse("r1", 1), ValidateUnassigned(RoleResourceType, resource("r1", "admin"), users, ctx))
+	assert.Equal(t, Error.InUse("e1", 1), ValidateUnassigned(EntitlementResourceType, resource("e1", "vpn"), users, ctx))
+	// the value of a role is not that of an entitlement
+	assert.Nil(t, ValidateUnassigned(RoleResourceType, resource("r2", "vpn"), users, ctx))
+	assert.Nil(t, ValidateUnassigned(EntitlementResourceType, resource("e2", "wifi"), users, ctx))
+	assert.Nil(t, ValidateUnassigned(RoleResourceType, resource("r1", "admin"), nil, ctx))
+}
@@ -0,0 +1,224 @@
+package shared
+
+import (
+	"strings"
+	"unicode"
+)
+
+// An attribute path as defined by RFC 7644 section 3.10:
+//
+//	PATH      = attrPath / valuePath [subAttr]
+//	attrPath  = [URI ":"] ATTRNAME *1subAttr
+//	valuePath = attrPath "[" valFilter "]"
+//
+// i.e. 'name.familyName', 'emails[type eq "work"].value' or
+// 'urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager.value'.
+// PATCH paths, the attributes and excludedAttributes parameters and sortBy are all read with CompilePath,
+// so that the same text addresses the same attribute everywhere.
+type AttributePath struct {
+	URN          string     // schema URN prefix, empty when absent; Resolve drops the URN of the schema itself
+	Attribute    string     // attribute name
+	Filter       FilterNode // valuePath filter, nil when absent
+	SubAttribute string     // sub attribute name, empty when absent
+	filter       string     // text of the filter, as given
+}
+
+// Parse the text into an AttributePath. Only the syntax is checked, see Resolve for the schema.
+func ParseAttributePath(text string) (*AttributePath, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return nil, Error.InvalidPath(text, "empty path")
+	}
+
+	ap := &AttributePath{}
+	rest := text
+
+	// URNs contain colons and periods while attribute names contain neither, so the URN ends at the
+	// last colon ahead of the filter
+	if strings.HasPrefix(strings.ToLower(rest), "urn:") {
+		head := rest
+		if i := strings.Index(head, "["); i >= 0 {
+			head = head[:i]
+		}
+		i := strings.LastIndex(head, ":")
+		ap.URN, rest = rest[:i], rest[i+1:]
+	}
+
+	hasSub := false
+	switch lb, rb := strings.Index(rest, "["), strings.LastIndex(rest, "]"); {
+	case lb == -1 && rb == -1:
+		if i := strings.Index(rest, "."); i >= 0 {
+			ap.Attribute, ap.SubAttribute, hasSub = rest[:i], rest[i+1:], true
+		} else {
+			ap.Attribute = rest
+		}
+
+	case lb > 0 && rb > lb+1:
+		ap.Attribute = rest[:lb]
+		ap.filter = rest[lb+1 : rb]
+		filter, err := NewFilter(ap.filter)
+		if err != nil {
+			return nil, err
+		}
+		ap.Filter = filter
+
+		switch after := rest[rb+1:]; {
+		case len(after) == 0:
+		case strings.HasPrefix(after, "."):
+			ap.SubAttribute, hasSub = after[1:], true
+		default:
+			return nil, Error.InvalidPath(text, "unexpected text after filter")
+		}
+
+	default:
+		return nil, Error.InvalidPath(text, "invalid placement of filter brackets")
+	}
+
+	if !validAttributeName(ap.Attribute) {
+		return nil, Error.InvalidPath(text, "invalid attribute name")
+	}
+	if hasSub && !validAttributeName(ap.SubAttribute) {
+		return nil, Error.InvalidPath(text, "invalid sub attribute name")
+	}
+	return ap, nil
+}
+
+// Parse the text and resolve it against the schema, returning the Path chain navigated by Complex.Get and
+// Complex.Set along with the attribute the path ends at.
+func CompilePath(text string, sch *Schema) (Path, *Attribute, error) {
+	ap, err := ParseAttributePath(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	attr, err := ap.Resolve(sch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ap.Path(), attr, nil
+}
+
+// Check the path against the schema and correct the case of every name, including those in the filter,
+// to that of the schema. A URN prefix must be the id of the schema, or of any core resource for the root
+// schema, which has no id, or else the name of an extension namespace in the schema.
+func (ap *AttributePath) Resolve(sch *Schema) (*Attribute, error) {
+	text := ap.String()
+	parent := sch.ToAttribute()
+
+	if len(ap.URN) > 0 {
+		switch {
+		case strings.EqualFold(ap.URN, sch.Id):
+			ap.URN = ""
+		case len(sch.Id) == 0 && (strings.EqualFold(ap.URN, UserUrn) || strings.EqualFold(ap.URN, GroupUrn)):
+			ap.URN = ""
+		default:
+			if ext := parent.SubAttribute(ap.URN); ext != nil && ext.Type == TypeComplex {
+				ap.URN, parent = ext.Name, ext
+			} else if ext := parent.SubAttribute(ap.URN + ":" + ap.Attribute); ext != nil {
+				// the extension namespace as a whole, i.e. 'urn:ietf:params:scim:schemas:extension:enterprise:2.0:User'
+				ap.URN, ap.Attribute = "", ext.Name
+			} else {
+				return nil, Error.InvalidPath(text, "unknown schema urn")
+			}
+		}
+	}
+
+	attr := parent.SubAttribute(ap.Attribute)
+	if attr == nil {
+		return nil, Error.InvalidPath(text, "no attribute found for path")
+	}
+	ap.Attribute = attr.Name
+
+	if ap.Filter != nil {
+		if !attr.MultiValued {
+			return nil, Error.InvalidPath(text, "filter on singular attribute")
+		}
+		if attr.Type == TypeComplex {
+			if !filterPathsDefined(ap.Filter, attr) {
+				return nil, Error.InvalidPath(text, "no attribute found for filter path")
+			}
+			ap.Filter.CorrectCase(attr)
+		}
+	}
+
+	if len(ap.SubAttribute) > 0 {
+		sub := attr.SubAttribute(ap.SubAttribute)
+		if sub == nil {
+			return nil, Error.InvalidPath(text, "no attribute found for path")
+		}
+		ap.SubAttribute = sub.Name
+		return sub, nil
+	}
+	return attr, nil
+}
+
+// Convert to the Path chain navigated by Complex.Get and Complex.Set. Extension attributes are nested below
+// their namespace, i.e. 'urn:ietf:params:scim:schemas:extension:enterprise:2.0:User' -> 'manager' -> 'value'.
+func (ap *AttributePath) Path() Path {
+	head := &path{text: ap.Attribute, base: ap.Attribute}
+	if ap.Filter != nil {
+		head.text = ap.Attribute + "[" + ap.filter + "]"
+		head.filterRoot = ap.Filter
+	}
+	if len(ap.SubAttribute) > 0 {
+		head.next = &path{text: ap.SubAttribute, base: ap.SubAttribute}
+	}
+	if len(ap.URN) > 0 {
+		head = &path{text: ap.URN, base: ap.URN, next: head}
+	}
+	return head
+}
+
+// the names along the path without the filter, the extension namespace first
+func (ap *AttributePath) names() []string {
+	names := make([]string, 0, 3)
+	if len(ap.URN) > 0 {
+		names = append(names, ap.URN)
+	}
+	names = append(names, ap.Attribute)
+	if len(ap.SubAttribute) > 0 {
+		names = append(names, ap.SubAttribute)
+	}
+	return names
+}
+
+// The path in RFC 7644 syntax. The filter is rendered as it was given.
+func (ap *AttributePath) String() string {
+	text := ap.Attribute
+	if len(ap.URN) > 0 {
+		text = ap.URN + ":" + text
+	}
+	if ap.Filter != nil {
+		text += "[" + ap.filter + "]"
+	}
+	if len(ap.SubAttribute) > 0 {
+		text += "." + ap.SubAttribute
+	}
+	return text
+}
+
+// ATTRNAME = ALPHA *(nameChar), with the '$ref' sub attribute of references allowed as well
+func validAttributeName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r):
+		case i == 0 && r == '$':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func filterPathsDefined(node FilterNode, guide *Attribute) bool {
+	if node == nil || node.(*filterNode) == nil {
+		return true
+	}
+	if node.Type() == PathOperand && guide.GetAttribute(node.Data().(Path), true) == nil {
+		return false
+	}
+	return filterPathsDefined(node.Left(), guide) && filterPathsDefined(node.Right(), guide)
+}
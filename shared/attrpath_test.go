@@ -0,0 +1,177 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const enterpriseUrn = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+
+func TestParseAttributePath(t *testing.T) {
+	for _, test := range []struct {
+		text      string
+		assertion func(ap *AttributePath, err error)
+	}{
+		{
+			"userName",
+			func(ap *AttributePath, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "", ap.URN)
+				assert.Equal(t, "userName", ap.Attribute)
+				assert.Nil(t, ap.Filter)
+				assert.Equal(t, "", ap.SubAttribute)
+			},
+		},
+		{
+			"emails[type eq \"work\"].value",
+			func(ap *AttributePath, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "emails", ap.Attribute)
+				assert.Equal(t, Eq, ap.Filter.Data())
+				assert.Equal(t, "value", ap.SubAttribute)
+				assert.Equal(t, "emails[type eq \"work\"].value", ap.String())
+			},
+		},
+		{
+			enterpriseUrn + ":manager.value",
+			func(ap *AttributePath, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, enterpriseUrn, ap.URN)
+				assert.Equal(t, "manager", ap.Attribute)
+				assert.Equal(t, "value", ap.SubAttribute)
+			},
+		},
+		{
+			"members[value eq \"urn:x:y\"]",
+			func(ap *AttributePath, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "", ap.URN)
+				assert.Equal(t, "members", ap.Attribute)
+			},
+		},
+		{
+			"members.$ref",
+			func(ap *AttributePath, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "$ref", ap.SubAttribute)
+			},
+		},
+		{
+			"name.",
+			func(ap *AttributePath, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+		{
+			"name.familyName.x",
+			func(ap *AttributePath, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+		{
+			"emails[type eq \"work\"]value",
+			func(ap *AttributePath, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+		{
+			"emails]",
+			func(ap *AttributePath, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+	} {
+		test.assertion(ParseAttributePath(test.text))
+	}
+}
+
+func TestCompilePath(t *testing.T) {
+	sch := &Schema{
+		Id: UserUrn,
+		Attributes: []*Attribute{
+			{Name: "userName", Type: TypeString},
+			{Name: "emails", Type: TypeComplex, MultiValued: true, SubAttributes: []*Attribute{
+				{Name: "value", Type: TypeString},
+				{Name: "type", Type: TypeString},
+			}},
+			{Name: enterpriseUrn, Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "employeeNumber", Type: TypeString},
+				{Name: "manager", Type: TypeComplex, SubAttributes: []*Attribute{
+					{Name: "value", Type: TypeString},
+				}},
+			}},
+		},
+	}
+	require.Nil(t, CompileSchema(sch))
+
+	for _, test := range []struct {
+		text      string
+		assertion func(p Path, attr *Attribute, err error)
+	}{
+		{
+			"urn:ietf:params:scim:schemas:core:2.0:User:USERNAME",
+			func(p Path, attr *Attribute, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "userName", p.CollectValue())
+				assert.Equal(t, "userName", attr.Name)
+			},
+		},
+		{
+			"Emails[Type eq \"work\"].Value",
+			func(p Path, attr *Attribute, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "emails", p.Base())
+				assert.Equal(t, "type", p.FilterRoot().Left().Data().(Path).Base())
+				assert.Equal(t, "value", p.Next().Base())
+				assert.Equal(t, "value", attr.Name)
+			},
+		},
+		{
+			enterpriseUrn + ":manager.value",
+			func(p Path, attr *Attribute, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, enterpriseUrn, p.Base())
+				assert.Equal(t, "manager", p.Next().Base())
+				assert.Equal(t, "value", p.Next().Next().Base())
+				assert.True(t, sch.Attributes[2].SubAttributes[1].SubAttributes[0] == attr)
+			},
+		},
+		{
+			enterpriseUrn + ":employeeNumber",
+			func(p Path, attr *Attribute, err error) {
+				assert.Nil(t, err)
+				assert.True(t, attr.EqualsToPath(p))
+			},
+		},
+		{
+			enterpriseUrn,
+			func(p Path, attr *Attribute, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, enterpriseUrn, p.Base())
+				assert.Nil(t, p.Next())
+				assert.Equal(t, enterpriseUrn, attr.Name)
+			},
+		},
+		{
+			"urn:ietf:params:scim:schemas:core:2.0:Group:displayName",
+			func(p Path, attr *Attribute, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+		{
+			"emails[primary eq true]",
+			func(p Path, attr *Attribute, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+		{
+			"userName[value eq \"foo\"]",
+			func(p Path, attr *Attribute, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
+	} {
+		test.assertion(CompilePath(test.text, sch))
+	}
+}
@@ -18,6 +18,8 @@ func CorrectCase(subj *Resource, sch *Schema, ctx context.Context) (err error) {
 		}
 	}()
 
+	// integers stay int64 and decimals float64 whether or not ValidateType ran before
+	decodeNumbers(subj.Complex, sch.ToAttribute(), true)
 	caseCorrectionInstance.correctCaseWithReflection(reflect.ValueOf(subj.Complex), sch.ToAttribute(), ctx)
 
 	err = nil
@@ -53,27 +55,45 @@ func (cc *caseCorrection) resetMapKey(m reflect.Value, guide *Attribute, ctx con
 	}
 
 	for _, k := range m.MapKeys() {
-		p, err := NewPath(k.String())
-		if err != nil {
-			cc.throw(Error.NoAttribute(p.Value()), ctx)
-		}
-
-		attr := guide.GetAttribute(p, false)
+		// keys are matched by name rather than parsed as paths, so that extension namespaces are found as a whole
+		attr := guide.SubAttribute(k.String())
 		if attr == nil {
-			cc.throw(Error.NoAttribute(p.Value()), ctx)
+			cc.throw(Error.NoAttribute(k.String()), ctx)
 		}
 
 		v := m.MapIndex(k)
+		if attr.Type == TypeDateTime {
+			v = cc.normalizeDateTime(v)
+		}
 
 		if k.String() != attr.Name {
-			m.SetMapIndex(reflect.ValueOf(attr.Name), v)
 			m.SetMapIndex(k, reflect.Value{})
 		}
+		m.SetMapIndex(reflect.ValueOf(attr.Name), v)
 
 		cc.correctCaseWithReflection(v, attr, ctx)
 	}
 }
 
+// dateTime values are stored in UTC, see FormatDateTime
+func (cc *caseCorrection) normalizeDateTime(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(NormalizeDateTime(v.String()))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if elem := v.Index(i); elem.CanSet() {
+				elem.Set(cc.normalizeDateTime(elem))
+			}
+		}
+	}
+	return v
+}
+
 func (cc *caseCorrection) throw(err error, ctx context.Context) {
 	panic(err)
 }
@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CBOR major types, RFC 8949 section 3.1
+const (
+	cborUnsigned byte = 0 << 5
+	cborNegative byte = 1 << 5
+	cborText     byte = 3 << 5
+	cborArray    byte = 4 << 5
+	cborMap      byte = 5 << 5
+	cborSimple   byte = 7 << 5
+)
+
+// write a value projected by project as CBOR
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(cborSimple | 22)
+	case bool:
+		if v {
+			buf.WriteByte(cborSimple | 21)
+		} else {
+			buf.WriteByte(cborSimple | 20)
+		}
+	case int64:
+		if v >= 0 {
+			cborHead(buf, cborUnsigned, uint64(v))
+		} else {
+			cborHead(buf, cborNegative, uint64(-1-v))
+		}
+	case float64:
+		buf.WriteByte(cborSimple | 27)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		cborHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		cborHead(buf, cborArray, uint64(len(v)))
+		for _, each := range v {
+			if err := encodeCBOR(buf, each); err != nil {
+				return err
+			}
+		}
+	case fields:
+		cborHead(buf, cborMap, uint64(len(v)))
+		for _, f := range v {
+			encodeCBOR(buf, f.name)
+			if err := encodeCBOR(buf, f.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("CBOR cannot encode a value of type %T", v)
+	}
+	return nil
+}
+
+// the initial byte of a data item and its argument, in the fewest bytes it fits
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
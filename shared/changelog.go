@@ -0,0 +1,136 @@
+package shared
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An ordered record of the changes of repositories answering delta queries: which resources were created,
+// updated or deleted since a watermark a client was given earlier. A change log is the Publisher of the
+// repositories it records, see NewPublishingRepository. Watermarks are opaque to clients.
+type ChangeLog interface {
+	Publisher
+	// The watermark of the latest change, for clients to ask for the changes after it later
+	Watermark(ctx context.Context) (string, error)
+	// The changes of the resource type after the watermark, oldest first and at most limit unless it is 0,
+	// with the watermark to continue from. Fails with an invalid since parameter when the watermark is not
+	// one of the log, or when changes after it were forgotten already, so that the client has to read all
+	// resources again.
+	Since(resourceType, watermark string, limit int, ctx context.Context) ([]*ChangeEvent, string, error)
+	// Forget the changes made before the time; run periodically rather than by Publish
+	Expire(before time.Time) error
+}
+
+// Returns a change log that keeps the changes of the retention in memory, at most capacity of them unless it
+// is 0: the oldest are forgotten early when more are published. Changes past the retention are only forgotten
+// by Expire. Its watermarks are neither shared between instances nor survive restarts; those of an earlier
+// process are rejected. Documents of the events are not kept, delta queries read the resources changed from
+// the repository.
+func NewMemoryChangeLog(retention time.Duration, capacity int) ChangeLog {
+	return &memoryChangeLog{
+		epoch:     strconv.FormatInt(time.Now().UnixNano(), 36),
+		retention: retention,
+		capacity:  capacity,
+		events:    make([]*ChangeEvent, 0),
+	}
+}
+
+type memoryChangeLog struct {
+	sync.Mutex
+	epoch     string // tells the watermarks of the log from those of another
+	retention time.Duration
+	capacity  int
+	forgotten int64          // sequence number of the latest change forgotten, events[0] is the one after it
+	events    []*ChangeEvent // in the order published
+}
+
+func (l *memoryChangeLog) Publish(event *ChangeEvent, ctx context.Context) error {
+	kept := *event
+	kept.Document = nil
+
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, &kept)
+	if l.capacity > 0 && len(l.events) > l.capacity {
+		l.forget(len(l.events) - l.capacity)
+	}
+	return nil
+}
+
+func (l *memoryChangeLog) Expire(before time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+
+	expired := 0
+	for expired < len(l.events) && l.events[expired].Time.Before(before) {
+		expired++
+	}
+	l.forget(expired)
+	return nil
+}
+
+// drops the oldest events, copying the rest so that the array they were kept in is released
+func (l *memoryChangeLog) forget(count int) {
+	if count > 0 {
+		l.forgotten += int64(count)
+		l.events = append(l.events[:0:0], l.events[count:]...)
+	}
+}
+
+func (l *memoryChangeLog) Watermark(ctx context.Context) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.watermark(l.forgotten + int64(len(l.events))), nil
+}
+
+func (l *memoryChangeLog) Since(resourceType, watermark string, limit int, ctx context.Context) ([]*ChangeEvent, string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	seq, ok := l.parseWatermark(watermark)
+	latest := l.forgotten + int64(len(l.events))
+	if !ok || seq > latest {
+		return nil, "", Error.InvalidParam("since", "a watermark of an earlier response", watermark)
+	}
+	if seq < l.forgotten {
+		recent := "a watermark of the last " + l.retention.String()
+		if l.capacity > 0 {
+			recent += " and of the last " + strconv.Itoa(l.capacity) + " changes"
+		}
+		return nil, "", Error.InvalidParam("since", recent, watermark)
+	}
+
+	changes := make([]*ChangeEvent, 0)
+	for i := seq - l.forgotten; i < int64(len(l.events)); i++ {
+		if limit > 0 && len(changes) == limit {
+			return changes, l.watermark(l.forgotten + i), nil
+		}
+		if event := l.events[i]; event.ResourceType == resourceType {
+			copied := *event
+			changes = append(changes, &copied)
+		}
+	}
+	return changes, l.watermark(latest), nil
+}
+
+// the watermark of the change of the sequence number, 0 before the first
+func (l *memoryChangeLog) watermark(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(l.epoch + "." + strconv.FormatInt(seq, 10)))
+}
+
+func (l *memoryChangeLog) parseWatermark(watermark string) (int64, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(watermark)
+	if err != nil {
+		return 0, false
+	}
+	parts := strings.SplitN(string(raw), ".", 2)
+	if len(parts) != 2 || parts[0] != l.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(parts[1], 10, 64)
+	return seq, err == nil && seq >= 0
+}
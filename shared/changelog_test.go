@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryChangeLog(t *testing.T) {
+	ctx := context.Background()
+	log := NewMemoryChangeLog(time.Hour, 0).(*memoryChangeLog)
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	start, err := log.Watermark(ctx)
+	require.Nil(t, err)
+	publish := func(resourceType, op, id string) {
+		require.Nil(t, log.Publish(&ChangeEvent{ResourceType: resourceType, Op: op, Id: id, Time: now, Document: Complex{"id": id}}, ctx))
+	}
+	publish(UserResourceType, ChangeCreate, "1")
+	publish(GroupResourceType, ChangeCreate, "g")
+	publish(UserResourceType, ChangeUpdate, "1")
+	publish(UserResourceType, ChangeDelete, "2")
+
+	changes, watermark, err := log.Since(UserResourceType, start, 0, ctx)
+	require.Nil(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, ChangeDelete, changes[2].Op)
+	assert.Nil(t, changes[0].Document)
+	latest, _ := log.Watermark(ctx)
+	assert.Equal(t, latest, watermark)
+
+	// pages end at the last change returned
+	changes, page, err := log.Since(UserResourceType, start, 2, ctx)
+	require.Nil(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, ChangeUpdate, changes[1].Op)
+	changes, page, err = log.Since(UserResourceType, page, 2, ctx)
+	require.Nil(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "2", changes[0].Id)
+	assert.Equal(t, latest, page)
+
+	changes, page, err = log.Since(UserResourceType, latest, 2, ctx)
+	require.Nil(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, latest, page)
+
+	// watermarks of another log, malformed ones and those of forgotten changes are rejected
+	for _, watermark := range []string{"", "not base64!", NewMemoryChangeLog(time.Hour, 0).(*memoryChangeLog).watermark(0), log.watermark(5)} {
+		_, _, err = log.Since(UserResourceType, watermark, 0, ctx)
+		assert.IsType(t, &InvalidParamError{}, err, watermark)
+	}
+	// changes are only forgotten by Expire
+	now = now.Add(2 * time.Hour)
+	publish(UserResourceType, ChangeCreate, "3")
+	_, _, err = log.Since(UserResourceType, start, 0, ctx)
+	require.Nil(t, err)
+	require.Nil(t, log.Expire(now.Add(-time.Hour)))
+	_, _, err = log.Since(UserResourceType, start, 0, ctx)
+	assert.IsType(t, &InvalidParamError{}, err)
+	changes, _, err = log.Since(UserResourceType, latest, 0, ctx)
+	require.Nil(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "3", changes[0].Id)
+}
+
+func TestMemoryChangeLog_Capacity(t *testing.T) {
+	ctx := context.Background()
+	log := NewMemoryChangeLog(time.Hour, 2)
+	start, err := log.Watermark(ctx)
+	require.Nil(t, err)
+	for _, id := range []string{"1", "2", "3"} {
+		require.Nil(t, log.Publish(&ChangeEvent{ResourceType: UserResourceType, Op: ChangeCreate, Id: id, Time: time.Now()}, ctx))
+	}
+
+	// the oldest change was forgotten early
+	_, _, err = log.Since(UserResourceType, start, 0, ctx)
+	assert.IsType(t, &InvalidParamError{}, err)
+	assert.Len(t, log.(*memoryChangeLog).events, 2)
+}
@@ -0,0 +1,339 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/satori/go.uuid"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Operations of change events
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// A change data capture message describing a successful write to a repository
+type ChangeEvent struct {
+	EventId      string    `json:"eventId"` // unique per event, consumers and brokers use it to drop redeliveries
+	ResourceType string    `json:"resourceType"`
+	Op           string    `json:"op"`
+	Id           string    `json:"id"`
+	Version      string    `json:"version,omitempty"`
+	Document     Complex   `json:"document,omitempty"` // the resource as written, absent for deletes
+	Time         time.Time `json:"time"`
+
+	// the id of the request that made the change, see RequestIdOf
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// Delivers change events to a message broker, i.e. a Kafka topic or a NATS subject. Publish returns once
+// the broker has accepted the event.
+type Publisher interface {
+	Publish(event *ChangeEvent, ctx context.Context) error
+}
+
+// Adapts a function to a Publisher
+type PublisherFunc func(event *ChangeEvent, ctx context.Context) error
+
+func (f PublisherFunc) Publish(event *ChangeEvent, ctx context.Context) error {
+	return f(event, ctx)
+}
+
+// Durable store of change events that were not acknowledged by the broker yet. Events are appended before
+// they are published and marked done once the broker accepted them; an OutboxRelay publishes the events
+// left pending by broker outages or crashes, so every event is delivered at least once.
+type Outbox interface {
+	Append(event *ChangeEvent, ctx context.Context) (key string, err error)
+	// the oldest pending events, in the order they were appended
+	Pending(limit int, ctx context.Context) ([]OutboxEntry, error)
+	Done(key string, ctx context.Context) error
+}
+
+type OutboxEntry struct {
+	Key   string
+	Event *ChangeEvent
+}
+
+// The error of appending to an outbox holding as many pending events as it can
+var ErrOutboxFull = errors.New("outbox full")
+
+// Implemented by outboxes that signal appends, so that an OutboxRelay publishes new events right away rather
+// than at its next pass
+type OutboxNotifier interface {
+	// receives a value after an append, at most one is buffered
+	Appended() <-chan struct{}
+}
+
+// Returns an outbox that keeps at most limit pending events in memory, 1000 if not positive; appending to a
+// full outbox fails with ErrOutboxFull. It does not survive restarts; use it for tests or when losing the
+// events of a crash is acceptable.
+func NewMemoryOutbox(limit int) Outbox {
+	if limit <= 0 {
+		limit = 1000
+	}
+	return &memoryOutbox{limit: limit, pending: make(map[string]*ChangeEvent), appended: make(chan struct{}, 1)}
+}
+
+type memoryOutbox struct {
+	sync.Mutex
+	limit    int
+	seq      int
+	keys     []string
+	pending  map[string]*ChangeEvent
+	appended chan struct{}
+}
+
+func (o *memoryOutbox) Append(event *ChangeEvent, ctx context.Context) (string, error) {
+	o.Lock()
+	defer o.Unlock()
+	if len(o.keys) >= o.limit {
+		return "", ErrOutboxFull
+	}
+	o.seq++
+	key := strconv.Itoa(o.seq)
+	o.keys = append(o.keys, key)
+	o.pending[key] = event
+	select {
+	case o.appended <- struct{}{}:
+	default:
+	}
+	return key, nil
+}
+
+func (o *memoryOutbox) Appended() <-chan struct{} {
+	return o.appended
+}
+
+func (o *memoryOutbox) Pending(limit int, ctx context.Context) ([]OutboxEntry, error) {
+	o.Lock()
+	defer o.Unlock()
+	entries := make([]OutboxEntry, 0)
+	for _, key := range o.keys {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		entries = append(entries, OutboxEntry{Key: key, Event: o.pending[key]})
+	}
+	return entries, nil
+}
+
+func (o *memoryOutbox) Done(key string, ctx context.Context) error {
+	o.Lock()
+	defer o.Unlock()
+	if _, ok := o.pending[key]; !ok {
+		return nil
+	}
+	delete(o.pending, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Decorates a repository so that every successful create, update and delete is published as a change event
+// of the resource type. Reads pass through. The documents of the events leave out the attributes the schema
+// never returns, like password; a subscriber narrows them further with NewMaskingPublisher.
+//
+// Events are published after the write, so a failure to publish is logged rather than returned: the write
+// took place and the caller must not retry it. Without an outbox such an event is lost. With an outbox, the
+// event is appended to it after the write and then published; when publishing fails, the event stays pending
+// for the OutboxRelay. Events published directly may overtake pending ones. With an outbox and a nil
+// publisher, events are only appended and the relay publishes them in the background, so that slow
+// subscribers like webhooks do not hold up writes. Events of a crash between the write and the append, or of
+// a failure to append, are lost, unless the repository writes the outbox in the transaction of the write
+// itself.
+func NewPublishingRepository(repo Repository, resourceType string, sch *Schema, publisher Publisher, outbox Outbox, logger Logger) Repository {
+	if logger == nil {
+		logger = NewNoOpLogger()
+	}
+	return &publishingRepository{repo: repo, resourceType: resourceType, sch: sch, publisher: publisher, outbox: outbox, logger: logger}
+}
+
+type publishingRepository struct {
+	repo         Repository
+	resourceType string
+	sch          *Schema
+	publisher    Publisher
+	outbox       Outbox
+	logger       Logger
+}
+
+func (r *publishingRepository) Create(provider DataProvider, ctx context.Context) error {
+	if err := r.repo.Create(provider, ctx); err != nil {
+		return err
+	}
+	r.publish(ChangeCreate, provider.GetId(), provider, ctx)
+	return nil
+}
+
+func (r *publishingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	return r.repo.Get(id, version, ctx)
+}
+
+func (r *publishingRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	return ResourceExists(r.repo, id, version, ctx)
+}
+
+func (r *publishingRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	return CountExisting(r.repo, ids, ctx)
+}
+
+func (r *publishingRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	return r.repo.GetAll(ctx)
+}
+
+func (r *publishingRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *publishingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	if err := r.repo.Update(id, version, provider, ctx); err != nil {
+		return err
+	}
+	r.publish(ChangeUpdate, id, provider, ctx)
+	return nil
+}
+
+// published as an update carrying the group as it is after the patch, read back from the repository
+func (r *publishingRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	if err := memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx); err != nil {
+		return err
+	}
+	dp, err := r.repo.Get(id, "", ctx)
+	if err != nil {
+		r.logger.Warn("change event without document, reading the patched resource failed", LogFields(ctx, "error", err.Error())...)
+		dp = nil
+	}
+	r.publish(ChangeUpdate, id, dp, ctx)
+	return nil
+}
+
+func (r *publishingRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *publishingRepository) Delete(id, version string, ctx context.Context) error {
+	if err := r.repo.Delete(id, version, ctx); err != nil {
+		return err
+	}
+	r.publish(ChangeDelete, id, nil, ctx)
+	return nil
+}
+
+func (r *publishingRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	return r.repo.Search(payload, ctx)
+}
+
+func (r *publishingRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+func (r *publishingRepository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	return SliceAttribute(r.repo, id, attribute, startIndex, count, ctx)
+}
+
+func (r *publishingRepository) publish(op, id string, provider DataProvider, ctx context.Context) {
+	event := &ChangeEvent{
+		EventId:      uuid.NewV4().String(),
+		ResourceType: r.resourceType,
+		Op:           op,
+		Id:           id,
+		Time:         time.Now().UTC(),
+		RequestId:    RequestIdOf(ctx),
+	}
+	if provider != nil {
+		event.Document = WithoutNeverReturned(provider.GetData(), r.sch)
+		if meta, ok := event.Document["meta"].(map[string]interface{}); ok {
+			event.Version, _ = meta["version"].(string)
+		}
+	}
+
+	fields := func(err error) []interface{} {
+		return LogFields(ctx, "eventId", event.EventId, "event", op+" "+r.resourceType+" "+id, "error", err.Error())
+	}
+	if r.outbox == nil {
+		if err := r.publisher.Publish(event, ctx); err != nil {
+			r.logger.Error("change event lost", fields(err)...)
+		}
+		return
+	}
+	key, err := r.outbox.Append(event, ctx)
+	if err != nil {
+		r.logger.Error("change event lost, outbox append failed", fields(err)...)
+		return
+	}
+	if r.publisher == nil {
+		return
+	}
+	if err := r.publisher.Publish(event, ctx); err != nil {
+		r.logger.Warn("change event left pending in the outbox", fields(err)...)
+		return
+	}
+	r.outbox.Done(key, ctx)
+}
+
+// Publishes the events left pending in an outbox, oldest first. Run it in the background of every server
+// sharing the outbox; brokers and consumers drop the redeliveries this causes by the event id.
+type OutboxRelay struct {
+	Outbox    Outbox
+	Publisher Publisher
+	BatchSize int           // events read from the outbox at once, 100 if not positive
+	Interval  time.Duration // pause between passes that found nothing to publish, 5 seconds if not positive
+}
+
+// Publish the pending events until the context is done, right after appends when the outbox is an
+// OutboxNotifier
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	var appended <-chan struct{}
+	if notifier, ok := r.Outbox.(OutboxNotifier); ok {
+		appended = notifier.Appended()
+	}
+	for {
+		n, err := r.RelayOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil && n > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-appended:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Publish one batch of pending events and return how many were published. Stops at the first event the
+// broker does not accept, so that events of a resource are not delivered out of order.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	batchSize := r.BatchSize
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	entries, err := r.Outbox.Pending(batchSize, ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range entries {
+		if err := r.Publisher.Publish(entry.Event, ctx); err != nil {
+			return i, err
+		}
+		if err := r.Outbox.Done(entry.Key, ctx); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,127 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type recordingPublisher struct {
+	events []*ChangeEvent
+	err    error
+}
+
+func (p *recordingPublisher) Publish(event *ChangeEvent, ctx context.Context) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestPublishingRepository(t *testing.T) {
+	ctx := context.WithValue(context.Background(), RequestId{}, "r1")
+	sch, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	publisher := &recordingPublisher{}
+	buf := new(bytes.Buffer)
+	repo := NewPublishingRepository(NewMapRepository(map[string]DataProvider{}), UserResourceType, sch, publisher, nil, NewTextLogger(buf, LogInfo))
+
+	user := &Resource{Complex: Complex{"id": "1", "userName": "alice", "password": "secret", "meta": map[string]interface{}{"version": "v1"}}}
+	require.Nil(t, repo.Create(user, ctx))
+	require.Nil(t, repo.Update("1", "", user, ctx))
+	require.Nil(t, repo.Delete("1", "", ctx))
+	assert.NotNil(t, repo.Delete("1", "", ctx))
+
+	require.Len(t, publisher.events, 3)
+	for i, op := range []string{ChangeCreate, ChangeUpdate, ChangeDelete} {
+		event := publisher.events[i]
+		assert.Equal(t, op, event.Op)
+		assert.Equal(t, UserResourceType, event.ResourceType)
+		assert.Equal(t, "1", event.Id)
+		assert.NotEmpty(t, event.EventId)
+		assert.Equal(t, "r1", event.RequestId)
+	}
+	assert.Equal(t, "v1", publisher.events[0].Version)
+	assert.Equal(t, Complex{"id": "1", "userName": "alice", "meta": map[string]interface{}{"version": "v1"}}, publisher.events[1].Document)
+	assert.Equal(t, "secret", user.Complex["password"])
+	assert.Nil(t, publisher.events[2].Document)
+
+	// the write took place, so the lost event is logged rather than failing it
+	publisher.err = errors.New("broker down")
+	assert.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "2"}}, ctx))
+	assert.Contains(t, buf.String(), "change event lost")
+	assert.Contains(t, buf.String(), "broker down")
+}
+
+func TestPublishingRepository_Outbox(t *testing.T) {
+	ctx := context.Background()
+	publisher := &recordingPublisher{err: errors.New("broker down")}
+	outbox := NewMemoryOutbox(0)
+	repo := NewPublishingRepository(NewMapRepository(map[string]DataProvider{}), GroupResourceType, nil, publisher, outbox, nil)
+
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "1"}}, ctx))
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "2"}}, ctx))
+	pending, err := outbox.Pending(0, ctx)
+	require.Nil(t, err)
+	require.Len(t, pending, 2)
+
+	relay := &OutboxRelay{Outbox: outbox, Publisher: publisher, BatchSize: 1}
+	n, err := relay.RelayOnce(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+
+	publisher.err = nil
+	for _, expect := range []int{1, 1, 0} {
+		n, err = relay.RelayOnce(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, expect, n)
+	}
+	require.Len(t, publisher.events, 2)
+	assert.Equal(t, "1", publisher.events[0].Id)
+	assert.Equal(t, "2", publisher.events[1].Id)
+
+	require.Nil(t, repo.Delete("1", "", ctx))
+	pending, _ = outbox.Pending(0, ctx)
+	assert.Empty(t, pending)
+	assert.Len(t, publisher.events, 3)
+}
+
+func TestPublishingRepository_Deferred(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	publisher := &recordingPublisher{}
+	outbox := NewMemoryOutbox(1)
+	buf := new(bytes.Buffer)
+	repo := NewPublishingRepository(NewMapRepository(map[string]DataProvider{}), UserResourceType, nil, nil, outbox, NewTextLogger(buf, LogInfo))
+
+	// without a publisher events wait in the outbox, and those it has no room for are logged
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "1"}}, ctx))
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "2"}}, ctx))
+	assert.Empty(t, publisher.events)
+	assert.Contains(t, buf.String(), ErrOutboxFull.Error())
+
+	relay := &OutboxRelay{Outbox: outbox, Publisher: publisher, Interval: time.Hour}
+	done := make(chan error)
+	go func() { done <- relay.Run(ctx) }()
+	require.Eventually(t, func() bool {
+		pending, _ := outbox.Pending(0, ctx)
+		return len(pending) == 0
+	}, time.Second, time.Millisecond)
+
+	// the relay is woken by the append rather than its interval
+	require.Nil(t, repo.Delete("1", "", ctx))
+	require.Eventually(t, func() bool {
+		pending, _ := outbox.Pending(0, ctx)
+		return len(pending) == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	require.Len(t, publisher.events, 2)
+	assert.Equal(t, ChangeCreate, publisher.events[0].Op)
+	assert.Equal(t, ChangeDelete, publisher.events[1].Op)
+}
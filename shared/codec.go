@@ -0,0 +1,335 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Names of the codecs, see CodecByName
+const (
+	CodecJSON        = "json"
+	CodecCBOR        = "cbor"
+	CodecMessagePack = "msgpack"
+)
+
+// Serializes resources, list responses and other values in a format of its own. Resources and the resources
+// of list responses are encoded as MarshalJSON encodes them: the attributes and excludedAttributes parameters
+// and the returned characteristic of the schema decide which attributes are written, in the order of the
+// schema. JSON is for the HTTP API; CBOR and MessagePack are for internal consumers of high throughput, i.e.
+// export jobs and event sinks, which take the smaller and faster binary formats. Other values are encoded as
+// encoding/json would encode them, maps with their keys sorted.
+type Codec interface {
+	Name() string
+	// the media type of the output, i.e. for the Content-Type of a response
+	ContentType() string
+	Marshal(v interface{}, sch *Schema, attributes []string, excludedAttributes []string, options ...MarshalOption) ([]byte, error)
+}
+
+var (
+	// MarshalJSON behind a Codec
+	JSONCodec Codec = jsonCodec{}
+	// RFC 8949 Concise Binary Object Representation, floats always written in 64 bits
+	CBORCodec Codec = &binaryCodec{name: CodecCBOR, contentType: "application/cbor", encode: encodeCBOR}
+	// MessagePack, floats always written in 64 bits
+	MessagePackCodec Codec = &binaryCodec{name: CodecMessagePack, contentType: "application/vnd.msgpack", encode: encodeMessagePack}
+)
+
+// The codec of the name, one of CodecJSON, CodecCBOR and CodecMessagePack
+func CodecByName(name string) (Codec, bool) {
+	switch name {
+	case CodecJSON:
+		return JSONCodec, true
+	case CodecCBOR:
+		return CBORCodec, true
+	case CodecMessagePack:
+		return MessagePackCodec, true
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return CodecJSON }
+func (jsonCodec) ContentType() string { return "application/scim+json" }
+
+func (jsonCodec) Marshal(v interface{}, sch *Schema, attributes []string, excludedAttributes []string, options ...MarshalOption) ([]byte, error) {
+	return MarshalJSON(v, sch, attributes, excludedAttributes, options...)
+}
+
+// a codec writing the values projected by project
+type binaryCodec struct {
+	name        string
+	contentType string
+	encode      func(buf *bytes.Buffer, v interface{}) error
+}
+
+func (c binaryCodec) Name() string        { return c.name }
+func (c binaryCodec) ContentType() string { return c.contentType }
+
+func (c binaryCodec) Marshal(v interface{}, sch *Schema, attributes []string, excludedAttributes []string, options ...MarshalOption) ([]byte, error) {
+	projected, err := project(v, sch, attributes, excludedAttributes, options...)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := c.encode(buf, projected); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A value reduced to what binary codecs write, the encoders failing on anything else: nil, bool, int64, float64, string, []interface{} and fields.
+// Resources become fields in the order of their schema, and other maps fields with their keys sorted.
+type fields []field
+
+type field struct {
+	name  string
+	value interface{}
+}
+
+// the value as a codec writes it, with the attributes of resources filtered as MarshalJSON filters them
+func project(v interface{}, sch *Schema, attributes []string, excludedAttributes []string, options ...MarshalOption) (interface{}, error) {
+	abs := abstractMarshalHelper{
+		Guide:              sch,
+		Attributes:         attributes,
+		ExcludedAttributes: excludedAttributes,
+		Options:            options,
+	}
+	for _, option := range options {
+		option(&abs)
+	}
+
+	switch v := v.(type) {
+	case DataProvider:
+		opt, err := abs.newEncOpts()
+		if err != nil {
+			return nil, err
+		}
+		return projectValue(reflect.ValueOf(v.GetData()), opt, abs.guide())
+
+	case *ListResponse:
+		resources := make([]interface{}, 0, len(v.Resources))
+		for _, dp := range v.Resources {
+			if len(abs.ItemSchemas) > 0 {
+				dp = withResourceTypeSchema(dp, abs.ItemSchemas)
+			}
+			resource, err := project(dp, sch, attributes, excludedAttributes, options...)
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, resource)
+		}
+		return projectListResponse(v, resources)
+
+	case *ChangeEvent:
+		if v.Document == nil || sch == nil {
+			return projectJSON(v)
+		}
+		envelope := *v
+		envelope.Document = nil
+		projected, err := projectJSON(&envelope)
+		if err != nil {
+			return nil, err
+		}
+		document, err := project(&Resource{Complex: v.Document}, sch, attributes, excludedAttributes, options...)
+		if err != nil {
+			return nil, err
+		}
+		// in the order projectJSON writes the fields of the envelope
+		event := append(projected.(fields), field{"document", document})
+		sort.SliceStable(event, func(i, j int) bool { return event[i].name < event[j].name })
+		return event, nil
+
+	default:
+		return projectJSON(v)
+	}
+}
+
+// the list response as listResponseMarshalHelper writes it, with the resources projected already
+func projectListResponse(lr *ListResponse, resources []interface{}) (interface{}, error) {
+	schemas := lr.Schemas
+	if len(schemas) == 0 {
+		schemas = []string{ListResponseUrn}
+	}
+	startIndex := lr.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	extensions := fields{}
+	if lr.Partial {
+		schemas = append(append([]string{}, schemas...), PartialListResponseUrn)
+		extensions = append(extensions, field{PartialListResponseUrn, fields{{"partial", true}, {"reason", "timeout"}}})
+	}
+	if len(lr.Watermark) > 0 {
+		schemas = append(append([]string{}, schemas...), DeltaListResponseUrn)
+		deleted, err := projectJSON(lr.Deleted)
+		if err != nil {
+			return nil, err
+		}
+		if deleted == nil {
+			deleted = []interface{}{}
+		}
+		extensions = append(extensions, field{DeltaListResponseUrn, fields{{"watermark", lr.Watermark}, {"deleted", deleted}}})
+	}
+
+	listed := make([]interface{}, 0, len(schemas))
+	for _, schema := range schemas {
+		listed = append(listed, schema)
+	}
+	return append(fields{
+		{"schemas", listed},
+		{"totalResults", int64(lr.TotalResults)},
+		{"itemsPerPage", int64(len(lr.Resources))},
+		{"startIndex", int64(startIndex)},
+		{"Resources", resources},
+	}, extensions...), nil
+}
+
+// mirrors the encoders of MarshalJSON, see newTypeEncoder
+func projectValue(v reflect.Value, opts encOpts, attr *Attribute) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		return projectValue(v.Elem(), opts, attr)
+	}
+
+	if attr.MultiValued {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+		default:
+			return nil, unsupportedType(v, attr)
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		elemAttr := attr.Clone()
+		elemAttr.MultiValued = false
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := projectValue(v.Index(i), opts, elemAttr)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	switch {
+	case attr.ExpectsBool() && v.Kind() == reflect.Bool:
+		return v.Bool(), nil
+
+	case attr.ExpectsInteger():
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int(), nil
+		}
+
+	case attr.ExpectsFloat():
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f := v.Float()
+			if math.IsInf(f, 0) || math.IsNaN(f) {
+				return nil, Error.Text("unsupported float: %s", strconv.FormatFloat(f, 'g', -1, 64))
+			}
+			return f, nil
+		}
+
+	case attr.ExpectsString() && v.Kind() == reflect.String:
+		if v.Type() == numberType {
+			return projectNumber(json.Number(v.String()))
+		}
+		return v.String(), nil
+
+	case attr.ExpectsComplex() && v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			return nil, nil
+		}
+		projected := make(fields, 0, len(attr.SubAttributes))
+		for _, subAttr := range attr.SubAttributes {
+			val := mapIndexByName(v, subAttr.Name)
+			if !opts.shouldEncode(val, subAttr) {
+				continue
+			}
+			value, err := projectValue(val, opts, subAttr)
+			if err != nil {
+				return nil, err
+			}
+			projected = append(projected, field{subAttr.Name, value})
+		}
+		return projected, nil
+	}
+	return nil, unsupportedType(v, attr)
+}
+
+// the error of unsupportedTypeEncoder
+func unsupportedType(v reflect.Value, attr *Attribute) error {
+	return Error.InvalidType(attr.Assist.FullPath, attr.TypeExpectation(), v.Type().String())
+}
+
+// the value as encoding/json encodes it, numbers integers where they are whole
+func projectJSON(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return projectGeneric(generic)
+}
+
+func projectGeneric(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return projectNumber(v)
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, each := range v {
+			value, err := projectGeneric(each)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		projected := make(fields, 0, len(v))
+		for _, name := range names {
+			value, err := projectGeneric(v[name])
+			if err != nil {
+				return nil, err
+			}
+			projected = append(projected, field{name, value})
+		}
+		return projected, nil
+	}
+	return v, nil
+}
+
+func projectNumber(n json.Number) (interface{}, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, Error.Text("invalid number literal %q", n.String())
+	}
+	return f, nil
+}
@@ -0,0 +1,157 @@
+package shared
+
+import (
+	"bytes"
+	hexadecimal "encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCodec_Encoders(t *testing.T) {
+	for _, test := range []struct {
+		value   interface{}
+		cbor    string
+		msgpack string
+	}{
+		// the CBOR of the examples of RFC 8949 appendix A
+		{int64(0), "00", "00"},
+		{int64(23), "17", "17"},
+		{int64(24), "1818", "18"},
+		{int64(128), "1880", "cc80"},
+		{int64(1000), "1903e8", "cd03e8"},
+		{int64(1000000), "1a000f4240", "ce000f4240"},
+		{int64(1000000000000), "1b000000e8d4a51000", "cf000000e8d4a51000"},
+		{int64(-1), "20", "ff"},
+		{int64(-33), "3820", "d0df"},
+		{int64(-1000), "3903e7", "d1fc18"},
+		{1.1, "fb3ff199999999999a", "cb3ff199999999999a"},
+		{false, "f4", "c2"},
+		{true, "f5", "c3"},
+		{nil, "f6", "c0"},
+		{"", "60", "a0"},
+		{"IETF", "6449455446", "a449455446"},
+		{[]interface{}{}, "80", "90"},
+		{[]interface{}{int64(1), int64(2), int64(3)}, "83010203", "93010203"},
+		{fields{{"a", int64(1)}, {"b", []interface{}{int64(2), int64(3)}}}, "a26161016162820203", "82a16101a162920203"},
+	} {
+		for _, encoder := range []struct {
+			name   string
+			encode func(buf *bytes.Buffer, v interface{}) error
+			expect string
+		}{
+			{"cbor", encodeCBOR, test.cbor},
+			{"msgpack", encodeMessagePack, test.msgpack},
+		} {
+			buf := new(bytes.Buffer)
+			require.Nil(t, encoder.encode(buf, test.value))
+			assert.Equal(t, encoder.expect, hexadecimal.EncodeToString(buf.Bytes()), "%s of %v", encoder.name, test.value)
+		}
+	}
+
+	// lengths beyond the fix formats
+	long := string(make([]byte, 300))
+	buf := new(bytes.Buffer)
+	encodeCBOR(buf, long)
+	assert.Equal(t, "79012c", hexadecimal.EncodeToString(buf.Bytes()[:3]))
+	buf.Reset()
+	encodeMessagePack(buf, long)
+	assert.Equal(t, "da012c", hexadecimal.EncodeToString(buf.Bytes()[:3]))
+
+	// values project does not produce are refused rather than left out
+	for _, encode := range []func(buf *bytes.Buffer, v interface{}) error{encodeCBOR, encodeMessagePack} {
+		assert.NotNil(t, encode(new(bytes.Buffer), []interface{}{int64(1), map[string]interface{}{}}))
+		assert.NotNil(t, encode(new(bytes.Buffer), fields{{"a", int32(1)}}))
+	}
+}
+
+func TestCodec_Marshal(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+	r.Complex["password"] = "t0ps3cret"
+	lr := &ListResponse{TotalResults: 3, StartIndex: 1, Resources: []DataProvider{r}, Watermark: "w"}
+	event := &ChangeEvent{EventId: "e1", ResourceType: UserResourceType, Op: ChangeUpdate, Id: r.GetId(), Document: r.Complex}
+
+	for _, test := range []struct {
+		value      interface{}
+		attributes []string
+		excluded   []string
+	}{
+		{r, nil, nil},
+		{r, []string{"userName", "name.givenName"}, nil},
+		{r, nil, []string{"emails", "meta"}},
+		{lr, nil, nil},
+		{lr, []string{"userName"}, nil},
+		{&ExportJob{Id: "1", Format: ExportCBOR, Resources: 2}, nil, nil},
+		{event, nil, nil},
+		{&ChangeEvent{EventId: "e2", ResourceType: UserResourceType, Op: ChangeDelete, Id: r.GetId()}, nil, nil},
+	} {
+		// every codec writes what MarshalJSON writes
+		expect, err := JSONCodec.Marshal(test.value, sch, test.attributes, test.excluded)
+		require.Nil(t, err)
+		var plain interface{}
+		require.Nil(t, json.Unmarshal(expect, &plain))
+
+		projected, err := project(test.value, sch, test.attributes, test.excluded)
+		require.Nil(t, err)
+		assert.Equal(t, plain, unproject(projected))
+		assert.NotContains(t, string(expect), "t0ps3cret")
+
+		for _, codec := range []Codec{CBORCodec, MessagePackCodec} {
+			raw, err := codec.Marshal(test.value, sch, test.attributes, test.excluded)
+			require.Nil(t, err)
+			buf := new(bytes.Buffer)
+			if codec == CBORCodec {
+				require.Nil(t, encodeCBOR(buf, projected))
+			} else {
+				require.Nil(t, encodeMessagePack(buf, projected))
+			}
+			assert.Equal(t, buf.Bytes(), raw, codec.Name())
+		}
+	}
+
+	// attributes of the schema are written in its order, as in JSON
+	projected, err := project(r, sch, []string{"userName", "displayName"}, nil)
+	require.Nil(t, err)
+	names := make([]string, 0)
+	for _, f := range projected.(fields) {
+		names = append(names, f.name)
+	}
+	assert.Equal(t, []string{"schemas", "id", "userName", "displayName"}, names)
+
+	r.Complex["active"] = "yes"
+	_, err = CBORCodec.Marshal(r, sch, nil, nil)
+	assert.NotNil(t, err)
+
+	for _, name := range []string{CodecJSON, CodecCBOR, CodecMessagePack} {
+		codec, ok := CodecByName(name)
+		require.True(t, ok)
+		assert.Equal(t, name, codec.Name())
+	}
+	_, ok := CodecByName("xml")
+	assert.False(t, ok)
+}
+
+// the projected value as encoding/json decodes the JSON of it
+func unproject(v interface{}) interface{} {
+	switch v := v.(type) {
+	case fields:
+		m := make(map[string]interface{}, len(v))
+		for _, f := range v {
+			m[f.name] = unproject(f.value)
+		}
+		return m
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, each := range v {
+			values = append(values, unproject(each))
+		}
+		return values
+	case int64:
+		return float64(v)
+	}
+	return v
+}
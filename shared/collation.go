@@ -0,0 +1,87 @@
+package shared
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var collation = struct {
+	sync.RWMutex
+	tag    language.Tag
+	turkic bool
+}{tag: language.Und}
+
+// Compare strings of the locale, i.e. 'tr' or 'de-DE', from now on: the dotted and dotless i of Turkish and
+// Azerbaijani fold as they do there, and the map repository sorts strings by the collation of the locale
+// instead of by byte. An empty locale restores the default of neither. Meant to be called while the server
+// starts, like RegisterFilterOperator.
+func SetCollationLocale(locale string) error {
+	tag := language.Und
+	if len(locale) > 0 {
+		var err error
+		if tag, err = language.Parse(locale); err != nil {
+			return Error.InvalidParam("locale", "a BCP 47 language tag, i.e. 'tr'", locale)
+		}
+	}
+	base, _ := tag.Base()
+
+	collation.Lock()
+	defer collation.Unlock()
+	collation.tag = tag
+	collation.turkic = base.String() == "tr" || base.String() == "az"
+	return nil
+}
+
+// Fold the case of the string by full Unicode case folding, the way string values of attributes that are not
+// caseExact are compared in filters and uniqueness checks, so that 'STRASSE' equals 'straße'.
+func FoldCase(s string) string {
+	collation.RLock()
+	tag, turkic := collation.tag, collation.turkic
+	collation.RUnlock()
+
+	if !turkic && isASCII(s) {
+		return strings.ToLower(s)
+	}
+	// casers are stateful, a new one per call keeps this safe for concurrent use
+	if turkic {
+		s = cases.Lower(tag).String(s)
+	}
+	return cases.Fold().String(s)
+}
+
+// the order the map repository sorts strings in, -1, 0 or 1: the collation of the locale set with
+// SetCollationLocale, or by byte if none is set; the returned function is not safe for concurrent use
+func newStringComparison() func(a, b string) int {
+	collation.RLock()
+	tag := collation.tag
+	collation.RUnlock()
+
+	if tag == language.Und {
+		return compareBytes
+	}
+	return collate.New(tag).CompareString
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func compareBytes(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
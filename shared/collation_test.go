@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFoldCase(t *testing.T) {
+	defer SetCollationLocale("")
+
+	assert.Equal(t, "david", FoldCase("DaViD"))
+	assert.Equal(t, FoldCase("STRASSE"), FoldCase("straße"))
+	assert.NotEqual(t, FoldCase("ISTANBUL"), FoldCase("İstanbul"))
+
+	require.Nil(t, SetCollationLocale("tr"))
+	assert.Equal(t, FoldCase("ISPARTA"), FoldCase("ısparta"))
+	assert.Equal(t, FoldCase("İSTANBUL"), FoldCase("istanbul"))
+
+	assert.IsType(t, &InvalidParamError{}, SetCollationLocale("not a locale"))
+}
+
+func TestCollation(t *testing.T) {
+	defer SetCollationLocale("")
+
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"1": &Resource{Complex: Complex{"id": "1", "userName": "straße", "displayName": "Zoe"}},
+		"2": &Resource{Complex: Complex{"id": "2", "userName": "alice", "displayName": "Émile"}},
+		"3": &Resource{Complex: Complex{"id": "3", "userName": "bob", "displayName": "Anna"}},
+	})
+	search := func(filter string) []string {
+		lr, err := repo.Search(SearchRequest{Filter: filter, SortBy: "displayName", StartIndex: 1, Count: 10}, context.Background())
+		require.Nil(t, err, filter)
+		ids := make([]string, 0)
+		for _, r := range lr.Resources {
+			ids = append(ids, r.GetId())
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"1"}, search(`userName eq "STRASSE"`))
+	// by byte, É comes after Z
+	assert.Equal(t, []string{"3", "1", "2"}, search(`id pr`))
+	require.Nil(t, SetCollationLocale("fr"))
+	assert.Equal(t, []string{"3", "2", "1"}, search(`id pr`))
+
+	err = ValidateUniqueness(&Resource{Complex: Complex{"id": "4", "userName": "STRASSE"}}, sch, repo, nil, context.Background())
+	assert.IsType(t, &DuplicateError{}, err)
+}
@@ -0,0 +1,321 @@
+package shared
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A resource held in less memory than a Resource, for repositories keeping many of them, i.e. through a bulk
+// import of a million users. Instead of a map per complex value, with a copy of every attribute name decoded
+// from JSON, only the assigned attributes are kept, as slots of a layout derived from the schema and shared
+// by all resources of it; values of unknown attributes are kept as they are. GetData expands the resource
+// into a new Complex on every call, so changes to it are not reflected in the compact resource: compact it
+// again instead.
+type CompactResource struct {
+	layout *compactLayout
+	fields compactComplex
+}
+
+// Compact the data of the provider by the schema, see CompactResource
+func Compact(provider DataProvider, sch *Schema) *CompactResource {
+	layout := layoutOf(sch)
+	return &CompactResource{
+		layout: layout,
+		fields: layout.compact(provider.GetData()),
+	}
+}
+
+func (r *CompactResource) GetId() string {
+	if id, ok := r.layout.lookup(r.fields, "id").(string); ok {
+		return id
+	}
+	return ""
+}
+
+func (r *CompactResource) GetData() Complex {
+	return Complex(r.layout.expand(r.fields))
+}
+
+// A new Resource of the data, to be modified
+func (r *CompactResource) Expand() *Resource {
+	return &Resource{Complex: r.GetData()}
+}
+
+// the assigned attributes of a complex value, ordered by slot
+type compactComplex []compactField
+
+type compactField struct {
+	slot  int
+	value interface{}
+}
+
+// the attributes not in the schema, by name, are kept in a field of this slot
+const unknownSlot = -1
+
+// the slots of the sub attributes of a complex attribute, or of the attributes of a schema
+type compactLayout struct {
+	attrs []*Attribute
+	slots map[string]int // the lower case names of the attributes
+	sub   []*compactLayout
+}
+
+var compactLayouts = struct {
+	sync.RWMutex
+	bySchema map[*Schema]*compactLayout
+}{bySchema: make(map[*Schema]*compactLayout)}
+
+func layoutOf(sch *Schema) *compactLayout {
+	compactLayouts.RLock()
+	layout, ok := compactLayouts.bySchema[sch]
+	compactLayouts.RUnlock()
+	// a schema that got extensions added since needs a new layout
+	if ok && len(layout.attrs) == len(sch.Attributes) {
+		return layout
+	}
+
+	layout = newCompactLayout(sch.Attributes)
+	compactLayouts.Lock()
+	compactLayouts.bySchema[sch] = layout
+	compactLayouts.Unlock()
+	return layout
+}
+
+func newCompactLayout(attrs []*Attribute) *compactLayout {
+	layout := &compactLayout{
+		attrs: attrs,
+		slots: make(map[string]int, len(attrs)),
+		sub:   make([]*compactLayout, len(attrs)),
+	}
+	for i, attr := range attrs {
+		layout.slots[strings.ToLower(attr.Name)] = i
+		if attr.Type == TypeComplex {
+			layout.sub[i] = newCompactLayout(attr.SubAttributes)
+		}
+	}
+	return layout
+}
+
+func (l *compactLayout) slot(name string) (int, bool) {
+	if i, ok := l.slots[name]; ok {
+		return i, true
+	}
+	i, ok := l.slots[strings.ToLower(name)]
+	return i, ok
+}
+
+func (l *compactLayout) compact(data map[string]interface{}) compactComplex {
+	fields := make(compactComplex, 0, len(data))
+	var unknown map[string]interface{}
+	for k, v := range data {
+		i, ok := l.slot(k)
+		if !ok {
+			if unknown == nil {
+				unknown = make(map[string]interface{})
+			}
+			unknown[k] = v
+			continue
+		}
+		fields = append(fields, compactField{slot: i, value: l.compactValue(i, v)})
+	}
+	// insertion sort, complex values have few attributes
+	for i := 1; i < len(fields); i++ {
+		for j := i; j > 0 && fields[j].slot < fields[j-1].slot; j-- {
+			fields[j], fields[j-1] = fields[j-1], fields[j]
+		}
+	}
+	if unknown != nil {
+		fields = append(fields, compactField{slot: unknownSlot, value: unknown})
+	}
+	return fields
+}
+
+func (l *compactLayout) compactValue(i int, v interface{}) interface{} {
+	sub := l.sub[i]
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if sub != nil {
+			return sub.compact(value)
+		}
+	case []interface{}:
+		// copied, so that the compact resource does not change with the data it was made of
+		elements := make([]interface{}, len(value))
+		for j, element := range value {
+			if m, ok := element.(map[string]interface{}); ok && sub != nil {
+				elements[j] = sub.compact(m)
+			} else {
+				elements[j] = element
+			}
+		}
+		return elements
+	}
+	return v
+}
+
+func (l *compactLayout) lookup(fields compactComplex, name string) interface{} {
+	i, ok := l.slot(name)
+	if !ok {
+		return nil
+	}
+	for _, f := range fields {
+		if f.slot == i {
+			return f.value
+		}
+	}
+	return nil
+}
+
+func (l *compactLayout) expand(fields compactComplex) map[string]interface{} {
+	data := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if f.slot == unknownSlot {
+			for k, v := range f.value.(map[string]interface{}) {
+				data[k] = v
+			}
+			continue
+		}
+		data[l.attrs[f.slot].Name] = l.expandValue(f.slot, f.value)
+	}
+	return data
+}
+
+func (l *compactLayout) expandValue(i int, v interface{}) interface{} {
+	sub := l.sub[i]
+	switch value := v.(type) {
+	case compactComplex:
+		return sub.expand(value)
+	case []interface{}:
+		elements := make([]interface{}, len(value))
+		for j, element := range value {
+			if c, ok := element.(compactComplex); ok {
+				elements[j] = sub.expand(c)
+			} else {
+				elements[j] = element
+			}
+		}
+		return elements
+	default:
+		return v
+	}
+}
+
+// Decorates a repository keeping the data providers it is given, i.e. one of NewSearchableMapRepository, so
+// that it keeps them as CompactResource of the schema. Resources read from it are expanded into new ones,
+// which, unlike those of the map repository alone, can be changed without changing the stored ones. Filters
+// are evaluated on expanded resources, so searches trade time for the memory saved. The optional interfaces of
+// the repository decorated are forwarded.
+func NewCompactRepository(repo Repository, sch *Schema) Repository {
+	return &compactRepository{repo: repo, schema: sch}
+}
+
+type compactRepository struct {
+	repo   Repository
+	schema *Schema
+}
+
+func (r *compactRepository) expand(dp DataProvider) DataProvider {
+	if c, ok := dp.(*CompactResource); ok {
+		return c.Expand()
+	}
+	return dp
+}
+
+func (r *compactRepository) Create(provider DataProvider, ctx context.Context) error {
+	return r.repo.Create(Compact(provider, r.schema), ctx)
+}
+
+func (r *compactRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	dp, err := r.repo.Get(id, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.expand(dp), nil
+}
+
+func (r *compactRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	return ResourceExists(r.repo, id, version, ctx)
+}
+
+func (r *compactRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	return CountExisting(r.repo, ids, ctx)
+}
+
+// The values are sliced from the expanded resource, as those the repository decorated holds are compacted
+func (r *compactRepository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	dp, err := r.Get(id, "", ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	all, _ := dp.GetData()[attribute].([]interface{})
+	return SliceValues(all, startIndex, count), len(all), nil
+}
+
+func (r *compactRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	return r.repo.GetAll(ctx)
+}
+
+func (r *compactRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *compactRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return r.repo.Update(id, version, Compact(provider, r.schema), ctx)
+}
+
+// The resources are stored compacted, which the repository decorated cannot patch in place: the group is read,
+// patched and written back at the version read.
+func (r *compactRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	dp, err := r.repo.Get(id, version, ctx)
+	if err != nil {
+		return err
+	}
+	group := Complex(deepCopy(map[string]interface{}(r.expand(dp).GetData())).(map[string]interface{}))
+	existing, _ := group["members"].([]interface{})
+	group["members"] = MembershipDelta{Add: adds, Remove: removes}.Apply(existing)
+	current, _ := group["meta"].(map[string]interface{})
+	if current == nil {
+		current = make(map[string]interface{})
+		group["meta"] = current
+	}
+	read, _ := current["version"].(string)
+	for k, v := range meta {
+		current[k] = v
+	}
+	return r.repo.Update(id, read, Compact(&Resource{Complex: group}, r.schema), ctx)
+}
+
+func (r *compactRepository) Delete(id, version string, ctx context.Context) error {
+	return r.repo.Delete(id, version, ctx)
+}
+
+func (r *compactRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	lr, err := r.repo.Search(payload, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, dp := range lr.Resources {
+		lr.Resources[i] = r.expand(dp)
+	}
+	return lr, nil
+}
+
+func (r *compactRepository) SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	return r.repo.(SearchOrderer).SearchOrder(sortBy, ascending)
+}
+
+func (r *compactRepository) ReindexUnique(ctx context.Context) error {
+	return r.repo.(UniqueIndexer).ReindexUnique(ctx)
+}
+
+func (r *compactRepository) PurgeDeleted(before time.Time, ctx context.Context) (int, error) {
+	return r.repo.(DeletedPurger).PurgeDeleted(before, ctx)
+}
+
+func (r *compactRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *compactRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
@@ -0,0 +1,134 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"testing"
+)
+
+func TestCompactResource(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+	r.Complex["unknownAttribute"] = "kept"
+
+	c := Compact(r, sch)
+	assert.Equal(t, r.GetId(), c.GetId())
+	assert.Equal(t, r.GetData(), c.GetData())
+
+	// the compact resource changes neither with the data it was made of nor with the data it expands into
+	r.Complex["userName"] = "changed"
+	r.Complex["emails"].([]interface{})[0].(map[string]interface{})["value"] = "changed@example.com"
+	expanded := c.Expand()
+	assert.NotEqual(t, "changed", expanded.Complex["userName"])
+	expanded.Complex["displayName"] = "changed"
+	assert.NotEqual(t, "changed", c.GetData()["displayName"])
+
+	expected, err := MarshalJSON(c.Expand(), sch, nil, nil)
+	require.Nil(t, err)
+	actual, err := MarshalJSON(c, sch, nil, nil)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+func TestCompactRepository(t *testing.T) {
+	ctx := context.Background()
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	repo := NewCompactRepository(NewSearchableMapRepository(sch, map[string]DataProvider{}), sch)
+
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "foo", "userName": "david"}}, ctx))
+	dp, err := repo.Get("foo", "", ctx)
+	require.Nil(t, err)
+	require.IsType(t, &Resource{}, dp)
+	dp.(*Resource).Complex["userName"] = "changed"
+
+	lr, err := repo.Search(SearchRequest{Filter: `userName eq "david"`, StartIndex: 1, Count: 10}, ctx)
+	require.Nil(t, err)
+	require.Len(t, lr.Resources, 1)
+	assert.IsType(t, &Resource{}, lr.Resources[0])
+
+	require.Nil(t, repo.Update("foo", "", &Resource{Complex: Complex{"id": "foo", "userName": "alice"}}, ctx))
+	n, err := repo.Count(`userName eq "alice"`, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// the optional interfaces of the repository decorated are forwarded
+	_, ok := repo.(ExistenceChecker)
+	assert.True(t, ok)
+	exists, err := ResourceExists(repo, "foo", "", ctx)
+	require.Nil(t, err)
+	assert.True(t, exists)
+	_, ok = AsMemberPatcher(repo)
+	assert.True(t, ok)
+	_, ok = AsUniqueIndexer(repo)
+	assert.False(t, ok)
+	require.Nil(t, repo.Update("foo", "", &Resource{Complex: Complex{"id": "foo", "userName": "alice", "emails": []interface{}{
+		map[string]interface{}{"value": "a@example.com"},
+		map[string]interface{}{"value": "b@example.com"},
+	}}}, ctx))
+	values, total, err := SliceAttribute(repo, "foo", "emails", 2, 1, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "b@example.com"}}, values)
+}
+
+// bulk import sized: the bytes retained per stored user
+func BenchmarkRetainedResource(b *testing.B) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(b, err)
+	for _, bench := range []struct {
+		name   string
+		retain func(r *Resource) DataProvider
+	}{
+		{"Resource", func(r *Resource) DataProvider { return r }},
+		{"CompactResource", func(r *Resource) DataProvider { return Compact(r, sch) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			retained := make([]DataProvider, b.N)
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			for i := 0; i < b.N; i++ {
+				// parsed like request bodies are, every resource with its own copy of the attribute names
+				r, _, err := ParseResource("../resources/tests/user_1.json")
+				if err != nil {
+					b.Fatal(err)
+				}
+				r.Complex["id"] = fmt.Sprint(i)
+				retained[i] = bench.retain(r)
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "retained-B/op")
+			runtime.KeepAlive(retained)
+		})
+	}
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(b, err)
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(b, err)
+	for _, bench := range []struct {
+		name string
+		dp   DataProvider
+	}{
+		{"Resource", r},
+		{"CompactResource", Compact(r, sch)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := MarshalJSON(bench.dp, sch, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
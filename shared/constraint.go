@@ -0,0 +1,237 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A uniqueness constraint the server declares on an internal schema, on top of the attributes the schema
+// marks unique one by one: no two resources may hold the same combination of values of the attributes, i.e.
+// userName per tenant with 'userName' and 'urn:example:params:scim:schemas:extension:tenant:2.0:User:id'.
+// Every value of a multiValued attribute counts, so that 'emails.value' alone keeps two users from sharing
+// an email address. Resources lacking any of the attributes are not constrained.
+type UniqueConstraint struct {
+	Attributes []string // attribute paths, as given
+	Global     bool     // also checked against the repositories of the other resource types
+	paths      []*AttributePath
+	attrs      []*Attribute
+}
+
+// Declare a constraint on the attributes, see UniqueConstraint, enforced by ValidateUniqueness and
+// ValidateUniquenessBatch and indexed by repositories implementing UniqueIndexer. Fails when a path does not
+// resolve against the schema or filters values.
+func (s *Schema) DeclareUnique(attributes []string, global bool) error {
+	if len(attributes) == 0 {
+		return Error.Text("unique constraint without attributes")
+	}
+	c := &UniqueConstraint{Attributes: attributes, Global: global}
+	for _, text := range attributes {
+		ap, err := ParseAttributePath(text)
+		if err != nil {
+			return err
+		}
+		if ap.Filter != nil {
+			return Error.InvalidPath(text, "filter in unique constraint")
+		}
+		attr, err := ap.Resolve(s)
+		if err != nil {
+			return err
+		}
+		if attr.Type == TypeComplex {
+			return Error.InvalidPath(text, "complex attribute in unique constraint")
+		}
+		c.paths = append(c.paths, ap)
+		c.attrs = append(c.attrs, attr)
+	}
+	s.Constraints = append(s.Constraints, c)
+	return nil
+}
+
+// The attribute paths of the constraint as they are stored, i.e. 'emails.value', with the extension
+// namespace of attributes of extensions, or nil for constraints not declared with Schema.DeclareUnique
+func (c *UniqueConstraint) Paths() [][]string {
+	if len(c.paths) == 0 {
+		return nil
+	}
+	paths := make([][]string, 0, len(c.paths))
+	for _, ap := range c.paths {
+		paths = append(paths, ap.names())
+	}
+	return paths
+}
+
+// reported as the path of a DuplicateError
+func (c *UniqueConstraint) String() string {
+	return strings.Join(c.Attributes, ", ")
+}
+
+// the values of each attribute the data holds, nil when it lacks any of them
+func (c *UniqueConstraint) values(data Complex) [][]interface{} {
+	values := make([][]interface{}, 0, len(c.paths))
+	for i, ap := range c.paths {
+		assigned := valuesAt(data, ap, c.attrs[i])
+		if len(assigned) == 0 {
+			return nil
+		}
+		values = append(values, assigned)
+	}
+	return values
+}
+
+// the assigned values at a path without filter, every element of multiValued attributes on the way
+func valuesAt(data Complex, ap *AttributePath, attr *Attribute) []interface{} {
+	current := []interface{}{map[string]interface{}(data)}
+	for _, name := range ap.names() {
+		next := make([]interface{}, 0)
+		for _, each := range current {
+			m, ok := each.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			_, v, ok := entryByName(m, name)
+			if !ok || v == nil {
+				continue
+			}
+			if array, ok := v.([]interface{}); ok {
+				next = append(next, array...)
+			} else {
+				next = append(next, v)
+			}
+		}
+		current = next
+	}
+	assigned := make([]interface{}, 0, len(current))
+	for _, v := range current {
+		if attr.Assigned(reflect.ValueOf(v)) {
+			assigned = append(assigned, v)
+		}
+	}
+	return assigned
+}
+
+// a filter matching the resources holding any of the values of every attribute, failing on values that
+// cannot be written as filter strings, see QuoteFilterString
+func (c *UniqueConstraint) filter(values [][]interface{}) (string, error) {
+	clauses := make([]string, 0, len(values))
+	for i, each := range values {
+		terms := make([]string, 0, len(each))
+		for _, v := range each {
+			quoted, err := QuoteFilterString(fmt.Sprintf("%v", v))
+			if err != nil {
+				return "", err
+			}
+			terms = append(terms, c.paths[i].String()+" eq "+quoted)
+		}
+		if len(terms) > 1 {
+			clauses = append(clauses, "("+strings.Join(terms, " or ")+")")
+		} else {
+			clauses = append(clauses, terms[0])
+		}
+	}
+	return strings.Join(clauses, " and "), nil
+}
+
+// the combination of values both hold, one value per attribute, or nil if they hold none in common
+func (c *UniqueConstraint) overlap(values, held [][]interface{}) []interface{} {
+	common := make([]interface{}, 0, len(values))
+	for i := range values {
+		found := false
+		for _, v := range values[i] {
+			for _, h := range held[i] {
+				if uniqueKey(c.attrs[i], v) == uniqueKey(c.attrs[i], h) {
+					common, found = append(common, v), true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return common
+}
+
+// every combination of the values, one value per attribute
+func (c *UniqueConstraint) combinations(values [][]interface{}) [][]interface{} {
+	combinations := [][]interface{}{{}}
+	for _, each := range values {
+		next := make([][]interface{}, 0, len(combinations)*len(each))
+		for _, combination := range combinations {
+			for _, v := range each {
+				next = append(next, append(append([]interface{}{}, combination...), v))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// combinations compare the way the eq filter compares their values
+func (c *UniqueConstraint) key(combination []interface{}) string {
+	keys := make([]string, 0, len(combination))
+	for i, v := range combination {
+		keys = append(keys, uniqueKey(c.attrs[i], v))
+	}
+	return strings.Join(keys, "\x00")
+}
+
+func (c *UniqueConstraint) duplicate(common []interface{}) *DuplicateError {
+	var value interface{} = common
+	if len(common) == 1 {
+		value = common[0]
+	}
+	return Error.Duplicate(c.String(), value).(*DuplicateError)
+}
+
+func (uv *uniquenessValidator) checkConstraint(c *UniqueConstraint, subj *Resource, repo Repository, global []Repository, ctx context.Context) {
+	values := c.values(subj.Complex)
+	if values == nil {
+		return
+	}
+	uv.searchConstraint(c, values, repo, true, ctx)
+	if c.Global {
+		for _, other := range global {
+			if other != repo {
+				uv.searchConstraint(c, values, other, false, ctx)
+			}
+		}
+	}
+}
+
+func (uv *uniquenessValidator) searchConstraint(c *UniqueConstraint, values [][]interface{}, repo Repository, own bool, ctx context.Context) {
+	filter, err := c.filter(values)
+	if err != nil {
+		uv.throw(err, ctx)
+	}
+	lr, err := repo.Search(SearchRequest{Filter: filter, StartIndex: 1, Count: 2}, ctx)
+	if err != nil {
+		if !own {
+			switch err.(type) {
+			case *InvalidFilterError, *InvalidPathError, *NoAttributeError:
+				return
+			}
+		}
+		uv.throw(err, ctx)
+	}
+
+	selfId := updatedResourceId(own, ctx)
+	for _, match := range lr.Resources {
+		if len(selfId) > 0 && match.GetId() == selfId {
+			continue
+		}
+		held := c.values(match.GetData())
+		if held == nil {
+			continue
+		}
+		if common := c.overlap(values, held); common != nil {
+			dup := c.duplicate(common)
+			dup.ExistingId = match.GetId()
+			uv.throw(dup, ctx)
+		}
+	}
+}
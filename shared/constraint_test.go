@@ -0,0 +1,100 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSchema_DeclareUnique(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	assert.Nil(t, sch.DeclareUnique([]string{"Emails.Value"}, false))
+	assert.Nil(t, sch.DeclareUnique([]string{"userName", "title"}, true))
+	assert.Equal(t, [][]string{{"emails", "value"}}, sch.Constraints[0].Paths())
+	assert.Equal(t, [][]string{{"userName"}, {"title"}}, sch.Constraints[1].Paths())
+
+	assert.NotNil(t, sch.DeclareUnique(nil, false))
+	assert.NotNil(t, sch.DeclareUnique([]string{"unknown"}, false))
+	assert.NotNil(t, sch.DeclareUnique([]string{"name"}, false))
+	assert.NotNil(t, sch.DeclareUnique([]string{`emails[type eq "work"].value`}, false))
+	assert.Len(t, sch.Constraints, 2)
+}
+
+func TestValidateUniqueness_Constraints(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	require.Nil(t, sch.DeclareUnique([]string{"emails.value"}, false))
+	require.Nil(t, sch.DeclareUnique([]string{"nickName", "title"}, false))
+
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"existing": &Resource{Complex: Complex{
+			"id":       "existing",
+			"nickName": "david",
+			"title":    "Engineer",
+			"emails": []interface{}{
+				map[string]interface{}{"value": "david@example.com"},
+				map[string]interface{}{"value": "dq@example.com"},
+			},
+		}},
+	})
+	create := context.WithValue(context.Background(), RequestType{}, CreateUser)
+
+	// any of the email addresses conflicts
+	err = ValidateUniqueness(&Resource{Complex: Complex{
+		"id":     "new",
+		"emails": []interface{}{map[string]interface{}{"value": "other@example.com"}, map[string]interface{}{"value": "DQ@example.com"}},
+	}}, sch, repo, nil, create)
+	require.IsType(t, &DuplicateError{}, err)
+	assert.Equal(t, "emails.value", err.(*DuplicateError).Path)
+	assert.Equal(t, "DQ@example.com", err.(*DuplicateError).Value)
+	assert.Equal(t, "existing", err.(*DuplicateError).ExistingId)
+
+	// the combination conflicts, not the values one by one
+	assert.Nil(t, ValidateUniqueness(&Resource{Complex: Complex{"id": "new", "nickName": "david", "title": "Manager"}}, sch, repo, nil, create))
+	assert.Nil(t, ValidateUniqueness(&Resource{Complex: Complex{"id": "new", "nickName": "david"}}, sch, repo, nil, create))
+	err = ValidateUniqueness(&Resource{Complex: Complex{"id": "new", "nickName": "David", "title": "Engineer"}}, sch, repo, nil, create)
+	require.IsType(t, &DuplicateError{}, err)
+	assert.Equal(t, "nickName, title", err.(*DuplicateError).Path)
+	assert.Equal(t, []interface{}{"David", "Engineer"}, err.(*DuplicateError).Value)
+	// the values cannot alter the filter looking for them
+	err = ValidateUniqueness(&Resource{Complex: Complex{"id": "new", "nickName": "david", "title": `x" or id pr or title eq "y`}}, sch, repo, nil, create)
+	assert.IsType(t, &InvalidFilterError{}, err)
+
+	// the resource being replaced does not conflict with itself
+	replace := context.WithValue(context.WithValue(context.Background(), RequestType{}, ReplaceUser), ResourceId{}, "existing")
+	assert.Nil(t, ValidateUniqueness(&Resource{Complex: Complex{"id": "existing", "nickName": "david", "title": "Engineer"}}, sch, repo, nil, replace))
+}
+
+func TestValidateUniquenessBatch_Constraints(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	require.Nil(t, sch.DeclareUnique([]string{"emails.value"}, false))
+
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"existing": &Resource{Complex: Complex{
+			"id":     "existing",
+			"emails": []interface{}{map[string]interface{}{"value": "taken@example.com"}},
+		}},
+	})
+	email := func(value string) []interface{} {
+		return []interface{}{map[string]interface{}{"value": value}}
+	}
+	subjs := []*Resource{
+		{Complex: Complex{"id": "a", "userName": "a", "emails": email("taken@example.com")}},
+		{Complex: Complex{"id": "b", "userName": "b", "emails": email("fresh@example.com")}},
+		{Complex: Complex{"id": "c", "userName": "c", "emails": email("FRESH@example.com")}},
+		{Complex: Complex{"id": "d", "userName": "d"}},
+	}
+
+	errs := ValidateUniquenessBatch(subjs, sch, repo, nil, 10, 2, context.Background())
+	require.Len(t, errs, 4)
+	require.IsType(t, &DuplicateError{}, errs[0])
+	assert.Equal(t, "existing", errs[0].(*DuplicateError).ExistingId)
+	assert.Nil(t, errs[1])
+	require.IsType(t, &DuplicateError{}, errs[2])
+	assert.Empty(t, errs[2].(*DuplicateError).ExistingId)
+	assert.Nil(t, errs[3])
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"strings"
+	"time"
+)
+
+// Parse the value of a dateTime attribute, an xsd:dateTime that must conform to RFC 3339 (RFC 7643 section 2.3.5),
+// i.e. '2008-01-23T04:56:22Z' or '2008-01-23T05:56:22.5+01:00'. The letters T and Z may be lower case.
+func ParseDateTime(text string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.ToUpper(text))
+}
+
+// The layout dateTime attributes are stored in: RFC 3339 in UTC, always to the millisecond
+const dateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Render the time the way dateTime attributes are stored, i.e. '2008-01-23T04:56:22.500Z', see dateTimeLayout.
+// Finer fractions of a second are truncated. All stored values having the same precision, equal instants are
+// equal strings and stored values sort chronologically as strings.
+func FormatDateTime(t time.Time) string {
+	return t.UTC().Format(dateTimeLayout)
+}
+
+// Normalize the dateTime text to the form of FormatDateTime, leaving text that does not parse as it is
+func NormalizeDateTime(text string) string {
+	if t, err := ParseDateTime(text); err == nil {
+		return FormatDateTime(t)
+	}
+	return text
+}
+
+// Order two dateTime values chronologically, to the millisecond they are stored to, returning -1, 0 or 1. Values
+// that do not parse are compared as text.
+func CompareDateTime(a, b string) int {
+	ta, errA := ParseDateTime(a)
+	tb, errB := ParseDateTime(b)
+	ta, tb = ta.Truncate(time.Millisecond), tb.Truncate(time.Millisecond)
+	switch {
+	case errA != nil || errB != nil:
+		return strings.Compare(a, b)
+	case ta.Before(tb):
+		return -1
+	case ta.After(tb):
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalizeDateTime(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		expect string
+	}{
+		{"2008-01-23T04:56:22Z", "2008-01-23T04:56:22.000Z"},
+		{"2008-01-23T05:56:22+01:00", "2008-01-23T04:56:22.000Z"},
+		{"2008-01-23t04:56:22.5z", "2008-01-23T04:56:22.500Z"},
+		{"2008-01-23T04:56:22.1234567Z", "2008-01-23T04:56:22.123Z"},
+		{"2008-01-23", "2008-01-23"},
+		{"yesterday", "yesterday"},
+	} {
+		assert.Equal(t, test.expect, NormalizeDateTime(test.text))
+	}
+}
+
+func TestCompareDateTime(t *testing.T) {
+	assert.Equal(t, 0, CompareDateTime("2008-01-23T04:56:22Z", "2008-01-22T23:56:22-05:00"))
+	assert.Equal(t, -1, CompareDateTime("2008-01-23T04:56:22Z", "2008-01-23T04:56:22.1Z"))
+	assert.Equal(t, 1, CompareDateTime("2008-01-23T04:56:22Z", "2008-01-23T05:56:22+02:00"))
+	assert.Equal(t, 0, CompareDateTime("2008-01-23T04:56:22.5Z", "2008-01-23T04:56:22.5004Z"))
+
+	// stored values sort as strings whatever the precision they were written with
+	assert.True(t, NormalizeDateTime("2008-01-23T04:56:22Z") < NormalizeDateTime("2008-01-23T04:56:22.1Z"))
+}
+
+func TestDateTimeAttributes(t *testing.T) {
+	sch := &Schema{
+		Id: UserUrn,
+		Attributes: []*Attribute{
+			{Name: "userName", Type: TypeString},
+			{Name: enterpriseUrn, Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "hireDate", Type: TypeDateTime},
+			}},
+		},
+	}
+	require.Nil(t, CompileSchema(sch))
+	ctx := context.Background()
+
+	r := &Resource{Complex: Complex{
+		"userName":    "david",
+		enterpriseUrn: map[string]interface{}{"HireDate": "2015-03-01T09:00:00+01:00"},
+	}}
+	require.Nil(t, ValidateType(r, sch, ctx))
+	require.Nil(t, CorrectCase(r, sch, ctx))
+	assert.Equal(t, "2015-03-01T08:00:00.000Z", r.Complex[enterpriseUrn].(map[string]interface{})["hireDate"])
+
+	r = &Resource{Complex: Complex{
+		enterpriseUrn: map[string]interface{}{"hireDate": "March 1st"},
+	}}
+	err := ValidateType(r, sch, ctx)
+	require.IsType(t, &InvalidTypeError{}, err)
+	assert.Equal(t, UserUrn+":"+enterpriseUrn+".hireDate", err.(*InvalidTypeError).Path)
+}
@@ -0,0 +1,102 @@
+package shared
+
+import (
+	"context"
+	"sync"
+)
+
+// Computes the default value of an attribute for a create request, i.e. the preferred language configured for
+// the tenant the request belongs to. Returning nil leaves the attribute unassigned.
+type DefaultValue func(req WebRequest, ctx context.Context) interface{}
+
+// Returns the same default value for every request
+func StaticDefault(value interface{}) DefaultValue {
+	return func(req WebRequest, ctx context.Context) interface{} { return value }
+}
+
+// Returns the default value of the tenant the request belongs to, as told by the tenant function, i.e. from a
+// header or the host. Requests of tenants without a value fall back to the value of the empty tenant, if any.
+func TenantDefault(tenant func(req WebRequest) string, values map[string]interface{}) DefaultValue {
+	return func(req WebRequest, ctx context.Context) interface{} {
+		if value, ok := values[tenant(req)]; ok {
+			return value
+		}
+		return values[""]
+	}
+}
+
+// Registry of attribute default values per resource type, i.e. active defaulting to true. Defaults are only
+// applied on create, to attributes the resource does not carry, after authorization and before the
+// transformers and ValidateRequired, so that required attributes with a default may be omitted by clients.
+type Defaults struct {
+	sync.RWMutex
+	byType map[string][]attributeDefault
+}
+
+type attributeDefault struct {
+	path  string
+	value DefaultValue
+}
+
+func NewDefaults() *Defaults {
+	return &Defaults{byType: make(map[string][]attributeDefault)}
+}
+
+// Register the default value of the attribute at the path, i.e. Register(UserResourceType, "active",
+// StaticDefault(true)). A later registration for the same path replaces the earlier one.
+func (d *Defaults) Register(resourceType, path string, value DefaultValue) *Defaults {
+	d.Lock()
+	defer d.Unlock()
+	defaults := d.byType[resourceType]
+	for i, each := range defaults {
+		if each.path == path {
+			defaults[i].value = value
+			return d
+		}
+	}
+	d.byType[resourceType] = append(defaults, attributeDefault{path: path, value: value})
+	return d
+}
+
+// Whether defaults are registered for the resource type
+func (d *Defaults) Has(resourceType string) bool {
+	if d == nil {
+		return false
+	}
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.byType[resourceType]) > 0
+}
+
+// Assign the defaults of the resource type to the attributes the resource does not carry. The resource is
+// validated for type again when any default was assigned, so that misconfigured values are not stored.
+func (d *Defaults) Apply(resourceType string, r *Resource, sch *Schema, req WebRequest, ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.RLock()
+	defaults := d.byType[resourceType]
+	d.RUnlock()
+
+	assigned := false
+	for _, each := range defaults {
+		if _, _, err := CompilePath(each.path, sch); err != nil {
+			return err
+		}
+		if _, ok := lookupAttribute(r, each.path, sch); ok {
+			continue
+		}
+		value := each.value(req, ctx)
+		if value == nil {
+			continue
+		}
+		if err := assignAttribute(r, each.path, value, sch); err != nil {
+			return err
+		}
+		assigned = true
+	}
+	if !assigned {
+		return nil
+	}
+	return ValidateType(r, sch, ctx)
+}
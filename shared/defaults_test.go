@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	sch := &Schema{
+		Id: UserUrn,
+		Attributes: []*Attribute{
+			{Name: "userName", Type: TypeString},
+			{Name: "active", Type: TypeBoolean},
+			{Name: "preferredLanguage", Type: TypeString},
+			{Name: enterpriseUrn, Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "organization", Type: TypeString},
+			}},
+		},
+	}
+	require.Nil(t, CompileSchema(sch))
+
+	tenant := func(req WebRequest) string { return req.Header("X-Tenant") }
+	defaults := NewDefaults().
+		Register(UserResourceType, "active", StaticDefault(true)).
+		Register(UserResourceType, "preferredLanguage", TenantDefault(tenant, map[string]interface{}{
+			"":     "en-US",
+			"acme": "nl-BE",
+		})).
+		Register(UserResourceType, enterpriseUrn+":organization", TenantDefault(tenant, map[string]interface{}{
+			"acme": "Acme",
+		}))
+	assert.True(t, defaults.Has(UserResourceType))
+	assert.False(t, defaults.Has(GroupResourceType))
+
+	for _, test := range []struct {
+		data      Complex
+		headers   map[string]string
+		assertion func(data Complex)
+	}{
+		{
+			Complex{"userName": "david"},
+			map[string]string{},
+			func(data Complex) {
+				assert.Equal(t, true, data["active"])
+				assert.Equal(t, "en-US", data["preferredLanguage"])
+				assert.NotContains(t, data, enterpriseUrn)
+			},
+		},
+		{
+			// defaults do not override those sent
+			Complex{"userName": "david", "active": false, "preferredLanguage": "fr-FR"},
+			map[string]string{"X-Tenant": "acme"},
+			func(data Complex) {
+				assert.Equal(t, false, data["active"])
+				assert.Equal(t, "fr-FR", data["preferredLanguage"])
+				assert.Equal(t, map[string]interface{}{"organization": "Acme"}, data[enterpriseUrn])
+			},
+		},
+	} {
+		r := &Resource{Complex: test.data}
+		require.Nil(t, defaults.Apply(UserResourceType, r, sch, headerRequest(test.headers), context.Background()))
+		test.assertion(r.Complex)
+	}
+
+	// a later registration replaces the earlier one, values of the wrong type are rejected
+	defaults.Register(UserResourceType, "active", StaticDefault("yes"))
+	err := defaults.Apply(UserResourceType, &Resource{Complex: Complex{"userName": "david"}}, sch, headerRequest{}, context.Background())
+	assert.IsType(t, &InvalidTypeError{}, err)
+
+	assert.Nil(t, (*Defaults)(nil).Apply(UserResourceType, &Resource{Complex: Complex{}}, sch, headerRequest{}, context.Background()))
+}
@@ -0,0 +1,151 @@
+package shared
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// An attribute level change between two snapshots of a resource. The embedded Patch can be applied with ApplyPatch
+// to bring the old snapshot up to date; OldValue holds the value that was removed or replaced.
+type Change struct {
+	Patch
+	OldValue interface{} `json:"oldValue,omitempty"`
+}
+
+// Diff the snapshots of a resource attribute by attribute, in schema order, for change feeds like audit logs or
+// webhook payloads, i.e. from an AfterUpdate hook. Singular complex attributes are descended into; multiValued
+// attributes of complex elements with a value sub attribute yield an add of the new elements and a remove
+// per element that is gone, others a replace as a whole. The meta attribute is left out, and the values of
+// attributes that are never returned, like password, are withheld.
+func Diff(old, new *Resource, sch *Schema) []Change {
+	changes := make([]Change, 0)
+	var oldData, newData map[string]interface{}
+	if old != nil {
+		oldData = old.Complex
+	}
+	if new != nil {
+		newData = new.Complex
+	}
+	diffComplex(oldData, newData, sch.ToAttribute(), "", &changes)
+	return changes
+}
+
+func diffComplex(old, new map[string]interface{}, guide *Attribute, prefix string, changes *[]Change) {
+	for _, attr := range guide.SubAttributes {
+		if len(prefix) == 0 && attr.Name == "meta" {
+			continue
+		}
+
+		_, o, _ := entryByName(old, attr.Name)
+		_, n, _ := entryByName(new, attr.Name)
+		oVal, nVal := reflect.ValueOf(o), reflect.ValueOf(n)
+		path := diffPath(prefix, guide, attr.Name)
+
+		switch {
+		case !attr.Assigned(oVal) && !attr.Assigned(nVal):
+		case !attr.Assigned(oVal):
+			appendChange(changes, attr, Change{Patch: Patch{Op: Add, Path: path, Value: n}})
+		case !attr.Assigned(nVal):
+			appendChange(changes, attr, Change{Patch: Patch{Op: Remove, Path: path}, OldValue: o})
+		case reflect.DeepEqual(o, n):
+		case attr.ExpectsComplex():
+			om, ok1 := o.(map[string]interface{})
+			nm, ok2 := n.(map[string]interface{})
+			if ok1 && ok2 {
+				diffComplex(om, nm, attr, path, changes)
+			} else {
+				appendChange(changes, attr, Change{Patch: Patch{Op: Replace, Path: path, Value: n}, OldValue: o})
+			}
+		case attr.MultiValued:
+			diffMultiValued(o, n, attr, path, changes)
+		default:
+			appendChange(changes, attr, Change{Patch: Patch{Op: Replace, Path: path, Value: n}, OldValue: o})
+		}
+	}
+}
+
+func diffMultiValued(o, n interface{}, attr *Attribute, path string, changes *[]Change) {
+	oElems, ok1 := o.([]interface{})
+	nElems, ok2 := n.([]interface{})
+	if !ok1 || !ok2 || attr.Type != TypeComplex || attr.SubAttribute("value") == nil {
+		appendChange(changes, attr, Change{Patch: Patch{Op: Replace, Path: path, Value: n}, OldValue: o})
+		return
+	}
+
+	kept := make(map[string]bool)
+	for _, elem := range oElems {
+		if containsElement(nElems, elem) {
+			kept[elementValue(elem)] = true
+		}
+	}
+
+	removed := make([]Change, 0)
+	for _, elem := range oElems {
+		if containsElement(nElems, elem) {
+			continue
+		}
+		value := elementValue(elem)
+		if len(value) == 0 || kept[value] {
+			// the element cannot be addressed by its value alone
+			appendChange(changes, attr, Change{Patch: Patch{Op: Replace, Path: path, Value: n}, OldValue: o})
+			return
+		}
+		removed = append(removed, Change{
+			Patch:    Patch{Op: Remove, Path: fmt.Sprintf("%s[value eq %q]", path, value)},
+			OldValue: elem,
+		})
+	}
+
+	added := make([]interface{}, 0)
+	for _, elem := range nElems {
+		if !containsElement(oElems, elem) {
+			added = append(added, elem)
+		}
+	}
+
+	for _, change := range removed {
+		appendChange(changes, attr, change)
+	}
+	if len(added) > 0 {
+		appendChange(changes, attr, Change{Patch: Patch{Op: Add, Path: path, Value: added}})
+	}
+}
+
+func elementValue(elem interface{}) string {
+	if m, ok := elem.(map[string]interface{}); ok {
+		if value, ok := m["value"].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func containsElement(elems []interface{}, elem interface{}) bool {
+	for _, each := range elems {
+		if reflect.DeepEqual(each, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendChange(changes *[]Change, attr *Attribute, change Change) {
+	if attr.Returned == Never {
+		change.Value, change.OldValue = nil, nil
+	}
+	*changes = append(*changes, change)
+}
+
+// paths in the syntax of ApplyPatch: sub attributes are separated by a period, attributes of an extension
+// namespace by a colon
+func diffPath(prefix string, guide *Attribute, name string) string {
+	switch {
+	case len(prefix) == 0:
+		return name
+	case prefix == guide.Name && strings.HasPrefix(strings.ToLower(prefix), "urn:"):
+		return prefix + ":" + name
+	default:
+		return prefix + "." + name
+	}
+}
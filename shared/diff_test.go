@@ -0,0 +1,70 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	old, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+	snapshot := func() *Resource {
+		return &Resource{Complex: deepCopy(map[string]interface{}(old.Complex)).(map[string]interface{})}
+	}
+
+	new := snapshot()
+	for _, patch := range []Patch{
+		{Op: Replace, Path: "name.familyName", Value: "Kiu"},
+		{Op: Remove, Path: "nickName"},
+		{Op: Replace, Path: "password", Value: "n3wPa$$word"},
+		{Op: Remove, Path: "emails[value eq \"david@home.com\"]"},
+		{Op: Add, Path: "emails", Value: []interface{}{
+			map[string]interface{}{"value": "david@mobile.com", "type": "other"},
+			map[string]interface{}{"value": "qiu@example.com", "type": "other"},
+		}},
+	} {
+		require.Nil(t, ApplyPatch(patch, new, sch, context.Background()))
+	}
+
+	changes := Diff(old, new, sch)
+	require.Len(t, changes, 5)
+
+	assert.Equal(t, Replace, changes[0].Op)
+	assert.Equal(t, "name.familyName", changes[0].Path)
+	assert.Equal(t, "Kiu", changes[0].Value)
+	assert.Equal(t, "Qiu", changes[0].OldValue)
+
+	assert.Equal(t, Remove, changes[1].Op)
+	assert.Equal(t, "nickName", changes[1].Path)
+	assert.Equal(t, "Q", changes[1].OldValue)
+
+	// values of attributes that are never returned are withheld
+	assert.Equal(t, Replace, changes[2].Op)
+	assert.Equal(t, "password", changes[2].Path)
+	assert.Nil(t, changes[2].Value)
+	assert.Nil(t, changes[2].OldValue)
+
+	assert.Equal(t, Remove, changes[3].Op)
+	assert.Equal(t, "emails[value eq \"david@home.com\"]", changes[3].Path)
+
+	assert.Equal(t, Add, changes[4].Op)
+	assert.Equal(t, "emails", changes[4].Path)
+	assert.Len(t, changes[4].Value, 2)
+
+	// replaying the changes, save the withheld password, leads to the new snapshot
+	replayed := snapshot()
+	for _, change := range changes {
+		if change.Path != "password" {
+			require.Nil(t, ApplyPatch(change.Patch, replayed, sch, context.Background()))
+		}
+	}
+	replayed.Complex["password"] = new.Complex["password"]
+	assert.Equal(t, new.Complex, replayed.Complex)
+
+	assert.Empty(t, Diff(old, snapshot(), sch))
+}
@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// the *Drain of the server serving the request, see Go
+type InFlight struct{}
+
+// Counts the work of a server in flight, the requests it serves and the work they started in the background,
+// so that a shutdown stops taking new requests and waits for the others before closing the repositories.
+type Drain struct {
+	sync.Mutex
+	draining bool
+	work     sync.WaitGroup
+}
+
+func NewDrain() *Drain {
+	return &Drain{}
+}
+
+// Count in a request, reporting false once the drain has begun, when the request is to be rejected. Every
+// request counted in must be counted out with Leave.
+func (d *Drain) Enter() bool {
+	d.Lock()
+	defer d.Unlock()
+	if d.draining {
+		return false
+	}
+	d.work.Add(1)
+	return true
+}
+
+func (d *Drain) Leave() {
+	d.work.Done()
+}
+
+// Stop counting in requests and wait until the work in flight is done. Returns the error of the context when
+// it is done first, the work then goes on.
+func (d *Drain) Wait(ctx context.Context) error {
+	d.Lock()
+	d.draining = true
+	d.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.work.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run the work in the background, where it outlives the request of the context. The work is counted in the
+// drain of the context, if any, so that a shutdown waits for it as well, i.e. for an export to finish writing
+// its dump. It runs under the values of the context, without its deadline and cancellation.
+func Go(ctx context.Context, work func(ctx context.Context)) {
+	// the request is still counted in, so the drain cannot have finished waiting
+	if d, ok := ctx.Value(InFlight{}).(*Drain); ok {
+		d.work.Add(1)
+		go func() {
+			defer d.work.Done()
+			work(Detach(ctx))
+		}()
+		return
+	}
+	go work(Detach(ctx))
+}
+
+// Bounds the background work of one kind, i.e. the propagation of renamed groups, to a number of goroutines
+// at a time, so that a burst of requests does not start work without end
+type WorkerPool struct {
+	slots chan struct{}
+}
+
+// Returns a pool running up to size works at a time
+func NewWorkerPool(size int) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &WorkerPool{slots: make(chan struct{}, size)}
+}
+
+// Run the work in the background like Go when the pool, which may be nil, has a worker free, and tell whether
+// it did. Work the pool refuses is up to the caller, i.e. to do within the request, which slows the client
+// down instead.
+func (p *WorkerPool) TryGo(ctx context.Context, work func(ctx context.Context)) bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return false
+	}
+	Go(ctx, func(ctx context.Context) {
+		defer func() { <-p.slots }()
+		work(ctx)
+	})
+	return true
+}
+
+// The values of the context without its deadline and cancellation, for work outliving the request
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
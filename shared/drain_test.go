@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	d := NewDrain()
+	assert.True(t, d.Enter())
+	ctx := context.WithValue(context.Background(), InFlight{}, d)
+
+	// background work of the request is waited for after the request left
+	finished := make(chan struct{})
+	Go(ctx, func(ctx context.Context) {
+		<-finished
+	})
+	d.Leave()
+
+	waited := make(chan error)
+	go func() { waited <- d.Wait(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, d.Enter())
+	select {
+	case <-waited:
+		t.Fatal("waited for less than the work in flight")
+	default:
+	}
+	close(finished)
+	assert.Nil(t, <-waited)
+
+	// gives up with the context
+	d = NewDrain()
+	assert.True(t, d.Enter())
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, d.Wait(timeout))
+}
+
+func TestWorkerPool(t *testing.T) {
+	ctx := context.Background()
+	pool := NewWorkerPool(1)
+
+	// work beyond the size of the pool is refused
+	finished, done := make(chan struct{}), make(chan struct{})
+	assert.True(t, pool.TryGo(ctx, func(ctx context.Context) {
+		<-finished
+		close(done)
+	}))
+	assert.False(t, pool.TryGo(ctx, func(ctx context.Context) {}))
+
+	// until the worker is free again
+	close(finished)
+	<-done
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, pool.TryGo(ctx, func(ctx context.Context) {}))
+
+	// no pool, no background
+	assert.False(t, (*WorkerPool)(nil).TryGo(ctx, func(ctx context.Context) {}))
+}
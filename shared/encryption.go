@@ -0,0 +1,233 @@
+package shared
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+)
+
+// Encrypts and decrypts the string values of sensitive attributes. The attribute path is passed
+// along so that implementations can use different keys per attribute or bind the ciphertext to it.
+type Encryptor interface {
+	Encrypt(path, plaintext string) (string, error)
+	Decrypt(path, ciphertext string) (string, error)
+}
+
+// Returns an encryptor that seals values with AES-GCM under the given 16, 24 or 32 byte key, using the
+// attribute path as additional data. Ciphertexts are base64 encoded and carry their random nonce.
+func NewAESGCMEncryptor(key []byte) (Encryptor, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCMEncryptor{gcm: gcm}, nil
+}
+
+type aesGCMEncryptor struct {
+	gcm cipher.AEAD
+}
+
+func (e *aesGCMEncryptor) Encrypt(path, plaintext string) (string, error) {
+	nonce := make([]byte, e.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := e.gcm.Seal(nonce, nonce, []byte(plaintext), []byte(path))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *aesGCMEncryptor) Decrypt(path, ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < e.gcm.NonceSize() {
+		return "", Error.Text("ciphertext at '%s' is too short", path)
+	}
+	nonce, sealed := sealed[:e.gcm.NonceSize()], sealed[e.gcm.NonceSize():]
+	plaintext, err := e.gcm.Open(nil, nonce, sealed, []byte(path))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Decorates a repository so that the string values at the given attribute paths are encrypted before
+// they are written and decrypted after they are read. Paths may address sub attributes, i.e.
+// 'addresses.streetAddress', and extension attributes, i.e.
+// 'urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber'.
+// Since values are stored as ciphertext, filters on encrypted attributes do not match.
+func NewEncryptingRepository(repo Repository, encryptor Encryptor, paths ...string) Repository {
+	return &encryptingRepository{repo: repo, encryptor: encryptor, paths: paths}
+}
+
+type encryptingRepository struct {
+	repo      Repository
+	encryptor Encryptor
+	paths     []string
+}
+
+func (r *encryptingRepository) Create(provider DataProvider, ctx context.Context) error {
+	encrypted, err := r.transform(provider.GetData(), r.encryptor.Encrypt)
+	if err != nil {
+		return err
+	}
+	return r.repo.Create(&Resource{Complex: encrypted}, ctx)
+}
+
+func (r *encryptingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	dp, err := r.repo.Get(id, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.decrypt(dp)
+}
+
+func (r *encryptingRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	all, err := r.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]Complex, 0, len(all))
+	for _, c := range all {
+		d, err := r.transform(c, r.encryptor.Decrypt)
+		if err != nil {
+			return nil, err
+		}
+		decrypted = append(decrypted, d)
+	}
+	return decrypted, nil
+}
+
+func (r *encryptingRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *encryptingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	encrypted, err := r.transform(provider.GetData(), r.encryptor.Encrypt)
+	if err != nil {
+		return err
+	}
+	return r.repo.Update(id, version, &Resource{Complex: encrypted}, ctx)
+}
+
+// the added members are encrypted as those of an update; removes match the values as they are stored, so
+// member values are not to be encrypted
+func (r *encryptingRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	encrypted, err := r.transform(Complex{"members": adds}, r.encryptor.Encrypt)
+	if err != nil {
+		return err
+	}
+	adds, _ = encrypted["members"].([]interface{})
+	return memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *encryptingRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *encryptingRepository) Delete(id, version string, ctx context.Context) error {
+	return r.repo.Delete(id, version, ctx)
+}
+
+func (r *encryptingRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+func (r *encryptingRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	lr, err := r.repo.Search(payload, ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]DataProvider, 0, len(lr.Resources))
+	for _, dp := range lr.Resources {
+		d, err := r.decrypt(dp)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, d)
+	}
+	decrypted := *lr
+	decrypted.Resources = resources
+	return &decrypted, nil
+}
+
+func (r *encryptingRepository) decrypt(dp DataProvider) (DataProvider, error) {
+	decrypted, err := r.transform(dp.GetData(), r.encryptor.Decrypt)
+	if err != nil {
+		return nil, err
+	}
+	return &Resource{Complex: decrypted}, nil
+}
+
+// apply fn to a copy of the data at every configured path, leaving the original untouched
+func (r *encryptingRepository) transform(data Complex, fn func(path, value string) (string, error)) (Complex, error) {
+	copied := deepCopy(map[string]interface{}(data)).(map[string]interface{})
+	for _, path := range r.paths {
+		if err := transformAt(copied, splitEncryptedPath(path), path, fn); err != nil {
+			return nil, err
+		}
+	}
+	return Complex(copied), nil
+}
+
+func transformAt(data map[string]interface{}, segments []string, path string, fn func(path, value string) (string, error)) error {
+	k, v, ok := entryByName(data, segments[0])
+	if !ok {
+		return nil
+	}
+
+	if len(segments) == 1 {
+		switch v.(type) {
+		case string:
+			t, err := fn(path, v.(string))
+			if err != nil {
+				return err
+			}
+			data[k] = t
+		case []interface{}:
+			for i, elem := range v.([]interface{}) {
+				if s, ok := elem.(string); ok {
+					t, err := fn(path, s)
+					if err != nil {
+						return err
+					}
+					v.([]interface{})[i] = t
+				}
+			}
+		}
+		return nil
+	}
+
+	switch v.(type) {
+	case map[string]interface{}:
+		return transformAt(v.(map[string]interface{}), segments[1:], path, fn)
+	case []interface{}:
+		for _, elem := range v.([]interface{}) {
+			if m, ok := elem.(map[string]interface{}); ok {
+				if err := transformAt(m, segments[1:], path, fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// extension URNs contain periods themselves, so they are split off at the last colon
+func splitEncryptedPath(path string) []string {
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		if i := strings.LastIndex(path, ":"); i > 0 {
+			return append([]string{path[:i]}, strings.Split(path[i+1:], ".")...)
+		}
+	}
+	return strings.Split(path, ".")
+}
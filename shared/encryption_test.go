@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEncryptingRepository(t *testing.T) {
+	encryptor, err := NewAESGCMEncryptor([]byte("0123456789abcdef"))
+	require.Nil(t, err)
+
+	backing := NewMapRepository(nil)
+	repo := NewEncryptingRepository(backing, encryptor,
+		"addresses.streetAddress",
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber")
+
+	r := &Resource{Complex: Complex{
+		"id":       "foo",
+		"userName": "david",
+		"addresses": []interface{}{
+			map[string]interface{}{"streetAddress": "100 Universal City Plaza", "type": "work"},
+		},
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+			"employeeNumber": "701984",
+		},
+	}}
+	require.Nil(t, repo.Create(r, context.Background()))
+
+	// the caller's resource is untouched
+	assert.Equal(t, "100 Universal City Plaza", r.Complex["addresses"].([]interface{})[0].(map[string]interface{})["streetAddress"])
+
+	// stored encrypted
+	stored, err := backing.Get("foo", "", context.Background())
+	require.Nil(t, err)
+	storedAddress := stored.GetData()["addresses"].([]interface{})[0].(map[string]interface{})
+	assert.NotEqual(t, "100 Universal City Plaza", storedAddress["streetAddress"])
+	assert.Equal(t, "work", storedAddress["type"])
+	assert.NotEqual(t, "701984", stored.GetData()["urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"].(map[string]interface{})["employeeNumber"])
+	assert.Equal(t, "david", stored.GetData()["userName"])
+
+	// read decrypted
+	dp, err := repo.Get("foo", "", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "100 Universal City Plaza", dp.GetData()["addresses"].([]interface{})[0].(map[string]interface{})["streetAddress"])
+	assert.Equal(t, "701984", dp.GetData()["urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"].(map[string]interface{})["employeeNumber"])
+}
+
+func TestAESGCMEncryptor_BoundToPath(t *testing.T) {
+	encryptor, err := NewAESGCMEncryptor([]byte("0123456789abcdef"))
+	require.Nil(t, err)
+
+	ciphertext, err := encryptor.Encrypt("name.familyName", "Qiu")
+	require.Nil(t, err)
+
+	plaintext, err := encryptor.Decrypt("name.familyName", ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "Qiu", plaintext)
+
+	_, err = encryptor.Decrypt("name.givenName", ciphertext)
+	assert.NotNil(t, err)
+}
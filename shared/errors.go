@@ -2,7 +2,9 @@ package shared
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -21,11 +23,29 @@ type ErrorFactory interface {
 	InvalidFilter(filter, detail string) error
 	InvalidType(path, expect, got string) error
 	NoAttribute(path string) error
+	UnknownAttribute(path string) error
 	MissingRequiredProperty(path string) error
 	MutabilityViolation(path string) error
+	MembershipCycle(path []string) error
 	InvalidParam(name, expect, got string) error
 	ResourceNotFound(id, version string) error
+	Gone(id string, deleted time.Time) error
+	VersionConflict(id string, attempts int) error
 	Duplicate(path string, value interface{}) error
+	TooMany(maxResults int) error
+	Forbidden(path string) error
+	ForbiddenFilter(path string) error
+	RateLimited(retryAfter time.Duration) error
+	Timeout(operation string) error
+	Unavailable(retryAfter time.Duration) error
+	UnsupportedMediaType(contentType string) error
+	NotAcceptable(accept string) error
+	PayloadTooLarge(maxBytes int) error
+	NotImplemented(feature string) error
+	NotReady(id, status string) error
+	RangeNotSatisfiable(size int64) error
+	PreconditionRequired(id string) error
+	InUse(id string, holders int) error
 	Text(template string, args ...interface{}) error
 }
 
@@ -95,6 +115,19 @@ func (e *NoAttributeError) Error() string {
 	return fmt.Sprintf("No attribute defined for path (segment) '%s'", e.Path)
 }
 
+func (f *errorFactory) UnknownAttribute(path string) error {
+	return &UnknownAttributeError{path}
+}
+
+// Unknown Attribute
+type UnknownAttributeError struct {
+	Path string
+}
+
+func (e *UnknownAttributeError) Error() string {
+	return fmt.Sprintf("Attribute '%s' is not defined by the schema", e.Path)
+}
+
 func (f *errorFactory) MissingRequiredProperty(path string) error {
 	return &MissingRequiredPropertyError{path}
 }
@@ -123,6 +156,19 @@ func (e *MutabilityViolationError) Error() string {
 	return fmt.Sprintf("Violated mutability rule at '%s'", e.Path)
 }
 
+func (f *errorFactory) MembershipCycle(path []string) error {
+	return &MembershipCycleError{path}
+}
+
+// Membership Cycle, the ids of the groups along the cycle, starting and ending with the group written
+type MembershipCycleError struct {
+	Path []string
+}
+
+func (e *MembershipCycleError) Error() string {
+	return fmt.Sprintf("Group membership cycle %s", strings.Join(e.Path, " -> "))
+}
+
 func (f *errorFactory) InvalidParam(name, expect, got string) error {
 	return &InvalidParamError{name, expect, got}
 }
@@ -160,16 +206,232 @@ func (e ResourceNotFoundError) Error() string {
 	return "Resource not found"
 }
 
+func (f *errorFactory) Gone(id string, deleted time.Time) error {
+	return &GoneError{id, deleted}
+}
+
+// Gone, for resources known to be deleted, see DeletionReader
+type GoneError struct {
+	Id      string
+	Deleted time.Time
+}
+
+func (e *GoneError) Error() string {
+	return fmt.Sprintf("Resource '%s' was deleted at %s", e.Id, e.Deleted.UTC().Format(time.RFC3339))
+}
+
+func (f *errorFactory) VersionConflict(id string, attempts int) error {
+	return &VersionConflictError{id, attempts}
+}
+
+// Version Conflict, for writes that kept losing the race against concurrent ones
+type VersionConflictError struct {
+	Id       string
+	Attempts int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("Resource '%s' was modified concurrently, gave up after %d attempts", e.Id, e.Attempts)
+}
+
 func (f *errorFactory) Duplicate(path string, value interface{}) error {
-	return &DuplicateError{path, value}
+	return &DuplicateError{Path: path, Value: value}
 }
 
 // Duplicate Error
 type DuplicateError struct {
-	Path  string
-	Value interface{}
+	Path       string
+	Value      interface{}
+	ExistingId string // id of the resource already holding the value, if known
 }
 
 func (e DuplicateError) Error() string {
 	return fmt.Sprintf("Resource has duplicate value '%v' at path '%s'", e.Value, e.Path)
 }
+
+func (f *errorFactory) TooMany(maxResults int) error {
+	return &TooManyError{maxResults}
+}
+
+// Too Many Results
+type TooManyError struct {
+	MaxResults int
+}
+
+func (e TooManyError) Error() string {
+	return fmt.Sprintf("Search yields more results than the maximum of %d allowed", e.MaxResults)
+}
+
+func (f *errorFactory) Forbidden(path string) error {
+	return &ForbiddenError{Path: path}
+}
+
+// Filtering on the attribute is not allowed, see CheckFilterReturnable
+func (f *errorFactory) ForbiddenFilter(path string) error {
+	return &ForbiddenError{Path: path, Action: ForbiddenToFilter}
+}
+
+// Forbidden
+type ForbiddenError struct {
+	Path   string
+	Action string // what is not allowed, ForbiddenToModify if empty
+}
+
+// Actions of forbidden errors
+const (
+	ForbiddenToModify = "modify"
+	ForbiddenToFilter = "filter on"
+)
+
+func (e *ForbiddenError) Error() string {
+	action := e.Action
+	if len(action) == 0 {
+		action = ForbiddenToModify
+	}
+	return fmt.Sprintf("Not allowed to %s attribute at '%s'", action, e.Path)
+}
+
+func (f *errorFactory) RateLimited(retryAfter time.Duration) error {
+	return &RateLimitedError{retryAfter}
+}
+
+// Rate Limited
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e RateLimitedError) Error() string {
+	return "Too many requests, retry later"
+}
+
+func (f *errorFactory) Timeout(operation string) error {
+	return &TimeoutError{operation}
+}
+
+// Timeout
+type TimeoutError struct {
+	Operation string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Deadline exceeded while waiting for %s", e.Operation)
+}
+
+func (f *errorFactory) Unavailable(retryAfter time.Duration) error {
+	return &UnavailableError{retryAfter}
+}
+
+// Unavailable
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return "The database is unavailable, retry later"
+}
+
+func (f *errorFactory) UnsupportedMediaType(contentType string) error {
+	return &UnsupportedMediaTypeError{contentType}
+}
+
+// Unsupported Media Type
+type UnsupportedMediaTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("Unsupported content type '%s', expected application/scim+json or application/json", e.ContentType)
+}
+
+func (f *errorFactory) NotAcceptable(accept string) error {
+	return &NotAcceptableError{accept}
+}
+
+// Not Acceptable
+type NotAcceptableError struct {
+	Accept string
+}
+
+func (e *NotAcceptableError) Error() string {
+	return fmt.Sprintf("None of the accepted types '%s' can be produced", e.Accept)
+}
+
+func (f *errorFactory) PayloadTooLarge(maxBytes int) error {
+	return &PayloadTooLargeError{maxBytes}
+}
+
+// Payload Too Large
+type PayloadTooLargeError struct {
+	MaxBytes int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("Request body exceeds the limit of %d bytes", e.MaxBytes)
+}
+
+func (f *errorFactory) NotImplemented(feature string) error {
+	return &NotImplementedError{feature}
+}
+
+// Not Implemented, for features the service provider has turned off
+type NotImplementedError struct {
+	Feature string
+}
+
+func (e *NotImplementedError) Error() string {
+	return fmt.Sprintf("%s is not supported", e.Feature)
+}
+
+func (f *errorFactory) NotReady(id, status string) error {
+	return &NotReadyError{id, status}
+}
+
+// Not Ready, for results of background work, i.e. export dumps, asked for before the work succeeded
+type NotReadyError struct {
+	Id     string
+	Status string
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("'%s' is %s, retry once it succeeded", e.Id, e.Status)
+}
+
+func (f *errorFactory) RangeNotSatisfiable(size int64) error {
+	return &RangeNotSatisfiableError{size}
+}
+
+// Range Not Satisfiable, for byte ranges outside of the content
+type RangeNotSatisfiableError struct {
+	Size int64
+}
+
+func (e *RangeNotSatisfiableError) Error() string {
+	return fmt.Sprintf("Requested range is not within the %d bytes of the content", e.Size)
+}
+
+func (f *errorFactory) PreconditionRequired(id string) error {
+	return &PreconditionRequiredError{id}
+}
+
+// Precondition Required, for deletes without If-Match under scim.protocol.etag set to required
+type PreconditionRequiredError struct {
+	Id string
+}
+
+func (e *PreconditionRequiredError) Error() string {
+	return fmt.Sprintf("Resource '%s' is only deleted with an If-Match header holding its version", e.Id)
+}
+
+func (f *errorFactory) InUse(id string, holders int) error {
+	return &InUseError{id, holders}
+}
+
+// Conflict, for resources others still refer to, i.e. a role assigned to users
+type InUseError struct {
+	Id      string
+	Holders int
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("Resource '%s' is still referred to by %d resources", e.Id, e.Holders)
+}
@@ -0,0 +1,122 @@
+package shared
+
+import (
+	"context"
+	"strings"
+)
+
+// The attributes of the documents of the change events a subscriber receives, i.e. an HR webhook that needs
+// the names of users but must not see their passwords. Paths are those of the attributes and
+// excludedAttributes parameters, like name.givenName or the URN of an extension followed by one of its
+// attributes; the sub attributes of a path go along with it. id and schemas are always delivered, attributes
+// the schema never returns, like password, never, and attributes the schema does not define, i.e. extension
+// namespaces missing from it, only when included explicitly.
+type EventMask struct {
+	Attributes         []string // the only attributes delivered, all if empty
+	ExcludedAttributes []string
+}
+
+// Returns a copy of the document of the schema with only the attributes the mask lets through
+func (m EventMask) Apply(document Complex, sch *Schema) Complex {
+	guide := sch.ToAttribute()
+	included, excluded := maskPaths(m.Attributes, guide), maskPaths(m.ExcludedAttributes, guide)
+	never := neverReturned(guide, "")
+	masked := redactComplex(document, guide, "", func(path string, attr *Attribute) bool {
+		switch path {
+		case "id", "schemas":
+			return true
+		}
+		if attr == nil {
+			// the schema says nothing of whether it may be delivered
+			return grantsPath(included, path)
+		}
+		if len(included) > 0 && !grantsPath(included, path) {
+			return false
+		}
+		return !grantsPath(excluded, path) && !grantsPath(never, path) && !pathsBelow(excluded, path) &&
+			!pathsBelow(never, path)
+	})
+	return Complex(masked)
+}
+
+// Decorates the publisher of a subscriber so that the documents of the events it publishes carry only what
+// the mask lets through, using the schemas by resource type. Events of resource types without a schema are
+// published without their document. The events themselves are left alone, for the other publishers.
+func NewMaskingPublisher(publisher Publisher, schemas map[string]*Schema, mask EventMask) Publisher {
+	return &maskingPublisher{publisher: publisher, schemas: schemas, mask: mask}
+}
+
+type maskingPublisher struct {
+	publisher Publisher
+	schemas   map[string]*Schema
+	mask      EventMask
+}
+
+func (p *maskingPublisher) Publish(event *ChangeEvent, ctx context.Context) error {
+	masked := *event
+	if event.Document != nil {
+		if sch := p.schemas[event.ResourceType]; sch != nil {
+			masked.Document = p.mask.Apply(event.Document, sch)
+		} else {
+			masked.Document = nil
+		}
+	}
+	return p.publisher.Publish(&masked, ctx)
+}
+
+// Returns a copy of the document of the schema without the attributes the schema never returns, like
+// password; attributes the schema does not define are kept. Returns the document itself without a schema.
+func WithoutNeverReturned(document Complex, sch *Schema) Complex {
+	if sch == nil {
+		return document
+	}
+	guide := sch.ToAttribute()
+	never := neverReturned(guide, "")
+	if len(never) == 0 {
+		return document
+	}
+	return Complex(redactComplex(document, guide, "", func(path string, attr *Attribute) bool {
+		return !grantsPath(never, path) && !pathsBelow(never, path)
+	}))
+}
+
+// the paths of attributes the schema never returns, joined as redactComplex joins them
+func neverReturned(guide *Attribute, prefix string) []string {
+	paths := make([]string, 0)
+	for _, attr := range guide.SubAttributes {
+		path := joinAttributePath(prefix, attr.Name)
+		if attr.Returned == Never {
+			paths = append(paths, path)
+			continue
+		}
+		paths = append(paths, neverReturned(attr, path)...)
+	}
+	return paths
+}
+
+// the paths joined as redactComplex joins them, the attributes of extensions separated from their URN by a
+// period rather than a colon
+func maskPaths(paths []string, guide *Attribute) []string {
+	joined := make([]string, 0, len(paths))
+	for _, path := range paths {
+		for _, attr := range guide.SubAttributes {
+			if strings.HasPrefix(strings.ToLower(path), strings.ToLower(attr.Name)+":") {
+				path = attr.Name + "." + path[len(attr.Name)+1:]
+				break
+			}
+		}
+		joined = append(joined, path)
+	}
+	return joined
+}
+
+// whether any of the paths lies below the path, so that the attribute at the path is to be descended into
+func pathsBelow(paths []string, path string) bool {
+	path = strings.ToLower(path)
+	for _, p := range paths {
+		if strings.HasPrefix(strings.ToLower(p), path+".") {
+			return true
+		}
+	}
+	return false
+}
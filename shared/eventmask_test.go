@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEventMask(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	ext, err := NewSchemaRegistry().RegisterJSON([]byte(`{"id": "urn:example:hr", "attributes": [
+		{"name": "salary", "type": "integer"},
+		{"name": "costCenter", "type": "string"}
+	]}`))
+	require.Nil(t, err)
+	require.Nil(t, sch.AddExtension(ext, false))
+
+	document := func() Complex {
+		return Complex{
+			"schemas":        []interface{}{UserUrn},
+			"id":             "1",
+			"userName":       "david",
+			"password":       "secret",
+			"name":           map[string]interface{}{"givenName": "David", "familyName": "Q"},
+			"emails":         []interface{}{map[string]interface{}{"value": "d@example.com", "type": "work"}},
+			"urn:example:hr": map[string]interface{}{"salary": int64(100), "costCenter": "R&D"},
+			// a namespace the schema does not define
+			"urn:example:payroll": map[string]interface{}{"iban": "BE68539007547034"},
+		}
+	}
+
+	for _, test := range []struct {
+		mask   EventMask
+		expect Complex
+	}{
+		{
+			EventMask{},
+			Complex{
+				"schemas":        []interface{}{UserUrn},
+				"id":             "1",
+				"userName":       "david",
+				"name":           map[string]interface{}{"givenName": "David", "familyName": "Q"},
+				"emails":         []interface{}{map[string]interface{}{"value": "d@example.com", "type": "work"}},
+				"urn:example:hr": map[string]interface{}{"salary": int64(100), "costCenter": "R&D"},
+			},
+		},
+		{
+			EventMask{Attributes: []string{"userName", "name.givenName", "password", "urn:example:hr:costCenter"}},
+			Complex{
+				"schemas":        []interface{}{UserUrn},
+				"id":             "1",
+				"userName":       "david",
+				"name":           map[string]interface{}{"givenName": "David"},
+				"urn:example:hr": map[string]interface{}{"costCenter": "R&D"},
+			},
+		},
+		{
+			EventMask{ExcludedAttributes: []string{"emails", "name.familyName", "URN:EXAMPLE:HR:salary"}},
+			Complex{
+				"schemas":        []interface{}{UserUrn},
+				"id":             "1",
+				"userName":       "david",
+				"name":           map[string]interface{}{"givenName": "David"},
+				"urn:example:hr": map[string]interface{}{"costCenter": "R&D"},
+			},
+		},
+		{
+			EventMask{Attributes: []string{"userName", "urn:example:payroll"}},
+			Complex{
+				"schemas":             []interface{}{UserUrn},
+				"id":                  "1",
+				"userName":            "david",
+				"urn:example:payroll": map[string]interface{}{"iban": "BE68539007547034"},
+			},
+		},
+	} {
+		assert.Equal(t, test.expect, test.mask.Apply(document(), sch), "%+v", test.mask)
+	}
+
+	// other publishers see the event unmasked
+	var published []*ChangeEvent
+	publisher := NewMaskingPublisher(PublisherFunc(func(event *ChangeEvent, ctx context.Context) error {
+		published = append(published, event)
+		return nil
+	}), map[string]*Schema{UserResourceType: sch}, EventMask{Attributes: []string{"userName"}})
+	event := &ChangeEvent{ResourceType: UserResourceType, Op: ChangeCreate, Id: "1", Document: document()}
+	require.Nil(t, publisher.Publish(event, context.Background()))
+	require.Nil(t, publisher.Publish(&ChangeEvent{ResourceType: GroupResourceType, Op: ChangeCreate, Id: "g", Document: Complex{"id": "g"}}, context.Background()))
+	require.Nil(t, publisher.Publish(&ChangeEvent{ResourceType: UserResourceType, Op: ChangeDelete, Id: "1"}, context.Background()))
+	require.Len(t, published, 3)
+	assert.Equal(t, Complex{"schemas": []interface{}{UserUrn}, "id": "1", "userName": "david"}, published[0].Document)
+	assert.Equal(t, "secret", event.Document["password"])
+	assert.Nil(t, published[1].Document)
+	assert.Nil(t, published[2].Document)
+}
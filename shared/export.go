@@ -0,0 +1,352 @@
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Formats of export dumps
+const (
+	ExportNDJSON      = "ndjson"  // one resource per line
+	ExportGzip        = "gzip"    // the same, gzip compressed
+	ExportCBOR        = "cbor"    // one CBOR resource after another, an RFC 8742 CBOR sequence
+	ExportMessagePack = "msgpack" // one MessagePack resource after another
+)
+
+// The codec the resources of dumps of the format are marshaled with
+func ExportCodec(format string) Codec {
+	switch format {
+	case ExportCBOR:
+		return CBORCodec
+	case ExportMessagePack:
+		return MessagePackCodec
+	}
+	return JSONCodec
+}
+
+// A dump of the resources of a resource type that match a filter, produced in the background by RunExport for
+// downstream systems reconciling their copy in full. The status is one of those of operations.
+type ExportJob struct {
+	Id           string    `json:"id"`
+	ResourceType string    `json:"resourceType"`
+	Filter       string    `json:"filter,omitempty"`
+	Format       string    `json:"format"`
+	Status       string    `json:"status"`
+	Resources    int       `json:"resources"` // written so far
+	Size         int64     `json:"size"`      // of the dump in bytes, once succeeded
+	Error        string    `json:"error,omitempty"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Owner        string    `json:"-"` // the principal that submitted the export, the only one it is served to
+}
+
+// Keeps export jobs and their dumps. Implementations may keep dumps on disk, in object storage, etc.
+type ExportStore interface {
+	Put(job *ExportJob) error
+	Get(id string) (*ExportJob, error)
+	// a writer of the dump of the job, replacing what was written before
+	Create(id string) (io.WriteCloser, error)
+	// up to length bytes of the dump of the job from the offset on
+	ReadRange(id string, offset, length int64) ([]byte, error)
+	// forget the job and its dump
+	Delete(id string) error
+	// delete the jobs that finished, succeeded or failed, before the time, and their dumps
+	Expire(before time.Time) error
+}
+
+// Assign an id to the export and record it as pending, for RunExport to produce, owned by the principal of
+// the context
+func SubmitExport(job *ExportJob, store ExportStore, ctx context.Context) error {
+	now := time.Now()
+	job.Id = uuid.NewV4().String()
+	job.Owner, _ = ctx.Value(Principal{}).(string)
+	job.Status = OperationPending
+	job.Created = now
+	job.LastModified = now
+	return store.Put(job)
+}
+
+// Get the job of the store if the principal of the context owns it, failing as if there was none otherwise
+func GetExport(id string, store ExportStore, ctx context.Context) (*ExportJob, error) {
+	job, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if principal, _ := ctx.Value(Principal{}).(string); principal != job.Owner {
+		return nil, Error.ResourceNotFound(id, "")
+	}
+	return job, nil
+}
+
+// Write the resources matching the filter of the job, all if it has none, to its dump in pages of batchSize
+// sorted by id, marshaled one per line, or one after another for the binary formats, which delimit
+// themselves, keeping the job in the store up to date. Resources created or deleted while the export runs may
+// be missing from the dump; the job fails on the first error, and on a partial page, which would leave
+// resources out of the dump unnoticed.
+func RunExport(job *ExportJob, repo Repository, store ExportStore, batchSize int, marshal func(DataProvider) ([]byte, error), ctx context.Context) (err error) {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	job.Status = OperationRunning
+	job.LastModified = time.Now()
+	store.Put(job)
+
+	defer func() {
+		if err != nil {
+			job.Status = OperationFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = OperationSucceeded
+		}
+		job.LastModified = time.Now()
+		store.Put(job)
+	}()
+
+	dump, err := store.Create(job.Id)
+	if err != nil {
+		return err
+	}
+	counter := &countingWriter{w: dump}
+	var w io.Writer = counter
+	var gz *gzip.Writer
+	if job.Format == ExportGzip {
+		gz = gzip.NewWriter(counter)
+		w = gz
+	}
+
+	filter := job.Filter
+	if len(filter) == 0 {
+		filter = "id pr"
+	}
+	for startIndex := 1; ; startIndex += batchSize {
+		lr, err := repo.Search(SearchRequest{
+			Schemas:    []string{SearchUrn},
+			Filter:     filter,
+			SortBy:     "id",
+			SortOrder:  "ascending",
+			StartIndex: startIndex,
+			Count:      batchSize,
+		}, ctx)
+		if err != nil {
+			dump.Close()
+			return err
+		}
+		if lr.Partial {
+			dump.Close()
+			return Error.Text("partial page of resources from %d on", startIndex)
+		}
+		for _, resource := range lr.Resources {
+			line, err := marshal(resource)
+			if err != nil {
+				dump.Close()
+				return err
+			}
+			if ExportCodec(job.Format) == JSONCodec {
+				line = append(line, '\n')
+			}
+			if _, err := w.Write(line); err != nil {
+				dump.Close()
+				return err
+			}
+			job.Resources++
+		}
+		job.LastModified = time.Now()
+		store.Put(job)
+		if len(lr.Resources) < batchSize {
+			break
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			dump.Close()
+			return err
+		}
+	}
+	if err := dump.Close(); err != nil {
+		return err
+	}
+	job.Size = counter.n
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Returns an export store that keeps jobs and their dumps in memory
+func NewMemoryExportStore() ExportStore {
+	return &memoryExportStore{jobMap: newJobMap(), dumps: make(map[string][]byte)}
+}
+
+type memoryExportStore struct {
+	*jobMap
+	mu    sync.RWMutex
+	dumps map[string][]byte
+}
+
+func (s *memoryExportStore) Create(id string) (io.WriteCloser, error) {
+	return &memoryDump{store: s, id: id}, nil
+}
+
+func (s *memoryExportStore) ReadRange(id string, offset, length int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dump, ok := s.dumps[id]
+	if !ok {
+		return nil, Error.ResourceNotFound(id, "")
+	}
+	return sliceRange(dump, offset, length), nil
+}
+
+func (s *memoryExportStore) Delete(id string) error {
+	s.jobMap.delete(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dumps, id)
+	return nil
+}
+
+func (s *memoryExportStore) Expire(before time.Time) error {
+	for _, id := range s.jobMap.finishedBefore(before) {
+		s.Delete(id)
+	}
+	return nil
+}
+
+// a dump stored once it is closed
+type memoryDump struct {
+	bytes.Buffer
+	store *memoryExportStore
+	id    string
+}
+
+func (d *memoryDump) Close() error {
+	d.store.mu.Lock()
+	defer d.store.mu.Unlock()
+	d.store.dumps[d.id] = d.Bytes()
+	return nil
+}
+
+// Returns an export store that keeps jobs in memory and writes their dumps to files in the directory
+func NewFileExportStore(dir string) ExportStore {
+	return &fileExportStore{jobMap: newJobMap(), dir: dir}
+}
+
+type fileExportStore struct {
+	*jobMap
+	dir string
+}
+
+func (s *fileExportStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".dump")
+}
+
+func (s *fileExportStore) Create(id string) (io.WriteCloser, error) {
+	return os.Create(s.path(id))
+}
+
+func (s *fileExportStore) ReadRange(id string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, Error.ResourceNotFound(id, "")
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading export %s: %s", id, err)
+	}
+	return buf[:n], nil
+}
+
+func (s *fileExportStore) Delete(id string) error {
+	s.jobMap.delete(id)
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileExportStore) Expire(before time.Time) (err error) {
+	for _, id := range s.jobMap.finishedBefore(before) {
+		if deleteErr := s.Delete(id); deleteErr != nil && err == nil {
+			err = deleteErr
+		}
+	}
+	return
+}
+
+// export jobs kept in memory
+type jobMap struct {
+	sync.RWMutex
+	data map[string]ExportJob
+}
+
+func newJobMap() *jobMap {
+	return &jobMap{data: make(map[string]ExportJob)}
+}
+
+func (m *jobMap) Put(job *ExportJob) error {
+	m.Lock()
+	defer m.Unlock()
+	m.data[job.Id] = *job
+	return nil
+}
+
+func (m *jobMap) Get(id string) (*ExportJob, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if job, ok := m.data[id]; !ok {
+		return nil, Error.ResourceNotFound(id, "")
+	} else {
+		return &job, nil
+	}
+}
+
+func (m *jobMap) delete(id string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.data, id)
+}
+
+// the ids of the jobs that succeeded or failed before the time
+func (m *jobMap) finishedBefore(before time.Time) []string {
+	m.RLock()
+	defer m.RUnlock()
+	ids := make([]string, 0)
+	for id, job := range m.data {
+		if (job.Status == OperationSucceeded || job.Status == OperationFailed) && job.LastModified.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func sliceRange(data []byte, offset, length int64) []byte {
+	if offset >= int64(len(data)) {
+		return []byte{}
+	}
+	end := offset + length
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return data[offset:end]
+}
@@ -0,0 +1,169 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunExport(t *testing.T) {
+	ctx := context.Background()
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{})
+	for i := 0; i < 7; i++ {
+		require.Nil(t, repo.Create(&Resource{Complex: Complex{
+			"schemas":  []interface{}{UserUrn},
+			"id":       fmt.Sprintf("user-%d", i),
+			"userName": fmt.Sprintf("user%d", i),
+			"active":   i%2 == 0,
+		}}, ctx))
+	}
+	marshal := func(dp DataProvider) ([]byte, error) { return MarshalJSON(dp, sch, nil, nil) }
+	dir, err := ioutil.TempDir("", "export")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, store := range []ExportStore{NewMemoryExportStore(), NewFileExportStore(dir)} {
+		job := &ExportJob{ResourceType: UserResourceType}
+		require.Nil(t, SubmitExport(job, store, ctx))
+		pending, err := store.Get(job.Id)
+		require.Nil(t, err)
+		assert.Equal(t, OperationPending, pending.Status)
+
+		require.Nil(t, RunExport(job, repo, store, 3, marshal, ctx))
+		done, err := store.Get(job.Id)
+		require.Nil(t, err)
+		assert.Equal(t, OperationSucceeded, done.Status)
+		assert.Equal(t, 7, done.Resources)
+		dump, err := store.ReadRange(job.Id, 0, done.Size)
+		require.Nil(t, err)
+		assert.Equal(t, done.Size, int64(len(dump)))
+		assert.Equal(t, 7, bytes.Count(dump, []byte("\n")))
+
+		// ranges past the end are cut short
+		tail, err := store.ReadRange(job.Id, done.Size-10, 100)
+		require.Nil(t, err)
+		assert.Equal(t, dump[done.Size-10:], tail)
+		_, err = store.ReadRange("missing", 0, 10)
+		assert.IsType(t, &ResourceNotFoundError{}, err)
+	}
+
+	store := NewMemoryExportStore()
+	job := &ExportJob{ResourceType: UserResourceType, Filter: "active eq true", Format: ExportGzip}
+	require.Nil(t, SubmitExport(job, store, ctx))
+	require.Nil(t, RunExport(job, repo, store, 100, marshal, ctx))
+	assert.Equal(t, 4, job.Resources)
+	dump, err := store.ReadRange(job.Id, 0, job.Size)
+	require.Nil(t, err)
+	gz, err := gzip.NewReader(bytes.NewReader(dump))
+	require.Nil(t, err)
+	lines := 0
+	for scanner := bufio.NewScanner(gz); scanner.Scan(); lines++ {
+		assert.Contains(t, scanner.Text(), `"active":true`)
+	}
+	assert.Equal(t, 4, lines)
+
+	// binary dumps are the resources one after another
+	job = &ExportJob{ResourceType: UserResourceType, Filter: "id eq \"user-1\" or id eq \"user-2\"", Format: ExportCBOR}
+	require.Nil(t, SubmitExport(job, store, ctx))
+	codec := ExportCodec(job.Format)
+	binaryMarshal := func(dp DataProvider) ([]byte, error) { return codec.Marshal(dp, sch, nil, nil) }
+	require.Nil(t, RunExport(job, repo, store, 100, binaryMarshal, ctx))
+	dump, err = store.ReadRange(job.Id, 0, job.Size)
+	require.Nil(t, err)
+	resources := make([][]byte, 0)
+	for _, id := range []string{"user-1", "user-2"} {
+		dp, err := repo.Get(id, "", ctx)
+		require.Nil(t, err)
+		resource, err := binaryMarshal(dp)
+		require.Nil(t, err)
+		resources = append(resources, resource)
+	}
+	// in the order searched
+	assert.Contains(t, [][]byte{bytes.Join(resources, nil), append(resources[1], resources[0]...)}, dump)
+
+	job = &ExportJob{ResourceType: UserResourceType, Filter: "bogus eq"}
+	require.Nil(t, SubmitExport(job, store, ctx))
+	assert.NotNil(t, RunExport(job, repo, store, 100, marshal, ctx))
+	failed, err := store.Get(job.Id)
+	require.Nil(t, err)
+	assert.Equal(t, OperationFailed, failed.Status)
+	assert.NotEmpty(t, failed.Error)
+
+	// a partial page would leave resources out of the dump
+	job = &ExportJob{ResourceType: UserResourceType}
+	require.Nil(t, SubmitExport(job, store, ctx))
+	assert.NotNil(t, RunExport(job, &pagedRepository{Repository: repo, pageSize: 100, partial: true}, store, 100, marshal, ctx))
+	failed, err = store.Get(job.Id)
+	require.Nil(t, err)
+	assert.Equal(t, OperationFailed, failed.Status)
+}
+
+func TestExportStore_Expire(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "export")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, store := range []ExportStore{NewMemoryExportStore(), NewFileExportStore(dir)} {
+		jobs := make([]*ExportJob, 0)
+		for _, status := range []string{OperationSucceeded, OperationFailed, OperationRunning, OperationSucceeded} {
+			job := &ExportJob{ResourceType: UserResourceType}
+			require.Nil(t, SubmitExport(job, store, ctx))
+			dump, err := store.Create(job.Id)
+			require.Nil(t, err)
+			dump.Write([]byte("{}\n"))
+			require.Nil(t, dump.Close())
+			job.Status = status
+			job.LastModified = time.Now().Add(-2 * time.Hour)
+			require.Nil(t, store.Put(job))
+			jobs = append(jobs, job)
+		}
+		jobs[3].LastModified = time.Now()
+		require.Nil(t, store.Put(jobs[3]))
+
+		// finished ones are deleted once expired, running ones kept
+		require.Nil(t, store.Expire(time.Now().Add(-time.Hour)))
+		for i, job := range jobs {
+			_, err := store.Get(job.Id)
+			_, readErr := store.ReadRange(job.Id, 0, 3)
+			if i < 2 {
+				assert.IsType(t, &ResourceNotFoundError{}, err)
+				assert.IsType(t, &ResourceNotFoundError{}, readErr)
+			} else {
+				assert.Nil(t, err)
+				assert.Nil(t, readErr)
+			}
+		}
+
+		require.Nil(t, store.Delete(jobs[3].Id))
+		_, err := store.Get(jobs[3].Id)
+		assert.IsType(t, &ResourceNotFoundError{}, err)
+		_, err = store.ReadRange(jobs[3].Id, 0, 3)
+		assert.IsType(t, &ResourceNotFoundError{}, err)
+	}
+}
+
+func TestGetExport(t *testing.T) {
+	store := NewMemoryExportStore()
+	okta := context.WithValue(context.Background(), Principal{}, "okta")
+	job := &ExportJob{ResourceType: UserResourceType}
+	require.Nil(t, SubmitExport(job, store, okta))
+
+	got, err := GetExport(job.Id, store, okta)
+	require.Nil(t, err)
+	assert.Equal(t, job.Id, got.Id)
+	for _, ctx := range []context.Context{context.Background(), context.WithValue(context.Background(), Principal{}, "azure")} {
+		_, err = GetExport(job.Id, store, ctx)
+		assert.IsType(t, &ResourceNotFoundError{}, err)
+	}
+}
@@ -0,0 +1,320 @@
+package shared
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	And        = "and"
+	Or         = "or"
+	Not        = "not"
+	Eq         = "eq"
+	Ne         = "ne"
+	Sw         = "sw"
+	Ew         = "ew"
+	Co         = "co"
+	Pr         = "pr"
+	Gt         = "gt"
+	Ge         = "ge"
+	Lt         = "lt"
+	Le         = "le"
+	leftParen  = "("
+	rightParen = ")"
+)
+
+// the operators of the filter syntax, names registered operators cannot take
+var filterKeywords = map[string]FilterNodeType{
+	And: LogicalOperator,
+	Or:  LogicalOperator,
+	Not: LogicalOperator,
+	Eq:  RelationalOperator,
+	Ne:  RelationalOperator,
+	Sw:  RelationalOperator,
+	Ew:  RelationalOperator,
+	Co:  RelationalOperator,
+	Pr:  RelationalOperator,
+	Gt:  RelationalOperator,
+	Ge:  RelationalOperator,
+	Lt:  RelationalOperator,
+	Le:  RelationalOperator,
+}
+
+// filter lexer
+const (
+	quoteRune        = '"'
+	commaRune        = ','
+	periodRune       = '.'
+	leftBracketRune  = '['
+	rightBracketRune = ']'
+	leftParenRune    = '('
+	rightParenRune   = ')'
+)
+
+type filterTokenKind int
+
+const (
+	wordToken = filterTokenKind(iota + 1) // attribute paths, operators and literals other than strings
+	stringToken
+	leftParenToken
+	rightParenToken
+	endToken
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string // as in the filter, strings with their quotes
+	pos  int    // of the first character, counted from 1
+}
+
+func (t filterToken) String() string {
+	return fmt.Sprintf("'%s' at position %d", t.text, t.pos)
+}
+
+// split the filter into tokens, ending with one of endToken
+func lexFilter(text string) ([]filterToken, error) {
+	runes := []rune(text)
+	tokens := make([]filterToken, 0)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == leftParenRune:
+			tokens = append(tokens, filterToken{kind: leftParenToken, text: leftParen, pos: i + 1})
+			i++
+
+		case r == rightParenRune:
+			tokens = append(tokens, filterToken{kind: rightParenToken, text: rightParen, pos: i + 1})
+			i++
+
+		case r == quoteRune:
+			// quotes within strings, i.e. those of W/"1" in meta.version eq "W/"1"", end them only when followed by
+			// a space, a closing parenthesis or the end of the filter
+			end := i + 1
+			for end < len(runes) && !(runes[end] == quoteRune &&
+				(end+1 == len(runes) || unicode.IsSpace(runes[end+1]) || runes[end+1] == rightParenRune)) {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at position %d", i+1)
+			}
+			tokens = append(tokens, filterToken{kind: stringToken, text: string(runes[i : end+1]), pos: i + 1})
+			i = end + 1
+
+		case r == leftBracketRune || r == rightBracketRune:
+			return nil, fmt.Errorf("unexpected token '%c' at position %d, value filters are not supported here", r, i+1)
+
+		case r == commaRune:
+			return nil, fmt.Errorf("unexpected token '%c' at position %d", r, i+1)
+
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("\"()[],", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: wordToken, text: string(runes[i:end]), pos: i + 1})
+			i = end
+		}
+	}
+	return append(tokens, filterToken{kind: endToken, pos: len(runes) + 1}), nil
+}
+
+// The value as a string of a filter, i.e. to compare an attribute with an id. Filter strings have no escapes, so
+// a value with a quote that lexFilter would take for the end of the string, one followed by a space or a closing
+// parenthesis, cannot be written and is refused rather than let alter the filter.
+func QuoteFilterString(value string) (string, error) {
+	runes := []rune(value)
+	for i, r := range runes {
+		if r == quoteRune && i+1 < len(runes) && (unicode.IsSpace(runes[i+1]) || runes[i+1] == rightParenRune) {
+			return "", Error.InvalidFilter(value, fmt.Sprintf("value with a quote at position %d cannot be written as a filter string", i+1))
+		}
+	}
+	return string(quoteRune) + value + string(quoteRune), nil
+}
+
+// filter parser, by recursive descent on
+//
+//	filter     = and *("or" and)
+//	and        = not *("and" not)
+//	not        = "not" not / "(" filter ")" / comparison
+//	comparison = path "pr" / path operator value
+//
+// where and and or are left associative, and not, like pr, has its single operand on the left. Parentheses and
+// nots nest at most maxFilterNesting levels deep, whatever the FilterLimits of the server, so that the recursion
+// is bounded.
+type filterParser struct {
+	tokens []filterToken
+	i      int
+	depth  int
+}
+
+const maxFilterNesting = 100
+
+func (p *filterParser) peek() filterToken { return p.tokens[p.i] }
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.i]
+	if t.kind != endToken {
+		p.i++
+	}
+	return t
+}
+
+// whether the token is the word, case insensitive
+func (p *filterParser) isWord(t filterToken, word string) bool {
+	return t.kind == wordToken && strings.EqualFold(t.text, word)
+}
+
+func (p *filterParser) unexpected(t filterToken, expect string) error {
+	if t.kind == endToken {
+		return fmt.Errorf("unexpected end of filter at position %d, expecting %s", t.pos, expect)
+	}
+	return fmt.Errorf("unexpected token %s, expecting %s", t, expect)
+}
+
+func (p *filterParser) parse() (*filterNode, error) {
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != endToken {
+		if t.kind == rightParenToken {
+			return nil, fmt.Errorf("unexpected token %s", t)
+		}
+		return nil, p.unexpected(t, "'and', 'or' or the end of the filter")
+	}
+	return root, nil
+}
+
+func (p *filterParser) parseOr() (*filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isWord(p.peek(), Or) {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterNode{data: Or, typ: LogicalOperator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (*filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isWord(p.peek(), And) {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterNode{data: And, typ: LogicalOperator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (*filterNode, error) {
+	t := p.peek()
+	if t.kind == leftParenToken || p.isWord(t, Not) {
+		if p.depth++; p.depth > maxFilterNesting {
+			return nil, fmt.Errorf("token %s nests deeper than the maximum of %d levels", t, maxFilterNesting)
+		}
+		defer func() { p.depth-- }()
+	}
+	switch {
+	case p.isWord(t, Not):
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNode{data: Not, typ: LogicalOperator, left: operand}, nil
+
+	case t.kind == leftParenToken:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != rightParenToken {
+			return nil, p.unexpected(closing, fmt.Sprintf("')' to close '(' at position %d", t.pos))
+		}
+		return inner, nil
+
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *filterParser) parseComparison() (*filterNode, error) {
+	t := p.next()
+	if t.kind != wordToken || filterKeywords[strings.ToLower(t.text)] != 0 {
+		return nil, p.unexpected(t, "an attribute path")
+	}
+	attrPath, err := NewPath(t.text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attribute path %s: %s", t, err)
+	}
+	left := &filterNode{data: attrPath, typ: PathOperand}
+
+	t = p.next()
+	if t.kind != wordToken {
+		return nil, p.unexpected(t, "an operator")
+	}
+	var op string
+	if filterKeywords[strings.ToLower(t.text)] == RelationalOperator {
+		op = strings.ToLower(t.text)
+	} else if registered := LookupFilterOperator(t.text); registered != nil {
+		op = registered.Name
+	} else {
+		return nil, fmt.Errorf("unknown operator %s", t)
+	}
+	if op == Pr {
+		return &filterNode{data: Pr, typ: RelationalOperator, left: left}, nil
+	}
+
+	right, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &filterNode{data: op, typ: RelationalOperator, left: left, right: right}, nil
+}
+
+// a string, boolean or number, or an attribute path, i.e. null
+func (p *filterParser) parseValue() (*filterNode, error) {
+	t := p.next()
+	switch t.kind {
+	case stringToken:
+		return &filterNode{data: t.text[1 : len(t.text)-1], typ: ConstantOperand}, nil
+	case wordToken:
+		if filterKeywords[strings.ToLower(t.text)] != 0 {
+			break
+		}
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &filterNode{data: true, typ: ConstantOperand}, nil
+		case "false":
+			return &filterNode{data: false, typ: ConstantOperand}, nil
+		}
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &filterNode{data: i, typ: ConstantOperand}, nil
+		} else if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return &filterNode{data: f, typ: ConstantOperand}, nil
+		}
+		valuePath, err := NewPath(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %s: %s", t, err)
+		}
+		return &filterNode{data: valuePath, typ: PathOperand}, nil
+	}
+	return nil, p.unexpected(t, "a value")
+}
@@ -0,0 +1,59 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestNewFilter_Errors(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		detail string
+	}{
+		{`(userName eq "david" or age gt 18))`, `unexpected token ')' at position 35`},
+		{`userName eq "david" and (age gt 18`, `unexpected end of filter at position 35, expecting ')' to close '(' at position 25`},
+		{`userName eq`, `unexpected end of filter at position 12, expecting a value`},
+		{`userName eq and`, `unexpected token 'and' at position 13, expecting a value`},
+		{`userName xx "david"`, `unknown operator 'xx' at position 10`},
+		{`"david" eq userName`, `unexpected token '"david"' at position 1, expecting an attribute path`},
+		{`userName pr title pr`, `unexpected token 'title' at position 13, expecting 'and', 'or' or the end of the filter`},
+		{`userName eq "david`, `unterminated string starting at position 13`},
+		{`emails[type eq "work"] pr`, `unexpected token '[' at position 7, value filters are not supported here`},
+		{`not`, `unexpected end of filter at position 4, expecting an attribute path`},
+		{strings.Repeat("(", 101) + `userName pr` + strings.Repeat(")", 101), `token '(' at position 101 nests deeper than the maximum of 100 levels`},
+	} {
+		_, err := NewFilter(test.text)
+		require.IsType(t, &InvalidFilterError{}, err, test.text)
+		assert.Equal(t, test.detail, err.(*InvalidFilterError).Detail, test.text)
+	}
+}
+
+func TestNewFilter_Values(t *testing.T) {
+	root, err := NewFilter(`meta.version eq "W/"1"" and (active eq TRUE or age ge 18 or score lt 1.5)`)
+	require.Nil(t, err)
+	assert.Equal(t, And, root.Data())
+	assert.Equal(t, `W/"1"`, root.Left().Right().Data())
+
+	or := root.Right()
+	assert.Equal(t, Or, or.Data())
+	assert.Equal(t, true, or.Left().Left().Right().Data())
+	assert.Equal(t, int64(18), or.Left().Right().Right().Data())
+	assert.Equal(t, 1.5, or.Right().Right().Data())
+}
+
+func TestQuoteFilterString(t *testing.T) {
+	for _, value := range []string{"2819c223", `W/"1"`, `ends with "`, "(parenthesized)"} {
+		quoted, err := QuoteFilterString(value)
+		require.Nil(t, err, value)
+		root, err := NewFilter("groups.value eq " + quoted)
+		require.Nil(t, err, value)
+		assert.Equal(t, value, root.Right().Data())
+	}
+
+	for _, value := range []string{`x" or userName pr "`, `x") or (userName pr`} {
+		_, err := QuoteFilterString(value)
+		assert.IsType(t, &InvalidFilterError{}, err, value)
+	}
+}
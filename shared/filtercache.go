@@ -0,0 +1,167 @@
+package shared
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Optionally implemented by repositories that compile filters, so that they can share a FilterCache
+type FilterCacheUser interface {
+	UseFilterCache(cache *FilterCache)
+}
+
+// A least recently used cache of compiled filters keyed by the filter text and the id of the schema it was
+// compiled against. Identity providers tend to repeat the same filters, i.e. userName eq "...", which then
+// need not be parsed again. Besides the syntax tree, an entry keeps the query each backend translated the tree
+// to. Filters of the same CanonicalFilter share their tree and queries, so that a filter differing from a
+// cached one in case, spacing or the order of its operands is parsed but not translated again. Cached trees
+// and queries are shared between requests and must not be modified.
+//
+// A nil cache compiles every filter anew.
+type FilterCache struct {
+	sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front is the most recently used
+	hits     uint64
+	misses   uint64
+	lookups  Counter
+}
+
+type filterPlan struct {
+	root    FilterNode
+	queries map[string]interface{} // by backend
+}
+
+// an element of the order of a FilterCache, the plan of the filter text or canonical filter of the key
+type filterEntry struct {
+	key  string
+	plan *filterPlan
+}
+
+// Returns a cache holding up to capacity filters, counting lookups on the metrics, which may be nil
+func NewFilterCache(capacity int, metrics *Metrics) *FilterCache {
+	c := &FilterCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		lookups:  noOpMetric{},
+	}
+	if metrics != nil && metrics.FilterCacheLookups != nil {
+		c.lookups = metrics.FilterCacheLookups
+	}
+	return c
+}
+
+// Compile the filter against the schema like CompileFilter, reusing the tree of an earlier compilation.
+// Filters that fail to compile are not cached.
+func (c *FilterCache) Compile(text string, sch *Schema) (FilterNode, error) {
+	if c == nil {
+		return CompileFilter(text, sch)
+	}
+	plan, err := c.plan(text, sch)
+	if err != nil {
+		return nil, err
+	}
+	return plan.root, nil
+}
+
+// Compile the filter against the schema and translate the tree to the query language of the backend,
+// reusing the query of an earlier translation
+func (c *FilterCache) Query(text string, sch *Schema, backend string, translate func(root FilterNode) (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		root, err := CompileFilter(text, sch)
+		if err != nil {
+			return nil, err
+		}
+		return translate(root)
+	}
+
+	plan, err := c.plan(text, sch)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	query, ok := plan.queries[backend]
+	c.Unlock()
+	if ok {
+		return query, nil
+	}
+
+	query, err = translate(plan.root)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	plan.queries[backend] = query
+	c.Unlock()
+	return query, nil
+}
+
+// The share of lookups answered from the cache so far
+func (c *FilterCache) HitRate() float64 {
+	if c == nil {
+		return 0
+	}
+	hits, misses := atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func (c *FilterCache) plan(text string, sch *Schema) (*filterPlan, error) {
+	key := filterCacheKey(text, sch)
+
+	c.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		c.lookups.Inc("hit")
+		return element.Value.(*filterEntry).plan, nil
+	}
+	c.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+	c.lookups.Inc("miss")
+
+	root, err := CompileFilter(text, sch)
+	if err != nil {
+		return nil, err
+	}
+	canonical := filterCacheKey(CanonicalFilter(root), sch)
+
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[key]; ok {
+		// compiled concurrently, keep the first
+		return element.Value.(*filterEntry).plan, nil
+	}
+	plan := &filterPlan{root: root, queries: make(map[string]interface{})}
+	if c.capacity <= 0 {
+		return plan, nil
+	}
+	if element, ok := c.entries[canonical]; ok {
+		c.order.MoveToFront(element)
+		plan = element.Value.(*filterEntry).plan
+	} else {
+		c.entries[canonical] = c.order.PushFront(&filterEntry{key: canonical, plan: plan})
+	}
+	if key != canonical {
+		c.entries[key] = c.order.PushFront(&filterEntry{key: key, plan: plan})
+	}
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*filterEntry).key)
+	}
+	return plan, nil
+}
+
+func filterCacheKey(text string, sch *Schema) string {
+	if sch == nil {
+		return text
+	}
+	return sch.Id + " " + text
+}
@@ -0,0 +1,114 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFilterCache(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	registerer := &recordingRegisterer{counts: map[string]int{}, observations: map[string]int{}}
+	cache := NewFilterCache(2, NewMetrics(registerer))
+
+	first, err := cache.Compile(`userName eq "david"`, sch)
+	require.Nil(t, err)
+	second, err := cache.Compile(`userName eq "david"`, sch)
+	require.Nil(t, err)
+	assert.True(t, first == second)
+	assert.Equal(t, 1, registerer.counts[MetricFilterCacheLookups+"|hit"])
+	assert.Equal(t, 1, registerer.counts[MetricFilterCacheLookups+"|miss"])
+	assert.Equal(t, 0.5, cache.HitRate())
+
+	// invalid filters are not cached
+	_, err = cache.Compile(`userName eq`, sch)
+	assert.NotNil(t, err)
+	_, err = cache.Compile(`userName eq`, sch)
+	assert.NotNil(t, err)
+	_, ok := cache.entries[sch.Id+` userName eq`]
+	assert.False(t, ok)
+}
+
+func TestFilterCache_Eviction(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	cache := NewFilterCache(2, nil)
+
+	a, _ := cache.Compile(`userName eq "a"`, sch)
+	cache.Compile(`userName eq "b"`, sch)
+	cache.Compile(`userName eq "a"`, sch) // "a" is now the most recently used
+	cache.Compile(`userName eq "c"`, sch)
+
+	assert.Equal(t, 2, cache.order.Len())
+	again, _ := cache.Compile(`userName eq "a"`, sch)
+	assert.True(t, a == again)
+	_, ok := cache.entries[sch.Id+` userName eq "b"`]
+	assert.False(t, ok)
+}
+
+func TestFilterCache_Canonical(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	cache := NewFilterCache(10, nil)
+
+	translations := 0
+	translate := func(root FilterNode) (interface{}, error) {
+		translations++
+		return CanonicalFilter(root), nil
+	}
+	for _, text := range []string{`userName eq "a" and active eq true`, `ACTIVE eq true and (userName eq "a")`} {
+		q, err := cache.Query(text, sch, "test", translate)
+		require.Nil(t, err)
+		assert.Equal(t, `active eq true and userName eq "a"`, q)
+	}
+	assert.Equal(t, 1, translations)
+	assert.Equal(t, 3, cache.order.Len())
+}
+
+func TestFilterCache_Query(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	translations := 0
+	translate := func(root FilterNode) (interface{}, error) {
+		translations++
+		return root.Type(), nil
+	}
+	for _, cache := range []*FilterCache{NewFilterCache(10, nil), nil} {
+		translations = 0
+		for i := 0; i < 3; i++ {
+			q, err := cache.Query(`userName eq "david"`, sch, "test", translate)
+			require.Nil(t, err)
+			assert.Equal(t, RelationalOperator, q)
+		}
+		if cache == nil {
+			assert.Equal(t, 3, translations)
+		} else {
+			assert.Equal(t, 1, translations)
+		}
+	}
+}
+
+func BenchmarkCompileFilter(b *testing.B) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(b, err)
+	text := `userName eq "david" and (emails.value ew "@example.com" or not (active eq false)) and meta.lastModified gt "2020-01-01T00:00:00Z"`
+	for _, bench := range []struct {
+		name  string
+		cache *FilterCache
+	}{
+		{"Uncached", nil},
+		{"Cached", NewFilterCache(16, nil)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.cache.Compile(text, sch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
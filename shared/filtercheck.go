@@ -0,0 +1,167 @@
+package shared
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bounds of the filters of clients, guarding the parser and the repositories against filters crafted to
+// exhaust them, i.e. thousands of or'ed comparisons. A bound of 0 is not checked.
+type FilterLimits struct {
+	MaxDepth   int // levels of nested logical operators, i.e. 2 for a and (b or c)
+	MaxClauses int // comparisons, i.e. 3 for a and (b or c)
+}
+
+// Fail with an invalid filter when the compiled filter nests deeper or has more comparisons than the limits allow
+func (l FilterLimits) Check(text string, root FilterNode) error {
+	n, _ := root.(*filterNode)
+	if l.MaxDepth > 0 {
+		if depth := filterDepth(n); depth > l.MaxDepth {
+			return Error.InvalidFilter(text, fmt.Sprintf("filter nests %d levels deep, more than the maximum of %d", depth, l.MaxDepth))
+		}
+	}
+	if l.MaxClauses > 0 {
+		if clauses := filterClauses(n); clauses > l.MaxClauses {
+			return Error.InvalidFilter(text, fmt.Sprintf("filter has %d comparisons, more than the maximum of %d", clauses, l.MaxClauses))
+		}
+	}
+	return nil
+}
+
+// levels of logical operators, where a chain of the same operator, like a and b and c, is a single level
+func filterDepth(n *filterNode) int {
+	if n == nil || n.typ != LogicalOperator {
+		return 0
+	}
+	deepest := 0
+	for _, operand := range logicalOperands(n) {
+		if depth := filterDepth(operand); depth > deepest {
+			deepest = depth
+		}
+	}
+	return deepest + 1
+}
+
+func filterClauses(n *filterNode) int {
+	if n == nil {
+		return 0
+	}
+	if n.typ != LogicalOperator {
+		return 1
+	}
+	clauses := 0
+	for _, operand := range logicalOperands(n) {
+		clauses += filterClauses(operand)
+	}
+	return clauses
+}
+
+// the operands of the logical operator, those of nested operators of the same kind included, left to right
+func logicalOperands(n *filterNode) []*filterNode {
+	if n.data == Not {
+		return []*filterNode{n.left}
+	}
+	operands := make([]*filterNode, 0, 2)
+	for _, operand := range []*filterNode{n.left, n.right} {
+		if operand.typ == LogicalOperator && operand.data == n.data {
+			operands = append(operands, logicalOperands(operand)...)
+		} else {
+			operands = append(operands, operand)
+		}
+	}
+	return operands
+}
+
+// Fail with a forbidden error when the compiled filter compares an attribute that is never returned, i.e.
+// password pr or password eq "...", which would let clients probe values they cannot read
+func CheckFilterReturnable(root FilterNode, guide AttributeSource) error {
+	n, _ := root.(*filterNode)
+	if n == nil || guide == nil {
+		return nil
+	}
+	if n.typ == PathOperand {
+		if attr := guide.GetAttribute(n.data.(Path), true); attr != nil && attr.Returned == Never {
+			return Error.ForbiddenFilter(renderFilterPath(n.data.(Path)))
+		}
+		return nil
+	}
+	if n.left != nil {
+		if err := CheckFilterReturnable(n.left, guide); err != nil {
+			return err
+		}
+	}
+	if n.right != nil {
+		return CheckFilterReturnable(n.right, guide)
+	}
+	return nil
+}
+
+// The canonical form of the compiled filter: operators in lower case, attribute paths in the case CompileFilter
+// corrected them to, the operands of chained and and or operators sorted and only the parentheses needed, so
+// that equivalent filters like `userName eq "a" and active eq true` and `(Active EQ true) AND username eq "a"`
+// render alike. Canonical filters compile to filters matching the same resources; they key FilterCache
+// entries and are logged in place of the filters of clients.
+func CanonicalFilter(root FilterNode) string {
+	n, _ := root.(*filterNode)
+	return canonicalFilter(n)
+}
+
+func canonicalFilter(n *filterNode) string {
+	if n == nil {
+		return ""
+	}
+	switch n.typ {
+	case LogicalOperator:
+		if n.data == Not {
+			return Not + " (" + canonicalFilter(n.left) + ")"
+		}
+		operands := logicalOperands(n)
+		rendered := make([]string, 0, len(operands))
+		for _, operand := range operands {
+			each := canonicalFilter(operand)
+			if n.data == And && operand.typ == LogicalOperator && operand.data == Or {
+				each = "(" + each + ")"
+			}
+			rendered = append(rendered, each)
+		}
+		sort.Strings(rendered)
+		return strings.Join(rendered, " "+n.data.(string)+" ")
+
+	case RelationalOperator:
+		rendered := canonicalFilter(n.left) + " " + strings.ToLower(fmt.Sprint(n.data))
+		if n.right != nil {
+			rendered += " " + canonicalFilter(n.right)
+		}
+		return rendered
+
+	case PathOperand:
+		return renderFilterPath(n.data.(Path))
+
+	default:
+		switch v := n.data.(type) {
+		case string:
+			return `"` + v + `"`
+		case int64:
+			return strconv.FormatInt(v, 10)
+		case float64:
+			// keep decimals decimal, 1.0 rather than 1
+			rendered := strconv.FormatFloat(v, 'g', -1, 64)
+			if !strings.ContainsAny(rendered, ".eIN") {
+				rendered += ".0"
+			}
+			return rendered
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+}
+
+func renderFilterPath(p Path) string {
+	segments := make([]string, 0, 2)
+	for ; p != nil; p = p.Next() {
+		segments = append(segments, p.Base())
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFilterLimits(t *testing.T) {
+	limits := FilterLimits{MaxDepth: 2, MaxClauses: 3}
+	for _, test := range []struct {
+		text   string
+		detail string
+	}{
+		{`userName pr`, ""},
+		{`a pr and b pr and c pr`, ""},
+		{`a pr and (b pr or not (c pr))`, "filter nests 3 levels deep, more than the maximum of 2"},
+		{`a pr or b pr or c pr or d pr`, "filter has 4 comparisons, more than the maximum of 3"},
+	} {
+		root, err := NewFilter(test.text)
+		require.Nil(t, err, test.text)
+		err = limits.Check(test.text, root)
+		if len(test.detail) == 0 {
+			assert.Nil(t, err, test.text)
+			continue
+		}
+		require.IsType(t, &InvalidFilterError{}, err, test.text)
+		assert.Equal(t, test.detail, err.(*InvalidFilterError).Detail, test.text)
+	}
+
+	root, err := NewFilter(`a pr or b pr or c pr or d pr`)
+	require.Nil(t, err)
+	assert.Nil(t, FilterLimits{}.Check("", root))
+}
+
+func TestCheckFilterReturnable(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	for _, text := range []string{`Password pr`, `userName eq "a" or not (password eq "secret")`} {
+		root, err := CompileFilter(text, sch)
+		require.Nil(t, err)
+		err = CheckFilterReturnable(root, sch)
+		require.IsType(t, &ForbiddenError{}, err, text)
+		assert.Equal(t, "Not allowed to filter on attribute at 'password'", err.Error())
+	}
+
+	root, err := CompileFilter(`userName eq "a" and emails.value pr`, sch)
+	require.Nil(t, err)
+	assert.Nil(t, CheckFilterReturnable(root, sch))
+}
+
+func TestCanonicalFilter(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		texts     []string
+		canonical string
+	}{
+		{
+			[]string{`userName eq "a" and active eq true`, `(Active EQ True) AND   USERNAME eq "a"`},
+			`active eq true and userName eq "a"`,
+		},
+		{
+			[]string{`a pr or (b pr or c pr)`, `c pr or b pr or a pr`},
+			`a pr or b pr or c pr`,
+		},
+		{
+			[]string{`(b pr or a pr) and c pr`, `c pr and (a pr or b pr)`},
+			`(a pr or b pr) and c pr`,
+		},
+		{
+			[]string{`not name.givenName sw "J"`, `NOT (name.GIVENNAME sw "J")`},
+			`not (name.givenName sw "J")`,
+		},
+		{
+			[]string{`meta.version eq "W/"1""`},
+			`meta.version eq "W/"1""`,
+		},
+		{
+			[]string{`x gt 1.0 and y le 2 and z ne null`},
+			`x gt 1.0 and y le 2 and z ne null`,
+		},
+	} {
+		for _, text := range test.texts {
+			root, err := CompileFilter(text, sch)
+			require.Nil(t, err, text)
+			canonical := CanonicalFilter(root)
+			assert.Equal(t, test.canonical, canonical, text)
+
+			// the canonical form is canonical itself
+			again, err := CompileFilter(canonical, sch)
+			require.Nil(t, err, canonical)
+			assert.Equal(t, canonical, CanonicalFilter(again))
+		}
+	}
+}
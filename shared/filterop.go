@@ -0,0 +1,86 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A custom relational operator of filters, i.e. 'sx' for displayName values that sound alike. Registered
+// operators are parsed like the binary operators of RFC 7644 and bind as tightly, taking an attribute path on
+// the left and a value on the right, i.e. 'displayName sx "Jon"'. CompileFilter rejects them on attributes of
+// types they do not apply to. The map repository evaluates them with Match; other repositories translate them
+// themselves, i.e. with mongo.RegisterFilterTranslation, and fail filters with operators they cannot.
+type FilterOperator struct {
+	Name  string   // matched case insensitively, an attribute of the same name can no longer be filtered on
+	Types []string // the attribute types the operator applies to, i.e. TypeString, all but complex if empty
+	// Tell whether a value of the attribute matches the value of the filter, nil when the operator is not
+	// evaluated in memory
+	Match func(attr *Attribute, value, operand interface{}) bool
+}
+
+// Whether the operator applies to attributes of the type
+func (op *FilterOperator) AppliesTo(typ string) bool {
+	if len(op.Types) == 0 {
+		return typ != TypeComplex
+	}
+	for _, each := range op.Types {
+		if each == typ {
+			return true
+		}
+	}
+	return false
+}
+
+var filterOperators = struct {
+	sync.RWMutex
+	byName map[string]*FilterOperator
+}{byName: make(map[string]*FilterOperator)}
+
+// Make the operator available to all filters. Operators are to be registered before filters using them are
+// parsed, i.e. while the server starts; a later registration of the same name replaces the earlier one.
+func RegisterFilterOperator(op *FilterOperator) error {
+	name := strings.ToLower(strings.TrimSpace(op.Name))
+	if len(name) == 0 || strings.ContainsAny(name, " \"()[],.") {
+		return Error.InvalidParam("filter operator name", "a single word", fmt.Sprintf("'%s'", op.Name))
+	}
+	if _, standard := filterKeywords[name]; standard || name == leftParen || name == rightParen {
+		return Error.InvalidParam("filter operator name", "other than that of a standard operator", name)
+	}
+
+	registered := *op
+	registered.Name = name
+	filterOperators.Lock()
+	defer filterOperators.Unlock()
+	filterOperators.byName[name] = &registered
+	return nil
+}
+
+// The registered operator of the name, nil when there is none
+func LookupFilterOperator(name string) *FilterOperator {
+	filterOperators.RLock()
+	defer filterOperators.RUnlock()
+	return filterOperators.byName[strings.ToLower(name)]
+}
+
+// reject registered operators used on attributes of types they do not apply to, or with other operands than
+// an attribute path and a value
+func checkFilterOperators(text string, n *filterNode, guide AttributeSource) error {
+	if n == nil {
+		return nil
+	}
+	if n.typ == RelationalOperator {
+		if op := LookupFilterOperator(fmt.Sprint(n.data)); op != nil {
+			if n.left == nil || n.left.typ != PathOperand || n.right == nil || n.right.typ != ConstantOperand {
+				return Error.InvalidFilter(text, fmt.Sprintf("%s operator expects an attribute path and a value", op.Name))
+			}
+			if attr := guide.GetAttribute(n.left.data.(Path), true); attr != nil && !op.AppliesTo(attr.Type) {
+				return Error.InvalidFilter(text, fmt.Sprintf("Cannot use %s operator on %s attributes", op.Name, attr.Type))
+			}
+		}
+	}
+	if err := checkFilterOperators(text, n.left, guide); err != nil {
+		return err
+	}
+	return checkFilterOperators(text, n.right, guide)
+}
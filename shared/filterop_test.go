@@ -0,0 +1,59 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterOperator(t *testing.T) {
+	// shell patterns, i.e. 'displayName wc "J*n"'
+	require.Nil(t, RegisterFilterOperator(&FilterOperator{
+		Name:  "WC",
+		Types: []string{TypeString, TypeReference},
+		Match: func(attr *Attribute, value, operand interface{}) bool {
+			text, _ := value.(string)
+			pattern, _ := operand.(string)
+			matched, _ := filepath.Match(pattern, text)
+			return matched
+		},
+	}))
+	assert.NotNil(t, LookupFilterOperator("wc"))
+	assert.IsType(t, &InvalidParamError{}, RegisterFilterOperator(&FilterOperator{Name: "co"}))
+	assert.IsType(t, &InvalidParamError{}, RegisterFilterOperator(&FilterOperator{Name: "sounds like"}))
+
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	data := Complex{
+		"userName":    "david",
+		"displayName": "John",
+		"emails":      []interface{}{map[string]interface{}{"value": "david@example.com"}},
+	}
+	for _, test := range []struct {
+		filterText string
+		expect     bool
+	}{
+		{`displayName wc "J*n"`, true},
+		{`displayName WC "J?n"`, false},
+		{`emails.value wc "*@example.com"`, true},
+		{`userName eq "alice" or displayName wc "Jo*"`, true},
+		{`not (displayName wc "Jo*") and userName eq "david"`, false},
+	} {
+		filter, err := CompileFilter(test.filterText, sch)
+		require.Nil(t, err, test.filterText)
+		assert.Equal(t, test.expect, data.Evaluate(filter, sch), test.filterText)
+	}
+
+	for _, text := range []string{`active wc "t*"`, `displayName wc userName`} {
+		_, err := CompileFilter(text, sch)
+		assert.IsType(t, &InvalidFilterError{}, err, text)
+	}
+
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{"foo": &Resource{Complex: data}})
+	lr, err := repo.Search(SearchRequest{Filter: `displayName wc "J*"`, StartIndex: 1, Count: 10}, context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, 1, lr.TotalResults)
+}
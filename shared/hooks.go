@@ -0,0 +1,181 @@
+package shared
+
+import (
+	"context"
+	"sync"
+)
+
+// Called with the resource about to be created, or just created
+type CreateHook func(resource *Resource, ctx context.Context) error
+
+// Called with the resource about to be stored, or just stored, and the reference it replaces
+type UpdateHook func(resource *Resource, reference *Resource, ctx context.Context) error
+
+// Called with the id of the resource about to be deleted, or just deleted
+type DeleteHook func(id string, ctx context.Context) error
+
+// Called with the id of the user whose password was just changed through ChangeUserPasswordHandler, i.e. to
+// write an audit event; the principal is found in the context
+type PasswordChangeHook func(id string, ctx context.Context) error
+
+// Registry of lifecycle hooks per resource type. Before hooks run after all validation and read only
+// assignment and may modify the resource; an error aborts the request. After hooks run once the
+// repository write succeeded; their errors no longer affect the response. In queued provisioning mode
+// after hooks are not invoked, since the write happens later.
+type Hooks struct {
+	sync.RWMutex
+	beforeCreate   map[string][]CreateHook
+	afterCreate    map[string][]CreateHook
+	beforeUpdate   map[string][]UpdateHook
+	afterUpdate    map[string][]UpdateHook
+	beforeDelete   map[string][]DeleteHook
+	afterDelete    map[string][]DeleteHook
+	passwordChange []PasswordChangeHook
+}
+
+func NewHooks() *Hooks {
+	return &Hooks{
+		beforeCreate: make(map[string][]CreateHook),
+		afterCreate:  make(map[string][]CreateHook),
+		beforeUpdate: make(map[string][]UpdateHook),
+		afterUpdate:  make(map[string][]UpdateHook),
+		beforeDelete: make(map[string][]DeleteHook),
+		afterDelete:  make(map[string][]DeleteHook),
+	}
+}
+
+func (h *Hooks) BeforeCreate(resourceType string, hook CreateHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.beforeCreate[resourceType] = append(h.beforeCreate[resourceType], hook)
+	return h
+}
+
+func (h *Hooks) AfterCreate(resourceType string, hook CreateHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.afterCreate[resourceType] = append(h.afterCreate[resourceType], hook)
+	return h
+}
+
+func (h *Hooks) BeforeUpdate(resourceType string, hook UpdateHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.beforeUpdate[resourceType] = append(h.beforeUpdate[resourceType], hook)
+	return h
+}
+
+func (h *Hooks) AfterUpdate(resourceType string, hook UpdateHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.afterUpdate[resourceType] = append(h.afterUpdate[resourceType], hook)
+	return h
+}
+
+func (h *Hooks) BeforeDelete(resourceType string, hook DeleteHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.beforeDelete[resourceType] = append(h.beforeDelete[resourceType], hook)
+	return h
+}
+
+func (h *Hooks) AfterDelete(resourceType string, hook DeleteHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.afterDelete[resourceType] = append(h.afterDelete[resourceType], hook)
+	return h
+}
+
+// Run the create hooks registered for the resource type in registration order, stopping at the first error
+func (h *Hooks) RunCreate(before bool, resourceType string, resource *Resource, ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	hooks := h.afterCreate[resourceType]
+	if before {
+		hooks = h.beforeCreate[resourceType]
+	}
+	h.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(resource, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether before or after update hooks are registered for the resource type
+func (h *Hooks) HasUpdateHooks(resourceType string) bool {
+	if h == nil {
+		return false
+	}
+	h.RLock()
+	defer h.RUnlock()
+	return len(h.beforeUpdate[resourceType]) > 0 || len(h.afterUpdate[resourceType]) > 0
+}
+
+// Run the update hooks registered for the resource type in registration order, stopping at the first error
+func (h *Hooks) RunUpdate(before bool, resourceType string, resource *Resource, reference *Resource, ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	hooks := h.afterUpdate[resourceType]
+	if before {
+		hooks = h.beforeUpdate[resourceType]
+	}
+	h.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(resource, reference, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run the delete hooks registered for the resource type in registration order, stopping at the first error
+func (h *Hooks) RunDelete(before bool, resourceType string, id string, ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	hooks := h.afterDelete[resourceType]
+	if before {
+		hooks = h.beforeDelete[resourceType]
+	}
+	h.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(id, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) AfterPasswordChange(hook PasswordChangeHook) *Hooks {
+	h.Lock()
+	defer h.Unlock()
+	h.passwordChange = append(h.passwordChange, hook)
+	return h
+}
+
+// Run the password change hooks in registration order, stopping at the first error
+func (h *Hooks) RunPasswordChange(id string, ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	hooks := h.passwordChange
+	h.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(id, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
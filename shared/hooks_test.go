@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	calls := make([]string, 0)
+	hooks := NewHooks().
+		BeforeCreate(UserResourceType, func(resource *Resource, ctx context.Context) error {
+			calls = append(calls, "first")
+			resource.Complex["department"] = "sales"
+			return nil
+		}).
+		BeforeCreate(UserResourceType, func(resource *Resource, ctx context.Context) error {
+			calls = append(calls, "second")
+			return errors.New("rejected")
+		}).
+		BeforeCreate(UserResourceType, func(resource *Resource, ctx context.Context) error {
+			calls = append(calls, "third")
+			return nil
+		}).
+		BeforeCreate(GroupResourceType, func(resource *Resource, ctx context.Context) error {
+			calls = append(calls, "group")
+			return nil
+		})
+
+	r := &Resource{Complex: Complex{}}
+	err := hooks.RunCreate(true, UserResourceType, r, context.Background())
+	assert.EqualError(t, err, "rejected")
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.Equal(t, "sales", r.Complex["department"])
+
+	assert.Nil(t, hooks.RunCreate(false, UserResourceType, r, context.Background()))
+	assert.Nil(t, hooks.RunUpdate(true, UserResourceType, r, r, context.Background()))
+	assert.Nil(t, hooks.RunDelete(true, UserResourceType, "foo", context.Background()))
+
+	var none *Hooks
+	assert.Nil(t, none.RunCreate(true, UserResourceType, r, context.Background()))
+}
@@ -0,0 +1,117 @@
+package shared
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Remembers the id of the resource created for an idempotency key, so that a create retried
+// by the client with the same Idempotency-Key header can be recognized as a replay.
+type IdempotencyCache interface {
+	Get(key string) (id string, ok bool)
+	Put(key, id string)
+}
+
+// Returns an in memory idempotency cache that forgets keys after ttl
+func NewIdempotencyCache(ttl time.Duration) IdempotencyCache {
+	return &mapIdempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+type mapIdempotencyCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]idempotencyEntry
+	now     func() time.Time
+}
+
+type idempotencyEntry struct {
+	id      string
+	expires time.Time
+}
+
+func (c *mapIdempotencyCache) Get(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.id, true
+}
+
+func (c *mapIdempotencyCache) Put(key, id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = idempotencyEntry{id: id, expires: now.Add(c.ttl)}
+}
+
+// Resolves the idempotency cache key of a create request from its Idempotency-Key header, scoped to
+// the resource type and the authenticated subject. Returns an empty string when the header is absent.
+func IdempotencyKey(req WebRequest, resourceType string, ctx context.Context) string {
+	key := req.Header("Idempotency-Key")
+	if len(key) == 0 {
+		return ""
+	}
+	principal, _ := ctx.Value(Principal{}).(string)
+	return resourceType + ":" + principal + ":" + key
+}
+
+// Detect a replayed create: a create whose idempotency key was used for a resource that still exists, or
+// whose externalId is already held by a stored resource. A replay is reported as a DuplicateError carrying
+// the id of the resource created first. The cache may be nil and the key empty to skip the key lookup.
+func DetectReplay(subj *Resource, repo Repository, cache IdempotencyCache, key string, ctx context.Context) error {
+	if cache != nil && len(key) > 0 {
+		if id, ok := cache.Get(key); ok {
+			_, err := repo.Get(id, "", ctx)
+			switch err.(type) {
+			case nil:
+				dup := Error.Duplicate("Idempotency-Key", key).(*DuplicateError)
+				dup.ExistingId = id
+				return dup
+			case *ResourceNotFoundError:
+			default:
+				return err
+			}
+		}
+	}
+
+	externalId, ok := subj.Complex["externalId"].(string)
+	if !ok || len(externalId) == 0 {
+		return nil
+	}
+	value, err := QuoteFilterString(externalId)
+	if err != nil {
+		return Error.InvalidParam("externalId", "a value that can be written as a filter string", externalId)
+	}
+	lr, err := repo.Search(SearchRequest{
+		Filter:     "externalId eq " + value,
+		StartIndex: 1,
+		Count:      1,
+	}, ctx)
+	if err != nil {
+		return err
+	}
+	if len(lr.Resources) > 0 {
+		dup := Error.Duplicate("externalId", externalId).(*DuplicateError)
+		dup.ExistingId = lr.Resources[0].GetId()
+		return dup
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewIdempotencyCache(time.Minute).(*mapIdempotencyCache)
+	cache.now = func() time.Time { return now }
+
+	_, ok := cache.Get("a")
+	assert.False(t, ok)
+
+	cache.Put("a", "foo")
+	id, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", id)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	ctx := context.WithValue(context.Background(), Principal{}, "okta")
+	assert.Equal(t, "", IdempotencyKey(headerRequest{}, UserResourceType, ctx))
+	assert.Equal(t, "User:okta:k1", IdempotencyKey(headerRequest{"Idempotency-Key": "k1"}, UserResourceType, ctx))
+}
+
+func TestDetectReplay(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"foo": &Resource{Complex: Complex{"id": "foo", "externalId": "ext-1"}},
+	})
+	cache := NewIdempotencyCache(time.Minute)
+	cache.Put("k1", "foo")
+	cache.Put("k2", "deleted")
+
+	for _, test := range []struct {
+		subj      *Resource
+		key       string
+		assertion func(err error)
+	}{
+		{
+			&Resource{Complex: Complex{"userName": "new"}},
+			"",
+			func(err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			&Resource{Complex: Complex{"userName": "new"}},
+			"k1",
+			func(err error) {
+				require.IsType(t, &DuplicateError{}, err)
+				assert.Equal(t, "foo", err.(*DuplicateError).ExistingId)
+			},
+		},
+		{
+			// the resource created for the key is gone, so the create is not a replay
+			&Resource{Complex: Complex{"userName": "new"}},
+			"k2",
+			func(err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			&Resource{Complex: Complex{"userName": "new", "externalId": "ext-1"}},
+			"",
+			func(err error) {
+				require.IsType(t, &DuplicateError{}, err)
+				assert.Equal(t, "externalId", err.(*DuplicateError).Path)
+				assert.Equal(t, "foo", err.(*DuplicateError).ExistingId)
+			},
+		},
+		{
+			// the externalId cannot alter the filter looking for it
+			&Resource{Complex: Complex{"userName": "new", "externalId": `x" or id pr or externalId eq "y`}},
+			"",
+			func(err error) {
+				assert.IsType(t, &InvalidParamError{}, err)
+			},
+		},
+	} {
+		test.assertion(DetectReplay(test.subj, repo, cache, test.key, ctx))
+	}
+}
@@ -0,0 +1,247 @@
+package shared
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// why an index is advised
+const (
+	IndexForUniqueness = "uniqueness" // the attribute is unique, uniqueness validation looks its values up
+	IndexForConstraint = "constraint" // a unique constraint declared on the schema, see Schema.DeclareUnique
+	IndexForFilter     = "filter"     // clients filter on the attribute often
+)
+
+// An index AdviseIndexes recommends to the repository of a resource type
+type IndexAdvice struct {
+	Paths       []string `json:"paths"`       // period delimited, the extension namespace first, several for compound indexes
+	Unique      bool     `json:"unique"`      // no two resources share the values
+	CaseExact   bool     `json:"caseExact"`   // values compare case sensitively
+	MultiValued bool     `json:"multiValued"` // a path runs through a multiValued attribute
+	Sparse      bool     `json:"sparse"`      // resources need not have the attributes
+	Filters     int64    `json:"filters"`     // how often clients filtered on the paths
+	Reason      string   `json:"reason"`
+}
+
+// Recommend the indexes of the repository of a schema: one per attribute marked unique, one per unique
+// constraint declared on the schema and one per attribute clients filtered on at least minFilters times,
+// given the filtered attribute paths counted by AttributeUsage.Filtered or CountFilteredPaths. The id, which
+// repositories key resources on, and complex attributes, on which filters match their value sub attribute, are
+// never advised. Unique attributes come first, in schema order, then constraints, then filtered attributes by
+// descending count.
+func AdviseIndexes(sch *Schema, filtered map[string]int64, minFilters int64) []IndexAdvice {
+	if minFilters < 1 {
+		minFilters = 1
+	}
+	attrs := indexableAttributes(sch.ToAttribute(), nil, false)
+	advice := make([]IndexAdvice, 0)
+	advised := make(map[string]bool)
+
+	for _, each := range attrs {
+		switch each.attr.Uniqueness {
+		case Server, Global:
+			advice = append(advice, IndexAdvice{
+				Paths:       []string{each.path()},
+				Unique:      !each.multiValued,
+				CaseExact:   each.attr.CaseExact,
+				MultiValued: each.multiValued,
+				Sparse:      !each.attr.Required,
+				Filters:     filtered[usagePath(each.attr)],
+				Reason:      IndexForUniqueness,
+			})
+			advised[strings.ToLower(each.path())] = true
+		}
+	}
+
+	byPath := make(map[string]indexable, len(attrs))
+	for _, each := range attrs {
+		byPath[strings.ToLower(each.path())] = each
+	}
+	for _, constraint := range sch.Constraints {
+		a := IndexAdvice{Unique: true, CaseExact: true, Reason: IndexForConstraint}
+		for _, names := range constraint.Paths() {
+			each, ok := byPath[strings.ToLower(strings.Join(names, "."))]
+			if !ok {
+				continue
+			}
+			a.Paths = append(a.Paths, each.path())
+			a.CaseExact = a.CaseExact && each.attr.CaseExact
+			a.MultiValued = a.MultiValued || each.multiValued
+			a.Sparse = a.Sparse || !each.attr.Required
+		}
+		if len(a.Paths) == 0 {
+			continue
+		}
+		a.Unique = !a.MultiValued
+		if len(a.Paths) == 1 {
+			if advised[strings.ToLower(a.Paths[0])] {
+				continue
+			}
+			advised[strings.ToLower(a.Paths[0])] = true
+		}
+		advice = append(advice, a)
+	}
+
+	candidates := make([]IndexAdvice, 0)
+	for _, each := range attrs {
+		n := filtered[usagePath(each.attr)]
+		if n < minFilters || advised[strings.ToLower(each.path())] {
+			continue
+		}
+		candidates = append(candidates, IndexAdvice{
+			Paths:       []string{each.path()},
+			CaseExact:   each.attr.CaseExact,
+			MultiValued: each.multiValued,
+			Sparse:      !each.attr.Required,
+			Filters:     n,
+			Reason:      IndexForFilter,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Filters > candidates[j].Filters })
+	return append(advice, candidates...)
+}
+
+// an attribute that can be indexed, with the names leading to it
+type indexable struct {
+	names       []string
+	attr        *Attribute
+	multiValued bool // the attribute or one of its parents
+}
+
+func (i indexable) path() string {
+	return strings.Join(i.names, ".")
+}
+
+func indexableAttributes(guide *Attribute, names []string, multiValued bool) []indexable {
+	found := make([]indexable, 0)
+	for _, attr := range guide.SubAttributes {
+		path := append(append(make([]string, 0, len(names)+1), names...), attr.Name)
+		if attr.Type == TypeComplex {
+			found = append(found, indexableAttributes(attr, path, multiValued || attr.MultiValued)...)
+			continue
+		}
+		if len(names) == 0 && strings.EqualFold(attr.Name, "id") {
+			continue
+		}
+		found = append(found, indexable{names: path, attr: attr, multiValued: multiValued || attr.MultiValued})
+	}
+	return found
+}
+
+// Count the attribute paths of a log of executed filters, keyed like AttributeUsage.Report. Filters that do not
+// compile against the schema are ignored.
+func CountFilteredPaths(filters []string, sch *Schema) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, filter := range filters {
+		for _, path := range filteredPaths(filter, sch) {
+			counts[path]++
+		}
+	}
+	return counts
+}
+
+// Read a log of executed filters, one per line preceded by the resource type, i.e. User userName eq "david",
+// into the filters by resource type. Empty lines and lines starting with # are skipped.
+func ReadFilterLog(r io.Reader) (map[string][]string, error) {
+	filters := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) < 2 || len(strings.TrimSpace(fields[1])) == 0 {
+			return nil, fmt.Errorf("line %d of the filter log: expect a resource type and a filter", line)
+		}
+		filters[fields[0]] = append(filters[fields[0]], strings.TrimSpace(fields[1]))
+	}
+	return filters, scanner.Err()
+}
+
+// the attribute paths the filter compares, each once
+func filteredPaths(filter string, sch *Schema) []string {
+	root, err := CompileFilter(filter, sch)
+	if err != nil {
+		return nil
+	}
+	paths := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	var walk func(node FilterNode)
+	walk = func(node FilterNode) {
+		if node == nil || node.(*filterNode) == nil {
+			return
+		}
+		if node.Type() == PathOperand {
+			if attr := sch.GetAttribute(node.Data().(Path), true); attr != nil && !seen[usagePath(attr)] {
+				seen[usagePath(attr)] = true
+				paths = append(paths, usagePath(attr))
+			}
+		}
+		walk(node.Left())
+		walk(node.Right())
+	}
+	walk(root)
+	return paths
+}
+
+var sqlIdentifierUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// The PostgreSQL statement creating the index on the table keeping resources as JSONB in the column. Values
+// that do not compare case sensitively are indexed lower cased, to match lower(...) = lower(...) queries, and
+// multiValued attributes by a GIN index serving containment queries on their elements.
+func (a IndexAdvice) PostgreSQL(table, column string) string {
+	name := table
+	for _, path := range a.Paths {
+		name += "_" + sqlIdentifierUnsafe.ReplaceAllString(path, "_")
+	}
+	name = strings.ToLower(name) + "_idx"
+
+	if a.MultiValued {
+		roots := make([]string, 0, len(a.Paths))
+		for _, path := range a.Paths {
+			roots = append(roots, fmt.Sprintf("(%s->'%s')", column, splitIndexPath(path)[0]))
+		}
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING gin (%s jsonb_path_ops)",
+			name, table, strings.Join(roots, " jsonb_path_ops, "))
+	}
+
+	expressions := make([]string, 0, len(a.Paths))
+	for _, path := range a.Paths {
+		names := splitIndexPath(path)
+		expr := column
+		for i, name := range names {
+			if i == len(names)-1 {
+				expr += fmt.Sprintf("->>'%s'", name)
+			} else {
+				expr += fmt.Sprintf("->'%s'", name)
+			}
+		}
+		if !a.CaseExact {
+			expr = "lower(" + expr + ")"
+		}
+		expressions = append(expressions, "("+expr+")")
+	}
+	unique := ""
+	if a.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)", unique, name, table, strings.Join(expressions, ", "))
+}
+
+// the names of an advised path, the extension namespace, whose URN holds periods itself, kept whole
+func splitIndexPath(path string) []string {
+	if colon := strings.LastIndex(path, ":"); colon >= 0 {
+		if dot := strings.Index(path[colon:], "."); dot >= 0 {
+			return append([]string{path[:colon+dot]}, strings.Split(path[colon+dot+1:], ".")...)
+		}
+		return []string{path}
+	}
+	return strings.Split(path, ".")
+}
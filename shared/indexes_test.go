@@ -0,0 +1,92 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestAdviseIndexes(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	require.Nil(t, sch.DeclareUnique([]string{"externalId", "emails.value"}, false))
+
+	filtered := CountFilteredPaths([]string{
+		`userName eq "david"`,
+		`emails.value eq "david@example.com" and NAME.familyName sw "Q"`,
+		`emails.value eq "other@example.com"`,
+		`id eq "6B69753B"`,
+		`externalId eq "8"`,
+		`unknown eq "x"`,
+		`userName eq`,
+	}, sch)
+	assert.Equal(t, map[string]int64{
+		"userName":        1,
+		"emails.value":    2,
+		"name.familyName": 1,
+		"id":              1,
+		"externalId":      1,
+	}, filtered)
+
+	advice := AdviseIndexes(sch, filtered, 2)
+	require.Len(t, advice, 3)
+	assert.Equal(t, IndexAdvice{
+		Paths:   []string{"userName"},
+		Unique:  true,
+		Filters: 1,
+		Reason:  IndexForUniqueness,
+	}, advice[0])
+	assert.Equal(t, IndexAdvice{
+		Paths:       []string{"externalId", "emails.value"},
+		MultiValued: true,
+		Sparse:      true,
+		Reason:      IndexForConstraint,
+	}, advice[1])
+	assert.Equal(t, IndexAdvice{
+		Paths:       []string{"emails.value"},
+		MultiValued: true,
+		Sparse:      true,
+		Filters:     2,
+		Reason:      IndexForFilter,
+	}, advice[2])
+
+	// the id is never advised, however often filtered on
+	for _, each := range AdviseIndexes(sch, filtered, 0) {
+		assert.NotEqual(t, []string{"id"}, each.Paths)
+	}
+	assert.Len(t, AdviseIndexes(sch, filtered, 0), 5)
+}
+
+func TestIndexAdvice_PostgreSQL(t *testing.T) {
+	assert.Equal(t,
+		"CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON users ((lower(data->>'userName')))",
+		IndexAdvice{Paths: []string{"userName"}, Unique: true}.PostgreSQL("users", "data"))
+	assert.Equal(t,
+		"CREATE INDEX IF NOT EXISTS users_name_familyname_idx ON users ((data->'name'->>'familyName'))",
+		IndexAdvice{Paths: []string{"name.familyName"}, CaseExact: true}.PostgreSQL("users", "data"))
+	assert.Equal(t,
+		"CREATE INDEX IF NOT EXISTS users_emails_value_idx ON users USING gin ((data->'emails') jsonb_path_ops)",
+		IndexAdvice{Paths: []string{"emails.value"}, MultiValued: true}.PostgreSQL("users", "data"))
+	assert.Equal(t,
+		"CREATE INDEX IF NOT EXISTS users_urn_ietf_params_scim_schemas_extension_enterprise_2_0_user_employeenumber_idx "+
+			"ON users ((lower(data->'"+EnterpriseUrn+"'->>'employeeNumber')))",
+		IndexAdvice{Paths: []string{EnterpriseUrn + ".employeeNumber"}}.PostgreSQL("users", "data"))
+}
+
+func TestReadFilterLog(t *testing.T) {
+	filters, err := ReadFilterLog(strings.NewReader(`
+# from the access log
+User userName eq "david"
+Group displayName eq "Admins"
+User  emails.value eq "a@example.com"
+`))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		UserResourceType:  {`userName eq "david"`, `emails.value eq "a@example.com"`},
+		GroupResourceType: {`displayName eq "Admins"`},
+	}, filters)
+
+	_, err = ReadFilterLog(strings.NewReader("User\n"))
+	assert.EqualError(t, err, "line 1 of the filter log: expect a resource type and a filter")
+}
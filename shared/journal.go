@@ -0,0 +1,127 @@
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The response recorded for a mutating request carrying a client supplied request id
+type JournalEntry struct {
+	Key         string
+	Fingerprint string // digest of the request, see RequestFingerprint
+	Status      int    // 0 while the request is still being processed
+	Headers     map[string]string
+	Body        []byte
+	Time        time.Time // when the request was received
+}
+
+func (e *JournalEntry) InProgress() bool {
+	return e.Status == 0
+}
+
+// Records the responses of mutating requests by their request id, so that a request replayed within the
+// retention window is answered with the original response instead of being executed again.
+type Journal interface {
+	// Reserve the key for the request with the fingerprint. Returns nil when the key was free, or the entry
+	// recorded under it otherwise.
+	Begin(key, fingerprint string, ctx context.Context) (*JournalEntry, error)
+	// Record the response of the request that reserved the key
+	Complete(entry *JournalEntry, ctx context.Context) error
+	// Release the key without recording a response, so that the request can be retried
+	Abort(key string, ctx context.Context) error
+	// Remove the entries of requests received before the time; run periodically rather than by Begin
+	Expire(before time.Time) error
+}
+
+// Returns an in memory journal that forgets entries retention after their request was received. Entries of
+// requests that never completed expire alike. Begin disregards an expired entry of its key, the others are
+// only removed by Expire.
+func NewMemoryJournal(retention time.Duration) Journal {
+	return &memoryJournal{
+		retention: retention,
+		entries:   make(map[string]*JournalEntry),
+		now:       time.Now,
+	}
+}
+
+type memoryJournal struct {
+	sync.Mutex
+	retention time.Duration
+	entries   map[string]*JournalEntry
+	now       func() time.Time
+}
+
+func (j *memoryJournal) Begin(key, fingerprint string, ctx context.Context) (*JournalEntry, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	now := j.now()
+	if entry, ok := j.entries[key]; ok && now.Before(entry.Time.Add(j.retention)) {
+		copied := *entry
+		return &copied, nil
+	}
+	j.entries[key] = &JournalEntry{Key: key, Fingerprint: fingerprint, Time: now}
+	return nil, nil
+}
+
+func (j *memoryJournal) Complete(entry *JournalEntry, ctx context.Context) error {
+	j.Lock()
+	defer j.Unlock()
+
+	reserved, ok := j.entries[entry.Key]
+	if !ok {
+		return nil
+	}
+	completed := *entry
+	completed.Time = reserved.Time
+	j.entries[entry.Key] = &completed
+	return nil
+}
+
+func (j *memoryJournal) Abort(key string, ctx context.Context) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if entry, ok := j.entries[key]; ok && entry.InProgress() {
+		delete(j.entries, key)
+	}
+	return nil
+}
+
+func (j *memoryJournal) Expire(before time.Time) error {
+	j.Lock()
+	defer j.Unlock()
+
+	for key, entry := range j.entries {
+		if entry.Time.Before(before) {
+			delete(j.entries, key)
+		}
+	}
+	return nil
+}
+
+// Resolves the journal key of a request from its X-Journal-Key header, scoped to the authenticated subject.
+// Returns an empty string when the header is absent. The X-Request-Id header only correlates requests: clients
+// and proxies reuse it, i.e. for the requests of one sync run, which must not be answered with each other's
+// responses.
+func JournalKey(req WebRequest, ctx context.Context) string {
+	id := req.Header("X-Journal-Key")
+	if len(id) == 0 {
+		return ""
+	}
+	principal, _ := ctx.Value(Principal{}).(string)
+	return principal + ":" + id
+}
+
+// Digest of the method, target and body of a request and of whether it is a dry run. A journal key reused
+// for a request with a different fingerprint is not a replay.
+func RequestFingerprint(req WebRequest, body []byte, ctx context.Context) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method() + " " + req.Target() + " " + strconv.FormatBool(IsDryRun(ctx)) + "\n"))
+	h.Write(body)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
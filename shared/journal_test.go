@@ -0,0 +1,77 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryJournal(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	journal := NewMemoryJournal(time.Hour).(*memoryJournal)
+	journal.now = func() time.Time { return now }
+
+	entry, err := journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+
+	// replayed while in progress
+	entry, err = journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.InProgress())
+
+	require.Nil(t, journal.Complete(&JournalEntry{Key: "a", Fingerprint: "f1", Status: 201, Body: []byte("{}")}, ctx))
+	now = now.Add(30 * time.Minute)
+	entry, err = journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	require.NotNil(t, entry)
+	assert.False(t, entry.InProgress())
+	assert.Equal(t, 201, entry.Status)
+	assert.Equal(t, "{}", string(entry.Body))
+
+	// completed entries are not released
+	require.Nil(t, journal.Abort("a", ctx))
+	entry, err = journal.Begin("a", "f1", ctx)
+	require.Nil(t, err)
+	assert.NotNil(t, entry)
+
+	// the retention counts from the time the request was received
+	now = now.Add(30 * time.Minute)
+	entry, err = journal.Begin("a", "f2", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+
+	require.Nil(t, journal.Abort("a", ctx))
+	entry, err = journal.Begin("a", "f2", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+
+	// other keys are only removed by Expire
+	entry, err = journal.Begin("b", "f1", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, entry)
+	now = now.Add(2 * time.Hour)
+	assert.Len(t, journal.entries, 2)
+	require.Nil(t, journal.Expire(now.Add(-time.Hour)))
+	assert.Empty(t, journal.entries)
+}
+
+func TestJournalKey(t *testing.T) {
+	ctx := context.WithValue(context.Background(), Principal{}, "okta")
+	assert.Equal(t, "", JournalKey(headerRequest{}, ctx))
+	assert.Equal(t, "okta:r1", JournalKey(headerRequest{"X-Journal-Key": "r1"}, ctx))
+	// the request id only correlates
+	assert.Equal(t, "", JournalKey(headerRequest{"X-Request-Id": "r1"}, ctx))
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	ctx := context.Background()
+	fingerprint := RequestFingerprint(headerRequest{}, []byte(`{"userName":"alice"}`), ctx)
+	assert.Equal(t, fingerprint, RequestFingerprint(headerRequest{}, []byte(`{"userName":"alice"}`), ctx))
+	assert.NotEqual(t, fingerprint, RequestFingerprint(headerRequest{}, []byte(`{"userName":"bob"}`), ctx))
+	assert.NotEqual(t, fingerprint, RequestFingerprint(headerRequest{}, []byte(`{"userName":"alice"}`), context.WithValue(ctx, DryRun{}, true)))
+}
@@ -6,38 +6,84 @@ import (
 	"math"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"unicode/utf8"
 )
 
-func MarshalJSON(v interface{}, sch *Schema, attributes []string, excludedAttributes []string) ([]byte, error) {
+// Customizes the output of MarshalJSON
+type MarshalOption func(abs *abstractMarshalHelper)
+
+// Render the attributes of resources in a stable, canonical order rather than in the order of the schema:
+// schemas, id and externalId first, then the core attributes in schema order, then the extension namespaces
+// in schema order, and meta last. Useful for conformance test suites and for diffing output.
+func CanonicalOrder() MarshalOption {
+	return func(abs *abstractMarshalHelper) {
+		abs.Canonical = true
+	}
+}
+
+// Make every resource of a list response list the schema of its resource type, resolved from
+// meta.resourceType by the schemas of the resource types the server registers, see ResourceTypeSchemas. Helps
+// clients telling apart the resources of a root query when the stored resources do not carry their schemas.
+func ItemSchemas(schemas map[string]string) MarshalOption {
+	return func(abs *abstractMarshalHelper) {
+		abs.ItemSchemas = schemas
+	}
+}
+
+func MarshalJSON(v interface{}, sch *Schema, attributes []string, excludedAttributes []string, options ...MarshalOption) ([]byte, error) {
 	abs := abstractMarshalHelper{
 		Guide:              sch,
 		Attributes:         attributes,
 		ExcludedAttributes: excludedAttributes,
+		Options:            options,
+	}
+	for _, option := range options {
+		option(&abs)
 	}
 
 	switch v.(type) {
 	case DataProvider:
 		m := &dataProviderMarshalHelper{
-			Data: v.(DataProvider),
+			Data:                  v.(DataProvider),
 			abstractMarshalHelper: abs,
 		}
-		return json.Marshal(m)
+		// rather than through json.Marshal, which would only copy and validate the output of the encoder
+		return m.MarshalJSON()
 
 	case *ListResponse:
 		m := &listResponseMarshalHelper{
-			Data: v.(*ListResponse),
+			Data:                  v.(*ListResponse),
 			abstractMarshalHelper: abs,
 		}
 		return json.Marshal(m)
 
+	case *ChangeEvent:
+		event := v.(*ChangeEvent)
+		if event.Document == nil || sch == nil {
+			return json.Marshal(event)
+		}
+		document, err := MarshalJSON(&Resource{Complex: event.Document}, sch, attributes, excludedAttributes, options...)
+		if err != nil {
+			return nil, err
+		}
+		// the document field shadows the one of the embedded event
+		return json.Marshal(struct {
+			*changeEventFields
+			Document json.RawMessage `json:"document"`
+		}{(*changeEventFields)(event), document})
+
 	default:
 		return json.Marshal(v)
 	}
 }
 
+// the fields of a change event, written by encoding/json
+type changeEventFields ChangeEvent
+
 type marshalHelper interface {
 	json.Marshaler
 }
@@ -46,6 +92,39 @@ type abstractMarshalHelper struct {
 	Guide              *Schema
 	Attributes         []string
 	ExcludedAttributes []string
+	Options            []MarshalOption
+	Canonical          bool
+	ItemSchemas        map[string]string // the schema by resource type, none if empty
+}
+
+// the attribute guiding the encoding of a resource, with its sub attributes in canonical order if asked for
+func (abs abstractMarshalHelper) guide() *Attribute {
+	root := abs.Guide.ToAttribute()
+	if !abs.Canonical {
+		return root
+	}
+
+	leading := map[string]int{"schemas": 0, "id": 1, "externalId": 2}
+	ordered := make([]*Attribute, len(root.SubAttributes))
+	copy(ordered, root.SubAttributes)
+	rank := func(attr *Attribute) int {
+		switch {
+		case attr.Name == "meta":
+			return 5
+		case strings.Contains(attr.Name, ":"):
+			// extension namespaces are named by their URN
+			return 4
+		}
+		if r, ok := leading[attr.Name]; ok {
+			return r
+		}
+		return 3
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return rank(ordered[i]) < rank(ordered[j]) })
+
+	canonical := *root
+	canonical.SubAttributes = ordered
+	return &canonical
 }
 
 func (abs abstractMarshalHelper) newEncOpts() (encOpts, error) {
@@ -57,7 +136,7 @@ func (abs abstractMarshalHelper) newEncOpts() (encOpts, error) {
 	if len(abs.Attributes) > 0 {
 		for _, attr := range abs.Attributes {
 			if len(attr) > 0 {
-				if p, err := NewPath(attr); err != nil {
+				if p, err := abs.compilePath(attr); err != nil {
 					return opt, err
 				} else {
 					opt.attributes = append(opt.attributes, p)
@@ -68,7 +147,7 @@ func (abs abstractMarshalHelper) newEncOpts() (encOpts, error) {
 	if len(abs.ExcludedAttributes) > 0 {
 		for _, attr := range abs.ExcludedAttributes {
 			if len(attr) > 0 {
-				if p, err := NewPath(attr); err != nil {
+				if p, err := abs.compilePath(attr); err != nil {
 					return opt, err
 				} else {
 					opt.excludedAttributes = append(opt.excludedAttributes, p)
@@ -79,6 +158,15 @@ func (abs abstractMarshalHelper) newEncOpts() (encOpts, error) {
 	return opt, nil
 }
 
+// attributes of a resource are resolved against its schema, see CompilePath
+func (abs abstractMarshalHelper) compilePath(text string) (Path, error) {
+	if abs.Guide == nil {
+		return NewPath(text)
+	}
+	p, _, err := CompilePath(text, abs.Guide)
+	return p, err
+}
+
 type dataProviderMarshalHelper struct {
 	abstractMarshalHelper
 	Data DataProvider
@@ -89,12 +177,13 @@ func (h *dataProviderMarshalHelper) MarshalJSON() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	e := new(encodeState)
-	err = e.marshal(h.Data.GetData(), opt, h.Guide.ToAttribute())
+	e := newEncodeState()
+	defer encodeStatePool.Put(e)
+	err = e.marshal(h.Data.GetData(), opt, h.guide())
 	if err != nil {
 		return nil, err
 	}
-	return e.Bytes(), nil
+	return append([]byte(nil), e.Bytes()...), nil
 }
 
 // encode function
@@ -511,7 +600,7 @@ func (mEnc *mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts, at
 	e.WriteByte('{')
 	isFirst := true
 	for _, subAttr := range keyAttrs {
-		val := v.MapIndex(reflect.ValueOf(subAttr.Name))
+		val := mapIndexByName(v, subAttr.Name)
 		if !opts.shouldEncode(val, subAttr) {
 			continue
 		}
@@ -526,6 +615,20 @@ func (mEnc *mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts, at
 	e.WriteByte('}')
 }
 
+// look up the map entry for the attribute name, falling back to a key of different case for data that
+// did not pass through CorrectCase, see Attribute.SubAttribute
+func mapIndexByName(v reflect.Value, name string) reflect.Value {
+	if val := v.MapIndex(reflect.ValueOf(name)); val.IsValid() {
+		return val
+	}
+	for _, k := range v.MapKeys() {
+		if strings.EqualFold(k.String(), name) {
+			return v.MapIndex(k)
+		}
+	}
+	return reflect.Value{}
+}
+
 func newMapEncoder(t reflect.Type, _ *Attribute) encoderFunc {
 	switch t.Key().Kind() {
 	case reflect.String:
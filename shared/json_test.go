@@ -41,6 +41,18 @@ func TestMarshalJSON(t *testing.T) {
 			[]string{"id"},
 			nil,
 		},
+		{
+			// attribute names in any case, in the parameter and in the data
+			func() (interface{}, string) {
+				r, _, err := ParseResource("../resources/tests/user_1.json")
+				require.Nil(t, err)
+				r.Complex["UserName"] = r.Complex["userName"]
+				delete(r.Complex, "userName")
+				return r, `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "id": "6B69753B-4E38-444E-8AC6-9D0E4D644D80", "userName": "david@example.com"}`
+			},
+			[]string{"USERNAME"},
+			nil,
+		},
 		{
 			func() (interface{}, string) {
 				r, _, err := ParseResource("../resources/tests/user_1.json")
@@ -88,7 +100,7 @@ func TestMarshalJSON(t *testing.T) {
 				{
 					"schemas": ["urn:ietf:params:scim:api:messages:2.0:ListResponse"],
 					"totalResults": 100,
-					"itemsPerPage": 10,
+					"itemsPerPage": 2,
 					"startIndex": 1,
 					"Resources": [
 						{
@@ -116,3 +128,102 @@ func TestMarshalJSON(t *testing.T) {
 		assert.JSONEq(t, json, string(b))
 	}
 }
+
+func TestMarshalJSON_CanonicalOrder(t *testing.T) {
+	sch := &Schema{
+		Id: UserUrn,
+		Attributes: []*Attribute{
+			{Name: "meta", Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "resourceType", Type: TypeString},
+			}},
+			{Name: enterpriseUrn, Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "employeeNumber", Type: TypeString},
+			}},
+			{Name: "userName", Type: TypeString},
+			{Name: "id", Type: TypeString},
+			{Name: "displayName", Type: TypeString},
+			{Name: "schemas", Type: TypeString, MultiValued: true},
+		},
+	}
+	require.Nil(t, CompileSchema(sch))
+
+	r := &Resource{Complex: Complex{
+		"schemas":     []interface{}{UserUrn},
+		"id":          "foo",
+		"userName":    "david",
+		"displayName": "David",
+		"meta":        map[string]interface{}{"resourceType": UserResourceType},
+		enterpriseUrn: map[string]interface{}{"employeeNumber": "42"},
+	}}
+
+	json, err := MarshalJSON(r, sch, nil, nil, CanonicalOrder())
+	require.Nil(t, err)
+	assert.Equal(t, `{"schemas":["`+UserUrn+`"],"id":"foo","userName":"david","displayName":"David",`+
+		`"`+enterpriseUrn+`":{"employeeNumber":"42"},"meta":{"resourceType":"User"}}`, string(json))
+
+	// the option carries over to the resources of a list response
+	json, err = MarshalJSON(&ListResponse{Resources: []DataProvider{r}}, sch, nil, nil, CanonicalOrder())
+	require.Nil(t, err)
+	assert.Contains(t, string(json), `[{"schemas":["`+UserUrn+`"],"id":"foo",`)
+}
+
+func TestMarshalJSON_ListResponseEnvelope(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	stored := &Resource{Complex: Complex{
+		"id":       "foo",
+		"userName": "david",
+		"meta":     map[string]interface{}{"resourceType": UserResourceType},
+	}}
+	lr := &ListResponse{TotalResults: 3, ItemsPerPage: 10, Resources: []DataProvider{stored}}
+
+	json, err := MarshalJSON(lr, sch, nil, nil)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{
+		"schemas": ["`+ListResponseUrn+`"],
+		"totalResults": 3,
+		"itemsPerPage": 1,
+		"startIndex": 1,
+		"Resources": [{"schemas": null, "id": "foo", "userName": "david", "meta": {"resourceType": "User"}}]
+	}`, string(json))
+
+	roleType := &Resource{Complex: Complex{"name": RoleResourceType, "schema": RoleUrn}}
+	userType := &Resource{Complex: Complex{"name": UserResourceType, "schema": UserUrn}}
+	json, err = MarshalJSON(lr, sch, []string{"userName"}, nil, ItemSchemas(ResourceTypeSchemas(roleType, userType)))
+	require.Nil(t, err)
+	assert.Contains(t, string(json), `"Resources":[{"schemas":["`+UserUrn+`"],"id":"foo","userName":"david"}]`)
+	_, ok := stored.Complex["schemas"]
+	assert.False(t, ok)
+
+	// of every resource type registered, not only users and groups
+	role := &Resource{Complex: Complex{"id": "admin", "meta": map[string]interface{}{"resourceType": RoleResourceType}}}
+	json, err = MarshalJSON(&ListResponse{Resources: []DataProvider{role}}, sch, []string{"id"}, nil, ItemSchemas(ResourceTypeSchemas(roleType, userType)))
+	require.Nil(t, err)
+	assert.Contains(t, string(json), `"Resources":[{"schemas":["`+RoleUrn+`"],"id":"admin"}]`)
+}
+
+// projections the way query responses apply attributes and excludedAttributes to every resource
+func BenchmarkMarshalJSON_Projected(b *testing.B) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(b, err)
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(b, err)
+	for _, bench := range []struct {
+		name       string
+		attributes []string
+		excluded   []string
+	}{
+		{"Attributes", []string{"userName", "emails.value"}, nil},
+		{"ExcludedAttributes", nil, []string{"groups", "addresses"}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := MarshalJSON(r, sch, bench.attributes, bench.excluded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Returns a context under which ValidateType and ValidateRequired are lenient, for identity providers known
+// to send slightly invalid payloads: instead of failing the request, they repair what can be repaired without
+// guessing and drop optional values that cannot, noting each change in the ValidationWarnings of the context.
+//
+// ValidateType turns numbers and booleans sent as strings into numbers and booleans and the reverse for string
+// attributes, wraps single values of multiValued attributes in an array and unwraps arrays of one value sent
+// for singular attributes. Values that remain invalid are removed unless the attribute is required.
+// ValidateRequired removes elements of multiValued complex attributes, and optional complex attributes, that
+// carry a required sub attribute as null or empty. Such required attributes of the resource itself still fail
+// the request.
+func WithLenientValidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, Lenient{}, &ValidationWarnings{})
+}
+
+// Tell whether the principal of the request is one of the comma separated clients to validate leniently,
+// '*' naming all of them
+func IsLenientClient(clients string, ctx context.Context) bool {
+	principal, _ := ctx.Value(Principal{}).(string)
+	for _, client := range strings.Split(clients, ",") {
+		client = strings.TrimSpace(client)
+		if client == "*" || (len(client) > 0 && client == principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// The repairs lenient validation made to a request, see WithLenientValidation. Nil when the request is
+// validated strictly.
+func LenientWarnings(ctx context.Context) *ValidationWarnings {
+	warnings, _ := ctx.Value(Lenient{}).(*ValidationWarnings)
+	return warnings
+}
+
+// Warnings name the path of the attribute repaired and how, never the value sent, which may be writeOnly, i.e.
+// a password
+type ValidationWarnings struct {
+	sync.Mutex
+	messages []string
+}
+
+func (w *ValidationWarnings) add(path, template string, args ...interface{}) {
+	w.Lock()
+	defer w.Unlock()
+	w.messages = append(w.messages, path+": "+fmt.Sprintf(template, args...))
+}
+
+func (w *ValidationWarnings) Messages() []string {
+	if w == nil {
+		return nil
+	}
+	w.Lock()
+	defer w.Unlock()
+	return append([]string{}, w.messages...)
+}
+
+// Render the warnings as the value of a Warning header (RFC 7234 section 5.5), with the miscellaneous
+// persistent warn code 299. Empty without warnings.
+func (w *ValidationWarnings) Header() string {
+	messages := w.Messages()
+	values := make([]string, 0, len(messages))
+	for _, message := range messages {
+		values = append(values, "299 - "+strconv.Quote(message))
+	}
+	return strings.Join(values, ", ")
+}
+
+type lenientTypes struct {
+	warnings *ValidationWarnings
+}
+
+func (lt *lenientTypes) repair(m map[string]interface{}, guide *Attribute) {
+	for k, v := range m {
+		attr := guide.SubAttribute(k)
+		if attr == nil || v == nil || attr.Mutability == ReadOnly {
+			continue
+		}
+		path := attr.Assist.FullPath
+
+		if !attr.MultiValued {
+			if array, ok := v.([]interface{}); ok && len(array) == 1 {
+				v = array[0]
+				m[k] = v
+				lt.warnings.add(path, "unwrapped single value from array")
+			}
+			if attr.Type == TypeComplex {
+				if sub, ok := v.(map[string]interface{}); ok {
+					lt.repair(sub, attr)
+				}
+				continue
+			}
+			if repaired, ok := lt.scalar(v, attr, path); ok {
+				m[k] = repaired
+			} else if !attr.Required {
+				delete(m, k)
+				lt.warnings.add(path, "dropped invalid value")
+			}
+			continue
+		}
+
+		array, ok := v.([]interface{})
+		if !ok {
+			array = []interface{}{v}
+			lt.warnings.add(path, "wrapped single value in array")
+		}
+		kept := make([]interface{}, 0, len(array))
+		for _, elem := range array {
+			if attr.Type == TypeComplex {
+				if sub, ok := elem.(map[string]interface{}); ok {
+					lt.repair(sub, attr)
+				}
+				kept = append(kept, elem)
+				continue
+			}
+			if repaired, ok := lt.scalar(elem, attr, path); ok {
+				kept = append(kept, repaired)
+			} else if attr.Required {
+				kept = append(kept, elem)
+			} else {
+				lt.warnings.add(path, "dropped invalid value")
+			}
+		}
+		m[k] = kept
+	}
+}
+
+// bring the value to the type of the attribute where that is unambiguous, reporting false if impossible
+func (lt *lenientTypes) scalar(v interface{}, attr *Attribute, path string) (interface{}, bool) {
+	switch attr.Type {
+	case TypeString:
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case json.Number:
+			lt.warnings.add(path, "converted number to string")
+			return v.String(), true
+		case float64:
+			lt.warnings.add(path, "converted number to string")
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			lt.warnings.add(path, "converted boolean to string")
+			return strconv.FormatBool(v), true
+		}
+	case TypeInteger:
+		switch v := v.(type) {
+		case json.Number:
+			if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+				return i, true
+			}
+			// a whole number written as a decimal, i.e. 3.0 or 3e2
+			if f, err := v.Float64(); err == nil && f == math.Trunc(f) && math.Abs(f) < math.MaxInt64 {
+				lt.warnings.add(path, "converted number to integer")
+				return int64(f), true
+			}
+		case float64:
+			// how JSON numbers are decoded, not the client's fault
+			if v == math.Trunc(v) {
+				return int64(v), true
+			}
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				lt.warnings.add(path, "converted string to integer")
+				return i, true
+			}
+		}
+	case TypeDecimal:
+		switch v := v.(type) {
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				return f, true
+			}
+		case float64:
+			return v, true
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				lt.warnings.add(path, "converted string to decimal")
+				return f, true
+			}
+		}
+	case TypeBoolean:
+		switch v := v.(type) {
+		case bool:
+			return v, true
+		case string:
+			if b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(v))); err == nil {
+				lt.warnings.add(path, "converted string to boolean")
+				return b, true
+			}
+		}
+	case TypeDateTime:
+		if s, ok := v.(string); ok {
+			if _, err := ParseDateTime(s); err == nil {
+				return s, true
+			}
+		}
+	case TypeBinary:
+		if s, ok := v.(string); ok && validBinary(s) {
+			return s, true
+		}
+	case TypeReference:
+		if s, ok := v.(string); ok && validReference(s, attr.ReferenceTypes) {
+			return s, true
+		}
+	default:
+		return v, true
+	}
+	return v, false
+}
+
+type lenientRequired struct {
+	warnings *ValidationWarnings
+}
+
+func (lr *lenientRequired) repair(m map[string]interface{}, guide *Attribute) {
+	for k, v := range m {
+		attr := guide.SubAttribute(k)
+		if attr == nil || attr.Type != TypeComplex {
+			continue
+		}
+		path := attr.Assist.FullPath
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if missing := lr.missing(v, attr); len(missing) > 0 && !attr.Required {
+				delete(m, k)
+				lr.warnings.add(path, "dropped value with empty required %s", missing)
+				continue
+			}
+			lr.repair(v, attr)
+		case []interface{}:
+			kept := make([]interface{}, 0, len(v))
+			for _, elem := range v {
+				if sub, ok := elem.(map[string]interface{}); ok {
+					if missing := lr.missing(sub, attr); len(missing) > 0 {
+						lr.warnings.add(path, "dropped element with empty required %s", missing)
+						continue
+					}
+					lr.repair(sub, attr)
+				}
+				kept = append(kept, elem)
+			}
+			m[k] = kept
+		}
+	}
+}
+
+// the name of the first required sub attribute the value carries as null or empty, empty if none
+func (lr *lenientRequired) missing(m map[string]interface{}, attr *Attribute) string {
+	for _, subAttr := range attr.SubAttributes {
+		if !subAttr.Required || subAttr.Mutability == ReadOnly {
+			continue
+		}
+		_, v, ok := entryByName(m, subAttr.Name)
+		if !ok || (v == nil && subAttr.Mutability == Immutable) {
+			continue
+		}
+		if !subAttr.Assigned(reflect.ValueOf(v)) {
+			return subAttr.Name
+		}
+	}
+	return ""
+}
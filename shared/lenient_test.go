@@ -0,0 +1,105 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValidateType_Lenient(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	newResource := func() *Resource {
+		return &Resource{Complex: Complex{
+			"userName":   "david",
+			"active":     "TRUE",
+			"nickName":   float64(42),
+			"title":      []interface{}{"Engineer"},
+			"profileUrl": "not a url",
+			"emails":     map[string]interface{}{"value": "david@example.com", "primary": "true"},
+		}}
+	}
+
+	// strict by default
+	assert.NotNil(t, ValidateType(newResource(), sch, context.Background()))
+
+	ctx := WithLenientValidation(context.Background())
+	r := newResource()
+	require.Nil(t, ValidateType(r, sch, ctx))
+	assert.Equal(t, true, r.Complex["active"])
+	assert.Equal(t, "42", r.Complex["nickName"])
+	assert.Equal(t, "Engineer", r.Complex["title"])
+	_, ok := r.Complex["profileUrl"]
+	assert.False(t, ok)
+	emails := r.Complex["emails"].([]interface{})
+	assert.Len(t, emails, 1)
+	assert.Equal(t, true, emails[0].(map[string]interface{})["primary"])
+	assert.Len(t, LenientWarnings(ctx).Messages(), 6)
+	// the warnings name attributes, not the values sent
+	assert.Contains(t, LenientWarnings(ctx).Messages(), UserUrn+":nickName: converted number to string")
+	assert.Contains(t, LenientWarnings(ctx).Messages(), UserUrn+":profileUrl: dropped invalid value")
+
+	// required attributes are not dropped
+	ctx = WithLenientValidation(context.Background())
+	r = &Resource{Complex: Complex{"userName": true}}
+	require.Nil(t, ValidateType(r, sch, ctx))
+	assert.Equal(t, "true", r.Complex["userName"])
+	r = &Resource{Complex: Complex{"userName": map[string]interface{}{}}}
+	assert.NotNil(t, ValidateType(r, sch, ctx))
+}
+
+func TestValidateRequired_Lenient(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	emails := sch.ToAttribute().SubAttribute("emails")
+	emails.SubAttribute("value").Required = true
+	sch.ToAttribute().SubAttribute("name").SubAttribute("familyName").Required = true
+
+	newResource := func() *Resource {
+		return &Resource{Complex: Complex{
+			"userName": "david",
+			"name":     map[string]interface{}{"givenName": "David", "familyName": nil},
+			"emails": []interface{}{
+				map[string]interface{}{"value": "david@example.com"},
+				map[string]interface{}{"value": "", "type": "work"},
+			},
+		}}
+	}
+
+	assert.NotNil(t, ValidateRequired(newResource(), sch, context.Background()))
+
+	ctx := WithLenientValidation(context.Background())
+	r := newResource()
+	require.Nil(t, ValidateRequired(r, sch, ctx))
+	assert.Len(t, r.Complex["emails"], 1)
+	_, ok := r.Complex["name"]
+	assert.False(t, ok)
+	assert.Contains(t, LenientWarnings(ctx).Messages(), UserUrn+":emails: dropped element with empty required value")
+	assert.Contains(t, LenientWarnings(ctx).Messages(), UserUrn+":name: dropped value with empty required familyName")
+
+	// required attributes of the resource itself still fail
+	r = newResource()
+	r.Complex["userName"] = ""
+	assert.NotNil(t, ValidateRequired(r, sch, WithLenientValidation(context.Background())))
+}
+
+func TestIsLenientClient(t *testing.T) {
+	ctx := context.WithValue(context.Background(), Principal{}, "okta")
+	assert.True(t, IsLenientClient("azure, okta", ctx))
+	assert.True(t, IsLenientClient("*", context.Background()))
+	assert.False(t, IsLenientClient("azure", ctx))
+	assert.False(t, IsLenientClient("", ctx))
+	assert.False(t, IsLenientClient("okta", context.Background()))
+}
+
+func TestValidationWarnings_Header(t *testing.T) {
+	var strict *ValidationWarnings
+	assert.Empty(t, strict.Header())
+
+	warnings := LenientWarnings(WithLenientValidation(context.Background()))
+	warnings.add("active", "converted string to boolean")
+	warnings.add("name", "dropped value with empty required %s", "familyName")
+	assert.Equal(t, `299 - "active: converted string to boolean", 299 - "name: dropped value with empty required familyName"`, warnings.Header())
+}
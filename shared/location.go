@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Decides the base URL clients reach the SCIM endpoints at, i.e. 'https://idm.example.com/scim/v2', from which
+// meta.location and the Location header are built. Behind load balancers and path prefixing gateways this is
+// not the address the server listens on. When the server provides none, the locations are taken from the
+// scim.resources.<type>.locationBase properties.
+type BaseURLProvider interface {
+	BaseURL(req WebRequest) string
+}
+
+// Adapts a function to a BaseURLProvider, i.e. to look up the base URL of the tenant a request belongs to
+type BaseURLFunc func(req WebRequest) string
+
+func (f BaseURLFunc) BaseURL(req WebRequest) string {
+	return f(req)
+}
+
+// Returns a provider of the same base URL for every request
+func NewStaticBaseURL(base string) BaseURLProvider {
+	base = strings.TrimSuffix(base, "/")
+	return BaseURLFunc(func(req WebRequest) string { return base })
+}
+
+// Returns a provider honoring the X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix headers set by
+// reverse proxies, falling back to the scheme, host and path of base for those that are absent. Only use it
+// when the headers are set by a trusted proxy, clients could forge them otherwise.
+func NewForwardedBaseURL(base string) (BaseURLProvider, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, Error.InvalidParam("base url", "absolute url", base)
+	}
+
+	return BaseURLFunc(func(req WebRequest) string {
+		scheme, host, prefix := u.Scheme, u.Host, u.Path
+		if proto := firstForwarded(req.Header("X-Forwarded-Proto")); len(proto) > 0 {
+			scheme = proto
+		}
+		if h := firstForwarded(req.Header("X-Forwarded-Host")); len(h) > 0 {
+			host = h
+		}
+		if p := firstForwarded(req.Header("X-Forwarded-Prefix")); len(p) > 0 {
+			prefix = "/" + strings.Trim(p, "/")
+		}
+		return fmt.Sprintf("%s://%s%s", scheme, host, prefix)
+	}), nil
+}
+
+// proxies in a chain append their value, the first one was set by the proxy facing the client
+func firstForwarded(value string) string {
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// The location of the resource, built from the BaseURL context value and the scim.protocol.uri.<type> property
+// when the request carries a base URL, or else from the scim.resources.<type>.locationBase property.
+func NewResourceLocation(ps PropertySource, resourceType, id string, ctx context.Context) (string, error) {
+	if base, ok := ctx.Value(BaseURL{}).(string); ok && len(base) > 0 {
+		uri := ps.GetString(fmt.Sprintf("scim.protocol.uri.%s", strings.ToLower(resourceType)))
+		return fmt.Sprintf("%s/%s/%s", base, strings.Trim(uri, "/"), id), nil
+	}
+
+	propertyKey := fmt.Sprintf("scim.resources.%s.locationBase", strings.ToLower(resourceType))
+	locationTemplate := strings.TrimSuffix(ps.GetString(propertyKey), "/")
+	if len(locationTemplate) == 0 {
+		return "", Error.Text("Cannot assign value to meta: no resource location template configured with key %s", propertyKey)
+	}
+	return fmt.Sprintf("%s/%s", locationTemplate, id), nil
+}
+
+// The location of a stored resource as seen by the client of the request: stored locations were built from
+// the base URL of the request that wrote the resource, which need not be the base URL of this request.
+func ResourceLocation(dp DataProvider, ps PropertySource, ctx context.Context) string {
+	meta, _ := dp.GetData()["meta"].(map[string]interface{})
+	stored, _ := meta["location"].(string)
+	if base, ok := ctx.Value(BaseURL{}).(string); !ok || len(base) == 0 {
+		return stored
+	}
+
+	resourceType, _ := meta["resourceType"].(string)
+	if len(resourceType) == 0 || len(dp.GetId()) == 0 {
+		return stored
+	}
+	if location, err := NewResourceLocation(ps, resourceType, dp.GetId(), ctx); err == nil {
+		return location
+	}
+	return stored
+}
+
+// Returns a copy of the resource with meta.location relative to the base URL of the request, or the resource
+// itself when the location is current already.
+func Relocate(dp DataProvider, ps PropertySource, ctx context.Context) DataProvider {
+	meta, ok := dp.GetData()["meta"].(map[string]interface{})
+	if !ok {
+		return dp
+	}
+	location := ResourceLocation(dp, ps, ctx)
+	if location == meta["location"] {
+		return dp
+	}
+
+	data := make(map[string]interface{}, len(dp.GetData()))
+	for k, v := range dp.GetData() {
+		data[k] = v
+	}
+	relocated := make(map[string]interface{}, len(meta))
+	for k, v := range meta {
+		relocated[k] = v
+	}
+	relocated["location"] = location
+	data["meta"] = relocated
+	return &Resource{Complex: Complex(data)}
+}
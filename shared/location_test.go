@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForwardedBaseURL(t *testing.T) {
+	provider, err := NewForwardedBaseURL("http://localhost:8080/v2/")
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		headers map[string]string
+		expect  string
+	}{
+		{nil, "http://localhost:8080/v2"},
+		{map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "idm.example.com"}, "https://idm.example.com/v2"},
+		{map[string]string{"X-Forwarded-Host": "idm.example.com, lb.internal", "X-Forwarded-Prefix": "/scim/v2/"}, "http://idm.example.com/scim/v2"},
+	} {
+		assert.Equal(t, test.expect, provider.BaseURL(headerRequest(test.headers)))
+	}
+
+	_, err = NewForwardedBaseURL("/v2")
+	assert.NotNil(t, err)
+}
+
+func TestResourceLocation(t *testing.T) {
+	ps := &mapPropertySource{data: map[string]interface{}{
+		"scim.resources.user.locationBase": "http://localhost:8080/v2/Users/",
+		"scim.protocol.uri.user":           "/Users",
+	}}
+	r := &Resource{Complex: Complex{
+		"id":   "foo",
+		"meta": map[string]interface{}{"resourceType": UserResourceType, "location": "http://localhost:8080/v2/Users/foo"},
+	}}
+
+	// without a base URL, the configured location base applies
+	ctx := context.Background()
+	location, err := NewResourceLocation(ps, UserResourceType, "foo", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "http://localhost:8080/v2/Users/foo", location)
+	assert.Equal(t, r, Relocate(r, ps, ctx))
+
+	ctx = context.WithValue(ctx, BaseURL{}, "https://idm.example.com/scim/v2")
+	location, err = NewResourceLocation(ps, UserResourceType, "foo", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "https://idm.example.com/scim/v2/Users/foo", location)
+
+	relocated := Relocate(r, ps, ctx)
+	assert.Equal(t, location, relocated.GetData()["meta"].(map[string]interface{})["location"])
+	assert.Equal(t, "http://localhost:8080/v2/Users/foo", r.Complex["meta"].(map[string]interface{})["location"])
+}
@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severities of log messages, in increasing order
+const (
+	LogDebug = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// Log field keys set by LogFields
+const (
+	LogFieldRequestId    = "requestId"
+	LogFieldResourceType = "resourceType"
+	LogFieldOperation    = "operation"
+	LogFieldResourceId   = "resourceId"
+)
+
+var (
+	oneNoOpLogger sync.Once
+	noOpLoggerVal Logger
+)
+
+// Returns a logger that discards every message
+func NewNoOpLogger() Logger {
+	oneNoOpLogger.Do(func() {
+		noOpLoggerVal = noOpLogger{}
+	})
+	return noOpLoggerVal
+}
+
+type noOpLogger struct{}
+
+func (l noOpLogger) Debug(msg string, keyvals ...interface{}) {}
+func (l noOpLogger) Info(msg string, keyvals ...interface{})  {}
+func (l noOpLogger) Warn(msg string, keyvals ...interface{})  {}
+func (l noOpLogger) Error(msg string, keyvals ...interface{}) {}
+
+// Returns a logger writing one line per message of at least the level to w, formatted as
+// 2017-01-01T00:00:00Z INFO message key=value key="value with spaces"
+func NewTextLogger(w io.Writer, level int) Logger {
+	return &textLogger{w: w, level: level, now: time.Now}
+}
+
+type textLogger struct {
+	sync.Mutex
+	w     io.Writer
+	level int
+	now   func() time.Time
+}
+
+func (l *textLogger) Debug(msg string, keyvals ...interface{}) { l.log(LogDebug, msg, keyvals) }
+func (l *textLogger) Info(msg string, keyvals ...interface{})  { l.log(LogInfo, msg, keyvals) }
+func (l *textLogger) Warn(msg string, keyvals ...interface{})  { l.log(LogWarn, msg, keyvals) }
+func (l *textLogger) Error(msg string, keyvals ...interface{}) { l.log(LogError, msg, keyvals) }
+
+func (l *textLogger) log(level int, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(l.now().UTC().Format(time.RFC3339))
+	buf.WriteString(" " + logLevelNames[level] + " " + msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := "MISSING"
+		if i+1 < len(keyvals) {
+			value = fmt.Sprint(keyvals[i+1])
+		}
+		if len(value) == 0 || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(" " + key + "=" + value)
+	}
+	buf.WriteString("\n")
+
+	l.Lock()
+	defer l.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+// Returns the fields describing the request in ctx, i.e. its request id, resource type and operation and the
+// id of the resource it targets, followed by the given keys and values
+func LogFields(ctx context.Context, keyvals ...interface{}) []interface{} {
+	fields := make([]interface{}, 0, 8+len(keyvals))
+	if requestId, ok := ctx.Value(RequestId{}).(string); ok {
+		fields = append(fields, LogFieldRequestId, requestId)
+	}
+	if requestType, ok := ctx.Value(RequestType{}).(int); ok {
+		resourceType, operation := DescribeRequestType(requestType)
+		fields = append(fields, LogFieldResourceType, resourceType, LogFieldOperation, operation)
+	}
+	if resourceId, ok := ctx.Value(ResourceId{}).(string); ok && len(resourceId) > 0 {
+		fields = append(fields, LogFieldResourceId, resourceId)
+	}
+	return append(fields, keyvals...)
+}
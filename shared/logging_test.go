@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewTextLogger(buf, LogInfo).(*textLogger)
+	logger.now = func() time.Time { return time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	logger.Debug("step completed", "step", "parse")
+	logger.Info("request rejected", "status", 400, "error", `Path [foo] is invalid: "bar"`, "empty", "", "dangling")
+	logger.Error("request failed")
+
+	assert.Equal(t, "2017-01-01T00:00:00Z INFO request rejected status=400 "+
+		`error="Path [foo] is invalid: \"bar\"" empty="" dangling=MISSING`+"\n"+
+		"2017-01-01T00:00:00Z ERROR request failed\n", buf.String())
+}
+
+func TestLogFields(t *testing.T) {
+	assert.Empty(t, LogFields(context.Background()))
+
+	ctx := context.WithValue(context.Background(), RequestId{}, "r1")
+	ctx = context.WithValue(ctx, RequestType{}, PatchGroup)
+	ctx = context.WithValue(ctx, ResourceId{}, "g1")
+	assert.Equal(t, []interface{}{
+		LogFieldRequestId, "r1",
+		LogFieldResourceType, GroupResourceType,
+		LogFieldOperation, "patch",
+		LogFieldResourceId, "g1",
+		"step", "parse",
+	}, LogFields(ctx, "step", "parse"))
+}
+
+func TestValidRequestId(t *testing.T) {
+	assert.True(t, ValidRequestId("0f8fad5b-d9cb-469f-a165-70867728950e"))
+	assert.True(t, ValidRequestId("client:42/retry=1"))
+	assert.False(t, ValidRequestId(""))
+	assert.False(t, ValidRequestId("two words"))
+	assert.False(t, ValidRequestId(`say "hi"`))
+	assert.False(t, ValidRequestId("line\nbreak"))
+	assert.False(t, ValidRequestId(strings.Repeat("x", 129)))
+}
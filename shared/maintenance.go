@@ -0,0 +1,218 @@
+package shared
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Optionally implemented by repositories that index the attributes marked unique, and those of the unique
+// constraints declared on the schema, so that uniqueness lookups do not scan. Rebuilds the indexes from the
+// schema the repository was created with.
+type UniqueIndexer interface {
+	ReindexUnique(ctx context.Context) error
+}
+
+// Optionally implemented by repositories that create the indexes AdviseIndexes recommends. Indexes the
+// repository cannot build are skipped, and existing ones are left as they are.
+type IndexEnsurer interface {
+	EnsureIndexes(advice []IndexAdvice, ctx context.Context) error
+}
+
+// Optionally implemented by repositories that flag deleted resources instead of removing them.
+// Removes the resources deleted before the cutoff for good and returns how many were removed.
+type DeletedPurger interface {
+	PurgeDeleted(before time.Time, ctx context.Context) (int, error)
+}
+
+// Returns the repository as UniqueIndexer when it indexes unique attributes, and so do all repositories it
+// decorates, which decorators forward the reindex to
+func AsUniqueIndexer(repo Repository) (UniqueIndexer, bool) {
+	if !supports(repo, func(r Repository) bool { _, ok := r.(UniqueIndexer); return ok }) {
+		return nil, false
+	}
+	return repo.(UniqueIndexer), true
+}
+
+// Returns the repository as DeletedPurger when it flags deleted resources, and so do all repositories it
+// decorates, which decorators forward the purge to
+func AsDeletedPurger(repo Repository) (DeletedPurger, bool) {
+	if !supports(repo, func(r Repository) bool { _, ok := r.(DeletedPurger); return ok }) {
+		return nil, false
+	}
+	return repo.(DeletedPurger), true
+}
+
+// Count the stored resources of every repository, keyed like the repositories
+func CountResources(repos map[string]Repository, ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int, len(repos))
+	for resourceType, repo := range repos {
+		n, err := repo.Count("id pr", ctx)
+		if err != nil {
+			return nil, err
+		}
+		counts[resourceType] = n
+	}
+	return counts, nil
+}
+
+// Progress of a group reference rebuild
+type RebuildProgress struct {
+	Total   int // users in the repository when the run started
+	Updated int // users whose groups attribute was out of date
+	Skipped int // users changed or deleted concurrently, their write assigned the groups anew
+	Failed  int // users the groups could not be computed or written for
+}
+
+// Recompute the groups attribute of all users from the members of the groups, i.e. after groups were
+// written to the database directly. Users are processed in batches and written back conditionally on their
+// meta.version, so concurrent writes win and are not overwritten.
+func RebuildGroupReferences(userRepo, groupRepo Repository, batchSize int, ctx context.Context) (RebuildProgress, error) {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+
+	progress := RebuildProgress{}
+	total, err := userRepo.Count("id pr", ctx)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = total
+
+	assignment := NewGroupAssignment(groupRepo)
+	for startIndex := 1; startIndex <= total; startIndex += batchSize {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		lr, err := userRepo.Search(SearchRequest{
+			Filter:     "id pr",
+			SortBy:     "id",
+			SortOrder:  "ascending",
+			StartIndex: startIndex,
+			Count:      batchSize,
+		}, ctx)
+		if err != nil {
+			return progress, err
+		}
+
+		for _, dp := range lr.Resources {
+			rebuildOne(dp, userRepo, assignment, &progress, ctx)
+		}
+		if len(lr.Resources) < batchSize {
+			break
+		}
+	}
+	return progress, nil
+}
+
+func rebuildOne(dp DataProvider, userRepo Repository, assignment ReadOnlyAssignment, progress *RebuildProgress, ctx context.Context) {
+	r := &Resource{Complex: Complex(deepCopy(map[string]interface{}(dp.GetData())).(map[string]interface{}))}
+	version := ""
+	if meta, ok := r.Complex["meta"].(map[string]interface{}); ok {
+		version, _ = meta["version"].(string)
+	}
+
+	if err := assignment.AssignValue(r, ctx); err != nil {
+		progress.Failed++
+		return
+	}
+	stored, _ := dp.GetData()["groups"].([]interface{})
+	if groups := r.Complex["groups"].([]interface{}); reflect.DeepEqual(stored, groups) ||
+		(len(stored) == 0 && len(groups) == 0) {
+		return
+	}
+	writeBack(r, version, userRepo, progress, ctx)
+}
+
+// Rewrite the display of the groups entries of all users referring to the group, directly or indirectly, i.e.
+// after the displayName of the group changed. Users are processed and written back like by
+// RebuildGroupReferences, Total counting the users referring to the group.
+func PropagateGroupDisplay(userRepo Repository, groupId string, display interface{}, batchSize int, ctx context.Context) (RebuildProgress, error) {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+
+	progress := RebuildProgress{}
+	quoted, err := QuoteFilterString(groupId)
+	if err != nil {
+		return progress, err
+	}
+	filter := "groups.value eq " + quoted
+	total, err := userRepo.Count(filter, ctx)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = total
+
+	for startIndex := 1; startIndex <= total; startIndex += batchSize {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		lr, err := userRepo.Search(SearchRequest{
+			Filter:     filter,
+			SortBy:     "id",
+			SortOrder:  "ascending",
+			StartIndex: startIndex,
+			Count:      batchSize,
+		}, ctx)
+		if err != nil {
+			return progress, err
+		}
+
+		for _, dp := range lr.Resources {
+			renameOne(dp, userRepo, groupId, display, &progress, ctx)
+		}
+		if len(lr.Resources) < batchSize {
+			break
+		}
+	}
+	return progress, nil
+}
+
+func renameOne(dp DataProvider, userRepo Repository, groupId string, display interface{}, progress *RebuildProgress, ctx context.Context) {
+	r := &Resource{Complex: Complex(deepCopy(map[string]interface{}(dp.GetData())).(map[string]interface{}))}
+	version := ""
+	if meta, ok := r.Complex["meta"].(map[string]interface{}); ok {
+		version, _ = meta["version"].(string)
+	}
+
+	renamed := false
+	groups, _ := r.Complex["groups"].([]interface{})
+	for _, each := range groups {
+		if g, ok := each.(map[string]interface{}); ok && g["value"] == groupId && !reflect.DeepEqual(g["display"], display) {
+			g["display"] = display
+			renamed = true
+		}
+	}
+	if !renamed {
+		return
+	}
+	writeBack(r, version, userRepo, progress, ctx)
+}
+
+// write the user back unless it changed since it was read at the version, bumping its meta.lastModified and
+// meta.version so that clients holding the version read see the user changed
+func writeBack(r *Resource, version string, userRepo Repository, progress *RebuildProgress, ctx context.Context) {
+	meta, ok := r.Complex["meta"].(map[string]interface{})
+	if !ok {
+		meta = make(map[string]interface{})
+		r.Complex["meta"] = meta
+	}
+	ro := &metaAssignment{}
+	now := ro.timestamp()
+	meta["lastModified"] = now
+	// the version read is hashed along, the user may have been written within the same second
+	meta["version"] = ro.generateVersion(r.GetId(), now, version)
+
+	if err := userRepo.Update(r.GetId(), version, r, ctx); err != nil {
+		if _, ok := err.(*ResourceNotFoundError); ok {
+			progress.Skipped++
+		} else {
+			progress.Failed++
+		}
+		return
+	}
+	progress.Updated++
+}
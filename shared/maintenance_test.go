@@ -0,0 +1,77 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRebuildGroupReferences(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	groups := &roTestMockDB{}
+	groups.init()
+
+	users := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"u_001": &Resource{Complex: Complex{"id": "u_001", "groups": []interface{}{}}},
+		"u_002": &Resource{Complex: Complex{"id": "u_002", "meta": map[string]interface{}{
+			"version": "W/\"1\"", "lastModified": "2018-01-01T00:00:00Z",
+		}}},
+		"u_003": &Resource{Complex: Complex{"id": "u_003", "groups": []interface{}{
+			map[string]interface{}{"value": "g_002", "$ref": "http://scim.com/Groups/g_002", "display": "Group002", "type": "direct"},
+		}}},
+		"u_004": &Resource{Complex: Complex{"id": "u_004"}},
+	})
+	ctx := context.Background()
+
+	progress, err := RebuildGroupReferences(users, groups, 3, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, RebuildProgress{Total: 4, Updated: 2}, progress)
+
+	dp, err := users.Get("u_002", "", ctx)
+	require.Nil(t, err)
+	assert.Len(t, dp.GetData()["groups"], 3)
+	// rewritten users get a new version
+	meta := dp.GetData()["meta"].(map[string]interface{})
+	assert.NotEqual(t, "W/\"1\"", meta["version"])
+	assert.NotEqual(t, "2018-01-01T00:00:00Z", meta["lastModified"])
+	dp, err = users.Get("u_004", "", ctx)
+	require.Nil(t, err)
+	assert.Nil(t, dp.GetData()["meta"])
+
+	counts, err := CountResources(map[string]Repository{UserResourceType: users}, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{UserResourceType: 4}, counts)
+}
+
+func TestPropagateGroupDisplay(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	group := func(id, display, typ string) map[string]interface{} {
+		return map[string]interface{}{"value": id, "$ref": "http://scim.com/Groups/" + id, "display": display, "type": typ}
+	}
+	users := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"u_001": &Resource{Complex: Complex{"id": "u_001", "groups": []interface{}{group("g_001", "Old", "direct")}}},
+		"u_002": &Resource{Complex: Complex{"id": "u_002", "groups": []interface{}{
+			group("g_002", "Other", "direct"), group("g_001", "Old", "indirect"),
+		}, "meta": map[string]interface{}{"version": "W/\"1\""}}},
+		"u_003": &Resource{Complex: Complex{"id": "u_003", "groups": []interface{}{group("g_001", "New", "direct")}}},
+		"u_004": &Resource{Complex: Complex{"id": "u_004", "groups": []interface{}{group("g_002", "Old", "direct")}}},
+	})
+	ctx := context.Background()
+
+	progress, err := PropagateGroupDisplay(users, "g_001", "New", 2, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, RebuildProgress{Total: 3, Updated: 2}, progress)
+
+	dp, err := users.Get("u_002", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{group("g_002", "Other", "direct"), group("g_001", "New", "indirect")}, dp.GetData()["groups"])
+	assert.NotEqual(t, "W/\"1\"", dp.GetData()["meta"].(map[string]interface{})["version"])
+	dp, err = users.Get("u_004", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{group("g_002", "Old", "direct")}, dp.GetData()["groups"])
+}
@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// The managers of a user, see ResolveManagerChain
+type ManagerChain struct {
+	Managers  []DataProvider // the manager of the user first, the top of the chain last
+	Cycle     string         // id of a manager met twice, ending the chain, empty if none
+	Dangling  string         // id of a manager that does not exist, ending the chain, empty if none
+	Truncated bool           // the depth limit ended the chain before its top
+}
+
+// Describe how the chain ended other than at a user without manager, for the Warning header (see
+// ValidationWarnings.Header)
+func (c *ManagerChain) Warnings() *ValidationWarnings {
+	w := &ValidationWarnings{}
+	if len(c.Cycle) > 0 {
+		w.add(EnterpriseUrn+":manager", "cycle at %s", c.Cycle)
+	}
+	if len(c.Dangling) > 0 {
+		w.add(EnterpriseUrn+":manager", "manager %s does not exist", c.Dangling)
+	}
+	if c.Truncated {
+		w.add(EnterpriseUrn+":manager", "chain truncated after %d managers", len(c.Managers))
+	}
+	return w
+}
+
+// Resolve the management chain of a user by following the manager attribute of the enterprise extension:
+// the manager of the user, the manager of that manager and so on. Managers are identified by manager.value,
+// or by the last segment of manager.$ref when the value is missing. The chain ends at a user without manager,
+// at a manager already in it, at a manager that does not exist or, if maxDepth is positive, after maxDepth
+// managers. A user that does not exist is reported as ResourceNotFoundError.
+func ResolveManagerChain(userRepo Repository, id string, maxDepth int, ctx context.Context) (*ManagerChain, error) {
+	dp, err := userRepo.Get(id, "", ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &ManagerChain{Managers: make([]DataProvider, 0)}
+	visited := map[string]bool{id: true}
+	for {
+		managerId := managerOf(dp.GetData())
+		if len(managerId) == 0 {
+			return chain, nil
+		}
+		if visited[managerId] {
+			chain.Cycle = managerId
+			return chain, nil
+		}
+		if maxDepth > 0 && len(chain.Managers) >= maxDepth {
+			chain.Truncated = true
+			return chain, nil
+		}
+		visited[managerId] = true
+
+		dp, err = userRepo.Get(managerId, "", ctx)
+		if err != nil {
+			if _, ok := err.(*ResourceNotFoundError); ok {
+				chain.Dangling = managerId
+				return chain, nil
+			}
+			return nil, err
+		}
+		chain.Managers = append(chain.Managers, dp)
+	}
+}
+
+// the id of the manager of the user, empty if it has none
+func managerOf(data Complex) string {
+	_, extension, _ := entryByName(data, EnterpriseUrn)
+	ext, ok := extension.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	_, manager, _ := entryByName(ext, "manager")
+	m, ok := manager.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	_, value, _ := entryByName(m, "value")
+	if id, _ := value.(string); len(id) > 0 {
+		return id
+	}
+	_, ref, _ := entryByName(m, "$ref")
+	s, _ := ref.(string)
+	s = strings.TrimRight(s, "/")
+	s = s[strings.LastIndex(s, "/")+1:]
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestResolveManagerChain(t *testing.T) {
+	user := func(id string, manager map[string]interface{}) DataProvider {
+		data := Complex{"id": id, "userName": id}
+		if manager != nil {
+			data[EnterpriseUrn] = map[string]interface{}{"manager": manager}
+		}
+		return &Resource{Complex: data}
+	}
+	repo := NewMapRepository(map[string]DataProvider{
+		"anne":  user("anne", map[string]interface{}{"value": "bob"}),
+		"bob":   user("bob", map[string]interface{}{"$ref": "https://example.com/v2/Users/carol"}),
+		"carol": user("carol", nil),
+		"dave":  user("dave", map[string]interface{}{"value": "erin"}),
+		"erin":  user("erin", map[string]interface{}{"value": "dave"}),
+		"fred":  user("fred", map[string]interface{}{"value": "gone"}),
+	})
+	ctx := context.Background()
+	ids := func(chain *ManagerChain) []string {
+		ids := make([]string, 0)
+		for _, dp := range chain.Managers {
+			ids = append(ids, dp.GetId())
+		}
+		return ids
+	}
+
+	chain, err := ResolveManagerChain(repo, "anne", 0, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"bob", "carol"}, ids(chain))
+	assert.Empty(t, chain.Warnings().Header())
+
+	chain, err = ResolveManagerChain(repo, "anne", 1, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"bob"}, ids(chain))
+	assert.True(t, chain.Truncated)
+
+	chain, err = ResolveManagerChain(repo, "dave", 10, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"erin"}, ids(chain))
+	assert.Equal(t, "dave", chain.Cycle)
+	assert.Contains(t, chain.Warnings().Header(), "cycle at dave")
+
+	chain, err = ResolveManagerChain(repo, "fred", 10, ctx)
+	require.Nil(t, err)
+	assert.Empty(t, chain.Managers)
+	assert.Equal(t, "gone", chain.Dangling)
+
+	_, err = ResolveManagerChain(repo, "unknown", 10, ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+}
@@ -0,0 +1,359 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Resolve the members of a group, including the members of nested groups, to a flat list of
+// non group members. Groups are traversed breadth first and each group is visited only once,
+// so membership cycles terminate. Members without a type are looked up in the group repository
+// to decide whether they are groups. Duplicate members are reported once. Each level of nesting
+// takes at most two searches of the group repository by id, rather than a Get per member.
+func ExpandMembers(groupRepo Repository, id string, ctx context.Context) ([]interface{}, error) {
+	visited := map[string]bool{id: true}
+	seen := make(map[string]bool)
+	flat := make([]interface{}, 0)
+
+	groups, err := getGroups(groupRepo, []string{id}, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if groups[id] == nil {
+		return nil, Error.ResourceNotFound(id, "")
+	}
+	for level := []string{id}; len(level) > 0; {
+		// the values of members without a type that were not looked up yet, some may be groups
+		untyped := make([]string, 0)
+		for _, groupId := range level {
+			for _, m := range groupMembers(groups[groupId]) {
+				if value := m["value"].(string); m["type"] != GroupResourceType && m["type"] != UserResourceType && !visited[value] {
+					untyped = append(untyped, value)
+				}
+			}
+		}
+		found, err := getGroups(groupRepo, untyped, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for groupId, dp := range found {
+			groups[groupId] = dp
+		}
+
+		next := make([]string, 0)
+		for _, groupId := range level {
+			for _, m := range groupMembers(groups[groupId]) {
+				value := m["value"].(string)
+				if m["type"] == GroupResourceType || (m["type"] != UserResourceType && groups[value] != nil) {
+					if !visited[value] {
+						visited[value] = true
+						next = append(next, value)
+					}
+					continue
+				}
+				if !seen[value] {
+					seen[value] = true
+					flat = append(flat, m)
+				}
+			}
+		}
+
+		// groups nested by type are fetched now, those missing are skipped
+		unfetched := make([]string, 0)
+		for _, groupId := range next {
+			if groups[groupId] == nil {
+				unfetched = append(unfetched, groupId)
+			}
+		}
+		if found, err = getGroups(groupRepo, unfetched, ctx); err != nil {
+			return nil, err
+		}
+		for groupId, dp := range found {
+			groups[groupId] = dp
+		}
+		level = next
+	}
+	return flat, nil
+}
+
+// the members of the group with a value, none for a group that does not exist
+func groupMembers(dp DataProvider) []map[string]interface{} {
+	if dp == nil {
+		return nil
+	}
+	members, _ := dp.GetData()["members"].([]interface{})
+	valued := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		if m, ok := member.(map[string]interface{}); ok {
+			if value, _ := m["value"].(string); len(value) > 0 {
+				valued = append(valued, m)
+			}
+		}
+	}
+	return valued
+}
+
+// the groups of the ids that exist, by id, searched with one disjunctive filter on their ids and paged through;
+// a repository may cap the page size below the count asked for
+func getGroups(groupRepo Repository, ids []string, ctx context.Context) (map[string]DataProvider, error) {
+	groups := make(map[string]DataProvider, len(ids))
+	clauses := make([]string, 0, len(ids))
+	for _, id := range distinct(ids) {
+		quoted, err := QuoteFilterString(id)
+		if err != nil {
+			// no filter can ask for it, so it is no id the server assigned
+			continue
+		}
+		clauses = append(clauses, "id eq "+quoted)
+	}
+	if len(clauses) == 0 {
+		return groups, nil
+	}
+
+	for start := 1; ; {
+		lr, err := groupRepo.Search(SearchRequest{Filter: strings.Join(clauses, " or "), StartIndex: start, Count: len(clauses)}, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if lr.Partial {
+			return nil, Error.Timeout("the nested groups")
+		}
+		for _, dp := range lr.Resources {
+			groups[dp.GetId()] = dp
+		}
+		start += len(lr.Resources)
+		if len(lr.Resources) == 0 || start > lr.TotalResults {
+			return groups, nil
+		}
+	}
+}
+
+func isGroupMember(groupRepo Repository, member map[string]interface{}, value string, ctx context.Context) bool {
+	switch member["type"] {
+	case GroupResourceType:
+		return true
+	case UserResourceType:
+		return false
+	}
+	_, err := groupRepo.Get(value, "", ctx)
+	return err == nil
+}
+
+// Fail with MembershipCycleError when any of the members, i.e. those added to the group of the id, is the
+// group itself or a group that has it among its nested members, which would make flattening the members of
+// either group loop. The reported path is the shortest chain of nested groups closing the cycle.
+func DetectMembershipCycle(groupRepo Repository, id string, members []interface{}, ctx context.Context) error {
+	for _, member := range members {
+		m, ok := member.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, _ := m["value"].(string)
+		if len(value) == 0 {
+			continue
+		}
+		if value == id {
+			return Error.MembershipCycle([]string{id, id})
+		}
+		if !isGroupMember(groupRepo, m, value, ctx) {
+			continue
+		}
+		path, err := membershipPath(groupRepo, value, id, ctx)
+		if err != nil {
+			return err
+		}
+		if path != nil {
+			return Error.MembershipCycle(append([]string{id}, path...))
+		}
+	}
+	return nil
+}
+
+// the ids of the groups along the shortest chain of nested groups from one group to the other, both included,
+// or nil when the other is no nested member of the one
+func membershipPath(groupRepo Repository, from, to string, ctx context.Context) ([]string, error) {
+	parents := map[string]string{from: ""}
+	pending := NewQueueWithoutLimit()
+	pending.Offer(from)
+	for pending.Size() > 0 {
+		groupId := pending.Poll().(string)
+		dp, err := groupRepo.Get(groupId, "", ctx)
+		if err != nil {
+			if _, ok := err.(*ResourceNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		members, _ := dp.GetData()["members"].([]interface{})
+		for _, member := range members {
+			m, ok := member.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			value, _ := m["value"].(string)
+			if value == to {
+				path := []string{to}
+				for each := groupId; len(each) > 0; each = parents[each] {
+					path = append([]string{each}, path...)
+				}
+				return path, nil
+			}
+			if _, visited := parents[value]; visited || len(value) == 0 {
+				continue
+			}
+			if isGroupMember(groupRepo, m, value, ctx) {
+				parents[value] = groupId
+				pending.Offer(value)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Optionally implemented by group repositories that can add and remove members in place, without loading
+// and rewriting the whole group. Adds are member objects replacing any member of the same value, removes
+// are member values. The entries of meta, i.e. lastModified and version, are set on the meta attribute along.
+// A group that does not exist or does not carry the version is reported as ResourceNotFoundError.
+type MemberPatcher interface {
+	PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error
+}
+
+// Returns the repository as MemberPatcher when it patches members in place, and so do all repositories it
+// decorates, which decorators forward the patch to
+func AsMemberPatcher(repo Repository) (MemberPatcher, bool) {
+	if !supports(repo, func(r Repository) bool { _, ok := r.(MemberPatcher); return ok }) {
+		return nil, false
+	}
+	return repo.(MemberPatcher), true
+}
+
+// the repository as MemberPatcher, for decorators forwarding patches to a repository AsMemberPatcher vouched for
+func memberPatcherOf(repo Repository) MemberPatcher {
+	return repo.(MemberPatcher)
+}
+
+// A computed change of the members of a group, i.e. by a sync engine comparing a group with its source: the
+// members to add, replacing any member of the same value, and the values of the members to remove. A value
+// both added and removed is added.
+type MembershipDelta struct {
+	Add    []interface{} `json:"add,omitempty"`
+	Remove []string      `json:"remove,omitempty"`
+}
+
+// Fail with an invalid value when an added member is no object with a value
+func (d MembershipDelta) Validate() error {
+	for _, member := range d.Add {
+		m, ok := member.(map[string]interface{})
+		if !ok {
+			return Error.InvalidParam("add", "member objects", fmt.Sprintf("%v", member))
+		}
+		if value, _ := m["value"].(string); len(value) == 0 {
+			return Error.InvalidParam("add", "members with a value", fmt.Sprintf("%v", member))
+		}
+	}
+	return nil
+}
+
+// Whether the delta neither adds nor removes members
+func (d MembershipDelta) IsEmpty() bool {
+	return len(d.Add) == 0 && len(d.Remove) == 0
+}
+
+// Returns the members after the delta: the members of removed or added values are dropped, the added members
+// appended. Members are compared by value only, as MemberPatcher does.
+func (d MembershipDelta) Apply(members []interface{}) []interface{} {
+	drop := make(map[string]bool, len(d.Remove)+len(d.Add))
+	for _, value := range d.Remove {
+		drop[value] = true
+	}
+	for _, member := range d.Add {
+		drop[member.(map[string]interface{})["value"].(string)] = true
+	}
+	result := make([]interface{}, 0, len(members)+len(d.Add))
+	for _, member := range members {
+		if m, ok := member.(map[string]interface{}); ok && drop[fmt.Sprint(m["value"])] {
+			continue
+		}
+		result = append(result, member)
+	}
+	return append(result, d.Add...)
+}
+
+var memberValuePath = regexp.MustCompile(`(?i)^\s*members\s*\[\s*value\s+eq\s+"([^"\\]*)"\s*\]\s*$`)
+
+// Reduce patch operations that only add members or remove members by value to the net members to add and
+// the member values to remove; a later operation on a value overrides an earlier one. Returns false when any
+// operation does something else, i.e. replaces the members or touches other attributes.
+func MemberDelta(ops []Patch) (adds []interface{}, removes []string, ok bool) {
+	last := make(map[string]interface{})
+	order := make([]string, 0)
+	record := func(value string, op interface{}) {
+		if _, seen := last[value]; !seen {
+			order = append(order, value)
+		}
+		last[value] = op
+	}
+
+	for _, patch := range ops {
+		switch strings.ToLower(patch.Op) {
+		case Add:
+			var values interface{}
+			switch {
+			case strings.EqualFold(strings.TrimSpace(patch.Path), "members"):
+				values = patch.Value
+			case len(patch.Path) == 0:
+				m, isMap := patch.Value.(map[string]interface{})
+				if !isMap || len(m) != 1 {
+					return nil, nil, false
+				}
+				for k, v := range m {
+					if !strings.EqualFold(k, "members") {
+						return nil, nil, false
+					}
+					values = v
+				}
+			default:
+				return nil, nil, false
+			}
+			if single, isMap := values.(map[string]interface{}); isMap {
+				values = []interface{}{single}
+			}
+			members, isArray := values.([]interface{})
+			if !isArray {
+				return nil, nil, false
+			}
+			for _, member := range members {
+				m, isMap := member.(map[string]interface{})
+				if !isMap {
+					return nil, nil, false
+				}
+				value, _ := m["value"].(string)
+				if len(value) == 0 {
+					return nil, nil, false
+				}
+				record(value, m)
+			}
+		case Remove:
+			match := memberValuePath.FindStringSubmatch(patch.Path)
+			if match == nil {
+				return nil, nil, false
+			}
+			record(match[1], nil)
+		default:
+			return nil, nil, false
+		}
+	}
+
+	adds, removes = make([]interface{}, 0), make([]string, 0)
+	for _, value := range order {
+		if member := last[value]; member != nil {
+			adds = append(adds, member)
+		} else {
+			removes = append(removes, value)
+		}
+	}
+	return adds, removes, len(order) > 0
+}
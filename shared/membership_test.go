@@ -0,0 +1,275 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestExpandMembers(t *testing.T) {
+	member := func(value, typ string) map[string]interface{} {
+		m := map[string]interface{}{"value": value}
+		if len(typ) > 0 {
+			m["type"] = typ
+		}
+		return m
+	}
+	group := func(id string, members ...interface{}) DataProvider {
+		return &Resource{Complex: Complex{"id": id, "members": members}}
+	}
+
+	sch, _, err := ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+	repo := &searchCountingRepository{Repository: NewSearchableMapRepository(sch, map[string]DataProvider{
+		"a": group("a", member("u1", UserResourceType), member("b", GroupResourceType), member("c", "")),
+		"b": group("b", member("u2", UserResourceType), member("a", GroupResourceType), member("missing", GroupResourceType)),
+		"c": group("c", member("u1", UserResourceType), member("u3", "")),
+	})}
+
+	members, err := ExpandMembers(repo, "a", context.Background())
+	require.Nil(t, err)
+
+	values := make([]string, 0)
+	for _, m := range members {
+		values = append(values, m.(map[string]interface{})["value"].(string))
+	}
+	assert.Equal(t, []string{"u1", "u2", "u3"}, values)
+	// the groups of a level are searched at once, not got one by one
+	assert.Equal(t, 0, repo.gets)
+	assert.Equal(t, 5, repo.searches)
+
+	_, err = ExpandMembers(repo, "missing", context.Background())
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+}
+
+type searchCountingRepository struct {
+	Repository
+	gets, searches int
+}
+
+func (r *searchCountingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	r.gets++
+	return r.Repository.Get(id, version, ctx)
+}
+
+func (r *searchCountingRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	r.searches++
+	return r.Repository.Search(payload, ctx)
+}
+
+func TestDetectMembershipCycle(t *testing.T) {
+	member := func(value, typ string) interface{} {
+		m := map[string]interface{}{"value": value}
+		if len(typ) > 0 {
+			m["type"] = typ
+		}
+		return m
+	}
+	group := func(id string, members ...interface{}) DataProvider {
+		return &Resource{Complex: Complex{"id": id, "members": members}}
+	}
+
+	// a has b as member, which has c, which has d
+	repo := NewMapRepository(map[string]DataProvider{
+		"a": group("a", member("u1", UserResourceType), member("b", GroupResourceType)),
+		"b": group("b", member("c", "")),
+		"c": group("c", member("d", GroupResourceType), member("u2", "")),
+		"d": group("d"),
+	})
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		id      string
+		members []interface{}
+		path    []string
+	}{
+		{"d", []interface{}{member("u1", UserResourceType), member("a", GroupResourceType)}, []string{"d", "a", "b", "c", "d"}},
+		{"c", []interface{}{member("b", "")}, []string{"c", "b", "c"}},
+		{"a", []interface{}{member("a", GroupResourceType)}, []string{"a", "a"}},
+		{"a", []interface{}{member("b", GroupResourceType), member("d", GroupResourceType), member("missing", GroupResourceType)}, nil},
+		{"d", []interface{}{member("u2", "")}, nil},
+	} {
+		err := DetectMembershipCycle(repo, test.id, test.members, ctx)
+		if test.path == nil {
+			assert.Nil(t, err)
+		} else if assert.IsType(t, &MembershipCycleError{}, err) {
+			assert.Equal(t, test.path, err.(*MembershipCycleError).Path)
+		}
+	}
+}
+
+func TestMemberDelta(t *testing.T) {
+	member := func(value string) map[string]interface{} {
+		return map[string]interface{}{"value": value}
+	}
+
+	for _, test := range []struct {
+		name    string
+		ops     []Patch
+		adds    []interface{}
+		removes []string
+		ok      bool
+	}{
+		{
+			"add and remove",
+			[]Patch{
+				{Op: Add, Path: "members", Value: []interface{}{member("u1"), member("u2")}},
+				{Op: Remove, Path: `members[value eq "u3"]`},
+			},
+			[]interface{}{member("u1"), member("u2")},
+			[]string{"u3"},
+			true,
+		},
+		{
+			"add without path",
+			[]Patch{{Op: Add, Value: map[string]interface{}{"members": member("u1")}}},
+			[]interface{}{member("u1")},
+			[]string{},
+			true,
+		},
+		{
+			"later operation wins",
+			[]Patch{
+				{Op: Add, Path: "members", Value: member("u1")},
+				{Op: Remove, Path: `Members[Value Eq "u1"]`},
+			},
+			[]interface{}{},
+			[]string{"u1"},
+			true,
+		},
+		{
+			"replace",
+			[]Patch{{Op: Replace, Path: "members", Value: []interface{}{member("u1")}}},
+			nil, nil, false,
+		},
+		{
+			"other attribute",
+			[]Patch{
+				{Op: Add, Path: "members", Value: member("u1")},
+				{Op: Replace, Path: "displayName", Value: "Admins"},
+			},
+			nil, nil, false,
+		},
+		{
+			"remove by other filter",
+			[]Patch{{Op: Remove, Path: `members[type eq "User"]`}},
+			nil, nil, false,
+		},
+		{
+			"member without value",
+			[]Patch{{Op: Add, Path: "members", Value: map[string]interface{}{"type": UserResourceType}}},
+			nil, nil, false,
+		},
+	} {
+		adds, removes, ok := MemberDelta(test.ops)
+		assert.Equal(t, test.ok, ok, test.name)
+		if test.ok {
+			assert.ElementsMatch(t, test.adds, adds, test.name)
+			assert.ElementsMatch(t, test.removes, removes, test.name)
+		}
+	}
+}
+
+func TestMapRepository_PatchMembers(t *testing.T) {
+	repo := NewMapRepository(map[string]DataProvider{
+		"a": &Resource{Complex: Complex{
+			"id": "a",
+			"members": []interface{}{
+				map[string]interface{}{"value": "u1"},
+				map[string]interface{}{"value": "u2", "display": "Old"},
+			},
+			"meta": map[string]interface{}{"version": "v1"},
+		}},
+	}).(MemberPatcher)
+
+	err := repo.PatchMembers("a", "v1",
+		[]interface{}{map[string]interface{}{"value": "u2", "display": "New"}, map[string]interface{}{"value": "u3"}},
+		[]string{"u1"},
+		map[string]interface{}{"version": "v2"},
+		context.Background())
+	require.Nil(t, err)
+
+	group, err := repo.(Repository).Get("a", "v2", context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"value": "u2", "display": "New"},
+		map[string]interface{}{"value": "u3"},
+	}, group.GetData()["members"])
+	assert.Equal(t, "v2", group.GetData()["meta"].(map[string]interface{})["version"])
+
+	err = repo.PatchMembers("missing", "", nil, []string{"u1"}, nil, context.Background())
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+}
+
+func TestAsMemberPatcher(t *testing.T) {
+	ctx := context.Background()
+	group := func() map[string]DataProvider {
+		return map[string]DataProvider{"a": &Resource{Complex: Complex{
+			"id":      "a",
+			"members": []interface{}{map[string]interface{}{"value": "u1"}},
+			"meta":    map[string]interface{}{"version": "v1"},
+		}}}
+	}
+	members := func(repo Repository) []interface{} {
+		dp, err := repo.Get("a", "", ctx)
+		require.Nil(t, err)
+		return dp.GetData()["members"].([]interface{})
+	}
+
+	// decorators forward the patch, publishing and dropping the cached group along
+	publisher := &recordingPublisher{}
+	cache := NewLRUResourceCache(10, time.Minute)
+	repo := NewCachingRepository(NewPublishingRepository(NewInstrumentedRepository(NewMapRepository(group()), GroupResourceType, NewMetrics(nil)),
+		GroupResourceType, nil, publisher, nil, nil), cache, GroupResourceType, NewMetrics(nil))
+	require.Len(t, members(repo), 1)
+	patcher, ok := AsMemberPatcher(repo)
+	require.True(t, ok)
+	require.Nil(t, patcher.PatchMembers("a", "v1", []interface{}{map[string]interface{}{"value": "u2"}}, nil, map[string]interface{}{"version": "v2"}, ctx))
+	assert.Len(t, members(repo), 2)
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, ChangeUpdate, publisher.events[0].Op)
+	assert.Equal(t, "v2", publisher.events[0].Version)
+
+	// but are no patchers of repositories that are none
+	plain := struct{ Repository }{NewMapRepository(group())}
+	_, ok = AsMemberPatcher(NewInstrumentedRepository(plain, GroupResourceType, NewMetrics(nil)))
+	assert.False(t, ok)
+
+	// compacted groups are written back whole, at the version read
+	sch, _, err := ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+	compact := NewCompactRepository(NewMapRepository(map[string]DataProvider{}), sch)
+	require.Nil(t, compact.Create(group()["a"], ctx))
+	patcher, ok = AsMemberPatcher(compact)
+	require.True(t, ok)
+	require.Nil(t, patcher.PatchMembers("a", "", nil, []string{"u1"}, map[string]interface{}{"version": "v2"}, ctx))
+	assert.Empty(t, members(compact))
+	assert.IsType(t, &ResourceNotFoundError{}, patcher.PatchMembers("a", "v1", nil, []string{"u1"}, nil, ctx))
+}
+
+func TestMembershipDelta(t *testing.T) {
+	delta := MembershipDelta{
+		Add:    []interface{}{map[string]interface{}{"value": "u2", "display": "New"}, map[string]interface{}{"value": "u4"}},
+		Remove: []string{"u1", "u4", "missing"},
+	}
+	require.Nil(t, delta.Validate())
+	assert.False(t, delta.IsEmpty())
+	// added values win over removed ones
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"value": "u3"},
+		map[string]interface{}{"value": "u2", "display": "New"},
+		map[string]interface{}{"value": "u4"},
+	}, delta.Apply([]interface{}{
+		map[string]interface{}{"value": "u1"},
+		map[string]interface{}{"value": "u2", "display": "Old"},
+		map[string]interface{}{"value": "u3"},
+	}))
+
+	assert.True(t, MembershipDelta{}.IsEmpty())
+	for _, add := range []interface{}{"u1", map[string]interface{}{"display": "no value"}} {
+		err := MembershipDelta{Add: []interface{}{add}}.Validate()
+		assert.IsType(t, &InvalidParamError{}, err)
+	}
+}
@@ -0,0 +1,203 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// The code of the error the catalog keys its messages by, the name of the method of ErrorFactory creating it,
+// i.e. missingRequiredProperty, or an empty string for errors of no factory method.
+func ErrorCode(err error) string {
+	switch err.(type) {
+	case *InvalidPathError:
+		return "invalidPath"
+	case *InvalidFilterError:
+		return "invalidFilter"
+	case *InvalidTypeError:
+		return "invalidType"
+	case *NoAttributeError:
+		return "noAttribute"
+	case *UnknownAttributeError:
+		return "unknownAttribute"
+	case *MissingRequiredPropertyError:
+		return "missingRequiredProperty"
+	case *MutabilityViolationError:
+		return "mutabilityViolation"
+	case *MembershipCycleError:
+		return "membershipCycle"
+	case *InvalidParamError:
+		return "invalidParam"
+	case *ResourceNotFoundError:
+		return "resourceNotFound"
+	case *GoneError:
+		return "gone"
+	case *VersionConflictError:
+		return "versionConflict"
+	case *DuplicateError:
+		return "duplicate"
+	case *TooManyError:
+		return "tooMany"
+	case *ForbiddenError:
+		return "forbidden"
+	case *RateLimitedError:
+		return "rateLimited"
+	case *TimeoutError:
+		return "timeout"
+	case *UnavailableError:
+		return "unavailable"
+	case *UnsupportedMediaTypeError:
+		return "unsupportedMediaType"
+	case *NotAcceptableError:
+		return "notAcceptable"
+	case *PayloadTooLargeError:
+		return "payloadTooLarge"
+	case *NotImplementedError:
+		return "notImplemented"
+	case *NotReadyError:
+		return "notReady"
+	case *RangeNotSatisfiableError:
+		return "rangeNotSatisfiable"
+	case *PreconditionRequiredError:
+		return "preconditionRequired"
+	case *InUseError:
+		return "inUse"
+	}
+	return ""
+}
+
+// Translations of the details of error responses, by language and error code, see ErrorCode. Messages are
+// text/template templates executed with the error, so that they refer to its fields, i.e.
+// "Pflichtwert fehlt bei '{{.Path}}'" for missingRequiredProperty; join concatenates lists, as in
+// {{join .Path " -> "}}. Errors of codes without a message in any of the languages a client accepts keep their
+// English detail.
+type MessageCatalog struct {
+	sync.RWMutex
+	byLanguage map[string]map[string]*template.Template
+}
+
+func NewMessageCatalog() *MessageCatalog {
+	return &MessageCatalog{byLanguage: make(map[string]map[string]*template.Template)}
+}
+
+var messageFuncs = template.FuncMap{"join": strings.Join}
+
+// Register the message of the error code in the language, a tag like de or pt-BR. Fails when the message is
+// no valid template.
+func (c *MessageCatalog) Register(language, code, message string) error {
+	tmpl, err := template.New(code).Funcs(messageFuncs).Parse(message)
+	if err != nil {
+		return Error.InvalidParam("message of "+code, "text/template", err.Error())
+	}
+	language = strings.ToLower(language)
+	c.Lock()
+	defer c.Unlock()
+	if c.byLanguage[language] == nil {
+		c.byLanguage[language] = make(map[string]*template.Template)
+	}
+	c.byLanguage[language][code] = tmpl
+	return nil
+}
+
+// Register the messages of a JSON file of messages by error code by language, i.e.
+// {"de": {"duplicate": "Der Wert '{{.Value}}' von '{{.Path}}' ist bereits vergeben"}}
+func (c *MessageCatalog) LoadFile(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	byLanguage := make(map[string]map[string]string)
+	if err := json.Unmarshal(raw, &byLanguage); err != nil {
+		return fmt.Errorf("messages %s: %s", path, err)
+	}
+	for language, messages := range byLanguage {
+		for code, message := range messages {
+			if err := c.Register(language, code, message); err != nil {
+				return fmt.Errorf("messages %s: %s", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// The detail of the error in the language the Accept-Language header prefers among those with a message of
+// its code, and that language. Returns empty strings when the English detail of the error is to be used: the
+// header names no such language, or prefers English over them. Languages match exactly or by their primary
+// tag, so that de-CH is served de.
+func (c *MessageCatalog) Localize(err error, acceptLanguage string) (detail, language string) {
+	if c == nil || len(acceptLanguage) == 0 {
+		return "", ""
+	}
+	code := ErrorCode(err)
+	if len(code) == 0 {
+		return "", ""
+	}
+	c.RLock()
+	defer c.RUnlock()
+	for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+		for _, candidate := range []string{tag, primaryTag(tag)} {
+			tmpl, ok := c.byLanguage[candidate][code]
+			if !ok {
+				continue
+			}
+			var buf bytes.Buffer
+			if tmpl.Execute(&buf, err) != nil {
+				continue
+			}
+			return buf.String(), candidate
+		}
+		if primaryTag(tag) == "en" {
+			return "", ""
+		}
+	}
+	return "", ""
+}
+
+func primaryTag(tag string) string {
+	if i := strings.Index(tag, "-"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// The language tags of an Accept-Language header, lower cased, most preferred first. Tags of quality 0 and
+// the wildcard are left out.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	ranges := make([]weighted, 0)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	tags := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		tags = append(tags, r.tag)
+	}
+	return tags
+}
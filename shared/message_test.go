@@ -0,0 +1,47 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, []string{"de-ch", "fr", "en"}, ParseAcceptLanguage("fr;q=0.9, en;q=0.5, de-CH, *;q=0.1, nl;q=0"))
+	assert.Empty(t, ParseAcceptLanguage(""))
+}
+
+func TestMessageCatalog(t *testing.T) {
+	catalog := NewMessageCatalog()
+	require.Nil(t, catalog.Register("de", "missingRequiredProperty", "Pflichtwert fehlt bei '{{.Path}}'"))
+	require.Nil(t, catalog.Register("de", "membershipCycle", `Zyklische Gruppenmitgliedschaft {{join .Path " -> "}}`))
+	require.Nil(t, catalog.Register("fr", "duplicate", "La valeur '{{.Value}}' de '{{.Path}}' est déjà utilisée"))
+	assert.NotNil(t, catalog.Register("de", "duplicate", "{{.Path"))
+
+	detail, language := catalog.Localize(Error.MissingRequiredProperty("userName"), "de-CH, en;q=0.5")
+	assert.Equal(t, "Pflichtwert fehlt bei 'userName'", detail)
+	assert.Equal(t, "de", language)
+	detail, _ = catalog.Localize(Error.MembershipCycle([]string{"a", "b", "a"}), "de")
+	assert.Equal(t, "Zyklische Gruppenmitgliedschaft a -> b -> a", detail)
+
+	// the next language translating the error, unless English comes first
+	detail, language = catalog.Localize(Error.Duplicate("userName", "bjensen"), "de, fr;q=0.8")
+	assert.Equal(t, "La valeur 'bjensen' de 'userName' est déjà utilisée", detail)
+	assert.Equal(t, "fr", language)
+	_, language = catalog.Localize(Error.Duplicate("userName", "bjensen"), "de, en;q=0.9, fr;q=0.8")
+	assert.Empty(t, language)
+
+	_, language = catalog.Localize(Error.Text("boom"), "de")
+	assert.Empty(t, language)
+	_, language = catalog.Localize(Error.MissingRequiredProperty("userName"), "")
+	assert.Empty(t, language)
+	_, language = (*MessageCatalog)(nil).Localize(Error.MissingRequiredProperty("userName"), "de")
+	assert.Empty(t, language)
+
+	loaded := NewMessageCatalog()
+	require.Nil(t, loaded.LoadFile("../resources/messages/messages.json"))
+	detail, _ = loaded.Localize(Error.ForbiddenFilter("password"), "de")
+	assert.Equal(t, "Keine Berechtigung, nach dem Attribut 'password' zu filtern", detail)
+	detail, _ = loaded.Localize(Error.Forbidden("password"), "de")
+	assert.Equal(t, "Keine Berechtigung, das Attribut 'password' zu ändern", detail)
+}
@@ -0,0 +1,159 @@
+package shared
+
+import (
+	"context"
+	"time"
+)
+
+// Metric names registered by NewMetrics
+const (
+	MetricRequests             = "scim_requests_total"
+	MetricRequestDuration      = "scim_request_duration_seconds"
+	MetricRepositoryDuration   = "scim_repository_duration_seconds"
+	MetricFilterParseFailures  = "scim_filter_parse_failures_total"
+	MetricPatchOps             = "scim_patch_operations_total"
+	MetricFilterCacheLookups   = "scim_filter_cache_lookups_total"
+	MetricResourceCacheLookups = "scim_resource_cache_lookups_total"
+)
+
+// Collectors for provisioning health. All collectors are registered once
+// against a MetricsRegisterer and then shared by handlers and repositories.
+type Metrics struct {
+	Requests             Counter   // labels: resource_type, operation, status
+	RequestDuration      Histogram // labels: resource_type, operation
+	RepositoryDuration   Histogram // labels: resource_type, method
+	FilterParseFailures  Counter   // labels: resource_type
+	PatchOps             Counter   // labels: resource_type, op
+	FilterCacheLookups   Counter   // labels: result (hit or miss)
+	ResourceCacheLookups Counter   // labels: resource_type, result (hit, miss, stale or error)
+}
+
+// Register all collectors with the registerer. A nil registerer produces
+// metrics that discard every observation.
+func NewMetrics(registerer MetricsRegisterer) *Metrics {
+	if registerer == nil {
+		registerer = noOpRegisterer{}
+	}
+	return &Metrics{
+		Requests: registerer.Counter(
+			MetricRequests,
+			"Number of handled requests by resource type, operation and response status.",
+			"resource_type", "operation", "status"),
+		RequestDuration: registerer.Histogram(
+			MetricRequestDuration,
+			"Time spent handling requests by resource type and operation.",
+			"resource_type", "operation"),
+		RepositoryDuration: registerer.Histogram(
+			MetricRepositoryDuration,
+			"Time spent in repository calls by resource type and method.",
+			"resource_type", "method"),
+		FilterParseFailures: registerer.Counter(
+			MetricFilterParseFailures,
+			"Number of search requests rejected because of an invalid filter.",
+			"resource_type"),
+		PatchOps: registerer.Counter(
+			MetricPatchOps,
+			"Number of applied patch operations by resource type and op.",
+			"resource_type", "op"),
+		FilterCacheLookups: registerer.Counter(
+			MetricFilterCacheLookups,
+			"Number of compiled filter lookups by whether the filter was cached.",
+			"result"),
+		ResourceCacheLookups: registerer.Counter(
+			MetricResourceCacheLookups,
+			"Number of resource cache lookups by resource type and whether the resource was cached.",
+			"resource_type", "result"),
+	}
+}
+
+type noOpRegisterer struct{}
+
+func (r noOpRegisterer) Counter(name, help string, labelNames ...string) Counter { return noOpMetric{} }
+func (r noOpRegisterer) Histogram(name, help string, labelNames ...string) Histogram {
+	return noOpMetric{}
+}
+
+type noOpMetric struct{}
+
+func (m noOpMetric) Inc(labelValues ...string)                    {}
+func (m noOpMetric) Observe(value float64, labelValues ...string) {}
+
+// Decorates a repository so that the latency of every call is observed
+// under the given resource type
+func NewInstrumentedRepository(repo Repository, resourceType string, metrics *Metrics) Repository {
+	return &instrumentedRepository{repo: repo, resourceType: resourceType, metrics: metrics}
+}
+
+type instrumentedRepository struct {
+	repo         Repository
+	resourceType string
+	metrics      *Metrics
+}
+
+func (r *instrumentedRepository) observe(method string, start time.Time) {
+	r.metrics.RepositoryDuration.Observe(time.Since(start).Seconds(), r.resourceType, method)
+}
+
+func (r *instrumentedRepository) Create(provider DataProvider, ctx context.Context) error {
+	defer r.observe("create", time.Now())
+	return r.repo.Create(provider, ctx)
+}
+
+func (r *instrumentedRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	defer r.observe("get", time.Now())
+	return r.repo.Get(id, version, ctx)
+}
+
+func (r *instrumentedRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	defer r.observe("exists", time.Now())
+	return ResourceExists(r.repo, id, version, ctx)
+}
+
+func (r *instrumentedRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	defer r.observe("countByIds", time.Now())
+	return CountExisting(r.repo, ids, ctx)
+}
+
+func (r *instrumentedRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	defer r.observe("getAll", time.Now())
+	return r.repo.GetAll(ctx)
+}
+
+func (r *instrumentedRepository) Count(query string, ctx context.Context) (int, error) {
+	defer r.observe("count", time.Now())
+	return r.repo.Count(query, ctx)
+}
+
+func (r *instrumentedRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	defer r.observe("update", time.Now())
+	return r.repo.Update(id, version, provider, ctx)
+}
+
+func (r *instrumentedRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	defer r.observe("patchMembers", time.Now())
+	return memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *instrumentedRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *instrumentedRepository) Delete(id, version string, ctx context.Context) error {
+	defer r.observe("delete", time.Now())
+	return r.repo.Delete(id, version, ctx)
+}
+
+func (r *instrumentedRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	defer r.observe("search", time.Now())
+	return r.repo.Search(payload, ctx)
+}
+
+func (r *instrumentedRepository) Ping(ctx context.Context) error {
+	defer r.observe("ping", time.Now())
+	return r.repo.Ping(ctx)
+}
+
+func (r *instrumentedRepository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	defer r.observe("getSlice", time.Now())
+	return SliceAttribute(r.repo, id, attribute, startIndex, count, ctx)
+}
@@ -0,0 +1,83 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestNewMetrics(t *testing.T) {
+	registerer := &recordingRegisterer{counts: map[string]int{}, observations: map[string]int{}}
+	metrics := NewMetrics(registerer)
+	require.NotNil(t, metrics)
+
+	metrics.Requests.Inc(UserResourceType, "create", "201")
+	metrics.Requests.Inc(UserResourceType, "create", "201")
+	metrics.FilterParseFailures.Inc(GroupResourceType)
+	metrics.PatchOps.Inc(GroupResourceType, Add)
+
+	assert.Equal(t, 2, registerer.counts[MetricRequests+"|User|create|201"])
+	assert.Equal(t, 1, registerer.counts[MetricFilterParseFailures+"|Group"])
+	assert.Equal(t, 1, registerer.counts[MetricPatchOps+"|Group|add"])
+
+	// nil registerer discards everything
+	assert.NotPanics(t, func() {
+		discard := NewMetrics(nil)
+		discard.Requests.Inc(UserResourceType, "get", "200")
+		discard.RepositoryDuration.Observe(0.1, UserResourceType, "get")
+	})
+}
+
+func TestInstrumentedRepository(t *testing.T) {
+	registerer := &recordingRegisterer{counts: map[string]int{}, observations: map[string]int{}}
+	metrics := NewMetrics(registerer)
+
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+
+	repo := NewInstrumentedRepository(NewMapRepository(nil), UserResourceType, metrics)
+	require.Nil(t, repo.Create(r, context.Background()))
+	_, err = repo.Get(r.GetId(), "", context.Background())
+	require.Nil(t, err)
+	_, err = repo.Get("foo", "", context.Background())
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+	require.Nil(t, repo.Delete(r.GetId(), "", context.Background()))
+
+	assert.Equal(t, 1, registerer.observations[MetricRepositoryDuration+"|User|create"])
+	assert.Equal(t, 2, registerer.observations[MetricRepositoryDuration+"|User|get"])
+	assert.Equal(t, 1, registerer.observations[MetricRepositoryDuration+"|User|delete"])
+}
+
+// A registerer that keeps track of the number of increments and observations
+// keyed by metric name and label values
+type recordingRegisterer struct {
+	counts       map[string]int
+	observations map[string]int
+}
+
+func (r *recordingRegisterer) Counter(name, help string, labelNames ...string) Counter {
+	return &recordingMetric{name: name, registerer: r}
+}
+
+func (r *recordingRegisterer) Histogram(name, help string, labelNames ...string) Histogram {
+	return &recordingMetric{name: name, registerer: r}
+}
+
+type recordingMetric struct {
+	name       string
+	registerer *recordingRegisterer
+}
+
+func (m *recordingMetric) key(labelValues []string) string {
+	return strings.Join(append([]string{m.name}, labelValues...), "|")
+}
+
+func (m *recordingMetric) Inc(labelValues ...string) {
+	m.registerer.counts[m.key(labelValues)]++
+}
+
+func (m *recordingMetric) Observe(value float64, labelValues ...string) {
+	m.registerer.observations[m.key(labelValues)]++
+}
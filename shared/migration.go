@@ -0,0 +1,273 @@
+package shared
+
+import (
+	"context"
+	"sort"
+)
+
+// Key under which the schema version of a stored resource is kept. It is added by NewMigratingRepository
+// on write and removed on read, so it never reaches handlers or clients.
+const SchemaVersionKey = "_schemaVersion"
+
+// A versioned transformation of stored resources, i.e. renaming an attribute or moving it into an extension.
+// Migrate modifies the data in place.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(data Complex) error
+}
+
+// Ordered set of migrations of a resource type
+type Migrations struct {
+	list []Migration
+}
+
+// Create a migration set, migrations are ordered by version which must be positive and unique
+func NewMigrations(migrations ...Migration) (*Migrations, error) {
+	list := append([]Migration{}, migrations...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	for i, m := range list {
+		if m.Version < 1 {
+			return nil, Error.InvalidParam("migration version", "positive integer", "non-positive")
+		}
+		if i > 0 && list[i-1].Version == m.Version {
+			return nil, Error.InvalidParam("migration version", "unique version", "duplicate")
+		}
+	}
+	return &Migrations{list: list}, nil
+}
+
+// The version resources have once all migrations are applied, 0 if there are none
+func (m *Migrations) Latest() int {
+	if len(m.list) == 0 {
+		return 0
+	}
+	return m.list[len(m.list)-1].Version
+}
+
+// The schema version recorded in the data, 0 if none is recorded
+func SchemaVersionOf(data Complex) int {
+	switch v := data[SchemaVersionKey].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// Apply the migrations the data has not seen yet and record the latest version in it.
+// Returns whether any migration was applied.
+func (m *Migrations) Apply(data Complex) (bool, error) {
+	current := SchemaVersionOf(data)
+	applied := false
+	for _, migration := range m.list {
+		if migration.Version <= current {
+			continue
+		}
+		if err := migration.Migrate(data); err != nil {
+			return applied, Error.Text("migration %d (%s) failed: %s", migration.Version, migration.Description, err.Error())
+		}
+		data[SchemaVersionKey] = migration.Version
+		applied = true
+	}
+	return applied, nil
+}
+
+// Decorates a repository so that stored resources are migrated lazily on read and stamped with the
+// latest schema version on write. Filters are evaluated by the underlying repository against the
+// stored, possibly not yet migrated, data; run a MigrationRunner to bring all of it up to date.
+func NewMigratingRepository(repo Repository, migrations *Migrations) Repository {
+	return &migratingRepository{repo: repo, migrations: migrations}
+}
+
+type migratingRepository struct {
+	repo       Repository
+	migrations *Migrations
+}
+
+func (r *migratingRepository) stamp(provider DataProvider) DataProvider {
+	data := deepCopy(map[string]interface{}(provider.GetData())).(map[string]interface{})
+	data[SchemaVersionKey] = r.migrations.Latest()
+	return &Resource{Complex: Complex(data)}
+}
+
+func (r *migratingRepository) migrate(data Complex) (Complex, error) {
+	if _, ok := data[SchemaVersionKey]; !ok && r.migrations.Latest() == 0 {
+		return data, nil
+	}
+	migrated := Complex(deepCopy(map[string]interface{}(data)).(map[string]interface{}))
+	if _, err := r.migrations.Apply(migrated); err != nil {
+		return nil, err
+	}
+	delete(migrated, SchemaVersionKey)
+	return migrated, nil
+}
+
+func (r *migratingRepository) Create(provider DataProvider, ctx context.Context) error {
+	return r.repo.Create(r.stamp(provider), ctx)
+}
+
+func (r *migratingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	dp, err := r.repo.Get(id, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrated, err := r.migrate(dp.GetData())
+	if err != nil {
+		return nil, err
+	}
+	return &Resource{Complex: migrated}, nil
+}
+
+func (r *migratingRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	all, err := r.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrated := make([]Complex, 0, len(all))
+	for _, c := range all {
+		m, err := r.migrate(c)
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, m)
+	}
+	return migrated, nil
+}
+
+func (r *migratingRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *migratingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return r.repo.Update(id, version, r.stamp(provider), ctx)
+}
+
+// the members patched in place are of the latest schema already, the version of the group is left alone
+func (r *migratingRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	return memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *migratingRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *migratingRepository) Delete(id, version string, ctx context.Context) error {
+	return r.repo.Delete(id, version, ctx)
+}
+
+func (r *migratingRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+func (r *migratingRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	lr, err := r.repo.Search(payload, ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]DataProvider, 0, len(lr.Resources))
+	for _, dp := range lr.Resources {
+		m, err := r.migrate(dp.GetData())
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, &Resource{Complex: m})
+	}
+	migrated := *lr
+	migrated.Resources = resources
+	return &migrated, nil
+}
+
+// Progress of a migration run
+type MigrationProgress struct {
+	Total    int // resources in the repository when the run started
+	Migrated int // resources rewritten at the latest version
+	Current  int // resources already at the latest version
+	Skipped  int // resources changed or deleted concurrently, they are migrated by that write
+	Failed   int // resources a migration failed for
+}
+
+// Migrates all resources of a repository in batches. Repository must be the undecorated repository,
+// so that the stored schema version is visible. Each resource is written back conditionally on its
+// meta.version, so concurrent writes win and are not overwritten.
+type MigrationRunner struct {
+	Repository Repository
+	Migrations *Migrations
+	BatchSize  int
+	Progress   func(progress MigrationProgress)
+}
+
+// Run the migration until all resources are processed or the context is done
+func (r *MigrationRunner) Run(ctx context.Context) (MigrationProgress, error) {
+	batchSize := r.BatchSize
+	if batchSize < 1 {
+		batchSize = 100
+	}
+
+	progress := MigrationProgress{}
+	total, err := r.Repository.Count("id pr", ctx)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = total
+
+	for startIndex := 1; startIndex <= total; startIndex += batchSize {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		lr, err := r.Repository.Search(SearchRequest{
+			Filter:     "id pr",
+			SortBy:     "id",
+			SortOrder:  "ascending",
+			StartIndex: startIndex,
+			Count:      batchSize,
+		}, ctx)
+		if err != nil {
+			return progress, err
+		}
+
+		for _, dp := range lr.Resources {
+			r.migrateOne(dp, &progress, ctx)
+		}
+		if r.Progress != nil {
+			r.Progress(progress)
+		}
+		if len(lr.Resources) < batchSize {
+			break
+		}
+	}
+	return progress, nil
+}
+
+func (r *MigrationRunner) migrateOne(dp DataProvider, progress *MigrationProgress, ctx context.Context) {
+	data := Complex(deepCopy(map[string]interface{}(dp.GetData())).(map[string]interface{}))
+	version := ""
+	if meta, ok := data["meta"].(map[string]interface{}); ok {
+		version, _ = meta["version"].(string)
+	}
+
+	applied, err := r.Migrations.Apply(data)
+	if err != nil {
+		progress.Failed++
+		return
+	}
+	if !applied {
+		progress.Current++
+		return
+	}
+
+	if err := r.Repository.Update(dp.GetId(), version, &Resource{Complex: data}, ctx); err != nil {
+		if _, ok := err.(*ResourceNotFoundError); ok {
+			progress.Skipped++
+		} else {
+			progress.Failed++
+		}
+		return
+	}
+	progress.Migrated++
+}
@@ -0,0 +1,100 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func renameNickNameMigrations(t *testing.T) *Migrations {
+	migrations, err := NewMigrations(
+		Migration{
+			Version:     2,
+			Description: "uppercase nick name",
+			Migrate: func(data Complex) error {
+				if v, ok := data["nickName"].(string); ok {
+					data["nickName"] = v + "!"
+				}
+				return nil
+			},
+		},
+		Migration{
+			Version:     1,
+			Description: "rename nick to nickName",
+			Migrate: func(data Complex) error {
+				if v, ok := data["nick"]; ok {
+					data["nickName"] = v
+					delete(data, "nick")
+				}
+				return nil
+			},
+		},
+	)
+	require.Nil(t, err)
+	return migrations
+}
+
+func TestNewMigrations(t *testing.T) {
+	_, err := NewMigrations(Migration{Version: 1}, Migration{Version: 1})
+	assert.NotNil(t, err)
+	_, err = NewMigrations(Migration{Version: 0})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, renameNickNameMigrations(t).Latest())
+}
+
+func TestMigratingRepository(t *testing.T) {
+	migrations := renameNickNameMigrations(t)
+	backing := NewMapRepository(map[string]DataProvider{
+		"v0": &Resource{Complex: Complex{"id": "v0", "nick": "Q"}},
+		"v1": &Resource{Complex: Complex{"id": "v1", "nickName": "Q", SchemaVersionKey: 1}},
+	})
+	repo := NewMigratingRepository(backing, migrations)
+
+	for _, id := range []string{"v0", "v1"} {
+		dp, err := repo.Get(id, "", context.Background())
+		require.Nil(t, err)
+		assert.Equal(t, "Q!", dp.GetData()["nickName"])
+		assert.NotContains(t, dp.GetData(), "nick")
+		assert.NotContains(t, dp.GetData(), SchemaVersionKey)
+	}
+
+	// migrated lazily, storage untouched
+	stored, _ := backing.Get("v0", "", context.Background())
+	assert.Equal(t, "Q", stored.GetData()["nick"])
+
+	// writes are stamped with the latest version
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "new", "nickName": "N"}}, context.Background()))
+	stored, _ = backing.Get("new", "", context.Background())
+	assert.Equal(t, 2, stored.GetData()[SchemaVersionKey])
+	dp, _ := repo.Get("new", "", context.Background())
+	assert.Equal(t, "N", dp.GetData()["nickName"])
+}
+
+func TestMigrationRunner(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	data := make(map[string]DataProvider)
+	for _, id := range []string{"a", "b", "c"} {
+		data[id] = &Resource{Complex: Complex{"id": id, "nick": id}}
+	}
+	data["d"] = &Resource{Complex: Complex{"id": "d", "nickName": "d", SchemaVersionKey: 2}}
+	repo := NewSearchableMapRepository(sch, data)
+
+	reports := 0
+	runner := &MigrationRunner{
+		Repository: repo,
+		Migrations: renameNickNameMigrations(t),
+		BatchSize:  2,
+		Progress:   func(progress MigrationProgress) { reports++ },
+	}
+	progress, err := runner.Run(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, MigrationProgress{Total: 4, Migrated: 3, Current: 1}, progress)
+	assert.Equal(t, 2, reports)
+
+	stored, _ := repo.Get("a", "", context.Background())
+	assert.Equal(t, "a!", stored.GetData()["nickName"])
+	assert.Equal(t, 2, stored.GetData()[SchemaVersionKey])
+}
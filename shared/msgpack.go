@@ -0,0 +1,101 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// write a value projected by project as MessagePack, integers in the fewest bytes they fit
+func encodeMessagePack(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int64:
+		msgpackInt(buf, v)
+	case float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		msgpackHead(buf, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		msgpackHead(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, each := range v {
+			if err := encodeMessagePack(buf, each); err != nil {
+				return err
+			}
+		}
+	case fields:
+		msgpackHead(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, f := range v {
+			encodeMessagePack(buf, f.name)
+			if err := encodeMessagePack(buf, f.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("MessagePack cannot encode a value of type %T", v)
+	}
+	return nil
+}
+
+func msgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		// positive fixint
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		// negative fixint
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(i))
+	case i >= 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(i))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// the head of a string, array or map of n entries: the fix format when n is below fixLimit, else the
+// format of 8, 16 or 32 bit length, arrays and maps having none of 8 bits
+func msgpackHead(buf *bytes.Buffer, n int, fix byte, fixLimit int, len8, len16, len32 byte) {
+	switch {
+	case n < fixLimit:
+		buf.WriteByte(fix | byte(n))
+	case len8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(len8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(len16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(len32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
@@ -156,6 +156,8 @@ func (mv *mutabilityValidator) getValue(v reflect.Value, attr *Attribute) reflec
 
 func (mv *mutabilityValidator) safeIsNil(value reflect.Value) bool {
 	switch value.Kind() {
+	case reflect.Invalid:
+		return true
 	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
 		return value.IsNil()
 	default:
@@ -0,0 +1,116 @@
+package shared
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types of SCIM request and response bodies. application/json is accepted for clients that
+// do not know the SCIM media type.
+const (
+	ScimMediaType = "application/scim+json"
+	JsonMediaType = "application/json"
+)
+
+// Check that the body of a create, replace, patch or search request is SCIM or plain JSON encoded in UTF-8.
+// Media type parameters other than charset are ignored. A request without Content-Type is assumed to be JSON.
+func CheckContentType(req WebRequest) error {
+	switch req.Method() {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return nil
+	}
+
+	contentType := req.Header("Content-Type")
+	if len(contentType) == 0 {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Error.UnsupportedMediaType(contentType)
+	}
+	if mediaType != ScimMediaType && mediaType != JsonMediaType {
+		return Error.UnsupportedMediaType(contentType)
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return Error.UnsupportedMediaType(contentType)
+	}
+	return nil
+}
+
+// Check that the Accept header of the request, if any, admits a JSON response: the most specific range
+// matching application/scim+json or application/json has a quality above 0. Ranges of quality 0 exclude the
+// media type even when a less specific range, like */*, admits it.
+func CheckAccept(req WebRequest) error {
+	accept := req.Header("Accept")
+	if len(accept) == 0 {
+		return nil
+	}
+	ranges := parseRanges(accept)
+	for _, mediaType := range []string{ScimMediaType, JsonMediaType} {
+		if quality(ranges, func(r string) int {
+			switch r {
+			case mediaType:
+				return 3
+			case "application/*":
+				return 2
+			case "*/*":
+				return 1
+			}
+			return 0
+		}) > 0 {
+			return nil
+		}
+	}
+	return Error.NotAcceptable(accept)
+}
+
+// Tell whether the Accept-Encoding header of the request admits a gzip encoded response, gzip;q=0 excluding it
+// even when * admits it
+func AcceptsGzip(req WebRequest) bool {
+	return quality(parseRanges(req.Header("Accept-Encoding")), func(r string) int {
+		switch r {
+		case "gzip":
+			return 2
+		case "*":
+			return 1
+		}
+		return 0
+	}) > 0
+}
+
+type weightedRange struct {
+	value   string
+	quality float64
+}
+
+// the ranges of an Accept or Accept-Encoding header with their quality, 1 when it is absent or malformed;
+// malformed ranges are skipped
+func parseRanges(header string) []weightedRange {
+	ranges := make([]weightedRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		value, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if parsed, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = parsed
+		}
+		ranges = append(ranges, weightedRange{value, q})
+	}
+	return ranges
+}
+
+// the quality of the most specific of the ranges that match, 0 when none does
+func quality(ranges []weightedRange, specificity func(r string) int) float64 {
+	most, q := 0, 0.0
+	for _, r := range ranges {
+		if s := specificity(r.value); s > most {
+			most, q = s, r.quality
+		}
+	}
+	return q
+}
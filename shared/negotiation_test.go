@@ -0,0 +1,70 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+type methodRequest struct {
+	headerRequest
+	method string
+}
+
+func (r methodRequest) Method() string { return r.method }
+
+func TestCheckContentType(t *testing.T) {
+	for _, test := range []struct {
+		method      string
+		contentType string
+		supported   bool
+	}{
+		{http.MethodPost, "application/scim+json", true},
+		{http.MethodPut, "application/json; charset=UTF-8", true},
+		{http.MethodPatch, "", true},
+		{http.MethodPost, "application/scim+json; charset=iso-8859-1", false},
+		{http.MethodPost, "text/plain", false},
+		{http.MethodPost, "application/xml", false},
+		{http.MethodGet, "text/plain", true},
+	} {
+		err := CheckContentType(methodRequest{headerRequest{"Content-Type": test.contentType}, test.method})
+		if test.supported {
+			assert.Nil(t, err, test.contentType)
+		} else {
+			assert.IsType(t, &UnsupportedMediaTypeError{}, err, test.contentType)
+		}
+	}
+}
+
+func TestCheckAccept(t *testing.T) {
+	for _, test := range []struct {
+		accept     string
+		acceptable bool
+	}{
+		{"", true},
+		{"application/scim+json", true},
+		{"text/html, application/json;q=0.9", true},
+		{"*/*", true},
+		{"text/html", false},
+		{"application/json;q=0", false},
+		{"application/json;q=0.000", false},
+		{"*/*, application/scim+json;q=0, application/json;q=0", false},
+		{"*/*;q=0, application/json", true},
+	} {
+		err := CheckAccept(headerRequest{"Accept": test.accept})
+		if test.acceptable {
+			assert.Nil(t, err, test.accept)
+		} else {
+			assert.IsType(t, &NotAcceptableError{}, err, test.accept)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	assert.False(t, AcceptsGzip(headerRequest{}))
+	assert.True(t, AcceptsGzip(headerRequest{"Accept-Encoding": "gzip"}))
+	assert.True(t, AcceptsGzip(headerRequest{"Accept-Encoding": "deflate, gzip;q=0.5"}))
+	assert.True(t, AcceptsGzip(headerRequest{"Accept-Encoding": "*"}))
+	assert.False(t, AcceptsGzip(headerRequest{"Accept-Encoding": "gzip;q=0, deflate"}))
+	assert.False(t, AcceptsGzip(headerRequest{"Accept-Encoding": "*, gzip;q=0.0"}))
+}
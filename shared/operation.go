@@ -0,0 +1,198 @@
+package shared
+
+import (
+	"context"
+	"github.com/satori/go.uuid"
+	"sync"
+	"time"
+)
+
+// Kinds of queued mutations
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Status of a queued mutation
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// A mutation that was validated synchronously and is applied to the repository by a worker later.
+// Patches are validated and applied to the resource before being queued as an update.
+type Operation struct {
+	Id           string       `json:"id"`
+	Kind         string       `json:"kind"`
+	Status       string       `json:"status"`
+	ResourceType string       `json:"resourceType"`
+	ResourceId   string       `json:"resourceId,omitempty"`
+	Version      string       `json:"version,omitempty"`
+	Resource     DataProvider `json:"-"`
+	Error        string       `json:"error,omitempty"`
+	Created      time.Time    `json:"created"`
+	LastModified time.Time    `json:"lastModified"`
+}
+
+// Transport of queued operations. Implementations may be backed by a channel, Redis, SQS, etc.
+// Dequeue blocks until an operation is available or the context is done. Queues that lose their operations
+// with the process, like the channel queue, return those available even once the context is done, so that
+// workers being stopped apply them first.
+type OperationQueue interface {
+	Enqueue(op *Operation) error
+	Dequeue(ctx context.Context) (*Operation, error)
+}
+
+// Keeps track of the status of operations so that it can be reported to clients
+type OperationStore interface {
+	Put(op *Operation) error
+	Get(id string) (*Operation, error)
+}
+
+// Assign an id to the operation, record it as pending and hand it to the queue
+func SubmitOperation(op *Operation, queue OperationQueue, store OperationStore) error {
+	now := time.Now()
+	op.Id = uuid.NewV4().String()
+	op.Status = OperationPending
+	op.Created = now
+	op.LastModified = now
+	if err := store.Put(op); err != nil {
+		return err
+	}
+	if err := queue.Enqueue(op); err != nil {
+		op.Status = OperationFailed
+		op.Error = err.Error()
+		op.LastModified = time.Now()
+		store.Put(op)
+		return err
+	}
+	return nil
+}
+
+// Returns an in process queue with the given buffer size. Enqueue does not wait when the buffer is full, it
+// drops the operation and fails with a rate limited error for the client to retry later.
+func NewChannelOperationQueue(size int) OperationQueue {
+	return &channelOperationQueue{ch: make(chan *Operation, size)}
+}
+
+type channelOperationQueue struct {
+	ch chan *Operation
+}
+
+func (q *channelOperationQueue) Enqueue(op *Operation) error {
+	select {
+	case q.ch <- op:
+		return nil
+	default:
+		return Error.RateLimited(time.Second)
+	}
+}
+
+func (q *channelOperationQueue) Dequeue(ctx context.Context) (*Operation, error) {
+	select {
+	case op := <-q.ch:
+		return op, nil
+	default:
+	}
+	select {
+	case op := <-q.ch:
+		return op, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Returns an operation store that keeps operations in memory
+func NewMapOperationStore() OperationStore {
+	return &mapOperationStore{data: make(map[string]Operation)}
+}
+
+type mapOperationStore struct {
+	sync.RWMutex
+	data map[string]Operation
+}
+
+func (s *mapOperationStore) Put(op *Operation) error {
+	s.Lock()
+	defer s.Unlock()
+	s.data[op.Id] = *op
+	return nil
+}
+
+func (s *mapOperationStore) Get(id string) (*Operation, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if op, ok := s.data[id]; !ok {
+		return nil, Error.ResourceNotFound(id, "")
+	} else {
+		return &op, nil
+	}
+}
+
+// Pool of workers that apply queued operations to the repository of their resource type
+type OperationWorkers struct {
+	Size       int
+	Queue      OperationQueue
+	Store      OperationStore
+	Repository func(resourceType string) Repository
+}
+
+// Run the workers until the context is done and the queue has no operation left for them. Blocks until all
+// workers have returned. Operations are applied to the end, unaffected by the context being done, so that
+// stopping the workers does not cut writes short.
+func (w *OperationWorkers) Run(ctx context.Context) {
+	size := w.Size
+	if size < 1 {
+		size = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(size)
+	for i := 0; i < size; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				op, err := w.Queue.Dequeue(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					continue
+				}
+				w.apply(op, Detach(ctx))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *OperationWorkers) apply(op *Operation, ctx context.Context) {
+	op.Status = OperationRunning
+	op.LastModified = time.Now()
+	w.Store.Put(op)
+
+	var err error
+	repo := w.Repository(op.ResourceType)
+	switch op.Kind {
+	case OperationCreate:
+		err = repo.Create(op.Resource, ctx)
+	case OperationUpdate:
+		err = repo.Update(op.ResourceId, op.Version, op.Resource, ctx)
+	case OperationDelete:
+		err = repo.Delete(op.ResourceId, op.Version, ctx)
+	default:
+		err = Error.Text("unknown operation kind %s", op.Kind)
+	}
+
+	if err != nil {
+		op.Status = OperationFailed
+		op.Error = err.Error()
+	} else {
+		op.Status = OperationSucceeded
+	}
+	op.LastModified = time.Now()
+	w.Store.Put(op)
+}
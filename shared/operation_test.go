@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOperationWorkers(t *testing.T) {
+	queue := NewChannelOperationQueue(10)
+	store := NewMapOperationStore()
+	repo := NewMapRepository(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	workers := &OperationWorkers{
+		Size:       1,
+		Queue:      queue,
+		Store:      store,
+		Repository: func(resourceType string) Repository { return repo },
+	}
+	done := make(chan struct{})
+	go func() {
+		workers.Run(ctx)
+		close(done)
+	}()
+
+	create := &Operation{
+		Kind:         OperationCreate,
+		ResourceType: UserResourceType,
+		Resource:     &Resource{Complex: Complex{"id": "foo"}},
+	}
+	require.Nil(t, SubmitOperation(create, queue, store))
+	assert.NotEmpty(t, create.Id)
+
+	deleteMissing := &Operation{
+		Kind:         OperationDelete,
+		ResourceType: UserResourceType,
+		ResourceId:   "bar",
+	}
+	require.Nil(t, SubmitOperation(deleteMissing, queue, store))
+
+	waitForStatus := func(id string) *Operation {
+		for i := 0; i < 100; i++ {
+			op, err := store.Get(id)
+			require.Nil(t, err)
+			if op.Status == OperationSucceeded || op.Status == OperationFailed {
+				return op
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("operation %s did not complete", id)
+		return nil
+	}
+
+	assert.Equal(t, OperationSucceeded, waitForStatus(create.Id).Status)
+	_, err := repo.Get("foo", "", context.Background())
+	assert.Nil(t, err)
+
+	failed := waitForStatus(deleteMissing.Id)
+	assert.Equal(t, OperationFailed, failed.Status)
+	assert.NotEmpty(t, failed.Error)
+
+	_, err = store.Get("unknown")
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+
+	cancel()
+	<-done
+}
+
+func TestOperationWorkers_Stopped(t *testing.T) {
+	queue := NewChannelOperationQueue(10)
+	store := NewMapOperationStore()
+	repo := NewMapRepository(nil)
+	for _, id := range []string{"a", "b", "c"} {
+		require.Nil(t, SubmitOperation(&Operation{
+			Kind:         OperationCreate,
+			ResourceType: UserResourceType,
+			Resource:     &Resource{Complex: Complex{"id": id}},
+		}, queue, store))
+	}
+
+	// the operations queued are applied before the workers return
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	(&OperationWorkers{
+		Size:       2,
+		Queue:      queue,
+		Store:      store,
+		Repository: func(resourceType string) Repository { return repo },
+	}).Run(ctx)
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := repo.Get(id, "", context.Background())
+		assert.Nil(t, err, id)
+	}
+}
+
+func TestChannelOperationQueue_Full(t *testing.T) {
+	queue := NewChannelOperationQueue(1)
+	store := NewMapOperationStore()
+	require.Nil(t, SubmitOperation(&Operation{Kind: OperationDelete, ResourceType: UserResourceType, ResourceId: "a"}, queue, store))
+
+	// the operation is dropped rather than holding up the request, and recorded as failed
+	dropped := &Operation{Kind: OperationDelete, ResourceType: UserResourceType, ResourceId: "b"}
+	err := SubmitOperation(dropped, queue, store)
+	assert.IsType(t, &RateLimitedError{}, err)
+	op, err := store.Get(dropped.Id)
+	require.Nil(t, err)
+	assert.Equal(t, OperationFailed, op.Status)
+}
@@ -0,0 +1,207 @@
+package shared
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Decides whether a password is too common to be accepted, i.e. by a list of breached passwords or by asking
+// a breach corpus service
+type BannedPasswords interface {
+	Banned(password string, ctx context.Context) (bool, error)
+}
+
+// Returns banned passwords from a fixed list, compared case insensitively
+func NewBannedPasswordList(passwords ...string) BannedPasswords {
+	list := make(bannedPasswordList, len(passwords))
+	for _, password := range passwords {
+		list[strings.ToLower(password)] = struct{}{}
+	}
+	return list
+}
+
+type bannedPasswordList map[string]struct{}
+
+func (l bannedPasswordList) Banned(password string, ctx context.Context) (bool, error) {
+	_, ok := l[strings.ToLower(password)]
+	return ok, nil
+}
+
+// Rules the passwords of users must satisfy. Character classes are lower case letters, upper case letters,
+// digits and everything else. Violations are reported as InvalidParamError on password, without the password.
+type PasswordPolicy struct {
+	MinLength           int             // in characters, no minimum if not positive
+	MaxLength           int             // in characters, no maximum if not positive
+	MinCharacterClasses int             // number of character classes the password must draw from
+	RejectUserName      bool            // reject passwords containing the userName, case insensitively
+	Banned              BannedPasswords // may be nil
+}
+
+// Validate the password of the user, which is the resource the password is set on
+func (p *PasswordPolicy) Validate(password string, user *Resource, ctx context.Context) error {
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		return Error.InvalidParam("password", fmt.Sprintf("at least %d characters", p.MinLength), fmt.Sprintf("%d characters", length))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return Error.InvalidParam("password", fmt.Sprintf("at most %d characters", p.MaxLength), fmt.Sprintf("%d characters", length))
+	}
+
+	if classes := characterClasses(password); classes < p.MinCharacterClasses {
+		return Error.InvalidParam("password", fmt.Sprintf("characters of %d classes", p.MinCharacterClasses), fmt.Sprintf("%d classes", classes))
+	}
+
+	if p.RejectUserName && user != nil {
+		if userName, ok := user.Complex["userName"].(string); ok && len(userName) > 0 &&
+			strings.Contains(strings.ToLower(password), strings.ToLower(userName)) {
+			return Error.InvalidParam("password", "a password not containing the userName", "one containing it")
+		}
+	}
+
+	if p.Banned != nil {
+		banned, err := p.Banned.Banned(password, ctx)
+		if err != nil {
+			return err
+		}
+		if banned {
+			return Error.InvalidParam("password", "a password not commonly used", "a banned password")
+		}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// Enforce the policy on users being created, replaced, patched or having their password changed, by
+// registering before hooks. Updates keeping the stored password are not checked, so that passwords set
+// before the policy was tightened stay valid until they change.
+func (p *PasswordPolicy) Register(hooks *Hooks) *Hooks {
+	return hooks.BeforeCreate(UserResourceType, func(resource *Resource, ctx context.Context) error {
+		if password, ok := resource.Complex["password"].(string); ok {
+			return p.Validate(password, resource, ctx)
+		}
+		return nil
+	}).BeforeUpdate(UserResourceType, func(resource *Resource, reference *Resource, ctx context.Context) error {
+		password, ok := resource.Complex["password"].(string)
+		if !ok {
+			return nil
+		}
+		if reference != nil {
+			// the stored hash, left alone by a patch, or the stored password given once more
+			if stored, _ := reference.Complex["password"].(string); stored == password || VerifyPassword(reference, password) {
+				return nil
+			}
+		}
+		return p.Validate(password, resource, ctx)
+	})
+}
+
+// Check the password against the one stored for the user, its bcrypt hash, in constant time. Passwords stored
+// before they were hashed are compared as they are, in constant time too, until they change. Users without a
+// password match no password.
+func VerifyPassword(user *Resource, password string) bool {
+	stored, ok := user.Complex["password"].(string)
+	if !ok || len(stored) == 0 {
+		return false
+	}
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// Hash the password with bcrypt. Passwords longer than the 72 bytes bcrypt takes are rejected as invalid, rather
+// than have the rest ignored.
+func HashPassword(password string) (string, error) {
+	if len(password) > 72 {
+		return "", Error.InvalidParam("password", "at most 72 bytes", fmt.Sprintf("%d bytes", len(password)))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func isPasswordHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+// Decorates a user repository so that passwords are stored as their bcrypt hash, see HashPassword. Passwords
+// that are hashed already, i.e. the stored one a patch leaves alone, are written as they are. The password of
+// the resource written is replaced by its hash, so that the decorators around this one, i.e. caches and
+// change events, never hold the password either: it is to be the innermost decorator.
+func NewPasswordHashingRepository(repo Repository) Repository {
+	return &passwordHashingRepository{Repository: repo}
+}
+
+type passwordHashingRepository struct {
+	Repository
+}
+
+func (r *passwordHashingRepository) Create(provider DataProvider, ctx context.Context) error {
+	if err := hashPasswordOf(provider); err != nil {
+		return err
+	}
+	return r.Repository.Create(provider, ctx)
+}
+
+func (r *passwordHashingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	if err := hashPasswordOf(provider); err != nil {
+		return err
+	}
+	return r.Repository.Update(id, version, provider, ctx)
+}
+
+func (r *passwordHashingRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	return memberPatcherOf(r.Repository).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *passwordHashingRepository) decorated() []Repository {
+	return []Repository{r.Repository}
+}
+
+func hashPasswordOf(provider DataProvider) error {
+	password, ok := provider.GetData()["password"].(string)
+	if !ok || len(password) == 0 || isPasswordHash(password) {
+		return nil
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	provider.GetData()["password"] = hash
+	return nil
+}
+
+// Verify the old password of the change against the user and return a copy of the user carrying the new
+// password. A wrong old password is reported as ForbiddenError on password.
+func ApplyPasswordChange(user DataProvider, change PasswordChange) (*Resource, error) {
+	changed := &Resource{Complex: Complex(deepCopy(map[string]interface{}(user.GetData())).(map[string]interface{}))}
+	if !VerifyPassword(changed, change.OldPassword) {
+		return nil, Error.Forbidden("password")
+	}
+	changed.Complex["password"] = change.NewPassword
+	return changed, nil
+}
@@ -0,0 +1,119 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:           8,
+		MaxLength:           64,
+		MinCharacterClasses: 3,
+		RejectUserName:      true,
+		Banned:              NewBannedPasswordList("Passw0rd!"),
+	}
+	user := &Resource{Complex: Complex{"userName": "alice"}}
+
+	for _, test := range []struct {
+		password string
+		valid    bool
+	}{
+		{"Tr0ub4dor&3", true},
+		{"Sh0rt!", false},
+		{"alllowercase", false},
+		{"lower and 2 spaces", true},
+		{"MyAlice-2017", false},
+		{"passw0rd!", false},
+	} {
+		err := policy.Validate(test.password, user, context.Background())
+		if test.valid {
+			assert.Nil(t, err, test.password)
+		} else {
+			assert.IsType(t, &InvalidParamError{}, err, test.password)
+		}
+	}
+}
+
+func TestPasswordPolicy_Register(t *testing.T) {
+	ctx := context.Background()
+	hooks := (&PasswordPolicy{MinLength: 8}).Register(NewHooks())
+
+	assert.Nil(t, hooks.RunCreate(true, UserResourceType, &Resource{Complex: Complex{"userName": "alice"}}, ctx))
+	assert.NotNil(t, hooks.RunCreate(true, UserResourceType, &Resource{Complex: Complex{"password": "short"}}, ctx))
+	assert.Nil(t, hooks.RunCreate(true, GroupResourceType, &Resource{Complex: Complex{"password": "short"}}, ctx))
+
+	// a password set before the policy is kept
+	reference := &Resource{Complex: Complex{"password": "short"}}
+	assert.Nil(t, hooks.RunUpdate(true, UserResourceType, &Resource{Complex: Complex{"password": "short"}}, reference, ctx))
+	assert.NotNil(t, hooks.RunUpdate(true, UserResourceType, &Resource{Complex: Complex{"password": "shorter"}}, reference, ctx))
+	assert.Nil(t, hooks.RunUpdate(true, UserResourceType, &Resource{Complex: Complex{"password": "long enough"}}, reference, ctx))
+	hash, err := HashPassword("short")
+	require.Nil(t, err)
+	reference = &Resource{Complex: Complex{"password": hash}}
+	assert.Nil(t, hooks.RunUpdate(true, UserResourceType, &Resource{Complex: Complex{"password": hash}}, reference, ctx))
+	assert.Nil(t, hooks.RunUpdate(true, UserResourceType, &Resource{Complex: Complex{"password": "short"}}, reference, ctx))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	assert.True(t, VerifyPassword(&Resource{Complex: Complex{"password": "secret"}}, "secret"))
+	assert.False(t, VerifyPassword(&Resource{Complex: Complex{"password": "secret"}}, "Secret"))
+	assert.False(t, VerifyPassword(&Resource{Complex: Complex{}}, ""))
+
+	hash, err := HashPassword("secret")
+	require.Nil(t, err)
+	assert.NotEqual(t, "secret", hash)
+	assert.True(t, VerifyPassword(&Resource{Complex: Complex{"password": hash}}, "secret"))
+	assert.False(t, VerifyPassword(&Resource{Complex: Complex{"password": hash}}, "Secret"))
+	assert.False(t, VerifyPassword(&Resource{Complex: Complex{"password": hash}}, hash))
+}
+
+func TestPasswordHashingRepository(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+	repo := NewPasswordHashingRepository(NewSearchableMapRepository(sch, map[string]DataProvider{}))
+	ctx := context.Background()
+
+	user := &Resource{Complex: Complex{"id": "u1", "userName": "david", "password": "secret"}}
+	require.Nil(t, repo.Create(user, ctx))
+	stored, err := repo.Get("u1", "", ctx)
+	require.Nil(t, err)
+	hash := stored.GetData()["password"].(string)
+	assert.True(t, VerifyPassword(stored.(*Resource), "secret"))
+	// the resource written carries the hash as well
+	assert.Equal(t, hash, user.Complex["password"])
+
+	// the hash a patch leaves in place is not hashed once more
+	require.Nil(t, repo.Update("u1", "", stored, ctx))
+	stored, err = repo.Get("u1", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, hash, stored.GetData()["password"])
+
+	tooLong := &Resource{Complex: Complex{"id": "u2", "userName": "mary", "password": strings.Repeat("x", 73)}}
+	assert.IsType(t, &InvalidParamError{}, repo.Create(tooLong, ctx))
+}
+
+func TestHooks_RunPasswordChange(t *testing.T) {
+	changed := make([]string, 0)
+	hooks := NewHooks().AfterPasswordChange(func(id string, ctx context.Context) error {
+		changed = append(changed, id)
+		return nil
+	})
+	require.Nil(t, hooks.RunPasswordChange("foo", context.Background()))
+	assert.Equal(t, []string{"foo"}, changed)
+}
+
+func TestApplyPasswordChange(t *testing.T) {
+	user := &Resource{Complex: Complex{"userName": "alice", "password": "old secret"}}
+
+	_, err := ApplyPasswordChange(user, PasswordChange{OldPassword: "wrong", NewPassword: "new secret"})
+	assert.IsType(t, &ForbiddenError{}, err)
+
+	changed, err := ApplyPasswordChange(user, PasswordChange{OldPassword: "old secret", NewPassword: "new secret"})
+	require.Nil(t, err)
+	assert.Equal(t, "new secret", changed.Complex["password"])
+	assert.Equal(t, "old secret", user.Complex["password"], "the user is left untouched")
+}
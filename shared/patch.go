@@ -5,6 +5,10 @@ import (
 	"reflect"
 )
 
+// Apply the patch operation to the resource. Values that leave an attribute unassigned, see IsUnassigned, clear
+// it whether they are added or replace it, and multi-valued attributes left without elements are removed, so
+// that null, "" and remove all leave the same state; see RemoveUnassigned. An element made primary by the
+// operation unsets the primary flag of the others, see EnforcePrimary.
 func ApplyPatch(patch Patch, subj *Resource, sch *Schema, ctx context.Context) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -23,17 +27,10 @@ func ApplyPatch(patch Patch, subj *Resource, sch *Schema, ctx context.Context) (
 	if len(patch.Path) == 0 {
 		path = nil
 	} else {
-		path, err = NewPath(patch.Path)
+		path, ps.destAttr, err = CompilePath(patch.Path, sch)
 		if err != nil {
 			return err
 		}
-		path.CorrectCase(sch, true)
-
-		if attr := sch.GetAttribute(path, true); attr != nil {
-			ps.destAttr = attr
-		} else {
-			return Error.InvalidPath(patch.Path, "no attribute found for path")
-		}
 	}
 
 	v := reflect.ValueOf(patch.Value)
@@ -42,15 +39,23 @@ func ApplyPatch(patch Patch, subj *Resource, sch *Schema, ctx context.Context) (
 	}
 
 	switch patch.Op {
-	case Add:
-		ps.applyPatchAdd(path, v, subj)
-	case Replace:
-		ps.applyPatchReplace(path, v, subj)
+	case Add, Replace:
+		before := &Resource{Complex: deepCopy(subj.Complex).(map[string]interface{})}
+		if patch.Op == Add {
+			ps.applyPatchAdd(path, v, subj)
+		} else {
+			ps.applyPatchReplace(path, v, subj)
+		}
+		ps.throw(EnforcePrimary(subj, before, sch), ctx)
 	case Remove:
+		if path == nil {
+			return Error.InvalidParam("path of remove op", "to be present", "none")
+		}
 		ps.applyPatchRemove(path, subj)
 	default:
-		err = Error.InvalidParam("Op", "one of [add|remove|replace]", patch.Op)
+		return Error.InvalidParam("Op", "one of [add|remove|replace]", patch.Op)
 	}
+	RemoveUnassigned(subj.Complex)
 	return
 }
 
@@ -142,6 +147,22 @@ func (ps *patchState) applyPatchRemove(p Path, subj *Resource) {
 }
 
 func (ps *patchState) applyPatchReplace(p Path, v reflect.Value, subj *Resource) {
+	if p == nil {
+		if v.Kind() != reflect.Map {
+			ps.throw(Error.InvalidParam("value of replace op", "to be complex (for implicit path)", "non-complex"), ps.ctx)
+		}
+		for _, k := range v.MapKeys() {
+			if err := ApplyPatch(Patch{
+				Op:    Replace,
+				Path:  k.String(),
+				Value: v.MapIndex(k).Interface(),
+			}, subj, ps.sch, ps.ctx); err != nil {
+				ps.throw(err, ps.ctx)
+			}
+		}
+		return
+	}
+
 	basePath, lastPath := p.SeparateAtLast()
 	baseChannel := make(chan interface{}, 1)
 	if basePath == nil {
@@ -207,6 +228,10 @@ func (ps *patchState) applyPatchAdd(p Path, v reflect.Value, subj *Resource) {
 				keyVal := reflect.ValueOf(lastPath.Base())
 				if ps.destAttr.MultiValued {
 					origVal := baseVal.MapIndex(keyVal)
+					if !v.IsValid() {
+						// adding null adds no element
+						continue
+					}
 					if !origVal.IsValid() {
 						switch v.Kind() {
 						case reflect.Array, reflect.Slice:
@@ -219,16 +244,16 @@ func (ps *patchState) applyPatchAdd(p Path, v reflect.Value, subj *Resource) {
 						if origVal.Kind() == reflect.Interface {
 							origVal = origVal.Elem()
 						}
-						var newArr MultiValued
+						newArr := MultiValued(origVal.Interface().([]interface{}))
 						switch v.Kind() {
 						case reflect.Array, reflect.Slice:
 							for i := 0; i < v.Len(); i++ {
-								newArr = MultiValued(origVal.Interface().([]interface{})).Add(v.Index(i).Interface())
+								newArr = newArr.Add(v.Index(i).Interface())
 							}
 						default:
-							newArr = MultiValued(origVal.Interface().([]interface{})).Add(v.Interface())
+							newArr = newArr.Add(v.Interface())
 						}
-						baseVal.SetMapIndex(keyVal, reflect.ValueOf(newArr))
+						baseVal.SetMapIndex(keyVal, reflect.ValueOf([]interface{}(newArr)))
 					}
 				} else {
 					baseVal.SetMapIndex(keyVal, v)
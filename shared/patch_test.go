@@ -90,6 +90,37 @@ func TestApplyPatch(t *testing.T) {
 				assert.Equal(t, "foo", r.GetData()["userName"])
 			},
 		},
+		{
+			// replace: schema urn prefix
+			Patch{Op: Replace, Path: "urn:ietf:params:scim:schemas:core:2.0:User:name.familyName", Value: "foo"},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "foo", r.GetData()["name"].(map[string]interface{})["familyName"])
+			},
+		},
+		{
+			// replace: attribute names in any case
+			Patch{Op: Replace, Path: "Emails[TYPE eq \"work\"].Value", Value: "foo@bar.com"},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				emails := r.GetData()["emails"].([]interface{})
+				for _, email := range emails {
+					email := email.(map[string]interface{})
+					if email["type"] == "work" {
+						assert.Equal(t, "foo@bar.com", email["value"])
+					} else {
+						assert.NotEqual(t, "foo@bar.com", email["value"])
+					}
+				}
+			},
+		},
+		{
+			// replace: undefined attribute
+			Patch{Op: Replace, Path: "name.nickName", Value: "foo"},
+			func(r *Resource, err error) {
+				assert.IsType(t, &InvalidPathError{}, err)
+			},
+		},
 		{
 			// replace: duplex path
 			Patch{Op: Replace, Path: "name.familyName", Value: "foo"},
@@ -199,3 +230,67 @@ func TestApplyPatch(t *testing.T) {
 		test.assertion(r, err)
 	}
 }
+
+func TestApplyPatch_Unassigned(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	// every way of clearing an attribute leaves the same state
+	for _, patch := range []Patch{
+		{Op: Remove, Path: "nickName"},
+		{Op: Replace, Path: "nickName", Value: nil},
+		{Op: Replace, Path: "nickName", Value: ""},
+		{Op: Add, Path: "nickName", Value: ""},
+		{Op: Replace, Value: map[string]interface{}{"nickName": nil}},
+	} {
+		r, _, err := ParseResource("../resources/tests/user_1.json")
+		require.Nil(t, err)
+		require.Nil(t, ApplyPatch(patch, r, sch, context.Background()), "%+v", patch)
+		assert.NotContains(t, r.Complex, "nickName", "%+v", patch)
+		// empty strings stored before are gone as well
+		assert.NotContains(t, r.Complex["name"], "middleName", "%+v", patch)
+	}
+
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+	ctx := context.Background()
+	require.Nil(t, ApplyPatch(Patch{Op: Replace, Path: "emails", Value: []interface{}{}}, r, sch, ctx))
+	assert.NotContains(t, r.Complex, "emails")
+	require.Nil(t, ApplyPatch(Patch{Op: Add, Path: "emails", Value: nil}, r, sch, ctx))
+	assert.NotContains(t, r.Complex, "emails")
+	require.Nil(t, ApplyPatch(Patch{Op: Replace, Path: "name", Value: map[string]interface{}{"givenName": ""}}, r, sch, ctx))
+	assert.NotContains(t, r.Complex, "name")
+	require.Nil(t, ApplyPatch(Patch{Op: Replace, Value: map[string]interface{}{"displayName": "Dave"}}, r, sch, ctx))
+	assert.Equal(t, "Dave", r.Complex["displayName"])
+
+	assert.NotNil(t, ApplyPatch(Patch{Op: Remove}, r, sch, ctx))
+}
+
+func BenchmarkApplyPatch(b *testing.B) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(b, err)
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(b, err)
+	ctx := context.Background()
+	for _, bench := range []struct {
+		name  string
+		patch Patch
+	}{
+		{"AddSimple", Patch{Op: Add, Path: "userName", Value: "foo"}},
+		{"ReplaceFiltered", Patch{Op: Replace, Path: "emails[type eq \"work\"].value", Value: "foo@bar.com"}},
+		{"AddImplicit", Patch{Op: Add, Value: map[string]interface{}{"userName": "foo", "externalId": "bar"}}},
+		{"RemoveFiltered", Patch{Op: Remove, Path: "emails[type eq \"work\"]"}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				subj := r.DeepCopy()
+				b.StartTimer()
+				if err := ApplyPatch(bench.patch, subj, sch, ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
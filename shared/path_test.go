@@ -279,6 +279,14 @@ func TestNewFilter(t *testing.T) {
 				assert.Nil(t, root.Right())
 			},
 		},
+		{
+			// unknown operator
+			"username xx \"david\"",
+			func(root FilterNode, err error) {
+				assert.Nil(t, root)
+				assert.IsType(t, &InvalidFilterError{}, err)
+			},
+		},
 	} {
 		test.assertion(NewFilter(test.text))
 	}
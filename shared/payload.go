@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // ----------------------------------
@@ -89,7 +90,7 @@ type BulkRespOp struct {
 	Status   int             `json:"status"`
 }
 
-func (bro BulkRespOp) Populate(origReq BulkReqOp, resp WebResponse) {
+func (bro *BulkRespOp) Populate(origReq BulkReqOp, resp WebResponse) {
 	bro.Method = strings.ToLower(origReq.Method)
 	bro.BulkId = origReq.BulkId
 	bro.Version = resp.GetHeader("ETag")
@@ -123,7 +124,7 @@ type Patch struct {
 }
 
 func (m Modification) Validate() error {
-	if len(m.Schemas) != 1 && m.Schemas[0] != PatchOpUrn {
+	if len(m.Schemas) != 1 || m.Schemas[0] != PatchOpUrn {
 		return Error.InvalidParam("schemas", PatchOpUrn, fmt.Sprintf("%+v", m.Schemas))
 	}
 
@@ -145,7 +146,9 @@ func (m Modification) Validate() error {
 			if patch.Value == nil {
 				return Error.InvalidParam("value of replace op", "to be present", "nil")
 			} else if len(patch.Path) == 0 {
-				return Error.InvalidParam("path", "to be present", "empty")
+				if _, ok := patch.Value.(map[string]interface{}); !ok {
+					return Error.InvalidParam("value of replace op", "to be complex (for implicit path)", "non-complex")
+				}
 			}
 		case Remove:
 			if patch.Value != nil {
@@ -157,6 +160,13 @@ func (m Modification) Validate() error {
 		default:
 			return Error.InvalidParam("Op", "one of [add|remove|replace]", patch.Op)
 		}
+
+		// the path is resolved against the schema when the patch is applied
+		if len(patch.Path) > 0 {
+			if _, err := ParseAttributePath(patch.Path); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -171,6 +181,18 @@ type ListResponse struct {
 	ItemsPerPage int
 	StartIndex   int
 	Resources    []DataProvider
+	Partial      bool // the search timed out, and Resources and TotalResults may fall short of all matches
+
+	// the watermark of the ChangeLog to ask for later changes with, none if empty
+	Watermark string
+	// resources deleted since the watermark a delta query asked for
+	Deleted []Tombstone
+}
+
+// A resource deleted, listed by the response to a delta query
+type Tombstone struct {
+	Id      string    `json:"id"`
+	Deleted time.Time `json:"deleted"`
 }
 
 type listResponseMarshalHelper struct {
@@ -185,7 +207,10 @@ func (h *listResponseMarshalHelper) MarshalJSON() ([]byte, error) {
 		if i > 0 {
 			buf.WriteString(",")
 		}
-		b, err := MarshalJSON(dp, h.Guide, h.Attributes, h.ExcludedAttributes)
+		if len(h.ItemSchemas) > 0 {
+			dp = withResourceTypeSchema(dp, h.ItemSchemas)
+		}
+		b, err := MarshalJSON(dp, h.Guide, h.Attributes, h.ExcludedAttributes, h.Options...)
 		if err != nil {
 			return nil, err
 		}
@@ -193,22 +218,109 @@ func (h *listResponseMarshalHelper) MarshalJSON() ([]byte, error) {
 	}
 	buf.WriteString("]")
 
+	// itemsPerPage is the size of the page actually returned, which may fall short of the count asked for
+	schemas := h.Data.Schemas
+	if len(schemas) == 0 {
+		schemas = []string{ListResponseUrn}
+	}
+	startIndex := h.Data.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+
+	var partial *partialListResponse
+	if h.Data.Partial {
+		schemas = append(append([]string{}, schemas...), PartialListResponseUrn)
+		partial = &partialListResponse{Partial: true, Reason: "timeout"}
+	}
+
+	var delta *deltaListResponse
+	if len(h.Data.Watermark) > 0 {
+		schemas = append(append([]string{}, schemas...), DeltaListResponseUrn)
+		delta = &deltaListResponse{Watermark: h.Data.Watermark, Deleted: h.Data.Deleted}
+		if delta.Deleted == nil {
+			delta.Deleted = []Tombstone{}
+		}
+	}
+
 	raw := json.RawMessage(buf.Bytes())
 	return json.Marshal(struct {
-		Schemas      []string         `json:"schemas"`
-		TotalResults int              `json:"totalResults"`
-		ItemsPerPage int              `json:"itemsPerPage"`
-		StartIndex   int              `json:"startIndex"`
-		Resources    *json.RawMessage `json:"Resources"`
+		Schemas      []string             `json:"schemas"`
+		TotalResults int                  `json:"totalResults"`
+		ItemsPerPage int                  `json:"itemsPerPage"`
+		StartIndex   int                  `json:"startIndex"`
+		Resources    *json.RawMessage     `json:"Resources"`
+		Partial      *partialListResponse `json:"urn:go-scim:params:scim:api:messages:2.0:PartialListResponse,omitempty"`
+		Delta        *deltaListResponse   `json:"urn:go-scim:params:scim:api:messages:2.0:DeltaListResponse,omitempty"`
 	}{
-		Schemas:      h.Data.Schemas,
+		Schemas:      schemas,
 		TotalResults: h.Data.TotalResults,
-		ItemsPerPage: h.Data.ItemsPerPage,
-		StartIndex:   h.Data.StartIndex,
+		ItemsPerPage: len(h.Data.Resources),
+		StartIndex:   startIndex,
 		Resources:    &raw,
+		Partial:      partial,
+		Delta:        delta,
 	})
 }
 
+// the body of the DeltaListResponseUrn extension
+type deltaListResponse struct {
+	Watermark string      `json:"watermark"`
+	Deleted   []Tombstone `json:"deleted"`
+}
+
+// the body of the PartialListResponseUrn extension
+type partialListResponse struct {
+	Partial bool   `json:"partial"`
+	Reason  string `json:"reason"`
+}
+
+// The schema by name of the resource types, i.e. those listed at /ResourceTypes, for ItemSchemas
+func ResourceTypeSchemas(resourceTypes ...DataProvider) map[string]string {
+	schemas := make(map[string]string, len(resourceTypes))
+	for _, rt := range resourceTypes {
+		name, _ := rt.GetData()["name"].(string)
+		schema, _ := rt.GetData()["schema"].(string)
+		if len(name) > 0 && len(schema) > 0 {
+			schemas[name] = schema
+		}
+	}
+	return schemas
+}
+
+// a shallow copy of the resource with the schema of its resource type added to schemas, or the resource
+// itself when it lists the schema already or its resource type is unknown
+func withResourceTypeSchema(dp DataProvider, resourceTypeSchemas map[string]string) DataProvider {
+	data := dp.GetData()
+	meta, _ := data["meta"].(map[string]interface{})
+	resourceType, _ := meta["resourceType"].(string)
+	urn, ok := resourceTypeSchemas[resourceType]
+	if !ok {
+		return dp
+	}
+	schemas := make([]interface{}, 0)
+	switch v := data["schemas"].(type) {
+	case []interface{}:
+		schemas = append(schemas, v...)
+	case []string:
+		for _, schema := range v {
+			schemas = append(schemas, schema)
+		}
+	}
+	for _, schema := range schemas {
+		if schema == urn {
+			return dp
+		}
+	}
+
+	copied := make(Complex, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+	copied["schemas"] = append(schemas, urn)
+	return &Resource{Complex: copied}
+}
+
 // ----------------------------------
 // Search Request
 // ----------------------------------
@@ -231,7 +343,10 @@ func (sr SearchRequest) Ascending() bool {
 		return false
 	}
 }
-func (sr SearchRequest) Validate(guide AttributeSource) error {
+
+// Validate the search request, normalizing the paging parameters, checking the syntax of the filter and
+// resolving sortBy, attributes and excludedAttributes against the schema when one is given.
+func (sr *SearchRequest) Validate(guide *Schema) error {
 	if len(sr.Schemas) != 1 || sr.Schemas[0] != SearchUrn {
 		return Error.InvalidParam("search request", "search operation urn", "non-search urn")
 	}
@@ -240,6 +355,11 @@ func (sr SearchRequest) Validate(guide AttributeSource) error {
 		return Error.InvalidParam("search request", "query string", "empty string")
 	}
 
+	// fail with invalidFilter here, rather than with whatever error the repository reports
+	if _, err := NewFilter(sr.Filter); err != nil {
+		return err
+	}
+
 	if sr.StartIndex < 1 {
 		sr.StartIndex = 1
 	}
@@ -254,62 +374,77 @@ func (sr SearchRequest) Validate(guide AttributeSource) error {
 		return Error.InvalidParam("search request", "[as|des]cending or blank for sortOrder", sr.SortOrder)
 	}
 
-	if guide != nil {
-		if len(sr.SortBy) > 0 {
-			if corrected, err := sr.correctPathCase(sr.SortBy, guide); err != nil {
-				return err
-			} else {
-				sr.SortBy = corrected
-			}
+	if guide != nil && len(sr.SortBy) > 0 {
+		if corrected, err := sr.correctPathCase(sr.SortBy, guide); err != nil {
+			return err
+		} else {
+			sr.SortBy = corrected
 		}
+	}
 
-		if len(sr.Attributes) > 0 {
-			updated := make([]string, 0)
-			for _, each := range sr.Attributes {
-				if len(each) > 0 {
-					if corrected, err := sr.correctPathCase(each, guide); err != nil {
-						return err
-					} else {
-						updated = append(updated, corrected)
-					}
-				}
-			}
-			sr.Attributes = updated
-		}
+	return sr.ValidateAttributes(guide)
+}
 
-		if len(sr.ExcludedAttributes) > 0 {
-			updated := make([]string, 0)
-			for _, each := range sr.Attributes {
-				if len(each) > 0 {
-					if corrected, err := sr.correctPathCase(each, guide); err != nil {
-						return err
-					} else {
-						updated = append(updated, corrected)
-					}
+// Resolve attributes and excludedAttributes against the schema when one is given, dropping empty entries.
+// Attributes takes precedence: once it overrides the default set of attributes returned, excludedAttributes
+// has nothing left to exclude from, so it is dropped.
+func (sr *SearchRequest) ValidateAttributes(guide *Schema) error {
+	correct := func(paths []string) ([]string, error) {
+		updated := make([]string, 0)
+		for _, each := range paths {
+			each = strings.TrimSpace(each)
+			if len(each) == 0 {
+				continue
+			}
+			if guide != nil {
+				corrected, err := sr.correctPathCase(each, guide)
+				if err != nil {
+					return nil, err
 				}
+				each = corrected
 			}
-			sr.ExcludedAttributes = updated
+			updated = append(updated, each)
 		}
+		return updated, nil
 	}
 
+	var err error
+	if sr.Attributes, err = correct(sr.Attributes); err != nil {
+		return err
+	}
+	if sr.ExcludedAttributes, err = correct(sr.ExcludedAttributes); err != nil {
+		return err
+	}
+	if len(sr.Attributes) > 0 {
+		sr.ExcludedAttributes = []string{}
+	}
 	return nil
 }
-func (sr SearchRequest) correctPathCase(text string, guide AttributeSource) (string, error) {
-	p, err := NewPath(text)
+
+// resolve the path against the schema and render it in canonical form, see CompilePath
+func (sr *SearchRequest) correctPathCase(text string, guide *Schema) (string, error) {
+	ap, err := ParseAttributePath(text)
 	if err != nil {
 		return "", err
 	}
-	p.CorrectCase(guide, true)
-	buf := new(bytes.Buffer)
-	i := 0
-	for p != nil {
-		if i > 0 {
-			buf.WriteString(".")
+	if _, err := ap.Resolve(guide); err != nil {
+		return "", err
+	}
+	return ap.String(), nil
+}
 
-		}
-		buf.WriteString(p.Base())
-		p = p.Next()
-		i++
+// ----------------------------------
+// Password Change
+// ----------------------------------
+// The body of a password change, see ChangeUserPasswordHandler
+type PasswordChange struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+func (pc PasswordChange) Validate() error {
+	if len(pc.NewPassword) == 0 {
+		return Error.MissingRequiredProperty("newPassword")
 	}
-	return buf.String(), nil
+	return nil
 }
@@ -0,0 +1,105 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSearchRequest_Validate(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	sr := SearchRequest{
+		Schemas:            []string{SearchUrn},
+		Filter:             `USERNAME eq "david"`,
+		SortBy:             "NAME.familyName",
+		Attributes:         []string{"", " userName", "Emails.value"},
+		ExcludedAttributes: []string{"displayName"},
+		StartIndex:         -1,
+		Count:              -1,
+	}
+	require.Nil(t, sr.Validate(sch))
+	assert.Equal(t, "name.familyName", sr.SortBy)
+	assert.Equal(t, []string{"userName", "emails.value"}, sr.Attributes)
+	assert.Empty(t, sr.ExcludedAttributes, "attributes takes precedence")
+	assert.Equal(t, 1, sr.StartIndex)
+	assert.Equal(t, 0, sr.Count)
+
+	for _, test := range []struct {
+		name   string
+		modify func(sr *SearchRequest)
+		assert func(t *testing.T, err error)
+	}{
+		{
+			"malformed filter",
+			func(sr *SearchRequest) { sr.Filter = `userName eq` },
+			func(t *testing.T, err error) { assert.IsType(t, &InvalidFilterError{}, err) },
+		},
+		{
+			"unknown sortBy",
+			func(sr *SearchRequest) { sr.SortBy = "unknown" },
+			func(t *testing.T, err error) { assert.NotNil(t, err) },
+		},
+		{
+			"sortOrder",
+			func(sr *SearchRequest) { sr.SortOrder = "up" },
+			func(t *testing.T, err error) { assert.IsType(t, &InvalidParamError{}, err) },
+		},
+		{
+			"unknown excludedAttributes",
+			func(sr *SearchRequest) { sr.ExcludedAttributes = []string{"unknown"} },
+			func(t *testing.T, err error) { assert.NotNil(t, err) },
+		},
+	} {
+		sr := SearchRequest{Schemas: []string{SearchUrn}, Filter: `userName eq "david"`}
+		test.modify(&sr)
+		t.Run(test.name, func(t *testing.T) {
+			test.assert(t, sr.Validate(sch))
+		})
+	}
+}
+
+func TestSearchRequest_ValidateAttributes(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	sr := SearchRequest{Attributes: []string{""}, ExcludedAttributes: []string{"DisplayName", ""}}
+	require.Nil(t, sr.ValidateAttributes(sch))
+	assert.Empty(t, sr.Attributes)
+	assert.Equal(t, []string{"displayName"}, sr.ExcludedAttributes)
+
+	sr = SearchRequest{Attributes: []string{"name.unknown"}}
+	assert.NotNil(t, sr.ValidateAttributes(sch))
+}
+
+func TestModification_Validate(t *testing.T) {
+	ops := []Patch{{Op: Replace, Path: "userName", Value: "david"}}
+	require.Nil(t, Modification{Schemas: []string{PatchOpUrn}, Ops: ops}.Validate())
+
+	// any schemas but the PatchOp one alone are rejected, none without failing on the missing first one
+	for _, schemas := range [][]string{nil, {}, {UserUrn}, {PatchOpUrn, UserUrn}, {UserUrn, PatchOpUrn}} {
+		err := Modification{Schemas: schemas, Ops: ops}.Validate()
+		assert.IsType(t, &InvalidParamError{}, err, "%v", schemas)
+	}
+}
+
+func TestModification_Validate_ImplicitReplace(t *testing.T) {
+	// a replace without a path replaces the attributes of its complex value, as an add without one adds them
+	for _, test := range []struct {
+		value interface{}
+		valid bool
+	}{
+		{map[string]interface{}{"displayName": "Dave"}, true},
+		{"Dave", false},
+		{[]interface{}{map[string]interface{}{"displayName": "Dave"}}, false},
+		{nil, false},
+	} {
+		err := Modification{Schemas: []string{PatchOpUrn}, Ops: []Patch{{Op: Replace, Value: test.value}}}.Validate()
+		if test.valid {
+			assert.Nil(t, err, "%v", test.value)
+		} else {
+			assert.IsType(t, &InvalidParamError{}, err, "%v", test.value)
+		}
+	}
+}
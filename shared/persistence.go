@@ -1,82 +1,431 @@
 package shared
 
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
 type DataProvider interface {
 	GetId() string
 	GetData() Complex
 }
 
+// Every method receives the context of the request it serves; implementations should give up
+// and return the context error once it is done.
 type Repository interface {
-	Create(provider DataProvider) error
+	Create(provider DataProvider, ctx context.Context) error
+
+	Get(id, version string, ctx context.Context) (DataProvider, error)
+
+	GetAll(ctx context.Context) ([]Complex, error)
+
+	Count(query string, ctx context.Context) (int, error)
 
-	Get(id, version string) (DataProvider, error)
+	Update(id, version string, provider DataProvider, ctx context.Context) error
 
-	GetAll() ([]Complex, error)
+	Delete(id, version string, ctx context.Context) error
 
-	Count(query string) (int, error)
+	Search(payload SearchRequest, ctx context.Context) (*ListResponse, error)
 
-	Update(id, version string, provider DataProvider) error
+	// Check that the underlying database can be reached, for readiness probes
+	Ping(ctx context.Context) error
+}
+
+// Optionally implemented by repositories that can return a page of a multiValued attribute,
+// i.e. the members of a group, without loading the attribute in full.
+// startIndex is 1-based; total is the number of values the attribute holds.
+type AttributeSlicer interface {
+	GetSlice(id, attribute string, startIndex, count int, ctx context.Context) (values []interface{}, total int, err error)
+}
 
-	Delete(id, version string) error
+// Return a page of the multiValued attribute of a resource, using the repository's AttributeSlicer
+// if it has one, or by slicing the full resource in memory otherwise.
+func SliceAttribute(repo Repository, id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	if slicer, ok := repo.(AttributeSlicer); ok {
+		return slicer.GetSlice(id, attribute, startIndex, count, ctx)
+	}
 
-	Search(payload SearchRequest) (*ListResponse, error)
+	dp, err := repo.Get(id, "", ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	all, _ := dp.GetData()[attribute].([]interface{})
+	return SliceValues(all, startIndex, count), len(all), nil
+}
+
+// Optionally implemented by repositories that tell the order their Search returns resources in, so that the
+// pages of several repositories can be merged in the order each of them sorts, see NewShardedRepository. The
+// comparison, -1, 0 or 1, ranks resources the way they follow each other in a page sorted by sortBy in the
+// direction, ties included.
+type SearchOrderer interface {
+	SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error)
+}
+
+// The order the Search of the repository returns resources sorted by sortBy in, told by its SearchOrderer when
+// it and all repositories it decorates have one, or that of the map repository of the schema otherwise
+func SearchOrder(repo Repository, sch *Schema, sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	if supports(repo, func(r Repository) bool { _, ok := r.(SearchOrderer); return ok }) {
+		return repo.(SearchOrderer).SearchOrder(sortBy, ascending)
+	}
+	return (&mapRepository{schema: sch}).SearchOrder(sortBy, ascending)
+}
+
+// Implemented by repositories decorating others, so that an optional interface a decorator forwards is only
+// taken for supported when the repositories it decorates support it as well
+type repositoryDecorator interface {
+	decorated() []Repository
+}
+
+// whether the repository implements an optional interface, and so do all repositories it decorates, which
+// decorators forward the calls of the interface to
+func supports(repo Repository, implements func(Repository) bool) bool {
+	if !implements(repo) {
+		return false
+	}
+	if decorator, ok := repo.(repositoryDecorator); ok {
+		for _, inner := range decorator.decorated() {
+			if !supports(inner, implements) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Optionally implemented by repositories that can tell whether resources exist without loading them, i.e.
+// for conditional GETs, instead of counting the matches of a filter built from the id.
+type ExistenceChecker interface {
+	// Whether the resource of the id exists, at the version unless it is empty
+	Exists(id, version string, ctx context.Context) (bool, error)
+	// The number of the ids, duplicates counted once, a resource exists for
+	CountByIds(ids []string, ctx context.Context) (int, error)
+}
+
+// Tell whether the resource of the id exists, at the version unless it is empty, using the repository's
+// ExistenceChecker if it has one, or by getting the resource otherwise.
+func ResourceExists(repo Repository, id, version string, ctx context.Context) (bool, error) {
+	if checker, ok := repo.(ExistenceChecker); ok {
+		return checker.Exists(id, version, ctx)
+	}
+
+	dp, err := repo.Get(id, "", ctx)
+	if err != nil {
+		return missing(err)
+	}
+	return len(version) == 0 || resourceVersion(dp.GetData()) == version, nil
+}
+
+// Count the ids, duplicates counted once, a resource exists for, using the repository's ExistenceChecker if
+// it has one, or by getting the resources one by one otherwise.
+func CountExisting(repo Repository, ids []string, ctx context.Context) (int, error) {
+	if checker, ok := repo.(ExistenceChecker); ok {
+		return checker.CountByIds(ids, ctx)
+	}
+
+	count := 0
+	for _, id := range distinct(ids) {
+		exists, err := ResourceExists(repo, id, "", ctx)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// false for the errors of resources that do not exist, the error otherwise
+func missing(err error) (bool, error) {
+	switch err.(type) {
+	case *ResourceNotFoundError, *GoneError:
+		return false, nil
+	}
+	return false, err
+}
+
+// the ids without duplicates, in the order of their first occurrence
+func distinct(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// Return the page of values starting at the 1-based startIndex
+func SliceValues(all []interface{}, startIndex, count int) []interface{} {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if startIndex > len(all) || count <= 0 {
+		return []interface{}{}
+	}
+	end := startIndex - 1 + count
+	if end > len(all) {
+		end = len(all)
+	}
+	return all[startIndex-1 : end]
 }
 
 // An simple in memory database fit for test use and read only production use
 // this implementation:
-// - only implements Create, Get, GetAll, Update, Delete
-// - is not thread safe
-// - ignores the version argument
+// - only implements Count and Search when constructed with a schema to evaluate filters against
+// - is safe for concurrent use, reads sharing a lock that writes hold alone
+// - keeps and hands out copies of Resource, like a database does, and other data providers as they are
+// - fails reads and writes of other versions than the stored one with a ResourceNotFoundError, like databases
 type mapRepository struct {
-	data map[string]DataProvider
+	mu      sync.RWMutex
+	data    map[string]DataProvider
+	schema  *Schema
+	filters *FilterCache
 }
 
-func (r *mapRepository) Create(provider DataProvider) error {
-	r.data[provider.GetId()] = provider
-	return nil
+// a copy of a Resource, so that changing it leaves the stored one alone; other data providers, i.e.
+// CompactResource, are kept as given
+func (r *mapRepository) copy(dp DataProvider) DataProvider {
+	if resource, ok := dp.(*Resource); ok {
+		return resource.DeepCopy()
+	}
+	return dp
 }
 
-func (r *mapRepository) Get(id, version string) (DataProvider, error) {
-	if dp, ok := r.data[id]; !ok {
+// the stored resource of the id, at the version unless it is empty, to be called holding the lock
+func (r *mapRepository) stored(id, version string) (DataProvider, error) {
+	dp, ok := r.data[id]
+	if !ok || (len(version) > 0 && resourceVersion(dp.GetData()) != version) {
 		return nil, Error.ResourceNotFound(id, version)
-	} else {
-		return dp, nil
 	}
+	return dp, nil
+}
+
+func (r *mapRepository) Create(provider DataProvider, ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data[provider.GetId()] = r.copy(provider)
+	return nil
+}
+
+func (r *mapRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	dp, err := r.stored(id, version)
+	if err != nil {
+		return nil, err
+	}
+	return r.copy(dp), nil
 }
 
-func (r *mapRepository) GetAll() ([]Complex, error) {
+func (r *mapRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	dp, ok := r.data[id]
+	return ok && (len(version) == 0 || resourceVersion(dp.GetData()) == version), nil
+}
+
+func (r *mapRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	count := 0
+	for _, id := range distinct(ids) {
+		if _, ok := r.data[id]; ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *mapRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	all := make([]Complex, 0)
 	for _, v := range r.data {
-		all = append(all, v.GetData())
+		all = append(all, r.copy(v).GetData())
 	}
 	return all, nil
 }
 
-func (r *mapRepository) Count(query string) (int, error) {
-	return 0, Error.Text("not implemented")
+func (r *mapRepository) Count(query string, ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matches, err := r.filter(query)
+	if err != nil {
+		return 0, err
+	}
+	return len(matches), nil
 }
 
-func (r *mapRepository) Update(id, version string, provider DataProvider) error {
-	if _, ok := r.data[id]; !ok {
-		return Error.ResourceNotFound(id, version)
-	} else {
-		r.data[id] = provider
-		return nil
+// the stored resources matching the query, to be called holding the lock
+func (r *mapRepository) filter(query string) ([]DataProvider, error) {
+	if r.schema == nil {
+		return nil, Error.Text("not implemented")
 	}
+
+	var root FilterNode
+	if len(query) > 0 {
+		var err error
+		if root, err = r.filters.Compile(query, r.schema); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]DataProvider, 0)
+	for _, dp := range r.data {
+		if root == nil || dp.GetData().Evaluate(root, r.schema) {
+			matches = append(matches, dp)
+		}
+	}
+	return matches, nil
 }
 
-func (r *mapRepository) Delete(id, version string) error {
-	if _, ok := r.data[id]; !ok {
-		return Error.ResourceNotFound(id, version)
-	} else {
-		delete(r.data, id)
-		return nil
+func (r *mapRepository) UseFilterCache(cache *FilterCache) {
+	r.filters = cache
+}
+
+func (r *mapRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.stored(id, version); err != nil {
+		return err
+	}
+	r.data[id] = r.copy(provider)
+	return nil
+}
+
+func (r *mapRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dp, err := r.stored(id, version)
+	if err != nil {
+		return err
+	}
+	data := dp.GetData()
+	existing, _ := data["members"].([]interface{})
+	data["members"] = MembershipDelta{Add: adds, Remove: removes}.Apply(existing)
+
+	if len(meta) > 0 {
+		m, ok := data["meta"].(map[string]interface{})
+		if !ok {
+			m = make(map[string]interface{})
+			data["meta"] = m
+		}
+		for k, v := range meta {
+			m[k] = v
+		}
 	}
+	return nil
 }
 
-func (r *mapRepository) Search(payload SearchRequest) (*ListResponse, error) {
-	return nil, Error.Text("not implemented")
+func (r *mapRepository) Delete(id, version string, ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.stored(id, version); err != nil {
+		return err
+	}
+	delete(r.data, id)
+	return nil
 }
 
+func (r *mapRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Search evaluates the filter against every resource. totalResults is the number of all matches, while
+// only the requested page is returned; count=0 returns no resources at all.
+func (r *mapRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matches, err := r.filter(payload.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	compare, err := r.SearchOrder(payload.SortBy, payload.Ascending() || len(payload.SortBy) == 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return compare(matches[i], matches[j]) < 0
+	})
+
+	startIndex := payload.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	page := make([]DataProvider, 0)
+	for i := startIndex - 1; i < len(matches) && len(page) < payload.Count; i++ {
+		page = append(page, r.copy(matches[i]))
+	}
+
+	return &ListResponse{
+		Schemas:      []string{ListResponseUrn},
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		TotalResults: len(matches),
+		Resources:    page,
+	}, nil
+}
+
+func (r *mapRepository) SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	compare, err := resourceOrder(sortBy, r.schema)
+	if err != nil || ascending {
+		return compare, err
+	}
+	return func(a, b DataProvider) int { return compare(b, a) }, nil
+}
+
+// The ascending order of resources by the attribute at sortBy, by id when it is empty or the values are equal.
+// Strings sort by the collation of the locale, if one is set, and dateTime values chronologically; of multiple
+// values, the first counts.
+func resourceOrder(sortBy string, sch *Schema) (func(a, b DataProvider) int, error) {
+	var (
+		sortPath Path
+		sortAttr *Attribute
+		err      error
+	)
+	if len(sortBy) > 0 {
+		if sch == nil {
+			sortPath, err = NewPath(sortBy)
+		} else {
+			sortPath, sortAttr, err = CompilePath(sortBy, sch)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortKey := func(dp DataProvider) string {
+		if sortPath == nil {
+			return dp.GetId()
+		}
+		key := ""
+		for v := range dp.GetData().Get(sortPath, sch) {
+			if len(key) == 0 {
+				key = fmt.Sprintf("%v", v)
+			}
+		}
+		return key
+	}
+	compare := compareBytes
+	if sortAttr != nil && sortAttr.Type == TypeString {
+		compare = newStringComparison()
+	} else if sortAttr != nil && sortAttr.Type == TypeDateTime {
+		compare = CompareDateTime
+	}
+	return func(a, b DataProvider) int {
+		if c := compare(sortKey(a), sortKey(b)); c != 0 || sortPath == nil {
+			return c
+		}
+		return compareBytes(a.GetId(), b.GetId())
+	}, nil
+}
+
+// Create an in memory repository, for tests and examples, safe for concurrent use
 func NewMapRepository(initialData map[string]DataProvider) Repository {
 	if len(initialData) == 0 {
 		return &mapRepository{data: make(map[string]DataProvider, 0)}
@@ -85,19 +434,26 @@ func NewMapRepository(initialData map[string]DataProvider) Repository {
 	}
 }
 
+// Create an in memory repository that supports Count and Search by evaluating filters against the schema
+func NewSearchableMapRepository(sch *Schema, initialData map[string]DataProvider) Repository {
+	repo := NewMapRepository(initialData).(*mapRepository)
+	repo.schema = sch
+	return repo
+}
+
 // simple factory method to return a repository query method
 // to easily put together a query only repository by composing
 // several repositories, useful when implementing root query functions
 func CompositeSearchFunc(
 	repositories ...Repository,
-) func(payload SearchRequest) (*ListResponse, error) {
-	return func(payload SearchRequest) (*ListResponse, error) {
+) func(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	return func(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
 		// prepare plans
 		skipQuota, limitQuota := 0, 0
 		grandListResponse := &ListResponse{
 			Schemas:      []string{ListResponseUrn},
 			StartIndex:   payload.StartIndex,
-			ItemsPerPage: payload.Count,
+			ItemsPerPage: 0,
 			TotalResults: 0,
 			Resources:    make([]DataProvider, 0),
 		}
@@ -121,7 +477,7 @@ func CompositeSearchFunc(
 			plans = append(plans, &queryExecutionPlan{repo: n})
 		}
 		for _, plan := range plans {
-			count, err := plan.repo.Count(payload.Filter)
+			count, err := plan.repo.Count(payload.Filter, ctx)
 			if err != nil {
 				plan.skipAll = true
 			} else {
@@ -183,7 +539,7 @@ func CompositeSearchFunc(
 
 			sr := SearchRequest{
 				Filter:             payload.Filter,
-				StartIndex:         plan.skip,
+				StartIndex:         plan.skip + 1,
 				Count:              plan.limit,
 				SortBy:             payload.SortBy,
 				SortOrder:          payload.SortOrder,
@@ -198,13 +554,18 @@ func CompositeSearchFunc(
 				sr.Count = 0
 			}
 
-			listResp, err := plan.repo.Search(sr)
+			listResp, err := plan.repo.Search(sr, ctx)
 			if err != nil {
 				return nil, err
 			}
 			grandListResponse.Resources = append(grandListResponse.Resources, listResp.Resources...)
+			grandListResponse.Partial = grandListResponse.Partial || listResp.Partial
 		}
 
+		if grandListResponse.StartIndex < 1 {
+			grandListResponse.StartIndex = 1
+		}
+		grandListResponse.ItemsPerPage = len(grandListResponse.Resources)
 		return grandListResponse, nil
 	}
 }
@@ -0,0 +1,244 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestSliceAttribute(t *testing.T) {
+	members := []interface{}{
+		map[string]interface{}{"value": "a"},
+		map[string]interface{}{"value": "b"},
+		map[string]interface{}{"value": "c"},
+	}
+	repo := NewMapRepository(map[string]DataProvider{
+		"foo": &Resource{Complex: Complex{"id": "foo", "members": members}},
+		"bar": &Resource{Complex: Complex{"id": "bar"}},
+	})
+
+	for _, test := range []struct {
+		id         string
+		startIndex int
+		count      int
+		values     []interface{}
+		total      int
+	}{
+		{"foo", 1, 2, members[0:2], 3},
+		{"foo", 2, 10, members[1:3], 3},
+		{"foo", 4, 10, []interface{}{}, 3},
+		{"foo", 1, 0, []interface{}{}, 3},
+		{"bar", 1, 10, []interface{}{}, 0},
+	} {
+		values, total, err := SliceAttribute(repo, test.id, "members", test.startIndex, test.count, context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, test.values, values)
+		assert.Equal(t, test.total, total)
+	}
+
+	_, _, err := SliceAttribute(repo, "missing", "members", 1, 10, context.Background())
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+}
+
+func TestResourceExists(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]DataProvider{
+		"foo": &Resource{Complex: Complex{"id": "foo", "meta": map[string]interface{}{"version": `W/"1"`}}},
+		"bar": &Resource{Complex: Complex{"id": "bar", "meta": map[string]interface{}{"version": `W/"2"`}}},
+	}
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	shards := map[string]Repository{"a": NewMapRepository(map[string]DataProvider{}), "b": NewMapRepository(map[string]DataProvider{})}
+	sharded := NewShardedRepository(sch, shards)
+	for _, dp := range data {
+		require.Nil(t, sharded.Create(dp, ctx))
+	}
+
+	for name, repo := range map[string]Repository{
+		"checker": NewMapRepository(data),
+		// hides the ExistenceChecker of the map repository, so that the resources are got instead
+		"fallback": struct{ Repository }{NewMapRepository(data)},
+		"sharded":  sharded,
+	} {
+		for _, test := range []struct {
+			id      string
+			version string
+			exists  bool
+		}{
+			{"foo", "", true},
+			{"foo", `W/"1"`, true},
+			{"foo", `W/"2"`, false},
+			{"bar", `W/"2"`, true},
+			{"missing", "", false},
+			{"missing", `W/"1"`, false},
+		} {
+			exists, err := ResourceExists(repo, test.id, test.version, ctx)
+			require.Nil(t, err, name)
+			assert.Equal(t, test.exists, exists, "%s %s %s", name, test.id, test.version)
+		}
+
+		count, err := CountExisting(repo, []string{"foo", "missing", "bar", "foo"}, ctx)
+		require.Nil(t, err, name)
+		assert.Equal(t, 2, count, name)
+		count, err = CountExisting(repo, []string{}, ctx)
+		require.Nil(t, err, name)
+		assert.Equal(t, 0, count, name)
+	}
+}
+
+func TestSearchableMapRepository_Search(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	data := make(map[string]DataProvider)
+	for _, name := range []string{"anne", "jack", "linda", "mary", "mike", "tom"} {
+		r, _, err := ParseResource("../resources/tests/" + name + ".json")
+		require.Nil(t, err)
+		data[r.GetId()] = r
+	}
+	repo := NewSearchableMapRepository(sch, data)
+
+	for _, test := range []struct {
+		payload   SearchRequest
+		assertion func(lr *ListResponse, err error)
+	}{
+		{
+			// count only
+			SearchRequest{Filter: "userName sw \"m\"", StartIndex: 1, Count: 0},
+			func(lr *ListResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, 2, lr.TotalResults)
+				assert.Equal(t, 0, lr.ItemsPerPage)
+				assert.Len(t, lr.Resources, 0)
+			},
+		},
+		{
+			// total reflects all matches, not the page
+			SearchRequest{StartIndex: 2, Count: 2, SortBy: "userName", SortOrder: "ascending"},
+			func(lr *ListResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, 6, lr.TotalResults)
+				assert.Equal(t, 2, lr.ItemsPerPage)
+				require.Len(t, lr.Resources, 2)
+				assert.Equal(t, "jack", lr.Resources[0].GetData()["userName"])
+				assert.Equal(t, "linda", lr.Resources[1].GetData()["userName"])
+			},
+		},
+		{
+			// attribute names in any case
+			SearchRequest{Filter: "USERNAME sw \"m\"", StartIndex: 1, Count: 10, SortBy: "UserName", SortOrder: "descending"},
+			func(lr *ListResponse, err error) {
+				assert.Nil(t, err)
+				require.Len(t, lr.Resources, 2)
+				assert.Equal(t, "mike", lr.Resources[0].GetData()["userName"])
+				assert.Equal(t, "mary", lr.Resources[1].GetData()["userName"])
+			},
+		},
+		{
+			// beyond the last match
+			SearchRequest{StartIndex: 10, Count: 5},
+			func(lr *ListResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, 6, lr.TotalResults)
+				assert.Len(t, lr.Resources, 0)
+			},
+		},
+	} {
+		test.assertion(repo.Search(test.payload, context.Background()))
+	}
+
+	count, err := repo.Count("userName eq \"tom\"", context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	lr, err := CompositeSearchFunc(repo, repo)(SearchRequest{StartIndex: 1, Count: 0}, context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 12, lr.TotalResults)
+	assert.Equal(t, 0, lr.ItemsPerPage)
+	assert.Len(t, lr.Resources, 0)
+
+	// the page starts in the first repository and ends in the second, short of the count
+	lr, err = CompositeSearchFunc(repo, repo)(SearchRequest{StartIndex: 5, Count: 10, SortBy: "userName"}, context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 12, lr.TotalResults)
+	assert.Equal(t, 5, lr.StartIndex)
+	assert.Equal(t, 8, lr.ItemsPerPage)
+	require.Len(t, lr.Resources, 8)
+	assert.Equal(t, "mike", lr.Resources[0].GetData()["userName"])
+	assert.Equal(t, "anne", lr.Resources[2].GetData()["userName"])
+}
+
+func TestSearchableMapRepository_SearchMeta(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/schemas/user_internal.json")
+	require.Nil(t, err)
+
+	user := func(id, lastModified string) DataProvider {
+		return &Resource{Complex: Complex{"id": id, "userName": id, "meta": map[string]interface{}{
+			"created": "2021-01-01T00:00:00Z", "lastModified": lastModified,
+		}}}
+	}
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"a": user("a", "2021-06-01T10:00:00.5Z"),
+		"b": user("b", "2021-06-01T10:00:00Z"),
+		"c": user("c", "2021-05-31T23:59:59Z"),
+	})
+
+	// the delta query of an identity provider, with an operand in another time zone
+	lr, err := repo.Search(SearchRequest{
+		Filter:     `meta.lastModified gt "2021-06-01T01:59:59+02:00"`,
+		SortBy:     "meta.lastModified",
+		SortOrder:  "ascending",
+		StartIndex: 1,
+		Count:      10,
+	}, context.Background())
+	require.Nil(t, err)
+	require.Len(t, lr.Resources, 2)
+	assert.Equal(t, "b", lr.Resources[0].GetId())
+	assert.Equal(t, "a", lr.Resources[1].GetId())
+
+	count, err := repo.Count(`meta.created le "2021-01-01T00:00:00Z" and meta.lastModified lt "2021-06-01T10:00:00.25Z"`, context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+// run with -race
+func TestMapRepository_Concurrency(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("user%d", i)
+			assert.Nil(t, repo.Create(&Resource{Complex: Complex{"id": id, "userName": id}}, ctx))
+			dp, err := repo.Get(id, "", ctx)
+			if !assert.Nil(t, err) {
+				return
+			}
+			// a changed copy leaves the stored resource alone until it is written back
+			dp.GetData()["displayName"] = "changed"
+			stored, _ := repo.Get(id, "", ctx)
+			assert.Nil(t, stored.GetData()["displayName"])
+			assert.Nil(t, repo.Update(id, "", dp, ctx))
+			_, err = repo.Search(SearchRequest{Filter: `userName sw "user"`, StartIndex: 1, Count: 10}, ctx)
+			assert.Nil(t, err)
+			_, err = repo.Count(`displayName eq "changed"`, ctx)
+			assert.Nil(t, err)
+			if i%2 == 0 {
+				assert.Nil(t, repo.Delete(id, "", ctx))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count, err := repo.Count(`displayName eq "changed"`, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 4, count)
+}
@@ -0,0 +1,25 @@
+package shared
+
+import "strings"
+
+// Whether deletes must carry the version they expect to remove, the scim.protocol.etag property, features.etag
+// in the config package
+const (
+	ETagOptional = "optional" // deletes without If-Match remove whatever version is stored
+	ETagRequired = "required" // deletes without If-Match are answered with 428 Precondition Required
+	ETagOff      = "off"      // as optional, and responses carry no ETag header, for servers not supporting ETags
+)
+
+// Check the value of an If-Match header, one or more comma separated versions or "*", against the stored
+// resource and return its version. A mismatch fails with the ResourceNotFoundError of the version asked for,
+// answered with 412 Precondition Failed.
+func CheckIfMatch(ifMatch string, stored DataProvider) (string, error) {
+	meta, _ := stored.GetData()["meta"].(map[string]interface{})
+	version, _ := meta["version"].(string)
+	for _, each := range strings.Split(ifMatch, ",") {
+		if each = strings.TrimSpace(each); each == "*" || (len(version) > 0 && each == version) {
+			return version, nil
+		}
+	}
+	return "", Error.ResourceNotFound(stored.GetId(), ifMatch)
+}
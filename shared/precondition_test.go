@@ -0,0 +1,25 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckIfMatch(t *testing.T) {
+	stored := &Resource{Complex: Complex{"id": "foo", "meta": map[string]interface{}{"version": `W/"2"`}}}
+
+	version, err := CheckIfMatch(`W/"2"`, stored)
+	assert.Nil(t, err)
+	assert.Equal(t, `W/"2"`, version)
+	version, err = CheckIfMatch(`W/"1", W/"2"`, stored)
+	assert.Nil(t, err)
+	assert.Equal(t, `W/"2"`, version)
+	version, err = CheckIfMatch("*", stored)
+	assert.Nil(t, err)
+	assert.Equal(t, `W/"2"`, version)
+
+	_, err = CheckIfMatch(`W/"1"`, stored)
+	assert.Equal(t, Error.ResourceNotFound("foo", `W/"1"`), err)
+	_, err = CheckIfMatch(`W/"1"`, &Resource{Complex: Complex{"id": "foo"}})
+	assert.NotNil(t, err)
+}
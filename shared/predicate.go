@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 )
@@ -53,6 +54,9 @@ func (impl *predicateImpl) getFunc(filter FilterNode) predicateFunc {
 	case Le:
 		return impl.leFunc(filter)
 	}
+	if op := LookupFilterOperator(fmt.Sprint(filter.Data())); op != nil {
+		return impl.customFunc(filter, op)
+	}
 	return nil
 }
 
@@ -81,39 +85,37 @@ func (impl *predicateImpl) notFunc(filter FilterNode) predicateFunc {
 
 func (impl *predicateImpl) eqFunc(filter FilterNode) predicateFunc {
 	return func(c Complex) bool {
-		return impl.compare(filter.Left(), filter.Right(), c) == equal
+		return impl.compareAny(filter.Left(), filter.Right(), c, equal)
 	}
 }
 
 func (impl *predicateImpl) neFunc(filter FilterNode) predicateFunc {
 	return func(c Complex) bool {
-		return impl.compare(filter.Left(), filter.Right(), c) != equal
+		return !impl.compareAny(filter.Left(), filter.Right(), c, equal)
 	}
 }
 
 func (impl *predicateImpl) gtFunc(filter FilterNode) predicateFunc {
 	return func(c Complex) bool {
-		return impl.compare(filter.Left(), filter.Right(), c) == greater
+		return impl.compareAny(filter.Left(), filter.Right(), c, greater)
 	}
 }
 
 func (impl *predicateImpl) geFunc(filter FilterNode) predicateFunc {
 	return func(c Complex) bool {
-		r := impl.compare(filter.Left(), filter.Right(), c)
-		return r == greater || r == equal
+		return impl.compareAny(filter.Left(), filter.Right(), c, greater, equal)
 	}
 }
 
 func (impl *predicateImpl) ltFunc(filter FilterNode) predicateFunc {
 	return func(c Complex) bool {
-		return impl.compare(filter.Left(), filter.Right(), c) == less
+		return impl.compareAny(filter.Left(), filter.Right(), c, less)
 	}
 }
 
 func (impl *predicateImpl) leFunc(filter FilterNode) predicateFunc {
 	return func(c Complex) bool {
-		r := impl.compare(filter.Left(), filter.Right(), c)
-		return r == less || r == equal
+		return impl.compareAny(filter.Left(), filter.Right(), c, less, equal)
 	}
 }
 
@@ -166,18 +168,63 @@ func (impl *predicateImpl) coFunc(filter FilterNode) predicateFunc {
 	}
 }
 
+// Registered operators without Match match nothing
+func (impl *predicateImpl) customFunc(filter FilterNode, op *FilterOperator) predicateFunc {
+	return func(c Complex) bool {
+		lhs, rhs := filter.Left(), filter.Right()
+		if op.Match == nil || lhs.Type() != PathOperand || rhs.Type() != ConstantOperand {
+			return false
+		}
+		key := lhs.Data().(Path)
+		attr := impl.attrSource.GetAttribute(key, true)
+		if attr == nil || !op.AppliesTo(attr.Type) {
+			return false
+		}
+		for _, v := range impl.values(key, c) {
+			if impl.matchesCustom(attr, v, rhs.Data(), op) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// the value of a multiValued attribute matches when any of its elements does
+func (impl *predicateImpl) matchesCustom(attr *Attribute, v, operand interface{}, op *FilterOperator) bool {
+	if attr.MultiValued {
+		if elems, ok := v.([]interface{}); ok {
+			for _, elem := range elems {
+				if op.Match(attr, elem, operand) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return v != nil && op.Match(attr, v, operand)
+}
+
 func (impl *predicateImpl) stringOp(lhs, rhs FilterNode, c Complex, op func(a, b string) bool) bool {
 	if lhs.Type() != PathOperand || rhs.Type() != ConstantOperand {
 		return false
 	}
 
 	key := lhs.Data().(Path)
-	attr := impl.attrSource.GetAttribute(key, false)
+	attr := impl.attrSource.GetAttribute(key, true)
 	if attr == nil || attr.MultiValued || attr.Type == TypeComplex {
 		return false
 	}
 
-	lVal := reflect.ValueOf(<-c.Get(key, impl.attrSource))
+	for _, v := range impl.values(key, c) {
+		if impl.stringOpValue(attr, v, rhs, op) {
+			return true
+		}
+	}
+	return false
+}
+
+func (impl *predicateImpl) stringOpValue(attr *Attribute, v interface{}, rhs FilterNode, op func(a, b string) bool) bool {
+	lVal := reflect.ValueOf(v)
 	if !lVal.IsValid() {
 		return false
 	} else if lVal.Kind() == reflect.Interface {
@@ -197,23 +244,74 @@ func (impl *predicateImpl) stringOp(lhs, rhs FilterNode, c Complex, op func(a, b
 		if attr.CaseExact {
 			return op(lVal.String(), rVal.String())
 		} else {
-			return op(strings.ToLower(lVal.String()), strings.ToLower(rVal.String()))
+			return op(FoldCase(lVal.String()), FoldCase(rVal.String()))
 		}
 	}
 }
 
-func (impl *predicateImpl) compare(lhs, rhs FilterNode, c Complex) comparison {
+// Tell whether comparing any value at the path to the constant has one of the outcomes. A path through a
+// multiValued complex attribute, i.e. 'emails.value', holds the value of every element (RFC 7644 section
+// 3.4.2.2).
+func (impl *predicateImpl) compareAny(lhs, rhs FilterNode, c Complex, outcomes ...comparison) bool {
 	if lhs.Type() != PathOperand || rhs.Type() != ConstantOperand {
-		return invalid
+		return false
 	}
+	for _, v := range impl.values(lhs.Data().(Path), c) {
+		r := impl.compare(lhs, rhs, v)
+		for _, outcome := range outcomes {
+			if r == outcome {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the values at the path, descending into every element of multiValued complex attributes along the way
+func (impl *predicateImpl) values(key Path, c Complex) []interface{} {
+	if key.FilterRoot() != nil {
+		return []interface{}{<-c.Get(key, impl.attrSource)}
+	}
+	return pathValues(c, key, impl.attrSource)
+}
 
+func pathValues(c Complex, p Path, guide AttributeSource) []interface{} {
+	attr := guide.GetAttribute(p, false)
+	if attr == nil {
+		return nil
+	}
+	_, v, ok := entryByName(c, attr.Name)
+	if !ok || v == nil {
+		return nil
+	}
+	if p.Next() == nil {
+		return []interface{}{v}
+	}
+
+	elems := []interface{}{v}
+	if attr.MultiValued {
+		if mv, ok := v.([]interface{}); ok {
+			elems = mv
+		}
+	}
+	values := make([]interface{}, 0, len(elems))
+	for _, elem := range elems {
+		if m, ok := elem.(map[string]interface{}); ok {
+			values = append(values, pathValues(Complex(m), p.Next(), attr)...)
+		}
+	}
+	return values
+}
+
+// compare a value held at the path of the left hand side to the constant of the right hand side
+func (impl *predicateImpl) compare(lhs, rhs FilterNode, v interface{}) comparison {
 	key := lhs.Data().(Path)
 	attr := impl.attrSource.GetAttribute(key, true)
 	if attr == nil || attr.MultiValued || attr.Type == TypeComplex {
 		return invalid
 	}
 
-	lVal := reflect.ValueOf(<-c.Get(key, impl.attrSource))
+	lVal := reflect.ValueOf(v)
 	if !lVal.IsValid() {
 		return invalid
 	} else if lVal.Kind() == reflect.Interface {
@@ -268,7 +366,21 @@ func (impl *predicateImpl) compare(lhs, rhs FilterNode, c Complex) comparison {
 			}
 		}
 
-	case TypeString, TypeBinary, TypeDateTime, TypeReference:
+	case TypeDateTime:
+		if !impl.kindOf(lVal, reflect.String) || !impl.kindOf(rVal, reflect.String) {
+			return invalid
+		} else {
+			switch CompareDateTime(lVal.String(), rVal.String()) {
+			case 0:
+				return equal
+			case -1:
+				return less
+			case 1:
+				return greater
+			}
+		}
+
+	case TypeString, TypeBinary, TypeReference:
 		if !impl.kindOf(lVal, reflect.String) || !impl.kindOf(rVal, reflect.String) {
 			return invalid
 		} else {
@@ -276,7 +388,7 @@ func (impl *predicateImpl) compare(lhs, rhs FilterNode, c Complex) comparison {
 			if attr.CaseExact {
 				a, b = lVal.String(), rVal.String()
 			} else {
-				a, b = strings.ToLower(lVal.String()), strings.ToLower(rVal.String())
+				a, b = FoldCase(lVal.String()), FoldCase(rVal.String())
 			}
 			switch {
 			case a == b:
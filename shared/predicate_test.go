@@ -56,6 +56,53 @@ func TestEvaluatePredicate(t *testing.T) {
 			Complex{"meta": map[string]interface{}{"created": "2017-01-01"}},
 			true,
 		},
+		{
+			"meta.created gt \"2017-01-01T10:00:00+02:00\"",
+			Complex{"meta": map[string]interface{}{"created": "2017-01-01T09:00:00Z"}},
+			true,
+		},
+		{
+			"meta.created eq \"2017-01-01T11:00:00+02:00\"",
+			Complex{"meta": map[string]interface{}{"created": "2017-01-01T09:00:00Z"}},
+			true,
+		},
+		{
+			"meta.created lt \"2017-01-01T09:00:00.5Z\"",
+			Complex{"meta": map[string]interface{}{"created": "2017-01-01T09:00:00Z"}},
+			true,
+		},
+		{
+			"name.givenName sw \"da\"",
+			Complex{"name": map[string]interface{}{"givenName": "David"}},
+			true,
+		},
+		{
+			// any element of a multiValued attribute matches
+			"emails.value eq \"DQ@example.com\"",
+			Complex{"emails": []interface{}{
+				map[string]interface{}{"value": "david@example.com"},
+				map[string]interface{}{"value": "dq@example.com"},
+			}},
+			true,
+		},
+		{
+			"emails.value ne \"dq@example.com\"",
+			Complex{"emails": []interface{}{
+				map[string]interface{}{"value": "david@example.com"},
+				map[string]interface{}{"value": "dq@example.com"},
+			}},
+			false,
+		},
+		{
+			"emails.value ew \"@example.org\"",
+			Complex{"emails": []interface{}{map[string]interface{}{"value": "david@example.com"}}},
+			false,
+		},
+		{
+			"emails.value ne \"david@example.com\"",
+			Complex{"userName": "david"},
+			true,
+		},
 	} {
 		filter, err := NewFilter(test.filterText)
 		require.Nil(t, err)
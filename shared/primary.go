@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Enforce that at most one element of each multi-valued complex attribute with a primary sub attribute, i.e.
+// emails, phoneNumbers or addresses, is primary (RFC 7643 section 2.4). Against the reference, the resource
+// before a replace or a patch operation, an element made primary takes the flag from the elements primary
+// before, so that adding or replacing a primary email unsets the old one. More than one primary element is an
+// invalid value in created resources, and so are several elements made primary at once.
+func EnforcePrimary(subj *Resource, ref *Resource, sch *Schema) error {
+	var refData map[string]interface{}
+	if ref != nil {
+		refData = ref.Complex
+	}
+	return enforcePrimary(subj.Complex, refData, sch.ToAttribute(), "")
+}
+
+func enforcePrimary(subj, ref map[string]interface{}, guide *Attribute, prefix string) error {
+	for _, attr := range guide.SubAttributes {
+		if attr.Type != TypeComplex {
+			continue
+		}
+		k, v, ok := entryByName(subj, attr.Name)
+		if !ok {
+			continue
+		}
+		_, refV, _ := entryByName(ref, attr.Name)
+
+		if !attr.MultiValued {
+			sub, ok := v.(map[string]interface{})
+			refSub, _ := refV.(map[string]interface{})
+			if ok {
+				if err := enforcePrimary(sub, refSub, attr, prefix+k+"."); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if primary := attr.SubAttribute("primary"); primary == nil || primary.Type != TypeBoolean {
+			continue
+		}
+
+		elements, _ := v.([]interface{})
+		primaries := make([]map[string]interface{}, 0, 1)
+		for _, element := range elements {
+			if m, ok := element.(map[string]interface{}); ok && isPrimary(m) {
+				primaries = append(primaries, m)
+			}
+		}
+		if len(primaries) <= 1 {
+			continue
+		}
+
+		if ref == nil {
+			return Error.InvalidParam(prefix+k, "at most one element to be primary", fmt.Sprintf("%d", len(primaries)))
+		}
+		refElements, _ := refV.([]interface{})
+		made := make([]int, 0, 1)
+		for i, m := range primaries {
+			if !wasPrimary(m, refElements) {
+				made = append(made, i)
+			}
+		}
+		switch len(made) {
+		case 0:
+			// stored that way, not up to this request
+		case 1:
+			for i, m := range primaries {
+				if i != made[0] {
+					deletePrimary(m)
+				}
+			}
+		default:
+			return Error.InvalidParam(prefix+k, "at most one element to be made primary", fmt.Sprintf("%d", len(made)))
+		}
+	}
+	return nil
+}
+
+func isPrimary(element map[string]interface{}) bool {
+	_, v, _ := entryByName(element, "primary")
+	primary, _ := v.(bool)
+	return primary
+}
+
+func deletePrimary(element map[string]interface{}) {
+	for k := range element {
+		if strings.EqualFold(k, "primary") {
+			delete(element, k)
+		}
+	}
+}
+
+// whether the reference has the element, apart from its primary flag, as primary element
+func wasPrimary(element map[string]interface{}, refElements []interface{}) bool {
+	for _, each := range refElements {
+		m, ok := each.(map[string]interface{})
+		if ok && isPrimary(m) && equalExceptPrimary(element, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalExceptPrimary(a, b map[string]interface{}) bool {
+	without := func(m map[string]interface{}) map[string]interface{} {
+		c := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			if !strings.EqualFold(k, "primary") {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	return reflect.DeepEqual(without(a), without(b))
+}
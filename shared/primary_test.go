@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEnforcePrimary(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	emails := func(primaries ...bool) []interface{} {
+		elements := make([]interface{}, 0, len(primaries))
+		for i, primary := range primaries {
+			element := map[string]interface{}{"value": string(rune('a'+i)) + "@example.com"}
+			if primary {
+				element["primary"] = true
+			}
+			elements = append(elements, element)
+		}
+		return elements
+	}
+	primaryOf := func(r *Resource) []string {
+		values := make([]string, 0)
+		for _, each := range r.Complex["emails"].([]interface{}) {
+			if m := each.(map[string]interface{}); m["primary"] == true {
+				values = append(values, m["value"].(string))
+			}
+		}
+		return values
+	}
+
+	// created
+	r := &Resource{Complex: Complex{"emails": emails(true, false)}}
+	assert.Nil(t, EnforcePrimary(r, nil, sch))
+	r = &Resource{Complex: Complex{"emails": emails(true, true)}}
+	assert.IsType(t, &InvalidParamError{}, EnforcePrimary(r, nil, sch))
+
+	// replaced, the new primary wins
+	ref := &Resource{Complex: Complex{"emails": emails(true, false)}}
+	r = &Resource{Complex: Complex{"emails": emails(true, true)}}
+	require.Nil(t, EnforcePrimary(r, ref, sch))
+	assert.Equal(t, []string{"b@example.com"}, primaryOf(r))
+
+	// several made primary at once
+	r = &Resource{Complex: Complex{"emails": emails(true, true, true)}}
+	assert.IsType(t, &InvalidParamError{}, EnforcePrimary(r, ref, sch))
+
+	// patched
+	r = &Resource{Complex: Complex{"emails": emails(true, false)}}
+	require.Nil(t, ApplyPatch(Patch{Op: Add, Path: "emails", Value: map[string]interface{}{
+		"value": "c@example.com", "primary": true,
+	}}, r, sch, context.Background()))
+	assert.Equal(t, []string{"c@example.com"}, primaryOf(r))
+	require.Nil(t, ApplyPatch(Patch{Op: Replace, Path: "emails[value eq \"b@example.com\"].primary", Value: true}, r, sch, context.Background()))
+	assert.Equal(t, []string{"b@example.com"}, primaryOf(r))
+	assert.NotNil(t, ApplyPatch(Patch{Op: Add, Path: "emails", Value: []interface{}{
+		map[string]interface{}{"value": "d@example.com", "primary": true},
+		map[string]interface{}{"value": "e@example.com", "primary": true},
+	}}, r, sch, context.Background()))
+}
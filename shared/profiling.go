@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// The pprof label of the step ProfileStep runs, next to the resource type and operation labels keyed like the
+// span attributes, i.e. scim.resource_type
+const ProfileLabelStep = "scim.step"
+
+type profileLabelsKey struct{}
+
+// Turn the pprof labels of ProfileStep on for the steps run with the context, i.e. those of the requests of a
+// server labeling them. They are off by default, as labeling every step allocates.
+func WithProfileLabels(ctx context.Context) context.Context {
+	return context.WithValue(ctx, profileLabelsKey{}, true)
+}
+
+// Runs a step with pprof labels of the resource type and operation of the request and the name of the step,
+// when turned on with WithProfileLabels, so that samples of CPU profiles taken in production, i.e. through
+// net/http/pprof, are attributed to the pipeline stage or repository call they were taken in. Labels of nested
+// steps replace the step label of the enclosing one.
+func ProfileStep(ctx context.Context, name string, step func(ctx context.Context) error) (err error) {
+	if on, _ := ctx.Value(profileLabelsKey{}).(bool); !on {
+		return step(ctx)
+	}
+	var resourceType, operation string
+	if requestType, ok := ctx.Value(RequestType{}).(int); ok {
+		resourceType, operation = DescribeRequestType(requestType)
+	}
+	labels := pprof.Labels(TraceAttrResourceType, resourceType, TraceAttrOperation, operation, ProfileLabelStep, name)
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		err = step(ctx)
+	})
+	return
+}
@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestProfileStep(t *testing.T) {
+	ctx := context.WithValue(context.Background(), RequestType{}, PatchGroup)
+	labels := func(ctx context.Context) []string {
+		values := make([]string, 0)
+		for _, key := range []string{TraceAttrResourceType, TraceAttrOperation, ProfileLabelStep} {
+			value, _ := pprof.Label(ctx, key)
+			values = append(values, value)
+		}
+		return values
+	}
+
+	assert.Nil(t, ProfileStep(ctx, "applyPatch", func(ctx context.Context) error {
+		assert.Equal(t, []string{"", "", ""}, labels(ctx))
+		return nil
+	}))
+
+	ctx = WithProfileLabels(ctx)
+	boom := errors.New("boom")
+	err := ProfileStep(ctx, "applyPatch", func(ctx context.Context) error {
+		assert.Equal(t, []string{GroupResourceType, "patch", "applyPatch"}, labels(ctx))
+		return ProfileStep(ctx, "repository.update", func(ctx context.Context) error {
+			assert.Equal(t, []string{GroupResourceType, "patch", "repository.update"}, labels(ctx))
+			return boom
+		})
+	})
+	assert.Equal(t, boom, err)
+}
@@ -0,0 +1,152 @@
+package shared
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decides whether a request from the client identified by key may proceed.
+// When it may not, retryAfter hints how long the client should wait.
+type RateLimiter interface {
+	Allow(key string) (allowed bool, retryAfter time.Duration)
+}
+
+// Returns a rate limiter that allows everything
+func NewUnlimitedRateLimiter() RateLimiter {
+	return unlimitedRateLimiter{}
+}
+
+type unlimitedRateLimiter struct{}
+
+func (l unlimitedRateLimiter) Allow(key string) (bool, time.Duration) { return true, 0 }
+
+// Returns a rate limiter that keeps one token bucket per key. Each bucket holds at most
+// burst tokens and is refilled with rate tokens per second; each request takes one token.
+func NewTokenBucketRateLimiter(rate float64, burst int) RateLimiter {
+	return &tokenBucketRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// number of buckets after which buckets that have refilled completely are dropped
+const maxIdleTokenBuckets = 10000
+
+type tokenBucketRateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *tokenBucketRateLimiter) Allow(key string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleTokenBuckets {
+			l.evictFullBuckets(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if l.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (l *tokenBucketRateLimiter) refill(b *tokenBucket, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+}
+
+func (l *tokenBucketRateLimiter) evictFullBuckets(now time.Time) {
+	for k, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Resolves the rate limiting key of a request: the authenticated subject stored under the Principal context
+// key if present, otherwise the IP of the client. That is the IP of the connection, the RemoteAddr context
+// value, unless the connection comes from one of the trusted proxies, IPs or CIDR ranges: then it is the
+// address the proxies report, the last of X-Forwarded-For that is not a trusted proxy itself, or X-Real-IP.
+// Clients cannot pick a bucket of their own by sending these headers directly. Requests of unknown origin
+// share the "anonymous" key.
+func RateLimitKey(req WebRequest, ctx context.Context, trustedProxies []string) string {
+	if principal, ok := ctx.Value(Principal{}).(string); ok && len(principal) > 0 {
+		return "principal:" + principal
+	}
+	remote, _ := ctx.Value(RemoteAddr{}).(string)
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if len(remote) == 0 {
+		return "anonymous"
+	}
+	if !trustedProxy(remote, trustedProxies) {
+		return "ip:" + remote
+	}
+
+	if forwarded := req.Header("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(forwarded, ",")
+		// proxies append the address they were reached from, the leftmost hops are the client's to forge
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if len(hop) > 0 && (i == 0 || !trustedProxy(hop, trustedProxies)) {
+				return "ip:" + hop
+			}
+		}
+	}
+	if realIp := strings.TrimSpace(req.Header("X-Real-IP")); len(realIp) > 0 {
+		return "ip:" + realIp
+	}
+	return "ip:" + remote
+}
+
+// whether the IP is one of the proxies, given as IPs or CIDR ranges
+func trustedProxy(ip string, proxies []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if other := net.ParseIP(proxy); other != nil && other.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
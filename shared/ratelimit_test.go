@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRateLimiter(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewTokenBucketRateLimiter(1, 2).(*tokenBucketRateLimiter)
+	limiter.now = func() time.Time { return now }
+
+	allowed, _ := limiter.Allow("a")
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("a")
+	assert.True(t, allowed)
+	allowed, retryAfter := limiter.Allow("a")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// other clients have their own bucket
+	allowed, _ = limiter.Allow("b")
+	assert.True(t, allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, retryAfter = limiter.Allow("a")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.Allow("a")
+	assert.True(t, allowed)
+}
+
+func TestRateLimitKey(t *testing.T) {
+	from := func(addr string) context.Context {
+		return context.WithValue(context.Background(), RemoteAddr{}, addr)
+	}
+	proxies := []string{"10.0.0.0/8", "192.168.1.1"}
+
+	for _, test := range []struct {
+		headers map[string]string
+		ctx     context.Context
+		key     string
+	}{
+		{
+			map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2"},
+			context.WithValue(from("10.0.0.9:4321"), Principal{}, "alice"),
+			"principal:alice",
+		},
+		{
+			// headers of clients connecting directly are ignored
+			map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.5"},
+			from("203.0.113.7:4321"),
+			"ip:203.0.113.7",
+		},
+		{
+			// the last hop that is not a trusted proxy, not what the client put in front
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.2, 10.0.0.2"},
+			from("10.0.0.9:4321"),
+			"ip:198.51.100.2",
+		},
+		{
+			map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			from("192.168.1.1:4321"),
+			"ip:10.0.0.3",
+		},
+		{
+			map[string]string{"X-Real-IP": "198.51.100.3"},
+			from("10.0.0.9:4321"),
+			"ip:198.51.100.3",
+		},
+		{
+			map[string]string{},
+			from("10.0.0.9:4321"),
+			"ip:10.0.0.9",
+		},
+		{
+			map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			context.Background(),
+			"anonymous",
+		},
+	} {
+		assert.Equal(t, test.key, RateLimitKey(headerRequest(test.headers), test.ctx, proxies), "%v", test.headers)
+	}
+
+	// without trusted proxies, every connection is a client of its own
+	assert.Equal(t, "ip:10.0.0.9", RateLimitKey(headerRequest{"X-Forwarded-For": "1.2.3.4"}, from("10.0.0.9:4321"), nil))
+}
+
+type headerRequest map[string]string
+
+func (r headerRequest) Target() string            { return "" }
+func (r headerRequest) Method() string            { return "" }
+func (r headerRequest) Header(name string) string { return r[name] }
+func (r headerRequest) Param(name string) string  { return "" }
+func (r headerRequest) Body() ([]byte, error)     { return nil, nil }
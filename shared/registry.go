@@ -0,0 +1,260 @@
+package shared
+
+import (
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry of the schemas a server serves, loadable from the standard schema representation of
+// RFC 7643 section 7, i.e. the format served at /Schemas. Schemas may be added at any time during
+// startup; registering a schema with an id already present replaces it. Once the server serves, Freeze
+// makes the registry read only, so that the schemas handlers read never change under them.
+type SchemaRegistry struct {
+	sync.RWMutex
+	schemas map[string]*Schema
+	frozen  bool
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: make(map[string]*Schema)}
+}
+
+// Validate the schema, fill in defaults and the assist metadata and add it to the registry. Fails once the
+// registry is frozen.
+func (r *SchemaRegistry) Register(sch *Schema) error {
+	if r.Frozen() {
+		return errFrozenRegistry(sch)
+	}
+	if err := CompileSchema(sch); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.frozen {
+		return errFrozenRegistry(sch)
+	}
+	r.schemas[sch.Id] = sch
+	return nil
+}
+
+// Stop accepting schemas, i.e. when the server starts serving
+func (r *SchemaRegistry) Freeze() {
+	r.Lock()
+	defer r.Unlock()
+	r.frozen = true
+}
+
+// Whether the registry no longer accepts schemas
+func (r *SchemaRegistry) Frozen() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.frozen
+}
+
+func errFrozenRegistry(sch *Schema) error {
+	return Error.Text("schema %s registered after the schema registry was frozen", sch.Id)
+}
+
+// Parse a standard schema JSON definition and register it
+func (r *SchemaRegistry) RegisterJSON(raw []byte) (*Schema, error) {
+	sch := &Schema{}
+	if err := json.Unmarshal(raw, sch); err != nil {
+		return nil, Error.InvalidParam("schema definition", "json conforming to schema syntax", err.Error())
+	}
+	if err := r.Register(sch); err != nil {
+		return nil, err
+	}
+	return sch, nil
+}
+
+// Register the schema defined in the file
+func (r *SchemaRegistry) LoadFile(filePath string) (*Schema, error) {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return r.RegisterJSON(raw)
+}
+
+// Register all schemas in the file system matching the glob pattern, i.e. from an embed.FS
+func (r *SchemaRegistry) LoadFS(fsys fs.FS, pattern string) ([]*Schema, error) {
+	matches, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make([]*Schema, 0, len(matches))
+	for _, match := range matches {
+		raw, err := fs.ReadFile(fsys, match)
+		if err != nil {
+			return nil, err
+		}
+		sch, err := r.RegisterJSON(raw)
+		if err != nil {
+			return nil, Error.Text("failed to load schema from %s: %s", filepath.ToSlash(match), err.Error())
+		}
+		schemas = append(schemas, sch)
+	}
+	return schemas, nil
+}
+
+// The registered schema with the id, nil if there is none
+func (r *SchemaRegistry) Get(id string) *Schema {
+	r.RLock()
+	defer r.RUnlock()
+	return r.schemas[id]
+}
+
+// All registered schemas, ordered by id
+func (r *SchemaRegistry) All() []*Schema {
+	r.RLock()
+	defer r.RUnlock()
+	all := make([]*Schema, 0, len(r.schemas))
+	for _, sch := range r.schemas {
+		all = append(all, sch)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Id < all[j].Id })
+	return all
+}
+
+// Validate a schema against the rules of RFC 7643 section 7 and prepare it for use: type names are
+// normalized, omitted characteristics get their defaults and the assist metadata is derived.
+// Assist metadata already present, as in the internal schemas, is kept.
+func CompileSchema(sch *Schema) error {
+	if len(sch.Id) == 0 {
+		return Error.InvalidParam("schema id", "non-empty urn", "")
+	}
+	return compileAttributes(sch.Attributes, sch.Id, "")
+}
+
+// Embed an extension schema into the internal schema of a resource type, i.e. custom attributes of groups,
+// as a complex attribute named by the URN of the extension, the way resources carry the extension namespace.
+// The extension is compiled like a registered schema, so that its attributes are validated and marshalled like
+// core attributes and paths like 'urn:example:params:scim:schemas:extension:acme:2.0:Group:costCenter' reach
+// them in patches and the attributes parameters. The namespace attribute of a required extension is required
+// itself, as for ValidateRequired. Embedding an extension again replaces it.
+func (s *Schema) AddExtension(ext *Schema, required bool) error {
+	if strings.EqualFold(ext.Id, s.Id) {
+		return Error.InvalidParam("extension id", "urn other than that of the schema", ext.Id)
+	}
+	if err := CompileSchema(ext); err != nil {
+		return err
+	}
+
+	namespace := &Attribute{
+		Name:          ext.Id,
+		Type:          TypeComplex,
+		SubAttributes: ext.Attributes,
+		Required:      required,
+		Mutability:    ReadWrite,
+		Returned:      Default,
+		Uniqueness:    None,
+		Assist:        &Assist{JSONName: ext.Id, Path: ext.Id, FullPath: ext.Id, ArrayIndexKey: []string{}},
+	}
+	for i, attr := range s.Attributes {
+		if strings.EqualFold(attr.Name, ext.Id) {
+			s.Attributes[i] = namespace
+			return nil
+		}
+	}
+	s.Attributes = append(s.Attributes, namespace)
+	return nil
+}
+
+func compileAttributes(attrs []*Attribute, urn, prefix string) error {
+	names := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		if attr == nil || len(attr.Name) == 0 {
+			return Error.InvalidParam("attribute name", "non-empty name", "")
+		}
+
+		path := joinAttributePath(prefix, attr.Name)
+		if names[strings.ToLower(attr.Name)] {
+			return Error.InvalidParam(path, "unique attribute name", "duplicate")
+		}
+		names[strings.ToLower(attr.Name)] = true
+
+		if err := compileCharacteristics(attr, path); err != nil {
+			return err
+		}
+
+		if err := compileAttributes(attr.SubAttributes, urn, path); err != nil {
+			return err
+		}
+
+		if attr.Assist == nil {
+			attr.Assist = &Assist{
+				JSONName:      attr.Name,
+				Path:          path,
+				FullPath:      urn + ":" + path,
+				ArrayIndexKey: arrayIndexKey(attr),
+			}
+		}
+	}
+	return nil
+}
+
+func compileCharacteristics(attr *Attribute, path string) error {
+	switch strings.ToLower(attr.Type) {
+	case TypeString, TypeBoolean, TypeBinary, TypeDecimal, TypeInteger, TypeDateTime, TypeReference, TypeComplex:
+		attr.Type = strings.ToLower(attr.Type)
+	default:
+		return Error.InvalidParam(path+".type", "one of string, boolean, binary, decimal, integer, dateTime, reference, complex", attr.Type)
+	}
+
+	if attr.Type == TypeComplex && len(attr.SubAttributes) == 0 {
+		return Error.InvalidParam(path+".subAttributes", "sub attributes for complex attribute", "none")
+	}
+	if attr.Type != TypeComplex && len(attr.SubAttributes) > 0 {
+		return Error.InvalidParam(path+".subAttributes", "no sub attributes for "+attr.Type+" attribute", "sub attributes")
+	}
+
+	switch attr.Mutability {
+	case "":
+		attr.Mutability = ReadWrite
+	case ReadOnly, ReadWrite, Immutable, WriteOnly:
+	default:
+		return Error.InvalidParam(path+".mutability", "one of readOnly, readWrite, immutable, writeOnly", attr.Mutability)
+	}
+
+	switch attr.Returned {
+	case "":
+		attr.Returned = Default
+	case Always, Never, Default, Request:
+	default:
+		return Error.InvalidParam(path+".returned", "one of always, never, default, request", attr.Returned)
+	}
+
+	switch attr.Uniqueness {
+	case "":
+		attr.Uniqueness = None
+	case None, Server, Global:
+	default:
+		return Error.InvalidParam(path+".uniqueness", "one of none, server, global", attr.Uniqueness)
+	}
+	return nil
+}
+
+// multiValued complex attributes are indexed by value if they have one, otherwise by all their
+// singular string sub attributes except the formatted representation (i.e. addresses)
+func arrayIndexKey(attr *Attribute) []string {
+	if !attr.MultiValued || attr.Type != TypeComplex {
+		return []string{}
+	}
+	for _, subAttr := range attr.SubAttributes {
+		if subAttr.Name == "value" {
+			return []string{"value"}
+		}
+	}
+	keys := make([]string, 0)
+	for _, subAttr := range attr.SubAttributes {
+		if subAttr.Name != "formatted" && subAttr.ExpectsString() {
+			keys = append(keys, subAttr.Name)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,173 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSchemaRegistry_LoadFS(t *testing.T) {
+	registry := NewSchemaRegistry()
+	schemas, err := registry.LoadFS(os.DirFS("../resources/schemas"), "[ug]*[rp].json")
+	require.Nil(t, err)
+	assert.Len(t, schemas, 2)
+
+	user := registry.Get(UserUrn)
+	require.NotNil(t, user)
+	assert.NotNil(t, registry.Get(GroupUrn))
+	assert.Len(t, registry.All(), 2)
+
+	p, err := NewPath("emails.value")
+	require.Nil(t, err)
+	attr := user.GetAttribute(p, true)
+	require.NotNil(t, attr)
+	assert.Equal(t, "emails.value", attr.Assist.Path)
+	assert.Equal(t, UserUrn+":emails.value", attr.Assist.FullPath)
+
+	p, err = NewPath("emails")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"value"}, user.GetAttribute(p, false).Assist.ArrayIndexKey)
+
+	p, err = NewPath("addresses")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"streetAddress", "locality", "region", "postalCode", "country", "type"},
+		user.GetAttribute(p, false).Assist.ArrayIndexKey)
+}
+
+func TestSchemaRegistry_RegisterJSON(t *testing.T) {
+	for _, test := range []struct {
+		definition string
+		assertion  func(sch *Schema, err error)
+	}{
+		{
+			`{"id": "urn:example:Badge", "attributes": [{"name": "issued", "type": "dateTime"}]}`,
+			func(sch *Schema, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, TypeDateTime, sch.Attributes[0].Type)
+				assert.Equal(t, ReadWrite, sch.Attributes[0].Mutability)
+				assert.Equal(t, Default, sch.Attributes[0].Returned)
+				assert.Equal(t, None, sch.Attributes[0].Uniqueness)
+			},
+		},
+		{
+			`{"attributes": []}`,
+			func(sch *Schema, err error) {
+				assert.IsType(t, &InvalidParamError{}, err)
+			},
+		},
+		{
+			`{"id": "urn:example:Badge", "attributes": [{"name": "issued", "type": "timestamp"}]}`,
+			func(sch *Schema, err error) {
+				assert.IsType(t, &InvalidParamError{}, err)
+			},
+		},
+		{
+			`{"id": "urn:example:Badge", "attributes": [{"name": "owner", "type": "complex"}]}`,
+			func(sch *Schema, err error) {
+				assert.IsType(t, &InvalidParamError{}, err)
+			},
+		},
+		{
+			`{"id": "urn:example:Badge", "attributes": [{"name": "a", "type": "string"}, {"name": "A", "type": "string"}]}`,
+			func(sch *Schema, err error) {
+				assert.IsType(t, &InvalidParamError{}, err)
+			},
+		},
+		{
+			`{"id": "urn:example:Badge", "attributes": [{"name": "a", "type": "string", "mutability": "sometimes"}]}`,
+			func(sch *Schema, err error) {
+				assert.IsType(t, &InvalidParamError{}, err)
+			},
+		},
+	} {
+		test.assertion(NewSchemaRegistry().RegisterJSON([]byte(test.definition)))
+	}
+
+	registry := NewSchemaRegistry()
+	_, err := registry.LoadFS(fstest.MapFS{
+		"badge.json": {Data: []byte(`{"id": "urn:example:Badge", "attributes": [{"name": "issued", "type": "dateTime"}]}`)},
+	}, "*.json")
+	assert.Nil(t, err)
+	assert.NotNil(t, registry.Get("urn:example:Badge"))
+}
+
+// run with -race: registering while handlers read is safe until the registry is frozen
+func TestSchemaRegistry_Freeze(t *testing.T) {
+	registry := NewSchemaRegistry()
+	_, err := registry.LoadFile("../resources/schemas/user.json")
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NotNil(t, registry.Get(UserUrn))
+				assert.NotEmpty(t, registry.All())
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			_, err := registry.RegisterJSON([]byte(fmt.Sprintf(`{"id": "urn:example:%d", "attributes": [{"name": "a", "type": "string"}]}`, i)))
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, registry.All(), 5)
+
+	registry.Freeze()
+	assert.True(t, registry.Frozen())
+	_, err = registry.RegisterJSON([]byte(`{"id": "urn:example:late", "attributes": [{"name": "a", "type": "string"}]}`))
+	assert.NotNil(t, err)
+	assert.Nil(t, registry.Get("urn:example:late"))
+	assert.Len(t, registry.All(), 5)
+}
+
+func TestSchema_AddExtension(t *testing.T) {
+	const acmeUrn = "urn:example:params:scim:schemas:extension:acme:2.0:Group"
+	sch, _, err := ParseSchema("../resources/schemas/group_internal.json")
+	require.Nil(t, err)
+	ext, err := NewSchemaRegistry().LoadFile("../resources/tests/group_extension.json")
+	require.Nil(t, err)
+	require.Nil(t, sch.AddExtension(ext, false))
+	require.Nil(t, sch.AddExtension(ext, false))
+	assert.IsType(t, &InvalidParamError{}, sch.AddExtension(&Schema{Id: GroupUrn}, false))
+
+	_, attr, err := CompilePath(acmeUrn+":owners.value", sch)
+	require.Nil(t, err)
+	assert.Equal(t, "owners.value", attr.Assist.Path)
+	assert.Equal(t, acmeUrn+":owners.value", attr.Assist.FullPath)
+
+	ctx := context.Background()
+	group := func(extension interface{}) *Resource {
+		data := Complex{"schemas": []interface{}{GroupUrn, acmeUrn}, "displayName": "admins"}
+		if extension != nil {
+			data[acmeUrn] = extension
+		}
+		return &Resource{Complex: data}
+	}
+	assert.Nil(t, ValidateRequired(group(nil), sch, ctx))
+	assert.Nil(t, ValidateType(group(map[string]interface{}{"costCenter": "4130"}), sch, ctx))
+	assert.IsType(t, &InvalidTypeError{}, ValidateType(group(map[string]interface{}{"costCenter": 4130}), sch, ctx))
+	assert.IsType(t, &MissingRequiredPropertyError{}, ValidateRequired(group(map[string]interface{}{"costCenter": ""}), sch, ctx))
+
+	r := group(map[string]interface{}{"costCenter": "4130"})
+	require.Nil(t, ApplyPatch(Patch{Op: Replace, Path: acmeUrn + ":description", Value: "Administrators"}, r, sch, ctx))
+	require.Nil(t, ApplyPatch(Patch{Op: Add, Path: acmeUrn + ":owners", Value: []interface{}{
+		map[string]interface{}{"value": "2819c223"},
+	}}, r, sch, ctx))
+	require.Nil(t, ApplyPatch(Patch{Op: Remove, Path: acmeUrn + ":costCenter"}, r, sch, ctx))
+	assert.Equal(t, map[string]interface{}{
+		"description": "Administrators",
+		"owners":      []interface{}{map[string]interface{}{"value": "2819c223"}},
+	}, r.Complex[acmeUrn])
+
+	require.Nil(t, sch.AddExtension(ext, true))
+	assert.IsType(t, &MissingRequiredPropertyError{}, ValidateRequired(group(map[string]interface{}{}), sch, ctx))
+}
@@ -0,0 +1,87 @@
+package shared
+
+import (
+	"context"
+	"sync"
+)
+
+// Policies for attributes omitted from the body of a replace (PUT) request
+const (
+	StrictReplace = "strict" // omitted attributes are cleared, as RFC 7644 section 3.5.1 prescribes
+	MergeReplace  = "merge"  // omitted attributes keep their stored value, for legacy clients sending partial resources
+)
+
+// Apply the replace policy to the resource of a replace request against the stored reference. Under the merge
+// policy, every attribute the schema defines that is absent from the resource is copied from the reference;
+// singular complex attributes are merged sub attribute by sub attribute. Attributes given as null, "" or an
+// empty array are cleared under either policy, see IsUnassigned, and removed from the resource. Read only
+// attributes are left to the read only assignment. An empty or unrecognized policy is treated as strict. A
+// policy set on the context, see ReplacePolicy, takes the place of the one given.
+func ApplyReplacePolicy(subj *Resource, ref *Resource, sch *Schema, policy string, ctx context.Context) (err error) {
+	if override, ok := ctx.Value(ReplacePolicy{}).(string); ok && len(override) > 0 {
+		policy = override
+	}
+	if policy != MergeReplace || ref == nil {
+		RemoveUnassigned(subj.Complex)
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case error:
+				err = r.(error)
+			default:
+				err = Error.Text("%v", r)
+			}
+		}
+	}()
+
+	replaceMergeInstance.merge(subj.Complex, ref.Complex, sch.ToAttribute(), ctx)
+	RemoveUnassigned(subj.Complex)
+
+	err = nil
+	return
+}
+
+type replaceMerge struct{}
+
+func (rm *replaceMerge) merge(subj, ref map[string]interface{}, guide *Attribute, ctx context.Context) {
+	for _, attr := range guide.SubAttributes {
+		if attr.Mutability == ReadOnly {
+			continue
+		}
+
+		_, refV, refPresent := entryByName(ref, attr.Name)
+		if !refPresent {
+			continue
+		}
+
+		// given, even as null, the attribute is meant to be replaced, or cleared
+		k, v, present := entryByName(subj, attr.Name)
+		if !present {
+			subj[attr.Name] = deepCopy(refV)
+			continue
+		}
+
+		if attr.Type == TypeComplex && !attr.MultiValued {
+			sm, ok1 := v.(map[string]interface{})
+			refM, ok2 := refV.(map[string]interface{})
+			if ok1 && ok2 && len(sm) > 0 {
+				rm.merge(sm, refM, attr, ctx)
+				subj[k] = sm
+			}
+		}
+	}
+}
+
+var (
+	singleReplaceMerge   sync.Once
+	replaceMergeInstance *replaceMerge
+)
+
+func init() {
+	singleReplaceMerge.Do(func() {
+		replaceMergeInstance = &replaceMerge{}
+	})
+}
@@ -0,0 +1,75 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestApplyReplacePolicy(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	for _, test := range []struct {
+		policy    string
+		assertion func(r *Resource, err error)
+	}{
+		{
+			StrictReplace,
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				assert.NotContains(t, r.Complex, "emails")
+				assert.NotContains(t, r.Complex["name"], "givenName")
+			},
+		},
+		{
+			MergeReplace,
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				assert.Len(t, r.Complex["emails"], 2)
+				assert.Equal(t, "David", r.Complex["name"].(map[string]interface{})["givenName"])
+				assert.Equal(t, "Q", r.Complex["name"].(map[string]interface{})["familyName"])
+				assert.Equal(t, "Dave", r.Complex["displayName"])
+			},
+		},
+	} {
+		ref, _, err := ParseResource("../resources/tests/user_1.json")
+		require.Nil(t, err)
+
+		r := &Resource{Complex: Complex{
+			"schemas":     []interface{}{UserUrn},
+			"id":          ref.GetId(),
+			"userName":    "david@example.com",
+			"displayName": "Dave",
+			"name":        map[string]interface{}{"familyName": "Q"},
+		}}
+		test.assertion(r, ApplyReplacePolicy(r, ref, sch, test.policy, context.Background()))
+	}
+}
+
+func TestApplyReplacePolicy_Unassigned(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	for _, policy := range []string{StrictReplace, MergeReplace} {
+		ref, _, err := ParseResource("../resources/tests/user_1.json")
+		require.Nil(t, err)
+
+		r := &Resource{Complex: Complex{
+			"schemas":     []interface{}{UserUrn},
+			"id":          ref.GetId(),
+			"userName":    "david@example.com",
+			"displayName": nil,
+			"nickName":    "",
+			"emails":      []interface{}{},
+			"name":        map[string]interface{}{"givenName": nil, "familyName": "Q"},
+		}}
+		require.Nil(t, ApplyReplacePolicy(r, ref, sch, policy, context.Background()))
+		assert.NotContains(t, r.Complex, "displayName", policy)
+		assert.NotContains(t, r.Complex, "nickName", policy)
+		assert.NotContains(t, r.Complex, "emails", policy)
+		assert.NotContains(t, r.Complex["name"], "givenName", policy)
+		assert.Equal(t, "Q", r.Complex["name"].(map[string]interface{})["familyName"], policy)
+	}
+}
@@ -0,0 +1,224 @@
+package shared
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Policies to pick the read replica serving a read
+const (
+	RoundRobinReplicas = "roundRobin" // take turns
+	LatencyReplicas    = "latency"    // prefer the replica that answered fastest recently
+)
+
+// Returns a repository that sends Create, Update and Delete to the primary and spreads Get, GetAll, Count
+// and Search over the replicas according to the policy. Without replicas, everything goes to the primary.
+//
+// A replica failing with a transient error, see IsTransientError, is left out for replicaRetryInterval and
+// then read from again; with the latency policy, so is a replica that was not read from for that long, so
+// that one slow for a while is probed again rather than left out for good. Reads go to the primary while all
+// replicas are left out.
+//
+// Replicas lag behind the primary. When readYourWrites is positive, the reads of a principal (the Principal
+// context value) go to the primary for that long after the principal's last mutation, so that clients see
+// their own changes. Requests without a principal are never pinned.
+func NewCompositeRepository(primary Repository, replicas []Repository, policy string, readYourWrites time.Duration) Repository {
+	return &compositeRepository{
+		primary:        primary,
+		replicas:       replicas,
+		policy:         policy,
+		readYourWrites: readYourWrites,
+		latencies:      make([]time.Duration, len(replicas)),
+		observed:       make([]time.Time, len(replicas)),
+		down:           make([]time.Time, len(replicas)),
+		pinned:         make(map[string]time.Time),
+		now:            time.Now,
+	}
+}
+
+// number of pinned principals after which expired pins are dropped
+const maxPinnedPrincipals = 10000
+
+// how long a replica that failed is left out of reads, and how long one is not read from before it is probed
+const replicaRetryInterval = 30 * time.Second
+
+// weight of the latest observation in the moving average of replica latencies
+const latencyWeight = 0.2
+
+type compositeRepository struct {
+	sync.Mutex
+	primary        Repository
+	replicas       []Repository
+	policy         string
+	readYourWrites time.Duration
+	next           int
+	latencies      []time.Duration
+	observed       []time.Time // of the latest latency of each replica, zero if none yet
+	down           []time.Time // until when each replica is left out
+	pinned         map[string]time.Time
+	now            func() time.Time
+}
+
+// pick the repository to read from along with a callback to report the outcome of the read
+func (r *compositeRepository) reader(ctx context.Context) (Repository, func(start time.Time, err *error)) {
+	r.Lock()
+	defer r.Unlock()
+
+	none := func(start time.Time, err *error) {}
+	if len(r.replicas) == 0 {
+		return r.primary, none
+	}
+	if principal, ok := ctx.Value(Principal{}).(string); ok && len(principal) > 0 {
+		if until, ok := r.pinned[principal]; ok && r.now().Before(until) {
+			return r.primary, none
+		}
+	}
+
+	now := r.now()
+	i := -1
+	switch r.policy {
+	case LatencyReplicas:
+		for j, latency := range r.latencies {
+			if now.Before(r.down[j]) {
+				continue
+			}
+			if !r.observed[j].IsZero() && now.Sub(r.observed[j]) >= replicaRetryInterval {
+				i = j
+				break
+			}
+			if i < 0 || latency < r.latencies[i] {
+				i = j
+			}
+		}
+	default:
+		for range r.replicas {
+			j := r.next
+			r.next = (r.next + 1) % len(r.replicas)
+			if !now.Before(r.down[j]) {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 {
+		return r.primary, none
+	}
+	return r.replicas[i], func(start time.Time, err *error) { r.observe(i, r.now().Sub(start), *err) }
+}
+
+// record the latency of a read from the replica, or leave the replica out for a while if it failed
+func (r *compositeRepository) observe(i int, latency time.Duration, err error) {
+	r.Lock()
+	defer r.Unlock()
+	if err != nil && IsTransientError(err) {
+		r.down[i] = r.now().Add(replicaRetryInterval)
+		return
+	}
+	r.observed[i] = r.now()
+	if r.latencies[i] == 0 {
+		r.latencies[i] = latency
+	} else {
+		r.latencies[i] = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(r.latencies[i]))
+	}
+}
+
+// pin the reads of the principal of a successful mutation to the primary
+func (r *compositeRepository) wrote(err error, ctx context.Context) error {
+	if err != nil || r.readYourWrites <= 0 {
+		return err
+	}
+	principal, ok := ctx.Value(Principal{}).(string)
+	if !ok || len(principal) == 0 {
+		return nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	now := r.now()
+	if len(r.pinned) >= maxPinnedPrincipals {
+		for k, until := range r.pinned {
+			if !now.Before(until) {
+				delete(r.pinned, k)
+			}
+		}
+	}
+	r.pinned[principal] = now.Add(r.readYourWrites)
+	return nil
+}
+
+func (r *compositeRepository) Create(provider DataProvider, ctx context.Context) error {
+	return r.wrote(r.primary.Create(provider, ctx), ctx)
+}
+
+func (r *compositeRepository) Get(id, version string, ctx context.Context) (dp DataProvider, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return repo.Get(id, version, ctx)
+}
+
+func (r *compositeRepository) Exists(id, version string, ctx context.Context) (exists bool, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return ResourceExists(repo, id, version, ctx)
+}
+
+func (r *compositeRepository) CountByIds(ids []string, ctx context.Context) (count int, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return CountExisting(repo, ids, ctx)
+}
+
+func (r *compositeRepository) GetAll(ctx context.Context) (all []Complex, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return repo.GetAll(ctx)
+}
+
+func (r *compositeRepository) Count(query string, ctx context.Context) (count int, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return repo.Count(query, ctx)
+}
+
+func (r *compositeRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return r.wrote(r.primary.Update(id, version, provider, ctx), ctx)
+}
+
+func (r *compositeRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	return r.wrote(memberPatcherOf(r.primary).PatchMembers(id, version, adds, removes, meta, ctx), ctx)
+}
+
+// only the primary is written to
+func (r *compositeRepository) decorated() []Repository {
+	return []Repository{r.primary}
+}
+
+func (r *compositeRepository) Delete(id, version string, ctx context.Context) error {
+	return r.wrote(r.primary.Delete(id, version, ctx), ctx)
+}
+
+func (r *compositeRepository) Search(payload SearchRequest, ctx context.Context) (lr *ListResponse, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return repo.Search(payload, ctx)
+}
+
+// The primary and every replica must be reachable, as reads may be sent to any of them
+func (r *compositeRepository) Ping(ctx context.Context) error {
+	if err := r.primary.Ping(ctx); err != nil {
+		return err
+	}
+	for _, replica := range r.replicas {
+		if err := replica.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *compositeRepository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) (values []interface{}, total int, err error) {
+	repo, observe := r.reader(ctx)
+	defer observe(r.now(), &err)
+	return SliceAttribute(repo, id, attribute, startIndex, count, ctx)
+}
@@ -0,0 +1,109 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCompositeRepository(t *testing.T) {
+	primary := NewMapRepository(nil)
+	replicaA := NewMapRepository(map[string]DataProvider{"a": &Resource{Complex: Complex{"id": "a"}}})
+	replicaB := NewMapRepository(map[string]DataProvider{"b": &Resource{Complex: Complex{"id": "b"}}})
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := NewCompositeRepository(primary, []Repository{replicaA, replicaB}, RoundRobinReplicas, time.Minute).(*compositeRepository)
+	repo.now = func() time.Time { return now }
+
+	ctx := context.WithValue(context.Background(), Principal{}, "okta")
+	other := context.WithValue(context.Background(), Principal{}, "azure")
+
+	// reads take turns over the replicas
+	_, err := repo.Get("a", "", ctx)
+	assert.Nil(t, err)
+	_, err = repo.Get("b", "", ctx)
+	assert.Nil(t, err)
+
+	// writes go to the primary
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "c"}}, ctx))
+	_, err = primary.Get("c", "", ctx)
+	assert.Nil(t, err)
+	_, err = replicaA.Get("c", "", ctx)
+	assert.NotNil(t, err)
+
+	// the writer reads its own write from the primary, others keep reading from the replicas
+	_, err = repo.Get("c", "", ctx)
+	assert.Nil(t, err)
+	_, err = repo.Get("a", "", other)
+	assert.Nil(t, err)
+	_, err = repo.Get("c", "", other)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+
+	now = now.Add(time.Minute)
+	_, err = repo.Get("c", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+}
+
+func TestCompositeRepository_Latency(t *testing.T) {
+	replicaA := NewMapRepository(map[string]DataProvider{"a": &Resource{Complex: Complex{"id": "a"}}})
+	replicaB := NewMapRepository(map[string]DataProvider{"b": &Resource{Complex: Complex{"id": "b"}}})
+	repo := NewCompositeRepository(NewMapRepository(nil), []Repository{replicaA, replicaB}, LatencyReplicas, 0).(*compositeRepository)
+	repo.latencies = []time.Duration{20 * time.Millisecond, 5 * time.Millisecond}
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return now }
+
+	_, err := repo.Get("b", "", context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 4*time.Millisecond, repo.latencies[1])
+	repo.observe(1, 100*time.Millisecond, nil)
+	assert.Equal(t, 23200*time.Microsecond, repo.latencies[1])
+	_, err = repo.Get("a", "", context.Background())
+	assert.Nil(t, err)
+
+	// a replica not read from for a while is probed again
+	now = now.Add(replicaRetryInterval)
+	repo.observed[0] = now
+	_, err = repo.Get("b", "", context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, now, repo.observed[1])
+}
+
+func TestCompositeRepository_Failure(t *testing.T) {
+	primary := NewMapRepository(map[string]DataProvider{"p": &Resource{Complex: Complex{"id": "p"}}})
+	replica := &failingReplica{Repository: NewMapRepository(map[string]DataProvider{"r": &Resource{Complex: Complex{"id": "r"}}}), err: io.EOF}
+	for _, policy := range []string{RoundRobinReplicas, LatencyReplicas} {
+		repo := NewCompositeRepository(primary, []Repository{replica}, policy, 0).(*compositeRepository)
+		now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		repo.now = func() time.Time { return now }
+
+		// a replica failing is left out, reads go to the primary meanwhile
+		replica.failing = true
+		_, err := repo.Get("r", "", context.Background())
+		assert.Equal(t, io.EOF, err, policy)
+		_, err = repo.Get("p", "", context.Background())
+		assert.Nil(t, err, policy)
+
+		// and tried again after the retry interval
+		replica.failing = false
+		now = now.Add(replicaRetryInterval)
+		_, err = repo.Get("r", "", context.Background())
+		assert.Nil(t, err, policy)
+	}
+}
+
+type failingReplica struct {
+	Repository
+	err     error
+	failing bool
+}
+
+func (r *failingReplica) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	if r.failing {
+		return nil, r.err
+	}
+	return r.Repository.Get(id, version, ctx)
+}
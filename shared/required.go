@@ -18,6 +18,9 @@ func ValidateRequired(subj *Resource, sch *Schema, ctx context.Context) (err err
 		}
 	}()
 
+	if warnings := LenientWarnings(ctx); warnings != nil {
+		(&lenientRequired{warnings: warnings}).repair(subj.Complex, sch.ToAttribute())
+	}
 	requiredValidatorInstance.validateRequiredWithReflection(reflect.ValueOf(subj.Complex), sch.ToAttribute(), ctx)
 
 	err = nil
@@ -74,13 +77,10 @@ func (rv *requiredValidator) validateRequiredWithReflection(v reflect.Value, att
 	case reflect.Map:
 		rv.checkValue(v, attr, ctx)
 		for _, k := range v.MapKeys() {
-			p, err := NewPath(k.String())
-			if err != nil {
-				rv.throw(err, ctx)
-			}
-			subAttr := attr.GetAttribute(p, false)
+			// matched by name, so that extension namespaces are found as a whole
+			subAttr := attr.SubAttribute(k.String())
 			if subAttr == nil {
-				rv.throw(Error.NoAttribute(p.Value()), ctx)
+				rv.throw(Error.NoAttribute(k.String()), ctx)
 			}
 			rv.validateRequiredWithReflection(v.MapIndex(k), subAttr, ctx)
 		}
@@ -0,0 +1,297 @@
+package shared
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Stores resources by id for NewCachingRepository. An implementation backed by a shared store, i.e. Redis,
+// lets the instances of a server share one cache; it must then hand out resources the way they were put,
+// with meta.version intact. A Get that fails is taken for a miss, and a Put that fails for a Remove.
+type ResourceCache interface {
+	Get(id string, ctx context.Context) (Complex, bool, error)
+	Put(id string, resource Complex, ctx context.Context) error
+	Remove(id string, ctx context.Context) error
+}
+
+// Returns an in memory ResourceCache holding up to capacity resources, least recently used first to go, each
+// for at most ttl so that changes made around the cache, i.e. by another instance, are picked up eventually.
+// A ttl that is not positive keeps resources until they are evicted.
+func NewLRUResourceCache(capacity int, ttl time.Duration) ResourceCache {
+	return &lruResourceCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+type lruResourceCache struct {
+	sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // front is the most recently used
+	now      func() time.Time
+}
+
+type cachedResource struct {
+	id       string
+	resource Complex
+	expires  time.Time
+}
+
+func (c *lruResourceCache) Get(id string, ctx context.Context) (Complex, bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*cachedResource)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, id)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.resource, true, nil
+}
+
+func (c *lruResourceCache) Put(id string, resource Complex, ctx context.Context) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.capacity <= 0 {
+		return nil
+	}
+	entry := &cachedResource{id: id, resource: resource, expires: c.now().Add(c.ttl)}
+	if element, ok := c.entries[id]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[id] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResource).id)
+	}
+	return nil
+}
+
+func (c *lruResourceCache) Remove(id string, ctx context.Context) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+	return nil
+}
+
+// Decorates a repository so that Get is served from the cache, which holds the resources last read, created
+// or updated through the decorator. Updates replace the cached resource with the new one, so that the GET
+// identity providers send after a PATCH does not reach the database, and deletes remove it. A Get for a
+// version, i.e. from If-None-Match, is served from the cache only when the cached resource has that version.
+// Lookups are counted on the metrics, which may be nil, by whether they hit, missed, found a stale version or
+// failed.
+//
+// Resources are copied in and out of the cache, since handlers modify the resources they get. A Get filling
+// the cache and the writes of the same resource take turns, so that a Get reading the resource before a
+// concurrent Update does not put it in the cache after the Update did. Search, Count and GetAll are passed on
+// as they are.
+func NewCachingRepository(repo Repository, cache ResourceCache, resourceType string, metrics *Metrics) Repository {
+	r := &cachingRepository{repo: repo, cache: cache, resourceType: resourceType, lookups: noOpMetric{}}
+	if metrics != nil && metrics.ResourceCacheLookups != nil {
+		r.lookups = metrics.ResourceCacheLookups
+	}
+	return r
+}
+
+// the number of locks the ids of a caching repository are spread over
+const cacheLockStripes = 64
+
+type cachingRepository struct {
+	repo         Repository
+	cache        ResourceCache
+	resourceType string
+	lookups      Counter
+	locks        [cacheLockStripes]sync.Mutex
+}
+
+// the lock serializing the cache fills and writes of the resource of the id
+func (r *cachingRepository) lock(id string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &r.locks[h.Sum32()%cacheLockStripes]
+}
+
+// the cached resource, if any, a cache that fails counted like in Get
+func (r *cachingRepository) cached(id string, ctx context.Context) (Complex, bool) {
+	cached, ok, err := r.cache.Get(id, ctx)
+	if err != nil {
+		r.lookups.Inc(r.resourceType, "error")
+		return nil, false
+	}
+	return cached, ok
+}
+
+// cache the resource, or drop the cached one when that fails, so that it is not served after it changed
+func (r *cachingRepository) put(id string, data Complex, ctx context.Context) error {
+	if len(id) == 0 {
+		return nil
+	}
+	if err := r.cache.Put(id, copyComplex(data), ctx); err != nil {
+		return r.cache.Remove(id, ctx)
+	}
+	return nil
+}
+
+func (r *cachingRepository) Create(provider DataProvider, ctx context.Context) error {
+	l := r.lock(provider.GetId())
+	l.Lock()
+	defer l.Unlock()
+
+	if err := r.repo.Create(provider, ctx); err != nil {
+		return err
+	}
+	// the resource is new, nothing stale is left behind when it is not cached
+	r.put(provider.GetId(), provider.GetData(), ctx)
+	return nil
+}
+
+func (r *cachingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	cached, ok, err := r.cache.Get(id, ctx)
+	switch {
+	case err != nil:
+		r.lookups.Inc(r.resourceType, "error")
+	case !ok:
+		r.lookups.Inc(r.resourceType, "miss")
+	case len(version) == 0 || resourceVersion(cached) == version:
+		r.lookups.Inc(r.resourceType, "hit")
+		return &Resource{Complex: copyComplex(cached)}, nil
+	default:
+		r.lookups.Inc(r.resourceType, "stale")
+	}
+
+	l := r.lock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	dp, err := r.repo.Get(id, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a Get failing to fill the cache leaves the resource uncached, which the next Get retries
+	r.put(id, dp.GetData(), ctx)
+	return dp, nil
+}
+
+// a resource cached at the version exists as Get would find it there, others are asked for
+func (r *cachingRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	if cached, ok := r.cached(id, ctx); ok && (len(version) == 0 || resourceVersion(cached) == version) {
+		return true, nil
+	}
+	return ResourceExists(r.repo, id, version, ctx)
+}
+
+func (r *cachingRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	return CountExisting(r.repo, ids, ctx)
+}
+
+// a page of a cached resource is sliced from the cache, others are asked for
+func (r *cachingRepository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	if cached, ok := r.cached(id, ctx); ok {
+		all, _ := copyComplex(cached)[attribute].([]interface{})
+		return SliceValues(all, startIndex, count), len(all), nil
+	}
+	return SliceAttribute(r.repo, id, attribute, startIndex, count, ctx)
+}
+
+func (r *cachingRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	return r.repo.GetAll(ctx)
+}
+
+func (r *cachingRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *cachingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	l := r.lock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	if err := r.repo.Update(id, version, provider, ctx); err != nil {
+		// whether the stored resource changed is unknown
+		if removeErr := r.cache.Remove(id, ctx); removeErr != nil {
+			return removeErr
+		}
+		return err
+	}
+	return r.put(id, provider.GetData(), ctx)
+}
+
+func (r *cachingRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	l := r.lock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	// the group is not read back, the next Get fills the cache
+	err := memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+	if removeErr := r.cache.Remove(id, ctx); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+func (r *cachingRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *cachingRepository) Delete(id, version string, ctx context.Context) error {
+	l := r.lock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	err := r.repo.Delete(id, version, ctx)
+	if removeErr := r.cache.Remove(id, ctx); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+// the cached resources carry no indexes and stay as they are
+func (r *cachingRepository) ReindexUnique(ctx context.Context) error {
+	return r.repo.(UniqueIndexer).ReindexUnique(ctx)
+}
+
+// purged resources were deleted, which removed them from the cache
+func (r *cachingRepository) PurgeDeleted(before time.Time, ctx context.Context) (int, error) {
+	return r.repo.(DeletedPurger).PurgeDeleted(before, ctx)
+}
+
+func (r *cachingRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	return r.repo.Search(payload, ctx)
+}
+
+func (r *cachingRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+func copyComplex(data Complex) Complex {
+	return Complex(deepCopy(map[string]interface{}(data)).(map[string]interface{}))
+}
+
+// the meta.version of the resource, empty if it has none
+func resourceVersion(data Complex) string {
+	meta, _ := data["meta"].(map[string]interface{})
+	version, _ := meta["version"].(string)
+	return version
+}
@@ -0,0 +1,202 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCachingRepository(t *testing.T) {
+	registerer := &recordingRegisterer{counts: map[string]int{}, observations: map[string]int{}}
+	metrics := NewMetrics(registerer)
+	ctx := context.Background()
+
+	// the instrumented repository counts the calls that reach the database
+	database := NewInstrumentedRepository(NewMapRepository(nil), UserResourceType, metrics)
+	repo := NewCachingRepository(database, NewLRUResourceCache(10, time.Minute), UserResourceType, metrics)
+
+	user := &Resource{Complex: Complex{
+		"id":       "a",
+		"userName": "david",
+		"meta":     map[string]interface{}{"version": "v1"},
+	}}
+	require.Nil(t, repo.Create(user, ctx))
+
+	dp, err := repo.Get("a", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "david", dp.GetData()["userName"])
+	assert.Equal(t, 0, registerer.observations[MetricRepositoryDuration+"|User|get"])
+
+	// handlers modify what they get, which must not reach the cache
+	dp.GetData()["userName"] = "changed"
+	dp.GetData()["meta"].(map[string]interface{})["version"] = "changed"
+	dp, err = repo.Get("a", "v1", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "david", dp.GetData()["userName"])
+
+	// the GET after a PATCH is served from the cache
+	updated := &Resource{Complex: Complex{
+		"id":       "a",
+		"userName": "dq",
+		"meta":     map[string]interface{}{"version": "v2"},
+	}}
+	require.Nil(t, repo.Update("a", "v1", updated, ctx))
+	dp, err = repo.Get("a", "v2", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "dq", dp.GetData()["userName"])
+	assert.Equal(t, 0, registerer.observations[MetricRepositoryDuration+"|User|get"])
+	assert.Equal(t, 3, registerer.counts[MetricResourceCacheLookups+"|User|hit"])
+
+	// another version is looked up in the database
+	_, err = repo.Get("a", "v1", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+	assert.Equal(t, 1, registerer.observations[MetricRepositoryDuration+"|User|get"])
+	assert.Equal(t, 1, registerer.counts[MetricResourceCacheLookups+"|User|stale"])
+
+	require.Nil(t, repo.Delete("a", "", ctx))
+	_, err = repo.Get("a", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+	assert.Equal(t, 1, registerer.counts[MetricResourceCacheLookups+"|User|miss"])
+}
+
+// a cache failing every call
+type failingResourceCache struct{}
+
+func (failingResourceCache) Get(id string, ctx context.Context) (Complex, bool, error) {
+	return nil, false, errors.New("unreachable")
+}
+
+func (failingResourceCache) Put(id string, resource Complex, ctx context.Context) error {
+	return errors.New("unreachable")
+}
+
+func (failingResourceCache) Remove(id string, ctx context.Context) error {
+	return errors.New("unreachable")
+}
+
+func TestCachingRepository_FailingCache(t *testing.T) {
+	registerer := &recordingRegisterer{counts: map[string]int{}, observations: map[string]int{}}
+	ctx := context.Background()
+	repo := NewCachingRepository(NewMapRepository(nil), failingResourceCache{}, UserResourceType, NewMetrics(registerer))
+
+	// reads pass the cache by
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "a", "meta": map[string]interface{}{"version": "v1"}}}, ctx))
+	dp, err := repo.Get("a", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "a", dp.GetId())
+	assert.Equal(t, 1, registerer.counts[MetricResourceCacheLookups+"|User|error"])
+
+	// writes that may leave a stale resource cached fail
+	assert.NotNil(t, repo.Update("a", "v1", &Resource{Complex: Complex{"id": "a", "meta": map[string]interface{}{"version": "v2"}}}, ctx))
+	assert.NotNil(t, repo.Delete("a", "", ctx))
+}
+
+// a repository whose Get returns the resource read before the first Update, after the Update finished
+type racingRepository struct {
+	Repository
+	read    chan struct{}
+	updated chan struct{}
+}
+
+func (r *racingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	dp, err := r.Repository.Get(id, version, ctx)
+	if r.read != nil {
+		read := r.read
+		r.read = nil
+		close(read)
+		select {
+		case <-r.updated:
+		case <-time.After(100 * time.Millisecond): // the Update waits for the Get
+		}
+	}
+	return dp, err
+}
+
+func (r *racingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	defer close(r.updated)
+	return r.Repository.Update(id, version, provider, ctx)
+}
+
+func TestCachingRepository_ConcurrentUpdate(t *testing.T) {
+	ctx := context.Background()
+	database := &racingRepository{
+		Repository: NewMapRepository(map[string]DataProvider{
+			"a": &Resource{Complex: Complex{"id": "a", "meta": map[string]interface{}{"version": "v1"}}},
+		}),
+		read:    make(chan struct{}),
+		updated: make(chan struct{}),
+	}
+	repo := NewCachingRepository(database, NewLRUResourceCache(10, time.Minute), UserResourceType, nil)
+
+	read := database.read
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		repo.Get("a", "", ctx)
+	}()
+	<-read
+	require.Nil(t, repo.Update("a", "v1", &Resource{Complex: Complex{"id": "a", "meta": map[string]interface{}{"version": "v2"}}}, ctx))
+	<-done
+
+	// the Get read v1, but the Update cached v2 after it
+	dp, err := repo.Get("a", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "v2", resourceVersion(dp.GetData()))
+}
+
+func TestCachingRepository_GetSlice(t *testing.T) {
+	ctx := context.Background()
+	database := NewInstrumentedRepository(NewMapRepository(map[string]DataProvider{
+		"a": &Resource{Complex: Complex{"id": "a", "members": []interface{}{"u1", "u2", "u3"}}},
+	}), GroupResourceType, NewMetrics(nil))
+	repo := NewCachingRepository(database, NewLRUResourceCache(10, time.Minute), GroupResourceType, nil)
+
+	values, total, err := SliceAttribute(repo, "a", "members", 2, 1, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"u2"}, values)
+	assert.Equal(t, 3, total)
+
+	// served from the cache once the group was read
+	values, total, err = SliceAttribute(repo, "a", "members", 3, 5, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"u3"}, values)
+	assert.Equal(t, 3, total)
+
+	// the map repository neither indexes nor purges, which the cache does not hide
+	_, ok := AsUniqueIndexer(repo)
+	assert.False(t, ok)
+	_, ok = AsDeletedPurger(repo)
+	assert.False(t, ok)
+}
+
+func TestLRUResourceCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cache := NewLRUResourceCache(2, time.Minute).(*lruResourceCache)
+	cache.now = func() time.Time { return now }
+
+	require.Nil(t, cache.Put("a", Complex{"id": "a"}, ctx))
+	require.Nil(t, cache.Put("b", Complex{"id": "b"}, ctx))
+	_, ok, err := cache.Get("a", ctx) // "a" is now the most recently used
+	require.Nil(t, err)
+	assert.True(t, ok)
+	require.Nil(t, cache.Put("c", Complex{"id": "c"}, ctx))
+
+	_, ok, _ = cache.Get("b", ctx)
+	assert.False(t, ok)
+	_, ok, _ = cache.Get("a", ctx)
+	assert.True(t, ok)
+
+	require.Nil(t, cache.Remove("a", ctx))
+	_, ok, _ = cache.Get("a", ctx)
+	assert.False(t, ok)
+
+	// entries expire after the ttl
+	now = now.Add(time.Minute)
+	_, ok, _ = cache.Get("c", ctx)
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.order.Len())
+}
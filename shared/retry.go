@@ -0,0 +1,248 @@
+package shared
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Decides whether a repository error is transient, i.e. a dropped connection, so that the call is worth
+// retrying and counts against the circuit breaker
+type ErrorClassifier func(err error) bool
+
+// The default ErrorClassifier: timeouts, network errors and connections closed mid response are transient.
+// Errors of this package other than timeouts, such as ResourceNotFoundError, are not.
+func IsTransientError(err error) bool {
+	switch err.(type) {
+	case *TimeoutError:
+		return true
+	case net.Error:
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// How a repository call failing with a transient error is retried. The delay before each retry doubles,
+// starting at BaseDelay, and is drawn at random from its upper half so that retrying requests spread out.
+type RetryPolicy struct {
+	MaxAttempts int             // attempts including the first one, no retries if less than 2
+	BaseDelay   time.Duration   // delay before the first retry
+	MaxDelay    time.Duration   // upper bound of the delay, unbounded if not positive
+	RetryWrites bool            // also retry Create, Update and Delete, which may then apply twice
+	Classifier  ErrorClassifier // IsTransientError if nil
+}
+
+// Returns a circuit breaker that opens after threshold consecutive transient failures. While open, calls are
+// rejected right away for the cooldown; then a single trial call is let through, closing the circuit when it
+// succeeds and opening it again when it fails or panics. A trial call that has not returned after another
+// cooldown no longer holds up the next one.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Protects a database from requests piling up while it is unavailable, see NewCircuitBreaker. A nil circuit
+// breaker never opens. Repositories of the same database should share one.
+type CircuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int       // consecutive transient failures
+	openUntil time.Time // while failures reached the threshold
+	trial     time.Time // when the trial call in flight was let through, zero if none
+	now       func() time.Time
+}
+
+// Decide whether a call may proceed. When it may not, retryAfter hints when the circuit is tried again.
+func (b *CircuitBreaker) Allow() (allowed bool, retryAfter time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true, 0
+	}
+	if now := b.now(); now.Before(b.openUntil) {
+		return false, b.openUntil.Sub(now)
+	}
+	if now := b.now(); !b.trial.IsZero() && now.Before(b.trial.Add(b.cooldown)) {
+		return false, b.trial.Add(b.cooldown).Sub(now)
+	}
+	b.trial = b.now()
+	return true, 0
+}
+
+// Record the outcome of a call that was allowed to proceed
+func (b *CircuitBreaker) Record(transientFailure bool) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = time.Time{}
+	if !transientFailure {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// Decorates a repository so that calls failing with a transient error are retried according to the policy,
+// and calls are rejected with UnavailableError, answered with 503 Service Unavailable and Retry-After, while
+// the circuit breaker is open. The breaker may be nil. Retries give up once the context is done.
+func NewRetryingRepository(repo Repository, policy RetryPolicy, breaker *CircuitBreaker) Repository {
+	if policy.Classifier == nil {
+		policy.Classifier = IsTransientError
+	}
+	return &retryingRepository{repo: repo, policy: policy, breaker: breaker}
+}
+
+type retryingRepository struct {
+	repo    Repository
+	policy  RetryPolicy
+	breaker *CircuitBreaker
+}
+
+func (r *retryingRepository) do(write bool, ctx context.Context, call func() error) error {
+	attempts := r.policy.MaxAttempts
+	if attempts < 1 || (write && !r.policy.RetryWrites) {
+		attempts = 1
+	}
+
+	delay := r.policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		if allowed, retryAfter := r.breaker.Allow(); !allowed {
+			return Error.Unavailable(retryAfter)
+		}
+		transient, err := r.attempt(ctx, call)
+		if !transient || attempt >= attempts {
+			return err
+		}
+
+		wait := delay
+		if wait > 1 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+		if r.policy.MaxDelay > 0 && delay > r.policy.MaxDelay {
+			delay = r.policy.MaxDelay
+		}
+	}
+}
+
+// make the call and record its outcome with the breaker, a call that panics as a transient failure, so that a
+// trial call always ends the trial
+func (r *retryingRepository) attempt(ctx context.Context, call func() error) (transient bool, err error) {
+	transient = true
+	defer func() {
+		r.breaker.Record(transient)
+	}()
+	err = call()
+	// a request running out of time says nothing about the database
+	transient = err != nil && ctx.Err() == nil && r.policy.Classifier(err)
+	return
+}
+
+func (r *retryingRepository) Create(provider DataProvider, ctx context.Context) error {
+	return r.do(true, ctx, func() error {
+		return r.repo.Create(provider, ctx)
+	})
+}
+
+func (r *retryingRepository) Get(id, version string, ctx context.Context) (dp DataProvider, err error) {
+	err = r.do(false, ctx, func() (err error) {
+		dp, err = r.repo.Get(id, version, ctx)
+		return
+	})
+	return
+}
+
+func (r *retryingRepository) Exists(id, version string, ctx context.Context) (exists bool, err error) {
+	err = r.do(false, ctx, func() (err error) {
+		exists, err = ResourceExists(r.repo, id, version, ctx)
+		return
+	})
+	return
+}
+
+func (r *retryingRepository) CountByIds(ids []string, ctx context.Context) (count int, err error) {
+	err = r.do(false, ctx, func() (err error) {
+		count, err = CountExisting(r.repo, ids, ctx)
+		return
+	})
+	return
+}
+
+func (r *retryingRepository) GetAll(ctx context.Context) (all []Complex, err error) {
+	err = r.do(false, ctx, func() (err error) {
+		all, err = r.repo.GetAll(ctx)
+		return
+	})
+	return
+}
+
+func (r *retryingRepository) Count(query string, ctx context.Context) (count int, err error) {
+	err = r.do(false, ctx, func() (err error) {
+		count, err = r.repo.Count(query, ctx)
+		return
+	})
+	return
+}
+
+func (r *retryingRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return r.do(true, ctx, func() error {
+		return r.repo.Update(id, version, provider, ctx)
+	})
+}
+
+func (r *retryingRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	return r.do(true, ctx, func() error {
+		return memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+	})
+}
+
+func (r *retryingRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *retryingRepository) Delete(id, version string, ctx context.Context) error {
+	return r.do(true, ctx, func() error {
+		return r.repo.Delete(id, version, ctx)
+	})
+}
+
+func (r *retryingRepository) Search(payload SearchRequest, ctx context.Context) (lr *ListResponse, err error) {
+	err = r.do(false, ctx, func() (err error) {
+		lr, err = r.repo.Search(payload, ctx)
+		return
+	})
+	return
+}
+
+func (r *retryingRepository) SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	return r.repo.(SearchOrderer).SearchOrder(sortBy, ascending)
+}
+
+func (r *retryingRepository) ReindexUnique(ctx context.Context) error {
+	return r.do(true, ctx, func() error {
+		return r.repo.(UniqueIndexer).ReindexUnique(ctx)
+	})
+}
+
+// pings bypass the circuit breaker, so that readiness probes report the database as it is
+func (r *retryingRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
@@ -0,0 +1,140 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+// fails the first calls with the given errors
+type flakyRepository struct {
+	Repository
+	errs  []error
+	calls int
+}
+
+func (r *flakyRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	r.calls++
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return nil, err
+	}
+	return &Resource{Complex: Complex{"id": id}}, nil
+}
+
+func (r *flakyRepository) Delete(id, version string, ctx context.Context) error {
+	r.calls++
+	return io.EOF
+}
+
+func TestRetryingRepository(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	// transient errors are retried
+	flaky := &flakyRepository{errs: []error{io.EOF, Error.Timeout("the database")}}
+	dp, err := NewRetryingRepository(flaky, policy, nil).Get("foo", "", ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", dp.GetId())
+	assert.Equal(t, 3, flaky.calls)
+
+	// others are not
+	flaky = &flakyRepository{errs: []error{Error.ResourceNotFound("foo", "")}}
+	_, err = NewRetryingRepository(flaky, policy, nil).Get("foo", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+	assert.Equal(t, 1, flaky.calls)
+
+	// attempts are bounded
+	flaky = &flakyRepository{errs: []error{io.EOF, io.EOF, io.EOF, io.EOF}}
+	_, err = NewRetryingRepository(flaky, policy, nil).Get("foo", "", ctx)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 3, flaky.calls)
+
+	// writes are not retried unless asked for
+	flaky = &flakyRepository{}
+	assert.Equal(t, io.EOF, NewRetryingRepository(flaky, policy, nil).Delete("foo", "", ctx))
+	assert.Equal(t, 1, flaky.calls)
+	policy.RetryWrites = true
+	flaky = &flakyRepository{}
+	assert.Equal(t, io.EOF, NewRetryingRepository(flaky, policy, nil).Delete("foo", "", ctx))
+	assert.Equal(t, 3, flaky.calls)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	flaky := &flakyRepository{errs: []error{io.EOF, io.EOF, io.EOF}}
+	repo := NewRetryingRepository(flaky, RetryPolicy{MaxAttempts: 1}, breaker)
+
+	for i := 0; i < 2; i++ {
+		_, err := repo.Get("foo", "", ctx)
+		assert.Equal(t, io.EOF, err)
+	}
+
+	// open, calls do not reach the database
+	now = now.Add(20 * time.Second)
+	_, err := repo.Get("foo", "", ctx)
+	if assert.IsType(t, &UnavailableError{}, err) {
+		assert.Equal(t, 40*time.Second, err.(*UnavailableError).RetryAfter)
+	}
+	assert.Equal(t, 2, flaky.calls)
+
+	// the failing trial call opens the circuit again
+	now = now.Add(time.Minute)
+	_, err = repo.Get("foo", "", ctx)
+	assert.Equal(t, io.EOF, err)
+	_, err = repo.Get("foo", "", ctx)
+	assert.IsType(t, &UnavailableError{}, err)
+
+	// the succeeding one closes it
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		_, err = repo.Get("foo", "", ctx)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 5, flaky.calls)
+}
+
+func TestCircuitBreaker_Trial(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	breaker.Record(true)
+
+	// a trial call that panics opens the circuit again rather than leaving the trial in flight
+	now = now.Add(time.Minute)
+	panicking := NewRetryingRepository(&panickingRepository{}, RetryPolicy{MaxAttempts: 1}, breaker)
+	assert.Panics(t, func() { panicking.Get("foo", "", ctx) })
+	allowed, _ := breaker.Allow()
+	assert.False(t, allowed)
+
+	// a trial call that does not return holds up others for a cooldown at most
+	now = now.Add(time.Minute)
+	allowed, _ = breaker.Allow()
+	require.True(t, allowed)
+	allowed, retryAfter := breaker.Allow()
+	assert.False(t, allowed)
+	assert.Equal(t, time.Minute, retryAfter)
+	now = now.Add(time.Minute)
+	allowed, _ = breaker.Allow()
+	assert.True(t, allowed)
+	breaker.Record(false)
+	allowed, _ = breaker.Allow()
+	assert.True(t, allowed)
+}
+
+type panickingRepository struct {
+	Repository
+}
+
+func (r *panickingRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	panic("connection pool exhausted")
+}
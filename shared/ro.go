@@ -7,12 +7,17 @@ import (
 	"fmt"
 	"github.com/satori/go.uuid"
 	"math"
-	"strings"
 	"time"
 )
 
 func NewIdAssignment() ReadOnlyAssignment {
-	return &idAssignment{}
+	return NewGeneratedIdAssignment(func() string { return uuid.NewV4().String() })
+}
+
+// Assigns ids from the generator, i.e. ids of an upstream directory or sortable ids. Generated ids must be
+// unique, they are not checked against the repository.
+func NewGeneratedIdAssignment(generate func() string) ReadOnlyAssignment {
+	return &idAssignment{generate: generate}
 }
 
 func NewMetaAssignment(properties PropertySource, resourceType string) ReadOnlyAssignment {
@@ -27,11 +32,13 @@ type ReadOnlyAssignment interface {
 	AssignValue(r *Resource, ctx context.Context) error
 }
 
-// Generates id value with UUID v4
-type idAssignment struct{}
+// Generates id value, with UUID v4 by default
+type idAssignment struct {
+	generate func() string
+}
 
 func (ro *idAssignment) AssignValue(r *Resource, ctx context.Context) error {
-	r.Complex["id"] = uuid.NewV4().String()
+	r.Complex["id"] = ro.generate()
 	return nil
 }
 
@@ -50,30 +57,35 @@ func (ro *metaAssignment) AssignValue(r *Resource, ctx context.Context) error {
 
 	now := ro.timestamp()
 	if meta, ok := r.Complex["meta"].(map[string]interface{}); !ok {
-		propertyKey := fmt.Sprintf("scim.resources.%s.locationBase", strings.ToLower(ro.resourceType))
-		locationTemplate := strings.TrimSuffix(ro.GetString(propertyKey), "/")
-		if len(locationTemplate) == 0 {
-			return Error.Text("Cannot assign value to meta: no resource location template configured with key %s", propertyKey)
+		location, err := NewResourceLocation(ro, ro.resourceType, id, ctx)
+		if err != nil {
+			return err
 		}
 		meta := map[string]interface{}{
 			"created":      now,
 			"lastModified": now,
 			"version":      ro.generateVersion(id, now),
 			"resourceType": ro.resourceType,
-			"location":     fmt.Sprintf("%s/%s", locationTemplate, id),
+			"location":     location,
 		}
 		r.Complex["meta"] = meta
 	} else {
 		now := ro.timestamp()
 		meta["lastModified"] = now
 		meta["version"] = ro.generateVersion(id, now)
+		if _, ok := ctx.Value(BaseURL{}).(string); ok {
+			// follow the base URL the resource is written at
+			if location, err := NewResourceLocation(ro, ro.resourceType, id, ctx); err == nil {
+				meta["location"] = location
+			}
+		}
 		r.Complex["meta"] = meta
 	}
 	return nil
 }
 
 func (ro *metaAssignment) timestamp() string {
-	return time.Now().Format("2006-01-02T15:04:05Z")
+	return FormatDateTime(time.Now().Truncate(time.Second))
 }
 
 func (ro *metaAssignment) generateVersion(args ...string) string {
@@ -111,7 +123,7 @@ func (ro *groupAssignment) AssignValue(r *Resource, ctx context.Context) error {
 
 	for memberIdsToSearch.Size() > 0 {
 		id := memberIdsToSearch.Poll().(string)
-		groups, err := ro.searchGroups(id)
+		groups, err := ro.searchGroups(id, ctx)
 		if err != nil {
 			return err
 		}
@@ -134,12 +146,16 @@ func (ro *groupAssignment) AssignValue(r *Resource, ctx context.Context) error {
 	return nil
 }
 
-func (ro *groupAssignment) searchGroups(memberId string) ([]DataProvider, error) {
+func (ro *groupAssignment) searchGroups(memberId string, ctx context.Context) ([]DataProvider, error) {
+	value, err := QuoteFilterString(memberId)
+	if err != nil {
+		return nil, err
+	}
 	list, err := ro.groupRepo.Search(SearchRequest{
-		Filter:     fmt.Sprintf("members.value eq \"%s\"", memberId),
+		Filter:     "members.value eq " + value,
 		Count:      math.MaxInt32,
 		StartIndex: 1,
-	})
+	}, ctx)
 	if err != nil {
 		return nil, Error.Text("Failed to calculate group: %s", err.Error())
 	} else {
@@ -46,6 +46,9 @@ type Schema struct {
 	Name        string       `json:"name,omitempty"`
 	Description string       `json:"description,omitempty"`
 	Attributes  []*Attribute `json:"attributes,omitempty"`
+
+	// declared by the server on internal schemas, see DeclareUnique
+	Constraints []*UniqueConstraint `json:"-"`
 }
 
 func (s *Schema) ToAttribute() *Attribute {
@@ -60,7 +63,7 @@ func (s *Schema) ToAttribute() *Attribute {
 
 func (s *Schema) GetAttribute(p Path, recursive bool) *Attribute {
 	for _, attr := range s.Attributes {
-		if strings.ToLower(attr.Name) == strings.ToLower(p.Base()) {
+		if strings.EqualFold(attr.Name, p.Base()) {
 			if recursive {
 				return attr.GetAttribute(p.Next(), recursive)
 			} else {
@@ -70,7 +73,7 @@ func (s *Schema) GetAttribute(p Path, recursive bool) *Attribute {
 			switch attr.Name {
 			case "schemas", "id", "externalId", "meta":
 			default:
-				if strings.ToLower(fmt.Sprintf("%s:%s", s.Id, attr.Name)) == strings.ToLower(p.Base()) {
+				if strings.EqualFold(fmt.Sprintf("%s:%s", s.Id, attr.Name), p.Base()) {
 					if recursive {
 						return attr.GetAttribute(p.Next(), recursive)
 					} else {
@@ -130,14 +133,8 @@ func (a *Attribute) MarshalJSON() ([]byte, error) {
 }
 
 func (a *Attribute) EqualsToPath(p Path) bool {
-	switch strings.ToLower(p.CollectValue()) {
-	case strings.ToLower(a.Assist.FullPath):
-		return true
-	case strings.ToLower(a.Assist.Path):
-		return true
-	default:
-		return false
-	}
+	text := p.CollectValue()
+	return strings.EqualFold(text, a.Assist.FullPath) || strings.EqualFold(text, a.Assist.Path)
 }
 
 func (a *Attribute) Assigned(v reflect.Value) bool {
@@ -243,20 +240,28 @@ func (a *Attribute) GetAttribute(p Path, recursive bool) *Attribute {
 		return a
 	}
 
-	for _, subAttr := range a.SubAttributes {
-		if strings.ToLower(subAttr.Name) == strings.ToLower(p.Base()) {
-			if recursive {
-				return subAttr.GetAttribute(p.Next(), recursive)
-			} else {
-				return subAttr
-			}
-
+	if subAttr := a.SubAttribute(p.Base()); subAttr != nil {
+		if recursive {
+			return subAttr.GetAttribute(p.Next(), recursive)
+		} else {
+			return subAttr
 		}
 	}
 
 	return nil
 }
 
+// Attribute names are case insensitive (RFC 7643 section 2.1). Lookups by name, whether of a filter, an attributes
+// parameter, a PATCH path or a key in a request body, all come down to this, so that any casing matches.
+func (a *Attribute) SubAttribute(name string) *Attribute {
+	for _, subAttr := range a.SubAttributes {
+		if strings.EqualFold(subAttr.Name, name) {
+			return subAttr
+		}
+	}
+	return nil
+}
+
 type Assist struct {
 	JSONName      string   `json:"_jsonName"`      // JSON field name used to render this field
 	Path          string   `json:"_path"`          // period delimited field names, useful to retrieve nested fields
@@ -267,6 +272,9 @@ type Assist struct {
 const (
 	UserUrn         = "urn:ietf:params:scim:schemas:core:2.0:User"
 	GroupUrn        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	EnterpriseUrn   = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	RoleUrn         = "urn:ietf:params:scim:schemas:core:2.0:Role"
+	EntitlementUrn  = "urn:ietf:params:scim:schemas:core:2.0:Entitlement"
 	ResourceTypeUrn = "urn:ietf:params:scim:schemas:core:2.0:resourceType"
 	SPConfigUrn     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
 	SchemaUrn       = "urn:ietf:params:scim:schemas:core:2.0:Schema"
@@ -277,6 +285,15 @@ const (
 	BulkRequestUrn  = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
 	BulkResponseUrn = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
 
+	// Extension of list responses whose search timed out, see NewSearchTimeoutRepository
+	PartialListResponseUrn = "urn:go-scim:params:scim:api:messages:2.0:PartialListResponse"
+	// Extension of list responses carrying a watermark for delta queries, see ChangeLog
+	DeltaListResponseUrn = "urn:go-scim:params:scim:api:messages:2.0:DeltaListResponse"
+
+	// SCIM 1.1 counterparts of UserUrn and GroupUrn, EnterpriseUrn and the message urns
+	Core11Urn       = "urn:scim:schemas:core:1.0"
+	Enterprise11Urn = "urn:scim:schemas:extension:enterprise:1.0"
+
 	TypeString    = "string"
 	TypeBoolean   = "boolean"
 	TypeBinary    = "binary"
@@ -308,4 +325,8 @@ const (
 	SchemaResourceType                = "Schema"
 	ResourceTypeResourceType          = "ResourceType"
 	ServiceProviderConfigResourceType = "ServiceProviderConfig"
+	OperationResourceType             = "Operation"
+	ExportResourceType                = "Export"
+	RoleResourceType                  = "Role"
+	EntitlementResourceType           = "Entitlement"
 )
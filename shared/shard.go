@@ -0,0 +1,290 @@
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// points of every shard on the hash ring, the more the evener the resources are spread
+const shardReplicas = 128
+
+// Routes the resources of a type across repositories, i.e. MongoDB collections of different clusters, by a
+// consistent hash of their id, so that a tenant can outgrow a single collection. Shards are named; adding a
+// shard moves only the resources that hash to it, a share of about 1/n of all, which are to be migrated by
+// the operator before the shard is added. Reads, writes and deletes of an id go to its shard.
+//
+// Count, GetAll and Search ask all shards in parallel. Search merges the pages of the shards by sortBy, by id
+// when none is given, in the order the shards sort in (see SearchOrderer), so that pages are stable however the
+// resources are spread and no resource is skipped or repeated: for a page starting at
+// startIndex, every shard is asked for its first startIndex-1+count matches, which makes deep pages
+// expensive. totalResults is the sum of those of the shards; the result is partial when that of any shard is.
+func NewShardedRepository(sch *Schema, shards map[string]Repository) Repository {
+	r := &shardedRepository{schema: sch, shards: make([]Repository, 0, len(shards))}
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		r.shards = append(r.shards, shards[name])
+		for replica := 0; replica < shardReplicas; replica++ {
+			r.ring = append(r.ring, shardPoint{hash: shardHash(name + "#" + strconv.Itoa(replica)), shard: i})
+		}
+	}
+	sort.Slice(r.ring, func(i, j int) bool { return r.ring[i].hash < r.ring[j].hash })
+	return r
+}
+
+type shardedRepository struct {
+	schema *Schema
+	shards []Repository // in the order of their names
+	ring   []shardPoint // by hash
+}
+
+type shardPoint struct {
+	hash  uint64
+	shard int
+}
+
+// sha256 rather than a faster hash, which clusters the points of names differing in their last bytes only
+func shardHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// the shard of the id, nil if there are none
+func (r *shardedRepository) shardOf(id string) Repository {
+	if len(r.ring) == 0 {
+		return nil
+	}
+	return r.shards[r.ring[r.point(id)].shard]
+}
+
+// the index of the point of the ring the id belongs to, the first at or after the hash of the id
+func (r *shardedRepository) point(id string) int {
+	hash := shardHash(id)
+	i := sort.Search(len(r.ring), func(i int) bool { return r.ring[i].hash >= hash })
+	if i == len(r.ring) {
+		return 0
+	}
+	return i
+}
+
+// run the call on every shard in parallel, returning the error of the first shard that failed
+func (r *shardedRepository) scatter(call func(i int, shard Repository) error) error {
+	errs := make([]error, len(r.shards))
+	var wg sync.WaitGroup
+	for i, shard := range r.shards {
+		wg.Add(1)
+		go func(i int, shard Repository) {
+			defer wg.Done()
+			errs[i] = call(i, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *shardedRepository) Create(provider DataProvider, ctx context.Context) error {
+	shard := r.shardOf(provider.GetId())
+	if shard == nil {
+		return Error.Text("no shards")
+	}
+	return shard.Create(provider, ctx)
+}
+
+func (r *shardedRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	shard := r.shardOf(id)
+	if shard == nil {
+		return nil, Error.ResourceNotFound(id, version)
+	}
+	return shard.Get(id, version, ctx)
+}
+
+func (r *shardedRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	shard := r.shardOf(id)
+	if shard == nil {
+		return false, nil
+	}
+	return ResourceExists(shard, id, version, ctx)
+}
+
+// asks every shard holding any of the ids in parallel, for those of the ids it holds
+func (r *shardedRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	byShard := make([][]string, len(r.shards))
+	for _, id := range distinct(ids) {
+		if len(r.ring) > 0 {
+			i := r.ring[r.point(id)].shard
+			byShard[i] = append(byShard[i], id)
+		}
+	}
+	counts := make([]int, len(r.shards))
+	err := r.scatter(func(i int, shard Repository) (err error) {
+		if len(byShard[i]) > 0 {
+			counts[i], err = CountExisting(shard, byShard[i], ctx)
+		}
+		return
+	})
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, err
+}
+
+func (r *shardedRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	all := make([][]Complex, len(r.shards))
+	err := r.scatter(func(i int, shard Repository) (err error) {
+		all[i], err = shard.GetAll(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged := make([]Complex, 0)
+	for _, each := range all {
+		merged = append(merged, each...)
+	}
+	return merged, nil
+}
+
+func (r *shardedRepository) Count(query string, ctx context.Context) (int, error) {
+	counts := make([]int, len(r.shards))
+	err := r.scatter(func(i int, shard Repository) (err error) {
+		counts[i], err = shard.Count(query, ctx)
+		return
+	})
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, err
+}
+
+func (r *shardedRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	shard := r.shardOf(id)
+	if shard == nil {
+		return Error.ResourceNotFound(id, version)
+	}
+	return shard.Update(id, version, provider, ctx)
+}
+
+func (r *shardedRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	shard := r.shardOf(id)
+	if shard == nil {
+		return Error.ResourceNotFound(id, version)
+	}
+	return memberPatcherOf(shard).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *shardedRepository) decorated() []Repository {
+	return r.shards
+}
+
+func (r *shardedRepository) Delete(id, version string, ctx context.Context) error {
+	shard := r.shardOf(id)
+	if shard == nil {
+		return Error.ResourceNotFound(id, version)
+	}
+	return shard.Delete(id, version, ctx)
+}
+
+func (r *shardedRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	startIndex := payload.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	perShard := payload
+	perShard.StartIndex = 1
+	perShard.Count = startIndex - 1 + payload.Count
+	if payload.Count <= 0 {
+		perShard.Count = 0
+	}
+	if len(perShard.SortBy) == 0 {
+		perShard.SortBy, perShard.SortOrder = "id", "ascending"
+	}
+	compare, err := r.SearchOrder(perShard.SortBy, perShard.Ascending())
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]*ListResponse, len(r.shards))
+	err = r.scatter(func(i int, shard Repository) (err error) {
+		pages[i], err = shard.Search(perShard, ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lr := &ListResponse{Schemas: []string{ListResponseUrn}, StartIndex: startIndex}
+	merged := make([]DataProvider, 0)
+	for _, page := range pages {
+		lr.TotalResults += page.TotalResults
+		lr.Partial = lr.Partial || page.Partial
+		merged = append(merged, page.Resources...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return compare(merged[i], merged[j]) < 0
+	})
+
+	lr.Resources = make([]DataProvider, 0)
+	for i := startIndex - 1; i < len(merged) && len(lr.Resources) < payload.Count; i++ {
+		lr.Resources = append(lr.Resources, merged[i])
+	}
+	lr.ItemsPerPage = len(lr.Resources)
+	return lr, nil
+}
+
+// the order of the shards, which are all alike
+func (r *shardedRepository) SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	if len(r.shards) == 0 {
+		return resourceOrder(sortBy, r.schema)
+	}
+	return SearchOrder(r.shards[0], r.schema, sortBy, ascending)
+}
+
+// reindexes every shard in parallel
+func (r *shardedRepository) ReindexUnique(ctx context.Context) error {
+	return r.scatter(func(i int, shard Repository) error {
+		return shard.(UniqueIndexer).ReindexUnique(ctx)
+	})
+}
+
+// purges every shard in parallel, counting the resources purged from all of them
+func (r *shardedRepository) PurgeDeleted(before time.Time, ctx context.Context) (int, error) {
+	counts := make([]int, len(r.shards))
+	err := r.scatter(func(i int, shard Repository) (err error) {
+		counts[i], err = shard.(DeletedPurger).PurgeDeleted(before, ctx)
+		return
+	})
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, err
+}
+
+func (r *shardedRepository) Ping(ctx context.Context) error {
+	return r.scatter(func(i int, shard Repository) error {
+		return shard.Ping(ctx)
+	})
+}
+
+func (r *shardedRepository) GetSlice(id, attribute string, startIndex, count int, ctx context.Context) ([]interface{}, int, error) {
+	shard := r.shardOf(id)
+	if shard == nil {
+		return nil, 0, Error.ResourceNotFound(id, "")
+	}
+	return SliceAttribute(shard, id, attribute, startIndex, count, ctx)
+}
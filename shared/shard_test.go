@@ -0,0 +1,171 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestShardedRepository(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	shards := map[string]Repository{}
+	for _, name := range []string{"a", "b", "c"} {
+		shards[name] = NewSearchableMapRepository(sch, map[string]DataProvider{})
+	}
+	repo := NewShardedRepository(sch, shards)
+	single := NewSearchableMapRepository(sch, map[string]DataProvider{})
+	for i := 0; i < 60; i++ {
+		user := Complex{"id": fmt.Sprintf("%02d", i), "userName": fmt.Sprintf("user%02d", (i*7)%60), "title": "engineer"}
+		require.Nil(t, repo.Create(&Resource{Complex: user}, ctx))
+		require.Nil(t, single.Create(&Resource{Complex: user}, ctx))
+	}
+
+	// every shard holds some of the users, each user one shard
+	for name, shard := range shards {
+		count, err := shard.Count(`id pr`, ctx)
+		require.Nil(t, err)
+		assert.True(t, count > 5 && count < 40, "%s holds %d", name, count)
+	}
+	count, err := repo.Count(`title eq "engineer"`, ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 60, count)
+	all, err := repo.GetAll(ctx)
+	require.Nil(t, err)
+	assert.Len(t, all, 60)
+
+	dp, err := repo.Get("42", "", ctx)
+	require.Nil(t, err)
+	assert.Equal(t, "user54", dp.GetData()["userName"])
+	require.Nil(t, repo.Update("42", "", &Resource{Complex: Complex{"id": "42", "userName": "renamed"}}, ctx))
+	require.Nil(t, single.Update("42", "", &Resource{Complex: Complex{"id": "42", "userName": "renamed"}}, ctx))
+	require.Nil(t, repo.Delete("13", "", ctx))
+	require.Nil(t, single.Delete("13", "", ctx))
+	_, err = repo.Get("13", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+	assert.Nil(t, repo.Ping(ctx))
+
+	// pages are those of a single repository holding all users
+	ids := func(lr *ListResponse) []string {
+		ids := make([]string, 0)
+		for _, dp := range lr.Resources {
+			ids = append(ids, dp.GetId())
+		}
+		return ids
+	}
+	for _, sr := range []SearchRequest{
+		{Filter: `id pr`, StartIndex: 1, Count: 10},
+		{Filter: `id pr`, StartIndex: 21, Count: 15},
+		{Filter: `id pr`, SortBy: "userName", StartIndex: 5, Count: 10},
+		{Filter: `id pr`, SortBy: "userName", SortOrder: "descending", StartIndex: 50, Count: 20},
+		{Filter: `userName sw "user1"`, SortBy: "userName", StartIndex: 1, Count: 0},
+		// all users share the title, the id breaks the ties
+		{Filter: `id pr`, SortBy: "title", StartIndex: 11, Count: 10},
+		{Filter: `id pr`, SortBy: "title", SortOrder: "descending", StartIndex: 31, Count: 10},
+	} {
+		sharded, err := repo.Search(sr, ctx)
+		require.Nil(t, err)
+		expected, err := single.Search(sr, ctx)
+		require.Nil(t, err)
+		assert.Equal(t, ids(expected), ids(sharded), "%+v", sr)
+		assert.Equal(t, expected.TotalResults, sharded.TotalResults, "%+v", sr)
+		assert.Equal(t, expected.StartIndex, sharded.StartIndex, "%+v", sr)
+	}
+}
+
+// a repository sorting userNames by their length first, like a database of another collation may
+type lengthOrderedRepository struct {
+	Repository
+}
+
+func (r *lengthOrderedRepository) SearchOrder(sortBy string, ascending bool) (func(a, b DataProvider) int, error) {
+	return func(a, b DataProvider) int {
+		if !ascending {
+			a, b = b, a
+		}
+		x, y := a.GetData()[sortBy].(string), b.GetData()[sortBy].(string)
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		return compareBytes(x+a.GetId(), y+b.GetId())
+	}, nil
+}
+
+func (r *lengthOrderedRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	all := payload
+	all.StartIndex, all.Count = 1, 1000
+	lr, err := r.Repository.Search(all, ctx)
+	if err != nil {
+		return nil, err
+	}
+	compare, _ := r.SearchOrder(payload.SortBy, payload.Ascending())
+	sort.Slice(lr.Resources, func(i, j int) bool { return compare(lr.Resources[i], lr.Resources[j]) < 0 })
+	lr.Resources = lr.Resources[payload.StartIndex-1:]
+	if len(lr.Resources) > payload.Count {
+		lr.Resources = lr.Resources[:payload.Count]
+	}
+	lr.StartIndex = payload.StartIndex
+	return lr, nil
+}
+
+func TestShardedRepository_SearchOrder(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	shards := map[string]Repository{}
+	for _, name := range []string{"a", "b", "c"} {
+		shards[name] = &lengthOrderedRepository{NewSearchableMapRepository(sch, map[string]DataProvider{})}
+	}
+	repo := NewShardedRepository(sch, shards)
+	single := &lengthOrderedRepository{NewSearchableMapRepository(sch, map[string]DataProvider{})}
+	for i := 0; i < 30; i++ {
+		user := Complex{"id": fmt.Sprintf("%02d", i), "userName": strings.Repeat("z", i%4) + fmt.Sprintf("%d", i)}
+		require.Nil(t, repo.Create(&Resource{Complex: user}, ctx))
+		require.Nil(t, single.Create(&Resource{Complex: user}, ctx))
+	}
+
+	// pages are merged the way the shards sort
+	for _, sr := range []SearchRequest{
+		{Filter: `id pr`, SortBy: "userName", StartIndex: 4, Count: 10},
+		{Filter: `id pr`, SortBy: "userName", SortOrder: "descending", StartIndex: 11, Count: 10},
+	} {
+		sharded, err := repo.Search(sr, ctx)
+		require.Nil(t, err)
+		expected, err := single.Search(sr, ctx)
+		require.Nil(t, err)
+		assert.Equal(t, expected.Resources, sharded.Resources, "%+v", sr)
+	}
+
+	// map repositories neither index nor purge, and no more do shards of them
+	_, ok := AsUniqueIndexer(repo)
+	assert.False(t, ok)
+	_, ok = AsDeletedPurger(repo)
+	assert.False(t, ok)
+}
+
+func TestShardedRepository_Consistent(t *testing.T) {
+	shards := map[string]Repository{"a": nil, "b": nil, "c": nil}
+	before := NewShardedRepository(nil, shards).(*shardedRepository)
+	shards["d"] = nil
+	after := NewShardedRepository(nil, shards).(*shardedRepository)
+
+	// a fourth shard takes about a fourth of the ids, all others stay where they are
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		id := fmt.Sprintf("id-%d", i)
+		was, is := before.ring[before.point(id)].shard, after.ring[after.point(id)].shard
+		if is != 3 {
+			assert.Equal(t, was, is, id)
+		} else {
+			moved++
+		}
+	}
+	assert.InDelta(t, 1000, moved, 250)
+}
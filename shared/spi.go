@@ -1,5 +1,7 @@
 package shared
 
+import "context"
+
 // Common abstraction for property providers
 type PropertySource interface {
 	Get(key string) interface{}
@@ -8,9 +10,43 @@ type PropertySource interface {
 	GetBool(key string) bool
 }
 
-// Common abstraction for logging providers
+// Common abstraction for logging providers. Messages are constant; the details go into fields given as
+// alternating keys and values, i.e. Info("changed password", "resourceType", "User", "resourceId", id), so that
+// adapters for structured loggers can pass them on as such.
 type Logger interface {
-	Info(template string, args ...interface{})
-	Debug(template string, args ...interface{})
-	Error(template string, args ...interface{})
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// Common abstraction for metrics providers, i.e. an adapter over a prometheus registerer
+type MetricsRegisterer interface {
+	Counter(name, help string, labelNames ...string) Counter
+	Histogram(name, help string, labelNames ...string) Histogram
+}
+
+// Common abstraction for a labeled, monotonically increasing counter
+type Counter interface {
+	Inc(labelValues ...string)
+}
+
+// Common abstraction for a labeled distribution of observed values
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// Common abstraction for tracing providers, i.e. an adapter over an OpenTelemetry tracer
+type Tracer interface {
+	// continue the trace carried by the incoming request headers, if any
+	Extract(ctx context.Context, header func(name string) string) context.Context
+	// start a child span of the span in ctx, the returned context carries the new span
+	Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// Common abstraction for a single unit of traced work
+type Span interface {
+	SetAttribute(key, value string)
+	RecordError(err error)
+	End()
 }
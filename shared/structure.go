@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 // SCIM resource
@@ -23,6 +25,65 @@ func (r *Resource) GetData() Complex {
 	return r.Complex
 }
 
+// The data provider as a Resource: itself if it is one, a Resource on its data otherwise, i.e. for the data
+// providers of custom repositories. Nil for nil.
+func AsResource(dp DataProvider) *Resource {
+	if dp == nil {
+		return nil
+	}
+	if resource, ok := dp.(*Resource); ok {
+		return resource
+	}
+	return &Resource{Complex: dp.GetData()}
+}
+
+// A copy of the resource sharing no map or slice with it, i.e. to keep the resource fetched for a patch as the
+// reference the patched resource is validated against, rather than fetching it again. Values are copied into
+// the types the schema driven code works on: maps of named types, like bson.M decoded by database drivers,
+// into map[string]interface{}, and slices of maps into []interface{}.
+func (r *Resource) DeepCopy() *Resource {
+	if r == nil {
+		return nil
+	}
+	return &Resource{Complex: Complex(deepCopy(map[string]interface{}(r.Complex)).(map[string]interface{}))}
+}
+
+// copy maps and slices recursively; maps of any named type with string keys, like those decoded by
+// database drivers, come out as map[string]interface{}, and so do slices of maps as []interface{}
+func deepCopy(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		m := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			m[k.String()] = deepCopy(rv.MapIndex(k).Interface())
+		}
+		return m
+	case reflect.Slice:
+		if rv.IsNil() {
+			return v
+		}
+		switch rv.Type().Elem().Kind() {
+		case reflect.Interface, reflect.Map:
+			s := make([]interface{}, rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				s[i] = deepCopy(rv.Index(i).Interface())
+			}
+			return s
+		default:
+			// i.e. []string, of values copied as they are
+			s := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+			reflect.Copy(s, rv)
+			return s.Interface()
+		}
+	default:
+		return v
+	}
+}
+
 func ParseResource(filePath string) (*Resource, string, error) {
 	path, err := filepath.Abs(filePath)
 	if err != nil {
@@ -52,6 +113,19 @@ func ParseResource(filePath string) (*Resource, string, error) {
 // SCIM complex data structure, Not thread-safe
 type Complex map[string]interface{}
 
+// find the entry for the attribute name regardless of the case of the key, see Attribute.SubAttribute
+func entryByName(data map[string]interface{}, name string) (string, interface{}, bool) {
+	if v, ok := data[name]; ok {
+		return name, v, true
+	}
+	for k, v := range data {
+		if strings.EqualFold(k, name) {
+			return k, v, true
+		}
+	}
+	return "", nil, false
+}
+
 func (c Complex) Get(p Path, guide AttributeSource) chan interface{} {
 	output := make(chan interface{})
 	go func() {
@@ -67,7 +141,7 @@ func (c Complex) get(p Path, guide AttributeSource, output chan interface{}) {
 		return
 	}
 
-	if v, ok := c[attr.Name]; ok && v != nil {
+	if _, v, ok := entryByName(c, attr.Name); ok && v != nil {
 		if p.FilterRoot() != nil {
 			if mv, ok := v.([]interface{}); ok && mv != nil {
 				matches := MultiValued(mv).Filter(p.FilterRoot(), attr)
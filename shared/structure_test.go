@@ -246,3 +246,32 @@ func TestComplex_Get(t *testing.T) {
 		test.assertion(test.complex.Get(p, sch))
 	}
 }
+
+func TestResource_DeepCopy(t *testing.T) {
+	type document map[string]interface{}
+	r := &Resource{Complex: Complex{
+		"userName": "david",
+		"emails":   []interface{}{map[string]interface{}{"value": "david@example.com"}},
+		"name":     document{"givenName": "David"},
+		"groups":   []map[string]interface{}{{"value": "admins"}},
+		"tags":     []string{"a", "b"},
+	}}
+	c := r.DeepCopy()
+	assert.Equal(t, "david", c.Complex["userName"])
+	assert.Equal(t, map[string]interface{}{"givenName": "David"}, c.Complex["name"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "admins"}}, c.Complex["groups"])
+
+	c.Complex["userName"] = "changed"
+	c.Complex["emails"].([]interface{})[0].(map[string]interface{})["value"] = "changed@example.com"
+	c.Complex["name"].(map[string]interface{})["givenName"] = "changed"
+	c.Complex["groups"].([]interface{})[0].(map[string]interface{})["value"] = "changed"
+	c.Complex["tags"].([]string)[0] = "changed"
+	assert.Equal(t, "david", r.Complex["userName"])
+	assert.Equal(t, "david@example.com", r.Complex["emails"].([]interface{})[0].(map[string]interface{})["value"])
+	assert.Equal(t, "David", r.Complex["name"].(document)["givenName"])
+	assert.Equal(t, "admins", r.Complex["groups"].([]map[string]interface{})[0]["value"])
+	assert.Equal(t, "a", r.Complex["tags"].([]string)[0])
+
+	var none *Resource
+	assert.Nil(t, none.DeepCopy())
+}
@@ -0,0 +1,88 @@
+package shared
+
+import (
+	"context"
+	"time"
+)
+
+// true when the caller of Search takes partial results: once the context is done, a repository able to may
+// return the resources found so far with ListResponse.Partial set, instead of failing with a timeout
+type PartialResults struct{}
+
+func AcceptsPartialResults(ctx context.Context) bool {
+	accepts, _ := ctx.Value(PartialResults{}).(bool)
+	return accepts
+}
+
+// Decorates a repository so that searches give up after the timeout, i.e. filters over unindexed attributes
+// of a large collection, instead of running until the request times out or a proxy cuts it off. Searches
+// accept partial results: a repository returning the resources found so far has them marked partial, and
+// the list response carries the PartialListResponseUrn extension. A repository failing once the timeout
+// passes has its error returned, a TimeoutError when the error is that of the context, since an empty page
+// would tell the client that nothing matched. The repository must honor the context.
+func NewSearchTimeoutRepository(repo Repository, timeout time.Duration) Repository {
+	return &searchTimeoutRepository{repo: repo, timeout: timeout}
+}
+
+type searchTimeoutRepository struct {
+	repo    Repository
+	timeout time.Duration
+}
+
+func (r *searchTimeoutRepository) Create(provider DataProvider, ctx context.Context) error {
+	return r.repo.Create(provider, ctx)
+}
+
+func (r *searchTimeoutRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	return r.repo.Get(id, version, ctx)
+}
+
+func (r *searchTimeoutRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	return ResourceExists(r.repo, id, version, ctx)
+}
+
+func (r *searchTimeoutRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	return CountExisting(r.repo, ids, ctx)
+}
+
+func (r *searchTimeoutRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	return r.repo.GetAll(ctx)
+}
+
+func (r *searchTimeoutRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *searchTimeoutRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return r.repo.Update(id, version, provider, ctx)
+}
+
+func (r *searchTimeoutRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	return memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *searchTimeoutRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *searchTimeoutRepository) Delete(id, version string, ctx context.Context) error {
+	return r.repo.Delete(id, version, ctx)
+}
+
+func (r *searchTimeoutRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	if r.timeout <= 0 {
+		return r.repo.Search(payload, ctx)
+	}
+	searchCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	lr, err := r.repo.Search(payload, context.WithValue(searchCtx, PartialResults{}, true))
+	if err == context.DeadlineExceeded && searchCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, Error.Timeout("the search")
+	}
+	return lr, err
+}
+
+func (r *searchTimeoutRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
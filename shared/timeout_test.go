@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// searches until the context is done, returning what it found when partial results are accepted
+type slowRepository struct {
+	Repository
+	cooperative  bool
+	contextError bool // fails with the error of the context rather than a TimeoutError
+}
+
+func (r *slowRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	found := []DataProvider{&Resource{Complex: Complex{"id": "foo"}}}
+	<-ctx.Done()
+	if r.cooperative && AcceptsPartialResults(ctx) {
+		return &ListResponse{Schemas: []string{ListResponseUrn}, TotalResults: 5, Resources: found, Partial: true}, nil
+	}
+	if r.contextError {
+		return nil, ctx.Err()
+	}
+	return nil, Error.Timeout("the database")
+}
+
+func TestSearchTimeoutRepository(t *testing.T) {
+	ctx := context.Background()
+	payload := SearchRequest{Filter: `title co "engineer"`, StartIndex: 1, Count: 10}
+
+	lr, err := NewSearchTimeoutRepository(&slowRepository{cooperative: true}, 10*time.Millisecond).Search(payload, ctx)
+	require.Nil(t, err)
+	assert.True(t, lr.Partial)
+	assert.Len(t, lr.Resources, 1)
+	assert.Equal(t, 5, lr.TotalResults)
+
+	// repositories failing with the timeout are not taken for having found nothing
+	_, err = NewSearchTimeoutRepository(&slowRepository{}, 10*time.Millisecond).Search(payload, ctx)
+	assert.IsType(t, &TimeoutError{}, err)
+	_, err = NewSearchTimeoutRepository(&slowRepository{contextError: true}, 10*time.Millisecond).Search(payload, ctx)
+	assert.IsType(t, &TimeoutError{}, err)
+
+	// the request running out of time is not answered with partial results
+	requestCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = NewSearchTimeoutRepository(&slowRepository{}, time.Minute).Search(payload, requestCtx)
+	assert.IsType(t, &TimeoutError{}, err)
+
+	// searches finishing in time are not partial
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	repo := NewSearchTimeoutRepository(NewSearchableMapRepository(sch, map[string]DataProvider{
+		"foo": &Resource{Complex: Complex{"id": "foo", "userName": "david"}},
+	}), time.Minute)
+	lr, err = repo.Search(SearchRequest{Filter: `userName eq "david"`, StartIndex: 1, Count: 10}, ctx)
+	require.Nil(t, err)
+	assert.False(t, lr.Partial)
+	assert.Len(t, lr.Resources, 1)
+}
+
+func TestMarshalJSON_PartialListResponse(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	json, err := MarshalJSON(&ListResponse{TotalResults: 0, Resources: []DataProvider{}, Partial: true}, sch, nil, nil)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{
+		"schemas": ["`+ListResponseUrn+`", "`+PartialListResponseUrn+`"],
+		"totalResults": 0,
+		"itemsPerPage": 0,
+		"startIndex": 1,
+		"Resources": [],
+		"`+PartialListResponseUrn+`": {"partial": true, "reason": "timeout"}
+	}`, string(json))
+}
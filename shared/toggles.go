@@ -0,0 +1,56 @@
+package shared
+
+import "sort"
+
+// The operations of resource types turned off, i.e. delete of User for a server whose users are managed
+// elsewhere. Operations are named as by DescribeRequestType: get, create, replace, patch, delete and query;
+// reading the members of a group or the managers of a user counts as get, changing a password as patch.
+// Requests for disabled operations, also within bulk requests, are answered 501 Not Implemented. Toggles
+// are set while the server starts; a nil set disables nothing.
+type OperationToggles struct {
+	disabled map[string]map[string]bool // by resource type and operation
+}
+
+func NewOperationToggles() *OperationToggles {
+	return &OperationToggles{disabled: make(map[string]map[string]bool)}
+}
+
+// Turn the operations of the resource type off
+func (t *OperationToggles) Disable(resourceType string, operations ...string) *OperationToggles {
+	if t.disabled[resourceType] == nil {
+		t.disabled[resourceType] = make(map[string]bool)
+	}
+	for _, op := range operations {
+		t.disabled[resourceType][op] = true
+	}
+	return t
+}
+
+// Whether the operation of the resource type is turned on
+func (t *OperationToggles) Enabled(resourceType, operation string) bool {
+	if t == nil {
+		return true
+	}
+	return !t.disabled[resourceType][operation]
+}
+
+// The disabled operations of the resource type, sorted
+func (t *OperationToggles) Disabled(resourceType string) []string {
+	if t == nil {
+		return nil
+	}
+	operations := make([]string, 0, len(t.disabled[resourceType]))
+	for op := range t.disabled[resourceType] {
+		operations = append(operations, op)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// Error if the operation of the resource type is turned off, see Error.NotImplemented
+func (t *OperationToggles) Check(resourceType, operation string) error {
+	if t.Enabled(resourceType, operation) {
+		return nil
+	}
+	return Error.NotImplemented(operation + " of " + resourceType)
+}
@@ -0,0 +1,23 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOperationToggles(t *testing.T) {
+	var none *OperationToggles
+	assert.True(t, none.Enabled(UserResourceType, "delete"))
+	assert.Nil(t, none.Check(UserResourceType, "delete"))
+	assert.Empty(t, none.Disabled(UserResourceType))
+
+	toggles := NewOperationToggles().Disable(UserResourceType, "patch", "delete")
+	assert.False(t, toggles.Enabled(UserResourceType, "delete"))
+	assert.True(t, toggles.Enabled(UserResourceType, "create"))
+	assert.True(t, toggles.Enabled(GroupResourceType, "delete"))
+	assert.Equal(t, []string{"delete", "patch"}, toggles.Disabled(UserResourceType))
+
+	err := toggles.Check(UserResourceType, "patch")
+	assert.IsType(t, &NotImplementedError{}, err)
+	assert.Equal(t, "patch of User is not supported", err.Error())
+}
@@ -0,0 +1,156 @@
+package shared
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Optionally implemented by repositories that remember deleted resources, i.e. those flagging them instead of
+// removing them. Tells when the resource of the id was deleted, false when it was not or is forgotten. Reads
+// and writes of deleted resources are then answered with 410 Gone instead of 404 Not Found.
+type DeletionReader interface {
+	DeletedAt(id string, ctx context.Context) (time.Time, bool, error)
+}
+
+// Keeps the times resources were deleted by their ids, for NewTombstoneRepository. NewMemoryTombstoneStore keeps
+// them in memory, mongo.NewTombstoneStore in a collection shared by all server instances that survives restarts.
+type TombstoneStore interface {
+	// Record that the resource of the id was deleted at the time
+	Put(id string, deleted time.Time, ctx context.Context) error
+	// When the resource of the id was deleted, false without a tombstone
+	Get(id string, ctx context.Context) (time.Time, bool, error)
+	// Forget the tombstone of the id, if any
+	Remove(id string, ctx context.Context) error
+	// Remove the tombstones of resources deleted before the time; run periodically
+	Expire(before time.Time) error
+}
+
+// Decorates a repository removing deleted resources so that it remembers when they were deleted, for the
+// retention, as a DeletionReader. Identity providers can then tell resources that were deprovisioned from ids
+// that never existed. The tombstones are kept in the store, whose Expire removes those past the retention.
+// The repository still reports deleted resources as ResourceNotFoundError.
+func NewTombstoneRepository(repo Repository, store TombstoneStore, retention time.Duration) Repository {
+	return &tombstoneRepository{
+		repo:      repo,
+		store:     store,
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+type tombstoneRepository struct {
+	repo      Repository
+	store     TombstoneStore
+	retention time.Duration
+	now       func() time.Time
+}
+
+func (r *tombstoneRepository) Create(provider DataProvider, ctx context.Context) error {
+	if err := r.repo.Create(provider, ctx); err != nil {
+		return err
+	}
+	// a resource created again under the id is no longer gone; the write is not failed when the tombstone
+	// stays, which is only consulted once the resource is missing again
+	r.store.Remove(provider.GetId(), ctx)
+	return nil
+}
+
+func (r *tombstoneRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	return r.repo.Get(id, version, ctx)
+}
+
+func (r *tombstoneRepository) Exists(id, version string, ctx context.Context) (bool, error) {
+	return ResourceExists(r.repo, id, version, ctx)
+}
+
+func (r *tombstoneRepository) CountByIds(ids []string, ctx context.Context) (int, error) {
+	return CountExisting(r.repo, ids, ctx)
+}
+
+func (r *tombstoneRepository) GetAll(ctx context.Context) ([]Complex, error) {
+	return r.repo.GetAll(ctx)
+}
+
+func (r *tombstoneRepository) Count(query string, ctx context.Context) (int, error) {
+	return r.repo.Count(query, ctx)
+}
+
+func (r *tombstoneRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return r.repo.Update(id, version, provider, ctx)
+}
+
+func (r *tombstoneRepository) PatchMembers(id, version string, adds []interface{}, removes []string, meta map[string]interface{}, ctx context.Context) error {
+	return memberPatcherOf(r.repo).PatchMembers(id, version, adds, removes, meta, ctx)
+}
+
+func (r *tombstoneRepository) decorated() []Repository {
+	return []Repository{r.repo}
+}
+
+func (r *tombstoneRepository) Delete(id, version string, ctx context.Context) error {
+	if err := r.repo.Delete(id, version, ctx); err != nil {
+		return err
+	}
+	// the delete is done, failing to record it only costs clients the 410 Gone
+	r.store.Put(id, r.now(), ctx)
+	return nil
+}
+
+func (r *tombstoneRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	return r.repo.Search(payload, ctx)
+}
+
+func (r *tombstoneRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+func (r *tombstoneRepository) DeletedAt(id string, ctx context.Context) (time.Time, bool, error) {
+	deleted, ok, err := r.store.Get(id, ctx)
+	if err != nil || !ok || !r.now().Before(deleted.Add(r.retention)) {
+		return time.Time{}, false, err
+	}
+	return deleted, true, nil
+}
+
+// Returns a store keeping tombstones in memory: they are neither shared between instances nor survive restarts.
+func NewMemoryTombstoneStore() TombstoneStore {
+	return &memoryTombstoneStore{deleted: make(map[string]time.Time)}
+}
+
+type memoryTombstoneStore struct {
+	sync.Mutex
+	deleted map[string]time.Time
+}
+
+func (s *memoryTombstoneStore) Put(id string, deleted time.Time, ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+	s.deleted[id] = deleted
+	return nil
+}
+
+func (s *memoryTombstoneStore) Get(id string, ctx context.Context) (time.Time, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	deleted, ok := s.deleted[id]
+	return deleted, ok, nil
+}
+
+func (s *memoryTombstoneStore) Remove(id string, ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.deleted, id)
+	return nil
+}
+
+func (s *memoryTombstoneStore) Expire(before time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	for id, deleted := range s.deleted {
+		if deleted.Before(before) {
+			delete(s.deleted, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTombstoneRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewTombstoneRepository(NewMapRepository(map[string]DataProvider{
+		"foo": &Resource{Complex: Complex{"id": "foo"}},
+	}), NewMemoryTombstoneStore(), time.Hour)
+	repo.(*tombstoneRepository).now = func() time.Time { return now }
+	reader := repo.(DeletionReader)
+
+	_, ok, err := reader.DeletedAt("foo", ctx)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	// failed deletes leave no tombstone
+	assert.IsType(t, &ResourceNotFoundError{}, repo.Delete("bar", "", ctx))
+	_, ok, _ = reader.DeletedAt("bar", ctx)
+	assert.False(t, ok)
+
+	require.Nil(t, repo.Delete("foo", "", ctx))
+	deleted, ok, err := reader.DeletedAt("foo", ctx)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, now, deleted)
+	_, err = repo.Get("foo", "", ctx)
+	assert.IsType(t, &ResourceNotFoundError{}, err)
+
+	// forgotten after the retention
+	now = now.Add(time.Hour)
+	_, ok, _ = reader.DeletedAt("foo", ctx)
+	assert.False(t, ok)
+
+	// created again, the resource is no longer gone
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "foo"}}, ctx))
+	require.Nil(t, repo.Delete("foo", "", ctx))
+	require.Nil(t, repo.Create(&Resource{Complex: Complex{"id": "foo"}}, ctx))
+	_, ok, _ = reader.DeletedAt("foo", ctx)
+	assert.False(t, ok)
+}
+
+func TestMemoryTombstoneStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryTombstoneStore()
+	require.Nil(t, store.Put("foo", now, ctx))
+	require.Nil(t, store.Put("bar", now.Add(time.Hour), ctx))
+
+	deleted, ok, err := store.Get("foo", ctx)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, now, deleted)
+
+	require.Nil(t, store.Expire(now.Add(time.Minute)))
+	_, ok, _ = store.Get("foo", ctx)
+	assert.False(t, ok)
+	_, ok, _ = store.Get("bar", ctx)
+	assert.True(t, ok)
+
+	require.Nil(t, store.Remove("bar", ctx))
+	_, ok, _ = store.Get("bar", ctx)
+	assert.False(t, ok)
+}
@@ -0,0 +1,68 @@
+package shared
+
+import (
+	"context"
+	"sync"
+)
+
+// Span attribute keys set by TraceStep and the request level span
+const (
+	TraceAttrResourceType = "scim.resource_type"
+	TraceAttrOperation    = "scim.operation"
+	TraceAttrRequestId    = "scim.request_id"
+	TraceAttrStatus       = "http.status_code"
+)
+
+var (
+	oneNoOpTracer sync.Once
+	noOpTracerVal Tracer
+)
+
+// Returns a tracer that does not record anything
+func NewNoOpTracer() Tracer {
+	oneNoOpTracer.Do(func() {
+		noOpTracerVal = &noOpTracer{}
+	})
+	return noOpTracerVal
+}
+
+type noOpTracer struct{}
+
+func (t *noOpTracer) Extract(ctx context.Context, header func(name string) string) context.Context {
+	return ctx
+}
+
+func (t *noOpTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	return ctx, noOpSpan{}
+}
+
+type noOpSpan struct{}
+
+func (s noOpSpan) SetAttribute(key, value string) {}
+func (s noOpSpan) RecordError(err error)          {}
+func (s noOpSpan) End()                           {}
+
+// Returns the span attributes describing the request in ctx
+func TraceAttributes(ctx context.Context) map[string]string {
+	attributes := map[string]string{}
+	if requestType, ok := ctx.Value(RequestType{}).(int); ok {
+		attributes[TraceAttrResourceType], attributes[TraceAttrOperation] = DescribeRequestType(requestType)
+	}
+	if requestId, ok := ctx.Value(RequestId{}).(string); ok {
+		attributes[TraceAttrRequestId] = requestId
+	}
+	return attributes
+}
+
+// Runs a pipeline step inside a span annotated with the resource type and operation of the request,
+// the error returned by the step is recorded on the span and returned as is
+func TraceStep(tracer Tracer, ctx context.Context, name string, step func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, name, TraceAttributes(ctx))
+	defer span.End()
+
+	err := step(ctx)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
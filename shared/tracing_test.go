@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTraceStep(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		step      func(ctx context.Context) error
+		assertion func(tracer *recordingTracer, err error)
+	}{
+		{
+			"validateType",
+			func(ctx context.Context) error {
+				return nil
+			},
+			func(tracer *recordingTracer, err error) {
+				assert.Nil(t, err)
+				assert.Len(t, tracer.spans, 1)
+				assert.Equal(t, "validateType", tracer.spans[0].name)
+				assert.Equal(t, UserResourceType, tracer.spans[0].attributes[TraceAttrResourceType])
+				assert.Equal(t, "create", tracer.spans[0].attributes[TraceAttrOperation])
+				assert.Equal(t, "foo", tracer.spans[0].attributes[TraceAttrRequestId])
+				assert.True(t, tracer.spans[0].ended)
+				assert.Nil(t, tracer.spans[0].err)
+			},
+		},
+		{
+			"validateRequired",
+			func(ctx context.Context) error {
+				return Error.MissingRequiredProperty("userName")
+			},
+			func(tracer *recordingTracer, err error) {
+				assert.IsType(t, &MissingRequiredPropertyError{}, err)
+				assert.Len(t, tracer.spans, 1)
+				assert.True(t, tracer.spans[0].ended)
+				assert.Equal(t, err, tracer.spans[0].err)
+			},
+		},
+		{
+			"nested",
+			func(ctx context.Context) error {
+				assert.NotNil(t, ctx.Value(recordingSpanKey{}))
+				return nil
+			},
+			func(tracer *recordingTracer, err error) {
+				assert.Nil(t, err)
+			},
+		},
+	} {
+		tracer := &recordingTracer{}
+		ctx := context.WithValue(context.Background(), RequestType{}, CreateUser)
+		ctx = context.WithValue(ctx, RequestId{}, "foo")
+		err := TraceStep(tracer, ctx, test.name, test.step)
+		test.assertion(tracer, err)
+	}
+
+	// no-op tracer passes the context through
+	ctx := context.Background()
+	assert.Nil(t, TraceStep(NewNoOpTracer(), ctx, "noop", func(ctx0 context.Context) error {
+		assert.Equal(t, ctx, ctx0)
+		return nil
+	}))
+}
+
+// A tracer that keeps every started span in memory
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+type recordingSpanKey struct{}
+
+func (t *recordingTracer) Extract(ctx context.Context, header func(name string) string) context.Context {
+	return ctx
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	span := &recordingSpan{name: name, attributes: attributes}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordingSpanKey{}, span), span
+}
+
+type recordingSpan struct {
+	name       string
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+func (s *recordingSpan) SetAttribute(key, value string) { s.attributes[key] = value }
+func (s *recordingSpan) RecordError(err error)          { s.err = err }
+func (s *recordingSpan) End()                           { s.ended = true }
@@ -0,0 +1,253 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A write time transformation of a resource, i.e. deriving or normalizing attributes. Transformers run before
+// ValidateType and CorrectCase, so that the values they assign are validated like any other and derived
+// attributes count towards the required ones; they find attributes by name whatever their case, and leave
+// values of the wrong type for ValidateType to reject. An error aborts the request.
+type Transformer func(r *Resource, sch *Schema, ctx context.Context) error
+
+// Registry of transformers per resource type, run in the order of registration
+type Transformers struct {
+	sync.RWMutex
+	byType map[string][]Transformer
+}
+
+func NewTransformers() *Transformers {
+	return &Transformers{byType: make(map[string][]Transformer)}
+}
+
+func (t *Transformers) Register(resourceType string, transformers ...Transformer) *Transformers {
+	t.Lock()
+	defer t.Unlock()
+	t.byType[resourceType] = append(t.byType[resourceType], transformers...)
+	return t
+}
+
+// Whether transformers are registered for the resource type
+func (t *Transformers) Has(resourceType string) bool {
+	if t == nil {
+		return false
+	}
+	t.RLock()
+	defer t.RUnlock()
+	return len(t.byType[resourceType]) > 0
+}
+
+// Run the transformers of the resource type on the resource, stopping at the first error
+func (t *Transformers) Apply(resourceType string, r *Resource, sch *Schema, ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.RLock()
+	transformers := t.byType[resourceType]
+	t.RUnlock()
+
+	for _, transform := range transformers {
+		if err := transform(r, sch, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Derive the target attribute from an expression when the resource does not carry it, i.e.
+// DeriveAttribute("displayName", "${name.givenName} ${name.familyName}"). Placeholders hold an attribute
+// path, optionally piped through the functions lower, upper and trim: '${userName | lower}'. Absent
+// attributes render empty; a blank result leaves the target unassigned.
+func DeriveAttribute(target, expression string) (Transformer, error) {
+	expr, err := parseExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *Resource, sch *Schema, ctx context.Context) error {
+		if _, ok := lookupAttribute(r, target, sch); ok {
+			return nil
+		}
+		value, err := expr.render(r, sch)
+		if err != nil {
+			return err
+		}
+		if value = strings.TrimSpace(value); len(value) == 0 {
+			return nil
+		}
+		return assignAttribute(r, target, value, sch)
+	}, nil
+}
+
+// Lower case the string value of the attribute, i.e. userName for directories that compare it exactly
+func LowerCaseAttribute(path string) Transformer {
+	return TransformAttribute(path, func(value interface{}) interface{} {
+		if s, ok := value.(string); ok {
+			return strings.ToLower(s)
+		}
+		return value
+	})
+}
+
+// Replace the value of the attribute with the result of the function, when the resource carries it
+func TransformAttribute(path string, fn func(value interface{}) interface{}) Transformer {
+	return func(r *Resource, sch *Schema, ctx context.Context) error {
+		value, ok := lookupAttribute(r, path, sch)
+		if !ok {
+			return nil
+		}
+		return assignAttribute(r, path, fn(value), sch)
+	}
+}
+
+// Move a top level attribute the schema does not define, i.e. a custom attribute sent by an identity provider,
+// into the target attribute, typically one of an extension:
+// MapAttribute("costCenter", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:costCenter").
+// Requires scim.protocol.unknownAttributes to preserve unknown attributes.
+func MapAttribute(source, target string) Transformer {
+	return func(r *Resource, sch *Schema, ctx context.Context) error {
+		key, value, ok := entryByName(r.Complex, source)
+		if !ok {
+			return nil
+		}
+		delete(r.Complex, key)
+		if value == nil {
+			return nil
+		}
+		return assignAttribute(r, target, value, sch)
+	}
+}
+
+// the first value at the path, and whether it is assigned
+func lookupAttribute(r *Resource, text string, sch *Schema) (interface{}, bool) {
+	path, attr, err := CompilePath(text, sch)
+	if err != nil {
+		return nil, false
+	}
+	var first interface{}
+	found := false
+	for v := range r.Complex.Get(path, sch) {
+		if !found && attr.Assigned(reflect.ValueOf(v)) {
+			first, found = v, true
+		}
+	}
+	return first, found
+}
+
+// set the value at a path without filter, creating the enclosing complex values as needed
+func assignAttribute(r *Resource, text string, value interface{}, sch *Schema) error {
+	ap, err := ParseAttributePath(text)
+	if err != nil {
+		return err
+	}
+	if _, err := ap.Resolve(sch); err != nil {
+		return err
+	}
+	if ap.Filter != nil {
+		return Error.InvalidPath(text, "cannot assign to a filtered path")
+	}
+
+	names := []string{ap.Attribute}
+	if len(ap.URN) > 0 {
+		names = append([]string{ap.URN}, names...)
+	}
+	if len(ap.SubAttribute) > 0 {
+		names = append(names, ap.SubAttribute)
+	}
+
+	container := map[string]interface{}(r.Complex)
+	for _, name := range names[:len(names)-1] {
+		key, v, ok := entryByName(container, name)
+		if !ok {
+			key = name
+		}
+		next, ok := v.(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			container[key] = next
+		}
+		container = next
+	}
+	last := names[len(names)-1]
+	if key, _, ok := entryByName(container, last); ok {
+		last = key
+	}
+	container[last] = value
+	return nil
+}
+
+// a parsed DeriveAttribute expression: literal text interleaved with placeholders
+type expression []expressionPart
+
+type expressionPart struct {
+	literal   string
+	path      string
+	functions []string
+}
+
+func parseExpression(text string) (expression, error) {
+	expr := make(expression, 0)
+	rest := text
+	for len(rest) > 0 {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			expr = append(expr, expressionPart{literal: rest})
+			break
+		}
+		if start > 0 {
+			expr = append(expr, expressionPart{literal: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, Error.InvalidParam("expression", "closed placeholders", text)
+		}
+
+		segments := strings.Split(rest[start+2:start+end], "|")
+		part := expressionPart{path: strings.TrimSpace(segments[0])}
+		if len(part.path) == 0 {
+			return nil, Error.InvalidParam("expression", "attribute path in placeholder", text)
+		}
+		for _, fn := range segments[1:] {
+			fn = strings.TrimSpace(fn)
+			if _, ok := expressionFunctions[fn]; !ok {
+				return nil, Error.InvalidParam("expression", "one of [lower, upper, trim]", fn)
+			}
+			part.functions = append(part.functions, fn)
+		}
+		expr = append(expr, part)
+		rest = rest[start+end+1:]
+	}
+	return expr, nil
+}
+
+var expressionFunctions = map[string]func(string) string{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+func (expr expression) render(r *Resource, sch *Schema) (string, error) {
+	sb := strings.Builder{}
+	for _, part := range expr {
+		if len(part.path) == 0 {
+			sb.WriteString(part.literal)
+			continue
+		}
+		if _, _, err := CompilePath(part.path, sch); err != nil {
+			return "", err
+		}
+		value, ok := lookupAttribute(r, part.path, sch)
+		if !ok {
+			continue
+		}
+		s := fmt.Sprintf("%v", value)
+		for _, fn := range part.functions {
+			s = expressionFunctions[fn](s)
+		}
+		sb.WriteString(s)
+	}
+	return sb.String(), nil
+}
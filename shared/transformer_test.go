@@ -0,0 +1,85 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTransformers(t *testing.T) {
+	sch := &Schema{
+		Id: UserUrn,
+		Attributes: []*Attribute{
+			{Name: "userName", Type: TypeString},
+			{Name: "displayName", Type: TypeString},
+			{Name: "name", Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "givenName", Type: TypeString},
+				{Name: "familyName", Type: TypeString},
+			}},
+			{Name: enterpriseUrn, Type: TypeComplex, SubAttributes: []*Attribute{
+				{Name: "costCenter", Type: TypeString},
+			}},
+		},
+	}
+	require.Nil(t, CompileSchema(sch))
+
+	deriveDisplayName, err := DeriveAttribute("displayName", "${name.givenName} ${name.familyName | upper}")
+	require.Nil(t, err)
+	transformers := NewTransformers().Register(UserResourceType,
+		deriveDisplayName,
+		LowerCaseAttribute("userName"),
+		MapAttribute("costcenter", enterpriseUrn+":costCenter"),
+	)
+
+	for _, test := range []struct {
+		data      Complex
+		assertion func(data Complex)
+	}{
+		{
+			Complex{
+				"userName":   "David@Example.com",
+				"name":       map[string]interface{}{"givenName": "David", "familyName": "Qiu"},
+				"costCenter": "4130",
+			},
+			func(data Complex) {
+				assert.Equal(t, "David QIU", data["displayName"])
+				assert.Equal(t, "david@example.com", data["userName"])
+				assert.Equal(t, map[string]interface{}{"costCenter": "4130"}, data[enterpriseUrn])
+				assert.NotContains(t, data, "costCenter")
+			},
+		},
+		{
+			// derived attributes do not override those sent, blank results are not assigned
+			Complex{
+				"displayName": "Dave",
+				"name":        map[string]interface{}{"givenName": "David"},
+			},
+			func(data Complex) {
+				assert.Equal(t, "Dave", data["displayName"])
+			},
+		},
+		{
+			Complex{"userName": "david"},
+			func(data Complex) {
+				assert.NotContains(t, data, "displayName")
+			},
+		},
+	} {
+		r := &Resource{Complex: test.data}
+		require.Nil(t, transformers.Apply(UserResourceType, r, sch, context.Background()))
+		test.assertion(r.Complex)
+	}
+
+	// other resource types are left alone
+	r := &Resource{Complex: Complex{"userName": "David"}}
+	require.Nil(t, transformers.Apply(GroupResourceType, r, sch, context.Background()))
+	assert.Equal(t, "David", r.Complex["userName"])
+}
+
+func TestDeriveAttribute_InvalidExpression(t *testing.T) {
+	for _, expression := range []string{"${name.givenName", "${}", "${userName | reverse}"} {
+		_, err := DeriveAttribute("displayName", expression)
+		assert.NotNil(t, err, expression)
+	}
+}
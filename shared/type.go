@@ -2,7 +2,12 @@ package shared
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -18,6 +23,10 @@ func ValidateType(subj *Resource, sch *Schema, ctx context.Context) (err error)
 		}
 	}()
 
+	if warnings := LenientWarnings(ctx); warnings != nil {
+		(&lenientTypes{warnings: warnings}).repair(subj.Complex, sch.ToAttribute())
+	}
+	decodeNumbers(subj.Complex, sch.ToAttribute(), false)
 	typeValidatorInstance.validateTypeWithReflection(reflect.ValueOf(subj.Complex), sch.ToAttribute(), ctx)
 	err = nil
 	return
@@ -52,9 +61,30 @@ func (tv *typeValidator) validateTypeWithReflection(v reflect.Value, attr *Attri
 
 	switch v.Kind() {
 	case reflect.String:
+		// numbers decodeNumbers left, those of no integer for integer attributes or of attributes of other types
+		if v.Type() == jsonNumberType {
+			if _, err := numberValue(json.Number(v.String()), attr); err != nil {
+				tv.throw(err, ctx)
+			}
+			return
+		}
 		if !attr.ExpectsString() {
 			tv.throw(Error.InvalidType(attr.Assist.FullPath, TypeString, v.Type().Name()), ctx)
 		}
+		switch attr.Type {
+		case TypeDateTime:
+			if _, err := ParseDateTime(v.String()); err != nil {
+				tv.throw(Error.InvalidType(attr.Assist.FullPath, "RFC 3339 "+TypeDateTime, v.String()), ctx)
+			}
+		case TypeBinary:
+			if !validBinary(v.String()) {
+				tv.throw(Error.InvalidType(attr.Assist.FullPath, "base64 encoded "+TypeBinary, "non-base64 string"), ctx)
+			}
+		case TypeReference:
+			if !validReference(v.String(), attr.ReferenceTypes) {
+				tv.throw(Error.InvalidType(attr.Assist.FullPath, "reference to "+strings.Join(attr.ReferenceTypes, " or "), v.String()), ctx)
+			}
+		}
 
 	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
 		if !attr.ExpectsInteger() {
@@ -88,14 +118,10 @@ func (tv *typeValidator) validateTypeWithReflection(v reflect.Value, attr *Attri
 		}
 
 		for _, k := range v.MapKeys() {
-			p, err := NewPath(k.String())
-			if err != nil {
-				tv.throw(err, ctx)
-			}
-
-			subAttr := attr.GetAttribute(p, false)
+			// matched by name, so that extension namespaces are found as a whole
+			subAttr := attr.SubAttribute(k.String())
 			if subAttr == nil {
-				tv.throw(Error.NoAttribute(p.Value()), ctx)
+				tv.throw(Error.NoAttribute(k.String()), ctx)
 			}
 
 			tv.validateTypeWithReflection(v.MapIndex(k), subAttr, ctx)
@@ -109,3 +135,118 @@ func (tv *typeValidator) validateTypeWithReflection(v reflect.Value, attr *Attri
 func (tv *typeValidator) throw(err error, ctx context.Context) {
 	panic(err)
 }
+
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+// The number as the value of the attribute type: int64 for integer attributes, failing for numbers with a
+// fractional part or beyond the range of int64, and float64 for decimal attributes. Numbers of request bodies
+// are decoded as json.Number, so that integers keep every digit rather than pass through float64.
+func numberValue(n json.Number, attr *Attribute) (interface{}, error) {
+	switch attr.Type {
+	case TypeInteger:
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return i, nil
+		}
+	case TypeDecimal:
+		if f, err := n.Float64(); err == nil {
+			return f, nil
+		}
+	default:
+		return nil, Error.InvalidType(attr.Assist.FullPath, attr.Type, "number")
+	}
+	return nil, Error.InvalidType(attr.Assist.FullPath, attr.Type, string(n))
+}
+
+// Replace the json.Number values of the complex value, and of the complex values within, by their value of
+// numberValue. Numbers numberValue fails for are left for validation to reject, or turned into float64 with
+// fallback, as encoding/json would have decoded them.
+func decodeNumbers(m map[string]interface{}, guide *Attribute, fallback bool) {
+	decode := func(v interface{}, attr *Attribute) interface{} {
+		n, ok := v.(json.Number)
+		if !ok {
+			if sub, ok := v.(map[string]interface{}); ok {
+				decodeNumbers(sub, attr, fallback)
+			}
+			return v
+		}
+		if value, err := numberValue(n, attr); err == nil {
+			return value
+		}
+		if f, err := n.Float64(); err == nil && fallback {
+			return f
+		}
+		return v
+	}
+
+	for k, v := range m {
+		attr := guide.SubAttribute(k)
+		if attr == nil {
+			continue
+		}
+		if array, ok := v.([]interface{}); ok {
+			for i, elem := range array {
+				array[i] = decode(elem, attr)
+			}
+			continue
+		}
+		m[k] = decode(v, attr)
+	}
+}
+
+// Reference types of RFC 7643 section 7. Any other reference type names the resource type the reference
+// points to, i.e. 'User' or 'Group'.
+const (
+	ExternalReference = "external" // a URL of a resource outside the service provider, i.e. a photo
+	UriReference      = "uri"      // an absolute URI, i.e. a schema URN
+)
+
+// endpoints of the resource types a reference may name
+var referenceEndpoints = map[string]string{
+	"User":  "Users",
+	"Group": "Groups",
+}
+
+// binary values are base64 encoded (RFC 7643 section 2.3.6), padding may be left out
+func validBinary(text string) bool {
+	if _, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return true
+	}
+	_, err := base64.RawStdEncoding.DecodeString(text)
+	return err == nil
+}
+
+// a reference must be a URI of one of the reference types. A reference to a resource type, absolute or
+// relative, must go through the endpoint of the type, i.e. '../Groups/e9e30dba'; resource types without
+// a known endpoint admit any URI.
+func validReference(text string, referenceTypes []string) bool {
+	u, err := url.Parse(text)
+	if err != nil || len(text) == 0 {
+		return false
+	}
+	if len(referenceTypes) == 0 {
+		return true
+	}
+
+	for _, referenceType := range referenceTypes {
+		switch referenceType {
+		case ExternalReference:
+			if u.IsAbs() && len(u.Host) > 0 {
+				return true
+			}
+		case UriReference:
+			if u.IsAbs() {
+				return true
+			}
+		default:
+			endpoint, ok := referenceEndpoints[referenceType]
+			if !ok {
+				return true
+			}
+			segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if len(segments) >= 2 && segments[len(segments)-2] == endpoint {
+				return true
+			}
+		}
+	}
+	return false
+}
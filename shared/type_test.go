@@ -2,9 +2,11 @@ package shared
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 )
 
@@ -113,6 +115,41 @@ func TestValidateType(t *testing.T) {
 				assert.Equal(t, "foo", err.(*NoAttributeError).Path)
 			},
 		},
+		{
+			// binary
+			func(r *Resource) *Resource {
+				r.Complex["x509Certificates"] = []interface{}{
+					map[string]interface{}{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"},
+				}
+				return r
+			},
+			func(err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			// binary not base64 encoded
+			func(r *Resource) *Resource {
+				r.Complex["x509Certificates"] = []interface{}{
+					map[string]interface{}{"value": "-----BEGIN CERTIFICATE-----"},
+				}
+				return r
+			},
+			func(err error) {
+				assert.IsType(t, &InvalidTypeError{}, err)
+			},
+		},
+		{
+			// external reference not a URL
+			func(r *Resource) *Resource {
+				r.Complex["profileUrl"] = "davidqiu"
+				return r
+			},
+			func(err error) {
+				assert.IsType(t, &InvalidTypeError{}, err)
+				assert.Equal(t, fmt.Sprintf("%s:profileUrl", UserUrn), err.(*InvalidTypeError).Path)
+			},
+		},
 	} {
 		r, _, err := ParseResource("../resources/tests/user_1.json")
 		require.Nil(t, err)
@@ -122,3 +159,77 @@ func TestValidateType(t *testing.T) {
 		test.assertion(ValidateType(test.getResource(r), sch, ctx))
 	}
 }
+
+func TestValidateType_Numbers(t *testing.T) {
+	sch, err := NewSchemaRegistry().RegisterJSON([]byte(`{"id": "urn:example:Device", "attributes": [
+		{"name": "serial", "type": "integer"},
+		{"name": "weight", "type": "decimal"},
+		{"name": "ports", "type": "integer", "multiValued": true},
+		{"name": "label", "type": "string"}
+	]}`))
+	require.Nil(t, err)
+	parse := func(raw string) *Resource {
+		data := make(map[string]interface{})
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		require.Nil(t, decoder.Decode(&data))
+		return &Resource{Complex: Complex(data)}
+	}
+
+	for _, test := range []struct {
+		raw    string
+		expect Complex
+		path   string
+	}{
+		{`{"serial": 9007199254740993, "weight": 2, "ports": [80, 443]}`,
+			Complex{"serial": int64(9007199254740993), "weight": float64(2), "ports": []interface{}{int64(80), int64(443)}}, ""},
+		{`{"weight": 1.25}`, Complex{"weight": 1.25}, ""},
+		{`{"serial": 1.5}`, nil, "urn:example:Device:serial"},
+		{`{"serial": 3.0}`, nil, "urn:example:Device:serial"},
+		{`{"serial": 92233720368547758070}`, nil, "urn:example:Device:serial"},
+		{`{"ports": [80, 80.5]}`, nil, "urn:example:Device:ports"},
+		{`{"label": 42}`, nil, "urn:example:Device:label"},
+	} {
+		r := parse(test.raw)
+		err := ValidateType(r, sch, context.Background())
+		if len(test.path) > 0 {
+			require.IsType(t, &InvalidTypeError{}, err, test.raw)
+			assert.Equal(t, test.path, err.(*InvalidTypeError).Path, test.raw)
+			continue
+		}
+		require.Nil(t, err, test.raw)
+		assert.Equal(t, test.expect, r.Complex, test.raw)
+	}
+
+	// case correction keeps the types, failing numbers as ValidateType would not have let through
+	r := parse(`{"Serial": 12, "WEIGHT": 0.5, "label": 7}`)
+	require.Nil(t, CorrectCase(r, sch, context.Background()))
+	assert.Equal(t, Complex{"serial": int64(12), "weight": 0.5, "label": float64(7)}, r.Complex)
+
+	// whole numbers written as decimals are repaired leniently
+	ctx := WithLenientValidation(context.Background())
+	r = parse(`{"serial": 3.0, "label": 42}`)
+	require.Nil(t, ValidateType(r, sch, ctx))
+	assert.Equal(t, Complex{"serial": int64(3), "label": "42"}, r.Complex)
+	assert.Len(t, LenientWarnings(ctx).Messages(), 2)
+}
+
+func TestValidReference(t *testing.T) {
+	for _, test := range []struct {
+		text           string
+		referenceTypes []string
+		expect         bool
+	}{
+		{"https://example.com/photo.jpg", []string{ExternalReference}, true},
+		{"/photo.jpg", []string{ExternalReference}, false},
+		{UserUrn, []string{UriReference}, true},
+		{"User", []string{UriReference}, false},
+		{"https://example.com/v2/Groups/e9e30dba", []string{"User", "Group"}, true},
+		{"../Users/2819c223", []string{"User", "Group"}, true},
+		{"https://example.com/v2/Devices/1", []string{"User", "Group"}, false},
+		{"https://example.com/v2/Devices/1", []string{"Device"}, true},
+		{"%zz", nil, false},
+	} {
+		assert.Equal(t, test.expect, validReference(test.text, test.referenceTypes), test.text)
+	}
+}
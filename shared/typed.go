@@ -0,0 +1,28 @@
+package shared
+
+import "encoding/json"
+
+// Convert a typed struct, i.e. one generated by cmd/scimgen, to a resource. The struct goes through its JSON
+// representation, so the resource holds the values a parsed request body would hold: numbers as float64 and
+// nested structs as maps.
+func MarshalResource(v interface{}) (*Resource, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return &Resource{Complex: Complex(data)}, nil
+}
+
+// Fill a typed struct, i.e. one generated by cmd/scimgen, from a resource. Attributes the struct has no field
+// for are dropped; a value whose type does not match its field fails the conversion.
+func UnmarshalResource(r *Resource, v interface{}) error {
+	raw, err := json.Marshal(r.Complex)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,56 @@
+package shared
+
+// Whether the value leaves an attribute unassigned. RFC 7643 section 2.5 considers null and the empty array
+// equivalent to an unassigned attribute; so are, here, the empty string, which identity providers send to
+// clear an attribute, and complex values whose sub attributes are all unassigned. Replacing an attribute by
+// any of these, by PATCH or PUT, clears it, just like removing it.
+func IsUnassigned(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return len(value) == 0
+	case []interface{}:
+		for _, element := range value {
+			if !IsUnassigned(element) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for _, sub := range value {
+			if !IsUnassigned(sub) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// Remove the unassigned attributes from the data, see IsUnassigned, so that clearing an attribute stores
+// the same state however it is cleared: null, empty strings and empty complex values are removed, elements of
+// multi-valued attributes that are unassigned are dropped and arrays left empty are removed.
+func RemoveUnassigned(data map[string]interface{}) {
+	for k, v := range data {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			RemoveUnassigned(value)
+		case []interface{}:
+			kept := make([]interface{}, 0, len(value))
+			for _, element := range value {
+				if m, ok := element.(map[string]interface{}); ok {
+					RemoveUnassigned(m)
+				}
+				if !IsUnassigned(element) {
+					kept = append(kept, element)
+				}
+			}
+			data[k] = kept
+		}
+		if IsUnassigned(data[k]) {
+			delete(data, k)
+		}
+	}
+}
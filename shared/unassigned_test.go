@@ -0,0 +1,51 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIsUnassigned(t *testing.T) {
+	for _, v := range []interface{}{
+		nil,
+		"",
+		[]interface{}{},
+		[]interface{}{nil, ""},
+		map[string]interface{}{},
+		map[string]interface{}{"value": "", "type": nil},
+	} {
+		assert.True(t, IsUnassigned(v), "%v", v)
+	}
+	for _, v := range []interface{}{
+		"foo",
+		false,
+		0.0,
+		[]interface{}{"foo"},
+		map[string]interface{}{"primary": false},
+	} {
+		assert.False(t, IsUnassigned(v), "%v", v)
+	}
+}
+
+func TestRemoveUnassigned(t *testing.T) {
+	data := map[string]interface{}{
+		"userName": "david",
+		"nickName": "",
+		"title":    nil,
+		"name":     map[string]interface{}{"givenName": "David", "middleName": ""},
+		"x509":     map[string]interface{}{"value": nil},
+		"emails": []interface{}{
+			map[string]interface{}{"value": "david@example.com", "display": ""},
+			map[string]interface{}{"value": "", "type": ""},
+		},
+		"phoneNumbers": []interface{}{},
+	}
+	RemoveUnassigned(data)
+	assert.Equal(t, map[string]interface{}{
+		"userName": "david",
+		"name":     map[string]interface{}{"givenName": "David"},
+		"emails": []interface{}{
+			map[string]interface{}{"value": "david@example.com"},
+		},
+	}, data)
+}
@@ -2,12 +2,34 @@ package shared
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
-func ValidateUniqueness(subj *Resource, sch *Schema, repo Repository, ctx context.Context) (err error) {
+// Responses to a create request that conflicts with an existing resource on a unique attribute
+const (
+	ConflictOnDuplicate       = "conflict" // respond 409 with scimType uniqueness, as RFC 7644 section 3.3 prescribes
+	ReturnExistingOnDuplicate = "existing" // respond with the existing resource, for identity providers that retry creates
+)
+
+// Validate that no other resource holds the value of an attribute marked unique, or the combination of values
+// of a constraint declared on the schema, see Schema.DeclareUnique. Attributes with server
+// uniqueness are checked against repo, attributes with global uniqueness are additionally checked against
+// every repository in global, i.e. the repositories of all resource types the server provides.
+// On replace and patch requests, the resource identified by the ResourceId context value is the resource
+// being updated and does not conflict with itself. A conflict is reported as a DuplicateError carrying the
+// path of the attribute and the id of the conflicting resource.
+func ValidateUniqueness(subj *Resource, sch *Schema, repo Repository, global []Repository, ctx context.Context) (err error) {
+	// the outcome of the batch holds as long as the resource holds the values it was computed for, which
+	// transformers, defaults and hooks may have changed in between
+	if checked, ok := ctx.Value(uniquenessChecked{}).(uniquenessOutcome); ok && checked.values == uniqueValues(subj, checked.sch) {
+		return checked.err
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			switch r.(type) {
@@ -19,7 +41,10 @@ func ValidateUniqueness(subj *Resource, sch *Schema, repo Repository, ctx contex
 		}
 	}()
 
-	uniquenessValidatorInstance.validateUniquenessWithReflection(reflect.ValueOf(subj.Complex), sch.ToAttribute(), repo, ctx)
+	uniquenessValidatorInstance.validateUniquenessWithReflection(reflect.ValueOf(subj.Complex), sch.ToAttribute(), repo, global, ctx)
+	for _, c := range sch.Constraints {
+		uniquenessValidatorInstance.checkConstraint(c, subj, repo, global, ctx)
+	}
 	return
 }
 
@@ -36,7 +61,7 @@ func init() {
 
 type uniquenessValidator struct{}
 
-func (uv *uniquenessValidator) validateUniquenessWithReflection(v reflect.Value, guide *Attribute, repo Repository, ctx context.Context) {
+func (uv *uniquenessValidator) validateUniquenessWithReflection(v reflect.Value, guide *Attribute, repo Repository, global []Repository, ctx context.Context) {
 	for _, attr := range guide.SubAttributes {
 		v0 := v.MapIndex(reflect.ValueOf(attr.Name))
 		if !attr.Assigned(v0) {
@@ -47,39 +72,336 @@ func (uv *uniquenessValidator) validateUniquenessWithReflection(v reflect.Value,
 		}
 
 		switch attr.Uniqueness {
-		case Server, Global:
-			query := fmt.Sprintf("%s eq \"%v\"", attr.Assist.Path, v0.Interface())
-			count, err := repo.Count(query)
-			if err != nil {
-				uv.throw(err, ctx)
-			} else if count > 0 {
-				requestType := ctx.Value(RequestType{}).(int)
-				switch requestType {
-				case ReplaceUser, ReplaceGroup, PatchUser, PatchGroup:
-					if count > 1 {
-						uv.throw(Error.Duplicate(attr.Assist.Path, v0.Interface()), ctx)
-					} else {
-						resourceId := ctx.Value(ResourceId{}).(string)
-						lr, err := repo.Search(SearchRequest{Filter: query, StartIndex: 1})
-						if err != nil {
-							uv.throw(Error.Text("Cannot verify uniqueness: %s", err.Error()), ctx)
-						}
-						if resourceId != lr.Resources[0].GetData()["id"].(string) {
-							uv.throw(Error.Duplicate(attr.Assist.Path, v0.Interface()), ctx)
-						}
-					}
-				default:
-					uv.throw(Error.Duplicate(attr.Assist.Path, v0.Interface()), ctx)
+		case Server:
+			uv.checkRepository(attr, v0.Interface(), repo, true, ctx)
+		case Global:
+			uv.checkRepository(attr, v0.Interface(), repo, true, ctx)
+			for _, other := range global {
+				if other != repo {
+					uv.checkRepository(attr, v0.Interface(), other, false, ctx)
 				}
 			}
 		}
 
 		if attr.ExpectsComplex() && v0.Kind() == reflect.Map {
-			uv.validateUniquenessWithReflection(v0, attr, repo, ctx)
+			uv.validateUniquenessWithReflection(v0, attr, repo, global, ctx)
+		}
+	}
+}
+
+// look for resources holding the value in the repository. own tells whether the repository holds the
+// resource being validated, in which case a match on that resource is not a conflict.
+func (uv *uniquenessValidator) checkRepository(attr *Attribute, value interface{}, repo Repository, own bool, ctx context.Context) {
+	quoted, err := QuoteFilterString(fmt.Sprintf("%v", value))
+	if err != nil {
+		uv.throw(err, ctx)
+	}
+	lr, err := repo.Search(SearchRequest{Filter: attr.Assist.Path + " eq " + quoted, StartIndex: 1, Count: 2}, ctx)
+	if err != nil {
+		if !own {
+			// resources of another type may not define the attribute at all
+			switch err.(type) {
+			case *InvalidFilterError, *InvalidPathError, *NoAttributeError:
+				return
+			}
+		}
+		uv.throw(err, ctx)
+	}
+
+	selfId := updatedResourceId(own, ctx)
+	for _, match := range lr.Resources {
+		if len(selfId) > 0 && match.GetId() == selfId {
+			continue
 		}
+		dup := Error.Duplicate(attr.Assist.Path, value).(*DuplicateError)
+		dup.ExistingId = match.GetId()
+		uv.throw(dup, ctx)
 	}
 }
 
 func (uv *uniquenessValidator) throw(err error, ctx context.Context) {
 	panic(err)
 }
+
+// the id of the resource a replace or patch request updates, which does not conflict with itself, if the
+// repository holds it
+func updatedResourceId(own bool, ctx context.Context) string {
+	if !own {
+		return ""
+	}
+	switch requestType, _ := ctx.Value(RequestType{}).(int); requestType {
+	case ReplaceUser, ReplaceGroup, PatchUser, PatchGroup, ReplaceRole, PatchRole, ReplaceEntitlement, PatchEntitlement:
+		id, _ := ctx.Value(ResourceId{}).(string)
+		return id
+	}
+	return ""
+}
+
+// Validate the uniqueness of many new resources of one type at once, i.e. the creates of a bulk request. Instead
+// of a search per resource and unique attribute, the values of each unique attribute are looked up in batches of
+// at most batchSize values with a single disjunctive filter, and at most workers of these searches run at a time.
+// Resources of the batch that conflict with each other are caught as well: the first to hold a value keeps it.
+// Constraints declared on the schema are searched resource by resource.
+// The result holds the outcome, a DuplicateError or nil, for each resource in order, or an error telling
+// WithCheckedUniqueness to leave the resource to ValidateUniqueness when a search fell short of all matches.
+func ValidateUniquenessBatch(subjs []*Resource, sch *Schema, repo Repository, global []Repository, batchSize, workers int, ctx context.Context) []error {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	b := &uniquenessBatch{
+		errs:   make([]error, len(subjs)),
+		values: make(map[*Attribute][]uniqueValue),
+	}
+	for i, subj := range subjs {
+		b.collect(reflect.ValueOf(subj.Complex), sch.ToAttribute(), i)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		pool = make(chan struct{}, workers)
+	)
+	for attr, values := range b.values {
+		repos := []Repository{repo}
+		if attr.Uniqueness == Global {
+			for _, other := range global {
+				if other != repo {
+					repos = append(repos, other)
+				}
+			}
+		}
+
+		for start := 0; start < len(values); start += batchSize {
+			end := start + batchSize
+			if end > len(values) {
+				end = len(values)
+			}
+			for _, r := range repos {
+				wg.Add(1)
+				pool <- struct{}{}
+				go func(attr *Attribute, values []uniqueValue, r Repository) {
+					defer func() {
+						<-pool
+						wg.Done()
+					}()
+					b.search(attr, values, sch, r, r == repo, ctx)
+				}(attr, values[start:end], r)
+			}
+		}
+	}
+	wg.Wait()
+
+	for _, c := range sch.Constraints {
+		held := make(map[string]bool)
+		for i, subj := range subjs {
+			values := c.values(subj.Complex)
+			if values == nil || b.errs[i] != nil {
+				continue
+			}
+			combinations := c.combinations(values)
+			for _, combination := range combinations {
+				if held[c.key(combination)] {
+					b.errs[i] = c.duplicate(combination)
+					break
+				}
+			}
+			if b.errs[i] != nil {
+				continue
+			}
+			for _, combination := range combinations {
+				held[c.key(combination)] = true
+			}
+			b.errs[i] = validateConstraint(c, subj, repo, global, ctx)
+		}
+	}
+	return b.errs
+}
+
+func validateConstraint(c *UniqueConstraint, subj *Resource, repo Repository, global []Repository, ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case error:
+				err = r.(error)
+			default:
+				err = Error.Text("%v", r)
+			}
+		}
+	}()
+
+	uniquenessValidatorInstance.checkConstraint(c, subj, repo, global, ctx)
+	return
+}
+
+// Return a context telling ValidateUniqueness the outcome of ValidateUniquenessBatch for the resource of the
+// request, so that it does not search again. ValidateUniqueness still searches when the resource it validates
+// no longer holds the unique values of subj.
+func WithCheckedUniqueness(ctx context.Context, subj *Resource, sch *Schema, err error) context.Context {
+	if err == errUncheckedUniqueness {
+		return ctx
+	}
+	return context.WithValue(ctx, uniquenessChecked{}, uniquenessOutcome{uniqueValues(subj, sch), sch, err})
+}
+
+// the outcome of ValidateUniquenessBatch for a resource of which a search fell short
+var errUncheckedUniqueness = errors.New("uniqueness not checked")
+
+type uniquenessChecked struct{}
+
+type uniquenessOutcome struct {
+	values string
+	sch    *Schema
+	err    error
+}
+
+// the values of the unique attributes and constraints the resource holds, compared the way the eq filter does
+func uniqueValues(subj *Resource, sch *Schema) string {
+	b := &uniquenessBatch{errs: make([]error, 1), values: make(map[*Attribute][]uniqueValue)}
+	b.collect(reflect.ValueOf(subj.Complex), sch.ToAttribute(), 0)
+	keys := make([]string, 0)
+	for attr, values := range b.values {
+		for _, each := range values {
+			keys = append(keys, attr.Assist.Path+"="+uniqueKey(attr, each.value))
+		}
+	}
+	for i, c := range sch.Constraints {
+		if values := c.values(subj.Complex); values != nil {
+			for _, combination := range c.combinations(values) {
+				keys = append(keys, fmt.Sprintf("%d=%s", i, c.key(combination)))
+			}
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\x00")
+}
+
+// a distinct value of a unique attribute along with the resource holding it
+type uniqueValue struct {
+	value interface{}
+	index int
+}
+
+type uniquenessBatch struct {
+	sync.Mutex
+	errs   []error
+	values map[*Attribute][]uniqueValue
+}
+
+func (b *uniquenessBatch) collect(v reflect.Value, guide *Attribute, index int) {
+	for _, attr := range guide.SubAttributes {
+		v0 := v.MapIndex(reflect.ValueOf(attr.Name))
+		if !attr.Assigned(v0) {
+			continue
+		}
+		if v0.Kind() == reflect.Interface {
+			v0 = v0.Elem()
+		}
+
+		switch attr.Uniqueness {
+		case Server, Global:
+			b.add(attr, v0.Interface(), index)
+		}
+
+		if attr.ExpectsComplex() && v0.Kind() == reflect.Map {
+			b.collect(v0, attr, index)
+		}
+	}
+}
+
+func (b *uniquenessBatch) add(attr *Attribute, value interface{}, index int) {
+	key := uniqueKey(attr, value)
+	for _, other := range b.values[attr] {
+		if uniqueKey(attr, other.value) == key {
+			if b.errs[index] == nil {
+				b.errs[index] = Error.Duplicate(attr.Assist.Path, value)
+			}
+			return
+		}
+	}
+	b.values[attr] = append(b.values[attr], uniqueValue{value, index})
+}
+
+// look the values up with a disjunctive filter, paging through all matches; a repository may cap the page
+// size below the count asked for
+func (b *uniquenessBatch) search(attr *Attribute, values []uniqueValue, sch *Schema, repo Repository, own bool, ctx context.Context) {
+	clauses := make([]string, 0, len(values))
+	searched := make([]uniqueValue, 0, len(values))
+	for _, each := range values {
+		quoted, err := QuoteFilterString(fmt.Sprintf("%v", each.value))
+		if err != nil {
+			b.fail(each.index, err)
+			continue
+		}
+		clauses = append(clauses, attr.Assist.Path+" eq "+quoted)
+		searched = append(searched, each)
+	}
+	if len(clauses) == 0 {
+		return
+	}
+	p, err := NewPath(attr.Assist.Path)
+	if err != nil {
+		return
+	}
+
+	for start := 1; ; {
+		lr, err := repo.Search(SearchRequest{Filter: strings.Join(clauses, " or "), StartIndex: start, Count: 2 * len(searched)}, ctx)
+		if err != nil {
+			if !own {
+				switch err.(type) {
+				case *InvalidFilterError, *InvalidPathError, *NoAttributeError:
+					return
+				}
+			}
+			for _, each := range searched {
+				b.fail(each.index, err)
+			}
+			return
+		}
+		if lr.Partial {
+			for _, each := range searched {
+				b.fail(each.index, errUncheckedUniqueness)
+			}
+			return
+		}
+
+		for _, match := range lr.Resources {
+			for held := range match.GetData().Get(p, sch) {
+				for _, each := range searched {
+					if uniqueKey(attr, held) == uniqueKey(attr, each.value) {
+						dup := Error.Duplicate(attr.Assist.Path, each.value).(*DuplicateError)
+						dup.ExistingId = match.GetId()
+						b.fail(each.index, dup)
+					}
+				}
+			}
+		}
+		start += len(lr.Resources)
+		if len(lr.Resources) == 0 || start > lr.TotalResults {
+			return
+		}
+	}
+}
+
+func (b *uniquenessBatch) fail(index int, err error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.errs[index] == nil {
+		b.errs[index] = err
+	}
+}
+
+// values compare the way the eq filter does: strings ignore case unless the attribute is caseExact, dateTime
+// values are instants
+func uniqueKey(attr *Attribute, value interface{}) string {
+	key := fmt.Sprintf("%v", value)
+	if attr.Type == TypeDateTime {
+		key = NormalizeDateTime(key)
+	}
+	if _, ok := value.(string); ok && !attr.CaseExact {
+		key = FoldCase(key)
+	}
+	return key
+}
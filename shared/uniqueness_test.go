@@ -2,6 +2,7 @@ package shared
 
 import (
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"strings"
@@ -17,12 +18,16 @@ func TestValidateUniqueness(t *testing.T) {
 
 	for _, test := range []struct {
 		getResource func(r *Resource) *Resource
+		getContext  func(ctx context.Context) context.Context
 		assertion   func(err error)
 	}{
 		{
 			func(r *Resource) *Resource {
 				return r
 			},
+			func(ctx context.Context) context.Context {
+				return ctx
+			},
 			func(err error) {
 				assert.Nil(t, err)
 			},
@@ -32,11 +37,42 @@ func TestValidateUniqueness(t *testing.T) {
 				r.Complex["id"] = "foo"
 				return r
 			},
+			func(ctx context.Context) context.Context {
+				return context.WithValue(ctx, RequestType{}, CreateUser)
+			},
 			func(err error) {
 				assert.NotNil(t, err)
 				assert.IsType(t, &DuplicateError{}, err)
 				assert.Equal(t, "id", err.(*DuplicateError).Path)
 				assert.Equal(t, "foo", err.(*DuplicateError).Value)
+				assert.Equal(t, "foo", err.(*DuplicateError).ExistingId)
+			},
+		},
+		{
+			// updating the resource that holds the value does not conflict with itself
+			func(r *Resource) *Resource {
+				r.Complex["id"] = "foo"
+				return r
+			},
+			func(ctx context.Context) context.Context {
+				ctx = context.WithValue(ctx, RequestType{}, ReplaceUser)
+				return context.WithValue(ctx, ResourceId{}, "foo")
+			},
+			func(err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			func(r *Resource) *Resource {
+				r.Complex["id"] = "foo"
+				return r
+			},
+			func(ctx context.Context) context.Context {
+				ctx = context.WithValue(ctx, RequestType{}, PatchUser)
+				return context.WithValue(ctx, ResourceId{}, "bar")
+			},
+			func(err error) {
+				assert.IsType(t, &DuplicateError{}, err)
 			},
 		},
 	} {
@@ -45,22 +81,152 @@ func TestValidateUniqueness(t *testing.T) {
 		require.NotNil(t, r)
 		r = test.getResource(r)
 
-		ctx := context.Background()
-		test.assertion(ValidateUniqueness(r, sch, repo, ctx))
+		ctx := test.getContext(context.Background())
+		test.assertion(ValidateUniqueness(r, sch, repo, nil, ctx))
 	}
 }
 
-// A mock repository that mocks the Count(query string) method
-// If the query contains "foo", returns 1, else
+func TestValidateUniqueness_Global(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	r, _, err := ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+	r.Complex["id"] = "6B69753B-2E4C-4B52-A4F6-5D3A1A9D5E1B"
+
+	// id is globally unique: a group holding the id conflicts with the user
+	users := NewSearchableMapRepository(sch, nil)
+	groups := NewSearchableMapRepository(sch, map[string]DataProvider{
+		r.GetId(): &Resource{Complex: Complex{"id": r.GetId()}},
+	})
+	ctx := context.WithValue(context.Background(), RequestType{}, CreateUser)
+
+	assert.Nil(t, ValidateUniqueness(r, sch, users, nil, ctx))
+	err = ValidateUniqueness(r, sch, users, []Repository{users, groups}, ctx)
+	assert.IsType(t, &DuplicateError{}, err)
+	assert.Equal(t, r.GetId(), err.(*DuplicateError).ExistingId)
+}
+
+func TestValidateUniqueness_Quoted(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"existing": &Resource{Complex: Complex{"id": "existing", "userName": "taken"}},
+	})
+	ctx := context.WithValue(context.Background(), RequestType{}, CreateUser)
+
+	// a value cannot alter the filter looking for it
+	err = ValidateUniqueness(&Resource{Complex: Complex{"userName": `x" or id pr or userName eq "y`}}, sch, repo, nil, ctx)
+	assert.IsType(t, &InvalidFilterError{}, err)
+	assert.Nil(t, ValidateUniqueness(&Resource{Complex: Complex{"userName": `say "hi"`}}, sch, repo, nil, ctx))
+}
+
+func TestValidateUniquenessBatch(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	repo := NewSearchableMapRepository(sch, map[string]DataProvider{
+		"existing": &Resource{Complex: Complex{"id": "existing", "userName": "taken"}},
+	})
+	subjs := []*Resource{
+		{Complex: Complex{"id": "a", "userName": "Taken"}},
+		{Complex: Complex{"id": "b", "userName": "fresh"}},
+		{Complex: Complex{"id": "c", "userName": "FRESH"}},
+		{Complex: Complex{"id": "d", "userName": "other"}},
+	}
+
+	for _, batchSize := range []int{1, 2, 10} {
+		errs := ValidateUniquenessBatch(subjs, sch, repo, nil, batchSize, 2, context.Background())
+		require.Len(t, errs, 4)
+
+		// conflicts with a stored resource
+		require.IsType(t, &DuplicateError{}, errs[0])
+		assert.Equal(t, "userName", errs[0].(*DuplicateError).Path)
+		assert.Equal(t, "existing", errs[0].(*DuplicateError).ExistingId)
+
+		// the first of the batch to hold a value keeps it
+		assert.Nil(t, errs[1])
+		require.IsType(t, &DuplicateError{}, errs[2])
+		assert.Empty(t, errs[2].(*DuplicateError).ExistingId)
+
+		assert.Nil(t, errs[3])
+	}
+
+	// the outcome is handed on to ValidateUniqueness, for the values it was computed for
+	ctx := WithCheckedUniqueness(context.Background(), subjs[1], sch, nil)
+	assert.Nil(t, ValidateUniqueness(subjs[1], sch, repo, nil, ctx))
+	assert.Nil(t, ValidateUniqueness(&Resource{Complex: Complex{"id": "b", "userName": "FRESH"}}, sch, repo, nil, ctx))
+	err = ValidateUniqueness(&Resource{Complex: Complex{"id": "b", "userName": "taken"}}, sch, repo, nil, ctx)
+	assert.IsType(t, &DuplicateError{}, err)
+}
+
+func TestValidateUniquenessBatch_Pages(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	stored := make(map[string]DataProvider)
+	subjs := make([]*Resource, 0)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("existing%d", i)
+		stored[id] = &Resource{Complex: Complex{"id": id, "userName": fmt.Sprintf("taken%d", i)}}
+		subjs = append(subjs, &Resource{Complex: Complex{"id": fmt.Sprintf("new%d", i), "userName": fmt.Sprintf("taken%d", i)}})
+	}
+	repo := &pagedRepository{Repository: NewSearchableMapRepository(sch, stored), pageSize: 2}
+
+	// every match is found, however few a page holds
+	for _, err := range ValidateUniquenessBatch(subjs, sch, repo, nil, 10, 1, context.Background()) {
+		assert.IsType(t, &DuplicateError{}, err)
+	}
+
+	// a partial search leaves the check to ValidateUniqueness
+	repo.partial = true
+	errs := ValidateUniquenessBatch(subjs, sch, repo, nil, 10, 1, context.Background())
+	repo.partial = false
+	ctx := WithCheckedUniqueness(context.WithValue(context.Background(), RequestType{}, CreateUser), subjs[0], sch, errs[0])
+	assert.IsType(t, &DuplicateError{}, ValidateUniqueness(subjs[0], sch, repo, nil, ctx))
+}
+
+// a repository serving pages of at most pageSize resources, and partial ones if partial is set
+type pagedRepository struct {
+	Repository
+	pageSize int
+	partial  bool
+}
+
+func (r *pagedRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	if payload.Count > r.pageSize {
+		payload.Count = r.pageSize
+	}
+	lr, err := r.Repository.Search(payload, ctx)
+	if err == nil && r.partial {
+		lr.Partial = true
+	}
+	return lr, err
+}
+
+// A mock repository that mocks the Search(payload SearchRequest) method
+// If the filter contains "foo", returns the resource with id foo, else nothing
 type mockRepository struct{}
 
-func (r *mockRepository) Create(provider DataProvider) error                     { return nil }
-func (r *mockRepository) Get(id, version string) (DataProvider, error)           { return nil, nil }
-func (r *mockRepository) GetAll() ([]Complex, error)                             { return nil, nil }
-func (r *mockRepository) Update(id, version string, provider DataProvider) error { return nil }
-func (r *mockRepository) Delete(id, version string) error                        { return nil }
-func (r *mockRepository) Search(payload SearchRequest) (*ListResponse, error)    { return nil, nil }
-func (r *mockRepository) Count(query string) (int, error) {
+func (r *mockRepository) Create(provider DataProvider, ctx context.Context) error { return nil }
+func (r *mockRepository) Get(id, version string, ctx context.Context) (DataProvider, error) {
+	return nil, nil
+}
+func (r *mockRepository) GetAll(ctx context.Context) ([]Complex, error) { return nil, nil }
+func (r *mockRepository) Update(id, version string, provider DataProvider, ctx context.Context) error {
+	return nil
+}
+func (r *mockRepository) Delete(id, version string, ctx context.Context) error { return nil }
+func (r *mockRepository) Ping(ctx context.Context) error                       { return nil }
+func (r *mockRepository) Search(payload SearchRequest, ctx context.Context) (*ListResponse, error) {
+	resources := []DataProvider{}
+	if strings.Contains(payload.Filter, "foo") {
+		resources = append(resources, &Resource{Complex: Complex{"id": "foo"}})
+	}
+	return &ListResponse{Schemas: []string{ListResponseUrn}, TotalResults: len(resources), Resources: resources}, nil
+}
+func (r *mockRepository) Count(query string, ctx context.Context) (int, error) {
 	if strings.Contains(query, "foo") {
 		return 1, nil
 	} else {
@@ -0,0 +1,92 @@
+package shared
+
+import (
+	"context"
+	"sync"
+)
+
+// Policies for attributes in a request body that are not defined by the schema
+const (
+	RejectUnknownAttributes   = "reject"   // fail the request with an invalidValue error
+	StripUnknownAttributes    = "strip"    // silently remove the attribute
+	PreserveUnknownAttributes = "preserve" // leave the body untouched
+)
+
+// Check every attribute of the resource against the schema, descending into complex attributes,
+// multiValued complex attributes and extension namespaces, and apply the policy to those
+// that are not defined. An empty or unrecognized policy is treated as preserve.
+func CheckUnknownAttributes(subj *Resource, sch *Schema, policy string, ctx context.Context) (err error) {
+	switch policy {
+	case RejectUnknownAttributes, StripUnknownAttributes:
+	default:
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case error:
+				err = r.(error)
+			default:
+				err = Error.Text("%v", r)
+			}
+		}
+	}()
+
+	unknownAttributeCheckInstance.check(subj.Complex, sch.ToAttribute(), "", policy == StripUnknownAttributes, ctx)
+
+	err = nil
+	return
+}
+
+type unknownAttributeCheck struct{}
+
+func (uc *unknownAttributeCheck) check(m map[string]interface{}, guide *Attribute, prefix string, strip bool, ctx context.Context) {
+	for k, v := range m {
+		path := k
+		if len(prefix) > 0 {
+			path = prefix + "." + k
+		}
+
+		// match the key by name rather than by path, so that extension namespaces like
+		// urn:ietf:params:scim:schemas:extension:enterprise:2.0:User are found as a whole
+		attr := guide.SubAttribute(k)
+		if attr == nil {
+			if strip {
+				delete(m, k)
+				continue
+			}
+			uc.throw(Error.UnknownAttribute(path), ctx)
+		}
+
+		if attr.Type != TypeComplex {
+			continue
+		}
+
+		switch v.(type) {
+		case map[string]interface{}:
+			uc.check(v.(map[string]interface{}), attr, path, strip, ctx)
+		case []interface{}:
+			for _, elem := range v.([]interface{}) {
+				if sub, ok := elem.(map[string]interface{}); ok {
+					uc.check(sub, attr, path, strip, ctx)
+				}
+			}
+		}
+	}
+}
+
+func (uc *unknownAttributeCheck) throw(err error, ctx context.Context) {
+	panic(err)
+}
+
+var (
+	singleUnknownAttributeCheck   sync.Once
+	unknownAttributeCheckInstance *unknownAttributeCheck
+)
+
+func init() {
+	singleUnknownAttributeCheck.Do(func() {
+		unknownAttributeCheckInstance = &unknownAttributeCheck{}
+	})
+}
@@ -0,0 +1,89 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCheckUnknownAttributes(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	require.NotNil(t, sch)
+
+	for _, test := range []struct {
+		policy      string
+		getResource func(r *Resource) *Resource
+		assertion   func(r *Resource, err error)
+	}{
+		{
+			// known attributes only
+			RejectUnknownAttributes,
+			func(r *Resource) *Resource {
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			// unknown top level attribute rejected
+			RejectUnknownAttributes,
+			func(r *Resource) *Resource {
+				r.Complex["foo"] = "bar"
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.IsType(t, &UnknownAttributeError{}, err)
+				assert.Equal(t, "foo", err.(*UnknownAttributeError).Path)
+			},
+		},
+		{
+			// unknown sub attribute of multiValued complex rejected
+			RejectUnknownAttributes,
+			func(r *Resource) *Resource {
+				r.Complex["emails"].([]interface{})[0].(map[string]interface{})["foo"] = "bar"
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.IsType(t, &UnknownAttributeError{}, err)
+				assert.Equal(t, "emails.foo", err.(*UnknownAttributeError).Path)
+			},
+		},
+		{
+			// unknown attributes stripped
+			StripUnknownAttributes,
+			func(r *Resource) *Resource {
+				r.Complex["foo"] = "bar"
+				r.Complex["name"].(map[string]interface{})["foo"] = "bar"
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				assert.NotContains(t, r.Complex, "foo")
+				assert.NotContains(t, r.Complex["name"], "foo")
+				assert.Equal(t, "Qiu", r.Complex["name"].(map[string]interface{})["familyName"])
+			},
+		},
+		{
+			// unknown attributes preserved
+			PreserveUnknownAttributes,
+			func(r *Resource) *Resource {
+				r.Complex["foo"] = "bar"
+				return r
+			},
+			func(r *Resource, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, "bar", r.Complex["foo"])
+			},
+		},
+	} {
+		r, _, err := ParseResource("../resources/tests/user_1.json")
+		require.Nil(t, err)
+		require.NotNil(t, r)
+
+		r = test.getResource(r)
+		test.assertion(r, CheckUnknownAttributes(r, sch, test.policy, context.Background()))
+	}
+}
@@ -0,0 +1,225 @@
+package shared
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Counts per resource type how often clients read attributes, by asking for them with attributes=, write
+// them, by sending them in create, replace and patch requests, and filter on them. Attributes that are neither
+// read nor written are candidates for pruning from extensions, those filtered often for indexes, see
+// AdviseIndexes. A nil collector records nothing.
+type AttributeUsage struct {
+	sync.Mutex
+	since   time.Time
+	reads   map[string]map[string]int64 // by resource type and attribute path
+	writes  map[string]map[string]int64
+	filters map[string]map[string]int64
+}
+
+// The usage of a single attribute, see AttributeUsage.Report
+type AttributeUsageStat struct {
+	Path    string `json:"path"`
+	Reads   int64  `json:"reads"`
+	Writes  int64  `json:"writes"`
+	Filters int64  `json:"filters"`
+}
+
+func NewAttributeUsage() *AttributeUsage {
+	return &AttributeUsage{
+		since:   time.Now(),
+		reads:   make(map[string]map[string]int64),
+		writes:  make(map[string]map[string]int64),
+		filters: make(map[string]map[string]int64),
+	}
+}
+
+// When the counting started
+func (u *AttributeUsage) Since() time.Time {
+	if u == nil {
+		return time.Time{}
+	}
+	return u.since
+}
+
+// Record the attributes a client asked for. Paths the schema does not define are ignored.
+func (u *AttributeUsage) RecordRead(resourceType string, attributes []string, sch *Schema) {
+	if u == nil {
+		return
+	}
+	paths := make([]string, 0, len(attributes))
+	for _, text := range attributes {
+		if len(text) == 0 {
+			continue
+		}
+		if ap, err := ParseAttributePath(text); err == nil {
+			if attr, err := ap.Resolve(sch); err == nil {
+				paths = append(paths, usagePath(attr))
+			}
+		}
+	}
+	u.count(u.reads, resourceType, paths)
+}
+
+// Record the attributes present in a resource a client sent, sub attributes included
+func (u *AttributeUsage) RecordWrite(resourceType string, resource *Resource, sch *Schema) {
+	u.PendingWrite(resourceType, resource, sch)()
+}
+
+// Collect the attributes present in a resource a client sent like RecordWrite, to be counted by the returned
+// function once the write succeeded. The resource is collected as sent, before defaults or transformers
+// change it, and requests rejected on the way are not counted.
+func (u *AttributeUsage) PendingWrite(resourceType string, resource *Resource, sch *Schema) func() {
+	if u == nil {
+		return func() {}
+	}
+	paths := make([]string, 0)
+	collectUsagePaths(map[string]interface{}(resource.Complex), sch.ToAttribute(), &paths)
+	return func() {
+		u.count(u.writes, resourceType, paths)
+	}
+}
+
+// Record the attributes targeted by patch operations, and those present in the values of operations that
+// target complex attributes or no attribute at all
+func (u *AttributeUsage) RecordPatch(resourceType string, ops []Patch, sch *Schema) {
+	if u == nil {
+		return
+	}
+	paths := make([]string, 0)
+	for _, op := range ops {
+		attr := sch.ToAttribute()
+		if len(op.Path) > 0 {
+			ap, err := ParseAttributePath(op.Path)
+			if err != nil {
+				continue
+			}
+			if attr, err = ap.Resolve(sch); err != nil {
+				continue
+			}
+			paths = append(paths, usagePath(attr))
+			if attr.Type != TypeComplex {
+				continue
+			}
+		}
+		switch v := op.Value.(type) {
+		case map[string]interface{}:
+			collectUsagePaths(v, attr, &paths)
+		case []interface{}:
+			for _, elem := range v {
+				if m, ok := elem.(map[string]interface{}); ok {
+					collectUsagePaths(m, attr, &paths)
+				}
+			}
+		}
+	}
+	u.count(u.writes, resourceType, paths)
+}
+
+// Record the attributes a filter compares. Filters that do not compile against the schema are ignored.
+func (u *AttributeUsage) RecordFilter(resourceType string, filter string, sch *Schema) {
+	if u == nil || len(filter) == 0 {
+		return
+	}
+	u.count(u.filters, resourceType, filteredPaths(filter, sch))
+}
+
+// How often clients filtered on each attribute of the resource type, by path, for AdviseIndexes
+func (u *AttributeUsage) Filtered(resourceType string) map[string]int64 {
+	filtered := make(map[string]int64)
+	if u == nil {
+		return filtered
+	}
+	u.Lock()
+	defer u.Unlock()
+	for path, n := range u.filters[resourceType] {
+		filtered[path] = n
+	}
+	return filtered
+}
+
+func (u *AttributeUsage) count(counts map[string]map[string]int64, resourceType string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	u.Lock()
+	defer u.Unlock()
+	byPath, ok := counts[resourceType]
+	if !ok {
+		byPath = make(map[string]int64)
+		counts[resourceType] = byPath
+	}
+	for _, path := range paths {
+		byPath[path]++
+	}
+}
+
+// Report the usage of every attribute of the schema, unused ones included, ordered by path
+func (u *AttributeUsage) Report(resourceType string, sch *Schema) []AttributeUsageStat {
+	paths := make([]string, 0)
+	var walk func(attr *Attribute)
+	walk = func(attr *Attribute) {
+		for _, sub := range attr.SubAttributes {
+			paths = append(paths, usagePath(sub))
+			walk(sub)
+		}
+	}
+	walk(sch.ToAttribute())
+	sort.Strings(paths)
+
+	stats := make([]AttributeUsageStat, 0, len(paths))
+	if u != nil {
+		u.Lock()
+		defer u.Unlock()
+	}
+	for _, path := range paths {
+		stat := AttributeUsageStat{Path: path}
+		if u != nil {
+			stat.Reads = u.reads[resourceType][path]
+			stat.Writes = u.writes[resourceType][path]
+			stat.Filters = u.filters[resourceType][path]
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// walk the keys of the value that name sub attributes of the guide, in any casing
+func collectUsagePaths(value map[string]interface{}, guide *Attribute, paths *[]string) {
+	for k, v := range value {
+		attr := guide.SubAttribute(k)
+		if attr == nil || v == nil {
+			continue
+		}
+		*paths = append(*paths, usagePath(attr))
+		if attr.Type != TypeComplex {
+			continue
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			collectUsagePaths(v, attr, paths)
+		case []interface{}:
+			// count each sub attribute once per write, however many elements carry it
+			seen := make(map[string]interface{})
+			for _, elem := range v {
+				if m, ok := elem.(map[string]interface{}); ok {
+					for k, sv := range m {
+						if sv != nil {
+							seen[strings.ToLower(k)] = sv
+						}
+					}
+				}
+			}
+			collectUsagePaths(seen, attr, paths)
+		}
+	}
+}
+
+func usagePath(attr *Attribute) string {
+	if attr.Assist != nil && len(attr.Assist.Path) > 0 {
+		return attr.Assist.Path
+	}
+	return attr.Name
+}
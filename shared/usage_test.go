@@ -0,0 +1,87 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAttributeUsage(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	usage := NewAttributeUsage()
+
+	usage.RecordRead(UserResourceType, []string{"userName", "NAME.givenName", "unknown", ""}, sch)
+	usage.RecordRead(UserResourceType, []string{"userName"}, sch)
+	usage.RecordWrite(UserResourceType, &Resource{Complex: Complex{
+		"userName": "david",
+		"name":     map[string]interface{}{"givenName": "David"},
+		"emails": []interface{}{
+			map[string]interface{}{"value": "a@example.com", "primary": true},
+			map[string]interface{}{"value": "b@example.com"},
+		},
+	}}, sch)
+	usage.RecordPatch(UserResourceType, []Patch{
+		{Op: Replace, Path: "displayName", Value: "David"},
+		{Op: Add, Value: map[string]interface{}{"nickName": "Dave"}},
+		{Op: Add, Path: "emails", Value: []interface{}{map[string]interface{}{"type": "work"}}},
+	}, sch)
+
+	usage.RecordFilter(UserResourceType, `emails.value eq "a@example.com" or emails.value eq "b@example.com"`, sch)
+	usage.RecordFilter(UserResourceType, `userName eq`, sch)
+
+	stats := make(map[string]AttributeUsageStat)
+	for _, stat := range usage.Report(UserResourceType, sch) {
+		stats[stat.Path] = stat
+	}
+	assert.Equal(t, AttributeUsageStat{Path: "userName", Reads: 2, Writes: 1}, stats["userName"])
+	assert.Equal(t, AttributeUsageStat{Path: "name.givenName", Reads: 1, Writes: 1}, stats["name.givenName"])
+	assert.Equal(t, int64(2), stats["emails"].Writes)
+	assert.Equal(t, int64(1), stats["emails.value"].Writes)
+	assert.Equal(t, int64(1), stats["emails.type"].Writes)
+	assert.Equal(t, int64(1), stats["displayName"].Writes)
+	assert.Equal(t, int64(1), stats["nickName"].Writes)
+	assert.Equal(t, int64(1), stats["emails.value"].Filters)
+	assert.Equal(t, map[string]int64{"emails.value": 1}, usage.Filtered(UserResourceType))
+	// unused attributes are reported too
+	assert.Equal(t, AttributeUsageStat{Path: "title"}, stats["title"])
+
+	// other resource types are counted apart
+	for _, stat := range usage.Report(GroupResourceType, sch) {
+		assert.Equal(t, int64(0), stat.Reads+stat.Writes)
+	}
+}
+
+func TestAttributeUsage_Nil(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+
+	var usage *AttributeUsage
+	usage.RecordRead(UserResourceType, []string{"userName"}, sch)
+	usage.RecordWrite(UserResourceType, &Resource{Complex: Complex{"userName": "david"}}, sch)
+	usage.RecordPatch(UserResourceType, []Patch{{Op: Replace, Path: "userName", Value: "david"}}, sch)
+	usage.PendingWrite(UserResourceType, &Resource{Complex: Complex{"userName": "david"}}, sch)()
+	assert.NotEmpty(t, usage.Report(UserResourceType, sch))
+}
+
+func TestAttributeUsage_PendingWrite(t *testing.T) {
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	usage := NewAttributeUsage()
+
+	resource := &Resource{Complex: Complex{"userName": "david"}}
+	record := usage.PendingWrite(UserResourceType, resource, sch)
+	resource.Complex["nickName"] = "Dave"
+	for _, stat := range usage.Report(UserResourceType, sch) {
+		assert.Equal(t, int64(0), stat.Writes, stat.Path)
+	}
+
+	// counts the resource as it was sent, once
+	record()
+	stats := make(map[string]AttributeUsageStat)
+	for _, stat := range usage.Report(UserResourceType, sch) {
+		stats[stat.Path] = stat
+	}
+	assert.Equal(t, int64(1), stats["userName"].Writes)
+	assert.Equal(t, int64(0), stats["nickName"].Writes)
+}
@@ -0,0 +1,161 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Checks a single value of an attribute against a rule of the server, i.e. a corporate naming convention.
+// An invalid value is reported by returning what was expected instead, i.e. errors.New("a phone number in
+// E.164 format"), which Validators.Apply scopes to the attribute.
+type ValueValidator func(value interface{}, ctx context.Context) error
+
+// Registry of value validators per resource type and attribute path. Validators run after ValidateType, so
+// that they receive values of the type of their attribute, on every value at the path: each element of a
+// multiValued attribute, i.e. every address of 'emails.value'. Unassigned attributes are not validated.
+type Validators struct {
+	sync.RWMutex
+	byType map[string][]attributeValidator
+}
+
+type attributeValidator struct {
+	path       string
+	validators []ValueValidator
+}
+
+func NewValidators() *Validators {
+	return &Validators{byType: make(map[string][]attributeValidator)}
+}
+
+// Register validators of the attribute at the path, i.e. Register(UserResourceType, "phoneNumbers.value",
+// E164()). Validators of the same path run in the order of registration.
+func (v *Validators) Register(resourceType, path string, validators ...ValueValidator) *Validators {
+	v.Lock()
+	defer v.Unlock()
+	// Apply iterates the slice it read without holding the lock, so registering replaces it with a copy
+	registered := v.byType[resourceType]
+	updated := make([]attributeValidator, 0, len(registered)+1)
+	found := false
+	for _, each := range registered {
+		if each.path == path {
+			each.validators = append(append([]ValueValidator(nil), each.validators...), validators...)
+			found = true
+		}
+		updated = append(updated, each)
+	}
+	if !found {
+		updated = append(updated, attributeValidator{path: path, validators: validators})
+	}
+	v.byType[resourceType] = updated
+	return v
+}
+
+// Whether validators are registered for the resource type
+func (v *Validators) Has(resourceType string) bool {
+	if v == nil {
+		return false
+	}
+	v.RLock()
+	defer v.RUnlock()
+	return len(v.byType[resourceType]) > 0
+}
+
+// Run the validators of the resource type on the values of the resource, stopping at the first invalid value.
+// Errors of the validators are reported as invalid values of the attribute at the path they were registered
+// for, except for the errors of Error.InvalidParam, Forbidden, Unavailable and Timeout, which are returned as
+// they are, i.e. by validators looking values up in another system.
+func (v *Validators) Apply(resourceType string, r *Resource, sch *Schema, ctx context.Context) error {
+	if v == nil {
+		return nil
+	}
+	v.RLock()
+	registered := v.byType[resourceType]
+	v.RUnlock()
+
+	for _, each := range registered {
+		ap, err := ParseAttributePath(each.path)
+		if err != nil {
+			return err
+		}
+		attr, err := ap.Resolve(sch)
+		if err != nil {
+			return err
+		}
+		if ap.Filter != nil {
+			return Error.InvalidPath(each.path, "filter in validated path")
+		}
+		for _, value := range valuesAt(r.Complex, ap, attr) {
+			for _, validate := range each.validators {
+				if err := validate(value, ctx); err != nil {
+					return scopeValueError(err, each.path, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func scopeValueError(err error, path string, value interface{}) error {
+	switch err.(type) {
+	case *InvalidParamError, *ForbiddenError, *UnavailableError, *TimeoutError:
+		return err
+	}
+	return Error.InvalidParam(path, err.Error(), fmt.Sprintf("%v", value))
+}
+
+// Returns a validator of string values matching the regular expression in full, i.e. MatchPattern(
+// `[a-z]+\.[a-z]+`, "a name like first.last") for corporate user names. Fails when the expression does not
+// compile.
+func MatchPattern(expr, description string) (ValueValidator, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, Error.InvalidParam("pattern", "regular expression", expr)
+	}
+	if len(description) == 0 {
+		description = "a value matching " + expr
+	}
+	return func(value interface{}, ctx context.Context) error {
+		if s, ok := value.(string); ok && !re.MatchString(s) {
+			return errors.New(description)
+		}
+		return nil
+	}, nil
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// Returns a validator of phone numbers in E.164 format, i.e. +14155552671. Other formats RFC 3966 allows,
+// such as tel:+1-201-555-0123, are rejected.
+func E164() ValueValidator {
+	return func(value interface{}, ctx context.Context) error {
+		if s, ok := value.(string); ok && !e164.MatchString(s) {
+			return errors.New("a phone number in E.164 format")
+		}
+		return nil
+	}
+}
+
+// Returns a validator of email addresses in the domains, compared case insensitively. Subdomains are not
+// allowed unless listed.
+func EmailDomains(domains ...string) ValueValidator {
+	allowed := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		allowed[strings.ToLower(strings.TrimPrefix(domain, "@"))] = true
+	}
+	expect := "an email address in one of [" + strings.Join(domains, ", ") + "]"
+	return func(value interface{}, ctx context.Context) error {
+		s, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		at := strings.LastIndex(s, "@")
+		if at < 0 || !allowed[strings.ToLower(s[at+1:])] {
+			return errors.New(expect)
+		}
+		return nil
+	}
+}
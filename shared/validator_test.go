@@ -0,0 +1,92 @@
+package shared
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidators(t *testing.T) {
+	ctx := context.Background()
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	userName, err := MatchPattern(`[a-z]+\.[a-z]+`, "a name like first.last")
+	require.Nil(t, err)
+	validators := NewValidators().
+		Register(UserResourceType, "userName", userName).
+		Register(UserResourceType, "phoneNumbers.value", E164()).
+		Register(UserResourceType, "emails.value", EmailDomains("example.com"))
+	assert.True(t, validators.Has(UserResourceType))
+	assert.False(t, validators.Has(GroupResourceType))
+
+	valid := func() *Resource {
+		return &Resource{Complex: Complex{
+			"userName":     "david.q",
+			"phoneNumbers": []interface{}{map[string]interface{}{"value": "+14155552671"}},
+			"emails": []interface{}{
+				map[string]interface{}{"value": "david@example.com"},
+				map[string]interface{}{"value": "DAVID@EXAMPLE.COM"},
+			},
+		}}
+	}
+	assert.Nil(t, validators.Apply(UserResourceType, valid(), sch, ctx))
+	// absent attributes are not validated
+	assert.Nil(t, validators.Apply(UserResourceType, &Resource{Complex: Complex{"userName": "david.q"}}, sch, ctx))
+	assert.Nil(t, validators.Apply(GroupResourceType, &Resource{Complex: Complex{"userName": "david"}}, sch, ctx))
+
+	r := valid()
+	r.Complex["userName"] = "david"
+	err = validators.Apply(UserResourceType, r, sch, ctx)
+	assert.Equal(t, Error.InvalidParam("userName", "a name like first.last", "david"), err)
+
+	r = valid()
+	r.Complex["phoneNumbers"] = []interface{}{map[string]interface{}{"value": "555-1234"}}
+	err = validators.Apply(UserResourceType, r, sch, ctx)
+	assert.Equal(t, Error.InvalidParam("phoneNumbers.value", "a phone number in E.164 format", "555-1234"), err)
+
+	// every element of a multiValued attribute is validated
+	r = valid()
+	r.Complex["emails"] = append(r.Complex["emails"].([]interface{}), map[string]interface{}{"value": "david@sub.example.com"})
+	err = validators.Apply(UserResourceType, r, sch, ctx)
+	assert.Equal(t, Error.InvalidParam("emails.value", "an email address in one of [example.com]", "david@sub.example.com"), err)
+
+	// errors of validators looking values up elsewhere are kept
+	validators.Register(UserResourceType, "userName", func(value interface{}, ctx context.Context) error {
+		return Error.Unavailable(time.Second)
+	})
+	assert.IsType(t, &UnavailableError{}, validators.Apply(UserResourceType, valid(), sch, ctx))
+
+	_, err = MatchPattern("[a-z", "")
+	assert.NotNil(t, err)
+	var none *Validators
+	assert.Nil(t, none.Apply(UserResourceType, valid(), sch, ctx))
+}
+
+func TestValidators_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	sch, _, err := ParseSchema("../resources/tests/user_schema.json")
+	require.Nil(t, err)
+	validators := NewValidators().Register(UserResourceType, "userName", E164())
+	r := &Resource{Complex: Complex{"userName": "+14155552671"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				validators.Register(UserResourceType, "userName", E164())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Nil(t, validators.Apply(UserResourceType, r, sch, ctx))
+			}
+		}()
+	}
+	wg.Wait()
+}
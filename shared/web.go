@@ -1,10 +1,62 @@
 package shared
 
+import "context"
+
+// the id correlating the logs, events and error responses of a request as a string, see RequestIdOf
 type RequestId struct{}
 type ResourceId struct{}
 type RequestTimestamp struct{}
 type RequestType struct{}
 
+// the authenticated subject of the request, to be populated by authentication middleware
+type Principal struct{}
+
+// the scopes granted to the request as []string, to be populated by authentication middleware
+type Scopes struct{}
+
+// the network address of the connection the request came over as a string, http.Request.RemoteAddr, which is
+// that of the proxy in front of the server if any, see RateLimitKey
+type RemoteAddr struct{}
+
+// the base URL clients reach the endpoints at as a string, populated when the server has a BaseURLProvider
+type BaseURL struct{}
+
+// the *ValidationWarnings of a request validated leniently, see WithLenientValidation
+type Lenient struct{}
+
+// the replace policy of the request as a string, taking the place of the configured one, see ApplyReplacePolicy
+type ReplacePolicy struct{}
+
+// true when the request only asks for validation and mutations must not be persisted
+type DryRun struct{}
+
+// The id of the request of the context, the X-Request-Id header of the client or one InjectRequestScope
+// generated, for hooks and publishers correlating their audit records with the logs of the request. Empty
+// outside of requests.
+func RequestIdOf(ctx context.Context) string {
+	id, _ := ctx.Value(RequestId{}).(string)
+	return id
+}
+
+// Whether a request id chosen by a client is fit to be logged and echoed: at most 128 characters, all
+// printable ASCII except spaces, quotes and backslashes
+func ValidRequestId(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(DryRun{}).(bool)
+	return dryRun
+}
+
 const (
 	_ = iota
 	GetUserById
@@ -25,8 +77,85 @@ const (
 	GetAllSchema
 	GetSPConfig
 	GetAllResourceType
+	GetOperationById
+	GetGroupMembers
+	Maintenance
+	ChangeUserPassword
+	GetUserManagers
+	ExportUsers
+	ExportGroups
+	GetExportById
+	DownloadExport
+	GetRoleById
+	CreateRole
+	ReplaceRole
+	PatchRole
+	QueryRole
+	DeleteRole
+	GetEntitlementById
+	CreateEntitlement
+	ReplaceEntitlement
+	PatchEntitlement
+	QueryEntitlement
+	DeleteEntitlement
+	DeleteExport
 )
 
+// Resolve the resource type and the operation name of a request type,
+// useful for labeling metrics, traces and logs
+func DescribeRequestType(requestType int) (resourceType, operation string) {
+	switch requestType {
+	case GetUserById, CreateUser, ReplaceUser, PatchUser, QueryUser, DeleteUser, ChangeUserPassword, GetUserManagers, ExportUsers:
+		resourceType = UserResourceType
+	case GetGroupById, CreateGroup, ReplaceGroup, PatchGroup, QueryGroup, DeleteGroup, GetGroupMembers, ExportGroups:
+		resourceType = GroupResourceType
+	case GetSchemaById, GetAllSchema:
+		resourceType = SchemaResourceType
+	case GetSPConfig:
+		resourceType = ServiceProviderConfigResourceType
+	case GetAllResourceType:
+		resourceType = ResourceTypeResourceType
+	case GetOperationById:
+		resourceType = OperationResourceType
+	case GetExportById, DownloadExport, DeleteExport:
+		resourceType = ExportResourceType
+	case GetRoleById, CreateRole, ReplaceRole, PatchRole, QueryRole, DeleteRole:
+		resourceType = RoleResourceType
+	case GetEntitlementById, CreateEntitlement, ReplaceEntitlement, PatchEntitlement, QueryEntitlement, DeleteEntitlement:
+		resourceType = EntitlementResourceType
+	}
+
+	switch requestType {
+	case GetUserById, GetGroupById, GetSchemaById, GetSPConfig, GetOperationById, GetExportById, GetRoleById, GetEntitlementById:
+		operation = "get"
+	case CreateUser, CreateGroup, CreateRole, CreateEntitlement:
+		operation = "create"
+	case ReplaceUser, ReplaceGroup, ReplaceRole, ReplaceEntitlement:
+		operation = "replace"
+	case PatchUser, PatchGroup, PatchRole, PatchEntitlement:
+		operation = "patch"
+	case QueryUser, QueryGroup, RootQuery, QueryRole, QueryEntitlement:
+		operation = "query"
+	case DeleteUser, DeleteGroup, DeleteRole, DeleteEntitlement, DeleteExport:
+		operation = "delete"
+	case BulkOp:
+		operation = "bulk"
+	case Maintenance:
+		operation = "maintenance"
+	case ChangeUserPassword:
+		operation = "changePassword"
+	case ExportUsers, ExportGroups:
+		operation = "export"
+	case DownloadExport:
+		operation = "download"
+	case GetAllSchema, GetAllResourceType, GetGroupMembers, GetUserManagers:
+		operation = "list"
+	default:
+		operation = "unknown"
+	}
+	return
+}
+
 type WebRequest interface {
 	Target() string
 	Method() string
@@ -35,6 +164,12 @@ type WebRequest interface {
 	Body() ([]byte, error)
 }
 
+// Optionally implemented by web requests reading their body from a stream, so that a body larger than the
+// limit is cut off while it is read rather than read in full first. Fails with PayloadTooLargeError then.
+type BodyLimiter interface {
+	LimitedBody(maxBytes int) ([]byte, error)
+}
+
 type WebResponse interface {
 	GetStatus() int
 	GetHeader(name string) string
@@ -0,0 +1,39 @@
+// Code generated by scimgen from ../resources/schemas/enterprise_user.json. DO NOT EDIT.
+
+package typed
+
+import "github.com/davidiamyou/go-scim/shared"
+
+// the id of the schema of EnterpriseUser
+const EnterpriseUserUrn = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+
+// Enterprise User
+type EnterpriseUser struct {
+	EmployeeNumber string                 `json:"employeeNumber,omitempty"`
+	CostCenter     string                 `json:"costCenter,omitempty"`
+	Organization   string                 `json:"organization,omitempty"`
+	Division       string                 `json:"division,omitempty"`
+	Department     string                 `json:"department,omitempty"`
+	Manager        *EnterpriseUserManager `json:"manager,omitempty"`
+}
+
+// The User's manager.
+type EnterpriseUserManager struct {
+	Value       string `json:"value,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// Convert the EnterpriseUser to a resource
+func (v *EnterpriseUser) ToResource() (*shared.Resource, error) {
+	return shared.MarshalResource(v)
+}
+
+// Convert the resource to a EnterpriseUser
+func EnterpriseUserFromResource(r *shared.Resource) (*EnterpriseUser, error) {
+	v := &EnterpriseUser{}
+	if err := shared.UnmarshalResource(r, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,48 @@
+// Code generated by scimgen from ../resources/schemas/group_internal.json. DO NOT EDIT.
+
+package typed
+
+import "github.com/davidiamyou/go-scim/shared"
+
+// the id of the schema of Group
+const GroupUrn = "urn:ietf:params:scim:schemas:core:2.0:Group"
+
+// Group
+type Group struct {
+	Schemas     []string      `json:"schemas,omitempty"`
+	Id          string        `json:"id,omitempty"`
+	ExternalId  string        `json:"externalId,omitempty"`
+	Meta        *GroupMeta    `json:"meta,omitempty"`
+	DisplayName string        `json:"displayName,omitempty"`
+	Members     []GroupMember `json:"members,omitempty"`
+}
+
+// A complex attribute containing resource metadata.
+type GroupMeta struct {
+	ResourceType string `json:"resourceType,omitempty"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+// A list of members of the Group.
+type GroupMember struct {
+	Value string `json:"value,omitempty"`
+	Ref   string `json:"$ref,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// Convert the Group to a resource
+func (v *Group) ToResource() (*shared.Resource, error) {
+	return shared.MarshalResource(v)
+}
+
+// Convert the resource to a Group
+func GroupFromResource(r *shared.Resource) (*Group, error) {
+	v := &Group{}
+	if err := shared.UnmarshalResource(r, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
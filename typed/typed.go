@@ -0,0 +1,9 @@
+// Package typed offers the core resources as Go structs, for embedders that would rather not work with the
+// maps of shared.Resource. The structs are generated from the schemas by cmd/scimgen, which generates structs
+// for custom resource types alike. Convert with ToResource and UserFromResource, GroupFromResource or
+// EnterpriseUserFromResource.
+package typed
+
+//go:generate go run ../cmd/scimgen -schema ../resources/schemas/user_internal.json -type User -extension EnterpriseUser=../resources/schemas/enterprise_user.json -out user.go
+//go:generate go run ../cmd/scimgen -schema ../resources/schemas/group_internal.json -type Group -out group.go
+//go:generate go run ../cmd/scimgen -schema ../resources/schemas/enterprise_user.json -type EnterpriseUser -out enterprise_user.go
@@ -0,0 +1,55 @@
+package typed
+
+import (
+	"github.com/davidiamyou/go-scim/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestUser(t *testing.T) {
+	r, _, err := shared.ParseResource("../resources/tests/user_1.json")
+	require.Nil(t, err)
+	r.Complex[EnterpriseUserUrn] = map[string]interface{}{
+		"employeeNumber": "701984",
+		"manager":        map[string]interface{}{"value": "26118915-6090-4610-87e4-49d8ca9f808d"},
+	}
+
+	user, err := UserFromResource(r)
+	require.Nil(t, err)
+	assert.Equal(t, "david@example.com", user.UserName)
+	assert.Equal(t, "Qiu", user.Name.FamilyName)
+	require.Len(t, user.Emails, 2)
+	assert.True(t, *user.Emails[0].Primary)
+	assert.Nil(t, user.Emails[1].Primary)
+	require.NotNil(t, user.EnterpriseUser)
+	assert.Equal(t, "701984", user.EnterpriseUser.EmployeeNumber)
+	assert.Equal(t, "26118915-6090-4610-87e4-49d8ca9f808d", user.EnterpriseUser.Manager.Value)
+
+	back, err := user.ToResource()
+	require.Nil(t, err)
+	assert.Equal(t, r.Complex["userName"], back.Complex["userName"])
+	assert.Equal(t, r.Complex["emails"], back.Complex["emails"])
+	assert.Equal(t, r.Complex[EnterpriseUserUrn], back.Complex[EnterpriseUserUrn])
+	_, ok := back.Complex["roles"]
+	assert.False(t, ok, "absent attributes stay absent")
+}
+
+func TestGroup(t *testing.T) {
+	group := &Group{
+		Schemas:     []string{GroupUrn},
+		DisplayName: "Admins",
+		Members:     []GroupMember{{Value: "1", Type: "User"}},
+	}
+	r, err := group.ToResource()
+	require.Nil(t, err)
+	assert.Equal(t, shared.Complex{
+		"schemas":     []interface{}{GroupUrn},
+		"displayName": "Admins",
+		"members":     []interface{}{map[string]interface{}{"value": "1", "type": "User"}},
+	}, r.Complex)
+
+	r.Complex["members"] = "not an array"
+	_, err = GroupFromResource(r)
+	assert.NotNil(t, err)
+}
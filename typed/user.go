@@ -0,0 +1,147 @@
+// Code generated by scimgen from ../resources/schemas/user_internal.json. DO NOT EDIT.
+
+package typed
+
+import "github.com/davidiamyou/go-scim/shared"
+
+// the id of the schema of User
+const UserUrn = "urn:ietf:params:scim:schemas:core:2.0:User"
+
+// User Account
+type User struct {
+	Schemas           []string              `json:"schemas,omitempty"`
+	Id                string                `json:"id,omitempty"`
+	ExternalId        string                `json:"externalId,omitempty"`
+	Meta              *UserMeta             `json:"meta,omitempty"`
+	UserName          string                `json:"userName,omitempty"`
+	Name              *UserName             `json:"name,omitempty"`
+	DisplayName       string                `json:"displayName,omitempty"`
+	NickName          string                `json:"nickName,omitempty"`
+	ProfileUrl        string                `json:"profileUrl,omitempty"`
+	Title             string                `json:"title,omitempty"`
+	UserType          string                `json:"userType,omitempty"`
+	PreferredLanguage string                `json:"preferredLanguage,omitempty"`
+	Locale            string                `json:"locale,omitempty"`
+	Timezone          string                `json:"timezone,omitempty"`
+	Active            *bool                 `json:"active,omitempty"`
+	Password          string                `json:"password,omitempty"`
+	Emails            []UserEmail           `json:"emails,omitempty"`
+	PhoneNumbers      []UserPhoneNumber     `json:"phoneNumbers,omitempty"`
+	Ims               []UserIm              `json:"ims,omitempty"`
+	Photos            []UserPhoto           `json:"photos,omitempty"`
+	Addresses         []UserAddress         `json:"addresses,omitempty"`
+	Groups            []UserGroup           `json:"groups,omitempty"`
+	Entitlements      []UserEntitlement     `json:"entitlements,omitempty"`
+	Roles             []UserRole            `json:"roles,omitempty"`
+	X509Certificates  []UserX509Certificate `json:"x509Certificates,omitempty"`
+	EnterpriseUser    *EnterpriseUser       `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+}
+
+// A complex attribute containing resource metadata.
+type UserMeta struct {
+	ResourceType string `json:"resourceType,omitempty"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+// The components of the user's real name.
+type UserName struct {
+	Formatted       string `json:"formatted,omitempty"`
+	FamilyName      string `json:"familyName,omitempty"`
+	GivenName       string `json:"givenName,omitempty"`
+	MiddleName      string `json:"middleName,omitempty"`
+	HonorificPrefix string `json:"honorificPrefix,omitempty"`
+	HonorificSuffix string `json:"honorificSuffix,omitempty"`
+}
+
+// Email addresses for the user.
+type UserEmail struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// Phone numbers for the User.
+type UserPhoneNumber struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// Instant messaging addresses for the User.
+type UserIm struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// URLs of photos of the User.
+type UserPhoto struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// A physical mailing address for this User.
+type UserAddress struct {
+	Formatted     string `json:"formatted,omitempty"`
+	StreetAddress string `json:"streetAddress,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postalCode,omitempty"`
+	Country       string `json:"country,omitempty"`
+	Type          string `json:"type,omitempty"`
+	Primary       *bool  `json:"primary,omitempty"`
+}
+
+// A list of groups to which the user belongs, either through direct membership, through nested groups, or dynamically calculated.
+type UserGroup struct {
+	Value   string `json:"value,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// A list of entitlements for the User that represent a thing the User has.
+type UserEntitlement struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// A list of roles for the User that collectively represent who the User is, e.g., 'Student', 'Faculty'.
+type UserRole struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// A list of certificates issued to the User.
+type UserX509Certificate struct {
+	Value   string `json:"value,omitempty"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary *bool  `json:"primary,omitempty"`
+}
+
+// Convert the User to a resource
+func (v *User) ToResource() (*shared.Resource, error) {
+	return shared.MarshalResource(v)
+}
+
+// Convert the resource to a User
+func UserFromResource(r *shared.Resource) (*User, error) {
+	v := &User{}
+	if err := shared.UnmarshalResource(r, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}